@@ -0,0 +1,99 @@
+package contentstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/image/v5/internal/imagesource/impl"
+	"github.com/containers/image/v5/internal/imagesource/stubs"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+type contentStoreImageSource struct {
+	impl.Compat
+	impl.PropertyMethodsInitialize
+	impl.NoSignatures
+	impl.DoesNotAffectLayerInfosForCopy
+	stubs.NoGetBlobAtInitialize
+
+	ref contentStoreReference
+}
+
+// newImageSource returns an ImageSource reading from a content store directory.
+// The caller must call .Close() on the returned ImageSource.
+func newImageSource(ref contentStoreReference) private.ImageSource {
+	s := &contentStoreImageSource{
+		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
+			HasThreadSafeGetBlob: true,
+		}),
+		NoGetBlobAtInitialize: stubs.NoGetBlobAt(ref),
+
+		ref: ref,
+	}
+	s.Compat = impl.AddCompat(s)
+	return s
+}
+
+// Reference returns the reference used to set up this source, _as specified by the user_
+// (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
+func (s *contentStoreImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+// Close removes resources associated with an initialized ImageSource, if any.
+func (s *contentStoreImageSource) Close() error {
+	return nil
+}
+
+// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
+// It may use a remote (= slow) service.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *contentStoreImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	d := s.ref.manifestDigest
+	if instanceDigest != nil {
+		d = *instanceDigest
+	}
+	path, err := s.ref.blobPath(d)
+	if err != nil {
+		return nil, "", err
+	}
+	m, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	// The manifest is only identified by its digest, so make sure we are not returning something else.
+	matches, err := manifest.MatchesDigest(m, d)
+	if err != nil {
+		return nil, "", fmt.Errorf("computing digest of manifest %s: %w", d, err)
+	}
+	if !matches {
+		return nil, "", fmt.Errorf("manifest %q does not match digest %s", path, d)
+	}
+	return m, manifest.GuessMIMEType(m), nil
+}
+
+// GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
+// The Digest field in BlobInfo is guaranteed to be provided, Size may be -1 and MediaType may be optionally provided.
+// May update BlobInfoCache, preferably after it knows for certain that a blob truly exists at a specific location.
+func (s *contentStoreImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	path, err := s.ref.blobPath(info.Digest)
+	if err != nil {
+		return nil, -1, err
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, -1, err
+	}
+	fi, err := r.Stat()
+	if err != nil {
+		r.Close()
+		return nil, -1, err
+	}
+	return r, fi.Size(), nil
+}
@@ -0,0 +1,124 @@
+package contentstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ private.ImageSource = (*contentStoreImageSource)(nil)
+
+// writeTestBlob stores data in the content store at dir, and returns its digest.
+func writeTestBlob(t *testing.T, dir string, data []byte) digest.Digest {
+	d := digest.FromBytes(data)
+	err := os.MkdirAll(filepath.Join(dir, d.Algorithm().String()), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, d.Algorithm().String(), d.Encoded()), data, 0o644)
+	require.NoError(t, err)
+	return d
+}
+
+func TestImageSource(t *testing.T) {
+	dir := t.TempDir()
+
+	layers := [][]byte{[]byte("layer 1"), []byte("layer 2")}
+	layerDescriptors := []imgspecv1.Descriptor{}
+	for _, l := range layers {
+		layerDescriptors = append(layerDescriptors, imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageLayerGzip,
+			Digest:    writeTestBlob(t, dir, l),
+			Size:      int64(len(l)),
+		})
+	}
+	configBlob, err := json.Marshal(imgspecv1.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromString("a"), digest.FromString("b")}},
+	})
+	require.NoError(t, err)
+	configDescriptor := imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    writeTestBlob(t, dir, configBlob),
+		Size:      int64(len(configBlob)),
+	}
+	manifestBlob, err := manifest.OCI1FromComponents(configDescriptor, layerDescriptors).Serialize()
+	require.NoError(t, err)
+	manifestDigest := writeTestBlob(t, dir, manifestBlob)
+
+	ref, err := NewReference(dir, manifestDigest)
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+
+	m, mt, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBlob, m)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mt)
+
+	img, err := ref.NewImage(context.Background(), nil)
+	require.NoError(t, err)
+	defer img.Close()
+	layerInfos := img.LayerInfos()
+	require.Len(t, layerInfos, len(layers))
+	cache := memory.New()
+	for i, info := range layerInfos {
+		assert.Equal(t, layerDescriptors[i].Digest, info.Digest)
+		r, size, err := src.GetBlob(context.Background(), info, cache)
+		require.NoError(t, err)
+		contents, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, layers[i], contents)
+		assert.Equal(t, int64(len(layers[i])), size)
+	}
+	cfg, err := img.ConfigBlob(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, configBlob, cfg)
+
+	sigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, sigs)
+
+	// A missing blob
+	_, _, err = src.GetBlob(context.Background(), types.BlobInfo{Digest: digest.FromString("missing"), Size: -1}, cache)
+	assert.Error(t, err)
+	// A missing manifest instance
+	missing := digest.FromString("missing")
+	_, _, err = src.GetManifest(context.Background(), &missing)
+	assert.Error(t, err)
+	// An invalid digest must not be used to construct a path
+	invalid := digest.Digest("sha256:../../etc/passwd")
+	_, _, err = src.GetManifest(context.Background(), &invalid)
+	assert.Error(t, err)
+}
+
+func TestImageSourceManifestDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	d := writeTestBlob(t, dir, manifestBlob)
+	// Store different contents under the digest.
+	err := os.WriteFile(filepath.Join(dir, d.Algorithm().String(), d.Encoded()), bytes.ToUpper(manifestBlob), 0o644)
+	require.NoError(t, err)
+
+	ref, err := NewReference(dir, d)
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	_, _, err = src.GetManifest(context.Background(), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,198 @@
+// Package contentstore implements a read-only transport for images stored in a raw content store:
+// a directory containing blobs and manifests as individual files named by their digests,
+// e.g. sha256/0123…, without any index or other metadata.
+package contentstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/directory/explicitfilepath"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+func init() {
+	transports.Register(Transport)
+}
+
+// Transport is an ImageTransport for raw content store directories.
+var Transport = contentStoreTransport{}
+
+type contentStoreTransport struct{}
+
+func (t contentStoreTransport) Name() string {
+	return "content-store"
+}
+
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "content-store:path@algo:digest"
+	ReferenceSyntaxExample = "content-store:/var/lib/content@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t contentStoreTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
+// ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
+func (t contentStoreTransport) ParseReference(reference string) (types.ImageReference, error) {
+	return ParseReference(reference)
+}
+
+// ValidatePolicyConfigurationScope checks that scope is a valid name for a signature.PolicyTransportScopes keys
+// (i.e. a valid PolicyConfigurationIdentity() or PolicyConfigurationNamespaces() return value).
+// It is acceptable to allow an invalid value which will never be matched, it can "only" cause user confusion.
+// scope passed to this function will not be "", that value is always allowed.
+func (t contentStoreTransport) ValidatePolicyConfigurationScope(scope string) error {
+	path := scope
+	if i := strings.LastIndex(scope, "@"); i != -1 {
+		path = scope[:i]
+		if _, err := digest.Parse(scope[i+1:]); err != nil {
+			return fmt.Errorf("Invalid scope %s: %w", scope, err)
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("Invalid scope %s: Must be an absolute path", scope)
+	}
+	// Refuse also "/", otherwise "/" and "" would have the same semantics,
+	// and "" could be unexpectedly shadowed by the "/" entry.
+	if path == "/" {
+		return errors.New(`Invalid scope "/": Use the generic default scope ""`)
+	}
+	cleaned := filepath.Clean(path)
+	if cleaned != path {
+		return fmt.Errorf(`Invalid scope %s: Uses non-canonical format, perhaps try %s`, scope, cleaned)
+	}
+	return nil
+}
+
+// contentStoreReference is an ImageReference for a manifest in a raw content store directory.
+type contentStoreReference struct {
+	// As in directory.dirReference, path is used for filesystem operations, resolvedPath primarily for policy namespaces.
+	path           string // As specified by the user. May be relative, contain symlinks, etc.
+	resolvedPath   string // Absolute path with no symlinks, at least at the time of its creation.
+	manifestDigest digest.Digest
+}
+
+// ParseReference converts a string of the form path@algo:digest, which should not start with the ImageTransport.Name prefix,
+// into a content store ImageReference.
+func ParseReference(ref string) (types.ImageReference, error) {
+	i := strings.LastIndex(ref, "@")
+	if i == -1 {
+		return nil, fmt.Errorf("invalid content store reference %q: expected path@algo:digest", ref)
+	}
+	d, err := digest.Parse(ref[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid content store reference %q: %w", ref, err)
+	}
+	return NewReference(ref[:i], d)
+}
+
+// NewReference returns a reference to the image with manifestDigest in the content store at path.
+func NewReference(path string, manifestDigest digest.Digest) (types.ImageReference, error) {
+	if path == "" {
+		return nil, errors.New("invalid content store reference: the path is empty")
+	}
+	if err := manifestDigest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest digest %q: %w", manifestDigest, err)
+	}
+	resolved, err := explicitfilepath.ResolvePathToFullyExplicit(path)
+	if err != nil {
+		return nil, err
+	}
+	return contentStoreReference{path: path, resolvedPath: resolved, manifestDigest: manifestDigest}, nil
+}
+
+func (ref contentStoreReference) Transport() types.ImageTransport {
+	return Transport
+}
+
+// StringWithinTransport returns a string representation of the reference, which MUST be such that
+// reference.Transport().ParseReference(reference.StringWithinTransport()) returns an equivalent reference.
+// NOTE: The returned string is not promised to be equal to the original input to ParseReference;
+// e.g. default attribute values omitted by the user may be filled in in the return value, or vice versa.
+// WARNING: Do not use the return value in the UI to describe an image, it does not contain the Transport().Name() prefix.
+func (ref contentStoreReference) StringWithinTransport() string {
+	return ref.path + "@" + ref.manifestDigest.String()
+}
+
+// DockerReference returns a Docker reference associated with this reference
+// (fully explicit, i.e. !reference.IsNameOnly, but reflecting user intent,
+// not e.g. after redirect or alias processing), or nil if unknown/not applicable.
+func (ref contentStoreReference) DockerReference() reference.Named {
+	return nil
+}
+
+// PolicyConfigurationIdentity returns a string representation of the reference, suitable for policy lookup.
+// This MUST reflect user intent, not e.g. after processing of third-party redirects or aliases;
+// The value SHOULD be fully explicit about its semantics, with no hidden defaults, AND canonical
+// (i.e. various references with exactly the same semantics should return the same configuration identity)
+// It is fine for the return value to be equal to StringWithinTransport(), and it is desirable but
+// not required/guaranteed that it will be a valid input to Transport().ParseReference().
+// Returns "" if configuration identities for these references are not supported.
+func (ref contentStoreReference) PolicyConfigurationIdentity() string {
+	return ref.resolvedPath + "@" + ref.manifestDigest.String()
+}
+
+// PolicyConfigurationNamespaces returns a list of other policy configuration namespaces to search
+// for if explicit configuration for PolicyConfigurationIdentity() is not set.  The list will be processed
+// in order, terminating on first match, and an implicit "" is always checked at the end.
+// It is STRONGLY recommended for the first element, if any, to be a prefix of PolicyConfigurationIdentity(),
+// and each following element to be a prefix of the element preceding it.
+func (ref contentStoreReference) PolicyConfigurationNamespaces() []string {
+	res := []string{}
+	path := ref.resolvedPath
+	for {
+		res = append(res, path)
+		lastSlash := strings.LastIndex(path, "/")
+		if lastSlash == -1 || lastSlash == 0 {
+			break
+		}
+		path = path[:lastSlash]
+	}
+	// Note that we do not include "/"; it is redundant with the default "" global default,
+	// and rejected by contentStoreTransport.ValidatePolicyConfigurationScope above.
+	return res
+}
+
+// NewImage returns a types.ImageCloser for this reference, possibly specialized for this ImageTransport.
+// The caller must call .Close() on the returned ImageCloser.
+// NOTE: If any kind of signature verification should happen, build an UnparsedImage from the value returned by NewImageSource,
+// verify that UnparsedImage, and convert it into a real Image via image.FromUnparsedImage.
+// WARNING: This may not do the right thing for a manifest list, see image.FromSource for details.
+func (ref contentStoreReference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	return image.FromReference(ctx, sys, ref)
+}
+
+// NewImageSource returns a types.ImageSource for this reference.
+// The caller must call .Close() on the returned ImageSource.
+func (ref contentStoreReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	return newImageSource(ref), nil
+}
+
+// NewImageDestination returns a types.ImageDestination for this reference.
+// The caller must call .Close() on the returned ImageDestination.
+func (ref contentStoreReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return nil, errors.New("Writing images is not supported for content-store: images")
+}
+
+// DeleteImage deletes the named image from the registry, if supported.
+func (ref contentStoreReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	return errors.New("Deleting images not implemented for content-store: images")
+}
+
+// blobPath returns the path of the file containing the blob (or manifest) with digest d.
+func (ref contentStoreReference) blobPath(d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil { // Make sure d does not contain path separators or other unexpected values
+		return "", err
+	}
+	return filepath.Join(ref.path, d.Algorithm().String(), d.Encoded()), nil
+}
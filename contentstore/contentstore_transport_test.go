@@ -0,0 +1,111 @@
+package contentstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testManifestDigest = digest.Digest("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "content-store", Transport.Name())
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	for _, scope := range []string{
+		"/etc",
+		"/etc@" + testManifestDigest.String(),
+		"/this/does/not/exist",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.NoError(t, err, scope)
+	}
+
+	for _, scope := range []string{
+		"relative/path",
+		"/",
+		"/double//slashes",
+		"/has/./dot",
+		"/etc@sha256:invalid",
+		"/etc@",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.Error(t, err, scope)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	ref, err := ParseReference(tmpDir + "@" + testManifestDigest.String())
+	require.NoError(t, err)
+	csRef, ok := ref.(contentStoreReference)
+	require.True(t, ok)
+	assert.Equal(t, tmpDir, csRef.path)
+	assert.Equal(t, testManifestDigest, csRef.manifestDigest)
+
+	// A path containing "@" is not confused with the separator.
+	ref, err = ParseReference("/with@at@" + testManifestDigest.String())
+	require.NoError(t, err)
+	assert.Equal(t, "/with@at", ref.(contentStoreReference).path)
+
+	for _, input := range []string{
+		tmpDir,
+		tmpDir + "@",
+		tmpDir + "@sha256:invalid",
+		"@" + testManifestDigest.String(),
+	} {
+		_, err := ParseReference(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestReferenceStringWithinTransport(t *testing.T) {
+	tmpDir := t.TempDir()
+	ref, err := NewReference(tmpDir, testManifestDigest)
+	require.NoError(t, err)
+	s := ref.StringWithinTransport()
+	assert.Equal(t, tmpDir+"@"+testManifestDigest.String(), s)
+	ref2, err := Transport.ParseReference(s)
+	require.NoError(t, err)
+	assert.Equal(t, s, ref2.StringWithinTransport())
+}
+
+func TestReferencePolicyConfiguration(t *testing.T) {
+	tmpDir := t.TempDir()
+	resolved, err := filepath.EvalSymlinks(tmpDir)
+	require.NoError(t, err)
+	ref, err := NewReference(filepath.Join(tmpDir, "store"), testManifestDigest)
+	require.NoError(t, err)
+
+	storePath := filepath.Join(resolved, "store")
+	assert.Equal(t, storePath+"@"+testManifestDigest.String(), ref.PolicyConfigurationIdentity())
+	ns := ref.PolicyConfigurationNamespaces()
+	require.NotEmpty(t, ns)
+	assert.Equal(t, storePath, ns[0])
+	assert.Equal(t, resolved, ns[1])
+	assert.NotContains(t, ns, "/")
+	for _, n := range append(ns, ref.PolicyConfigurationIdentity()) {
+		assert.NoError(t, Transport.ValidatePolicyConfigurationScope(n), n)
+	}
+}
+
+func TestReferenceNewImageDestination(t *testing.T) {
+	ref, err := NewReference(t.TempDir(), testManifestDigest)
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(context.Background(), &types.SystemContext{})
+	assert.Error(t, err)
+}
+
+func TestReferenceDeleteImage(t *testing.T) {
+	ref, err := NewReference(t.TempDir(), testManifestDigest)
+	require.NoError(t, err)
+	err = ref.DeleteImage(context.Background(), nil)
+	assert.Error(t, err)
+}
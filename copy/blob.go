@@ -9,6 +9,7 @@ import (
 	"github.com/containers/image/v5/internal/private"
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
 	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 )
 
@@ -27,6 +28,9 @@ func (ic *imageCopier) copyBlobFromStream(ctx context.Context, srcReader io.Read
 		info:   srcInfo,
 	}
 
+	// === Stop reading the input as soon as ctx is cancelled, even if dest.PutBlob does not check ctx itself.
+	stream.reader = newCancelableReader(ctx, stream.reader)
+
 	// === Process input through digestingReader to validate against the expected digest.
 	// Be paranoid; in case PutBlob somehow managed to ignore an error from digestingReader,
 	// use a separate validation failure indicator.
@@ -50,7 +54,7 @@ func (ic *imageCopier) copyBlobFromStream(ctx context.Context, srcReader io.Read
 
 	// === Detect compression of the input stream.
 	// This requires us to “peek ahead” into the stream to read the initial part, which requires us to chain through another io.Reader returned by DetectCompression.
-	detectedCompression, err := blobPipelineDetectCompressionStep(&stream, srcInfo)
+	detectedCompression, err := blobPipelineDetectCompressionStep(&stream, srcInfo, decryptionStep)
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
@@ -95,11 +99,24 @@ func (ic *imageCopier) copyBlobFromStream(ctx context.Context, srcReader io.Read
 		stream.reader = progressReader
 	}
 
+	// === Drop the digest of a blob which should be referenced using a different digest algorithm; dest computes a new one.
+	// ic.digestAlgorithm is only set to a non-default value if the manifest can be modified.
+	if ic.digestAlgorithm != digest.Canonical && stream.info.Digest != "" && stream.info.Digest.Algorithm() != ic.digestAlgorithm {
+		stream.info.Digest = ""
+	}
+	// Otherwise, keep using the algorithm of a known digest.
+	digestAlgorithm := ic.digestAlgorithm
+	if stream.info.Digest != "" {
+		digestAlgorithm = stream.info.Digest.Algorithm()
+	}
+
 	// === Finally, send the layer stream to dest.
 	options := private.PutBlobOptions{
 		Cache:      ic.c.blobInfoCache,
 		IsConfig:   isConfig,
 		EmptyLayer: emptyLayer,
+
+		DigestAlgorithm: digestAlgorithm,
 	}
 	if !isConfig {
 		options.LayerIndex = &layerIndex
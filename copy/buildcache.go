@@ -0,0 +1,132 @@
+package copy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// buildCacheConfigFields are the top-level keys of image configs which contain build cache metadata
+// (notably the BuildKit inline cache), and are not necessary to run the image.
+var buildCacheConfigFields = []string{
+	"moby.buildkit.cache.v0",     // BuildKit inline cache
+	"moby.buildkit.buildinfo.v1", // BuildKit build dependencies, only used to reproduce builds
+}
+
+// buildCacheAnnotations are the manifest, config and layer annotations which contain build cache metadata.
+var buildCacheAnnotations = []string{
+	"buildkit/createdat",
+	"buildkit/description",
+}
+
+// buildCacheAnnotationPrefix is a prefix of all other build cache annotation names.
+const buildCacheAnnotationPrefix = "moby.buildkit.cache."
+
+// isBuildCacheAnnotation returns true if name is the name of a build cache annotation.
+func isBuildCacheAnnotation(name string) bool {
+	if strings.HasPrefix(name, buildCacheAnnotationPrefix) {
+		return true
+	}
+	for _, a := range buildCacheAnnotations {
+		if name == a {
+			return true
+		}
+	}
+	return false
+}
+
+// stripBuildCacheAnnotations returns annotations (which may be nil) without any build cache annotations.
+func stripBuildCacheAnnotations(annotations map[string]string) map[string]string {
+	var res map[string]string
+	for k, v := range annotations {
+		if isBuildCacheAnnotation(k) {
+			continue
+		}
+		if res == nil {
+			res = map[string]string{}
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// stripBuildCacheFromConfigBlob returns configBlob, a Docker schema2 or OCI config, without any build cache metadata,
+// and true if that required any modification.
+func stripBuildCacheFromConfigBlob(configBlob []byte) ([]byte, bool, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return nil, false, fmt.Errorf("parsing image config: %w", err)
+	}
+	modified := false
+	for _, field := range buildCacheConfigFields {
+		if _, ok := config[field]; ok {
+			delete(config, field)
+			modified = true
+		}
+	}
+	if !modified {
+		return configBlob, false, nil
+	}
+	res, err := json.Marshal(config)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// strippedBuildCacheImage returns img with any build cache metadata removed from its config;
+// if the config is modified, it is digested using algorithm.
+func strippedBuildCacheImage(ctx context.Context, img types.Image, algorithm digest.Algorithm) (types.Image, error) {
+	if img.ConfigInfo().Digest == "" { // schema1 has no separate config, and no place for build cache metadata.
+		return img, nil
+	}
+	configBlob, err := img.ConfigBlob(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading config blob: %w", err)
+	}
+	strippedBlob, modified, err := stripBuildCacheFromConfigBlob(configBlob)
+	if err != nil {
+		return nil, err
+	}
+	if !modified {
+		return img, nil
+	}
+	return updatedConfigBlobImage(ctx, img, algorithm, func([]byte) ([]byte, error) {
+		return strippedBlob, nil
+	})
+}
+
+// stripBuildCacheAnnotationsFromManifest returns man, a manifest with manMIMEType, without any build cache annotations.
+func stripBuildCacheAnnotationsFromManifest(man []byte, manMIMEType string) ([]byte, error) {
+	if manMIMEType != imgspecv1.MediaTypeImageManifest { // Other single-image manifest formats don’t support annotations.
+		return man, nil
+	}
+	m, err := manifest.OCI1FromManifest(man)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest to remove build cache annotations: %w", err)
+	}
+	modified := false
+	strip := func(annotations map[string]string) map[string]string {
+		res := stripBuildCacheAnnotations(annotations)
+		if len(res) != len(annotations) {
+			modified = true
+			return res
+		}
+		return annotations
+	}
+	m.Annotations = strip(m.Annotations)
+	m.Config.Annotations = strip(m.Config.Annotations)
+	for i := range m.Layers {
+		m.Layers[i].Annotations = strip(m.Layers[i].Annotations)
+	}
+	if !modified {
+		return man, nil
+	}
+	return m.Serialize()
+}
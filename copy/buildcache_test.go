@@ -0,0 +1,158 @@
+package copy
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBuildCacheImage creates an OCI image with BuildKit inline cache metadata in a new dir: directory,
+// and returns a reference to it and the digest of its only layer.
+func newTestBuildCacheImage(t *testing.T) (types.ImageReference, digest.Digest) {
+	ref, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "file", Mode: 0o644, Size: 8, Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte("contents"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	layerDigest := digest.FromBytes(layer.Bytes())
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(layer.Bytes()), types.BlobInfo{Digest: layerDigest, Size: int64(layer.Len())}, none.NoCache, false)
+	require.NoError(t, err)
+
+	configBlob, err := json.Marshal(map[string]interface{}{
+		"architecture":               "amd64",
+		"os":                         "linux",
+		"config":                     map[string]interface{}{"Cmd": []string{"/bin/app"}},
+		"rootfs":                     map[string]interface{}{"type": "layers", "diff_ids": []digest.Digest{layerDigest}},
+		"history":                    []map[string]interface{}{{"created_by": "COPY file /"}},
+		"moby.buildkit.cache.v0":     "eyJsYXllcnMiOlt7ImJsb2IiOiJzaGEyNTY6MDAwMCJ9XX0=",
+		"moby.buildkit.buildinfo.v1": "eyJzb3VyY2VzIjpbXX0=",
+	})
+	require.NoError(t, err)
+	configInfo := types.BlobInfo{Digest: digest.FromBytes(configBlob), Size: int64(len(configBlob))}
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(configBlob), configInfo, none.NoCache, true)
+	require.NoError(t, err)
+
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configInfo.Digest,
+		Size:      configInfo.Size,
+	}, []imgspecv1.Descriptor{{
+		MediaType:   imgspecv1.MediaTypeImageLayer,
+		Digest:      layerDigest,
+		Size:        int64(layer.Len()),
+		Annotations: map[string]string{"buildkit/createdat": "2022-01-01T00:00:00Z", "org.example.layer": "kept"},
+	}})
+	m.Annotations = map[string]string{"moby.buildkit.cache.v0.ref": "example", imgspecv1.AnnotationTitle: "kept"}
+	manBlob, err := m.Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	return ref, layerDigest
+}
+
+func TestImageStripBuildCache(t *testing.T) {
+	srcRef, layerDigest := newTestBuildCacheImage(t)
+
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		StripBuildCache: true,
+	})
+	require.NoError(t, err)
+	man, err := manifest.OCI1FromManifest(manBlob)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{imgspecv1.AnnotationTitle: "kept"}, man.Annotations)
+	require.Len(t, man.Layers, 1)
+	assert.Equal(t, layerDigest, man.Layers[0].Digest)
+	assert.Equal(t, map[string]string{"org.example.layer": "kept"}, man.Layers[0].Annotations)
+
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	img, err := image.FromSource(context.Background(), nil, src)
+	require.NoError(t, err)
+	configBlob, err := img.ConfigBlob(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, man.Config.Digest, digest.FromBytes(configBlob))
+	assert.Equal(t, man.Config.Size, int64(len(configBlob)))
+	var config map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(configBlob, &config))
+	assert.NotContains(t, config, "moby.buildkit.cache.v0")
+	assert.NotContains(t, config, "moby.buildkit.buildinfo.v1")
+	ociConfig, err := img.OCIConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/app"}, ociConfig.Config.Cmd)
+	assert.Equal(t, []digest.Digest{layerDigest}, ociConfig.RootFS.DiffIDs)
+	assert.Len(t, ociConfig.History, 1)
+
+	// An image without build cache metadata is not modified.
+	plainRef, _, configDigest := newTestDirImage(t, "layer")
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, plainRef, &Options{
+		StripBuildCache: true,
+	})
+	require.NoError(t, err)
+	schema2, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	assert.Equal(t, configDigest, schema2.ConfigDescriptor.Digest)
+
+	// Build cache metadata can't be removed when preserving digests.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		StripBuildCache: true,
+		PreserveDigests: true,
+	})
+	assert.Error(t, err)
+}
+
+func TestStripBuildCacheAnnotations(t *testing.T) {
+	for _, c := range []struct {
+		input, expected map[string]string
+	}{
+		{nil, nil},
+		{map[string]string{}, nil},
+		{map[string]string{"buildkit/createdat": "x", "buildkit/description": "y", "moby.buildkit.cache.v0.ref": "z"}, nil},
+		{map[string]string{"buildkit/createdat": "x", "a": "b"}, map[string]string{"a": "b"}},
+		{map[string]string{"buildkit/other": "x", "moby.buildkit.other": "y"}, map[string]string{"buildkit/other": "x", "moby.buildkit.other": "y"}},
+	} {
+		res := stripBuildCacheAnnotations(c.input)
+		assert.Equal(t, c.expected, res, c.input)
+	}
+}
+
+func TestStripBuildCacheFromConfigBlob(t *testing.T) {
+	original := []byte(`{"architecture": "amd64", "os": "linux"}`)
+	res, modified, err := stripBuildCacheFromConfigBlob(original)
+	require.NoError(t, err)
+	assert.False(t, modified)
+	assert.Equal(t, original, res)
+
+	res, modified, err = stripBuildCacheFromConfigBlob([]byte(`{"architecture":"amd64","moby.buildkit.cache.v0":"abc","os":"linux"}`))
+	require.NoError(t, err)
+	assert.True(t, modified)
+	assert.JSONEq(t, `{"architecture":"amd64","os":"linux"}`, string(res))
+
+	_, _, err = stripBuildCacheFromConfigBlob([]byte("not JSON"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package copy
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// cancelableReader is an io.Reader which fails with ctx.Err() once ctx is cancelled,
+// so that consumers which don’t otherwise observe ctx (e.g. io.Copy in a destination’s PutBlob)
+// stop reading promptly.
+type cancelableReader struct {
+	ctx    context.Context
+	source io.Reader
+}
+
+// newCancelableReader returns an io.Reader with the contents of source, which fails with ctx.Err() after ctx is cancelled.
+func newCancelableReader(ctx context.Context, source io.Reader) *cancelableReader {
+	return &cancelableReader{
+		ctx:    ctx,
+		source: source,
+	}
+}
+
+func (r *cancelableReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.source.Read(p)
+	if err != nil && err != io.EOF {
+		// If the read failed because the stream was closed by closeOnContextCancellation,
+		// report the more useful cancellation reason instead.
+		if ctxErr := r.ctx.Err(); ctxErr != nil {
+			return n, ctxErr
+		}
+	}
+	return n, err
+}
+
+// onceCloser is an io.ReadCloser which closes the wrapped stream only on the first call to Close.
+type onceCloser struct {
+	io.ReadCloser
+	once     sync.Once
+	closeErr error
+}
+
+func (c *onceCloser) Close() error {
+	c.once.Do(func() {
+		c.closeErr = c.ReadCloser.Close()
+	})
+	return c.closeErr
+}
+
+// closeOnContextCancellation returns a stream with the contents of stream, which is closed if ctx is cancelled,
+// to unblock any Read calls in progress (e.g. on a slow network connection or a pipe) instead of waiting for them
+// to fail with a timeout. stream must support Close being called concurrently with Read, as network connections,
+// pipes and files do. The returned stream closes stream only once, however often it is closed.
+// The caller must call the returned function when it is done using the returned stream; after it returns, the stream
+// is not closed by this function any more. The caller is still responsible for closing the returned stream.
+func closeOnContextCancellation(ctx context.Context, stream io.ReadCloser) (io.ReadCloser, func()) {
+	res := &onceCloser{ReadCloser: stream}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			res.Close()
+		case <-done:
+		}
+	}()
+	return res, func() {
+		close(done)
+		<-exited
+	}
+}
@@ -0,0 +1,98 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelableReaderRead(t *testing.T) {
+	// Data is passed through unmodified
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	data, err := io.ReadAll(newCancelableReader(ctx, bytes.NewReader([]byte("abc"))))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), data)
+
+	// Errors are passed through as long as ctx is not cancelled
+	reader, writer := io.Pipe()
+	readErr := errors.New("Expected error reading input in cancelableReader")
+	err = writer.CloseWithError(readErr)
+	require.NoError(t, err)
+	_, err = io.ReadAll(newCancelableReader(ctx, reader))
+	assert.ErrorIs(t, err, readErr)
+
+	// Reads fail after ctx is cancelled
+	cancel()
+	_, err = io.ReadAll(newCancelableReader(ctx, bytes.NewReader([]byte("abc"))))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCloseOnContextCancellation(t *testing.T) {
+	// A Read blocked on a slow source is aborted promptly when ctx is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader, writer := io.Pipe() // Nothing is ever written to writer, so reads block
+	defer writer.Close()
+	stream, stop := closeOnContextCancellation(ctx, reader)
+	defer stop()
+	readResult := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(newCancelableReader(ctx, stream))
+		readResult <- err
+	}()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	select {
+	case err := <-readResult:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Read was not aborted after ctx was cancelled")
+	}
+
+	// The stream is not closed after the caller stops watching ctx
+	ctx, cancel = context.WithCancel(context.Background())
+	reader, writer = io.Pipe()
+	stream, stop = closeOnContextCancellation(ctx, reader)
+	stop()
+	cancel()
+	writeResult := make(chan error, 1)
+	go func() {
+		_, err := writer.Write([]byte("abc"))
+		writeResult <- err
+	}()
+	buf := make([]byte, 3)
+	_, err := io.ReadFull(stream, buf)
+	require.NoError(t, err)
+	assert.NoError(t, <-writeResult)
+
+	// The stream is closed only once, even if closed both after cancellation and by the caller
+	ctx, cancel = context.WithCancel(context.Background())
+	closer := &countingCloser{}
+	closedStream, stop := closeOnContextCancellation(ctx, closer)
+	cancel()
+	stop()
+	require.NoError(t, closedStream.Close())
+	require.NoError(t, closedStream.Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&closer.closes))
+}
+
+// countingCloser is an io.ReadCloser which counts calls to Close.
+type countingCloser struct {
+	closes int32
+}
+
+func (c *countingCloser) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *countingCloser) Close() error {
+	atomic.AddInt32(&c.closes, 1)
+	return nil
+}
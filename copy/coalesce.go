@@ -0,0 +1,630 @@
+package copy
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/internal/tmpdir"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/layertar"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// layerGroup is a range of consecutive layers [start, end) which are copied as a single layer.
+type layerGroup struct {
+	start, end int
+}
+
+// coalescesLayers returns true if layers should be merged per c.coalesceLayersSmallerThan or c.coalesceLayersInto.
+func (c *copier) coalescesLayers() bool {
+	return c.coalesceLayersSmallerThan > 0 || c.coalesceLayersInto > 0
+}
+
+// canCoalesceLayer returns nil if layer can be merged with other layers, or the reason why it can’t.
+func canCoalesceLayer(layer types.BlobInfo) error {
+	if len(layer.URLs) != 0 {
+		return fmt.Errorf("layer %s is a foreign layer", layer.Digest)
+	}
+	if isOciEncrypted(layer.MediaType) {
+		return fmt.Errorf("layer %s is encrypted", layer.Digest)
+	}
+	return nil
+}
+
+// coalescedLayerGroups returns the groups of layers, covering all of them, which should be merged per
+// smallerThan and into (see Options.CoalesceLayersSmallerThan and Options.CoalesceLayersInto).
+func coalescedLayerGroups(layers []types.BlobInfo, smallerThan int64, into int) ([]layerGroup, error) {
+	res := []layerGroup{}
+	if into > 0 {
+		if len(layers) <= into {
+			for i := range layers {
+				res = append(res, layerGroup{start: i, end: i + 1})
+			}
+			return res, nil
+		}
+		// The first len(layers)%into groups contain one more layer than the others.
+		start := 0
+		for i := 0; i < into; i++ {
+			end := start + len(layers)/into
+			if i < len(layers)%into {
+				end++
+			}
+			if end-start > 1 {
+				for _, layer := range layers[start:end] {
+					if err := canCoalesceLayer(layer); err != nil {
+						return nil, fmt.Errorf("merging layers: %w", err)
+					}
+				}
+			}
+			res = append(res, layerGroup{start: start, end: end})
+			start = end
+		}
+		return res, nil
+	}
+
+	small := func(layer types.BlobInfo) bool {
+		return layer.Size != -1 && layer.Size < smallerThan && canCoalesceLayer(layer) == nil
+	}
+	for i := 0; i < len(layers); {
+		end := i + 1
+		if small(layers[i]) {
+			for end < len(layers) && small(layers[end]) {
+				end++
+			}
+		}
+		res = append(res, layerGroup{start: i, end: end})
+		i = end
+	}
+	return res, nil
+}
+
+// coalescedLayersSource is a source which returns an updated manifest, config and merged layers stored in a temporary directory,
+// instead of those of the wrapped source. Other blobs are read from the wrapped source.
+type coalescedLayersSource struct {
+	private.ImageSource
+	manifest     []byte
+	manifestType string
+	configDigest digest.Digest
+	config       []byte
+	layers       map[digest.Digest]string // Paths of the files containing the merged layers
+}
+
+func (s *coalescedLayersSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	return s.manifest, s.manifestType, nil
+}
+
+func (s *coalescedLayersSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if info.Digest == s.configDigest {
+		return io.NopCloser(bytes.NewReader(s.config)), int64(len(s.config)), nil
+	}
+	if p, ok := s.layers[info.Digest]; ok {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, -1, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, -1, err
+		}
+		return f, fi.Size(), nil
+	}
+	return s.ImageSource.GetBlob(ctx, info, cache)
+}
+
+func (s *coalescedLayersSource) SupportsGetBlobAt() bool {
+	return false // The merged layers can only be read as a whole.
+}
+
+func (s *coalescedLayersSource) LayerInfosForCopy(ctx context.Context, instanceDigest *digest.Digest) ([]types.BlobInfo, error) {
+	return nil, nil // The layers in the updated manifest are the ones to copy.
+}
+
+// coalesceLayers merges the layers of ic.src per ic.c.coalesceLayersSmallerThan or ic.c.coalesceLayersInto, storing the merged layers
+// in a temporary directory (see types.SystemContext.BigFilesTemporaryDir in sys), and updates ic.src and ic.rawSource to refer to an image with the merged layers.
+// The caller must call the returned cleanup function after the image is copied, even if this fails.
+func (ic *imageCopier) coalesceLayers(ctx context.Context, sys *types.SystemContext) (func(), error) {
+	cleanup := func() {}
+	switch ic.src.ManifestMIMEType {
+	case manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest:
+	default:
+		return cleanup, fmt.Errorf("merging layers of a manifest of type %q is not supported", ic.src.ManifestMIMEType)
+	}
+	layers := ic.src.LayerInfos()
+	groups, err := coalescedLayerGroups(layers, ic.c.coalesceLayersSmallerThan, ic.c.coalesceLayersInto)
+	if err != nil {
+		return cleanup, err
+	}
+	if len(groups) == len(layers) {
+		return cleanup, nil // Nothing to do.
+	}
+	diffIDs, err := ic.configDiffIDs(ctx, len(layers))
+	if err != nil {
+		return cleanup, err
+	}
+
+	dir, err := os.MkdirTemp(tmpdir.TemporaryDirectoryForBigFiles(sys), "copy-coalesced-layers")
+	if err != nil {
+		return cleanup, fmt.Errorf("creating a temporary directory for merged layers: %w", err)
+	}
+	cleanup = func() {
+		_ = os.RemoveAll(dir)
+	}
+
+	ic.c.Printf("Merging %d layers into %d\n", len(layers), len(groups))
+	mergedLayers := map[digest.Digest]string{}
+	newLayers := []types.BlobInfo{}
+	newDiffIDs := []digest.Digest{}
+	for i, group := range groups {
+		if group.end-group.start == 1 {
+			newLayers = append(newLayers, layers[group.start])
+			newDiffIDs = append(newDiffIDs, diffIDs[group.start])
+			continue
+		}
+		p := filepath.Join(dir, fmt.Sprintf("layer-%d", i))
+		info, err := ic.mergeLayers(ctx, layers[group.start:group.end], dir, p)
+		if err != nil {
+			return cleanup, fmt.Errorf("merging layers %d to %d: %w", group.start, group.end-1, err)
+		}
+		mergedLayers[info.Digest] = p
+		newLayers = append(newLayers, info)
+		newDiffIDs = append(newDiffIDs, info.Digest) // The merged layers are not compressed.
+	}
+
+	configBlob, err := ic.src.ConfigBlob(ctx)
+	if err != nil {
+		return cleanup, fmt.Errorf("reading config blob: %w", err)
+	}
+	configBlob, err = coalescedConfigBlob(configBlob, groups, newDiffIDs)
+	if err != nil {
+		return cleanup, err
+	}
+	configDigest := ic.digestAlgorithm.FromBytes(configBlob)
+	man, err := coalescedManifest(ic.src.ManifestBlob, ic.src.ManifestMIMEType, groups, newLayers, configDigest, int64(len(configBlob)))
+	if err != nil {
+		return cleanup, err
+	}
+
+	source := &coalescedLayersSource{
+		ImageSource:  ic.rawSource,
+		manifest:     man,
+		manifestType: ic.src.ManifestMIMEType,
+		configDigest: configDigest,
+		config:       configBlob,
+		layers:       mergedLayers,
+	}
+	src, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(source, nil))
+	if err != nil {
+		return cleanup, fmt.Errorf("initializing image with merged layers: %w", err)
+	}
+	ic.src = src
+	ic.rawSource = source
+	return cleanup, nil
+}
+
+// coalescedManifest returns manifestBlob, of manifestType, with the layers replaced by layers (one for each of groups),
+// and the config descriptor updated to configDigest and configSize.
+func coalescedManifest(manifestBlob []byte, manifestType string, groups []layerGroup, layers []types.BlobInfo, configDigest digest.Digest, configSize int64) ([]byte, error) {
+	switch manifestType {
+	case manifest.DockerV2Schema2MediaType:
+		m, err := manifest.Schema2FromManifest(manifestBlob)
+		if err != nil {
+			return nil, fmt.Errorf("parsing manifest to merge layers: %w", err)
+		}
+		newLayers := []manifest.Schema2Descriptor{}
+		for i, group := range groups {
+			if group.end-group.start == 1 {
+				newLayers = append(newLayers, m.LayersDescriptors[group.start])
+			} else {
+				newLayers = append(newLayers, manifest.Schema2Descriptor{
+					MediaType: manifest.DockerV2SchemaLayerMediaTypeUncompressed,
+					Size:      layers[i].Size,
+					Digest:    layers[i].Digest,
+				})
+			}
+		}
+		m.LayersDescriptors = newLayers
+		m.ConfigDescriptor.Digest = configDigest
+		m.ConfigDescriptor.Size = configSize
+		return m.Serialize()
+	case imgspecv1.MediaTypeImageManifest:
+		m, err := manifest.OCI1FromManifest(manifestBlob)
+		if err != nil {
+			return nil, fmt.Errorf("parsing manifest to merge layers: %w", err)
+		}
+		newLayers := []imgspecv1.Descriptor{}
+		for i, group := range groups {
+			if group.end-group.start == 1 {
+				newLayers = append(newLayers, m.Layers[group.start])
+			} else {
+				newLayers = append(newLayers, imgspecv1.Descriptor{
+					MediaType: imgspecv1.MediaTypeImageLayer,
+					Size:      layers[i].Size,
+					Digest:    layers[i].Digest,
+				})
+			}
+		}
+		m.Layers = newLayers
+		m.Config.Digest = configDigest
+		m.Config.Size = configSize
+		return m.Serialize()
+	default:
+		return nil, fmt.Errorf("merging layers of a manifest of type %q is not supported", manifestType)
+	}
+}
+
+// coalescedConfigBlob returns configBlob, a Docker schema2 or OCI config, with the layer DiffIDs replaced by diffIDs (one for each of groups),
+// and its history updated so that only the last entry of each of groups is associated with a layer.
+// Other fields are preserved.
+func coalescedConfigBlob(configBlob []byte, groups []layerGroup, diffIDs []digest.Digest) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return nil, fmt.Errorf("parsing image config: %w", err)
+	}
+	rootFS := map[string]json.RawMessage{}
+	if raw, ok := config["rootfs"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &rootFS); err != nil {
+			return nil, fmt.Errorf("parsing image config: %w", err)
+		}
+	}
+	diffIDsBlob, err := json.Marshal(diffIDs)
+	if err != nil {
+		return nil, err
+	}
+	rootFS["diff_ids"] = diffIDsBlob
+	rootFSBlob, err := json.Marshal(rootFS)
+	if err != nil {
+		return nil, err
+	}
+	config["rootfs"] = rootFSBlob
+
+	if raw, ok := config["history"]; ok && string(raw) != "null" {
+		var history []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &history); err != nil {
+			return nil, fmt.Errorf("parsing image config history: %w", err)
+		}
+		// lastInGroup[i] is true if layer i is the last layer of its group.
+		lastInGroup := map[int]bool{}
+		numLayers := 0
+		for _, group := range groups {
+			lastInGroup[group.end-1] = true
+			numLayers = group.end
+		}
+		layerIndex := 0
+		for _, entry := range history {
+			var emptyLayer bool
+			if raw, ok := entry["empty_layer"]; ok {
+				if err := json.Unmarshal(raw, &emptyLayer); err != nil {
+					return nil, fmt.Errorf("parsing image config history: %w", err)
+				}
+			}
+			if emptyLayer {
+				continue
+			}
+			if layerIndex < numLayers && !lastInGroup[layerIndex] {
+				entry["empty_layer"] = json.RawMessage("true")
+			}
+			layerIndex++
+		}
+		if layerIndex != numLayers {
+			return nil, fmt.Errorf("the image config history describes %d layers, but the image has %d layers", layerIndex, numLayers)
+		}
+		historyBlob, err := json.Marshal(history)
+		if err != nil {
+			return nil, err
+		}
+		config["history"] = historyBlob
+	}
+	return json.Marshal(config)
+}
+
+// mergedEntryID identifies an entry of one of the merged layers.
+type mergedEntryID struct {
+	layer, index int
+}
+
+// mergedMarker describes a whiteout, or an opaque directory marker, in the merged layer.
+type mergedMarker struct {
+	id     mergedEntryID // The entry at which position the marker is written
+	opaque bool
+}
+
+// before returns true if id precedes other in the merged layers.
+func (id mergedEntryID) before(other mergedEntryID) bool {
+	return id.layer < other.layer || (id.layer == other.layer && id.index < other.index)
+}
+
+// mergedPathTree records a set of paths, so that the paths under a directory can be found without looking at all of them.
+type mergedPathTree struct {
+	children map[string]map[string]struct{} // Paths, and all of their parent directories, indexed by their parent directory
+}
+
+// newMergedPathTree returns an empty mergedPathTree.
+func newMergedPathTree() *mergedPathTree {
+	return &mergedPathTree{children: map[string]map[string]struct{}{}}
+}
+
+// add records p, and all of its parent directories.
+func (t *mergedPathTree) add(p string) {
+	for p != "." && p != "/" {
+		parent := path.Dir(p)
+		siblings, ok := t.children[parent]
+		if !ok {
+			siblings = map[string]struct{}{}
+			t.children[parent] = siblings
+		}
+		if _, ok := siblings[p]; ok {
+			return // The parent directories have been recorded already.
+		}
+		siblings[p] = struct{}{}
+		p = parent
+	}
+}
+
+// removeUnder forgets all paths under parent, calling fn for each of them.
+func (t *mergedPathTree) removeUnder(parent string, fn func(p string)) {
+	for child := range t.children[parent] {
+		t.removeUnder(child, fn)
+		fn(child)
+	}
+	delete(t.children, parent)
+}
+
+// mergeLayers writes a single uncompressed layer with the contents of layers, read from ic.rawSource, to destPath, and returns its BlobInfo.
+// Entries which are replaced or removed by later layers are not included; whiteouts are preserved so that they apply to the layers
+// below the merged layer, and both AUFS-style and overlay-style whiteouts are recognized.
+// Hard links whose target is replaced or removed keep the original contents: the first such link is written as a regular file,
+// at the position of the target, and other links to the same target refer to it.
+// The layers are read from the source only once, into temporary files in spoolDir, because each of them is processed twice:
+// first to determine which entries are included, and then to write them.
+func (ic *imageCopier) mergeLayers(ctx context.Context, layers []types.BlobInfo, spoolDir, destPath string) (types.BlobInfo, error) {
+	spooledLayers := make([]string, len(layers))
+	defer func() {
+		for _, p := range spooledLayers {
+			if p != "" {
+				_ = os.Remove(p)
+			}
+		}
+	}()
+	for i, layer := range layers {
+		p, err := ic.spoolLayer(ctx, layer, spoolDir)
+		if err != nil {
+			return types.BlobInfo{}, fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+		}
+		spooledLayers[i] = p
+	}
+
+	visible := map[string]mergedEntryID{}
+	markers := map[string]mergedMarker{}
+	links := map[mergedEntryID]mergedEntryID{} // Hard links in the merged layers, and the regular files they refer to
+	paths := newMergedPathTree()               // All paths in visible and markers, and possibly some which have been removed from them
+	// removeUnder removes entries and markers at paths under parent.
+	removeUnder := func(parent string) {
+		paths.removeUnder(parent, func(p string) {
+			delete(visible, p)
+			delete(markers, p)
+		})
+	}
+	for i, layer := range layers {
+		if err := forEachLayerEntry(spooledLayers[i], func(index int, entry *layertar.Entry, _ io.Reader) error {
+			id := mergedEntryID{layer: i, index: index}
+			p := entry.Path
+			paths.add(p)
+			switch {
+			case entry.Whiteout || layertar.IsOverlayWhiteout(entry.Header):
+				delete(visible, p)
+				removeUnder(p)
+				markers[p] = mergedMarker{id: id}
+			case entry.OpaqueWhiteout:
+				removeUnder(p)
+				markers[p] = mergedMarker{id: id, opaque: true}
+			default:
+				if entry.Header.Typeflag != tar.TypeDir || layertar.IsOverlayOpaqueDir(entry.Header) {
+					removeUnder(p)
+				}
+				// A whiteout of p, or of one of its parents, must not remove p in the merged layer, but it must still remove
+				// the contents of the lower layers; so turn it into an opaque directory, unless p replaces it as a non-directory.
+				for parent := p; parent != "."; parent = path.Dir(parent) {
+					if m, ok := markers[parent]; ok && !m.opaque {
+						if parent == p && entry.Header.Typeflag != tar.TypeDir {
+							delete(markers, parent)
+						} else {
+							m.opaque = true
+							markers[parent] = m
+						}
+					}
+				}
+				// Links to files outside of the merged layers are written unmodified.
+				if entry.Header.Typeflag == tar.TypeLink {
+					if targetID, ok := visible[entry.LinkTarget]; ok {
+						if t, ok := links[targetID]; ok {
+							targetID = t
+						}
+						links[id] = targetID
+					}
+				}
+				visible[p] = id
+			}
+			return nil
+		}); err != nil {
+			return types.BlobInfo{}, fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+		}
+	}
+	markerPaths := map[mergedEntryID]string{}
+	for p, m := range markers {
+		markerPaths[m.id] = p
+	}
+	visiblePaths := map[mergedEntryID]string{}
+	survivingLinks := []mergedEntryID{}
+	for p, id := range visible {
+		visiblePaths[id] = p
+		if _, ok := links[id]; ok {
+			survivingLinks = append(survivingLinks, id)
+		}
+	}
+	// Process the links in order, so that the first link to a removed target is the one promoted to a regular file.
+	sort.Slice(survivingLinks, func(i, j int) bool {
+		return survivingLinks[i].before(survivingLinks[j])
+	})
+	linkNames := map[mergedEntryID]string{} // Link targets of surviving links, if they are not the original ones
+	promoted := map[mergedEntryID]string{}  // Removed link targets, and the paths of the links written as regular files instead
+	for _, id := range survivingLinks {
+		target := links[id]
+		if targetPath, ok := visiblePaths[target]; ok {
+			linkNames[id] = targetPath
+		} else if promotedPath, ok := promoted[target]; ok {
+			linkNames[id] = promotedPath
+		} else {
+			promoted[target] = visiblePaths[id]
+			delete(visible, visiblePaths[id]) // Written at the position of target instead.
+		}
+	}
+
+	f, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	defer f.Close()
+	digester := ic.digestAlgorithm.Digester()
+	counter := &countingWriter{}
+	tw := tar.NewWriter(io.MultiWriter(f, digester.Hash(), counter))
+	for i, layer := range layers {
+		if err := forEachLayerEntry(spooledLayers[i], func(index int, entry *layertar.Entry, contents io.Reader) error {
+			id := mergedEntryID{layer: i, index: index}
+			if visibleID, ok := visible[entry.Path]; ok && visibleID == id {
+				hdr := entry.Header
+				if linkName, ok := linkNames[id]; ok {
+					h := *hdr
+					h.Linkname = linkName
+					hdr = &h
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if _, err := io.Copy(tw, contents); err != nil {
+					return err
+				}
+			} else if promotedPath, ok := promoted[id]; ok {
+				hdr := *entry.Header
+				hdr.Name = promotedPath
+				hdr.Format = tar.FormatUnknown // The original format may not be able to represent the new name.
+				if err := tw.WriteHeader(&hdr); err != nil {
+					return err
+				}
+				if _, err := io.Copy(tw, contents); err != nil {
+					return err
+				}
+			}
+			if p, ok := markerPaths[id]; ok {
+				name := path.Join(path.Dir(p), layertar.WhiteoutPrefix+path.Base(p))
+				if markers[p].opaque {
+					name = path.Join(p, layertar.WhiteoutOpaqueDir)
+				}
+				if err := tw.WriteHeader(&tar.Header{
+					Typeflag: tar.TypeReg,
+					Name:     name,
+					Mode:     0o644,
+					ModTime:  entry.Header.ModTime,
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return types.BlobInfo{}, fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return types.BlobInfo{}, err
+	}
+	if err := f.Close(); err != nil {
+		return types.BlobInfo{}, err
+	}
+	return types.BlobInfo{
+		Digest: digester.Digest(),
+		Size:   counter.size,
+	}, nil
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	size int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+// spoolLayer copies layer, read from ic.rawSource, to a new temporary file in dir, verifying its digest, and returns the path of the file.
+func (ic *imageCopier) spoolLayer(ctx context.Context, layer types.BlobInfo, dir string) (_ string, retErr error) {
+	if err := layer.Digest.Validate(); err != nil {
+		return "", fmt.Errorf("invalid digest %q: %w", layer.Digest, err)
+	}
+	stream, _, err := ic.rawSource.GetBlob(ctx, layer, ic.c.blobInfoCache)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	f, err := os.CreateTemp(dir, "source-layer")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if retErr != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	defer f.Close()
+	verifier := layer.Digest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(f, verifier), stream); err != nil {
+		return "", err
+	}
+	if !verifier.Verified() {
+		return "", fmt.Errorf("blob %s does not match its digest", layer.Digest)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// forEachLayerEntry calls fn for each entry of the layer blob at layerPath, with the index of the entry and a reader for its contents.
+func forEachLayerEntry(layerPath string, fn func(index int, entry *layertar.Entry, contents io.Reader) error) error {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := layertar.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for index := 0; ; index++ {
+		entry, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		if err := fn(index, entry, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
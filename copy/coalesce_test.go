@@ -0,0 +1,390 @@
+package copy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/internal/testing/testimage"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/layertar"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTarEntry is an entry of a layer created by newTestLayeredImage.
+type testTarEntry struct {
+	hdr      tar.Header
+	contents string
+}
+
+// newTestLayeredImage creates an OCI image with gzip-compressed layers containing the specified entries, with one history entry
+// for each of them, in a new dir: directory, and returns a reference to it.
+func newTestLayeredImage(t *testing.T, layers ...[]testTarEntry) types.ImageReference {
+	img := testimage.Image{ManifestMIMEType: imgspecv1.MediaTypeImageManifest}
+	for i, entries := range layers {
+		tarEntries := []testimage.Entry{}
+		for _, e := range entries {
+			tarEntries = append(tarEntries, testimage.Entry{Header: e.hdr, Contents: e.contents})
+		}
+		tarBlob := testimage.Tar(t, tarEntries...)
+		img.Layers = append(img.Layers, testimage.Layer{
+			Blob:      testimage.Gzip(t, tarBlob),
+			MediaType: imgspecv1.MediaTypeImageLayerGzip,
+			DiffID:    digest.FromBytes(tarBlob),
+		})
+		img.Config.History = append(img.Config.History, imgspecv1.History{CreatedBy: fmt.Sprintf("layer %d", i)})
+	}
+	img.Config.History = append(img.Config.History, imgspecv1.History{CreatedBy: "CMD", EmptyLayer: true})
+	ref, _ := testimage.NewDirImage(t, img)
+	return ref
+}
+
+// readTestImage returns the image at ref, with its config, and the names and contents of the entries of each of its layers.
+func readTestImage(t *testing.T, ref types.ImageReference) (*imgspecv1.Image, []imgspecv1.Descriptor, [][]testTarEntry) {
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	img, err := image.FromSource(context.Background(), nil, src)
+	require.NoError(t, err)
+	config, err := img.OCIConfig(context.Background())
+	require.NoError(t, err)
+	manBlob, _, err := img.Manifest(context.Background())
+	require.NoError(t, err)
+	man, err := manifest.OCI1FromManifest(manBlob)
+	require.NoError(t, err)
+
+	contents := [][]testTarEntry{}
+	for i, layer := range img.LayerInfos() {
+		stream, _, err := src.GetBlob(context.Background(), layer, none.NoCache)
+		require.NoError(t, err)
+		defer stream.Close()
+		var uncompressed io.Reader = stream
+		if layer.MediaType == imgspecv1.MediaTypeImageLayerGzip {
+			uncompressed, err = gzip.NewReader(stream)
+			require.NoError(t, err)
+		}
+		verifier := config.RootFS.DiffIDs[i].Verifier()
+		r, err := layertar.NewReaderWithDecompressor(io.TeeReader(uncompressed, verifier), nil)
+		require.NoError(t, err)
+		entries := []testTarEntry{}
+		for {
+			entry, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			entries = append(entries, testTarEntry{hdr: tar.Header{Name: entry.Header.Name, Typeflag: entry.Header.Typeflag}, contents: string(data)})
+		}
+		assert.True(t, verifier.Verified(), "DiffID of layer %d", i)
+		contents = append(contents, entries)
+	}
+	return config, man.Layers, contents
+}
+
+func TestImageCoalesceLayers(t *testing.T) {
+	file := func(name, contents string) testTarEntry {
+		return testTarEntry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644}, contents: contents}
+	}
+	// mergedEntry is an entry as returned by readTestImage.
+	mergedEntry := func(name, contents string) testTarEntry {
+		return testTarEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeReg}, contents: contents}
+	}
+	bigContents := make([]byte, 16*1024)
+	_, err := rand.New(rand.NewSource(0)).Read(bigContents)
+	require.NoError(t, err)
+
+	srcRef := newTestLayeredImage(t,
+		[]testTarEntry{file("etc/a", "a"), file("etc/b", "b"), file("var/x/y", "y"), file("big", string(bigContents))},
+		[]testTarEntry{file("etc/.wh.a", ""), file("new/f1", "f1"), file("new/f2", "f2")},
+		// AUFS-style opaque directory
+		[]testTarEntry{file("var/.wh..wh..opq", ""), file("var/z", "z")},
+		// An overlay-style whiteout, and a file replacing a file removed by a whiteout
+		[]testTarEntry{file("new/f1", "f1 v2"), file("etc/a", "a v2"), {hdr: tar.Header{Typeflag: tar.TypeChar, Name: "new/f2"}}},
+	)
+
+	// The three small layers are merged into one, the large base layer is copied unmodified.
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		CoalesceLayersSmallerThan: 1024,
+	})
+	require.NoError(t, err)
+	config, descriptors, contents := readTestImage(t, destRef)
+	srcConfig, srcDescriptors, srcContents := readTestImage(t, srcRef)
+	require.Len(t, descriptors, 2)
+	assert.Equal(t, srcDescriptors[0], descriptors[0])
+	assert.Equal(t, imgspecv1.MediaTypeImageLayer, descriptors[1].MediaType)
+	assert.Equal(t, []digest.Digest{srcConfig.RootFS.DiffIDs[0], descriptors[1].Digest}, config.RootFS.DiffIDs)
+	assert.Equal(t, srcContents[0], contents[0])
+	assert.Equal(t, []testTarEntry{
+		mergedEntry("var/.wh..wh..opq", ""),
+		mergedEntry("var/z", "z"),
+		mergedEntry("new/f1", "f1 v2"),
+		mergedEntry("etc/a", "a v2"),
+		mergedEntry("new/.wh.f2", ""),
+	}, contents[1])
+	require.Len(t, config.History, 5)
+	for i, expected := range []bool{false, true, true, false, true} {
+		assert.Equal(t, srcConfig.History[i].CreatedBy, config.History[i].CreatedBy)
+		assert.Equal(t, expected, config.History[i].EmptyLayer, "history entry %d", i)
+	}
+
+	// Merging all layers into one.
+	srcRef, _, _ = newTestDirImage(t, "layer 1", "layer 2", "layer 3")
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		CoalesceLayersInto: 1,
+	})
+	require.NoError(t, err)
+	schema2, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	require.Len(t, schema2.LayersDescriptors, 1)
+	assert.Equal(t, manifest.DockerV2SchemaLayerMediaTypeUncompressed, schema2.LayersDescriptors[0].MediaType)
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	img, err := image.FromSource(context.Background(), nil, src)
+	require.NoError(t, err)
+	ociConfig, err := img.OCIConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []digest.Digest{schema2.LayersDescriptors[0].Digest}, ociConfig.RootFS.DiffIDs)
+	stream, _, err := src.GetBlob(context.Background(), img.LayerInfos()[0], none.NoCache)
+	require.NoError(t, err)
+	defer stream.Close()
+	r, err := layertar.NewReader(stream)
+	require.NoError(t, err)
+	defer r.Close()
+	entry, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "file", entry.Path)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "layer 3", string(data))
+	_, err = r.Next()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// The merged layer and the updated config use the requested digest algorithm.
+	destDir := t.TempDir()
+	destRef, err = directory.NewReference(destDir)
+	require.NoError(t, err)
+	manBlob, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx:     &types.SystemContext{DigestAlgorithm: digest.SHA512},
+		CoalesceLayersInto: 1,
+	})
+	require.NoError(t, err)
+	schema2, err = manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	require.Len(t, schema2.LayersDescriptors, 1)
+	for _, info := range []manifest.Schema2Descriptor{schema2.ConfigDescriptor, schema2.LayersDescriptors[0]} {
+		blob, err := os.ReadFile(filepath.Join(destDir, info.Digest.Encoded()))
+		require.NoError(t, err)
+		assert.Equal(t, digest.SHA512.FromBytes(blob), info.Digest)
+	}
+	config, _, _ = readTestImage(t, destRef)
+	assert.Equal(t, []digest.Digest{schema2.LayersDescriptors[0].Digest}, config.RootFS.DiffIDs)
+
+	// Layers can't be merged when preserving digests.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		CoalesceLayersInto: 1,
+		PreserveDigests:    true,
+	})
+	assert.Error(t, err)
+
+	// The two options can't be used together.
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		CoalesceLayersInto:        1,
+		CoalesceLayersSmallerThan: 1024,
+	})
+	assert.Error(t, err)
+}
+
+func TestImageCoalesceLayersHardLinks(t *testing.T) {
+	file := func(name, contents string) testTarEntry {
+		return testTarEntry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644}, contents: contents}
+	}
+	link := func(name, target string) testTarEntry {
+		return testTarEntry{hdr: tar.Header{Typeflag: tar.TypeLink, Name: name, Linkname: target, Mode: 0o644}}
+	}
+	// mergedLayer merges layers into one, and returns its entries, with link targets.
+	mergedLayer := func(layers ...[]testTarEntry) []testTarEntry {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, newTestLayeredImage(t, layers...), &Options{
+			CoalesceLayersInto: 1,
+		})
+		require.NoError(t, err)
+		src, err := destRef.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		defer src.Close()
+		img, err := image.FromSource(context.Background(), nil, src)
+		require.NoError(t, err)
+		require.Len(t, img.LayerInfos(), 1)
+		stream, _, err := src.GetBlob(context.Background(), img.LayerInfos()[0], none.NoCache)
+		require.NoError(t, err)
+		defer stream.Close()
+		r, err := layertar.NewReader(stream)
+		require.NoError(t, err)
+		defer r.Close()
+		entries := []testTarEntry{}
+		for {
+			entry, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			entries = append(entries, testTarEntry{hdr: tar.Header{
+				Name:     entry.Header.Name,
+				Typeflag: entry.Header.Typeflag,
+				Linkname: entry.Header.Linkname,
+			}, contents: string(data)})
+		}
+		return entries
+	}
+	mergedFile := func(name, contents string) testTarEntry {
+		return testTarEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeReg}, contents: contents}
+	}
+	mergedLink := func(name, target string) testTarEntry {
+		return testTarEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: target}}
+	}
+
+	// Links to a file which is still present are preserved.
+	assert.Equal(t, []testTarEntry{
+		mergedFile("a", "a"),
+		mergedLink("b", "a"),
+		mergedFile("c", "c"),
+	}, mergedLayer(
+		[]testTarEntry{file("a", "a"), link("b", "a")},
+		[]testTarEntry{file("c", "c")},
+	))
+
+	// If the link target is replaced, the links keep the original contents.
+	assert.Equal(t, []testTarEntry{
+		mergedFile("b", "a"),
+		mergedLink("c", "b"),
+		mergedLink("d", "b"),
+		mergedFile("a", "a v2"),
+	}, mergedLayer(
+		[]testTarEntry{file("a", "a"), link("b", "a"), link("c", "a")},
+		[]testTarEntry{link("d", "c"), file("a", "a v2")},
+	))
+
+	// The same applies if the link target is removed.
+	assert.Equal(t, []testTarEntry{
+		mergedFile("b", "a"),
+		mergedFile(".wh.a", ""),
+	}, mergedLayer(
+		[]testTarEntry{file("a", "a"), link("b", "a")},
+		[]testTarEntry{file(".wh.a", "")},
+	))
+}
+
+func TestCoalescedLayerGroups(t *testing.T) {
+	small := types.BlobInfo{Digest: digest.FromString("small"), Size: 10}
+	large := types.BlobInfo{Digest: digest.FromString("large"), Size: 1000}
+	unknown := types.BlobInfo{Digest: digest.FromString("unknown"), Size: -1}
+	foreign := types.BlobInfo{Digest: digest.FromString("foreign"), Size: 10, URLs: []string{"https://example.com/layer"}}
+	encrypted := types.BlobInfo{Digest: digest.FromString("encrypted"), Size: 10, MediaType: imgspecv1.MediaTypeImageLayerGzip + "+encrypted"}
+
+	for _, c := range []struct {
+		layers      []types.BlobInfo
+		smallerThan int64
+		into        int
+		expected    []layerGroup
+	}{
+		{[]types.BlobInfo{small, small, small}, 100, 0, []layerGroup{{0, 3}}},
+		{[]types.BlobInfo{large, small, small, large, small}, 100, 0, []layerGroup{{0, 1}, {1, 3}, {3, 4}, {4, 5}}},
+		{[]types.BlobInfo{small, unknown, small, foreign, small, encrypted, small, small}, 100, 0,
+			[]layerGroup{{0, 1}, {1, 2}, {2, 3}, {3, 4}, {4, 5}, {5, 6}, {6, 8}}},
+		{[]types.BlobInfo{small, large, small}, 0, 1, []layerGroup{{0, 3}}},
+		{[]types.BlobInfo{small, large, small, small, small}, 0, 2, []layerGroup{{0, 3}, {3, 5}}},
+		{[]types.BlobInfo{small, small, small, small, small, small, small}, 0, 3, []layerGroup{{0, 3}, {3, 5}, {5, 7}}},
+		{[]types.BlobInfo{small, foreign}, 0, 2, []layerGroup{{0, 1}, {1, 2}}},
+		{[]types.BlobInfo{small, foreign}, 0, 3, []layerGroup{{0, 1}, {1, 2}}},
+	} {
+		res, err := coalescedLayerGroups(c.layers, c.smallerThan, c.into)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, res)
+	}
+
+	// Foreign and encrypted layers can't be merged with other layers.
+	for _, layer := range []types.BlobInfo{foreign, encrypted} {
+		_, err := coalescedLayerGroups([]types.BlobInfo{small, layer, small}, 0, 1)
+		assert.Error(t, err)
+	}
+}
+
+func TestCoalescedConfigBlob(t *testing.T) {
+	groups := []layerGroup{{0, 1}, {1, 3}}
+	diffIDs := []digest.Digest{digest.FromString("layer 0"), digest.FromString("merged")}
+
+	res, err := coalescedConfigBlob([]byte(`{"architecture":"amd64","unknown":"kept","rootfs":{"type":"layers","diff_ids":["sha256:1","sha256:2","sha256:3"]},`+
+		`"history":[{"created_by":"0"},{"created_by":"env","empty_layer":true},{"created_by":"1"},{"created_by":"2","comment":"kept"}]}`), groups, diffIDs)
+	require.NoError(t, err)
+	var config map[string]interface{}
+	require.NoError(t, json.Unmarshal(res, &config))
+	assert.Equal(t, map[string]interface{}{
+		"architecture": "amd64",
+		"unknown":      "kept",
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []interface{}{diffIDs[0].String(), diffIDs[1].String()}},
+		"history": []interface{}{
+			map[string]interface{}{"created_by": "0"},
+			map[string]interface{}{"created_by": "env", "empty_layer": true},
+			map[string]interface{}{"created_by": "1", "empty_layer": true},
+			map[string]interface{}{"created_by": "2", "comment": "kept"},
+		},
+	}, config)
+
+	// A config without history is accepted.
+	_, err = coalescedConfigBlob([]byte(`{"rootfs":{"type":"layers","diff_ids":["sha256:1","sha256:2","sha256:3"]}}`), groups, diffIDs)
+	assert.NoError(t, err)
+	// History which does not match the layers is rejected.
+	_, err = coalescedConfigBlob([]byte(`{"rootfs":{"type":"layers","diff_ids":["sha256:1","sha256:2","sha256:3"]},"history":[{"created_by":"0"}]}`), groups, diffIDs)
+	assert.Error(t, err)
+}
+
+func TestMergedPathTree(t *testing.T) {
+	paths := newMergedPathTree()
+	for _, p := range []string{"a/b/c", "a/b", "a/d", "a/b/e/f", "g", "ab/c"} {
+		paths.add(p)
+	}
+	for _, c := range []struct {
+		parent   string
+		expected []string
+	}{
+		{"a/b/e/f", []string{}},
+		{"a/b", []string{"a/b/c", "a/b/e", "a/b/e/f"}},
+		{"a/b", []string{}}, // Removed already
+		{"a", []string{"a/b", "a/d"}},
+		{".", []string{"a", "ab", "ab/c", "g"}},
+	} {
+		removed := []string{}
+		paths.removeUnder(c.parent, func(p string) {
+			removed = append(removed, p)
+		})
+		sort.Strings(removed)
+		assert.Equal(t, c.expected, removed, c.parent)
+	}
+}
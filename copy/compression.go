@@ -6,9 +6,11 @@ import (
 	"io"
 
 	internalblobinfocache "github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/pkg/compression"
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
 	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,7 +25,15 @@ type bpDetectCompressionStepData struct {
 // blobPipelineDetectCompressionStep updates *stream to detect its current compression format.
 // srcInfo is only used for error messages.
 // Returns data for other steps.
-func blobPipelineDetectCompressionStep(stream *sourceStream, srcInfo types.BlobInfo) (bpDetectCompressionStepData, error) {
+func blobPipelineDetectCompressionStep(stream *sourceStream, srcInfo types.BlobInfo, decryptionStep *bpDecryptionStepData) (bpDetectCompressionStepData, error) {
+	if isOciEncrypted(stream.info.MediaType) && !decryptionStep.decrypting {
+		// The stream is encrypted and we can’t decrypt it; anything which looks like a compression format is a coincidence.
+		// The blob is passed through unmodified (see bpcPreserveEncrypted), and we don't know how its contents are compressed.
+		return bpDetectCompressionStepData{
+			isCompressed:      false,
+			srcCompressorName: internalblobinfocache.UnknownCompression,
+		}, nil
+	}
 	// This requires us to “peek ahead” into the stream to read the initial part, which requires us to chain through another io.Reader returned by DetectCompression.
 	format, decompressor, reader, err := compression.DetectCompressionFormat(stream.reader) // We could skip this in some cases, but let's keep the code path uniform
 	if err != nil {
@@ -71,12 +81,16 @@ func (ic *imageCopier) blobPipelineCompressionStep(stream *sourceStream, canModi
 		logrus.Debugf("Compression change for blob %s (%q) not supported", srcInfo.Digest, stream.info.MediaType)
 	}
 	if canModifyBlob && layerCompressionChangeSupported {
-		for _, fn := range []func(*sourceStream, bpDetectCompressionStepData) (*bpCompressionStepData, error){
+		steps := []func(*sourceStream, bpDetectCompressionStepData) (*bpCompressionStepData, error){
 			ic.bpcPreserveEncrypted,
-			ic.bpcCompressUncompressed,
-			ic.bpcRecompressCompressed,
-			ic.bpcDecompressCompressed,
-		} {
+		}
+		if ic.c.minimumLayerSizeToCompress > 0 && srcInfo.Size >= 0 && srcInfo.Size < ic.c.minimumLayerSizeToCompress {
+			logrus.Debugf("Not compressing blob %s, its size %d is below the minimum %d", srcInfo.Digest, srcInfo.Size, ic.c.minimumLayerSizeToCompress)
+		} else {
+			steps = append(steps, ic.bpcCompressUncompressed, ic.bpcRecompressCompressed)
+		}
+		steps = append(steps, ic.bpcDecompressCompressed)
+		for _, fn := range steps {
 			res, err := fn(stream, detected)
 			if err != nil {
 				return nil, err
@@ -106,7 +120,7 @@ func (ic *imageCopier) bpcPreserveEncrypted(stream *sourceStream, _ bpDetectComp
 
 // bpcCompressUncompressed checks if we should be compressing an uncompressed input, and returns a *bpCompressionStepData if so.
 func (ic *imageCopier) bpcCompressUncompressed(stream *sourceStream, detected bpDetectCompressionStepData) (*bpCompressionStepData, error) {
-	if ic.c.dest.DesiredLayerCompression() == types.Compress && !detected.isCompressed {
+	if ic.c.desiredLayerCompression() == types.Compress && !detected.isCompressed {
 		logrus.Debugf("Compressing blob on the fly")
 		var uploadedAlgorithm *compressiontypes.Algorithm
 		if ic.c.compressionFormat != nil {
@@ -136,7 +150,7 @@ func (ic *imageCopier) bpcCompressUncompressed(stream *sourceStream, detected bp
 
 // bpcRecompressCompressed checks if we should be recompressing a compressed input to another format, and returns a *bpCompressionStepData if so.
 func (ic *imageCopier) bpcRecompressCompressed(stream *sourceStream, detected bpDetectCompressionStepData) (*bpCompressionStepData, error) {
-	if ic.c.dest.DesiredLayerCompression() == types.Compress && detected.isCompressed &&
+	if ic.c.desiredLayerCompression() == types.Compress && detected.isCompressed &&
 		ic.c.compressionFormat != nil && ic.c.compressionFormat.Name() != detected.format.Name() {
 		// When the blob is compressed, but the desired format is different, it first needs to be decompressed and finally
 		// re-compressed using the desired format.
@@ -175,7 +189,7 @@ func (ic *imageCopier) bpcRecompressCompressed(stream *sourceStream, detected bp
 
 // bpcDecompressCompressed checks if we should be decompressing a compressed input, and returns a *bpCompressionStepData if so.
 func (ic *imageCopier) bpcDecompressCompressed(stream *sourceStream, detected bpDetectCompressionStepData) (*bpCompressionStepData, error) {
-	if ic.c.dest.DesiredLayerCompression() == types.Decompress && detected.isCompressed {
+	if ic.c.desiredLayerCompression() == types.Decompress && detected.isCompressed {
 		logrus.Debugf("Blob will be decompressed")
 		s, err := detected.decompressor(stream.reader)
 		if err != nil {
@@ -279,6 +293,27 @@ func (d *bpCompressionStepData) close() {
 	}
 }
 
+// desiredLayerCompression returns the layer compression operation to aim for:
+// the destination’s preference, unless the user has asked us to decompress all layers.
+func (c *copier) desiredLayerCompression() types.LayerCompression {
+	if c.decompressLayers {
+		return types.Decompress
+	}
+	return c.dest.DesiredLayerCompression()
+}
+
+// isUncompressedLayerMIMEType returns true if mimeType is known to identify uncompressed layers.
+// This is false for "", which is used e.g. for schema1 layers.
+func isUncompressedLayerMIMEType(mimeType string) bool {
+	switch mimeType {
+	case manifest.DockerV2SchemaLayerMediaTypeUncompressed, manifest.DockerV2Schema2ForeignLayerMediaType,
+		imgspecv1.MediaTypeImageLayer, imgspecv1.MediaTypeImageLayerNonDistributable:
+		return true
+	default:
+		return false
+	}
+}
+
 // doCompression reads all input from src and writes its compressed equivalent to dest.
 func doCompression(dest io.Writer, src io.Reader, metadata map[string]string, compressionFormat compressiontypes.Algorithm, compressionLevel *int) error {
 	compressor, err := compression.CompressStreamWithMetadata(dest, metadata, compressionFormat, compressionLevel)
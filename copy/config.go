@@ -0,0 +1,178 @@
+package copy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// imageConfigFields are the JSON keys of imgspecv1.ImageConfig; the Docker schema2 config uses the same keys for these values.
+var imageConfigFields = []string{"User", "ExposedPorts", "Env", "Entrypoint", "Cmd", "Volumes", "WorkingDir", "Labels", "StopSignal"}
+
+// updatedConfigImage is a types.Image with a replaced config blob, and a manifest referring to it.
+type updatedConfigImage struct {
+	types.Image
+	manifest     []byte
+	manifestType string
+	configBlob   []byte
+	configInfo   types.BlobInfo
+}
+
+func (i *updatedConfigImage) Manifest(ctx context.Context) ([]byte, string, error) {
+	return i.manifest, i.manifestType, nil
+}
+
+func (i *updatedConfigImage) ConfigInfo() types.BlobInfo {
+	return i.configInfo
+}
+
+func (i *updatedConfigImage) ConfigBlob(ctx context.Context) ([]byte, error) {
+	return i.configBlob, nil
+}
+
+// updatedImageConfig returns img with its config updated using c.updateImageConfig, and digested using algorithm.
+func (c *copier) updatedImageConfig(ctx context.Context, img types.Image, algorithm digest.Algorithm) (types.Image, error) {
+	return updatedConfigBlobImage(ctx, img, algorithm, c.updateConfigBlob)
+}
+
+// updatedConfigBlobImage returns img with its config blob replaced by the result of updateConfigBlob (or unmodified, if it is nil),
+// digested using algorithm, and its manifest updated to refer to the new config.
+func updatedConfigBlobImage(ctx context.Context, img types.Image, algorithm digest.Algorithm, updateConfigBlob func(configBlob []byte) ([]byte, error)) (types.Image, error) {
+	man, manType, err := img.Manifest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if img.ConfigInfo().Digest == "" {
+		return nil, fmt.Errorf("updating the image config of a manifest of type %q is not supported", manType)
+	}
+	configBlob, err := img.ConfigBlob(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading config blob: %w", err)
+	}
+	if updateConfigBlob != nil {
+		configBlob, err = updateConfigBlob(configBlob)
+		if err != nil {
+			return nil, err
+		}
+	}
+	configInfo := img.ConfigInfo()
+	configInfo.Digest = algorithm.FromBytes(configBlob)
+	configInfo.Size = int64(len(configBlob))
+
+	switch manType {
+	case manifest.DockerV2Schema2MediaType:
+		m, err := manifest.Schema2FromManifest(man)
+		if err != nil {
+			return nil, fmt.Errorf("parsing manifest to update the image config: %w", err)
+		}
+		m.ConfigDescriptor.Digest = configInfo.Digest
+		m.ConfigDescriptor.Size = configInfo.Size
+		man, err = m.Serialize()
+		if err != nil {
+			return nil, err
+		}
+	case imgspecv1.MediaTypeImageManifest:
+		m, err := manifest.OCI1FromManifest(man)
+		if err != nil {
+			return nil, fmt.Errorf("parsing manifest to update the image config: %w", err)
+		}
+		m.Config.Digest = configInfo.Digest
+		m.Config.Size = configInfo.Size
+		man, err = m.Serialize()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("updating the image config of a manifest of type %q is not supported", manType)
+	}
+	return &updatedConfigImage{
+		Image:        img,
+		manifest:     man,
+		manifestType: manType,
+		configBlob:   configBlob,
+		configInfo:   configInfo,
+	}, nil
+}
+
+// updateConfigBlob returns configBlob, a Docker schema2 or OCI config, with the "config" section replaced
+// by the result of c.updateImageConfig.
+// Other fields, including fields of the "config" section not represented in imgspecv1.ImageConfig, are preserved.
+func (c *copier) updateConfigBlob(configBlob []byte) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return nil, fmt.Errorf("parsing image config: %w", err)
+	}
+	section := map[string]json.RawMessage{}
+	var original imgspecv1.ImageConfig
+	if raw, ok := config["config"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &section); err != nil {
+			return nil, fmt.Errorf("parsing image config: %w", err)
+		}
+		if err := json.Unmarshal(raw, &original); err != nil {
+			return nil, fmt.Errorf("parsing image config: %w", err)
+		}
+	}
+
+	updated, err := c.updateImageConfig(original)
+	if err != nil {
+		return nil, fmt.Errorf("updating image config: %w", err)
+	}
+	updatedBlob, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+	var updatedSection map[string]json.RawMessage
+	if err := json.Unmarshal(updatedBlob, &updatedSection); err != nil {
+		return nil, err
+	}
+	for _, field := range imageConfigFields {
+		if value, ok := updatedSection[field]; ok {
+			section[field] = value
+		} else {
+			delete(section, field)
+		}
+	}
+
+	sectionBlob, err := json.Marshal(section)
+	if err != nil {
+		return nil, err
+	}
+	config["config"] = sectionBlob
+	return json.Marshal(config)
+}
+
+// overridesPlatform returns true if the platform in image configs should be overridden per c.overridePlatform.
+func (c *copier) overridesPlatform() bool {
+	return c.overridePlatform.OS != "" || c.overridePlatform.Architecture != "" || c.overridePlatform.Variant != ""
+}
+
+// overridePlatformInConfigBlob returns configBlob, a Docker schema2 or OCI config, with the "os", "architecture" and "variant"
+// fields replaced by the non-empty values in c.overridePlatform. Other fields are preserved.
+func (c *copier) overridePlatformInConfigBlob(configBlob []byte) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return nil, fmt.Errorf("parsing image config: %w", err)
+	}
+	for _, field := range []struct {
+		name, value string
+	}{
+		{"os", c.overridePlatform.OS},
+		{"architecture", c.overridePlatform.Architecture},
+		{"variant", c.overridePlatform.Variant},
+	} {
+		if field.value == "" {
+			continue
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		config[field.name] = value
+	}
+	return json.Marshal(config)
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"os"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,10 +19,12 @@ import (
 	"github.com/containers/image/v5/internal/image"
 	"github.com/containers/image/v5/internal/imagedestination"
 	"github.com/containers/image/v5/internal/imagesource"
+	internalManifest "github.com/containers/image/v5/internal/manifest"
 	"github.com/containers/image/v5/internal/pkg/platform"
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/pkg/blobinfocache"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
 	"github.com/containers/image/v5/pkg/compression"
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
 	"github.com/containers/image/v5/signature"
@@ -39,6 +43,9 @@ var (
 	// ErrDecryptParamsMissing is returned if there is missing decryption parameters
 	ErrDecryptParamsMissing = errors.New("Necessary DecryptParameters not present")
 
+	// ErrDestinationExists is wrapped by the error returned if Options.FailIfDestinationExists is set and the destination already exists
+	ErrDestinationExists = errors.New("destination image already exists")
+
 	// maxParallelDownloads is used to limit the maximum number of parallel
 	// downloads.  Let's follow Firefox by limiting it to 6.
 	maxParallelDownloads = uint(6)
@@ -46,6 +53,9 @@ var (
 	// defaultCompressionFormat is used if the destination transport requests
 	// compression, and the user does not explicitly instruct us to use an algorithm.
 	defaultCompressionFormat = &compression.Gzip
+
+	// anchoredTagRegexp matches valid tag names, used for Options.AdditionalTags.
+	anchoredTagRegexp = regexp.MustCompile("^" + reference.TagRegexp.String() + "$")
 )
 
 // compressionBufferSize is the buffer size used to compress a blob
@@ -75,6 +85,34 @@ type copier struct {
 	ociEncryptConfig              *encconfig.EncryptConfig
 	concurrentBlobCopiesSemaphore *semaphore.Weighted // Limits the amount of concurrently copied blobs
 	downloadForeignLayers         bool
+	decompressLayers              bool  // Decompress all layers, overriding dest.DesiredLayerCompression()
+	minimumLayerSizeToCompress    int64 // Don’t compress layers smaller than this, see Options.MinimumLayerSizeToCompress
+
+	provenanceAnnotations          map[string]string // Annotations to add to the top-level manifest, see Options.ProvenanceAnnotations
+	overwriteProvenanceAnnotations bool
+
+	updateImageConfig func(config imgspecv1.ImageConfig) (imgspecv1.ImageConfig, error) // See Options.UpdateImageConfig
+	stripBuildCache   bool                                                              // See Options.StripBuildCache
+	verifyDiffIDs     bool                                                              // See Options.VerifyLayerDiffIDs
+	annotateLayers    bool                                                              // See Options.AnnotateLayers
+
+	overridePlatform      imgspecv1.Platform // Only OS, Architecture and Variant are set, see Options.OverrideOS etc.
+	overrideListPlatforms bool               // See Options.OverrideListPlatforms
+
+	coalesceLayersSmallerThan int64 // See Options.CoalesceLayersSmallerThan
+	coalesceLayersInto        int   // See Options.CoalesceLayersInto
+
+	verifyBlobsBeforeManifest bool // See Options.VerifyBlobsBeforeManifest
+	deduplicateLayers         bool // See Options.DeduplicateLayers
+
+	skipFailedInstances bool                // Omit instances which fail to copy from the list, see ImageSkippingFailedInstances
+	instanceFailures    []InstanceCopyError // The instances omitted if skipFailedInstances, in the order of the source list
+
+	digestAlgorithm digest.Algorithm // The algorithm to use for new digests, see SystemContext.DigestAlgorithm; never ""
+
+	policyContextLock           sync.Mutex // Serializes uses of the PolicyContext, which is not safe for concurrent use, by concurrent instance copies.
+	destinationWriteLock        sync.Mutex // Serializes writing manifests, signing, and writing signatures by concurrent instance copies.
+	ociEncryptLayerSelectorLock sync.Mutex // Serializes calls to Options.OciEncryptLayerSelector by concurrent instance copies.
 }
 
 // imageCopier tracks state specific to a single image (possibly an item of a manifest list)
@@ -82,10 +120,19 @@ type imageCopier struct {
 	c                          *copier
 	manifestUpdates            *types.ManifestUpdateOptions
 	src                        *image.SourcedImage
+	rawSource                  private.ImageSource // The source of the blobs of src; usually c.rawSource
 	diffIDsAreNeeded           bool
 	cannotModifyManifestReason string // The reason the manifest cannot be modified, or an empty string if it can
 	canSubstituteBlobs         bool
 	ociEncryptLayers           *[]int
+	ociEncryptLayerSelector    func(index int, layer types.BlobInfo) bool
+	addProvenanceAnnotations   bool             // Add c.provenanceAnnotations to the manifest; only set for the top-level image.
+	digestAlgorithm            digest.Algorithm // The algorithm to use for new digests of this image; never ""
+	expectedDiffIDs            []digest.Digest  // If not nil, the DiffIDs from the config to verify each layer against, see Options.VerifyLayerDiffIDs
+	reuseDiffIDs               []digest.Digest  // If not nil, the DiffIDs from the config, to look for differently-compressed variants of layers at the destination
+	// DiffIDs from the config to verify layers against, by layer index, because they are used instead of other layers with
+	// the same DiffID; see Options.DeduplicateLayers
+	deduplicatedDiffIDs map[int]digest.Digest
 }
 
 const (
@@ -110,13 +157,20 @@ const (
 	// only accept one image (i.e., it cannot accept lists), an error
 	// should be returned.
 	CopySpecificImages
+	// CopyPlatformImage is a value which, when set in
+	// Options.ImageListSelection, indicates that the caller expects only one
+	// image to be copied, so if the source reference refers to a list of
+	// images, the one that matches Options.Platform will be selected, and
+	// written to the destination as a single image, without the list.
+	CopyPlatformImage
 )
 
-// ImageListSelection is one of CopySystemImage, CopyAllImages, or
-// CopySpecificImages, to control whether, when the source reference is a list,
-// copy.Image() copies only an image which matches the current runtime
-// environment, or all images which match the supplied reference, or only
-// specific images from the source reference.
+// ImageListSelection is one of CopySystemImage, CopyAllImages,
+// CopySpecificImages, or CopyPlatformImage, to control whether, when the source
+// reference is a list, copy.Image() copies only an image which matches the
+// current runtime environment, or all images which match the supplied reference,
+// or only specific images from the source reference, or only an image which
+// matches a specific platform.
 type ImageListSelection int
 
 const (
@@ -152,9 +206,14 @@ type Options struct {
 	// Preserve digests, and fail if we cannot.
 	PreserveDigests bool
 	// manifest MIME type of image set by user. "" is default and means use the autodetection to the the manifest MIME type
+	// Converting the manifest only rewrites the layer media types; layers are not recompressed unless a different
+	// compression format is requested, so their digests stay the same.
 	ForceManifestMIMEType string
-	ImageListSelection    ImageListSelection // set to either CopySystemImage (the default), CopyAllImages, or CopySpecificImages to control which instances we copy when the source reference is a list; ignored if the source reference is not a list
+	ImageListSelection    ImageListSelection // set to either CopySystemImage (the default), CopyAllImages, CopySpecificImages, or CopyPlatformImage to control which instances we copy when the source reference is a list; ignored if the source reference is not a list
 	Instances             []digest.Digest    // if ImageListSelection is CopySpecificImages, copy only these instances and the list itself
+	// If ImageListSelection is CopyPlatformImage, the platform of the instance to copy. Fields which are empty are taken from SourceCtx,
+	// or the current system, as with CopySystemImage.
+	Platform *imgspecv1.Platform
 
 	// If OciEncryptConfig is non-nil, it indicates that an image should be encrypted.
 	// The encryption options is derived from the construction of EncryptConfig object.
@@ -167,8 +226,15 @@ type Options struct {
 	// integers in the slice represent 0-indexed layer indices, with support for negative
 	// indexing. i.e. 0 is the first layer, -1 is the last (top-most) layer.
 	OciEncryptLayers *[]int
+	// If not nil, OciEncryptLayerSelector is called with the 0-based index and the source BlobInfo of each layer, and the layers
+	// for which it returns true are encrypted using OciEncryptConfig; the other layers are not modified.
+	// It can't be used together with OciEncryptLayers. When copying multiple images from a manifest list in parallel
+	// (see MaxParallelInstanceCopies), it is called for layers of several images, but never concurrently.
+	OciEncryptLayerSelector func(index int, layer types.BlobInfo) bool
 	// OciDecryptConfig contains the config that can be used to decrypt an image if it is
 	// encrypted if non-nil. If nil, it does not attempt to decrypt an image.
+	// Each encrypted layer is decrypted using whichever of the configured keys matches one of its recipients, and the copy fails
+	// if there is no such key. The decrypted layers use the plaintext media types, so the destination does not need to support encryption.
 	OciDecryptConfig *encconfig.DecryptConfig
 
 	// A weighted semaphore to limit the amount of concurrently copied layers and configs. Applies to all copy operations using the semaphore. If set, MaxParallelDownloads is ignored.
@@ -177,6 +243,14 @@ type Options struct {
 	// MaxParallelDownloads indicates the maximum layers to pull at the same time. Applies to a single copy operation. A reasonable default is used if this is left as 0. Ignored if ConcurrentBlobCopiesSemaphore is set.
 	MaxParallelDownloads uint
 
+	// MaxParallelInstanceCopies indicates the maximum number of images from a manifest list to copy at the same time, when
+	// copying more than one of them (see ImageListSelection). The images are copied one at a time if this is 0 or 1, or if
+	// the source or destination does not support concurrent blob transfers. Layers copied concurrently for all of the images
+	// are limited together by MaxParallelDownloads or ConcurrentBlobCopiesSemaphore. Writing the manifests and signatures of
+	// the images, and signing them, still happens one image at a time. While images are copied concurrently, progress is
+	// reported to ReportWriter one line per blob instead of using progress bars.
+	MaxParallelInstanceCopies uint
+
 	// When OptimizeDestinationImageAlreadyExists is set, optimize the copy assuming that the destination image already
 	// exists (and is equivalent). Making the eventual (no-op) copy more performant for this case. Enabling the option
 	// is slightly pessimistic if the destination image doesn't exist, or is not equivalent.
@@ -189,22 +263,211 @@ type Options struct {
 	// When only a subset of images of a list is copied, this action indicates if the manifest should be kept or stripped.
 	// See CopySpecificImages.
 	SparseImageListAction SparseManifestListAction
+
+	// Signers to use to add signatures during the copy, in addition to SignBy and SignBySigstorePrivateKeyFile.
+	// This allows using signing backends not directly supported by this library, e.g. keys stored in a HSM or a cloud KMS.
+	Signers []signature.Signer
+
+	// If set, decompress all layers, regardless of the compression preferred by the destination, and update
+	// the manifest to use the uncompressed layer MIME types. The layer DiffIDs, and therefore the config, are
+	// not affected. Fails if the manifest cannot be modified (e.g. when preserving digests or copying signatures).
+	DecompressLayers bool
+
+	// Annotations to add to the manifest written to the destination (or, when copying a manifest list, to the image index),
+	// e.g. to record the provenance of the image. This requires the image to use, or be converted to, an OCI format.
+	// Annotations which already exist in the manifest are not modified unless OverwriteProvenanceAnnotations is set.
+	ProvenanceAnnotations map[string]string
+	// If set, ProvenanceAnnotations replace existing manifest annotations with the same keys.
+	OverwriteProvenanceAnnotations bool
+
+	// If not empty, the copy fails, before copying any blobs, unless the digest of the source manifest is one of
+	// these values. When copying a single image from a manifest list, the digest of either the list or the chosen
+	// instance may be allowed; when copying a list with its instances, the digest of the list must be allowed.
+	AllowedManifestDigests []digest.Digest
+
+	// If set, an SBOM to write to the destination as an OCI artifact referring to the copied image (or, when copying
+	// a manifest list, to the list) using the OCI "subject" field, so that it can be discovered using the referrers API.
+	// For registries which don't support the referrers API, the "referrers tag schema" fallback is maintained.
+	// The copy fails if the destination transport can't store such artifacts (currently only docker:, oci: and oci-archive: can).
+	SBOM *SBOM
+
+	// If set, artifacts which refer to the copied image (or, when copying a manifest list, to the list) using the OCI "subject"
+	// field, e.g. signatures or SBOMs stored as OCI artifacts, are also copied. The copy fails if the source transport can't
+	// list such artifacts, or if the destination transport can't store them (like Options.SBOM). As with PreserveDigests, the copied
+	// manifests are not modified, because the artifacts would no longer refer to them; the copy fails, before copying any layers,
+	// if that is not possible.
+	CopyReferrers bool
+
+	// If > 0, layers with a known size smaller than this number of bytes are not compressed nor recompressed, even if
+	// compression is requested by the destination or DestinationCtx.CompressionFormat; they are copied as they are, and the
+	// manifest reflects the compression of each individual layer. Decompressing layers (e.g. DecompressLayers) is not affected.
+	MinimumLayerSizeToCompress int64
+
+	// If set, SignBy, SignBySigstorePrivateKeyFile and Signers don’t add a new signature if the signatures copied from the source
+	// already include a valid signature of the destination manifest, by the same key and for the same identity.
+	// Only the source signatures are considered: signatures already present at the destination are not read, because
+	// they are replaced by the copied and newly created ones (or, with RemoveSignatures, no signatures are considered at all).
+	// Signers must implement signature.VerifyingSigner; SignBy must be a fingerprint or a key ID.
+	SkipSigningIfSourceSigned bool
+
+	// If set, called with the execution parameters ("config" section) of the config of each copied image; the config written
+	// to the destination uses the returned value instead, and the manifest is updated to refer to it. Other parts of the
+	// config, notably the layer DiffIDs and history, are not affected. Fails if the manifest cannot be modified.
+	UpdateImageConfig func(config imgspecv1.ImageConfig) (imgspecv1.ImageConfig, error)
+
+	// If set, build cache metadata, notably the BuildKit inline cache in the image config and BuildKit cache annotations
+	// in the manifest, is removed from the copied images; the layers are not affected. Fails if the manifest cannot be modified.
+	StripBuildCache bool
+
+	// If > 0, the copy of an image with more than this number of layers fails, before copying any of its blobs.
+	// When copying a manifest list, this applies to each copied instance individually.
+	MaxLayers int
+
+	// If not empty, after the manifest (or, when copying a manifest list, the list) is written to the destination, the same
+	// manifest is also written under each of these tags, in the same repository, without copying any blobs again.
+	// Only supported by destinations which can store additional tags, e.g. the docker transport.
+	AdditionalTags []string
+
+	// If set, the uncompressed digest (DiffID) of every layer is computed while it is copied, and the copy fails if it does not
+	// match the DiffID recorded in the image config. This requires reading all layers from the source, so layers already
+	// present at the destination are not reused. Not supported for Docker schema1 images, which do not record DiffIDs.
+	VerifyLayerDiffIDs bool
+
+	// If set, each layer descriptor in the manifest written to the destination is annotated with the DiffID of the layer
+	// (LayerDiffIDAnnotation) and the size of the layer blob (LayerSizeAnnotation), so that tools can index the layers
+	// from the manifest alone. The layer blobs are not affected. This requires the image to use, or be converted to,
+	// an OCI format; DiffIDs not known from the blob info cache are computed while copying the layers.
+	AnnotateLayers bool
+
+	// If set, the copy of a single image fails, before copying any blobs, unless the OS and architecture in its config
+	// match the requested platform: Options.Platform if ImageListSelection is CopyPlatformImage, otherwise the platform
+	// selected by SourceCtx or the current system. This applies whether or not the image was chosen from a manifest list,
+	// and catches mislabeled images; it does not apply to the instances copied with CopyAllImages or CopySpecificImages.
+	VerifyPlatform bool
+
+	// If not empty, the "os", "architecture" and "variant" fields, respectively, of the config of each copied image are replaced
+	// by these values, and the manifest is updated to refer to the new config; e.g. to label an image built for another platform.
+	// Fields whose override is empty are not modified. The layers are not affected. Fails if the manifest cannot be modified.
+	OverrideOS           string
+	OverrideArchitecture string
+	OverrideVariant      string
+	// If set, when copying a manifest list, the platform recorded in the list for each copied instance is also updated
+	// per OverrideOS, OverrideArchitecture and OverrideVariant.
+	OverrideListPlatforms bool
+
+	// If set, the copy fails with an error wrapping ErrDestinationExists, before the destination is opened and before copying
+	// anything, if the destination already contains a manifest (e.g. if the destination tag already exists in a registry),
+	// unless the digest of that manifest is one of AllowedExistingDestinationDigests. Only a destination known not to exist
+	// is accepted: if that can't be determined (e.g. because the destination can't be accessed), the copy fails.
+	// This is supported for the docker://, dir:, oci: and containers-storage: transports.
+	FailIfDestinationExists bool
+	// If FailIfDestinationExists is set, an existing destination manifest with one of these digests is overwritten
+	// instead of failing the copy; e.g. the digest of the source manifest, to allow repeating a copy.
+	AllowedExistingDestinationDigests []digest.Digest
+
+	// If > 0, each run of consecutive layers whose size is known and smaller than this number of bytes is merged into a single
+	// uncompressed layer before copying, combining their tar streams so that entries replaced or removed by later layers are
+	// dropped, and whiteouts still apply to the layers below. The DiffIDs and history in the config, and the manifest, are updated.
+	// Foreign and encrypted layers are not merged. Fails if the manifest cannot be modified, and for Docker schema1 images.
+	CoalesceLayersSmallerThan int64
+	// If > 0, and an image has more than this number of layers, its layers are merged, as with CoalesceLayersSmallerThan,
+	// into this number of layers, each containing a similar number of consecutive source layers.
+	// It can't be used together with CoalesceLayersSmallerThan.
+	CoalesceLayersInto int
+
+	// If set, before writing the manifest of each copied image, the copy checks that every blob referenced by the manifest,
+	// except for foreign layers which are not copied, exists at the destination, and fails without writing the manifest
+	// if any of them is missing; e.g. to avoid creating a broken image if a registry silently drops a blob upload.
+	VerifyBlobsBeforeManifest bool
+
+	// If set, a layer which is referenced more than once in the manifest of an image is only copied once. If the manifest
+	// can be modified, layers with the same DiffID (i.e. the same uncompressed contents) but a different compressed
+	// representation are also only copied once, and the manifest is updated so that all of them refer to the same blob;
+	// the DiffIDs are read from the image config, so the copy fails if the layer which is copied does not match its DiffID.
+	DeduplicateLayers bool
+}
+
+const (
+	// LayerDiffIDAnnotation is the layer annotation set to the DiffID of the layer if Options.AnnotateLayers.
+	LayerDiffIDAnnotation = "io.github.containers.image.layer.diffid"
+	// LayerSizeAnnotation is the layer annotation set to the size of the layer blob, in bytes, if Options.AnnotateLayers.
+	LayerSizeAnnotation = "io.github.containers.image.layer.size"
+)
+
+// destinationDigestAlgorithm returns the algorithm to use for new digests of blobs and manifests written to dest,
+// as requested in sys (which may be nil), if dest supports it; digest.Canonical otherwise.
+func destinationDigestAlgorithm(sys *types.SystemContext, dest private.ImageDestination) (digest.Algorithm, error) {
+	if sys == nil || sys.DigestAlgorithm == "" || sys.DigestAlgorithm == digest.Canonical {
+		return digest.Canonical, nil
+	}
+	if !sys.DigestAlgorithm.Available() {
+		return "", fmt.Errorf("digest algorithm %q is not supported", sys.DigestAlgorithm)
+	}
+	if d, ok := dest.(private.ImageDestinationWithDigestAlgorithms); ok && d.SupportsDigestAlgorithm(sys.DigestAlgorithm) {
+		return sys.DigestAlgorithm, nil
+	}
+	logrus.Debugf("The destination does not support digest algorithm %q, using %q", sys.DigestAlgorithm, digest.Canonical)
+	return digest.Canonical, nil
+}
+
+// putManifest writes m to c.dest, like c.dest.PutManifest; if instanceDigest is nil and the destination computes
+// the digest of the manifest itself, it uses algorithm, which must be either digest.Canonical or c.digestAlgorithm.
+func (c *copier) putManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest, algorithm digest.Algorithm) error {
+	if d, ok := c.dest.(private.ImageDestinationWithDigestAlgorithms); ok {
+		return d.PutManifestWithDigestAlgorithm(ctx, m, instanceDigest, algorithm)
+	}
+	return c.dest.PutManifest(ctx, m, instanceDigest)
 }
 
 // validateImageListSelection returns an error if the passed-in value is not one that we recognize as a valid ImageListSelection value
 func validateImageListSelection(selection ImageListSelection) error {
 	switch selection {
-	case CopySystemImage, CopyAllImages, CopySpecificImages:
+	case CopySystemImage, CopyAllImages, CopySpecificImages, CopyPlatformImage:
 		return nil
 	default:
 		return fmt.Errorf("Invalid value for options.ImageListSelection: %d", selection)
 	}
 }
 
+// platformSystemContext returns a copy of sys (which may be nil) which selects platform when choosing an image from a list.
+func platformSystemContext(sys *types.SystemContext, platform *imgspecv1.Platform) *types.SystemContext {
+	res := types.SystemContext{}
+	if sys != nil {
+		res = *sys
+	}
+	if platform.OS != "" {
+		res.OSChoice = platform.OS
+	}
+	if platform.Architecture != "" {
+		res.ArchitectureChoice = platform.Architecture
+		res.VariantChoice = platform.Variant // The variant of a different architecture would not make sense.
+	} else if platform.Variant != "" {
+		res.VariantChoice = platform.Variant
+	}
+	return &res
+}
+
+// wantedPlatformContext returns the SystemContext which selects the platform of the image to copy from a list, per options.
+func wantedPlatformContext(options *Options) *types.SystemContext {
+	if options.ImageListSelection == CopyPlatformImage {
+		return platformSystemContext(options.SourceCtx, options.Platform)
+	}
+	return options.SourceCtx
+}
+
 // Image copies image from srcRef to destRef, using policyContext to validate
 // source image admissibility.  It returns the manifest which was written to
 // the new copy of the image.
 func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) (copiedManifest []byte, retErr error) {
+	return copyImage(ctx, policyContext, destRef, srcRef, options, nil, nil)
+}
+
+// copyImage is Image, except that if instanceDigest is not nil, srcRef must be a manifest list, and only the instance
+// with instanceDigest is copied, as a single image; options.ImageListSelection is ignored in that case.
+// If instanceFailures is not nil, instances of a manifest list which fail to copy are omitted from the copied list,
+// and the failures are stored in *instanceFailures, as in ImageSkippingFailedInstances.
+func copyImage(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options,
+	instanceDigest *digest.Digest, instanceFailures *[]InstanceCopyError) (copiedManifest []byte, retErr error) {
 	// NOTE this function uses an output parameter for the error return value.
 	// Setting this and returning is the ideal way to return an error.
 	//
@@ -217,6 +480,9 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 	if err := validateImageListSelection(options.ImageListSelection); err != nil {
 		return nil, err
 	}
+	if options.ImageListSelection == CopyPlatformImage && options.Platform == nil {
+		return nil, errors.New("options.Platform must be set when using CopyPlatformImage")
+	}
 
 	reportWriter := io.Discard
 
@@ -224,6 +490,13 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		reportWriter = options.ReportWriter
 	}
 
+	// This must happen before opening the destination, which may remove an existing image (e.g. with dir:).
+	if options.FailIfDestinationExists {
+		if err := checkDestinationDoesNotExist(ctx, destRef, options); err != nil {
+			return nil, err
+		}
+	}
+
 	publicDest, err := destRef.NewImageDestination(ctx, options.DestinationCtx)
 	if err != nil {
 		return nil, fmt.Errorf("initializing destination %s: %w", transports.ImageName(destRef), err)
@@ -276,6 +549,32 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		ociDecryptConfig:      options.OciDecryptConfig,
 		ociEncryptConfig:      options.OciEncryptConfig,
 		downloadForeignLayers: options.DownloadForeignLayers,
+		decompressLayers:      options.DecompressLayers,
+
+		minimumLayerSizeToCompress: options.MinimumLayerSizeToCompress,
+
+		provenanceAnnotations:          options.ProvenanceAnnotations,
+		overwriteProvenanceAnnotations: options.OverwriteProvenanceAnnotations,
+
+		updateImageConfig: options.UpdateImageConfig,
+		stripBuildCache:   options.StripBuildCache,
+		verifyDiffIDs:     options.VerifyLayerDiffIDs,
+		annotateLayers:    options.AnnotateLayers,
+
+		overridePlatform: imgspecv1.Platform{
+			OS:           options.OverrideOS,
+			Architecture: options.OverrideArchitecture,
+			Variant:      options.OverrideVariant,
+		},
+		overrideListPlatforms: options.OverrideListPlatforms,
+
+		coalesceLayersSmallerThan: options.CoalesceLayersSmallerThan,
+		coalesceLayersInto:        options.CoalesceLayersInto,
+
+		verifyBlobsBeforeManifest: options.VerifyBlobsBeforeManifest,
+		deduplicateLayers:         options.DeduplicateLayers,
+
+		skipFailedInstances: instanceFailures != nil,
 	}
 
 	// Set the concurrentBlobCopiesSemaphore if we can copy layers in parallel.
@@ -303,21 +602,66 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		c.compressionFormat = options.DestinationCtx.CompressionFormat
 		c.compressionLevel = options.DestinationCtx.CompressionLevel
 	}
+	c.digestAlgorithm, err = destinationDigestAlgorithm(options.DestinationCtx, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.OciEncryptLayers != nil && options.OciEncryptLayerSelector != nil {
+		return nil, errors.New("OciEncryptLayers and OciEncryptLayerSelector can't be used together")
+	}
+	if options.CoalesceLayersSmallerThan > 0 && options.CoalesceLayersInto > 0 {
+		return nil, errors.New("CoalesceLayersSmallerThan and CoalesceLayersInto can't be used together")
+	}
+
+	if options.SBOM != nil {
+		if err := c.validateSBOM(options.SBOM); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(options.AdditionalTags) != 0 {
+		if err := c.validateAdditionalTags(options.AdditionalTags); err != nil {
+			return nil, err
+		}
+	}
+	if options.CopyReferrers {
+		if _, ok := rawSource.(private.ImageSourceWithReferrers); !ok {
+			return nil, fmt.Errorf("copying referrers: listing referrers is not supported by %s", transports.ImageName(srcRef))
+		}
+		if _, ok := c.dest.(private.ImageDestinationWithReferrers); !ok {
+			return nil, fmt.Errorf("copying referrers: writing referrers is not supported by %s", transports.ImageName(destRef))
+		}
+	}
 
 	unparsedToplevel := image.UnparsedInstance(rawSource, nil)
+	var copiedSource *image.UnparsedImage  // The source of copiedManifest
+	var copiedManifestDigest digest.Digest // The digest of copiedManifest, as written to the destination
 	multiImage, err := isMultiImage(ctx, unparsedToplevel)
 	if err != nil {
 		return nil, fmt.Errorf("determining manifest MIME type for %s: %w", transports.ImageName(srcRef), err)
 	}
 
 	if !multiImage {
+		if instanceDigest != nil {
+			return nil, fmt.Errorf("copying instance %s: %s is not a manifest list", *instanceDigest, transports.ImageName(srcRef))
+		}
 		// The simple case: just copy a single image.
-		if copiedManifest, _, _, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedToplevel, nil); err != nil {
+		if copiedManifest, _, copiedManifestDigest, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedToplevel, nil); err != nil {
 			return nil, err
 		}
-	} else if options.ImageListSelection == CopySystemImage {
+		copiedSource = unparsedToplevel
+	} else if instanceDigest != nil {
+		logrus.Debugf("Source is a manifest list; copying (only) instance %s", *instanceDigest)
+		unparsedInstance := image.UnparsedInstance(rawSource, instanceDigest)
+
+		if copiedManifest, _, copiedManifestDigest, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, nil); err != nil {
+			return nil, fmt.Errorf("copying instance %s from manifest list: %w", *instanceDigest, err)
+		}
+		copiedSource = unparsedInstance
+	} else if options.ImageListSelection == CopySystemImage || options.ImageListSelection == CopyPlatformImage {
 		// This is a manifest list, and we weren't asked to copy multiple images.  Choose a single image that
-		// matches the current system to copy, and copy it.
+		// matches the current system (or the requested platform) to copy, and copy it.
 		mfest, manifestType, err := unparsedToplevel.Manifest(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("reading manifest for %s: %w", transports.ImageName(srcRef), err)
@@ -326,16 +670,19 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		if err != nil {
 			return nil, fmt.Errorf("parsing primary manifest as list for %s: %w", transports.ImageName(srcRef), err)
 		}
-		instanceDigest, err := manifestList.ChooseInstance(options.SourceCtx) // try to pick one that matches options.SourceCtx
+		platformCtx := wantedPlatformContext(options)
+		// Try to pick one that matches platformCtx, looking into nested indexes if necessary.
+		instanceDigest, _, _, err := image.ChooseLeafInstance(ctx, platformCtx, rawSource, manifestList)
 		if err != nil {
 			return nil, fmt.Errorf("choosing an image from manifest list %s: %w", transports.ImageName(srcRef), err)
 		}
-		logrus.Debugf("Source is a manifest list; copying (only) instance %s for current system", instanceDigest)
+		logrus.Debugf("Source is a manifest list; copying (only) instance %s for the selected platform", instanceDigest)
 		unparsedInstance := image.UnparsedInstance(rawSource, &instanceDigest)
 
-		if copiedManifest, _, _, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, nil); err != nil {
+		if copiedManifest, _, copiedManifestDigest, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, nil); err != nil {
 			return nil, fmt.Errorf("copying system image from manifest list: %w", err)
 		}
+		copiedSource = unparsedInstance
 	} else { /* options.ImageListSelection == CopyAllImages or options.ImageListSelection == CopySpecificImages, */
 		// If we were asked to copy multiple images and can't, that's an error.
 		if !supportsMultipleImages(c.dest) {
@@ -348,9 +695,31 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		case CopySpecificImages:
 			logrus.Debugf("Source is a manifest list; copying some instances")
 		}
-		if copiedManifest, err = c.copyMultipleImages(ctx, policyContext, options, unparsedToplevel); err != nil {
+		if copiedManifest, copiedManifestDigest, err = c.copyMultipleImages(ctx, policyContext, options, unparsedToplevel); err != nil {
 			return nil, err
 		}
+		if instanceFailures != nil {
+			*instanceFailures = c.instanceFailures
+		}
+		copiedSource = unparsedToplevel
+	}
+
+	if options.SBOM != nil {
+		if err := c.attachSBOM(ctx, options.SBOM, copiedManifest, copiedManifestDigest); err != nil {
+			return nil, fmt.Errorf("attaching SBOM: %w", err)
+		}
+	}
+
+	if len(options.AdditionalTags) != 0 {
+		if err := c.putAdditionalTags(ctx, options.AdditionalTags, copiedManifest); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.CopyReferrers {
+		if err := c.copyReferrers(ctx, copiedSource, copiedManifest); err != nil {
+			return nil, fmt.Errorf("copying referrers: %w", err)
+		}
 	}
 
 	if err := c.dest.Commit(ctx, unparsedToplevel); err != nil {
@@ -360,6 +729,55 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 	return copiedManifest, nil
 }
 
+// validateAdditionalTags returns an error if tags are not valid, or can't be written to c.dest.
+func (c *copier) validateAdditionalTags(tags []string) error {
+	if _, ok := c.dest.(private.ImageDestinationWithAdditionalTags); !ok {
+		return fmt.Errorf("writing additional tags is not supported by %s", transports.ImageName(c.dest.Reference()))
+	}
+	for _, tag := range tags {
+		if !anchoredTagRegexp.MatchString(tag) {
+			return fmt.Errorf("invalid additional tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// putAdditionalTags writes copiedManifest, which was already written to c.dest, under each of tags.
+func (c *copier) putAdditionalTags(ctx context.Context, tags []string, copiedManifest []byte) error {
+	dest, ok := c.dest.(private.ImageDestinationWithAdditionalTags)
+	if !ok { // Coverage: This should never happen, validateAdditionalTags has checked this.
+		return errors.New("Internal error: writing additional tags is not supported by the destination")
+	}
+	for _, tag := range tags {
+		logrus.Debugf("Writing the manifest with additional tag %s", tag)
+		if err := dest.PutManifestWithTag(ctx, copiedManifest, tag); err != nil {
+			return fmt.Errorf("writing additional tag %s: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+// checkManifestDigestAllowed returns an error unless the digest of any of manifests is one of allowed.
+func checkManifestDigestAllowed(allowed []digest.Digest, manifests ...[]byte) error {
+	for _, m := range manifests {
+		for _, d := range allowed {
+			// Use manifest.MatchesDigest rather than comparing with manifest.Digest, so that allowed can use any algorithm.
+			matches, err := manifest.MatchesDigest(m, d)
+			if err != nil {
+				return fmt.Errorf("computing digest of source image's manifest: %w", err)
+			}
+			if matches {
+				return nil
+			}
+		}
+	}
+	d, err := manifest.Digest(manifests[0])
+	if err != nil {
+		return fmt.Errorf("computing digest of source image's manifest: %w", err)
+	}
+	return fmt.Errorf("source image's manifest digest %s is not one of the allowed digests", d)
+}
+
 // Checks if the destination supports accepting multiple images by checking if it can support
 // manifest types that are lists of other manifests.
 func supportsMultipleImages(dest types.ImageDestination) bool {
@@ -410,6 +828,53 @@ func compareImageDestinationManifestEqual(ctx context.Context, options *Options,
 	return true, destManifest, destManifestType, destManifestDigest, nil
 }
 
+// checkDestinationDoesNotExist returns an error wrapping ErrDestinationExists if destRef contains a manifest
+// with a digest not included in options.AllowedExistingDestinationDigests.
+// Only a destination which is known not to exist is accepted; failures to determine that are returned as errors.
+func checkDestinationDoesNotExist(ctx context.Context, destRef types.ImageReference, options *Options) (retErr error) {
+	withExistenceCheck, ok := destRef.(private.ImageReferenceWithExistenceCheck)
+	if !ok {
+		return fmt.Errorf("checking whether destination %s exists is not supported", transports.ImageName(destRef))
+	}
+	exists, err := withExistenceCheck.ImageExists(ctx, options.DestinationCtx)
+	if err != nil {
+		return fmt.Errorf("checking whether destination %s exists: %w", transports.ImageName(destRef), err)
+	}
+	if !exists {
+		return nil
+	}
+
+	destImageSource, err := destRef.NewImageSource(ctx, options.DestinationCtx)
+	if err != nil {
+		return fmt.Errorf("reading existing destination %s: %w", transports.ImageName(destRef), err)
+	}
+	defer func() {
+		if err := destImageSource.Close(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("closing destination image %s: %w", transports.ImageName(destRef), err)
+		}
+	}()
+
+	destManifest, _, err := destImageSource.GetManifest(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("reading manifest of existing destination %s: %w", transports.ImageName(destRef), err)
+	}
+	for _, allowed := range options.AllowedExistingDestinationDigests {
+		matches, err := manifest.MatchesDigest(destManifest, allowed)
+		if err != nil {
+			return fmt.Errorf("computing digest of destination image's manifest: %w", err)
+		}
+		if matches {
+			logrus.Debugf("Destination image %s exists, with an allowed manifest digest %s", transports.ImageName(destRef), allowed)
+			return nil
+		}
+	}
+	destManifestDigest, err := manifest.Digest(destManifest)
+	if err != nil {
+		return fmt.Errorf("computing digest of destination image's manifest: %w", err)
+	}
+	return fmt.Errorf("%w: %s, with manifest %s", ErrDestinationExists, transports.ImageName(destRef), destManifestDigest)
+}
+
 // removeInstanceFromList removes the given image from the list
 // this should probably move to an internal API
 func removeInstancesFromList(manifestList manifest.List, imageIndices map[int]bool) error {
@@ -437,39 +902,125 @@ func removeInstancesFromList(manifestList manifest.List, imageIndices map[int]bo
 	}
 }
 
+// overrideListPlatforms updates the platforms of the instances at imageIndices in manifestList with the non-empty
+// OS, Architecture and Variant values of platform.
+func overrideListPlatforms(manifestList manifest.List, imageIndices []int, platform imgspecv1.Platform) error {
+	override := func(os, architecture, variant *string) {
+		if platform.OS != "" {
+			*os = platform.OS
+		}
+		if platform.Architecture != "" {
+			*architecture = platform.Architecture
+		}
+		if platform.Variant != "" {
+			*variant = platform.Variant
+		}
+	}
+	switch list := manifestList.(type) {
+	case *manifest.Schema2List:
+		for _, i := range imageIndices {
+			p := &list.Manifests[i].Platform
+			override(&p.OS, &p.Architecture, &p.Variant)
+		}
+		return nil
+	case *manifest.OCI1Index:
+		for _, i := range imageIndices {
+			if list.Manifests[i].Platform == nil {
+				list.Manifests[i].Platform = &imgspecv1.Platform{}
+			}
+			p := list.Manifests[i].Platform
+			override(&p.OS, &p.Architecture, &p.Variant)
+		}
+		return nil
+	default:
+		return fmt.Errorf("overriding platforms in manifest list type %s is not supported", manifestList.MIMEType())
+	}
+}
+
+// attestationSubjects returns the indices of docker buildx attestation manifests in manifestList,
+// mapped to the digests of the instances they describe ("" if not recorded).
+func attestationSubjects(manifestList manifest.List) map[int]digest.Digest {
+	index, ok := manifestList.(*manifest.OCI1Index)
+	if !ok {
+		return nil
+	}
+	res := map[int]digest.Digest{}
+	for i, d := range index.Manifests {
+		if subject, isAttestation := manifest.DockerAttestationSubject(d); isAttestation {
+			res[i] = subject
+		}
+	}
+	return res
+}
+
+// updateAttestationSubjects updates the subject references of the attestation manifests in manifestList, as returned by
+// attestationSubjects, if the digests of their subjects, originally instanceDigests, were changed by updates.
+func updateAttestationSubjects(manifestList manifest.List, subjects map[int]digest.Digest, instanceDigests []digest.Digest, updates []manifest.ListUpdate) {
+	index, ok := manifestList.(*manifest.OCI1Index)
+	if !ok {
+		return
+	}
+	for i, subject := range subjects {
+		for j, instanceDigest := range instanceDigests {
+			if instanceDigest == subject && updates[j].Digest != subject {
+				if index.Manifests[i].Annotations == nil {
+					index.Manifests[i].Annotations = map[string]string{}
+				}
+				index.Manifests[i].Annotations[manifest.DockerReferenceDigestAnnotation] = updates[j].Digest.String()
+				break
+			}
+		}
+	}
+}
+
 // copyMultipleImages copies some or all of an image list's instances, using
 // policyContext to validate source image admissibility.
-func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel *image.UnparsedImage) (copiedManifest []byte, retErr error) {
+func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel *image.UnparsedImage) (copiedManifest []byte, copiedManifestDigest digest.Digest, retErr error) {
+	copiedManifest, _, copiedManifestDigest, err := c.copyList(ctx, policyContext, options, unparsedToplevel, unparsedToplevel, nil, 0)
+	return copiedManifest, copiedManifestDigest, err
+}
+
+// copyList copies unparsedList, either the top-level list unparsedToplevel if targetInstance is nil, or an instance of a list
+// (a nested OCI index) nested depth levels deep, and its instances, or for the top-level list, those selected by options.
+// It returns the manifest of the list as written to the destination, its MIME type, and its digest.
+func (c *copier) copyList(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel, unparsedList *image.UnparsedImage,
+	targetInstance *digest.Digest, depth int) (copiedManifest []byte, copiedManifestType string, copiedManifestDigest digest.Digest, retErr error) {
 	// Parse the list and get a copy of the original value after it's re-encoded.
-	manifestList, manifestType, err := unparsedToplevel.Manifest(ctx)
+	manifestList, manifestType, err := unparsedList.Manifest(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("reading manifest list: %w", err)
+		return nil, "", "", fmt.Errorf("reading manifest list: %w", err)
 	}
 	originalList, err := manifest.ListFromBlob(manifestList, manifestType)
 	if err != nil {
-		return nil, fmt.Errorf("parsing manifest list %q: %w", string(manifestList), err)
+		return nil, "", "", fmt.Errorf("parsing manifest list %q: %w", string(manifestList), err)
+	}
+	// Instances of the top-level list are covered by the check of the top-level list.
+	if len(options.AllowedManifestDigests) != 0 && targetInstance == nil {
+		if err := checkManifestDigestAllowed(options.AllowedManifestDigests, manifestList); err != nil {
+			return nil, "", "", err
+		}
 	}
 	updatedList := originalList.Clone()
 
-	sigs, err := c.sourceSignatures(ctx, unparsedToplevel, options,
+	sigs, err := c.sourceSignatures(ctx, unparsedList, options,
 		"Getting image list signatures",
 		"Checking if image list destination supports signatures")
 	if err != nil {
-		return nil, err
+		return nil, "", "", err
 	}
 
 	// If the destination is a digested reference, make a note of that, determine what digest value we're
 	// expecting, and check that the source manifest matches it.
 	destIsDigestedReference := false
-	if named := c.dest.Reference().DockerReference(); named != nil {
+	if named := c.dest.Reference().DockerReference(); named != nil && targetInstance == nil {
 		if digested, ok := named.(reference.Digested); ok {
 			destIsDigestedReference = true
 			matches, err := manifest.MatchesDigest(manifestList, digested.Digest())
 			if err != nil {
-				return nil, fmt.Errorf("computing digest of source image's manifest: %w", err)
+				return nil, "", "", fmt.Errorf("computing digest of source image's manifest: %w", err)
 			}
 			if !matches {
-				return nil, errors.New("Digest of source image's manifest would not match destination reference")
+				return nil, "", "", errors.New("Digest of source image's manifest would not match destination reference")
 			}
 		}
 	}
@@ -481,12 +1032,19 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 	if len(sigs) > 0 {
 		cannotModifyManifestListReason = "Would invalidate signatures"
 	}
+	if options.CopyReferrers {
+		cannotModifyManifestListReason = "Would invalidate referrers"
+	}
 	if destIsDigestedReference {
 		cannotModifyManifestListReason = "Destination specifies a digest"
 	}
 	if options.PreserveDigests {
 		cannotModifyManifestListReason = "Instructed to preserve digests"
 	}
+	listDigestAlgorithm := digest.Canonical
+	if cannotModifyManifestListReason == "" {
+		listDigestAlgorithm = c.digestAlgorithm
+	}
 
 	// Determine if we'll need to convert the manifest list to a different format.
 	forceListMIMEType := options.ForceManifestMIMEType
@@ -496,28 +1054,47 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 	case imgspecv1.MediaTypeImageManifest:
 		forceListMIMEType = imgspecv1.MediaTypeImageIndex
 	}
+	if c.overrideListPlatforms && c.overridesPlatform() && cannotModifyManifestListReason != "" {
+		return nil, "", "", fmt.Errorf("Overriding the platforms of instances would change the manifest list, which we cannot do: %q", cannotModifyManifestListReason)
+	}
+	// Provenance annotations are only added to the top-level list.
+	addProvenanceAnnotations := len(c.provenanceAnnotations) != 0 && targetInstance == nil
+	if addProvenanceAnnotations {
+		if cannotModifyManifestListReason != "" {
+			return nil, "", "", fmt.Errorf("Adding provenance annotations would change the manifest list, which we cannot do: %q", cannotModifyManifestListReason)
+		}
+		// Only OCI indexes support annotations.
+		if forceListMIMEType != "" && forceListMIMEType != imgspecv1.MediaTypeImageIndex {
+			return nil, "", "", fmt.Errorf("Adding provenance annotations requires an OCI image index, but manifest list type %q was requested", forceListMIMEType)
+		}
+		forceListMIMEType = imgspecv1.MediaTypeImageIndex
+	}
 	selectedListType, otherManifestMIMETypeCandidates, err := c.determineListConversion(manifestType, c.dest.SupportedManifestMIMETypes(), forceListMIMEType)
 	if err != nil {
-		return nil, fmt.Errorf("determining manifest list type to write to destination: %w", err)
+		return nil, "", "", fmt.Errorf("determining manifest list type to write to destination: %w", err)
 	}
 	if selectedListType != originalList.MIMEType() {
 		if cannotModifyManifestListReason != "" {
-			return nil, fmt.Errorf("Manifest list must be converted to type %q to be written to destination, but we cannot modify it: %q", selectedListType, cannotModifyManifestListReason)
+			return nil, "", "", fmt.Errorf("Manifest list must be converted to type %q to be written to destination, but we cannot modify it: %q", selectedListType, cannotModifyManifestListReason)
 		}
 	}
 
 	// Copy each image, or just the ones we want to copy, in turn.
+	// options.Instances selects instances of the top-level list; all instances of a nested list are copied.
+	selectInstances := options.ImageListSelection == CopySpecificImages && targetInstance == nil
 	instanceDigests := updatedList.Instances()
 	imagesToCopy := len(instanceDigests)
-	if options.ImageListSelection == CopySpecificImages {
+	if selectInstances {
 		imagesToCopy = len(options.Instances)
 	}
 	c.Printf("Copying %d of %d images in list\n", imagesToCopy, len(instanceDigests))
 	updates := make([]manifest.ListUpdate, len(instanceDigests))
 	skipped := make(map[int]bool)
-	instancesCopied := 0
+	instancesToCopy := []int{} // Indices into instanceDigests
+	singleImages := []int{}    // The subset of instancesToCopy which are single images, not nested lists
+	nestedLists := []int{}     // The subset of instancesToCopy which are nested lists
 	for i, instanceDigest := range instanceDigests {
-		if options.ImageListSelection == CopySpecificImages {
+		if selectInstances {
 			skip := true
 			for _, instance := range options.Instances {
 				if instance == instanceDigest {
@@ -528,7 +1105,7 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 			if skip {
 				update, err := updatedList.Instance(instanceDigest)
 				if err != nil {
-					return nil, err
+					return nil, "", "", err
 				}
 				logrus.Debugf("Skipping instance %s (%d/%d)", instanceDigest, i+1, len(instanceDigests))
 				// Record the digest/size/type of the manifest that we didn't copy.
@@ -537,33 +1114,101 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 				continue
 			}
 		}
-		logrus.Debugf("Copying instance %s (%d/%d)", instanceDigest, i+1, len(instanceDigests))
-		c.Printf("Copying image %s (%d/%d)\n", instanceDigest, instancesCopied+1, imagesToCopy)
-		unparsedInstance := image.UnparsedInstance(c.rawSource, &instanceDigest)
-		updatedManifest, updatedManifestType, updatedManifestDigest, err := c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, &instanceDigest)
+		instance, err := updatedList.Instance(instanceDigest)
 		if err != nil {
-			return nil, fmt.Errorf("copying image %d/%d from manifest list: %w", instancesCopied+1, imagesToCopy, err)
+			return nil, "", "", err
 		}
-		instancesCopied++
-		// Record the result of a possible conversion here.
-		update := manifest.ListUpdate{
-			Digest:    updatedManifestDigest,
-			Size:      int64(len(updatedManifest)),
-			MediaType: updatedManifestType,
+		instancesToCopy = append(instancesToCopy, i)
+		if manifest.MIMETypeIsMultiImage(instance.MediaType) {
+			nestedLists = append(nestedLists, i)
+		} else {
+			singleImages = append(singleImages, i)
+		}
+	}
+	failures, err := c.copyInstances(ctx, policyContext, options, unparsedToplevel, instanceDigests, singleImages, imagesToCopy, updates)
+	if err != nil {
+		return nil, "", "", err
+	}
+	// Nested lists are copied one at a time, after the images, so that their instances can be copied concurrently
+	// without interfering with other copies.
+	for _, i := range nestedLists {
+		instanceDigest := instanceDigests[i]
+		update, err := c.copyNestedList(ctx, policyContext, options, unparsedToplevel, instanceDigest, depth+1)
+		if err != nil {
+			err = fmt.Errorf("copying manifest list %s (%d/%d) from manifest list: %w", instanceDigest, i+1, len(instanceDigests), err)
+			if !c.skipFailedInstances {
+				return nil, "", "", err
+			}
+			c.Printf("Skipping manifest list %s, copying it failed: %v\n", instanceDigest, err)
+			if failures == nil {
+				failures = map[int]error{}
+			}
+			failures[i] = err
+			continue
 		}
 		updates[i] = update
 	}
+	removed := map[int]bool{} // Indices into instanceDigests
+	if len(failures) != 0 {
+		if len(failures) == len(instancesToCopy) {
+			return nil, "", "", fmt.Errorf("all %d images failed to copy, the first failure: %w", len(failures), failures[instancesToCopy[0]])
+		}
+		if cannotModifyManifestListReason != "" {
+			return nil, "", "", fmt.Errorf("Omitting images which failed to copy would change the manifest list, which we cannot do: %q", cannotModifyManifestListReason)
+		}
+		for _, i := range instancesToCopy {
+			failure, ok := failures[i]
+			if !ok {
+				continue
+			}
+			update, err := updatedList.Instance(instanceDigests[i])
+			if err != nil {
+				return nil, "", "", err
+			}
+			updates[i] = update
+			removed[i] = true
+			c.instanceFailures = append(c.instanceFailures, InstanceCopyError{Digest: instanceDigests[i], Err: failure})
+		}
+	}
 
 	// Now reset the digest/size/types of the manifests in the list to account for any conversions that we made.
 	if err = updatedList.UpdateInstances(updates); err != nil {
-		return nil, fmt.Errorf("updating manifest list: %w", err)
+		return nil, "", "", fmt.Errorf("updating manifest list: %w", err)
+	}
+	// Attestation manifests created by docker buildx refer to their subject images by digest, keep them associated.
+	attestations := attestationSubjects(updatedList)
+	updateAttestationSubjects(updatedList, attestations, instanceDigests, updates)
+	if c.overrideListPlatforms && c.overridesPlatform() {
+		imagesToOverride := []int{} // Attestation manifests keep their "unknown" platform.
+		for _, i := range singleImages {
+			if _, isAttestation := attestations[i]; !isAttestation {
+				imagesToOverride = append(imagesToOverride, i)
+			}
+		}
+		if err := overrideListPlatforms(updatedList, imagesToOverride, c.overridePlatform); err != nil {
+			return nil, "", "", fmt.Errorf("updating manifest list: %w", err)
+		}
 	}
 
 	// Remove skipped images from the manifest if StripManifestList == true
 	if options.SparseImageListAction == StripSparseManifestList {
-		logrus.Debugf("Removing instances %v from manifest list", skipped)
-		if err := removeInstancesFromList(updatedList, skipped); err != nil {
-			return nil, fmt.Errorf("striping manifest list: %w", err)
+		for i := range skipped {
+			removed[i] = true
+		}
+	}
+	// Attestation manifests of removed images would refer to instances which are not in the list.
+	for i, subject := range attestations {
+		for j, instanceDigest := range instanceDigests {
+			if instanceDigest == subject && removed[j] {
+				removed[i] = true
+				break
+			}
+		}
+	}
+	if len(removed) != 0 {
+		logrus.Debugf("Removing instances %v from manifest list", removed)
+		if err := removeInstancesFromList(updatedList, removed); err != nil {
+			return nil, "", "", fmt.Errorf("striping manifest list: %w", err)
 		}
 	}
 
@@ -579,7 +1224,12 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		if thisListType != updatedList.MIMEType() {
 			attemptedList, err = updatedList.ConvertToMIMEType(thisListType)
 			if err != nil {
-				return nil, fmt.Errorf("converting manifest list to list with MIME type %q: %w", thisListType, err)
+				return nil, "", "", fmt.Errorf("converting manifest list to list with MIME type %q: %w", thisListType, err)
+			}
+		}
+		if addProvenanceAnnotations {
+			if err := c.addProvenanceAnnotationsToList(attemptedList); err != nil {
+				return nil, "", "", err
 			}
 		}
 
@@ -587,17 +1237,17 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		// by serializing them both so that we can compare them.
 		attemptedManifestList, err := attemptedList.Serialize()
 		if err != nil {
-			return nil, fmt.Errorf("encoding updated manifest list (%q: %#v): %w", updatedList.MIMEType(), updatedList.Instances(), err)
+			return nil, "", "", fmt.Errorf("encoding updated manifest list (%q: %#v): %w", updatedList.MIMEType(), updatedList.Instances(), err)
 		}
 		originalManifestList, err := originalList.Serialize()
 		if err != nil {
-			return nil, fmt.Errorf("encoding original manifest list for comparison (%q: %#v): %w", originalList.MIMEType(), originalList.Instances(), err)
+			return nil, "", "", fmt.Errorf("encoding original manifest list for comparison (%q: %#v): %w", originalList.MIMEType(), originalList.Instances(), err)
 		}
 
 		// If we can't just use the original value, but we have to change it, flag an error.
 		if !bytes.Equal(attemptedManifestList, originalManifestList) {
 			if cannotModifyManifestListReason != "" {
-				return nil, fmt.Errorf("Manifest list must be converted to type %q to be written to destination, but we cannot modify it: %q", thisListType, cannotModifyManifestListReason)
+				return nil, "", "", fmt.Errorf("Manifest list must be converted to type %q to be written to destination, but we cannot modify it: %q", thisListType, cannotModifyManifestListReason)
 			}
 			logrus.Debugf("Manifest list has been updated")
 		} else {
@@ -606,7 +1256,15 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		}
 
 		// Save the manifest list.
-		err = c.dest.PutManifest(ctx, attemptedManifestList, nil)
+		var instanceDigest *digest.Digest
+		if targetInstance != nil {
+			listDigest, err := manifest.DigestWithAlgorithm(attemptedManifestList, listDigestAlgorithm)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("computing digest of the updated manifest list: %w", err)
+			}
+			instanceDigest = &listDigest
+		}
+		err = c.putManifest(ctx, attemptedManifestList, instanceDigest, listDigestAlgorithm)
 		if err != nil {
 			logrus.Debugf("Upload of manifest list type %s failed: %v", thisListType, err)
 			errs = append(errs, fmt.Sprintf("%s(%v)", thisListType, err))
@@ -614,34 +1272,158 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		}
 		errs = nil
 		manifestList = attemptedManifestList
+		manifestType = thisListType
 		break
 	}
 	if errs != nil {
-		return nil, fmt.Errorf("Uploading manifest list failed, attempted the following formats: %s", strings.Join(errs, ", "))
+		return nil, "", "", fmt.Errorf("Uploading manifest list failed, attempted the following formats: %s", strings.Join(errs, ", "))
 	}
 
 	// Sign the manifest list.
-	if options.SignBy != "" {
-		newSig, err := c.createSignature(manifestList, options.SignBy, options.SignPassphrase, options.SignIdentity)
-		if err != nil {
-			return nil, err
+	newSigs, err := c.createSignatures(manifestList, sigs, options)
+	if err != nil {
+		return nil, "", "", err
+	}
+	sigs = append(sigs, newSigs...)
+
+	manifestListDigest, err := manifest.DigestWithAlgorithm(manifestList, listDigestAlgorithm)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("computing digest of the copied manifest list: %w", err)
+	}
+	var signaturesInstance *digest.Digest
+	if targetInstance != nil {
+		signaturesInstance = &manifestListDigest
+	}
+	c.Printf("Storing list signatures\n")
+	if err := c.dest.PutSignaturesWithFormat(ctx, sigs, signaturesInstance); err != nil {
+		return nil, "", "", fmt.Errorf("writing signatures: %w", err)
+	}
+
+	return manifestList, manifestType, manifestListDigest, nil
+}
+
+// copyNestedList copies the instance with instanceDigest of a list, which is itself a list nested depth levels deep,
+// and returns the data to record for it in the containing list.
+func (c *copier) copyNestedList(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel *image.UnparsedImage,
+	instanceDigest digest.Digest, depth int) (manifest.ListUpdate, error) {
+	if depth >= image.MaxListNestingDepth {
+		return manifest.ListUpdate{}, fmt.Errorf("instance %s is a manifest list nested more than %d levels deep", instanceDigest, image.MaxListNestingDepth)
+	}
+	logrus.Debugf("Copying nested manifest list %s", instanceDigest)
+	unparsedInstance := image.UnparsedInstance(c.rawSource, &instanceDigest)
+	copiedList, copiedListType, copiedListDigest, err := c.copyList(ctx, policyContext, options, unparsedToplevel, unparsedInstance, &instanceDigest, depth)
+	if err != nil {
+		return manifest.ListUpdate{}, err
+	}
+	return manifest.ListUpdate{
+		Digest:    copiedListDigest,
+		Size:      int64(len(copiedList)),
+		MediaType: copiedListType,
+	}, nil
+}
+
+// copyInstances copies the images instanceDigests[i] for all i in instancesToCopy, which are instances of unparsedToplevel,
+// and records the results in updates[i].
+// Up to options.MaxParallelInstanceCopies images are copied concurrently, if c.dest and c.rawSource allow that;
+// after the first failure, no further copies are started, and the error of the first image which failed, in list order, is returned.
+// If c.skipFailedInstances, all images are copied regardless of failures, and the failures are returned, indexed like instanceDigests.
+func (c *copier) copyInstances(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel *image.UnparsedImage,
+	instanceDigests []digest.Digest, instancesToCopy []int, imagesToCopy int, updates []manifest.ListUpdate) (map[int]error, error) {
+	parallelism := int64(1)
+	if options.MaxParallelInstanceCopies > 1 {
+		if c.dest.HasThreadSafePutBlob() && c.rawSource.HasThreadSafeGetBlob() {
+			parallelism = int64(options.MaxParallelInstanceCopies)
+		} else {
+			logrus.Debugf("Copying images one at a time, the source or destination does not support concurrent blob transfers")
 		}
-		sigs = append(sigs, newSig)
 	}
-	if options.SignBySigstorePrivateKeyFile != "" {
-		newSig, err := c.createSigstoreSignature(manifestList, options.SignBySigstorePrivateKeyFile, options.SignSigstorePrivateKeyPassphrase, options.SignIdentity)
+	instancesSemaphore := semaphore.NewWeighted(parallelism)
+	if parallelism > 1 {
+		// Progress bar pools of concurrent copies would overwrite each other's output; print one line per blob instead,
+		// and make sure the lines of concurrent copies are not interleaved.
+		reportWriter, progressOutput := c.reportWriter, c.progressOutput
+		c.reportWriter = &serializedWriter{w: reportWriter}
+		c.progressOutput = io.Discard
+		defer func() {
+			c.reportWriter, c.progressOutput = reportWriter, progressOutput
+		}()
+	}
+
+	errs := make([]error, len(instancesToCopy))
+	var acquireErr error
+	failed := false // Protected by failedLock
+	failedLock := sync.Mutex{}
+	copyGroup := sync.WaitGroup{}
+	copyInstance := func(n int) {
+		defer copyGroup.Done()
+		defer instancesSemaphore.Release(1)
+		i := instancesToCopy[n]
+		instanceDigest := instanceDigests[i]
+		logrus.Debugf("Copying instance %s (%d/%d)", instanceDigest, i+1, len(instanceDigests))
+		c.Printf("Copying image %s (%d/%d)\n", instanceDigest, n+1, imagesToCopy)
+		unparsedInstance := image.UnparsedInstance(c.rawSource, &instanceDigest)
+		updatedManifest, updatedManifestType, updatedManifestDigest, err := c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, &instanceDigest)
 		if err != nil {
-			return nil, err
+			errs[n] = fmt.Errorf("copying image %d/%d from manifest list: %w", n+1, imagesToCopy, err)
+			if c.skipFailedInstances {
+				c.Printf("Skipping image %s, copying it failed: %v\n", instanceDigest, err)
+				return
+			}
+			failedLock.Lock()
+			failed = true
+			failedLock.Unlock()
+			return
+		}
+		// Record the result of a possible conversion here.
+		updates[i] = manifest.ListUpdate{
+			Digest:    updatedManifestDigest,
+			Size:      int64(len(updatedManifest)),
+			MediaType: updatedManifestType,
 		}
-		sigs = append(sigs, newSig)
 	}
+	for n := range instancesToCopy {
+		if err := instancesSemaphore.Acquire(ctx, 1); err != nil {
+			// This can only fail with ctx.Err(), so no need to blame acquiring the semaphore.
+			acquireErr = fmt.Errorf("copying image %d/%d from manifest list: %w", n+1, imagesToCopy, err)
+			break
+		}
+		failedLock.Lock()
+		stop := failed
+		failedLock.Unlock()
+		if stop {
+			instancesSemaphore.Release(1)
+			break
+		}
+		copyGroup.Add(1)
+		go copyInstance(n)
+	}
+	copyGroup.Wait()
 
-	c.Printf("Storing list signatures\n")
-	if err := c.dest.PutSignaturesWithFormat(ctx, sigs, nil); err != nil {
-		return nil, fmt.Errorf("writing signatures: %w", err)
+	if c.skipFailedInstances {
+		if acquireErr != nil {
+			return nil, acquireErr
+		}
+		failures := map[int]error{}
+		for n, err := range errs {
+			if err != nil {
+				failures[instancesToCopy[n]] = err
+			}
+		}
+		return failures, nil
 	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, acquireErr
+}
 
-	return manifestList, nil
+// isRunningImageAllowed calls policyContext.IsRunningImageAllowed, ensuring that the PolicyContext is only used by one image copy at a time.
+func (c *copier) isRunningImageAllowed(ctx context.Context, policyContext *signature.PolicyContext, unparsedImage *image.UnparsedImage) (bool, error) {
+	c.policyContextLock.Lock()
+	defer c.policyContextLock.Unlock()
+	return policyContext.IsRunningImageAllowed(ctx, unparsedImage)
 }
 
 // copyOneImage copies a single (non-manifest-list) image unparsedImage, using policyContext to validate
@@ -661,13 +1443,43 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	// Please keep this policy check BEFORE reading any other information about the image.
 	// (The multiImage check above only matches the MIME type, which we have received anyway.
 	// Actual parsing of anything should be deferred.)
-	if allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsedImage); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
+	if allowed, err := c.isRunningImageAllowed(ctx, policyContext, unparsedImage); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
 		return nil, "", "", fmt.Errorf("Source image rejected: %w", err)
 	}
+	// If targetInstance is set, we are copying a whole list, and copyMultipleImages has already checked the list.
+	if len(options.AllowedManifestDigests) != 0 && targetInstance == nil {
+		manifestBlob, _, err := unparsedImage.Manifest(ctx)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("reading manifest from source image: %w", err)
+		}
+		candidates := [][]byte{manifestBlob}
+		if unparsedImage != unparsedToplevel {
+			manifestList, _, err := unparsedToplevel.Manifest(ctx)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("reading manifest from source image: %w", err)
+			}
+			candidates = append(candidates, manifestList)
+		}
+		if err := checkManifestDigestAllowed(options.AllowedManifestDigests, candidates...); err != nil {
+			return nil, "", "", err
+		}
+	}
 	src, err := image.FromUnparsedImage(ctx, options.SourceCtx, unparsedImage)
 	if err != nil {
 		return nil, "", "", fmt.Errorf("initializing image from source %s: %w", transports.ImageName(c.rawSource.Reference()), err)
 	}
+	if options.MaxLayers > 0 {
+		if layers := len(src.LayerInfos()); layers > options.MaxLayers {
+			return nil, "", "", fmt.Errorf("Source image has %d layers, more than the allowed maximum of %d", layers, options.MaxLayers)
+		}
+	}
+
+	// If targetInstance is set, we are copying a whole list, so the platform of the instance was not requested.
+	if options.VerifyPlatform && targetInstance == nil {
+		if err := verifyImagePlatform(ctx, wantedPlatformContext(options), src); err != nil {
+			return nil, "", "", err
+		}
+	}
 
 	// If the destination is a digested reference, make a note of that, determine what digest value we're
 	// expecting, and check that the source manifest matches it.  If the source manifest doesn't, but it's
@@ -714,6 +1526,9 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	if len(sigs) > 0 {
 		cannotModifyManifestReason = "Would invalidate signatures"
 	}
+	if options.CopyReferrers {
+		cannotModifyManifestReason = "Would invalidate referrers"
+	}
 	if destIsDigestedReference {
 		cannotModifyManifestReason = "Destination specifies a digest"
 	}
@@ -725,9 +1540,18 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 		c:               c,
 		manifestUpdates: &types.ManifestUpdateOptions{InformationOnly: types.ManifestUpdateInformation{Destination: c.dest}},
 		src:             src,
+		rawSource:       c.rawSource,
 		// diffIDsAreNeeded is computed later
 		cannotModifyManifestReason: cannotModifyManifestReason,
 		ociEncryptLayers:           options.OciEncryptLayers,
+		ociEncryptLayerSelector:    options.OciEncryptLayerSelector,
+		addProvenanceAnnotations:   len(c.provenanceAnnotations) != 0 && targetInstance == nil,
+		digestAlgorithm:            digest.Canonical,
+	}
+	if cannotModifyManifestReason == "" {
+		ic.digestAlgorithm = c.digestAlgorithm
+	} else if c.digestAlgorithm != digest.Canonical {
+		logrus.Debugf("Not using digest algorithm %q, because the manifest cannot be modified: %s", c.digestAlgorithm, cannotModifyManifestReason)
 	}
 	// Decide whether we can substitute blobs with semantic equivalents:
 	// - Don’t do that if we can’t modify the manifest at all
@@ -737,20 +1561,53 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	//   We do intend the RecordDigestUncompressedPair calls to only work with reliable data, but at least there’s a risk
 	//   that the compressed version coming from a third party may be designed to attack some other decompressor implementation,
 	//   and we would reuse and sign it.
-	ic.canSubstituteBlobs = ic.cannotModifyManifestReason == "" && options.SignBy == "" && options.SignBySigstorePrivateKeyFile == ""
+	ic.canSubstituteBlobs = ic.cannotModifyManifestReason == "" && options.SignBy == "" && options.SignBySigstorePrivateKeyFile == "" && len(options.Signers) == 0
+
+	if c.decompressLayers && ic.cannotModifyManifestReason != "" {
+		return nil, "", "", fmt.Errorf("Decompressing layers would change the manifest, which we cannot do: %q", ic.cannotModifyManifestReason)
+	}
+	if ic.addProvenanceAnnotations && ic.cannotModifyManifestReason != "" {
+		return nil, "", "", fmt.Errorf("Adding provenance annotations would change the manifest, which we cannot do: %q", ic.cannotModifyManifestReason)
+	}
+	if c.updateImageConfig != nil && ic.cannotModifyManifestReason != "" {
+		return nil, "", "", fmt.Errorf("Updating the image config would change the manifest, which we cannot do: %q", ic.cannotModifyManifestReason)
+	}
+	if c.overridesPlatform() && ic.cannotModifyManifestReason != "" {
+		return nil, "", "", fmt.Errorf("Overriding the image platform would change the manifest, which we cannot do: %q", ic.cannotModifyManifestReason)
+	}
+	if c.stripBuildCache && ic.cannotModifyManifestReason != "" {
+		return nil, "", "", fmt.Errorf("Removing build cache metadata would change the manifest, which we cannot do: %q", ic.cannotModifyManifestReason)
+	}
+	if c.annotateLayers && ic.cannotModifyManifestReason != "" {
+		return nil, "", "", fmt.Errorf("Annotating layers would change the manifest, which we cannot do: %q", ic.cannotModifyManifestReason)
+	}
+	if c.coalescesLayers() {
+		if ic.cannotModifyManifestReason != "" {
+			return nil, "", "", fmt.Errorf("Merging layers would change the manifest, which we cannot do: %q", ic.cannotModifyManifestReason)
+		}
+		cleanup, err := ic.coalesceLayers(ctx, options.DestinationCtx)
+		defer cleanup()
+		if err != nil {
+			return nil, "", "", err
+		}
+	}
 
 	if err := ic.updateEmbeddedDockerReference(); err != nil {
 		return nil, "", "", err
 	}
 
-	destRequiresOciEncryption := (isEncrypted(src) && ic.c.ociDecryptConfig != nil) || options.OciEncryptLayers != nil
+	destRequiresOciEncryption := options.OciEncryptLayers != nil || options.OciEncryptLayerSelector != nil
+	// All encrypted layers are decrypted (or the copy fails), so the destination does not need to support encryption in that case.
+	decryptingLayers := isEncrypted(src) && ic.c.ociDecryptConfig != nil
 
 	manifestConversionPlan, err := determineManifestConversion(determineManifestConversionInputs{
 		srcMIMEType:                    ic.src.ManifestMIMEType,
 		destSupportedManifestMIMETypes: ic.c.dest.SupportedManifestMIMETypes(),
 		forceManifestMIMEType:          options.ForceManifestMIMEType,
 		requiresOCIEncryption:          destRequiresOciEncryption,
+		requiresOCIAnnotations:         ic.addProvenanceAnnotations || c.annotateLayers,
 		cannotModifyManifestReason:     ic.cannotModifyManifestReason,
+		rejectSchema1:                  options.DestinationCtx != nil && options.DestinationCtx.DockerRejectSchema1Manifests,
 	})
 	if err != nil {
 		return nil, "", "", err
@@ -768,11 +1625,12 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 
 	// If enabled, fetch and compare the destination's manifest. And as an optimization skip updating the destination iff equal
 	if options.OptimizeDestinationImageAlreadyExists {
-		shouldUpdateSigs := len(sigs) > 0 || options.SignBy != "" || options.SignBySigstorePrivateKeyFile != "" // TODO: Consider allowing signatures updates only and skipping the image's layers/manifest copy if possible
+		shouldUpdateSigs := len(sigs) > 0 || options.SignBy != "" || options.SignBySigstorePrivateKeyFile != "" || len(options.Signers) > 0 // TODO: Consider allowing signatures updates only and skipping the image's layers/manifest copy if possible
 		noPendingManifestUpdates := ic.noPendingManifestUpdates()
 
-		logrus.Debugf("Checking if we can skip copying: has signatures=%t, OCI encryption=%t, no manifest updates=%t", shouldUpdateSigs, destRequiresOciEncryption, noPendingManifestUpdates)
-		if !shouldUpdateSigs && !destRequiresOciEncryption && noPendingManifestUpdates {
+		logrus.Debugf("Checking if we can skip copying: has signatures=%t, OCI encryption=%t, decrypting layers=%t, decompressing layers=%t, provenance annotations=%t, layer annotations=%t, updating config=%t, overriding platform=%t, stripping build cache=%t, merging layers=%t, deduplicating layers=%t, digest algorithm=%q, no manifest updates=%t",
+			shouldUpdateSigs, destRequiresOciEncryption, decryptingLayers, c.decompressLayers, ic.addProvenanceAnnotations, c.annotateLayers, c.updateImageConfig != nil, c.overridesPlatform(), c.stripBuildCache, c.coalescesLayers(), c.deduplicateLayers, ic.digestAlgorithm, noPendingManifestUpdates)
+		if !shouldUpdateSigs && !destRequiresOciEncryption && !decryptingLayers && !c.decompressLayers && !ic.addProvenanceAnnotations && !c.annotateLayers && c.updateImageConfig == nil && !c.overridesPlatform() && !c.stripBuildCache && !c.coalescesLayers() && !c.deduplicateLayers && ic.digestAlgorithm == digest.Canonical && noPendingManifestUpdates {
 			isSrcDestManifestEqual, retManifest, retManifestType, retManifestDigest, err := compareImageDestinationManifestEqual(ctx, options, src, targetInstance, c.dest)
 			if err != nil {
 				logrus.Warnf("Failed to compare destination image manifest: %v", err)
@@ -795,6 +1653,11 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	// without actually trying to upload something and getting a types.ManifestTypeRejectedError.
 	// So, try the preferred manifest MIME type with possibly-updated blob digests, media types, and sizes if
 	// we're altering how they're compressed.  If the process succeeds, fine…
+	//
+	// Only blob transfers of concurrent instance copies (see copyInstances) may overlap; PutManifest, PutSignatures
+	// and the signers are not necessarily safe for concurrent use.
+	c.destinationWriteLock.Lock()
+	defer c.destinationWriteLock.Unlock()
 	manifestBytes, retManifestDigest, err := ic.copyUpdatedConfigAndManifest(ctx, targetInstance)
 	retManifestType = manifestConversionPlan.preferredMIMEType
 	if err != nil {
@@ -848,20 +1711,11 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 		targetInstance = &retManifestDigest
 	}
 
-	if options.SignBy != "" {
-		newSig, err := c.createSignature(manifestBytes, options.SignBy, options.SignPassphrase, options.SignIdentity)
-		if err != nil {
-			return nil, "", "", err
-		}
-		sigs = append(sigs, newSig)
-	}
-	if options.SignBySigstorePrivateKeyFile != "" {
-		newSig, err := c.createSigstoreSignature(manifestBytes, options.SignBySigstorePrivateKeyFile, options.SignSigstorePrivateKeyPassphrase, options.SignIdentity)
-		if err != nil {
-			return nil, "", "", err
-		}
-		sigs = append(sigs, newSig)
+	newSigs, err := c.createSignatures(manifestBytes, sigs, options)
+	if err != nil {
+		return nil, "", "", err
 	}
+	sigs = append(sigs, newSigs...)
 
 	c.Printf("Storing signatures\n")
 	if err := c.dest.PutSignaturesWithFormat(ctx, sigs, targetInstance); err != nil {
@@ -880,38 +1734,70 @@ func (c *copier) Printf(format string, a ...interface{}) {
 	fmt.Fprintf(c.reportWriter, format, a...)
 }
 
+// serializedWriter is an io.Writer which forwards to w, allowing concurrent calls to Write.
+type serializedWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (w *serializedWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.w.Write(p)
+}
+
 // checkImageDestinationForCurrentRuntime enforces dest.MustMatchRuntimeOS, if necessary.
 func checkImageDestinationForCurrentRuntime(ctx context.Context, sys *types.SystemContext, src types.Image, dest types.ImageDestination) error {
 	if dest.MustMatchRuntimeOS() {
-		c, err := src.OCIConfig(ctx)
+		mismatch, err := imagePlatformMismatch(ctx, sys, src)
 		if err != nil {
-			return fmt.Errorf("parsing image configuration: %w", err)
+			return err
 		}
-		wantedPlatforms, err := platform.WantedPlatforms(sys)
-		if err != nil {
-			return fmt.Errorf("getting current platform information %#v: %w", sys, err)
+		if mismatch != "" {
+			logrus.Infof("Image operating system mismatch: %s", mismatch)
 		}
+	}
+	return nil
+}
 
-		options := newOrderedSet()
-		match := false
-		for _, wantedPlatform := range wantedPlatforms {
-			// Waiting for https://github.com/opencontainers/image-spec/pull/777 :
-			// This currently can’t use image.MatchesPlatform because we don’t know what to use
-			// for image.Variant.
-			if wantedPlatform.OS == c.OS && wantedPlatform.Architecture == c.Architecture {
-				match = true
-				break
-			}
-			options.append(fmt.Sprintf("%s+%s", wantedPlatform.OS, wantedPlatform.Architecture))
-		}
-		if !match {
-			logrus.Infof("Image operating system mismatch: image uses OS %q+architecture %q, expecting one of %q",
-				c.OS, c.Architecture, strings.Join(options.list, ", "))
-		}
+// verifyImagePlatform fails unless the OS and architecture in the config of src match a platform wanted per sys.
+func verifyImagePlatform(ctx context.Context, sys *types.SystemContext, src types.Image) error {
+	mismatch, err := imagePlatformMismatch(ctx, sys, src)
+	if err != nil {
+		return err
+	}
+	if mismatch != "" {
+		return fmt.Errorf("Image platform mismatch: %s", mismatch)
 	}
 	return nil
 }
 
+// imagePlatformMismatch returns a description of how the OS and architecture in the config of src differ
+// from the platforms wanted per sys, or "" if they match one of them.
+func imagePlatformMismatch(ctx context.Context, sys *types.SystemContext, src types.Image) (string, error) {
+	c, err := src.OCIConfig(ctx)
+	if err != nil {
+		return "", fmt.Errorf("parsing image configuration: %w", err)
+	}
+	wantedPlatforms, err := platform.WantedPlatforms(sys)
+	if err != nil {
+		return "", fmt.Errorf("getting current platform information %#v: %w", sys, err)
+	}
+
+	options := newOrderedSet()
+	for _, wantedPlatform := range wantedPlatforms {
+		// Waiting for https://github.com/opencontainers/image-spec/pull/777 :
+		// This currently can’t use image.MatchesPlatform because we don’t know what to use
+		// for image.Variant.
+		if wantedPlatform.OS == c.OS && wantedPlatform.Architecture == c.Architecture {
+			return "", nil
+		}
+		options.append(fmt.Sprintf("%s+%s", wantedPlatform.OS, wantedPlatform.Architecture))
+	}
+	return fmt.Sprintf("image uses OS %q+architecture %q, expecting one of %q",
+		c.OS, c.Architecture, strings.Join(options.list, ", ")), nil
+}
+
 // updateEmbeddedDockerReference handles the Docker reference embedded in Docker schema1 manifests.
 func (ic *imageCopier) updateEmbeddedDockerReference() error {
 	if ic.c.dest.IgnoresEmbeddedDockerReference() {
@@ -945,7 +1831,7 @@ func isTTY(w io.Writer) bool {
 	return false
 }
 
-// copyLayers copies layers from ic.src/ic.c.rawSource to dest, using and updating ic.manifestUpdates if necessary and ic.cannotModifyManifestReason == "".
+// copyLayers copies layers from ic.src/ic.rawSource to dest, using and updating ic.manifestUpdates if necessary and ic.cannotModifyManifestReason == "".
 func (ic *imageCopier) copyLayers(ctx context.Context) error {
 	srcInfos := ic.src.LayerInfos()
 	numLayers := len(srcInfos)
@@ -963,6 +1849,23 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 		srcInfosUpdated = true
 	}
 
+	if ic.c.verifyDiffIDs {
+		expected, err := ic.configDiffIDs(ctx, numLayers)
+		if err != nil {
+			return err
+		}
+		ic.expectedDiffIDs = expected
+	}
+	if ic.canSubstituteBlobs && !srcInfosUpdated && !internalManifest.IsSchema1MIMEType(ic.src.ManifestMIMEType) {
+		// The blob info cache does not necessarily know the DiffIDs of the source layers, e.g. if the source was never pulled.
+		// In that case the DiffIDs from the config still allow reusing blobs with the same uncompressed contents.
+		if diffIDs, err := ic.configDiffIDs(ctx, numLayers); err != nil {
+			logrus.Debugf("Not using the config DiffIDs to reuse layers: %v", err)
+		} else {
+			ic.reuseDiffIDs = diffIDs
+		}
+	}
+
 	type copyLayerData struct {
 		destInfo types.BlobInfo
 		diffID   digest.Digest
@@ -989,7 +1892,7 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 			// DiffIDs are, currently, needed only when converting from schema1.
 			// In which case src.LayerInfos will not have URLs because schema1
 			// does not support them.
-			if ic.diffIDsAreNeeded {
+			if ic.diffIDsAreNeeded || ic.expectedDiffIDs != nil || ic.c.annotateLayers {
 				cld.err = errors.New("getting DiffID for foreign layers is unimplemented")
 			} else {
 				cld.destInfo = srcLayer
@@ -1018,6 +1921,18 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 			}
 		}
 	}
+	if ic.ociEncryptLayerSelector != nil {
+		ic.c.ociEncryptLayerSelectorLock.Lock()
+		for i, srcLayer := range srcInfos {
+			encLayerBitmap[i] = ic.ociEncryptLayerSelector(i, srcLayer)
+		}
+		ic.c.ociEncryptLayerSelectorLock.Unlock()
+	}
+
+	duplicateOf := map[int]int{} // Layers which are not copied => the earlier layer whose copy is used instead
+	if ic.c.deduplicateLayers {
+		duplicateOf, ic.deduplicatedDiffIDs = ic.duplicateLayers(ctx, srcInfos, srcInfosUpdated, encLayerBitmap)
+	}
 
 	if err := func() error { // A scope for defer
 		progressPool := ic.c.newProgressPool()
@@ -1027,6 +1942,10 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 		defer copyGroup.Wait()
 
 		for i, srcLayer := range srcInfos {
+			if original, ok := duplicateOf[i]; ok {
+				logrus.Debugf("Layer %d (%s) is a duplicate of layer %d, not copying it again", i, srcLayer.Digest, original)
+				continue
+			}
 			err = ic.c.concurrentBlobCopiesSemaphore.Acquire(ctx, 1)
 			if err != nil {
 				// This can only fail with ctx.Err(), so no need to blame acquiring the semaphore.
@@ -1042,6 +1961,9 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 		return err
 	}
 
+	for i, original := range duplicateOf {
+		data[i] = data[original]
+	}
 	destInfos := make([]types.BlobInfo, numLayers)
 	diffIDs := make([]digest.Digest, numLayers)
 	for i, cld := range data {
@@ -1050,6 +1972,18 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 		}
 		destInfos[i] = cld.destInfo
 		diffIDs[i] = cld.diffID
+		if ic.c.annotateLayers {
+			if cld.diffID == "" {
+				return fmt.Errorf("Internal error: DiffID of layer %s was not computed", cld.destInfo.Digest)
+			}
+			annotations := make(map[string]string, len(cld.destInfo.Annotations)+2)
+			for k, v := range cld.destInfo.Annotations {
+				annotations[k] = v
+			}
+			annotations[LayerDiffIDAnnotation] = cld.diffID.String()
+			annotations[LayerSizeAnnotation] = strconv.FormatInt(cld.destInfo.Size, 10)
+			destInfos[i].Annotations = annotations
+		}
 	}
 
 	// WARNING: If you are adding new reasons to change ic.manifestUpdates, also update the
@@ -1058,12 +1992,72 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 	if ic.diffIDsAreNeeded {
 		ic.manifestUpdates.InformationOnly.LayerDiffIDs = diffIDs
 	}
-	if srcInfosUpdated || layerDigestsDiffer(srcInfos, destInfos) {
+	if srcInfosUpdated || layerDigestsDiffer(srcInfos, destInfos) || ic.c.annotateLayers {
 		ic.manifestUpdates.LayerInfos = destInfos
 	}
 	return nil
 }
 
+// duplicateLayers returns a map from indices of the layers in srcInfos which don't need to be copied, per Options.DeduplicateLayers,
+// to the index of the earlier layer with the same contents whose copy can be used instead.
+// Layers with the same blob digest are known to have the same contents. Layers with different blob digests are deduplicated
+// based on the DiffIDs in the config, which are not verified by the source; so it also returns the DiffIDs which the copied layers
+// must be verified against, by layer index.
+func (ic *imageCopier) duplicateLayers(ctx context.Context, srcInfos []types.BlobInfo, srcInfosUpdated bool, encLayerBitmap map[int]bool) (map[int]int, map[int]digest.Digest) {
+	// Layers with different digests can only be deduplicated if the manifest can be updated to refer to the same blob.
+	var diffIDs []digest.Digest
+	if ic.cannotModifyManifestReason == "" && !srcInfosUpdated && !internalManifest.IsSchema1MIMEType(ic.src.ManifestMIMEType) {
+		if d, err := ic.configDiffIDs(ctx, len(srcInfos)); err != nil {
+			logrus.Debugf("Not using the config DiffIDs to deduplicate layers: %v", err)
+		} else {
+			diffIDs = d
+		}
+	}
+
+	type layerKey struct {
+		digest  digest.Digest // The digest of the layer blob, or its DiffID
+		encrypt bool
+	}
+	byDigest := map[layerKey]int{}
+	byDiffID := map[layerKey]int{}
+	res := map[int]int{}
+	verifiedDiffIDs := map[int]digest.Digest{}
+	for i, srcLayer := range srcInfos {
+		key := layerKey{digest: srcLayer.Digest, encrypt: encLayerBitmap[i]}
+		if original, ok := byDigest[key]; ok {
+			res[i] = original
+			continue
+		}
+		byDigest[key] = i
+		// Foreign layers might not be copied at all, and encrypted layers differ in their encryption metadata.
+		if diffIDs != nil && len(srcLayer.URLs) == 0 && !isOciEncrypted(srcLayer.MediaType) {
+			key := layerKey{digest: diffIDs[i], encrypt: encLayerBitmap[i]}
+			if original, ok := byDiffID[key]; ok {
+				res[i] = original
+				verifiedDiffIDs[original] = diffIDs[i]
+				continue
+			}
+			byDiffID[key] = i
+		}
+	}
+	return res, verifiedDiffIDs
+}
+
+// configDiffIDs returns the DiffIDs recorded in the config of ic.src, which must list exactly numLayers of them.
+func (ic *imageCopier) configDiffIDs(ctx context.Context, numLayers int) ([]digest.Digest, error) {
+	if internalManifest.IsSchema1MIMEType(ic.src.ManifestMIMEType) {
+		return nil, errors.New("Docker schema1 images don't record layer DiffIDs")
+	}
+	config, err := ic.src.OCIConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading the layer DiffIDs from the image config: %w", err)
+	}
+	if len(config.RootFS.DiffIDs) != numLayers {
+		return nil, fmt.Errorf("the image config lists %d layer DiffIDs, but the manifest has %d layers", len(config.RootFS.DiffIDs), numLayers)
+	}
+	return config.RootFS.DiffIDs, nil
+}
+
 // layerDigestsDiffer returns true iff the digests in a and b differ (ignoring sizes and possible other fields)
 func layerDigestsDiffer(a, b []types.BlobInfo) bool {
 	if len(a) != len(b) {
@@ -1101,30 +2095,113 @@ func (ic *imageCopier) copyUpdatedConfigAndManifest(ctx context.Context, instanc
 		}
 		pendingImage = pi
 	}
-	man, _, err := pendingImage.Manifest(ctx)
+	if ic.c.updateImageConfig != nil {
+		pi, err := ic.c.updatedImageConfig(ctx, pendingImage, ic.digestAlgorithm)
+		if err != nil {
+			return nil, "", err
+		}
+		pendingImage = pi
+	}
+	if ic.c.overridesPlatform() {
+		pi, err := updatedConfigBlobImage(ctx, pendingImage, ic.digestAlgorithm, ic.c.overridePlatformInConfigBlob)
+		if err != nil {
+			return nil, "", err
+		}
+		pendingImage = pi
+	}
+	if ic.c.stripBuildCache {
+		pi, err := strippedBuildCacheImage(ctx, pendingImage, ic.digestAlgorithm)
+		if err != nil {
+			return nil, "", err
+		}
+		pendingImage = pi
+	}
+	if configDigest := pendingImage.ConfigInfo().Digest; ic.digestAlgorithm != digest.Canonical && configDigest != "" && configDigest.Algorithm() != ic.digestAlgorithm {
+		pi, err := updatedConfigBlobImage(ctx, pendingImage, ic.digestAlgorithm, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		pendingImage = pi
+	}
+	man, manType, err := pendingImage.Manifest(ctx)
 	if err != nil {
 		return nil, "", fmt.Errorf("reading manifest: %w", err)
 	}
+	if ic.c.stripBuildCache {
+		man, err = stripBuildCacheAnnotationsFromManifest(man, manType)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if ic.addProvenanceAnnotations {
+		man, err = ic.c.addProvenanceAnnotationsToManifest(man, manType)
+		if err != nil {
+			return nil, "", err
+		}
+	}
 
 	if err := ic.copyConfig(ctx, pendingImage); err != nil {
 		return nil, "", err
 	}
+	if ic.c.verifyBlobsBeforeManifest {
+		if err := ic.verifyBlobsExist(ctx, man, manType); err != nil {
+			return nil, "", err
+		}
+	}
 
 	ic.c.Printf("Writing manifest to image destination\n")
-	manifestDigest, err := manifest.Digest(man)
+	manifestDigest, err := manifest.DigestWithAlgorithm(man, ic.digestAlgorithm)
 	if err != nil {
 		return nil, "", err
 	}
 	if instanceDigest != nil {
 		instanceDigest = &manifestDigest
 	}
-	if err := ic.c.dest.PutManifest(ctx, man, instanceDigest); err != nil {
+	if err := ic.c.putManifest(ctx, man, instanceDigest, ic.digestAlgorithm); err != nil {
 		logrus.Debugf("Error %v while writing manifest %q", err, string(man))
 		return nil, "", fmt.Errorf("writing manifest: %w", err)
 	}
 	return man, manifestDigest, nil
 }
 
+// verifyBlobsExist returns an error if any blob referenced by man, except for foreign layers, does not exist at ic.c.dest.
+func (ic *imageCopier) verifyBlobsExist(ctx context.Context, man []byte, manType string) error {
+	m, err := manifest.FromBlob(man, manType)
+	if err != nil {
+		return fmt.Errorf("parsing manifest to verify its blobs: %w", err)
+	}
+	blobs := []types.BlobInfo{}
+	if configInfo := m.ConfigInfo(); configInfo.Digest != "" {
+		blobs = append(blobs, configInfo)
+	}
+	for _, layer := range m.LayerInfos() {
+		if len(layer.URLs) != 0 {
+			continue // A foreign layer which was not copied, see copyLayers.
+		}
+		blobs = append(blobs, layer.BlobInfo)
+	}
+	seen := map[digest.Digest]struct{}{}
+	for _, info := range blobs {
+		if _, ok := seen[info.Digest]; ok {
+			continue
+		}
+		seen[info.Digest] = struct{}{}
+		// Don't use a blob info cache, so that this only checks whether the blob exists, without e.g. mounting it from another repository.
+		exists, _, err := ic.c.dest.TryReusingBlobWithOptions(ctx, info, private.TryReusingBlobOptions{
+			Cache:         none.NoCache,
+			CanSubstitute: false,
+			SrcRef:        ic.c.rawSource.Reference().DockerReference(),
+		})
+		if err != nil {
+			return fmt.Errorf("checking whether blob %s exists at the destination: %w", info.Digest, err)
+		}
+		if !exists {
+			return fmt.Errorf("blob %s does not exist at the destination after copying it, not writing the manifest", info.Digest)
+		}
+	}
+	return nil
+}
+
 // copyConfig copies config.json, if any, from src to dest.
 func (ic *imageCopier) copyConfig(ctx context.Context, src types.Image) error {
 	srcInfo := src.ConfigInfo()
@@ -1199,12 +2276,33 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 	ic.c.printCopyInfo("blob", srcInfo)
 
 	cachedDiffID := ic.c.blobInfoCache.UncompressedDigest(srcInfo.Digest) // May be ""
-	diffIDIsNeeded := ic.diffIDsAreNeeded && cachedDiffID == ""
+	diffIDIsNeeded := (ic.diffIDsAreNeeded || ic.c.annotateLayers) && cachedDiffID == ""
+	var expectedDiffID digest.Digest // = "", meaning the DiffID is not verified
+	if ic.expectedDiffIDs != nil {
+		if isOciEncrypted(srcInfo.MediaType) && ic.c.ociDecryptConfig == nil {
+			return types.BlobInfo{}, "", fmt.Errorf("verifying the DiffID of encrypted layer %s requires decrypting it", srcInfo.Digest)
+		}
+		// Don’t trust the cache, the point is to verify the data we actually copy.
+		expectedDiffID = ic.expectedDiffIDs[layerIndex]
+		diffIDIsNeeded = true
+	}
+	if d, ok := ic.deduplicatedDiffIDs[layerIndex]; ok {
+		// Other layers are replaced by this one based on the DiffID in the config, so verify that it really has that DiffID.
+		expectedDiffID = d
+		diffIDIsNeeded = true
+	}
 	// When encrypting to decrypting, only use the simple code path. We might be able to optimize more
 	// (e.g. if we know the DiffID of an encrypted compressed layer, it might not be necessary to pull, decrypt and decompress again),
 	// but it’s not trivially safe to do such things, so until someone takes the effort to make a comprehensive argument, let’s not.
 	encryptingOrDecrypting := toEncrypt || (isOciEncrypted(srcInfo.MediaType) && ic.c.ociDecryptConfig != nil)
-	canAvoidProcessingCompleteLayer := !diffIDIsNeeded && !encryptingOrDecrypting
+	// If we are asked to decompress all layers, a reused (or partially pulled) blob could still be compressed;
+	// don’t take that risk for layers which are not known to be uncompressed already.
+	// (srcInfo.CompressionAlgorithm == nil does not imply that: it is not set for e.g. schema1 or foreign layers.)
+	forcedDecompression := ic.c.decompressLayers && (srcInfo.CompressionAlgorithm != nil || !isUncompressedLayerMIMEType(srcInfo.MediaType))
+	// A reused (or partially pulled) blob would keep its original digest, so a blob with a digest using a different
+	// algorithm than requested must be copied.
+	changingDigestAlgorithm := ic.digestAlgorithm != digest.Canonical && srcInfo.Digest.Algorithm() != ic.digestAlgorithm
+	canAvoidProcessingCompleteLayer := !diffIDIsNeeded && !encryptingOrDecrypting && !forcedDecompression && !changingDigestAlgorithm
 
 	// Don’t read the layer from the source if we already have the blob, and optimizations are acceptable.
 	if canAvoidProcessingCompleteLayer {
@@ -1228,6 +2326,33 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 		if err != nil {
 			return types.BlobInfo{}, "", fmt.Errorf("trying to reuse blob %s at destination: %w", srcInfo.Digest, err)
 		}
+		reusedDiffID := cachedDiffID
+		if !reused && canSubstitute && cachedDiffID == "" && ic.reuseDiffIDs != nil && ic.reuseDiffIDs[layerIndex] != srcInfo.Digest {
+			// The cache does not know the uncompressed digest of srcInfo, so it can’t find its variants by itself; look for
+			// blobs with the DiffID from the config instead. Even if the DiffID did not actually match srcInfo, this would
+			// create a consistent image: the config is not modified, and it correctly describes the reused blob.
+			configDiffID := ic.reuseDiffIDs[layerIndex]
+			logrus.Debugf("Checking if we can reuse a blob with DiffID %s instead of %s", configDiffID, srcInfo.Digest)
+			reused, blobInfo, err = ic.c.dest.TryReusingBlobWithOptions(ctx, types.BlobInfo{
+				Digest:    configDiffID,
+				Size:      -1,
+				MediaType: srcInfo.MediaType,
+			}, private.TryReusingBlobOptions{
+				Cache:         ic.c.blobInfoCache,
+				CanSubstitute: true,
+				EmptyLayer:    emptyLayer,
+				LayerIndex:    &layerIndex,
+				SrcRef:        srcRef,
+			})
+			if err != nil {
+				return types.BlobInfo{}, "", fmt.Errorf("trying to reuse blob %s at destination: %w", configDiffID, err)
+			}
+			if reused && blobInfo.Digest == configDiffID && blobInfo.CompressionAlgorithm == nil {
+				// The uncompressed blob itself was found; the code below would incorrectly use the compression of srcInfo.
+				blobInfo.CompressionOperation = types.Decompress
+			}
+			reusedDiffID = configDiffID
+		}
 		if reused {
 			logrus.Debugf("Skipping blob %s (already present):", srcInfo.Digest)
 			func() { // A scope for defer
@@ -1256,7 +2381,7 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 				blobInfo.CompressionOperation = srcInfo.CompressionOperation
 				blobInfo.CompressionAlgorithm = srcInfo.CompressionAlgorithm
 			}
-			return blobInfo, cachedDiffID, nil
+			return blobInfo, reusedDiffID, nil
 		}
 	}
 
@@ -1264,7 +2389,7 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 	// of the source file are not known yet and must be fetched.
 	// Attempt a partial only when the source allows to retrieve a blob partially and
 	// the destination has support for it.
-	if canAvoidProcessingCompleteLayer && ic.c.rawSource.SupportsGetBlobAt() && ic.c.dest.SupportsPutBlobPartial() {
+	if canAvoidProcessingCompleteLayer && ic.rawSource.SupportsGetBlobAt() && ic.c.dest.SupportsPutBlobPartial() {
 		if reused, blobInfo := func() (bool, types.BlobInfo) { // A scope for defer
 			bar := ic.c.createProgressBar(pool, true, srcInfo, "blob", "done")
 			hideProgressBar := true
@@ -1273,7 +2398,7 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 			}()
 
 			proxy := blobChunkAccessorProxy{
-				wrapped: ic.c.rawSource,
+				wrapped: ic.rawSource,
 				bar:     bar,
 			}
 			info, err := ic.c.dest.PutBlobPartial(ctx, &proxy, srcInfo, ic.c.blobInfoCache)
@@ -1298,11 +2423,13 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 		bar := ic.c.createProgressBar(pool, false, srcInfo, "blob", "done")
 		defer bar.Abort(false)
 
-		srcStream, srcBlobSize, err := ic.c.rawSource.GetBlob(ctx, srcInfo, ic.c.blobInfoCache)
+		srcStream, srcBlobSize, err := ic.rawSource.GetBlob(ctx, srcInfo, ic.c.blobInfoCache)
 		if err != nil {
 			return types.BlobInfo{}, "", fmt.Errorf("reading blob %s: %w", srcInfo.Digest, err)
 		}
+		srcStream, stopClosingOnCancellation := closeOnContextCancellation(ctx, srcStream)
 		defer srcStream.Close()
+		defer stopClosingOnCancellation()
 
 		blobInfo, diffIDChan, err := ic.copyLayerFromStream(ctx, srcStream, types.BlobInfo{Digest: srcInfo.Digest, Size: srcBlobSize, MediaType: srcInfo.MediaType, Annotations: srcInfo.Annotations}, diffIDIsNeeded, toEncrypt, bar, layerIndex, emptyLayer)
 		if err != nil {
@@ -1319,6 +2446,10 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 					return types.BlobInfo{}, "", fmt.Errorf("computing layer DiffID: %w", diffIDResult.err)
 				}
 				logrus.Debugf("Computed DiffID %s for layer %s", diffIDResult.digest, srcInfo.Digest)
+				if expectedDiffID != "" && diffIDResult.digest != expectedDiffID {
+					return types.BlobInfo{}, "", fmt.Errorf("layer %s: computed DiffID %s does not match the DiffID %s in the image config",
+						srcInfo.Digest, diffIDResult.digest, expectedDiffID)
+				}
 				// Don’t record any associations that involve encrypted data. This is a bit crude,
 				// some blob substitutions (replacing pulls of encrypted data with local reuse of known decryption outcomes)
 				// might be safe, but it’s not trivially obvious, so let’s be conservative for now.
@@ -1,16 +1,45 @@
 package copy
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/archive"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/internal/testing/testimage"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	pkgblobinfocache "github.com/containers/image/v5/pkg/blobinfocache"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
 	"github.com/containers/image/v5/pkg/compression"
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -77,3 +106,2072 @@ func TestComputeDiffID(t *testing.T) {
 	_, err = computeDiffID(reader, nil)
 	assert.Error(t, err)
 }
+
+// testImageLayer describes a layer created by newTestDirImage.
+type testImageLayer struct {
+	digest digest.Digest // Digest of the (gzip-compressed) blob
+	diffID digest.Digest
+}
+
+// newTestDirImage creates a schema2 image with gzip-compressed layers with the specified contents in a new dir: directory,
+// and returns a reference to it, the layer data, and the digest of its config.
+func newTestDirImage(t *testing.T, layerContents ...string) (types.ImageReference, []testImageLayer, digest.Digest) {
+	ref, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	manBlob, layers, configDigest := putTestImageBlobs(t, dest, "amd64", layerContents...)
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	return ref, layers, configDigest
+}
+
+// putTestImageBlobs writes the config and gzip-compressed layers with the specified contents of a schema2 image
+// for architecture to dest, and returns the image’s manifest (which is not written), the layer data, and the digest of its config.
+func putTestImageBlobs(t *testing.T, dest types.ImageDestination, architecture string, layerContents ...string) ([]byte, []testImageLayer, digest.Digest) {
+	img := testimage.Image{
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+		Config:           imgspecv1.Image{Architecture: architecture},
+	}
+	layers := []testImageLayer{}
+	for _, contents := range layerContents {
+		tarBlob := testimage.Tar(t, testimage.File("file", contents))
+		gzipBlob := testimage.Gzip(t, tarBlob)
+		layer := testImageLayer{digest: digest.FromBytes(gzipBlob), diffID: digest.FromBytes(tarBlob)}
+		layers = append(layers, layer)
+		img.Layers = append(img.Layers, testimage.Layer{Blob: gzipBlob, MediaType: manifest.DockerV2Schema2LayerMediaType, DiffID: layer.diffID})
+	}
+	manBlob, configDigest := testimage.PutBlobs(t, dest, img)
+	return manBlob, layers, configDigest
+}
+
+// acceptAnythingPolicyContext returns a *signature.PolicyContext accepting any image.
+func acceptAnythingPolicyContext(t *testing.T) *signature.PolicyContext {
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{
+		Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = policyContext.Destroy() })
+	return policyContext
+}
+
+func TestImageDecompressLayers(t *testing.T) {
+	srcRef, layers, configDigest := newTestDirImage(t, "layer 1", "layer 2")
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{DecompressLayers: true})
+	require.NoError(t, err)
+	man, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	assert.Equal(t, configDigest, man.ConfigDescriptor.Digest)
+	require.Len(t, man.LayersDescriptors, len(layers))
+	for i, layer := range layers {
+		assert.Equal(t, manifest.DockerV2SchemaLayerMediaTypeUncompressed, man.LayersDescriptors[i].MediaType)
+		assert.Equal(t, layer.diffID, man.LayersDescriptors[i].Digest)
+	}
+
+	// The result is usable, and the config still matches the layers.
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	img, err := image.FromSource(context.Background(), nil, src)
+	require.NoError(t, err)
+	config, err := img.OCIConfig(context.Background())
+	require.NoError(t, err)
+	for i, layer := range img.LayerInfos() {
+		assert.Equal(t, config.RootFS.DiffIDs[i], layer.Digest)
+		stream, _, err := src.GetBlob(context.Background(), layer, none.NoCache)
+		require.NoError(t, err)
+		blobDigest, err := digest.Canonical.FromReader(stream)
+		stream.Close()
+		require.NoError(t, err)
+		assert.Equal(t, layer.Digest, blobDigest)
+	}
+
+	// Decompressing is rejected if the manifest must not be modified.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{DecompressLayers: true, PreserveDigests: true})
+	assert.Error(t, err)
+}
+
+func TestImageDecompressLayersWithoutKnownCompression(t *testing.T) {
+	// The compression of a foreign layer is not recorded in srcInfo.CompressionAlgorithm.
+	srcRef, layers, _ := newTestDirImage(t, "foreign layer")
+	manifestPath := filepath.Join(srcRef.StringWithinTransport(), "manifest.json")
+	manBlob, err := os.ReadFile(manifestPath)
+	require.NoError(t, err)
+	srcMan, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	srcMan.LayersDescriptors[0].MediaType = manifest.DockerV2Schema2ForeignLayerMediaTypeGzip
+	manBlob, err = srcMan.Serialize()
+	require.NoError(t, err)
+	err = os.WriteFile(manifestPath, manBlob, 0o644)
+	require.NoError(t, err)
+
+	// Populate the destination with the compressed layer, so that it could be reused.
+	destDir := t.TempDir()
+	destRef, err := layout.NewReference(destDir, "compressed")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, nil)
+	require.NoError(t, err)
+
+	destRef, err = layout.NewReference(destDir, "decompressed")
+	require.NoError(t, err)
+	manBlob, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{DecompressLayers: true})
+	require.NoError(t, err)
+	man, err := manifest.OCI1FromManifest(manBlob)
+	require.NoError(t, err)
+	require.Len(t, man.Layers, 1)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayerNonDistributable, man.Layers[0].MediaType)
+	assert.Equal(t, layers[0].diffID, man.Layers[0].Digest)
+}
+
+// testRegistry is a minimal in-memory registry, able to store blobs and manifests in a single repository.
+type testRegistry struct {
+	mutex     sync.Mutex
+	blobs     map[digest.Digest][]byte
+	uploads   map[string]*bytes.Buffer
+	manifests map[string][]byte // Indexed by tag or digest
+
+	blobRequests map[digest.Digest][]string // Range headers of GET requests for each blob; "" for requests of the complete blob
+
+	supportsReferrers bool                       // If set, indicate support for the referrers API when receiving manifests with a subject
+	droppedBlobs      map[digest.Digest]struct{} // Uploads of these blobs are reported as successful, but the blobs are not stored
+	requestHook       func(r *http.Request)      // If set, called for every request before handling it, without holding mutex
+}
+
+// newTestRegistry returns a testRegistry and a running server for it.
+func newTestRegistry(t *testing.T) (*testRegistry, *httptest.Server) {
+	registry := &testRegistry{
+		blobs:        map[digest.Digest][]byte{},
+		uploads:      map[string]*bytes.Buffer{},
+		manifests:    map[string][]byte{},
+		blobRequests: map[digest.Digest][]string{},
+	}
+	uploadPathRegex := regexp.MustCompile("^/v2/[^:]*/blobs/uploads/(.*)$")
+	blobPathRegex := regexp.MustCompile("^/v2/[^:]*/blobs/(sha256:[0-9a-f]{64})$")
+	manifestPathRegex := regexp.MustCompile("^/v2/[^:]*/manifests/(.+)$")
+	referrersPathRegex := regexp.MustCompile("^/v2/[^:]*/referrers/(sha256:[0-9a-f]{64})$")
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		registry.mutex.Lock()
+		requestHook := registry.requestHook
+		registry.mutex.Unlock()
+		if requestHook != nil {
+			requestHook(r)
+		}
+
+		registry.mutex.Lock()
+		defer registry.mutex.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead && blobPathRegex.MatchString(r.URL.Path):
+			blob, ok := registry.blobs[digest.Digest(blobPathRegex.FindStringSubmatch(r.URL.Path)[1])]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			rw.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && blobPathRegex.MatchString(r.URL.Path):
+			blobDigest := digest.Digest(blobPathRegex.FindStringSubmatch(r.URL.Path)[1])
+			blob, ok := registry.blobs[blobDigest]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			registry.blobRequests[blobDigest] = append(registry.blobRequests[blobDigest], r.Header.Get("Range"))
+			http.ServeContent(rw, r, "", time.Time{}, bytes.NewReader(blob)) // Handles Range requests
+		case r.Method == http.MethodPost && uploadPathRegex.MatchString(r.URL.Path):
+			uploadID := strconv.Itoa(len(registry.uploads))
+			registry.uploads[uploadID] = &bytes.Buffer{}
+			rw.Header().Set("Location", r.URL.Path+uploadID)
+			rw.WriteHeader(http.StatusAccepted)
+		case (r.Method == http.MethodPatch || r.Method == http.MethodPut) && uploadPathRegex.MatchString(r.URL.Path):
+			upload, ok := registry.uploads[uploadPathRegex.FindStringSubmatch(r.URL.Path)[1]]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, err := io.Copy(upload, r.Body)
+			assert.NoError(t, err)
+			if r.Method == http.MethodPatch {
+				rw.Header().Set("Location", r.URL.Path)
+				rw.WriteHeader(http.StatusAccepted)
+				return
+			}
+			blobDigest := digest.Digest(r.URL.Query().Get("digest"))
+			assert.Equal(t, blobDigest, digest.FromBytes(upload.Bytes()))
+			if _, ok := registry.droppedBlobs[blobDigest]; !ok {
+				registry.blobs[blobDigest] = upload.Bytes()
+			}
+			rw.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && manifestPathRegex.MatchString(r.URL.Path):
+			manifestBlob, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			registry.manifests[manifestPathRegex.FindStringSubmatch(r.URL.Path)[1]] = manifestBlob
+			manifestDigest, err := manifest.Digest(manifestBlob) // Like real registries, ignoring schema1 signatures.
+			assert.NoError(t, err)
+			rw.Header().Set("Docker-Content-Digest", manifestDigest.String())
+			var withSubject struct {
+				Subject *imgspecv1.Descriptor `json:"subject"`
+			}
+			if registry.supportsReferrers && json.Unmarshal(manifestBlob, &withSubject) == nil && withSubject.Subject != nil {
+				rw.Header().Set("OCI-Subject", withSubject.Subject.Digest.String())
+			}
+			rw.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet && manifestPathRegex.MatchString(r.URL.Path):
+			manifestBlob, ok := registry.manifests[manifestPathRegex.FindStringSubmatch(r.URL.Path)[1]]
+			if !ok {
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusNotFound)
+				_, err := rw.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+				assert.NoError(t, err)
+				return
+			}
+			rw.Header().Set("Content-Type", manifest.GuessMIMEType(manifestBlob))
+			manifestDigest, err := manifest.Digest(manifestBlob)
+			assert.NoError(t, err)
+			rw.Header().Set("Docker-Content-Digest", manifestDigest.String())
+			rw.WriteHeader(http.StatusOK)
+			_, err = rw.Write(manifestBlob)
+			assert.NoError(t, err)
+		case r.Method == http.MethodGet && referrersPathRegex.MatchString(r.URL.Path):
+			// The referrers API is not supported; clients fall back to the referrers tag schema.
+			rw.WriteHeader(http.StatusNotFound)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return registry, server
+}
+
+// newTestDirManifestList creates a schema2 manifest list with images for architectures (amd64 and arm64 if none are specified)
+// in a new dir: directory, and returns a reference to it.
+func newTestDirManifestList(t *testing.T, architectures ...string) types.ImageReference {
+	if len(architectures) == 0 {
+		architectures = []string{"amd64", "arm64"}
+	}
+	srcRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := srcRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	listInstances := []manifest.Schema2ManifestDescriptor{}
+	for _, arch := range architectures {
+		manBlob, _, _ := putTestImageBlobs(t, dest, arch, "shared layer", "layer for "+arch)
+		manDigest := digest.FromBytes(manBlob)
+		require.NoError(t, dest.PutManifest(context.Background(), manBlob, &manDigest))
+		listInstances = append(listInstances, manifest.Schema2ManifestDescriptor{
+			Schema2Descriptor: manifest.Schema2Descriptor{
+				MediaType: manifest.DockerV2Schema2MediaType,
+				Size:      int64(len(manBlob)),
+				Digest:    manDigest,
+			},
+			Platform: manifest.Schema2PlatformSpec{Architecture: arch, OS: "linux"},
+		})
+	}
+	listBlob, err := manifest.Schema2ListFromComponents(listInstances).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), listBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+
+	return srcRef
+}
+
+func TestImageDockerArchiveManifestListRoundTrip(t *testing.T) {
+	srcRef := newTestDirManifestList(t)
+
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	options := &Options{
+		SourceCtx:          sys,
+		DestinationCtx:     sys,
+		ImageListSelection: CopyAllImages,
+	}
+
+	// Save the list to an archive
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	archiveRef, err := archive.ParseReference(archivePath + ":example.com/list:tag")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), archiveRef, srcRef, options)
+	require.NoError(t, err)
+
+	// Load it into a registry
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	registryRef, err := docker.ParseReference("//" + registryURL.Host + "/list:tag")
+	require.NoError(t, err)
+	archiveRef, err = archive.ParseReference(archivePath)
+	require.NoError(t, err)
+	copiedList, err := Image(context.Background(), acceptAnythingPolicyContext(t), registryRef, archiveRef, options)
+	require.NoError(t, err)
+
+	assert.Equal(t, copiedList, registry.manifests["tag"])
+	list, err := manifest.Schema2ListFromManifest(copiedList)
+	require.NoError(t, err)
+	require.Len(t, list.Manifests, 2)
+	for i, arch := range []string{"amd64", "arm64"} {
+		instance := list.Manifests[i]
+		assert.Equal(t, arch, instance.Platform.Architecture)
+		instanceBlob, ok := registry.manifests[instance.Digest.String()]
+		require.True(t, ok, arch)
+		man, err := manifest.Schema2FromManifest(instanceBlob)
+		require.NoError(t, err, arch)
+		assert.Contains(t, registry.blobs, man.ConfigDescriptor.Digest, arch)
+		require.Len(t, man.LayersDescriptors, 2, arch)
+		for _, layer := range man.LayersDescriptors {
+			assert.Contains(t, registry.blobs, layer.Digest, arch)
+		}
+	}
+
+	// The archive can still be read as individual images, e.g. by (docker load)
+	reader, err := archive.NewReader(nil, archivePath)
+	require.NoError(t, err)
+	defer reader.Close()
+	refs, err := reader.List()
+	require.NoError(t, err)
+	require.Len(t, refs, 3) // Two untagged instances, and the list
+	for _, instanceRefs := range refs[:2] {
+		require.Len(t, instanceRefs, 1)
+		img, err := instanceRefs[0].NewImage(context.Background(), nil)
+		require.NoError(t, err)
+		defer img.Close()
+		_, mimeType, err := img.Manifest(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, manifest.DockerV2Schema2MediaType, mimeType)
+	}
+	require.Len(t, refs[2], 1)
+	dockerRef := refs[2][0].DockerReference()
+	require.NotNil(t, dockerRef)
+	assert.Equal(t, "example.com/list:tag", dockerRef.String())
+}
+
+func TestImageDockerArchiveSourceIndex(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	writer, err := archive.NewWriter(nil, archivePath)
+	require.NoError(t, err)
+	configDigests := []digest.Digest{}
+	for i := 1; i <= 3; i++ {
+		srcRef, _, configDigest := newTestDirImage(t, fmt.Sprintf("layer of image %d", i))
+		configDigests = append(configDigests, configDigest)
+		named, err := reference.ParseNormalizedNamed(fmt.Sprintf("example.com/img:v%d", i))
+		require.NoError(t, err)
+		tagged, ok := named.(reference.NamedTagged)
+		require.True(t, ok)
+		archiveRef, err := writer.NewReference(tagged)
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), archiveRef, srcRef, &Options{})
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	// The second of the three images is selected by its zero-based index.
+	for _, ref := range []string{archivePath + ":@1", archivePath + ":example.com/img:v2"} {
+		archiveRef, err := archive.ParseReference(ref)
+		require.NoError(t, err, ref)
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err, ref)
+		copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, archiveRef, &Options{})
+		require.NoError(t, err, ref)
+		man, err := manifest.Schema2FromManifest(copiedManifest)
+		require.NoError(t, err, ref)
+		assert.Equal(t, configDigests[1], man.ConfigDescriptor.Digest, ref)
+	}
+
+	// An index out of range is rejected.
+	archiveRef, err := archive.NewIndexReference(archivePath, 3)
+	require.NoError(t, err)
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, archiveRef, &Options{})
+	assert.ErrorContains(t, err, "Invalid source index @3, only 3 manifest items available")
+}
+
+func TestImageProvenanceAnnotations(t *testing.T) {
+	provenance := map[string]string{
+		"org.example.source":    "dir:source",
+		imgspecv1.AnnotationURL: "https://example.com/provenance",
+	}
+
+	// A schema2 image is converted to OCI to be able to contain the annotations.
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	ociRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), ociRef, srcRef, &Options{
+		ProvenanceAnnotations: provenance,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, manifest.GuessMIMEType(manBlob))
+	ociMan, err := manifest.OCI1FromManifest(manBlob)
+	require.NoError(t, err)
+	assert.Equal(t, provenance, ociMan.Annotations)
+
+	// Existing annotations are preserved, unless overwriting is requested.
+	for _, overwrite := range []bool{false, true} {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, ociRef, &Options{
+			ProvenanceAnnotations: map[string]string{
+				imgspecv1.AnnotationURL: "https://example.com/other",
+				"org.example.copied":    "yes",
+			},
+			OverwriteProvenanceAnnotations: overwrite,
+		})
+		require.NoError(t, err)
+		man, err := manifest.OCI1FromManifest(manBlob)
+		require.NoError(t, err)
+		expectedURL := "https://example.com/provenance"
+		if overwrite {
+			expectedURL = "https://example.com/other"
+		}
+		assert.Equal(t, map[string]string{
+			"org.example.source":    "dir:source",
+			imgspecv1.AnnotationURL: expectedURL,
+			"org.example.copied":    "yes",
+		}, man.Annotations, overwrite)
+	}
+
+	// Manifests can't be modified when preserving digests.
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		ProvenanceAnnotations: provenance,
+		PreserveDigests:       true,
+	})
+	assert.Error(t, err)
+
+	// With a manifest list, the annotations are added to the index, not to the instances.
+	listRef := newTestDirManifestList(t)
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	listBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, listRef, &Options{
+		ImageListSelection:    CopyAllImages,
+		ProvenanceAnnotations: provenance,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, manifest.GuessMIMEType(listBlob))
+	index, err := manifest.OCI1IndexFromManifest(listBlob)
+	require.NoError(t, err)
+	assert.Equal(t, provenance, index.Annotations)
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	require.Len(t, index.Manifests, 2)
+	for _, instance := range index.Manifests {
+		instanceBlob, instanceType, err := src.GetManifest(context.Background(), &instance.Digest)
+		require.NoError(t, err)
+		assert.Equal(t, manifest.DockerV2Schema2MediaType, instanceType)
+		assert.NotContains(t, string(instanceBlob), "org.example.source")
+	}
+}
+
+// testManifestDigest returns the digest of the manifest (instanceDigest == nil) or instance in ref.
+func testManifestDigest(t *testing.T, ref types.ImageReference, instanceDigest *digest.Digest) digest.Digest {
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	manBlob, _, err := src.GetManifest(context.Background(), instanceDigest)
+	require.NoError(t, err)
+	d, err := manifest.Digest(manBlob)
+	require.NoError(t, err)
+	return d
+}
+
+func TestImageAllowedManifestDigests(t *testing.T) {
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	srcDigest := testManifestDigest(t, srcRef, nil)
+	otherDigest := digest.FromString("some other manifest")
+
+	destDir := t.TempDir()
+	destRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		AllowedManifestDigests: []digest.Digest{otherDigest, srcDigest},
+	})
+	require.NoError(t, err)
+
+	destDir = t.TempDir()
+	destRef, err = directory.NewReference(destDir)
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		AllowedManifestDigests: []digest.Digest{otherDigest},
+	})
+	assert.ErrorContains(t, err, srcDigest.String())
+	// No blobs have been copied.
+	entries, err := os.ReadDir(destDir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.Equal(t, "version", e.Name())
+	}
+
+	// When copying a single image from a list, either the list or the instance digest can be allowed.
+	listRef := newTestDirManifestList(t)
+	listDigest := testManifestDigest(t, listRef, nil)
+	src, err := listRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	listBlob, listType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, src.Close())
+	list, err := manifest.ListFromBlob(listBlob, listType)
+	require.NoError(t, err)
+	sys := &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"}
+	arm64Digest, err := list.ChooseInstance(sys)
+	require.NoError(t, err)
+	for _, c := range []struct {
+		selection ImageListSelection
+		allowed   digest.Digest
+		success   bool
+	}{
+		{CopySystemImage, listDigest, true},
+		{CopySystemImage, arm64Digest, true},
+		{CopySystemImage, otherDigest, false},
+		{CopyAllImages, listDigest, true},
+		{CopyAllImages, arm64Digest, false},
+	} {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, listRef, &Options{
+			SourceCtx:              sys,
+			ImageListSelection:     c.selection,
+			AllowedManifestDigests: []digest.Digest{c.allowed},
+		})
+		if c.success {
+			assert.NoError(t, err, c.allowed)
+		} else {
+			assert.Error(t, err, c.allowed)
+		}
+	}
+}
+
+func TestImageCopyPlatformImage(t *testing.T) {
+	listRef := newTestDirManifestList(t)
+	src, err := listRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	listBlob, listType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, src.Close())
+	list, err := manifest.ListFromBlob(listBlob, listType)
+	require.NoError(t, err)
+	amd64Digest, err := list.ChooseInstance(&types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux"})
+	require.NoError(t, err)
+
+	// The amd64 instance is written as a single manifest, even if SourceCtx would choose a different one.
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, listRef, &Options{
+		SourceCtx:          &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"},
+		ImageListSelection: CopyPlatformImage,
+		Platform:           &imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, manifest.GuessMIMEType(copiedManifest))
+	copiedDigest, err := manifest.Digest(copiedManifest)
+	require.NoError(t, err)
+	assert.Equal(t, amd64Digest, copiedDigest)
+	assert.Equal(t, amd64Digest, testManifestDigest(t, destRef, nil))
+
+	// This works with destinations which don't support manifest lists.
+	archiveRef, err := archive.NewReference(filepath.Join(t.TempDir(), "archive.tar"), nil)
+	require.NoError(t, err)
+	copiedManifest, err = Image(context.Background(), acceptAnythingPolicyContext(t), archiveRef, listRef, &Options{
+		ImageListSelection: CopyPlatformImage,
+		Platform:           &imgspecv1.Platform{Architecture: "amd64"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, manifest.GuessMIMEType(copiedManifest))
+
+	// A platform which is not in the list is rejected.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, listRef, &Options{
+		ImageListSelection: CopyPlatformImage,
+		Platform:           &imgspecv1.Platform{OS: "linux", Architecture: "s390x"},
+	})
+	assert.Error(t, err)
+
+	// The platform must be specified.
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, listRef, &Options{
+		ImageListSelection: CopyPlatformImage,
+	})
+	assert.Error(t, err)
+}
+
+func TestImageNestedIndex(t *testing.T) {
+	// putIndex writes an OCI index of instances to dest, as the top-level manifest if instance is false, and returns its digest.
+	putIndex := func(dest types.ImageDestination, instances []imgspecv1.Descriptor, instance bool) digest.Digest {
+		indexBlob, err := manifest.OCI1IndexFromComponents(instances, nil).Serialize()
+		require.NoError(t, err)
+		indexDigest := digest.FromBytes(indexBlob)
+		var instanceDigest *digest.Digest
+		if instance {
+			instanceDigest = &indexDigest
+		}
+		require.NoError(t, dest.PutManifest(context.Background(), indexBlob, instanceDigest))
+		return indexDigest
+	}
+	// putImage writes an image for architecture to dest, and returns its descriptor.
+	putImage := func(dest types.ImageDestination, architecture string) imgspecv1.Descriptor {
+		manBlob, _, _ := putTestImageBlobs(t, dest, architecture, "layer for "+architecture)
+		manDigest := digest.FromBytes(manBlob)
+		require.NoError(t, dest.PutManifest(context.Background(), manBlob, &manDigest))
+		return imgspecv1.Descriptor{
+			MediaType: manifest.DockerV2Schema2MediaType,
+			Digest:    manDigest,
+			Size:      int64(len(manBlob)),
+			Platform:  &imgspecv1.Platform{Architecture: architecture, OS: "linux"},
+		}
+	}
+
+	// A top-level index containing a s390x image, and a nested index (without a platform) containing amd64 and arm64 images.
+	srcRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := srcRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	images := map[string]imgspecv1.Descriptor{}
+	for _, arch := range []string{"amd64", "arm64", "s390x"} {
+		images[arch] = putImage(dest, arch)
+	}
+	nestedDigest := putIndex(dest, []imgspecv1.Descriptor{images["amd64"], images["arm64"]}, true)
+	putIndex(dest, []imgspecv1.Descriptor{images["s390x"], {MediaType: imgspecv1.MediaTypeImageIndex, Digest: nestedDigest}}, false)
+	require.NoError(t, dest.Commit(context.Background(), nil))
+
+	for _, arch := range []string{"amd64", "arm64", "s390x"} {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+			ImageListSelection: CopyPlatformImage,
+			Platform:           &imgspecv1.Platform{OS: "linux", Architecture: arch},
+		})
+		require.NoError(t, err, arch)
+		copiedDigest, err := manifest.Digest(copiedManifest)
+		require.NoError(t, err)
+		assert.Equal(t, images[arch].Digest, copiedDigest, arch)
+
+		// The nested index is also used when reading the image directly.
+		img, err := srcRef.NewImage(context.Background(), &types.SystemContext{ArchitectureChoice: arch, OSChoice: "linux"})
+		require.NoError(t, err, arch)
+		info, err := img.Inspect(context.Background())
+		require.NoError(t, err, arch)
+		assert.Equal(t, arch, info.Architecture)
+		require.NoError(t, img.Close())
+	}
+
+	// The nested index is copied along with the top-level one, including all of its instances.
+	for _, options := range []*Options{
+		{ImageListSelection: CopyAllImages},
+		{ImageListSelection: CopySpecificImages, Instances: []digest.Digest{images["s390x"].Digest, nestedDigest}},
+	} {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, options)
+		require.NoError(t, err)
+		for _, arch := range []string{"amd64", "arm64", "s390x"} {
+			img, err := destRef.NewImage(context.Background(), &types.SystemContext{ArchitectureChoice: arch, OSChoice: "linux"})
+			require.NoError(t, err, arch)
+			info, err := img.Inspect(context.Background())
+			require.NoError(t, err, arch)
+			assert.Equal(t, arch, info.Architecture)
+			require.NoError(t, img.Close())
+		}
+	}
+
+	// Instances which are not nested lists can be copied alone.
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	copiedList, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		ImageListSelection: CopySpecificImages,
+		Instances:          []digest.Digest{images["s390x"].Digest},
+	})
+	require.NoError(t, err)
+	index, err := manifest.OCI1IndexFromManifest(copiedList)
+	require.NoError(t, err)
+	require.Len(t, index.Manifests, 2)
+	assert.Equal(t, images["s390x"].Digest, index.Manifests[0].Digest)
+	assert.Equal(t, nestedDigest, index.Manifests[1].Digest)
+
+	// A platform which is not in the nested index is rejected.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		ImageListSelection: CopyPlatformImage,
+		Platform:           &imgspecv1.Platform{OS: "linux", Architecture: "ppc64le"},
+	})
+	assert.Error(t, err)
+
+	// If the first nested index does not contain a matching image, the other nested indexes are searched.
+	multiRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err = multiRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	arm64Image, amd64Image := putImage(dest, "arm64"), putImage(dest, "amd64")
+	putIndex(dest, []imgspecv1.Descriptor{
+		{MediaType: imgspecv1.MediaTypeImageIndex, Digest: putIndex(dest, []imgspecv1.Descriptor{arm64Image}, true)},
+		{MediaType: imgspecv1.MediaTypeImageIndex, Digest: putIndex(dest, []imgspecv1.Descriptor{amd64Image}, true)},
+	}, false)
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, multiRef, &Options{
+		ImageListSelection: CopyPlatformImage,
+		Platform:           &imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, amd64Image.Digest, digest.FromBytes(copiedManifest))
+
+	// Indexes nested too deeply are rejected.
+	deepRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err = deepRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	instance := putImage(dest, "amd64")
+	for i := 0; i < 10; i++ {
+		instance = imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageIndex, Digest: putIndex(dest, []imgspecv1.Descriptor{instance}, true)}
+	}
+	putIndex(dest, []imgspecv1.Descriptor{instance}, false)
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, deepRef, &Options{
+		ImageListSelection: CopyPlatformImage,
+		Platform:           &imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+	})
+	assert.ErrorContains(t, err, "nested more than")
+}
+
+func TestImageDockerAttestations(t *testing.T) {
+	// putBlob writes blob to dest, and returns its descriptor.
+	putBlob := func(dest types.ImageDestination, mediaType string, blob []byte, isConfig bool) imgspecv1.Descriptor {
+		blobDigest := digest.FromBytes(blob)
+		_, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, none.NoCache, isConfig)
+		require.NoError(t, err)
+		return imgspecv1.Descriptor{MediaType: mediaType, Digest: blobDigest, Size: int64(len(blob))}
+	}
+	// putManifest writes manBlob, an instance of type mimeType, to dest, and returns its descriptor.
+	putManifest := func(dest types.ImageDestination, mimeType string, manBlob []byte) imgspecv1.Descriptor {
+		manDigest := digest.FromBytes(manBlob)
+		require.NoError(t, dest.PutManifest(context.Background(), manBlob, &manDigest))
+		return imgspecv1.Descriptor{MediaType: mimeType, Digest: manDigest, Size: int64(len(manBlob))}
+	}
+
+	// A buildx-style index with amd64 and arm64 images, and an attestation manifest for each of them.
+	srcRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := srcRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	images := map[string]imgspecv1.Descriptor{}
+	attestations := map[string]imgspecv1.Descriptor{}
+	instances := []imgspecv1.Descriptor{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		manBlob, _, _ := putTestImageBlobs(t, dest, arch, "layer for "+arch)
+		img := putManifest(dest, manifest.DockerV2Schema2MediaType, manBlob)
+		img.Platform = &imgspecv1.Platform{Architecture: arch, OS: "linux"}
+		images[arch] = img
+		instances = append(instances, img)
+	}
+	for _, arch := range []string{"amd64", "arm64"} {
+		statement := []byte(`{"_type":"https://in-toto.io/Statement/v0.1","predicateType":"https://slsa.dev/provenance/v0.2",` +
+			`"subject":[{"name":"` + arch + `"}],"predicate":{}}`)
+		layer := putBlob(dest, "application/vnd.in-toto+json", statement, false)
+		layer.Annotations = map[string]string{"in-toto.io/predicate-type": "https://slsa.dev/provenance/v0.2"}
+		configBlob, err := json.Marshal(imgspecv1.Image{
+			Architecture: "unknown",
+			OS:           "unknown",
+			RootFS:       imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{layer.Digest}},
+		})
+		require.NoError(t, err)
+		config := putBlob(dest, imgspecv1.MediaTypeImageConfig, configBlob, true)
+		manBlob, err := manifest.OCI1FromComponents(config, []imgspecv1.Descriptor{layer}).Serialize()
+		require.NoError(t, err)
+		attestation := putManifest(dest, imgspecv1.MediaTypeImageManifest, manBlob)
+		attestation.Platform = &imgspecv1.Platform{Architecture: "unknown", OS: "unknown"}
+		attestation.Annotations = map[string]string{
+			manifest.DockerReferenceTypeAnnotation:   manifest.DockerAttestationManifestReferenceType,
+			manifest.DockerReferenceDigestAnnotation: images[arch].Digest.String(),
+		}
+		attestations[arch] = attestation
+		instances = append(instances, attestation)
+	}
+	indexBlob, err := manifest.OCI1IndexFromComponents(instances, nil).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), indexBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+
+	// copyIndex copies the index with options, and returns the copied index.
+	copyIndex := func(options *Options) *manifest.OCI1Index {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, options)
+		require.NoError(t, err)
+		index, err := manifest.OCI1IndexFromManifest(copiedManifest)
+		require.NoError(t, err)
+		return index
+	}
+
+	// Selecting a platform never chooses an attestation manifest.
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		ImageListSelection: CopyPlatformImage,
+		Platform:           &imgspecv1.Platform{OS: "linux", Architecture: "arm64"},
+	})
+	require.NoError(t, err)
+	copiedDigest, err := manifest.Digest(copiedManifest)
+	require.NoError(t, err)
+	assert.Equal(t, images["arm64"].Digest, copiedDigest)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		ImageListSelection: CopyPlatformImage,
+		Platform:           &imgspecv1.Platform{OS: "unknown", Architecture: "unknown"},
+	})
+	assert.Error(t, err)
+
+	// When images are converted, the attestation manifests are preserved, and refer to the converted images.
+	index := copyIndex(&Options{
+		ImageListSelection:    CopyAllImages,
+		ForceManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+	})
+	require.Len(t, index.Manifests, 4)
+	for i, arch := range []string{"amd64", "arm64"} {
+		img := index.Manifests[i]
+		assert.Equal(t, imgspecv1.MediaTypeImageManifest, img.MediaType, arch)
+		assert.NotEqual(t, images[arch].Digest, img.Digest, arch)
+		attestation := index.Manifests[2+i]
+		assert.Equal(t, attestations[arch].Digest, attestation.Digest, arch)
+		assert.Equal(t, attestations[arch].Platform, attestation.Platform, arch)
+		subject, ok := manifest.DockerAttestationSubject(attestation)
+		assert.True(t, ok, arch)
+		assert.Equal(t, img.Digest, subject, arch)
+	}
+
+	// Overriding the platforms of instances does not apply to attestation manifests.
+	index = copyIndex(&Options{
+		ImageListSelection:    CopyAllImages,
+		OverrideArchitecture:  "s390x",
+		OverrideListPlatforms: true,
+	})
+	require.Len(t, index.Manifests, 4)
+	for i, arch := range []string{"amd64", "arm64"} {
+		assert.Equal(t, &imgspecv1.Platform{OS: "linux", Architecture: "s390x"}, index.Manifests[i].Platform, arch)
+		assert.Equal(t, attestations[arch].Platform, index.Manifests[2+i].Platform, arch)
+	}
+
+	// Attestation manifests of images removed from the index are removed as well.
+	index = copyIndex(&Options{
+		ImageListSelection:    CopySpecificImages,
+		Instances:             []digest.Digest{images["arm64"].Digest, attestations["amd64"].Digest, attestations["arm64"].Digest},
+		SparseImageListAction: StripSparseManifestList,
+	})
+	assert.Equal(t, []digest.Digest{images["arm64"].Digest, attestations["arm64"].Digest}, index.Instances())
+}
+
+func TestImageVerifyPlatform(t *testing.T) {
+	srcRef, _, _ := newTestDirImage(t, "layer") // amd64
+	listRef := newTestDirManifestList(t)
+
+	// A list whose arm64 instance is actually an amd64 image.
+	mislabeledRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := mislabeledRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	manBlob, _, _ := putTestImageBlobs(t, dest, "amd64", "layer")
+	manDigest := digest.FromBytes(manBlob)
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, &manDigest))
+	listBlob, err := manifest.Schema2ListFromComponents([]manifest.Schema2ManifestDescriptor{{
+		Schema2Descriptor: manifest.Schema2Descriptor{
+			MediaType: manifest.DockerV2Schema2MediaType,
+			Size:      int64(len(manBlob)),
+			Digest:    manDigest,
+		},
+		Platform: manifest.Schema2PlatformSpec{Architecture: "arm64", OS: "linux"},
+	}}).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), listBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	require.NoError(t, dest.Close())
+
+	for i, c := range []struct {
+		src       types.ImageReference
+		selection ImageListSelection
+		sys       *types.SystemContext
+		platform  *imgspecv1.Platform
+		success   bool
+	}{
+		{srcRef, CopySystemImage, &types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux"}, nil, true},
+		{srcRef, CopySystemImage, &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"}, nil, false},
+		{srcRef, CopySystemImage, &types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "windows"}, nil, false},
+		{srcRef, CopyPlatformImage, nil, &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}, true},
+		{srcRef, CopyPlatformImage, nil, &imgspecv1.Platform{OS: "linux", Architecture: "arm64"}, false},
+		{listRef, CopySystemImage, &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"}, nil, true},
+		{listRef, CopyPlatformImage, &types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux"}, &imgspecv1.Platform{OS: "linux", Architecture: "arm64"}, true},
+		{mislabeledRef, CopySystemImage, &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"}, nil, false},
+		{mislabeledRef, CopyPlatformImage, nil, &imgspecv1.Platform{OS: "linux", Architecture: "arm64"}, false},
+		// Instances copied as a part of a list are not verified.
+		{mislabeledRef, CopyAllImages, &types.SystemContext{ArchitectureChoice: "s390x", OSChoice: "linux"}, nil, true},
+	} {
+		destDir := t.TempDir()
+		destRef, err := directory.NewReference(destDir)
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, c.src, &Options{
+			SourceCtx:          c.sys,
+			ImageListSelection: c.selection,
+			Platform:           c.platform,
+			VerifyPlatform:     true,
+		})
+		if c.success {
+			assert.NoError(t, err, i)
+		} else {
+			assert.Error(t, err, i)
+			// No blobs have been copied.
+			entries, err := os.ReadDir(destDir)
+			require.NoError(t, err)
+			for _, e := range entries {
+				assert.Equal(t, "version", e.Name(), i)
+			}
+		}
+	}
+
+	// Without VerifyPlatform, a mislabeled image is copied.
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, mislabeledRef, &Options{
+		SourceCtx: &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"},
+	})
+	assert.NoError(t, err)
+}
+
+func TestImageOCIManifestMediaType(t *testing.T) {
+	dirRef, _, _ := newTestDirImage(t, "layer")
+	ociRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), ociRef, dirRef, &Options{ForceManifestMIMEType: imgspecv1.MediaTypeImageManifest})
+	require.NoError(t, err)
+	ociDir := ociRef.StringWithinTransport()
+	withMediaType, err := os.ReadFile(filepath.Join(ociDir, "manifest.json"))
+	require.NoError(t, err)
+	mediaTypeField := []byte(`"mediaType":"` + imgspecv1.MediaTypeImageManifest + `",`)
+	require.True(t, bytes.Contains(withMediaType, mediaTypeField))
+	withoutMediaType := bytes.Replace(withMediaType, mediaTypeField, nil, 1)
+
+	for _, srcManifest := range [][]byte{withMediaType, withoutMediaType} {
+		hasMediaType := bytes.Contains(srcManifest, mediaTypeField)
+		require.NoError(t, os.WriteFile(filepath.Join(ociDir, "manifest.json"), srcManifest, 0o644))
+		srcDigest, err := manifest.Digest(srcManifest)
+		require.NoError(t, err)
+
+		// The manifest is copied unmodified to destinations which don't require changing it.
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, ociRef, nil)
+		require.NoError(t, err)
+		assert.Equal(t, srcManifest, copiedManifest)
+		assert.Equal(t, srcDigest, testManifestDigest(t, destRef, nil))
+		layoutRef, err := layout.NewReference(t.TempDir(), "latest")
+		require.NoError(t, err)
+		copiedManifest, err = Image(context.Background(), acceptAnythingPolicyContext(t), layoutRef, ociRef, nil)
+		require.NoError(t, err)
+		assert.Equal(t, srcManifest, copiedManifest)
+		assert.Equal(t, srcDigest, testManifestDigest(t, layoutRef, nil))
+
+		// If the manifest is modified, the presence of the field is preserved.
+		for _, options := range []*Options{
+			{ProvenanceAnnotations: map[string]string{"org.example.source": "test"}},
+			{DecompressLayers: true},
+			{AnnotateLayers: true},
+		} {
+			destRef, err := directory.NewReference(t.TempDir())
+			require.NoError(t, err)
+			copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, ociRef, options)
+			require.NoError(t, err)
+			assert.NotEqual(t, srcManifest, copiedManifest)
+			assert.Equal(t, hasMediaType, bytes.Contains(copiedManifest, mediaTypeField), string(copiedManifest))
+			assert.Equal(t, imgspecv1.MediaTypeImageManifest, manifest.GuessMIMEType(copiedManifest))
+		}
+	}
+
+	// The same applies to OCI indexes.
+	indexRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), indexRef, newTestDirManifestList(t), &Options{
+		ImageListSelection:    CopyAllImages,
+		ForceManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+	})
+	require.NoError(t, err)
+	indexDir := indexRef.StringWithinTransport()
+	indexWithMediaType, err := os.ReadFile(filepath.Join(indexDir, "manifest.json"))
+	require.NoError(t, err)
+	require.Equal(t, imgspecv1.MediaTypeImageIndex, manifest.GuessMIMEType(indexWithMediaType))
+	indexMediaTypeField := []byte(`"mediaType":"` + imgspecv1.MediaTypeImageIndex + `",`)
+	require.True(t, bytes.Contains(indexWithMediaType, indexMediaTypeField))
+	indexWithoutMediaType := bytes.Replace(indexWithMediaType, indexMediaTypeField, nil, 1)
+	for _, srcIndex := range [][]byte{indexWithMediaType, indexWithoutMediaType} {
+		hasMediaType := bytes.Contains(srcIndex, indexMediaTypeField)
+		require.NoError(t, os.WriteFile(filepath.Join(indexDir, "manifest.json"), srcIndex, 0o644))
+
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		copiedIndex, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, indexRef, &Options{ImageListSelection: CopyAllImages})
+		require.NoError(t, err)
+		assert.Equal(t, srcIndex, copiedIndex)
+
+		// Decompressing the layers modifies the instances, and therefore the index.
+		destRef, err = directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		copiedIndex, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, indexRef, &Options{
+			ImageListSelection: CopyAllImages,
+			DecompressLayers:   true,
+		})
+		require.NoError(t, err)
+		assert.NotEqual(t, srcIndex, copiedIndex)
+		assert.Equal(t, hasMediaType, bytes.Contains(copiedIndex, indexMediaTypeField), string(copiedIndex))
+		assert.Equal(t, imgspecv1.MediaTypeImageIndex, manifest.GuessMIMEType(copiedIndex))
+	}
+}
+
+func TestImageSchema2ToOCIPreservesLayers(t *testing.T) {
+	srcRef, layers, _ := newTestDirImage(t, "layer 1", "layer 2")
+	readBlob := func(ref types.ImageReference, blobDigest digest.Digest) []byte {
+		src, err := ref.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		defer src.Close()
+		stream, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+		require.NoError(t, err)
+		defer stream.Close()
+		blob, err := io.ReadAll(stream)
+		require.NoError(t, err)
+		return blob
+	}
+
+	for _, destCtx := range []*types.SystemContext{
+		nil,
+		// Requesting the format the layers already use does not recompress them either.
+		{CompressionFormat: &compression.Gzip},
+	} {
+		for _, newDestRef := range []func() types.ImageReference{
+			func() types.ImageReference {
+				ref, err := directory.NewReference(t.TempDir())
+				require.NoError(t, err)
+				return ref
+			},
+			func() types.ImageReference {
+				ref, err := layout.NewReference(t.TempDir(), "latest")
+				require.NoError(t, err)
+				return ref
+			},
+		} {
+			destRef := newDestRef()
+			copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+				DestinationCtx:        destCtx,
+				ForceManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+			})
+			require.NoError(t, err)
+			man, err := manifest.OCI1FromManifest(copiedManifest)
+			require.NoError(t, err)
+			// Only the media types change; the layer blobs are copied unmodified.
+			require.Len(t, man.Layers, len(layers))
+			for i, layer := range layers {
+				assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip, man.Layers[i].MediaType)
+				assert.Equal(t, layer.digest, man.Layers[i].Digest)
+			}
+			for _, layer := range layers {
+				assert.Equal(t, readBlob(srcRef, layer.digest), readBlob(destRef, layer.digest))
+			}
+		}
+	}
+}
+
+func TestImageMaxLayers(t *testing.T) {
+	srcRef, _, _ := newTestDirImage(t, "layer 1", "layer 2", "layer 3")
+	listRef := newTestDirManifestList(t) // Two layers in each instance
+
+	for _, c := range []struct {
+		src       types.ImageReference
+		maxLayers int
+		success   bool
+	}{
+		{srcRef, 0, true},
+		{srcRef, 3, true},
+		{srcRef, 2, false},
+		{listRef, 2, true},
+		{listRef, 1, false},
+	} {
+		destDir := t.TempDir()
+		destRef, err := directory.NewReference(destDir)
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, c.src, &Options{
+			ImageListSelection: CopyAllImages,
+			MaxLayers:          c.maxLayers,
+		})
+		if c.success {
+			assert.NoError(t, err, c.maxLayers)
+		} else {
+			assert.Error(t, err, c.maxLayers)
+			// No blobs have been copied.
+			entries, err := os.ReadDir(destDir)
+			require.NoError(t, err)
+			for _, e := range entries {
+				assert.Equal(t, "version", e.Name())
+			}
+		}
+	}
+}
+
+func TestImageVerifyLayerDiffIDs(t *testing.T) {
+	validRef, _, _ := newTestDirImage(t, "layer 1", "layer 2")
+
+	// An image where the config lists the DiffID of the first layer for both layers.
+	invalidRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := invalidRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	manBlob, layers, _ := putTestImageBlobs(t, dest, "amd64", "layer 1", "layer 2")
+	configBlob, err := json.Marshal(imgspecv1.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{layers[0].diffID, layers[0].diffID}},
+	})
+	require.NoError(t, err)
+	configDigest := digest.FromBytes(configBlob)
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(configBlob), types.BlobInfo{Digest: configDigest, Size: int64(len(configBlob))}, none.NoCache, true)
+	require.NoError(t, err)
+	man, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	man.ConfigDescriptor.Digest = configDigest
+	man.ConfigDescriptor.Size = int64(len(configBlob))
+	manBlob, err = man.Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+
+	for _, c := range []struct {
+		src     types.ImageReference
+		verify  bool
+		success bool
+	}{
+		{validRef, false, true},
+		{validRef, true, true},
+		{invalidRef, false, true},
+		{invalidRef, true, false},
+	} {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, c.src, &Options{
+			VerifyLayerDiffIDs: c.verify,
+		})
+		if c.success {
+			assert.NoError(t, err, c.src.StringWithinTransport(), c.verify)
+		} else {
+			assert.ErrorContains(t, err, layers[1].diffID.String())
+		}
+	}
+}
+
+func TestImageVerifyBlobsBeforeManifest(t *testing.T) {
+	sys := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	srcRef, layers, _ := newTestDirImage(t, "layer 1", "layer 2")
+
+	for _, c := range []struct {
+		verify  bool
+		success bool
+	}{
+		{false, true}, // The broken image is written
+		{true, false},
+	} {
+		registry, server := newTestRegistry(t)
+		registry.droppedBlobs = map[digest.Digest]struct{}{layers[1].digest: {}}
+		registryURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:tag")
+		require.NoError(t, err)
+
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+			DestinationCtx:            sys,
+			VerifyBlobsBeforeManifest: c.verify,
+		})
+		if c.success {
+			assert.NoError(t, err, c.verify)
+			assert.Contains(t, registry.manifests, "tag", c.verify)
+		} else {
+			assert.ErrorContains(t, err, layers[1].digest.String())
+			assert.Empty(t, registry.manifests)
+		}
+	}
+
+	// Nothing is missing if all uploads succeed.
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:tag")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx:            sys,
+		VerifyBlobsBeforeManifest: true,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, registry.manifests, "tag")
+}
+
+func TestImageDeduplicateLayers(t *testing.T) {
+	sys := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+
+	// The same layer, listed three times.
+	srcRef, layers, configDigest := newTestDirImage(t, "same", "same", "same")
+	require.Equal(t, layers[0].digest, layers[1].digest)
+	require.Equal(t, layers[0].digest, layers[2].digest)
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:tag")
+	require.NoError(t, err)
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx:    sys,
+		DeduplicateLayers: true,
+	})
+	require.NoError(t, err)
+	assert.Len(t, registry.uploads, 2) // The config and the layer
+	assert.Len(t, registry.blobs, 2)
+	assert.Contains(t, registry.blobs, configDigest)
+	assert.Contains(t, registry.blobs, layers[0].digest)
+	man, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	require.Len(t, man.LayersDescriptors, 3)
+	for _, layer := range man.LayersDescriptors {
+		assert.Equal(t, layers[0].digest, layer.Digest)
+	}
+
+	// The same contents, with a compressed and an uncompressed representation.
+	srcDir := t.TempDir()
+	srcRef, err = directory.NewReference(srcDir)
+	require.NoError(t, err)
+	dest, err := srcRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	manBlob, layers, _ = putTestImageBlobs(t, dest, "amd64", "same", "same")
+	compressedFile, err := os.Open(filepath.Join(srcDir, layers[0].digest.Encoded()))
+	require.NoError(t, err)
+	defer compressedFile.Close()
+	gzr, err := gzip.NewReader(compressedFile)
+	require.NoError(t, err)
+	uncompressed, err := io.ReadAll(gzr)
+	require.NoError(t, err)
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(uncompressed), types.BlobInfo{Digest: layers[1].diffID, Size: int64(len(uncompressed))}, none.NoCache, false)
+	require.NoError(t, err)
+	man, err = manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	man.LayersDescriptors[1] = manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2SchemaLayerMediaTypeUncompressed,
+		Size:      int64(len(uncompressed)),
+		Digest:    layers[1].diffID,
+	}
+	manBlob, err = man.Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+
+	for _, c := range []struct {
+		options  Options
+		expected []digest.Digest
+	}{
+		{Options{DeduplicateLayers: true}, []digest.Digest{layers[0].digest, layers[0].digest}},
+		// The manifest can't be modified, so both representations are copied.
+		{Options{DeduplicateLayers: true, PreserveDigests: true}, []digest.Digest{layers[0].digest, layers[1].diffID}},
+	} {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &c.options)
+		require.NoError(t, err)
+		man, err := manifest.Schema2FromManifest(manBlob)
+		require.NoError(t, err)
+		res := []digest.Digest{}
+		for _, layer := range man.LayersDescriptors {
+			res = append(res, layer.Digest)
+		}
+		assert.Equal(t, c.expected, res)
+	}
+
+	// Layers are not deduplicated based on DiffIDs in the config which don't match the layer contents.
+	tarA, tarB := testimage.Tar(t, testimage.File("file", "a")), testimage.Tar(t, testimage.File("file", "b"))
+	srcRef, _ = testimage.NewDirImage(t, testimage.Image{
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+		Layers: []testimage.Layer{
+			{Blob: testimage.Gzip(t, tarA), MediaType: manifest.DockerV2Schema2LayerMediaType, DiffID: digest.FromBytes(tarB)},
+			{Blob: testimage.Gzip(t, tarB), MediaType: manifest.DockerV2Schema2LayerMediaType, DiffID: digest.FromBytes(tarB)},
+		},
+	})
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{DeduplicateLayers: true})
+	assert.ErrorContains(t, err, "does not match the DiffID")
+}
+
+func TestImageAnnotateLayers(t *testing.T) {
+	srcRef, layers, configDigest := newTestDirImage(t, "layer 1", "layer 2")
+
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		AnnotateLayers: true,
+	})
+	require.NoError(t, err)
+	man, err := manifest.OCI1FromManifest(copiedManifest)
+	require.NoError(t, err)
+	// The config and the layers are not modified.
+	assert.Equal(t, configDigest, man.Config.Digest)
+	require.Len(t, man.Layers, len(layers))
+	for i, layer := range layers {
+		assert.Equal(t, layer.digest, man.Layers[i].Digest)
+		assert.Equal(t, map[string]string{
+			LayerDiffIDAnnotation: layer.diffID.String(),
+			LayerSizeAnnotation:   strconv.FormatInt(man.Layers[i].Size, 10),
+		}, man.Layers[i].Annotations)
+	}
+
+	// Annotating layers requires modifying the manifest.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		AnnotateLayers:  true,
+		PreserveDigests: true,
+	})
+	assert.Error(t, err)
+}
+
+// serializationCheckingSigner is a fakeSigner which fails the test if it is used concurrently.
+type serializationCheckingSigner struct {
+	fakeSigner
+	t      *testing.T
+	active int32 // Accessed atomically
+}
+
+func (s *serializationCheckingSigner) Sign(payload []byte) ([]byte, error) {
+	if atomic.AddInt32(&s.active, 1) != 1 {
+		assert.Fail(s.t, "Concurrent use of a signer")
+	}
+	defer atomic.AddInt32(&s.active, -1)
+	time.Sleep(10 * time.Millisecond) // Give concurrent callers, if any, a chance to overlap.
+	return s.fakeSigner.Sign(payload)
+}
+
+func TestImageMaxParallelInstanceCopies(t *testing.T) {
+	architectures := []string{"amd64", "arm64", "ppc64le", "s390x"}
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	// A dir: source does not support concurrent blob transfers, so populate a registry first.
+	srcRegistry, srcServer := newTestRegistry(t)
+	srcURL, err := url.Parse(srcServer.URL)
+	require.NoError(t, err)
+	srcRef, err := docker.ParseReference("//" + srcURL.Host + "/list:tag")
+	require.NoError(t, err)
+	srcList, err := Image(context.Background(), acceptAnythingPolicyContext(t), srcRef, newTestDirManifestList(t, architectures...), &Options{
+		DestinationCtx:     sys,
+		ImageListSelection: CopyAllImages,
+	})
+	require.NoError(t, err)
+
+	// Each request for a layer specific to one of the images waits until layers of two images are being read.
+	srcInstances, err := manifest.Schema2ListFromManifest(srcList)
+	require.NoError(t, err)
+	instanceLayers := map[string]struct{}{}
+	for _, instance := range srcInstances.Manifests {
+		man, err := manifest.Schema2FromManifest(srcRegistry.manifests[instance.Digest.String()])
+		require.NoError(t, err)
+		instanceLayers[man.LayersDescriptors[1].Digest.String()] = struct{}{}
+	}
+	inFlightLock := sync.Mutex{}
+	inFlight := map[string]struct{}{}
+	bothInFlight := make(chan struct{})
+	srcRegistry.mutex.Lock()
+	srcRegistry.requestHook = func(r *http.Request) {
+		layer := path.Base(r.URL.Path)
+		if _, ok := instanceLayers[layer]; !ok || r.Method != http.MethodGet {
+			return
+		}
+		inFlightLock.Lock()
+		inFlight[layer] = struct{}{}
+		if len(inFlight) == 2 {
+			close(bothInFlight)
+		}
+		inFlightLock.Unlock()
+		select {
+		case <-bothInFlight:
+		case <-time.After(10 * time.Second):
+			assert.Fail(t, "Only one image was being copied at a time")
+		}
+	}
+	srcRegistry.mutex.Unlock()
+
+	destRegistry, destServer := newTestRegistry(t)
+	destURL, err := url.Parse(destServer.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + destURL.Host + "/list:tag")
+	require.NoError(t, err)
+	registriesDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(registriesDir, "default.yaml"), []byte("default-docker:\n  use-sigstore-attachments: true\n"), 0600)
+	require.NoError(t, err)
+	destSys := *sys
+	destSys.RegistriesDirPath = registriesDir
+	signer := &serializationCheckingSigner{t: t}
+	copiedList, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		SourceCtx:                 sys,
+		DestinationCtx:            &destSys,
+		ImageListSelection:        CopyAllImages,
+		MaxParallelInstanceCopies: 2,
+		Signers:                   []signature.Signer{signer},
+	})
+	require.NoError(t, err)
+	assert.Len(t, signer.payloads, len(architectures)+1)
+	srcRegistry.mutex.Lock()
+	srcRegistry.requestHook = nil
+	srcRegistry.mutex.Unlock()
+	assert.Equal(t, srcList, copiedList)
+	assert.Equal(t, copiedList, destRegistry.manifests["tag"])
+	list, err := manifest.Schema2ListFromManifest(copiedList)
+	require.NoError(t, err)
+	require.Len(t, list.Manifests, len(architectures))
+	for i, arch := range architectures {
+		instance := list.Manifests[i]
+		assert.Equal(t, arch, instance.Platform.Architecture)
+		instanceBlob, ok := destRegistry.manifests[instance.Digest.String()]
+		require.True(t, ok, arch)
+		assert.Equal(t, srcRegistry.manifests[instance.Digest.String()], instanceBlob, arch)
+		man, err := manifest.Schema2FromManifest(instanceBlob)
+		require.NoError(t, err, arch)
+		assert.Contains(t, destRegistry.blobs, man.ConfigDescriptor.Digest, arch)
+		for _, layer := range man.LayersDescriptors {
+			assert.Contains(t, destRegistry.blobs, layer.Digest, arch)
+		}
+	}
+
+	// OciEncryptLayerSelector is not called concurrently
+	destRef, err = docker.ParseReference("//" + destURL.Host + "/list:selector")
+	require.NoError(t, err)
+	var activeSelectors int32
+	selectorCalls := int32(0)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		SourceCtx:                 sys,
+		DestinationCtx:            sys,
+		ImageListSelection:        CopyAllImages,
+		MaxParallelInstanceCopies: 2,
+		OciEncryptLayerSelector: func(int, types.BlobInfo) bool {
+			if atomic.AddInt32(&activeSelectors, 1) != 1 {
+				assert.Fail(t, "Concurrent call of OciEncryptLayerSelector")
+			}
+			defer atomic.AddInt32(&activeSelectors, -1)
+			atomic.AddInt32(&selectorCalls, 1)
+			time.Sleep(10 * time.Millisecond) // Give concurrent callers, if any, a chance to overlap.
+			return false
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2*len(architectures)), selectorCalls)
+
+	// A failure to copy one of the instances is reported
+	delete(srcRegistry.manifests, list.Manifests[2].Digest.String())
+	destRef, err = docker.ParseReference("//" + destURL.Host + "/list:failing")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		SourceCtx:                 sys,
+		DestinationCtx:            sys,
+		ImageListSelection:        CopyAllImages,
+		MaxParallelInstanceCopies: 2,
+	})
+	assert.ErrorContains(t, err, "copying image 3/4 from manifest list")
+	assert.NotContains(t, destRegistry.manifests, "failing")
+}
+
+func TestImageAdditionalTags(t *testing.T) {
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:latest")
+	require.NoError(t, err)
+
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx: sys,
+		AdditionalTags: []string{"v1", "v1.0"},
+	})
+	require.NoError(t, err)
+	for _, tag := range []string{"latest", "v1", "v1.0"} {
+		assert.Equal(t, copiedManifest, registry.manifests[tag], tag)
+	}
+	// The config and the layer were each uploaded only once.
+	assert.Len(t, registry.uploads, 2)
+
+	// Invalid tags, and destinations which can't store them, are rejected before copying any blobs.
+	for _, c := range []struct {
+		dest types.ImageReference
+		tags []string
+	}{
+		{destRef, []string{"v2", "invalid:tag"}},
+		{nil, []string{"v2"}},
+	} {
+		destRef := c.dest
+		if destRef == nil {
+			destRef, _, _ = newTestDirImage(t)
+		}
+		_, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+			DestinationCtx: sys,
+			AdditionalTags: c.tags,
+		})
+		assert.Error(t, err)
+	}
+	assert.Len(t, registry.uploads, 2)
+	assert.NotContains(t, registry.manifests, "v2")
+}
+
+func TestImageFailIfDestinationExists(t *testing.T) {
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	otherSrcRef, _, _ := newTestDirImage(t, "other layer")
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:latest")
+	require.NoError(t, err)
+
+	// The destination tag does not exist yet.
+	options := &Options{DestinationCtx: sys, FailIfDestinationExists: true}
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, options)
+	require.NoError(t, err)
+	assert.Equal(t, copiedManifest, registry.manifests["latest"])
+	uploads := len(registry.uploads)
+
+	// The destination tag exists; nothing is copied.
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, otherSrcRef, options)
+	assert.ErrorIs(t, err, ErrDestinationExists)
+	assert.Equal(t, copiedManifest, registry.manifests["latest"])
+	assert.Len(t, registry.uploads, uploads)
+
+	// Overwriting is allowed if the existing manifest has one of the allowed digests.
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, otherSrcRef, &Options{
+		DestinationCtx:                    sys,
+		FailIfDestinationExists:           true,
+		AllowedExistingDestinationDigests: []digest.Digest{digest.FromString("unrelated")},
+	})
+	assert.ErrorIs(t, err, ErrDestinationExists)
+	otherManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, otherSrcRef, &Options{
+		DestinationCtx:                    sys,
+		FailIfDestinationExists:           true,
+		AllowedExistingDestinationDigests: []digest.Digest{digest.FromString("unrelated"), digest.FromBytes(copiedManifest)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, otherManifest, registry.manifests["latest"])
+
+	// An existing dir: destination is detected before opening the destination, which would remove the image.
+	dirDestRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), dirDestRef, srcRef, options)
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), dirDestRef, otherSrcRef, options)
+	assert.ErrorIs(t, err, ErrDestinationExists)
+	dirManifest, err := os.ReadFile(filepath.Join(dirDestRef.StringWithinTransport(), "manifest.json"))
+	require.NoError(t, err)
+	assert.Equal(t, copiedManifest, dirManifest)
+
+	// A destination which can't be checked is not assumed to be missing.
+	failingServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer failingServer.Close()
+	failingURL, err := url.Parse(failingServer.URL)
+	require.NoError(t, err)
+	failingRef, err := docker.ParseReference("//" + failingURL.Host + "/img:latest")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), failingRef, srcRef, options)
+	assert.ErrorContains(t, err, "checking whether destination")
+	assert.NotErrorIs(t, err, ErrDestinationExists)
+	// Neither is a destination of a transport which can't check.
+	archiveRef, err := archive.ParseReference(filepath.Join(t.TempDir(), "archive.tar"))
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), archiveRef, srcRef, options)
+	assert.ErrorContains(t, err, "is not supported")
+}
+
+func TestImageReuseDifferentlyCompressedLayer(t *testing.T) {
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		CompressionFormat:           &compression.Zstd,
+	}
+	srcRef, layers, _ := newTestDirImage(t, "layer")
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:latest")
+	require.NoError(t, err)
+
+	// The destination already contains the layer, compressed differently than in the source.
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "file", Mode: 0o644, Size: int64(len("layer")), Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte("layer"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.Equal(t, layers[0].diffID, digest.FromBytes(tarBuf.Bytes()))
+	var gzBuf bytes.Buffer
+	gzw, err := gzip.NewWriterLevel(&gzBuf, gzip.BestCompression)
+	require.NoError(t, err)
+	_, err = gzw.Write(tarBuf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	existingDigest := digest.FromBytes(gzBuf.Bytes())
+	require.NotEqual(t, layers[0].digest, existingDigest)
+	registry.blobs[existingDigest] = gzBuf.Bytes()
+
+	// The cache only knows about the blob at the destination, not about the source layer.
+	cache := blobinfocache.FromBlobInfoCache(pkgblobinfocache.DefaultCache(sys))
+	cache.RecordDigestUncompressedPair(existingDigest, layers[0].diffID)
+	cache.RecordDigestCompressorName(existingDigest, compressiontypes.GzipAlgorithmName)
+	cache.RecordKnownLocation(docker.Transport, types.BICTransportScope{Opaque: registryURL.Host},
+		existingDigest, types.BICLocationReference{Opaque: destRef.DockerReference().Name()})
+
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx: sys,
+	})
+	require.NoError(t, err)
+	man, err := manifest.Schema2FromManifest(copiedManifest)
+	require.NoError(t, err)
+	require.Len(t, man.LayersDescriptors, 1)
+	assert.Equal(t, existingDigest, man.LayersDescriptors[0].Digest)
+	assert.Equal(t, int64(gzBuf.Len()), man.LayersDescriptors[0].Size)
+	assert.Equal(t, manifest.DockerV2Schema2LayerMediaType, man.LayersDescriptors[0].MediaType)
+	// Only the config was uploaded.
+	assert.Len(t, registry.uploads, 1)
+	assert.NotContains(t, registry.blobs, layers[0].digest)
+}
+
+func TestImageMinimumLayerSizeToCompress(t *testing.T) {
+	largeContents := make([]byte, 16*1024)
+	_, err := rand.New(rand.NewSource(1)).Read(largeContents)
+	require.NoError(t, err)
+	srcRef, layers, _ := newTestDirImage(t, "small layer", string(largeContents))
+
+	for _, c := range []struct {
+		minimumSize  int64
+		expectedZstd []bool
+	}{
+		{0, []bool{true, true}},
+		{1024, []bool{false, true}},
+		{1024 * 1024, []bool{false, false}},
+	} {
+		destRef, err := layout.ParseReference(filepath.Join(t.TempDir(), "layout") + ":tag")
+		require.NoError(t, err)
+		manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+			DestinationCtx:             &types.SystemContext{CompressionFormat: &compression.Zstd},
+			MinimumLayerSizeToCompress: c.minimumSize,
+		})
+		require.NoError(t, err)
+		man, err := manifest.OCI1FromManifest(manBlob)
+		require.NoError(t, err)
+		require.Len(t, man.Layers, len(layers))
+		for i, layer := range layers {
+			if c.expectedZstd[i] {
+				assert.Equal(t, imgspecv1.MediaTypeImageLayerZstd, man.Layers[i].MediaType, "%d/%d", c.minimumSize, i)
+				assert.NotEqual(t, layer.digest, man.Layers[i].Digest, "%d/%d", c.minimumSize, i)
+			} else {
+				assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip, man.Layers[i].MediaType, "%d/%d", c.minimumSize, i)
+				assert.Equal(t, layer.digest, man.Layers[i].Digest, "%d/%d", c.minimumSize, i)
+			}
+		}
+	}
+}
+
+func TestImageUpdateImageConfig(t *testing.T) {
+	srcRef, layers, configDigest := newTestDirImage(t, "layer 1", "layer 2")
+	updateEntrypoint := func(config imgspecv1.ImageConfig) (imgspecv1.ImageConfig, error) {
+		config.Entrypoint = []string{"/bin/sh", "-c"}
+		config.ExposedPorts = nil
+		return config, nil
+	}
+
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		UpdateImageConfig: updateEntrypoint,
+	})
+	require.NoError(t, err)
+	man, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	assert.NotEqual(t, configDigest, man.ConfigDescriptor.Digest)
+	require.Len(t, man.LayersDescriptors, len(layers))
+	for i, layer := range layers {
+		assert.Equal(t, layer.digest, man.LayersDescriptors[i].Digest)
+	}
+
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	img, err := image.FromSource(context.Background(), nil, src)
+	require.NoError(t, err)
+	configBlob, err := img.ConfigBlob(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, man.ConfigDescriptor.Digest, digest.FromBytes(configBlob))
+	assert.Equal(t, man.ConfigDescriptor.Size, int64(len(configBlob)))
+	config, err := img.OCIConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/sh", "-c"}, config.Config.Entrypoint)
+	assert.Equal(t, "amd64", config.Architecture)
+	require.Len(t, config.RootFS.DiffIDs, len(layers))
+	for i, layer := range layers {
+		assert.Equal(t, layer.diffID, config.RootFS.DiffIDs[i])
+	}
+
+	// An error from the callback fails the copy.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		UpdateImageConfig: func(config imgspecv1.ImageConfig) (imgspecv1.ImageConfig, error) {
+			return imgspecv1.ImageConfig{}, errors.New("refusing to update")
+		},
+	})
+	assert.Error(t, err)
+
+	// The config can't be updated when preserving digests.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		UpdateImageConfig: updateEntrypoint,
+		PreserveDigests:   true,
+	})
+	assert.Error(t, err)
+}
+
+func TestUpdateConfigBlob(t *testing.T) {
+	c := &copier{updateImageConfig: func(config imgspecv1.ImageConfig) (imgspecv1.ImageConfig, error) {
+		assert.Equal(t, imgspecv1.ImageConfig{
+			ExposedPorts: map[string]struct{}{"80/tcp": {}},
+			Entrypoint:   []string{"/bin/app"},
+		}, config)
+		config.ExposedPorts = nil
+		config.Cmd = []string{"--verbose"}
+		return config, nil
+	}}
+	res, err := c.updateConfigBlob([]byte(`{"architecture":"amd64","config":{"Hostname":"host","ExposedPorts":{"80/tcp":{}},"Entrypoint":["/bin/app"]},` +
+		`"rootfs":{"type":"layers","diff_ids":["sha256:0000000000000000000000000000000000000000000000000000000000000000"]}}`))
+	require.NoError(t, err)
+	// Fields not represented in imgspecv1.ImageConfig, both in the config section and outside of it, are preserved.
+	assert.JSONEq(t, `{"architecture":"amd64","config":{"Hostname":"host","Entrypoint":["/bin/app"],"Cmd":["--verbose"]},`+
+		`"rootfs":{"type":"layers","diff_ids":["sha256:0000000000000000000000000000000000000000000000000000000000000000"]}}`, string(res))
+
+	_, err = c.updateConfigBlob([]byte("not JSON"))
+	assert.Error(t, err)
+}
+
+func TestImageOverridePlatform(t *testing.T) {
+	// readConfig returns the config of the image at ref, verifying that it matches the manifest.
+	readConfig := func(ref types.ImageReference, instanceDigest *digest.Digest) *imgspecv1.Image {
+		src, err := ref.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		defer src.Close()
+		img, err := image.FromUnparsedImage(context.Background(), nil, image.UnparsedInstance(src, instanceDigest))
+		require.NoError(t, err)
+		configBlob, err := img.ConfigBlob(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, img.ConfigInfo().Digest, digest.FromBytes(configBlob))
+		assert.Equal(t, img.ConfigInfo().Size, int64(len(configBlob)))
+		config, err := img.OCIConfig(context.Background())
+		require.NoError(t, err)
+		return config
+	}
+
+	srcRef, layers, configDigest := newTestDirImage(t, "layer 1", "layer 2")
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		OverrideArchitecture: "arm64",
+		OverrideVariant:      "v8",
+	})
+	require.NoError(t, err)
+	man, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	assert.NotEqual(t, configDigest, man.ConfigDescriptor.Digest)
+	require.Len(t, man.LayersDescriptors, len(layers))
+	config := readConfig(destRef, nil)
+	assert.Equal(t, "linux", config.OS) // Not overridden
+	assert.Equal(t, "arm64", config.Architecture)
+	assert.Equal(t, "v8", config.Variant)
+	require.Len(t, config.RootFS.DiffIDs, len(layers))
+	for i, layer := range layers {
+		assert.Equal(t, layer.digest, man.LayersDescriptors[i].Digest)
+		assert.Equal(t, layer.diffID, config.RootFS.DiffIDs[i])
+	}
+
+	// The platform can't be overridden when preserving digests.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		OverrideArchitecture: "arm64",
+		PreserveDigests:      true,
+	})
+	assert.Error(t, err)
+
+	// Manifest lists
+	listRef := newTestDirManifestList(t, "amd64", "s390x")
+	for _, c := range []struct {
+		listMIMEType          string
+		overrideListPlatforms bool
+	}{
+		{"", false},
+		{"", true},
+		{imgspecv1.MediaTypeImageManifest, true},
+	} {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		listBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, listRef, &Options{
+			ImageListSelection:    CopyAllImages,
+			ForceManifestMIMEType: c.listMIMEType,
+			OverrideArchitecture:  "arm64",
+			OverrideVariant:       "v8",
+			OverrideListPlatforms: c.overrideListPlatforms,
+		})
+		require.NoError(t, err)
+		list, err := manifest.ListFromBlob(listBlob, manifest.GuessMIMEType(listBlob))
+		require.NoError(t, err)
+		instances := list.Instances()
+		require.Len(t, instances, 2)
+		for i, originalArch := range []string{"amd64", "s390x"} {
+			config := readConfig(destRef, &instances[i])
+			assert.Equal(t, "linux", config.OS)
+			assert.Equal(t, "arm64", config.Architecture)
+			assert.Equal(t, "v8", config.Variant)
+
+			var platform imgspecv1.Platform
+			switch l := list.(type) {
+			case *manifest.Schema2List:
+				platform = imgspecv1.Platform{OS: l.Manifests[i].Platform.OS, Architecture: l.Manifests[i].Platform.Architecture, Variant: l.Manifests[i].Platform.Variant}
+			case *manifest.OCI1Index:
+				require.NotNil(t, l.Manifests[i].Platform)
+				platform = *l.Manifests[i].Platform
+			default:
+				require.Failf(t, "Unexpected list type", "%T", list)
+			}
+			if c.overrideListPlatforms {
+				assert.Equal(t, imgspecv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, platform)
+			} else {
+				assert.Equal(t, imgspecv1.Platform{OS: "linux", Architecture: originalArch}, platform)
+			}
+		}
+	}
+}
+
+func TestOverridePlatformInConfigBlob(t *testing.T) {
+	c := &copier{overridePlatform: imgspecv1.Platform{OS: "windows", Variant: "v7"}}
+	res, err := c.overridePlatformInConfigBlob([]byte(`{"architecture":"arm","os":"linux","config":{"Hostname":"host"},` +
+		`"rootfs":{"type":"layers","diff_ids":["sha256:0000000000000000000000000000000000000000000000000000000000000000"]}}`))
+	require.NoError(t, err)
+	// Fields which are not overridden are preserved.
+	assert.JSONEq(t, `{"architecture":"arm","os":"windows","variant":"v7","config":{"Hostname":"host"},`+
+		`"rootfs":{"type":"layers","diff_ids":["sha256:0000000000000000000000000000000000000000000000000000000000000000"]}}`, string(res))
+
+	_, err = c.overridePlatformInConfigBlob([]byte("not JSON"))
+	assert.Error(t, err)
+}
+
+func TestImageDigestAlgorithm(t *testing.T) {
+	srcRef, layers, configDigest := newTestDirImage(t, "layer 1", "layer 2")
+
+	destDir := t.TempDir()
+	destRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx: &types.SystemContext{DigestAlgorithm: digest.SHA512},
+	})
+	require.NoError(t, err)
+	man, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	assert.Equal(t, digest.SHA512, man.ConfigDescriptor.Digest.Algorithm())
+	require.Len(t, man.LayersDescriptors, len(layers))
+	for _, d := range man.LayersDescriptors {
+		assert.Equal(t, digest.SHA512, d.Digest.Algorithm())
+	}
+	for _, info := range append([]manifest.Schema2Descriptor{man.ConfigDescriptor}, man.LayersDescriptors...) {
+		blob, err := os.ReadFile(filepath.Join(destDir, info.Digest.Encoded()))
+		require.NoError(t, err)
+		assert.Equal(t, info.Digest, digest.SHA512.FromBytes(blob))
+		assert.Equal(t, info.Size, int64(len(blob)))
+	}
+	// The layers, and the config (which refers to the layers by uncompressed digest), are not modified.
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	img, err := image.FromSource(context.Background(), nil, src)
+	require.NoError(t, err)
+	configBlob, err := img.ConfigBlob(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, configDigest, digest.FromBytes(configBlob))
+
+	// The sha512-digested image can be copied again; by default, existing digests are not changed.
+	destRef2, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob2, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef2, destRef, &Options{})
+	require.NoError(t, err)
+	assert.Equal(t, manBlob, manBlob2)
+
+	// With PreserveDigests, the digests are not changed.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx:  &types.SystemContext{DigestAlgorithm: digest.SHA512},
+		PreserveDigests: true,
+	})
+	require.NoError(t, err)
+	man, err = manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	assert.Equal(t, configDigest, man.ConfigDescriptor.Digest)
+	for i, layer := range layers {
+		assert.Equal(t, layer.digest, man.LayersDescriptors[i].Digest)
+	}
+
+	// An unavailable algorithm is rejected.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx: &types.SystemContext{DigestAlgorithm: digest.Algorithm("md5")},
+	})
+	assert.Error(t, err)
+
+	// Instances of a manifest list are referenced using the preferred algorithm.
+	listRef := newTestDirManifestList(t)
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	listBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, listRef, &Options{
+		DestinationCtx:     &types.SystemContext{DigestAlgorithm: digest.SHA512},
+		ImageListSelection: CopyAllImages,
+	})
+	require.NoError(t, err)
+	list, err := manifest.Schema2ListFromManifest(listBlob)
+	require.NoError(t, err)
+	require.Len(t, list.Manifests, 2)
+	for _, instance := range list.Manifests {
+		assert.Equal(t, digest.SHA512, instance.Digest.Algorithm())
+		d := instance.Digest
+		src, err := destRef.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		defer src.Close()
+		instanceBlob, _, err := src.GetManifest(context.Background(), &d)
+		require.NoError(t, err)
+		matches, err := manifest.MatchesDigest(instanceBlob, d)
+		require.NoError(t, err)
+		assert.True(t, matches)
+	}
+
+	// An OCI layout records the top-level manifest using the algorithm used for the image, which is not the preferred one
+	// if the manifest can't be modified.
+	for _, c := range []struct {
+		srcRef          types.ImageReference
+		preserveDigests bool
+		expected        digest.Algorithm
+	}{
+		{srcRef, false, digest.SHA512},
+		{srcRef, true, digest.Canonical},
+		{listRef, false, digest.SHA512},
+	} {
+		destDir := t.TempDir()
+		destRef, err := layout.NewReference(destDir, "latest")
+		require.NoError(t, err)
+		manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, c.srcRef, &Options{
+			DestinationCtx:     &types.SystemContext{DigestAlgorithm: digest.SHA512},
+			ImageListSelection: CopyAllImages,
+			PreserveDigests:    c.preserveDigests,
+		})
+		require.NoError(t, err)
+		indexBlob, err := os.ReadFile(filepath.Join(destDir, "index.json"))
+		require.NoError(t, err)
+		var index imgspecv1.Index
+		require.NoError(t, json.Unmarshal(indexBlob, &index))
+		require.Len(t, index.Manifests, 1)
+		assert.Equal(t, c.expected.FromBytes(manBlob), index.Manifests[0].Digest)
+	}
+}
+
+func TestImageCancelDuringUpload(t *testing.T) {
+	// Large enough not to fit into socket buffers, so that the client can't have sent all of it when the copy is cancelled.
+	largeContents := make([]byte, 16*1024*1024)
+	_, err := rand.New(rand.NewSource(1)).Read(largeContents)
+	require.NoError(t, err)
+	srcRef, _, _ := newTestDirImage(t, string(largeContents))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry, server := newTestRegistry(t)
+	uploadStarted := sync.Once{}
+	registry.mutex.Lock()
+	registry.requestHook = func(r *http.Request) {
+		if r.Method != http.MethodPatch {
+			return
+		}
+		uploadStarted.Do(func() {
+			// Cancel the copy after a part of the layer has been uploaded; the client should abort the upload
+			// instead of sending the rest of the layer.
+			_, err := io.ReadFull(r.Body, make([]byte, 64*1024))
+			assert.NoError(t, err)
+			cancel()
+			_, err = io.Copy(io.Discard, r.Body)
+			assert.Error(t, err)
+			panic(http.ErrAbortHandler) // Don’t try to handle the incomplete upload.
+		})
+	}
+	registry.mutex.Unlock()
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + registryURL.Host + "/repo:tag")
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = Image(ctx, acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx: &types.SystemContext{
+			RegistriesDirPath:           "/this/does/not/exist",
+			DockerPerHostCertDirPath:    "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		},
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	assert.NotContains(t, registry.manifests, "tag")
+}
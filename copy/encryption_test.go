@@ -0,0 +1,299 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/docker/archive"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/types"
+	encconfig "github.com/containers/ocicrypt/config"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsOciEncrypted(t *testing.T) {
+	for _, c := range []struct {
+		mediaType string
+		expected  bool
+	}{
+		{imgspecv1.MediaTypeImageLayerGzip + "+encrypted", true},
+		{imgspecv1.MediaTypeImageLayerZstd + "+encrypted", true},
+		{imgspecv1.MediaTypeImageLayer + "+encrypted", true},
+		{imgspecv1.MediaTypeImageLayerGzip, false},
+		{manifest.DockerV2Schema2LayerMediaType, false},
+		{"", false},
+	} {
+		assert.Equal(t, c.expected, isOciEncrypted(c.mediaType), c.mediaType)
+	}
+}
+
+// newTestEncryptedDirImage creates an OCI image with a single encrypted layer in a new dir: directory, and returns a reference to it
+// and the descriptor of the layer. No decryption key exists; the layer data happens to start with the gzip magic number.
+func newTestEncryptedDirImage(t *testing.T) (types.ImageReference, imgspecv1.Descriptor) {
+	ref, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	layerBlob := append([]byte{0x1f, 0x8b, 0x08, 0x00}, []byte("this is not really encrypted data")...)
+	layer := imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageLayerGzip + "+encrypted",
+		Digest:    digest.FromBytes(layerBlob),
+		Size:      int64(len(layerBlob)),
+		Annotations: map[string]string{
+			"org.opencontainers.image.enc.keys.jwe": "a-wrapped-key",
+			"org.opencontainers.image.enc.pubopts":  "eyJjaXBoZXIiOiJBRVNfMjU2X0NUUl9ITUFDX1NIQTI1NiJ9",
+		},
+	}
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(layerBlob), types.BlobInfo{Digest: layer.Digest, Size: layer.Size}, none.NoCache, false)
+	require.NoError(t, err)
+
+	configBlob, err := json.Marshal(imgspecv1.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromString("the decrypted layer")}},
+	})
+	require.NoError(t, err)
+	config := imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(configBlob),
+		Size:      int64(len(configBlob)),
+	}
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(configBlob), types.BlobInfo{Digest: config.Digest, Size: config.Size}, none.NoCache, true)
+	require.NoError(t, err)
+	manBlob, err := manifest.OCI1FromComponents(config, []imgspecv1.Descriptor{layer}).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	return ref, layer
+}
+
+func TestImageEncryptedLayerPassthrough(t *testing.T) {
+	srcRef, layer := newTestEncryptedDirImage(t)
+
+	for _, c := range []struct {
+		name    string
+		options Options
+	}{
+		{"default", Options{}},
+		{"decompressing layers", Options{DecompressLayers: true}},
+		{"compressing layers", Options{DestinationCtx: &types.SystemContext{DirForceCompress: true}}},
+		{"decompressing in the destination", Options{DestinationCtx: &types.SystemContext{DirForceDecompress: true}}},
+	} {
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		options := c.options
+		manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &options)
+		require.NoError(t, err, c.name)
+		man, err := manifest.OCI1FromManifest(manBlob)
+		require.NoError(t, err, c.name)
+		require.Len(t, man.Layers, 1, c.name)
+		assert.Equal(t, layer, man.Layers[0], c.name)
+	}
+}
+
+func TestBlobPipelineDetectCompressionStepEncrypted(t *testing.T) {
+	gzipMagic := []byte{0x1f, 0x8b, 0x08, 0x00}
+	info := types.BlobInfo{Digest: digest.FromBytes(gzipMagic), MediaType: imgspecv1.MediaTypeImageLayerGzip + "+encrypted"}
+
+	// Encrypted data is not inspected, so that its compression is not recorded based on random bytes.
+	stream := sourceStream{reader: bytes.NewReader(gzipMagic), info: info}
+	detected, err := blobPipelineDetectCompressionStep(&stream, info, &bpDecryptionStepData{decrypting: false})
+	require.NoError(t, err)
+	assert.False(t, detected.isCompressed)
+	assert.Equal(t, blobinfocache.UnknownCompression, detected.srcCompressorName)
+
+	// Decrypted data is inspected as usual.
+	stream = sourceStream{reader: bytes.NewReader(gzipMagic), info: info}
+	detected, err = blobPipelineDetectCompressionStep(&stream, info, &bpDecryptionStepData{decrypting: true})
+	require.NoError(t, err)
+	assert.True(t, detected.isCompressed)
+	assert.Equal(t, compression.Gzip.Name(), detected.srcCompressorName)
+}
+
+// newTestJWEKey returns a new RSA key pair, PEM-encoded, for use with ocicrypt’s JWE key wrapping.
+func newTestJWEKey(t *testing.T) (publicKey, privateKey []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	publicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	privateKey = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return publicKey, privateKey
+}
+
+func TestImageDecryptLayers(t *testing.T) {
+	srcRef, layers, configDigest := newTestDirImage(t, "layer 1", "layer 2")
+	publicKey1, privateKey1 := newTestJWEKey(t)
+	publicKey2, privateKey2 := newTestJWEKey(t)
+	_, unrelatedPrivateKey := newTestJWEKey(t)
+
+	// Encrypt all layers to two recipients.
+	encryptConfig, err := encconfig.EncryptWithJwe([][]byte{publicKey1, publicKey2})
+	require.NoError(t, err)
+	encryptedRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), encryptedRef, srcRef, &Options{
+		ForceManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+		OciEncryptConfig:      encryptConfig.EncryptConfig,
+		OciEncryptLayers:      &[]int{},
+	})
+	require.NoError(t, err)
+	man, err := manifest.OCI1FromManifest(manBlob)
+	require.NoError(t, err)
+	require.Len(t, man.Layers, len(layers))
+	for i, layer := range layers {
+		assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip+"+encrypted", man.Layers[i].MediaType)
+		assert.NotEqual(t, layer.digest, man.Layers[i].Digest)
+		assert.Contains(t, man.Layers[i].Annotations, "org.opencontainers.image.enc.keys.jwe")
+	}
+
+	// Either recipient can decrypt the image; the result matches the original image.
+	for _, privateKey := range [][]byte{privateKey1, privateKey2} {
+		decryptConfig, err := encconfig.DecryptWithPrivKeys([][]byte{unrelatedPrivateKey, privateKey}, [][]byte{nil, nil})
+		require.NoError(t, err)
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, encryptedRef, &Options{
+			OciDecryptConfig: decryptConfig.DecryptConfig,
+		})
+		require.NoError(t, err)
+		man, err := manifest.OCI1FromManifest(manBlob)
+		require.NoError(t, err)
+		assert.Equal(t, configDigest, man.Config.Digest)
+		require.Len(t, man.Layers, len(layers))
+		for i, layer := range layers {
+			assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip, man.Layers[i].MediaType)
+			assert.Equal(t, layer.digest, man.Layers[i].Digest)
+			for k := range man.Layers[i].Annotations {
+				assert.NotContains(t, k, "org.opencontainers.image.enc")
+			}
+		}
+
+		src, err := destRef.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		img, err := image.FromSource(context.Background(), nil, src)
+		require.NoError(t, err)
+		config, err := img.OCIConfig(context.Background())
+		require.NoError(t, err)
+		for i, layer := range img.LayerInfos() {
+			stream, _, err := src.GetBlob(context.Background(), layer, none.NoCache)
+			require.NoError(t, err)
+			blobDigest, err := digest.Canonical.FromReader(stream)
+			stream.Close()
+			require.NoError(t, err)
+			assert.Equal(t, layer.Digest, blobDigest)
+			assert.Equal(t, layers[i].diffID, config.RootFS.DiffIDs[i])
+		}
+		img.Close()
+	}
+
+	// The decrypted image can be written to a destination which does not support encryption.
+	decryptConfig, err := encconfig.DecryptWithPrivKeys([][]byte{privateKey1}, [][]byte{nil})
+	require.NoError(t, err)
+	archiveRef, err := archive.NewReference(filepath.Join(t.TempDir(), "archive.tar"), nil)
+	require.NoError(t, err)
+	manBlob, err = Image(context.Background(), acceptAnythingPolicyContext(t), archiveRef, encryptedRef, &Options{
+		OciDecryptConfig: decryptConfig.DecryptConfig,
+	})
+	require.NoError(t, err)
+	schema2, err := manifest.Schema2FromManifest(manBlob)
+	require.NoError(t, err)
+	require.Len(t, schema2.LayersDescriptors, len(layers))
+	for i, layer := range layers {
+		assert.Equal(t, manifest.DockerV2Schema2LayerMediaType, schema2.LayersDescriptors[i].MediaType)
+		assert.Equal(t, layer.digest, schema2.LayersDescriptors[i].Digest)
+	}
+
+	// A key which is not one of the recipients can't decrypt the image.
+	decryptConfig, err = encconfig.DecryptWithPrivKeys([][]byte{unrelatedPrivateKey}, [][]byte{nil})
+	require.NoError(t, err)
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, encryptedRef, &Options{
+		OciDecryptConfig: decryptConfig.DecryptConfig,
+	})
+	assert.Error(t, err)
+}
+
+func TestImageEncryptLayerSelector(t *testing.T) {
+	srcRef, layers, _ := newTestDirImage(t, "layer 1", "layer 2", "layer 3")
+	publicKey, privateKey := newTestJWEKey(t)
+	encryptConfig, err := encconfig.EncryptWithJwe([][]byte{publicKey})
+	require.NoError(t, err)
+
+	// Only encrypt the second layer.
+	encryptedRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	selectorCalls := []int{}
+	manBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), encryptedRef, srcRef, &Options{
+		ForceManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+		OciEncryptConfig:      encryptConfig.EncryptConfig,
+		OciEncryptLayerSelector: func(index int, layer types.BlobInfo) bool {
+			selectorCalls = append(selectorCalls, index)
+			assert.Equal(t, layers[index].digest, layer.Digest)
+			return index == 1
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, selectorCalls)
+	man, err := manifest.OCI1FromManifest(manBlob)
+	require.NoError(t, err)
+	require.Len(t, man.Layers, len(layers))
+	for i, layer := range layers {
+		if i == 1 {
+			assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip+"+encrypted", man.Layers[i].MediaType)
+			assert.NotEqual(t, layer.digest, man.Layers[i].Digest)
+			assert.Contains(t, man.Layers[i].Annotations, "org.opencontainers.image.enc.keys.jwe")
+			assert.Contains(t, man.Layers[i].Annotations, "org.opencontainers.image.enc.pubopts")
+		} else {
+			assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip, man.Layers[i].MediaType)
+			assert.Equal(t, layer.digest, man.Layers[i].Digest)
+			assert.Empty(t, man.Layers[i].Annotations)
+		}
+	}
+
+	// The encrypted layer round-trips with the private key.
+	decryptConfig, err := encconfig.DecryptWithPrivKeys([][]byte{privateKey}, [][]byte{nil})
+	require.NoError(t, err)
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	manBlob, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, encryptedRef, &Options{
+		OciDecryptConfig: decryptConfig.DecryptConfig,
+	})
+	require.NoError(t, err)
+	man, err = manifest.OCI1FromManifest(manBlob)
+	require.NoError(t, err)
+	require.Len(t, man.Layers, len(layers))
+	for i, layer := range layers {
+		assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip, man.Layers[i].MediaType)
+		assert.Equal(t, layer.digest, man.Layers[i].Digest)
+	}
+
+	// The selector can't be combined with OciEncryptLayers.
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		ForceManifestMIMEType:   imgspecv1.MediaTypeImageManifest,
+		OciEncryptConfig:        encryptConfig.EncryptConfig,
+		OciEncryptLayers:        &[]int{},
+		OciEncryptLayerSelector: func(int, types.BlobInfo) bool { return true },
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,239 @@
+package copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/internal/imagesource"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// EstimateTransferSize returns the total size, in bytes, of the source blobs (configs and layers) which Image would
+// copy from srcRef to destRef with the same options, excluding blobs which already exist at the destination.
+// Manifests and signatures are not included.
+//
+// Nothing is copied, and the destination is not modified; destRef must be a reference of a transport which can check for existing
+// blobs without opening an ImageDestination (currently docker: and oci:), other transports are rejected with an error.
+// The result may overestimate the transfer if Image can reuse blobs in other ways, e.g. by mounting them from other repositories
+// of the same registry or by substituting differently-compressed versions, and it is not accurate if Image changes the compression
+// of the layers. Sizes not recorded in the manifests are determined from the source, without reading the blobs if the transport supports it.
+// The signature policy is not checked.
+func EstimateTransferSize(ctx context.Context, destRef, srcRef types.ImageReference, options *Options) (int64, error) {
+	return EstimateTransferSizeByInstance(ctx, destRef, srcRef, options, nil)
+}
+
+// InstanceTransferEstimate is the contribution of a single instance to the result of EstimateTransferSizeByInstance.
+type InstanceTransferEstimate struct {
+	Instance digest.Digest // The digest of the instance, or "" if the source is not a manifest list.
+	// Size is the total size of blobs used by this instance which are missing at the destination.
+	// Blobs shared by several instances are only included in the Size of the first one.
+	Size  int64
+	Total int64 // The sum of Size for this instance and all instances reported before it.
+}
+
+// EstimateTransferSizeByInstance is EstimateTransferSize, which, if progress is not nil, also sends an InstanceTransferEstimate
+// to progress after each instance selected by options (or the single image, if srcRef is not a manifest list) is processed.
+// Every send blocks until the value is received, or until ctx is done; progress is not closed when EstimateTransferSizeByInstance returns.
+func EstimateTransferSizeByInstance(ctx context.Context, destRef, srcRef types.ImageReference, options *Options, progress chan<- InstanceTransferEstimate) (_ int64, retErr error) {
+	if options == nil {
+		options = &Options{}
+	}
+	if err := validateImageListSelection(options.ImageListSelection); err != nil {
+		return -1, err
+	}
+	if options.ImageListSelection == CopyPlatformImage && options.Platform == nil {
+		return -1, errors.New("options.Platform must be set when using CopyPlatformImage")
+	}
+
+	withProbes, ok := destRef.(private.ImageReferenceWithBlobProbes)
+	if !ok {
+		return -1, fmt.Errorf("estimating the transfer size to %s is not supported: the destination can't be inspected without modifying it", transports.ImageName(destRef))
+	}
+	dest, err := withProbes.NewBlobProbe(ctx, options.DestinationCtx)
+	if err != nil {
+		return -1, fmt.Errorf("initializing destination %s: %w", transports.ImageName(destRef), err)
+	}
+	defer func() {
+		if err := dest.Close(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("closing destination %s: %w", transports.ImageName(destRef), err)
+		}
+	}()
+	publicRawSource, err := srcRef.NewImageSource(ctx, options.SourceCtx)
+	if err != nil {
+		return -1, fmt.Errorf("initializing source %s: %w", transports.ImageName(srcRef), err)
+	}
+	rawSource := imagesource.FromPublic(publicRawSource)
+	defer func() {
+		if err := rawSource.Close(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("closing source %s: %w", transports.ImageName(srcRef), err)
+		}
+	}()
+
+	instances, err := estimatedInstances(ctx, rawSource, options)
+	if err != nil {
+		return -1, err
+	}
+	seen := map[digest.Digest]struct{}{}
+	total := int64(0)
+	for _, instanceDigest := range instances {
+		src, err := image.FromUnparsedImage(ctx, options.SourceCtx, image.UnparsedInstance(rawSource, instanceDigest))
+		if err != nil {
+			return -1, fmt.Errorf("initializing image from source %s: %w", transports.ImageName(srcRef), err)
+		}
+		instanceSize := int64(0)
+		blobs := []types.BlobInfo{}
+		if configInfo := src.ConfigInfo(); configInfo.Digest != "" { // Docker schema1 images don't have a config blob.
+			blobs = append(blobs, configInfo)
+		}
+		for _, layer := range src.LayerInfos() {
+			if !options.DownloadForeignLayers && dest.AcceptsForeignLayerURLs() && len(layer.URLs) != 0 {
+				continue // Not copied, see copyLayers.
+			}
+			blobs = append(blobs, layer)
+		}
+
+		for _, info := range blobs {
+			if _, ok := seen[info.Digest]; ok {
+				continue
+			}
+			seen[info.Digest] = struct{}{}
+			exists, err := dest.BlobExists(ctx, info)
+			if err != nil {
+				return -1, fmt.Errorf("checking whether blob %s exists at the destination: %w", info.Digest, err)
+			}
+			if exists {
+				logrus.Debugf("Blob %s already exists at the destination", info.Digest)
+				continue
+			}
+			size, err := estimatedBlobSize(ctx, rawSource, info)
+			if err != nil {
+				return -1, fmt.Errorf("determining size of blob %s: %w", info.Digest, err)
+			}
+			instanceSize += size
+		}
+		total += instanceSize
+		if progress != nil {
+			estimate := InstanceTransferEstimate{Size: instanceSize, Total: total}
+			if instanceDigest != nil {
+				estimate.Instance = *instanceDigest
+			}
+			select {
+			case progress <- estimate:
+			case <-ctx.Done():
+				return -1, ctx.Err()
+			}
+		}
+	}
+	return total, nil
+}
+
+// estimatedInstances returns the instances which Image would copy from rawSource per options, or a single nil value if rawSource
+// is not a manifest list.
+func estimatedInstances(ctx context.Context, rawSource private.ImageSource, options *Options) ([]*digest.Digest, error) {
+	unparsedToplevel := image.UnparsedInstance(rawSource, nil)
+	multiImage, err := isMultiImage(ctx, unparsedToplevel)
+	if err != nil {
+		return nil, fmt.Errorf("determining manifest MIME type for %s: %w", transports.ImageName(rawSource.Reference()), err)
+	}
+	if !multiImage {
+		return []*digest.Digest{nil}, nil
+	}
+	mfest, manifestType, err := unparsedToplevel.Manifest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest for %s: %w", transports.ImageName(rawSource.Reference()), err)
+	}
+	manifestList, err := manifest.ListFromBlob(mfest, manifestType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing primary manifest as list for %s: %w", transports.ImageName(rawSource.Reference()), err)
+	}
+	res := []*digest.Digest{}
+	switch options.ImageListSelection {
+	case CopySystemImage, CopyPlatformImage:
+		instanceDigest, _, _, err := image.ChooseLeafInstance(ctx, wantedPlatformContext(options), rawSource, manifestList)
+		if err != nil {
+			return nil, fmt.Errorf("choosing an image from manifest list %s: %w", transports.ImageName(rawSource.Reference()), err)
+		}
+		res = append(res, &instanceDigest)
+	case CopyAllImages, CopySpecificImages:
+		return appendEstimatedListInstances(ctx, rawSource, options, res, manifestList, 0)
+	}
+	return res, nil
+}
+
+// appendEstimatedListInstances appends the single-image instances which Image would copy from list, read from rawSource and nested
+// depth levels deep, to res, and returns the result. Like Image, it copies all instances of nested lists.
+func appendEstimatedListInstances(ctx context.Context, rawSource private.ImageSource, options *Options, res []*digest.Digest, list manifest.List, depth int) ([]*digest.Digest, error) {
+	for _, instanceDigest := range list.Instances() {
+		instanceDigest := instanceDigest
+		if depth == 0 && options.ImageListSelection == CopySpecificImages && !isInstanceSelected(options.Instances, instanceDigest) {
+			continue
+		}
+		instance, err := list.Instance(instanceDigest)
+		if err != nil {
+			return nil, err
+		}
+		if !manifest.MIMETypeIsMultiImage(instance.MediaType) {
+			res = append(res, &instanceDigest)
+			continue
+		}
+		if depth+1 >= image.MaxListNestingDepth {
+			return nil, fmt.Errorf("instance %s of %s is a manifest list nested more than %d levels deep", instanceDigest, transports.ImageName(rawSource.Reference()), image.MaxListNestingDepth)
+		}
+		nestedBlob, nestedType, err := image.UnparsedInstance(rawSource, &instanceDigest).Manifest(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("reading manifest list %s of %s: %w", instanceDigest, transports.ImageName(rawSource.Reference()), err)
+		}
+		nested, err := manifest.ListFromBlob(nestedBlob, nestedType)
+		if err != nil {
+			return nil, fmt.Errorf("parsing manifest list %s of %s: %w", instanceDigest, transports.ImageName(rawSource.Reference()), err)
+		}
+		if res, err = appendEstimatedListInstances(ctx, rawSource, options, res, nested, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// isInstanceSelected returns true if instanceDigest is one of instances.
+func isInstanceSelected(instances []digest.Digest, instanceDigest digest.Digest) bool {
+	for _, d := range instances {
+		if d == instanceDigest {
+			return true
+		}
+	}
+	return false
+}
+
+// estimatedBlobSize returns the size of the blob described by info in rawSource, using info.Size if it is known.
+func estimatedBlobSize(ctx context.Context, rawSource private.ImageSource, info types.BlobInfo) (int64, error) {
+	if info.Size != -1 {
+		return info.Size, nil
+	}
+	if withSizes, ok := rawSource.(private.ImageSourceWithBlobSizes); ok {
+		size, err := withSizes.BlobSize(ctx, info, none.NoCache)
+		if err != nil {
+			return -1, err
+		}
+		if size != -1 {
+			return size, nil
+		}
+	}
+	// Fall back to starting to read the blob, which typically returns the size without reading the contents.
+	stream, size, err := rawSource.GetBlob(ctx, info, none.NoCache)
+	if err != nil {
+		return -1, err
+	}
+	stream.Close()
+	if size == -1 {
+		return -1, errors.New("the size of the blob is not known")
+	}
+	return size, nil
+}
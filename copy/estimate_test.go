@@ -0,0 +1,266 @@
+package copy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storedBlobsSize returns the total size of blobs stored in registry.
+func (registry *testRegistry) storedBlobsSize() int64 {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	res := int64(0)
+	for _, blob := range registry.blobs {
+		res += int64(len(blob))
+	}
+	return res
+}
+
+func TestEstimateTransferSize(t *testing.T) {
+	sys := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef := func(tag string) types.ImageReference {
+		ref, err := docker.ParseReference("//" + registryURL.Host + "/img:" + tag)
+		require.NoError(t, err)
+		return ref
+	}
+
+	// checkEstimate verifies that the estimate matches the size of the blobs uploaded by a copy with options.
+	checkEstimate := func(dest, src types.ImageReference, options *Options) {
+		options.DestinationCtx = sys
+		estimate, err := EstimateTransferSize(context.Background(), dest, src, options)
+		require.NoError(t, err)
+		assert.NotZero(t, estimate)
+		before := registry.storedBlobsSize()
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), dest, src, options)
+		require.NoError(t, err)
+		assert.Equal(t, registry.storedBlobsSize()-before, estimate)
+
+		// Nothing is left to transfer after the copy.
+		estimate, err = EstimateTransferSize(context.Background(), dest, src, options)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), estimate)
+	}
+
+	srcRef, _, _ := newTestDirImage(t, "layer 1", "layer 2")
+	checkEstimate(destRef("first"), srcRef, &Options{})
+	// Only the config and the layer which don't exist at the destination are transferred.
+	srcRef, layers, configDigest := newTestDirImage(t, "layer 1", "layer 3")
+	estimate, err := EstimateTransferSize(context.Background(), destRef("second"), srcRef, &Options{DestinationCtx: sys})
+	require.NoError(t, err)
+	src, err := srcRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	expected := int64(0)
+	for _, d := range []digest.Digest{configDigest, layers[1].digest} {
+		stream, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: d, Size: -1}, nil)
+		require.NoError(t, err)
+		stream.Close()
+		expected += size
+	}
+	assert.Equal(t, expected, estimate)
+	checkEstimate(destRef("second"), srcRef, &Options{})
+
+	// Lists
+	listRef := newTestDirManifestList(t, "amd64", "arm64", "s390x")
+	checkEstimate(destRef("single"), listRef, &Options{
+		SourceCtx: &types.SystemContext{ArchitectureChoice: "arm64", OSChoice: "linux"},
+	})
+	checkEstimate(destRef("list"), listRef, &Options{ImageListSelection: CopyAllImages})
+
+	// Sizes not recorded in a schema1 manifest are determined from the source.
+	srcRegistry, srcServer := newTestRegistry(t)
+	srcRegistryURL, err := url.Parse(srcServer.URL)
+	require.NoError(t, err)
+	fsLayers := []manifest.Schema1FSLayers{}
+	history := []manifest.Schema1History{}
+	for i, contents := range []string{"schema1 layer 1", "schema1 layer 2"} {
+		var buf bytes.Buffer
+		gzw := gzip.NewWriter(&buf)
+		_, err := gzw.Write([]byte(contents))
+		require.NoError(t, err)
+		require.NoError(t, gzw.Close())
+		blobDigest := digest.FromBytes(buf.Bytes())
+		srcRegistry.blobs[blobDigest] = buf.Bytes()
+		fsLayers = append([]manifest.Schema1FSLayers{{BlobSum: blobDigest}}, fsLayers...)
+		v1Compatibility := `{"id":"` + digest.FromString(contents).Encoded() + `"`
+		if i > 0 {
+			v1Compatibility += `,"parent":"` + digest.FromString("schema1 layer 1").Encoded() + `"`
+		}
+		history = append([]manifest.Schema1History{{V1Compatibility: v1Compatibility + `,"architecture":"amd64","os":"linux"}`}}, history...)
+	}
+	named, err := reference.ParseNormalizedNamed(srcRegistryURL.Host + "/img:schema1")
+	require.NoError(t, err)
+	s1, err := manifest.Schema1FromComponents(named, fsLayers, history, "amd64")
+	require.NoError(t, err)
+	s1Blob, err := s1.Serialize()
+	require.NoError(t, err)
+	srcRegistry.manifests["schema1"] = s1Blob
+	schema1Ref, err := docker.ParseReference("//" + srcRegistryURL.Host + "/img:schema1")
+	require.NoError(t, err)
+	checkEstimate(destRef("schema1"), schema1Ref, &Options{SourceCtx: sys})
+
+	// Invalid options are rejected.
+	_, err = EstimateTransferSize(context.Background(), destRef("invalid"), listRef, &Options{
+		DestinationCtx:     sys,
+		ImageListSelection: CopyPlatformImage,
+	})
+	assert.Error(t, err)
+}
+
+func TestEstimateTransferSizeByInstance(t *testing.T) {
+	sys := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	_, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:list")
+	require.NoError(t, err)
+
+	// Both instances contain "shared layer".
+	listRef := newTestDirManifestList(t, "amd64", "arm64")
+	src, err := listRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	listBlob, listType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	list, err := manifest.ListFromBlob(listBlob, listType)
+	require.NoError(t, err)
+	instances := list.Instances()
+	require.Len(t, instances, 2)
+	blobs := [][]types.BlobInfo{}
+	for _, instanceDigest := range instances {
+		instanceDigest := instanceDigest
+		manBlob, manType, err := src.GetManifest(context.Background(), &instanceDigest)
+		require.NoError(t, err)
+		m, err := manifest.FromBlob(manBlob, manType)
+		require.NoError(t, err)
+		infos := []types.BlobInfo{m.ConfigInfo()}
+		for _, layer := range m.LayerInfos() {
+			infos = append(infos, layer.BlobInfo)
+		}
+		blobs = append(blobs, infos)
+	}
+	sharedLayer := blobs[0][1]
+	require.Equal(t, sharedLayer.Digest, blobs[1][1].Digest)
+	sizeOf := func(infos []types.BlobInfo) int64 {
+		res := int64(0)
+		for _, info := range infos {
+			res += info.Size
+		}
+		return res
+	}
+	firstSize, secondSize := sizeOf(blobs[0]), sizeOf(blobs[1])
+
+	for _, c := range []struct {
+		name     string
+		options  Options
+		expected []InstanceTransferEstimate
+	}{
+		{
+			"all", Options{ImageListSelection: CopyAllImages},
+			[]InstanceTransferEstimate{
+				{Instance: instances[0], Size: firstSize, Total: firstSize},
+				// The shared layer is only included in the first instance.
+				{Instance: instances[1], Size: secondSize - sharedLayer.Size, Total: firstSize + secondSize - sharedLayer.Size},
+			},
+		},
+		{
+			"specific", Options{ImageListSelection: CopySpecificImages, Instances: []digest.Digest{instances[1]}},
+			[]InstanceTransferEstimate{{Instance: instances[1], Size: secondSize, Total: secondSize}},
+		},
+	} {
+		options := c.options
+		options.DestinationCtx = sys
+		progress := make(chan InstanceTransferEstimate)
+		done := make(chan []InstanceTransferEstimate)
+		go func() {
+			reported := []InstanceTransferEstimate{}
+			for estimate := range progress {
+				reported = append(reported, estimate)
+			}
+			done <- reported
+		}()
+		estimate, err := EstimateTransferSizeByInstance(context.Background(), destRef, listRef, &options, progress)
+		close(progress)
+		reported := <-done
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.expected, reported, c.name)
+		assert.Equal(t, c.expected[len(c.expected)-1].Total, estimate, c.name)
+	}
+
+	// A single image is reported without an instance digest.
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	progress := make(chan InstanceTransferEstimate, 1)
+	estimate, err := EstimateTransferSizeByInstance(context.Background(), destRef, srcRef, &Options{DestinationCtx: sys}, progress)
+	require.NoError(t, err)
+	assert.Equal(t, InstanceTransferEstimate{Size: estimate, Total: estimate}, <-progress)
+
+	// If nothing receives from progress, the estimate stops when ctx is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err = EstimateTransferSizeByInstance(ctx, destRef, srcRef, &Options{DestinationCtx: sys}, make(chan InstanceTransferEstimate))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestEstimateTransferSizeDestinations(t *testing.T) {
+	srcRef, _, _ := newTestDirImage(t, "layer")
+
+	// An OCI layout is supported, and checked for existing blobs without modifying it.
+	ociDir := t.TempDir()
+	ociRef, err := layout.NewReference(ociDir, "tag")
+	require.NoError(t, err)
+	estimate, err := EstimateTransferSize(context.Background(), ociRef, srcRef, nil)
+	require.NoError(t, err)
+	assert.NotZero(t, estimate)
+	entries, err := os.ReadDir(ociDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), ociRef, srcRef, nil)
+	require.NoError(t, err)
+	estimate, err = EstimateTransferSize(context.Background(), ociRef, srcRef, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), estimate)
+
+	// dir: would remove an existing image when opened as a destination, so it is rejected, and left intact.
+	dirDest, _, _ := newTestDirImage(t, "other")
+	dirPath := dirDest.StringWithinTransport()
+	dirContents := func() []string {
+		entries, err := os.ReadDir(dirPath)
+		require.NoError(t, err)
+		res := []string{}
+		for _, e := range entries {
+			res = append(res, e.Name())
+		}
+		return res
+	}
+	before := dirContents()
+	require.Contains(t, before, "manifest.json")
+	_, err = EstimateTransferSize(context.Background(), dirDest, srcRef, nil)
+	assert.Error(t, err)
+	assert.Equal(t, before, dirContents())
+}
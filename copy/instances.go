@@ -0,0 +1,150 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// InstanceCopyResult describes a copy of a single instance of a manifest list made by ImageAndInstances.
+type InstanceCopyResult struct {
+	Digest      digest.Digest        // The digest of the instance in the source manifest list
+	Platform    *imgspecv1.Platform  // The platform of the instance, or nil if the list does not specify it
+	Destination types.ImageReference // The reference the instance was copied to
+	Manifest    []byte               // The manifest which was written to Destination
+}
+
+// InstanceDestinationFunc returns the reference to copy an instance of a manifest list to, given the instance’s digest
+// and platform (which may be nil if the list does not specify it).
+type InstanceDestinationFunc func(instanceDigest digest.Digest, platform *imgspecv1.Platform) (types.ImageReference, error)
+
+// ImageAndInstances copies a manifest list from srcRef to destRef, with all of its instances, as Image does with
+// options.ImageListSelection == CopyAllImages, and then copies each of the instances as a separate image
+// to the reference returned by instanceDest for it (e.g. to a per-architecture tag).
+// It returns the manifest list which was written to destRef, and the results of the per-instance copies,
+// in the order of the instances in the source list.
+//
+// Blobs shared by the instances are only uploaded once if the destinations can reuse them, e.g. if all of them
+// are in the same registry repository; options.DestinationCtx determines the blob info cache used for that purpose.
+// Options which apply to the manifest list (AdditionalTags, SBOM, CopyReferrers and ProvenanceAnnotations) are only
+// used for the copy of the list, not for the copies of the instances.
+func ImageAndInstances(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference,
+	instanceDest InstanceDestinationFunc, options *Options) ([]byte, []InstanceCopyResult, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	listOptions := *options
+	listOptions.ImageListSelection = CopyAllImages
+	listOptions.Instances = nil
+
+	instances, err := listInstancesWithPlatforms(ctx, srcRef, options.SourceCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	copiedList, err := Image(ctx, policyContext, destRef, srcRef, &listOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instanceOptions := instanceCopyOptions(options)
+	results := make([]InstanceCopyResult, 0, len(instances))
+	for _, instance := range instances {
+		instanceRef, err := instanceDest(instance.Digest, instance.Platform)
+		if err != nil {
+			return nil, nil, fmt.Errorf("determining destination for instance %s: %w", instance.Digest, err)
+		}
+		instanceDigest := instance.Digest
+		copiedManifest, err := copyImage(ctx, policyContext, instanceRef, srcRef, instanceOptions, &instanceDigest, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("copying instance %s to %s: %w", instance.Digest, transports.ImageName(instanceRef), err)
+		}
+		instance.Destination = instanceRef
+		instance.Manifest = copiedManifest
+		results = append(results, instance)
+	}
+	return copiedList, results, nil
+}
+
+// instanceCopyOptions returns the options ImageAndInstances uses to copy a single instance, given the caller’s options:
+// options which apply to the manifest list are only used for the copy of the list.
+func instanceCopyOptions(options *Options) *Options {
+	res := *options
+	res.AdditionalTags = nil
+	res.SBOM = nil
+	res.CopyReferrers = false
+	res.ProvenanceAnnotations = nil
+	res.OverwriteProvenanceAnnotations = false
+	return &res
+}
+
+// InstanceCopyError describes an instance of a manifest list which ImageSkippingFailedInstances failed to copy.
+type InstanceCopyError struct {
+	Digest digest.Digest // The digest of the instance in the source manifest list
+	Err    error
+}
+
+func (e InstanceCopyError) Error() string {
+	return fmt.Sprintf("copying instance %s: %v", e.Digest, e.Err)
+}
+
+func (e InstanceCopyError) Unwrap() error {
+	return e.Err
+}
+
+// ImageSkippingFailedInstances is Image, except that if options.ImageListSelection is CopyAllImages or CopySpecificImages,
+// instances of the manifest list at srcRef which fail to copy (e.g. because they are missing at the source) don’t abort the copy:
+// they are omitted from the manifest list written to destRef, and their errors are returned, in the order of the source list.
+// The copy fails if none of the instances could be copied, or if the manifest list can’t be modified (e.g. because it is signed,
+// or because options.PreserveDigests is set) and some instances failed.
+func ImageSkippingFailedInstances(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference,
+	options *Options) ([]byte, []InstanceCopyError, error) {
+	var failures []InstanceCopyError
+	copiedManifest, err := copyImage(ctx, policyContext, destRef, srcRef, options, nil, &failures)
+	if err != nil {
+		return nil, nil, err
+	}
+	return copiedManifest, failures, nil
+}
+
+// listInstancesWithPlatforms returns the digests and platforms of the instances of the manifest list at srcRef.
+func listInstancesWithPlatforms(ctx context.Context, srcRef types.ImageReference, sys *types.SystemContext) ([]InstanceCopyResult, error) {
+	src, err := srcRef.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, fmt.Errorf("initializing source %s: %w", transports.ImageName(srcRef), err)
+	}
+	defer src.Close()
+	manifestBlob, manifestType, err := image.UnparsedInstance(src, nil).Manifest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest for %s: %w", transports.ImageName(srcRef), err)
+	}
+	if !manifest.MIMETypeIsMultiImage(manifestType) {
+		return nil, fmt.Errorf("%s is not a manifest list", transports.ImageName(srcRef))
+	}
+	list, err := manifest.ListFromBlob(manifestBlob, manifestType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing primary manifest as list for %s: %w", transports.ImageName(srcRef), err)
+	}
+	converted, err := list.ConvertToMIMEType(imgspecv1.MediaTypeImageIndex)
+	if err != nil {
+		return nil, fmt.Errorf("parsing primary manifest as list for %s: %w", transports.ImageName(srcRef), err)
+	}
+	index, ok := converted.(*manifest.OCI1Index)
+	if !ok {
+		return nil, fmt.Errorf("internal error: unexpected manifest list type %T", converted)
+	}
+	res := make([]InstanceCopyResult, 0, len(index.Manifests))
+	for _, instance := range index.Manifests {
+		res = append(res, InstanceCopyResult{
+			Digest:   instance.Digest,
+			Platform: instance.Platform,
+		})
+	}
+	return res, nil
+}
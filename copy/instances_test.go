@@ -0,0 +1,151 @@
+package copy
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageAndInstances(t *testing.T) {
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+
+	// Create a local OCI index.
+	ociRef, err := layout.ParseReference(filepath.Join(t.TempDir(), "layout") + ":list")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), ociRef, newTestDirManifestList(t), &Options{
+		ImageListSelection: CopyAllImages,
+	})
+	require.NoError(t, err)
+
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	listRef, err := docker.ParseReference("//" + registryURL.Host + "/img:list")
+	require.NoError(t, err)
+	copiedList, results, err := ImageAndInstances(context.Background(), acceptAnythingPolicyContext(t), listRef, ociRef,
+		func(instanceDigest digest.Digest, platform *imgspecv1.Platform) (types.ImageReference, error) {
+			return docker.ParseReference("//" + registryURL.Host + "/img:arch-" + platform.Architecture)
+		}, &Options{
+			SourceCtx:             sys,
+			DestinationCtx:        sys,
+			AdditionalTags:        []string{"latest"},
+			ProvenanceAnnotations: map[string]string{"org.example.built-by": "test"},
+		})
+	require.NoError(t, err)
+
+	assert.Equal(t, copiedList, registry.manifests["list"])
+	// Options applying to the list are only used for the list.
+	assert.Equal(t, copiedList, registry.manifests["latest"])
+	index, err := manifest.OCI1IndexFromManifest(copiedList)
+	require.NoError(t, err)
+	assert.Equal(t, "test", index.Annotations["org.example.built-by"])
+	require.Len(t, index.Manifests, 2)
+	require.Len(t, results, 2)
+	for i, arch := range []string{"amd64", "arm64"} {
+		res := results[i]
+		assert.Equal(t, index.Manifests[i].Digest, res.Digest, arch)
+		require.NotNil(t, res.Platform, arch)
+		assert.Equal(t, arch, res.Platform.Architecture)
+		assert.Equal(t, "//"+registryURL.Host+"/img:arch-"+arch, res.Destination.StringWithinTransport())
+		assert.Equal(t, res.Manifest, registry.manifests["arch-"+arch], arch)
+		assert.Equal(t, res.Digest, digest.FromBytes(res.Manifest), arch)
+	}
+
+	// Two configs, a shared layer and two per-architecture layers have each been uploaded only once.
+	assert.Len(t, registry.blobs, 5)
+	assert.Len(t, registry.uploads, 5)
+
+	// The source must be a manifest list.
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	_, _, err = ImageAndInstances(context.Background(), acceptAnythingPolicyContext(t), listRef, srcRef,
+		func(instanceDigest digest.Digest, platform *imgspecv1.Platform) (types.ImageReference, error) {
+			return listRef, nil
+		}, &Options{
+			SourceCtx:      sys,
+			DestinationCtx: sys,
+		})
+	assert.Error(t, err)
+}
+
+func TestImageSkippingFailedInstances(t *testing.T) {
+	sys := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	ref := func(tag string) types.ImageReference {
+		ref, err := docker.ParseReference("//" + registryURL.Host + "/img:" + tag)
+		require.NoError(t, err)
+		return ref
+	}
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), ref("src"), newTestDirManifestList(t, "amd64", "arm64", "s390x"), &Options{
+		DestinationCtx:     sys,
+		ImageListSelection: CopyAllImages,
+	})
+	require.NoError(t, err)
+	srcList, err := manifest.ListFromBlob(registry.manifests["src"], manifest.DockerV2ListMediaType)
+	require.NoError(t, err)
+	instances := srcList.Instances()
+	require.Len(t, instances, 3)
+	// Reading the arm64 instance fails with a 404.
+	delete(registry.manifests, instances[1].String())
+
+	options := &Options{SourceCtx: sys, DestinationCtx: sys, ImageListSelection: CopyAllImages}
+	copiedList, failures, err := ImageSkippingFailedInstances(context.Background(), acceptAnythingPolicyContext(t), ref("dest"), ref("src"), options)
+	require.NoError(t, err)
+	require.Len(t, failures, 1)
+	assert.Equal(t, instances[1], failures[0].Digest)
+	assert.ErrorContains(t, failures[0], "manifest unknown")
+	list, err := manifest.ListFromBlob(copiedList, manifest.DockerV2ListMediaType)
+	require.NoError(t, err)
+	assert.Equal(t, []digest.Digest{instances[0], instances[2]}, list.Instances())
+	assert.Equal(t, copiedList, registry.manifests["dest"])
+
+	// Without any failures, the list is copied unchanged.
+	copiedList, failures, err = ImageSkippingFailedInstances(context.Background(), acceptAnythingPolicyContext(t), ref("specific"), ref("src"), &Options{
+		SourceCtx:          sys,
+		DestinationCtx:     sys,
+		ImageListSelection: CopySpecificImages,
+		Instances:          []digest.Digest{instances[0]},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, failures)
+	assert.Equal(t, registry.manifests["src"], copiedList)
+
+	// Image still fails.
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), ref("dest"), ref("src"), options)
+	assert.ErrorContains(t, err, "manifest unknown")
+
+	// The list can't be modified.
+	_, _, err = ImageSkippingFailedInstances(context.Background(), acceptAnythingPolicyContext(t), ref("preserved"), ref("src"), &Options{
+		SourceCtx:          sys,
+		DestinationCtx:     sys,
+		ImageListSelection: CopyAllImages,
+		PreserveDigests:    true,
+	})
+	assert.ErrorContains(t, err, "which we cannot do")
+
+	// All instances fail.
+	delete(registry.manifests, instances[0].String())
+	delete(registry.manifests, instances[2].String())
+	_, _, err = ImageSkippingFailedInstances(context.Background(), acceptAnythingPolicyContext(t), ref("dest"), ref("src"), options)
+	assert.ErrorContains(t, err, "all 3 images failed to copy")
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"strings"
 
+	internalManifest "github.com/containers/image/v5/internal/manifest"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 )
 
@@ -47,7 +49,9 @@ type determineManifestConversionInputs struct {
 
 	forceManifestMIMEType      string // User’s choice of forced manifest MIME type
 	requiresOCIEncryption      bool   // Restrict to manifest formats that can support OCI encryption
+	requiresOCIAnnotations     bool   // Restrict to manifest formats that can contain annotations
 	cannotModifyManifestReason string // The reason the manifest cannot be modified, or an empty string if it can
+	rejectSchema1              bool   // Never use Docker schema1 for the destination
 }
 
 // manifestConversionPlan contains the decisions made by determineManifestConversion.
@@ -73,7 +77,33 @@ func determineManifestConversion(in determineManifestConversionInputs) (manifest
 	if in.forceManifestMIMEType != "" {
 		destSupportedManifestMIMETypes = []string{in.forceManifestMIMEType}
 	}
+	if in.rejectSchema1 {
+		if internalManifest.IsSchema1MIMEType(srcType) && in.cannotModifyManifestReason != "" {
+			return manifestConversionPlan{}, fmt.Errorf("Docker schema1 manifests are disabled, and the schema1 manifest cannot be converted: %s", in.cannotModifyManifestReason)
+		}
+		if len(destSupportedManifestMIMETypes) == 0 {
+			if internalManifest.IsSchema1MIMEType(srcType) {
+				// Anything goes, except for schema1.
+				destSupportedManifestMIMETypes = []string{manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest}
+			}
+		} else {
+			filtered := []string{}
+			for _, t := range destSupportedManifestMIMETypes {
+				if !internalManifest.IsSchema1MIMEType(t) {
+					filtered = append(filtered, t)
+				}
+			}
+			if len(filtered) == 0 {
+				return manifestConversionPlan{}, fmt.Errorf("Docker schema1 manifests are disabled, and the destination supports only %q", destSupportedManifestMIMETypes)
+			}
+			destSupportedManifestMIMETypes = filtered
+		}
+	}
 
+	if len(destSupportedManifestMIMETypes) == 0 && in.requiresOCIAnnotations && srcType != imgspecv1.MediaTypeImageManifest {
+		// Anything goes, but we need to convert to a format that can contain annotations.
+		destSupportedManifestMIMETypes = []string{imgspecv1.MediaTypeImageManifest}
+	}
 	if len(destSupportedManifestMIMETypes) == 0 && (!in.requiresOCIEncryption || manifest.MIMETypeSupportsEncryption(srcType)) {
 		return manifestConversionPlan{ // Anything goes; just use the original as is, do not try any conversions.
 			preferredMIMEType:       srcType,
@@ -82,7 +112,8 @@ func determineManifestConversion(in determineManifestConversionInputs) (manifest
 	}
 	supportedByDest := map[string]struct{}{}
 	for _, t := range destSupportedManifestMIMETypes {
-		if !in.requiresOCIEncryption || manifest.MIMETypeSupportsEncryption(t) {
+		if (!in.requiresOCIEncryption || manifest.MIMETypeSupportsEncryption(t)) &&
+			(!in.requiresOCIAnnotations || t == imgspecv1.MediaTypeImageManifest) {
 			supportedByDest[t] = struct{}{}
 		}
 	}
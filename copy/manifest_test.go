@@ -215,6 +215,80 @@ func TestDetermineManifestConversion(t *testing.T) {
 			otherMIMETypeCandidates:          []string{},
 		}, res, c.description)
 	}
+
+	// With rejectSchema1, schema1 is never used for the destination
+	for _, c := range []struct {
+		description string
+		sourceType  string
+		destTypes   []string
+		expected    manifestConversionPlan
+	}{
+		{
+			"s1→anything", manifest.DockerV2Schema1SignedMediaType, nil,
+			manifestConversionPlan{
+				preferredMIMEType:                manifest.DockerV2Schema2MediaType,
+				preferredMIMETypeNeedsConversion: true,
+				otherMIMETypeCandidates:          []string{v1.MediaTypeImageManifest},
+			},
+		},
+		{
+			"s2→anything", manifest.DockerV2Schema2MediaType, nil,
+			manifestConversionPlan{
+				preferredMIMEType:                manifest.DockerV2Schema2MediaType,
+				preferredMIMETypeNeedsConversion: false,
+				otherMIMETypeCandidates:          []string{},
+			},
+		},
+		{
+			"s1→s1s2", manifest.DockerV2Schema1SignedMediaType, supportS1S2,
+			manifestConversionPlan{
+				preferredMIMEType:                manifest.DockerV2Schema2MediaType,
+				preferredMIMETypeNeedsConversion: true,
+				otherMIMETypeCandidates:          []string{},
+			},
+		},
+		{
+			"special→s1OCI", manifest.DockerV2ListMediaType, supportS1OCI,
+			manifestConversionPlan{
+				preferredMIMEType:                v1.MediaTypeImageManifest,
+				preferredMIMETypeNeedsConversion: true,
+				otherMIMETypeCandidates:          []string{},
+			},
+		},
+	} {
+		res, err := determineManifestConversion(determineManifestConversionInputs{
+			srcMIMEType:                    c.sourceType,
+			destSupportedManifestMIMETypes: c.destTypes,
+			rejectSchema1:                  true,
+		})
+		require.NoError(t, err, c.description)
+		assert.Equal(t, c.expected, res, c.description)
+	}
+	// With rejectSchema1, fail if schema1 would be necessary
+	for _, c := range []determineManifestConversionInputs{
+		{srcMIMEType: manifest.DockerV2Schema2MediaType, destSupportedManifestMIMETypes: supportOnlyS1},
+		{srcMIMEType: manifest.DockerV2Schema2MediaType, forceManifestMIMEType: manifest.DockerV2Schema1SignedMediaType},
+		{srcMIMEType: manifest.DockerV2Schema1SignedMediaType, cannotModifyManifestReason: "Preserving digests"},
+	} {
+		c.rejectSchema1 = true
+		_, err := determineManifestConversion(c)
+		assert.Error(t, err, c)
+	}
+
+	// With requiresOCIAnnotations, a destination accepting anything gets an OCI manifest
+	for _, srcType := range []string{manifest.DockerV2Schema2MediaType, v1.MediaTypeImageManifest} {
+		res, err := determineManifestConversion(determineManifestConversionInputs{
+			srcMIMEType:                    srcType,
+			destSupportedManifestMIMETypes: nil,
+			requiresOCIAnnotations:         true,
+		})
+		require.NoError(t, err, srcType)
+		assert.Equal(t, manifestConversionPlan{
+			preferredMIMEType:                v1.MediaTypeImageManifest,
+			preferredMIMETypeNeedsConversion: srcType != v1.MediaTypeImageManifest,
+			otherMIMETypeCandidates:          []string{},
+		}, res, srcType)
+	}
 }
 
 // fakeUnparsedImage is an implementation of types.UnparsedImage which only returns itself as a MIME type in Manifest,
@@ -0,0 +1,184 @@
+//go:build !containers_image_storage_stub
+// +build !containers_image_storage_stub
+
+package copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/internal/testing/testimage"
+	"github.com/containers/image/v5/manifest"
+	storagetransport "github.com/containers/image/v5/storage"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage"
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/pkg/chunked/compressor"
+	"github.com/containers/storage/pkg/idtools"
+	"github.com/containers/storage/pkg/reexec"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	if reexec.Init() { // Graph drivers re-execute the test binary to apply layers
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// newTestStore returns a new store using the vfs graph driver.
+func newTestStore(t *testing.T) storage.Store {
+	dir := t.TempDir()
+	uidMap := []idtools.IDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	gidMap := []idtools.IDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	store, err := storage.GetStore(storage.StoreOptions{
+		RunRoot:         filepath.Join(dir, "run"),
+		GraphRoot:       filepath.Join(dir, "root"),
+		GraphDriverName: "vfs",
+		UIDMap:          uidMap,
+		GIDMap:          gidMap,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := store.Shutdown(true)
+		assert.NoError(t, err)
+	})
+	return store
+}
+
+// putZstdChunkedTestImage stores an image with a single zstd:chunked layer containing entries in registry, as tag.
+// It returns the digest of the layer blob, its size, and the uncompressed layer.
+func putZstdChunkedTestImage(t *testing.T, registry *testRegistry, tag string, entries ...testimage.Entry) (digest.Digest, int64, []byte) {
+	uncompressed := testimage.Tar(t, entries...)
+	annotations := map[string]string{}
+	var layer bytes.Buffer
+	w, err := compressor.ZstdCompressor(&layer, annotations, nil)
+	require.NoError(t, err)
+	_, err = w.Write(uncompressed)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	layerDigest := digest.FromBytes(layer.Bytes())
+
+	config, err := json.Marshal(imgspecv1.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromBytes(uncompressed)}},
+	})
+	require.NoError(t, err)
+	configDigest := digest.FromBytes(config)
+	man, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configDigest,
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{{
+		MediaType:   imgspecv1.MediaTypeImageLayerZstd,
+		Digest:      layerDigest,
+		Size:        int64(layer.Len()),
+		Annotations: annotations,
+	}}).Serialize()
+	require.NoError(t, err)
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.blobs[layerDigest] = layer.Bytes()
+	registry.blobs[configDigest] = config
+	registry.manifests[tag] = man
+	return layerDigest, int64(layer.Len()), uncompressed
+}
+
+// rangeRequestSize returns the number of bytes requested by a Range header value.
+func rangeRequestSize(t *testing.T, header string) int64 {
+	require.True(t, strings.HasPrefix(header, "bytes="), header)
+	res := int64(0)
+	for _, r := range strings.Split(strings.TrimPrefix(header, "bytes="), ",") {
+		bounds := strings.Split(r, "-")
+		require.Len(t, bounds, 2, header)
+		start, err := strconv.ParseInt(bounds[0], 10, 64)
+		require.NoError(t, err)
+		end, err := strconv.ParseInt(bounds[1], 10, 64)
+		require.NoError(t, err)
+		res += end - start + 1
+	}
+	return res
+}
+
+// TestImagePartialPull tests partial pulls of zstd:chunked layers to containers-storage, using a graph driver (vfs) which
+// relies on the containers-storage destination, not on the graph driver, to find files which are already present:
+// only the parts of the layer blob with other files are fetched from the registry, using Range requests.
+func TestImagePartialPull(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	fileContents := func() string {
+		data := make([]byte, 64*1024)
+		_, err := random.Read(data) // Random, i.e. not compressible, contents
+		require.NoError(t, err)
+		return string(data)
+	}
+	shared1, shared2, only1, only2 := fileContents(), fileContents(), fileContents(), fileContents()
+
+	registry, server := newTestRegistry(t)
+	layer1Digest, layer1Size, _ := putZstdChunkedTestImage(t, registry, "one",
+		testimage.File("shared1", shared1), testimage.File("only1", only1), testimage.File("shared2", shared2))
+	layer2Digest, layer2Size, uncompressed2 := putZstdChunkedTestImage(t, registry, "two",
+		testimage.File("shared1", shared1), testimage.File("only2", only2), testimage.File("shared2", shared2))
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	store := newTestStore(t)
+	sourceCtx := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	for _, tag := range []string{"one", "two"} {
+		srcRef, err := docker.ParseReference("//" + registryURL.Host + "/repo:" + tag)
+		require.NoError(t, err)
+		destRef, err := storagetransport.Transport.ParseStoreReference(store, "localhost/repo:"+tag)
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+			SourceCtx:      sourceCtx,
+			DestinationCtx: &types.SystemContext{BlobInfoCacheDir: t.TempDir()},
+		})
+		require.NoError(t, err, tag)
+	}
+
+	// For each layer, the first request reads the table of contents, and then a single request reads the layer contents
+	// the destination does not already have.
+	registry.mutex.Lock()
+	layer1Requests := registry.blobRequests[layer1Digest]
+	layer2Requests := registry.blobRequests[layer2Digest]
+	registry.mutex.Unlock()
+	require.Len(t, layer1Requests, 2)
+	require.Len(t, layer2Requests, 2)
+	// The first image is pulled into an empty store, so all of its contents are fetched
+	assert.Greater(t, rangeRequestSize(t, layer1Requests[1]), int64(3*64*1024))
+	assert.Less(t, rangeRequestSize(t, layer1Requests[1]), layer1Size)
+	// The second image reuses shared1 and shared2, fetching only only2 and the tar headers.
+	fetched := rangeRequestSize(t, layer2Requests[1])
+	assert.Greater(t, fetched, int64(64*1024))
+	assert.Less(t, fetched, layer2Size-2*64*1024)
+
+	// The layer has been assembled correctly.
+	layers, err := store.LayersByUncompressedDigest(digest.FromBytes(uncompressed2))
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	noCompression := archive.Uncompressed
+	diff, err := store.Diff("", layers[0].ID, &storage.DiffOptions{Compression: &noCompression})
+	require.NoError(t, err)
+	defer diff.Close()
+	layerContents, err := io.ReadAll(diff)
+	require.NoError(t, err)
+	assert.Equal(t, uncompressed2, layerContents)
+}
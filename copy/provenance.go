@@ -0,0 +1,48 @@
+package copy
+
+import (
+	"fmt"
+
+	"github.com/containers/image/v5/manifest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// mergeProvenanceAnnotations returns a copy of annotations (which may be nil) with c.provenanceAnnotations added.
+func (c *copier) mergeProvenanceAnnotations(annotations map[string]string) map[string]string {
+	res := make(map[string]string, len(annotations)+len(c.provenanceAnnotations))
+	for k, v := range annotations {
+		res[k] = v
+	}
+	for k, v := range c.provenanceAnnotations {
+		if existing, ok := res[k]; ok && existing != v && !c.overwriteProvenanceAnnotations {
+			logrus.Debugf("Not overwriting existing annotation %q=%q with %q", k, existing, v)
+			continue
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// addProvenanceAnnotationsToManifest returns man, a manifest with manMIMEType, with c.provenanceAnnotations added.
+func (c *copier) addProvenanceAnnotationsToManifest(man []byte, manMIMEType string) ([]byte, error) {
+	if manMIMEType != imgspecv1.MediaTypeImageManifest {
+		return nil, fmt.Errorf("adding provenance annotations to a manifest of type %q is not supported", manMIMEType)
+	}
+	m, err := manifest.OCI1FromManifest(man)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest to add provenance annotations: %w", err)
+	}
+	m.Annotations = c.mergeProvenanceAnnotations(m.Annotations)
+	return m.Serialize()
+}
+
+// addProvenanceAnnotationsToList adds c.provenanceAnnotations to list.
+func (c *copier) addProvenanceAnnotationsToList(list manifest.List) error {
+	index, ok := list.(*manifest.OCI1Index)
+	if !ok {
+		return fmt.Errorf("adding provenance annotations to a manifest list of type %q is not supported", list.MIMEType())
+	}
+	index.Annotations = c.mergeProvenanceAnnotations(index.Annotations)
+	return nil
+}
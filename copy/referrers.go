@@ -0,0 +1,135 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// copyReferrers copies the artifacts which refer to the manifest of src (using the OCI "subject" field) from c.rawSource to c.dest.
+// copiedManifest is the manifest of src as written to c.dest; it must not differ from the source, otherwise the artifacts
+// would refer to a manifest which does not exist in the destination. The copy has already ensured that, by not modifying
+// the manifests of the image.
+func (c *copier) copyReferrers(ctx context.Context, src *image.UnparsedImage, copiedManifest []byte) error {
+	referrersSource, ok := c.rawSource.(private.ImageSourceWithReferrers)
+	if !ok {
+		return errors.New("Internal error: copyReferrers called for a source which does not support listing referrers")
+	}
+	srcManifest, _, err := src.Manifest(ctx)
+	if err != nil {
+		return err
+	}
+	srcDigest, err := manifest.Digest(srcManifest)
+	if err != nil {
+		return fmt.Errorf("computing digest of the source manifest: %w", err)
+	}
+	if !bytes.Equal(srcManifest, copiedManifest) { // Coverage: This should never happen, the copy does not modify the manifest.
+		return fmt.Errorf("Internal error: the manifest %s was modified during the copy, so referrers can't be copied", srcDigest.String())
+	}
+
+	referrers, err := referrersSource.GetReferrers(ctx, srcDigest, "")
+	if err != nil {
+		return fmt.Errorf("listing referrers of %s: %w", srcDigest.String(), err)
+	}
+	if len(referrers) == 0 {
+		return nil
+	}
+	c.Printf("Copying %d referrers\n", len(referrers))
+	for _, desc := range referrers {
+		logrus.Debugf("Copying referrer %s of %s", desc.Digest.String(), srcDigest.String())
+		if err := c.copyReferrer(ctx, desc); err != nil {
+			return fmt.Errorf("copying referrer %s: %w", desc.Digest.String(), err)
+		}
+	}
+	return nil
+}
+
+// copyReferrer copies the artifact manifest described by desc, along with its config and layers, from c.rawSource to c.dest.
+func (c *copier) copyReferrer(ctx context.Context, desc imgspecv1.Descriptor) error {
+	manifestBlob, mimeType, err := c.rawSource.GetManifest(ctx, &desc.Digest)
+	if err != nil {
+		return err
+	}
+	matches, err := manifest.MatchesDigest(manifestBlob, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("computing digest of the manifest: %w", err)
+	}
+	if !matches {
+		return fmt.Errorf("manifest does not match expected digest %s", desc.Digest.String())
+	}
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(manifestBlob)
+	}
+	if manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+		return fmt.Errorf("unsupported manifest type %q", mimeType)
+	}
+	m, err := manifest.OCI1FromManifest(manifestBlob)
+	if err != nil {
+		return err
+	}
+
+	if err := c.copyReferrerBlob(ctx, m.Config, true); err != nil {
+		return err
+	}
+	for _, layer := range m.Layers {
+		if err := c.copyReferrerBlob(ctx, layer, false); err != nil {
+			return err
+		}
+	}
+	dest, ok := c.dest.(private.ImageDestinationWithReferrers)
+	if !ok { // Coverage: This should never happen, Image has checked this.
+		return errors.New("Internal error: writing referrers is not supported by the destination")
+	}
+	if err := dest.PutReferrerManifest(ctx, manifestBlob, desc.Digest); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return nil
+}
+
+// copyReferrerBlob copies the blob described by desc, unmodified, from c.rawSource to c.dest, unless it already exists there.
+func (c *copier) copyReferrerBlob(ctx context.Context, desc imgspecv1.Descriptor, isConfig bool) error {
+	srcInfo := types.BlobInfo{
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+		MediaType: desc.MediaType,
+	}
+	reused, _, err := c.dest.TryReusingBlobWithOptions(ctx, srcInfo, private.TryReusingBlobOptions{
+		Cache:         c.blobInfoCache,
+		CanSubstitute: false,
+		SrcRef:        c.rawSource.Reference().DockerReference(),
+	})
+	if err != nil {
+		return fmt.Errorf("trying to reuse blob %s at destination: %w", desc.Digest.String(), err)
+	}
+	if reused {
+		return nil
+	}
+
+	srcStream, _, err := c.rawSource.GetBlob(ctx, srcInfo, c.blobInfoCache)
+	if err != nil {
+		return fmt.Errorf("reading blob %s: %w", desc.Digest.String(), err)
+	}
+	defer srcStream.Close()
+	digestingReader, err := newDigestingReader(srcStream, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("preparing to verify blob %s: %w", desc.Digest.String(), err)
+	}
+	if _, err := c.dest.PutBlobWithOptions(ctx, digestingReader, srcInfo, private.PutBlobOptions{
+		Cache:    c.blobInfoCache,
+		IsConfig: isConfig,
+	}); err != nil {
+		return fmt.Errorf("writing blob %s: %w", desc.Digest.String(), err)
+	}
+	if digestingReader.validationFailed { // Coverage: This should never happen, PutBlobWithOptions should have failed.
+		return fmt.Errorf("Internal error writing blob %s, digest verification failed but was ignored", desc.Digest.String())
+	}
+	return nil
+}
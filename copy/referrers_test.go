@@ -0,0 +1,123 @@
+package copy
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageCopyReferrers(t *testing.T) {
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	sbom := &SBOM{
+		MediaType: "application/spdx+json",
+		Data:      []byte(`{"spdxVersion":"SPDX-2.3"}`),
+	}
+	dirRef, _, _ := newTestDirImage(t, "layer")
+
+	// Create an OCI layout containing an image and an SBOM referring to it.
+	srcRef, err := layout.NewReference(t.TempDir(), "latest")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), srcRef, dirRef, &Options{
+		ForceManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+		SBOM:                  sbom,
+	})
+	require.NoError(t, err)
+
+	registry, server := newTestRegistry(t)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:latest")
+	require.NoError(t, err)
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		DestinationCtx: sys,
+		CopyReferrers:  true,
+	})
+	require.NoError(t, err)
+	copiedDigest := digest.FromBytes(copiedManifest)
+
+	var artifactDigest digest.Digest
+	for ref, blob := range registry.manifests {
+		var m ociArtifactManifest
+		require.NoError(t, json.Unmarshal(blob, &m))
+		if m.Subject == nil {
+			continue
+		}
+		assert.Equal(t, artifactDigest, digest.Digest(""), "multiple artifacts")
+		artifactDigest = digest.Digest(ref)
+		assert.Equal(t, digest.FromBytes(blob), artifactDigest)
+		assert.Equal(t, copiedDigest, m.Subject.Digest)
+		assert.Equal(t, sbom.MediaType, m.ArtifactType)
+		assert.Equal(t, ociEmptyBlob, registry.blobs[m.Config.Digest])
+		require.Len(t, m.Layers, 1)
+		assert.Equal(t, sbom.Data, registry.blobs[m.Layers[0].Digest])
+	}
+	require.NotEmpty(t, artifactDigest)
+
+	referrersTag := strings.Replace(copiedDigest.String(), ":", "-", 1)
+	indexBlob, ok := registry.manifests[referrersTag]
+	require.True(t, ok)
+	var index imgspecv1.Index
+	require.NoError(t, json.Unmarshal(indexBlob, &index))
+	require.Len(t, index.Manifests, 1)
+	assert.Equal(t, artifactDigest, index.Manifests[0].Digest)
+
+	// The manifest is not converted, as if it were signed.
+	convertedRef, err := docker.ParseReference("//" + registryURL.Host + "/converted:latest")
+	require.NoError(t, err)
+	convertedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), convertedRef, srcRef, &Options{
+		DestinationCtx:        sys,
+		CopyReferrers:         true,
+		ForceManifestMIMEType: manifest.DockerV2Schema2MediaType,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, copiedManifest, convertedManifest)
+
+	// Modifying the manifest is rejected before copying any layers.
+	decompressedRef, err := docker.ParseReference("//" + registryURL.Host + "/decompressed:latest")
+	require.NoError(t, err)
+	registry.mutex.Lock()
+	blobs := len(registry.blobs)
+	registry.mutex.Unlock()
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), decompressedRef, srcRef, &Options{
+		DestinationCtx:   sys,
+		CopyReferrers:    true,
+		DecompressLayers: true,
+	})
+	assert.ErrorContains(t, err, "Would invalidate referrers")
+	registry.mutex.Lock()
+	assert.Equal(t, blobs, len(registry.blobs))
+	registry.mutex.Unlock()
+
+	// Sources which can't list referrers, and destinations which can't store them, are rejected.
+	for _, c := range []struct {
+		src, dest types.ImageReference // dest == nil means a new OCI layout
+		options   *Options
+	}{
+		{dirRef, nil, &Options{CopyReferrers: true}},
+		{srcRef, dirRef, &Options{CopyReferrers: true}},
+	} {
+		destRef := c.dest
+		if destRef == nil {
+			destRef, err = layout.NewReference(t.TempDir(), "latest")
+			require.NoError(t, err)
+		}
+		_, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, c.src, c.options)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,122 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// SBOM is a software bill of materials to attach to the copied image, see Options.SBOM.
+type SBOM struct {
+	MediaType   string            // The media type of Data, e.g. "application/spdx+json"; also used as the artifact type.
+	Data        []byte            // The SBOM document
+	Annotations map[string]string // Annotations to add to the artifact manifest, if any.
+}
+
+const (
+	// ociEmptyMediaType is the media type of the empty JSON blob used as the config of artifacts which have no config.
+	ociEmptyMediaType = "application/vnd.oci.empty.v1+json"
+)
+
+// ociEmptyBlob is the content of the empty JSON blob with ociEmptyMediaType.
+var ociEmptyBlob = []byte("{}")
+
+// ociArtifactManifest is an OCI image manifest used to store an artifact.
+// It differs from imgspecv1.Manifest by including the artifactType field.
+type ociArtifactManifest struct {
+	imgspecv1.Manifest
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// validateSBOM returns an error if sbom is not valid, or can't be written to c.dest.
+func (c *copier) validateSBOM(sbom *SBOM) error {
+	if sbom.MediaType == "" {
+		return errors.New("the media type of the SBOM is not set")
+	}
+	if len(sbom.Data) == 0 {
+		return errors.New("the SBOM is empty")
+	}
+	if _, ok := c.dest.(private.ImageDestinationWithReferrers); !ok {
+		return fmt.Errorf("attaching an SBOM is not supported by %s", transports.ImageName(c.dest.Reference()))
+	}
+	return nil
+}
+
+// attachSBOM writes sbom to c.dest as an OCI artifact with subject, the copied manifest, which has subjectDigest.
+func (c *copier) attachSBOM(ctx context.Context, sbom *SBOM, subject []byte, subjectDigest digest.Digest) error {
+	dest, ok := c.dest.(private.ImageDestinationWithReferrers)
+	if !ok { // Coverage: This should never happen, validateSBOM has checked this.
+		return errors.New("Internal error: attaching an SBOM is not supported by the destination")
+	}
+	c.Printf("Attaching SBOM of type %s\n", sbom.MediaType)
+
+	configDesc, err := c.putArtifactBlob(ctx, ociEmptyBlob, ociEmptyMediaType, true)
+	if err != nil {
+		return err
+	}
+	sbomDesc, err := c.putArtifactBlob(ctx, sbom.Data, sbom.MediaType, false)
+	if err != nil {
+		return err
+	}
+	artifact := ociArtifactManifest{
+		Manifest: imgspecv1.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: imgspecv1.MediaTypeImageManifest,
+			Config:    configDesc,
+			Layers:    []imgspecv1.Descriptor{sbomDesc},
+			Subject: &imgspecv1.Descriptor{
+				MediaType: manifest.GuessMIMEType(subject),
+				Digest:    subjectDigest,
+				Size:      int64(len(subject)),
+			},
+			Annotations: sbom.Annotations,
+		},
+		ArtifactType: sbom.MediaType,
+	}
+	artifactBlob, err := json.Marshal(artifact)
+	if err != nil {
+		return err
+	}
+	artifactDigest, err := manifest.DigestWithAlgorithm(artifactBlob, c.digestAlgorithm)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Writing SBOM manifest %s referring to %s", artifactDigest.String(), subjectDigest.String())
+	if err := dest.PutReferrerManifest(ctx, artifactBlob, artifactDigest); err != nil {
+		return fmt.Errorf("writing SBOM manifest: %w", err)
+	}
+	return nil
+}
+
+// putArtifactBlob writes contents, with mimeType, to c.dest, and returns an OCI descriptor for it.
+func (c *copier) putArtifactBlob(ctx context.Context, contents []byte, mimeType string, isConfig bool) (imgspecv1.Descriptor, error) {
+	blobDigest := c.digestAlgorithm.FromBytes(contents)
+	info, err := c.dest.PutBlobWithOptions(ctx, bytes.NewReader(contents), types.BlobInfo{
+		Digest:    blobDigest,
+		Size:      int64(len(contents)),
+		MediaType: mimeType,
+	}, private.PutBlobOptions{
+		Cache:           c.blobInfoCache,
+		IsConfig:        isConfig,
+		DigestAlgorithm: c.digestAlgorithm,
+	})
+	if err != nil {
+		return imgspecv1.Descriptor{}, fmt.Errorf("writing blob %s: %w", blobDigest.String(), err)
+	}
+	return imgspecv1.Descriptor{
+		MediaType: mimeType,
+		Digest:    info.Digest,
+		Size:      info.Size,
+	}, nil
+}
@@ -0,0 +1,145 @@
+package copy
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/archive"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageSBOM(t *testing.T) {
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	sbom := &SBOM{
+		MediaType:   "application/spdx+json",
+		Data:        []byte(`{"spdxVersion":"SPDX-2.3"}`),
+		Annotations: map[string]string{"org.example.sbom": "yes"},
+	}
+	srcRef, _, _ := newTestDirImage(t, "layer")
+
+	for _, supportsReferrers := range []bool{false, true} {
+		registry, server := newTestRegistry(t)
+		registry.supportsReferrers = supportsReferrers
+		registryURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		destRef, err := docker.ParseReference("//" + registryURL.Host + "/img:latest")
+		require.NoError(t, err)
+
+		// Copying twice does not duplicate the referrers index entry.
+		for i := 0; i < 2; i++ {
+			_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+				DestinationCtx: sys,
+				SBOM:           sbom,
+			})
+			require.NoError(t, err, supportsReferrers)
+		}
+
+		copiedDigest := digest.FromBytes(registry.manifests["latest"])
+		var artifactDigest digest.Digest
+		for ref, blob := range registry.manifests {
+			var m ociArtifactManifest
+			require.NoError(t, json.Unmarshal(blob, &m))
+			if m.Subject == nil {
+				continue
+			}
+			assert.Equal(t, artifactDigest, digest.Digest(""), "multiple artifacts")
+			artifactDigest = digest.Digest(ref)
+			assert.Equal(t, digest.FromBytes(blob), artifactDigest)
+			assert.Equal(t, copiedDigest, m.Subject.Digest)
+			assert.Equal(t, sbom.MediaType, m.ArtifactType)
+			assert.Equal(t, sbom.Annotations, m.Annotations)
+			assert.Equal(t, ociEmptyMediaType, m.Config.MediaType)
+			assert.Equal(t, ociEmptyBlob, registry.blobs[m.Config.Digest])
+			require.Len(t, m.Layers, 1)
+			assert.Equal(t, sbom.MediaType, m.Layers[0].MediaType)
+			assert.Equal(t, sbom.Data, registry.blobs[m.Layers[0].Digest])
+		}
+		require.NotEmpty(t, artifactDigest, supportsReferrers)
+
+		referrersTag := strings.Replace(copiedDigest.String(), ":", "-", 1)
+		indexBlob, ok := registry.manifests[referrersTag]
+		if supportsReferrers {
+			assert.False(t, ok)
+			continue
+		}
+		require.True(t, ok)
+		var index imgspecv1.Index
+		require.NoError(t, json.Unmarshal(indexBlob, &index))
+		assert.Equal(t, imgspecv1.MediaTypeImageIndex, index.MediaType)
+		require.Len(t, index.Manifests, 1)
+		assert.Equal(t, artifactDigest, index.Manifests[0].Digest)
+		assert.Equal(t, imgspecv1.MediaTypeImageManifest, index.Manifests[0].MediaType)
+		assert.Equal(t, sbom.MediaType, index.Manifests[0].ArtifactType)
+		assert.Equal(t, sbom.Annotations, index.Manifests[0].Annotations)
+	}
+
+	// In an OCI layout, the artifact is listed in index.json without replacing the image, and uses the preferred digest algorithm.
+	layoutDir := t.TempDir()
+	layoutRef, err := layout.NewReference(layoutDir, "latest")
+	require.NoError(t, err)
+	copiedManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), layoutRef, srcRef, &Options{
+		DestinationCtx: &types.SystemContext{DigestAlgorithm: digest.SHA512},
+		SBOM:           sbom,
+	})
+	require.NoError(t, err)
+	indexBlob, err := os.ReadFile(filepath.Join(layoutDir, "index.json"))
+	require.NoError(t, err)
+	var index imgspecv1.Index
+	require.NoError(t, json.Unmarshal(indexBlob, &index))
+	require.Len(t, index.Manifests, 2)
+	assert.Equal(t, "latest", index.Manifests[0].Annotations[imgspecv1.AnnotationRefName])
+	copiedDigest := index.Manifests[0].Digest
+	assert.Equal(t, digest.SHA512.FromBytes(copiedManifest), copiedDigest)
+	artifactDesc := index.Manifests[1]
+	assert.Equal(t, sbom.MediaType, artifactDesc.ArtifactType)
+	readLayoutBlob := func(d digest.Digest) []byte {
+		blob, err := os.ReadFile(filepath.Join(layoutDir, "blobs", d.Algorithm().String(), d.Encoded()))
+		require.NoError(t, err)
+		assert.Equal(t, d, d.Algorithm().FromBytes(blob))
+		return blob
+	}
+	var artifact ociArtifactManifest
+	require.NoError(t, json.Unmarshal(readLayoutBlob(artifactDesc.Digest), &artifact))
+	require.NotNil(t, artifact.Subject)
+	assert.Equal(t, copiedDigest, artifact.Subject.Digest)
+	require.Len(t, artifact.Layers, 1)
+	for _, d := range []digest.Digest{artifactDesc.Digest, artifact.Config.Digest, artifact.Layers[0].Digest} {
+		assert.Equal(t, digest.SHA512, d.Algorithm())
+	}
+	assert.Equal(t, sbom.Data, readLayoutBlob(artifact.Layers[0].Digest))
+
+	// Invalid SBOMs, and destinations which can't store them, are rejected.
+	archiveRef, err := archive.ParseReference(filepath.Join(t.TempDir(), "archive.tar"))
+	require.NoError(t, err)
+	for _, c := range []struct {
+		dest types.ImageReference
+		sbom *SBOM
+	}{
+		{nil, &SBOM{Data: sbom.Data}},
+		{nil, &SBOM{MediaType: sbom.MediaType}},
+		{archiveRef, sbom},
+	} {
+		destRef := c.dest
+		if destRef == nil {
+			destRef, _, _ = newTestDirImage(t)
+		}
+		_, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{SBOM: c.sbom})
+		assert.Error(t, err)
+	}
+}
@@ -37,8 +37,47 @@ func (c *copier) sourceSignatures(ctx context.Context, unparsed private.Unparsed
 	return sigs, nil
 }
 
+// createSignatures creates new signatures of manifest, as requested by options.
+// If options.SkipSigningIfSourceSigned, signatures which would be redundant with existingSigs, the signatures copied
+// from the source, are not created.
+func (c *copier) createSignatures(manifest []byte, existingSigs []internalsig.Signature, options *Options) ([]internalsig.Signature, error) {
+	if !options.SkipSigningIfSourceSigned {
+		existingSigs = nil
+	}
+	res := []internalsig.Signature{}
+	if options.SignBy != "" {
+		newSig, err := c.createSignature(manifest, options.SignBy, options.SignPassphrase, options.SignIdentity, existingSigs)
+		if err != nil {
+			return nil, err
+		}
+		if newSig != nil {
+			res = append(res, newSig)
+		}
+	}
+	if options.SignBySigstorePrivateKeyFile != "" {
+		newSig, err := c.createSigstoreSignature(manifest, options.SignBySigstorePrivateKeyFile, options.SignSigstorePrivateKeyPassphrase, options.SignIdentity, existingSigs)
+		if err != nil {
+			return nil, err
+		}
+		if newSig != nil {
+			res = append(res, newSig)
+		}
+	}
+	for _, signer := range options.Signers {
+		newSig, err := c.createSignatureWithSigner(manifest, signer, options.SignIdentity, existingSigs)
+		if err != nil {
+			return nil, err
+		}
+		if newSig != nil {
+			res = append(res, newSig)
+		}
+	}
+	return res, nil
+}
+
 // createSignature creates a new signature of manifest using keyIdentity.
-func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase string, identity reference.Named) (internalsig.Signature, error) {
+// If one of existingSigs is already a valid signature of manifest by keyIdentity, it returns nil instead.
+func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase string, identity reference.Named, existingSigs []internalsig.Signature) (internalsig.Signature, error) {
 	mech, err := signature.NewGPGSigningMechanism()
 	if err != nil {
 		return nil, fmt.Errorf("initializing GPG: %w", err)
@@ -47,28 +86,26 @@ func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase
 	if err := mech.SupportsSigning(); err != nil {
 		return nil, fmt.Errorf("Signing not supported: %w", err)
 	}
-
-	if identity != nil {
-		if reference.IsNameOnly(identity) {
-			return nil, fmt.Errorf("Sign identity must be a fully specified reference %s", identity)
-		}
-	} else {
-		identity = c.dest.Reference().DockerReference()
-		if identity == nil {
-			return nil, fmt.Errorf("Cannot determine canonical Docker reference for destination %s", transports.ImageName(c.dest.Reference()))
-		}
+	signer, err := signature.NewGPGSigner(mech, keyIdentity, passphrase)
+	if err != nil {
+		return nil, err
 	}
+	return c.createSignatureWithSigner(manifest, signer, identity, existingSigs)
+}
 
-	c.Printf("Signing manifest using simple signing\n")
-	newSig, err := signature.SignDockerManifestWithOptions(manifest, identity.String(), mech, keyIdentity, &signature.SignOptions{Passphrase: passphrase})
+// createSigstoreSignature creates a new sigstore signature of manifest using privateKeyFile and identity.
+// If one of existingSigs is already a valid signature of manifest by the same key, it returns nil instead.
+func (c *copier) createSigstoreSignature(manifest []byte, privateKeyFile string, passphrase []byte, identity reference.Named, existingSigs []internalsig.Signature) (internalsig.Signature, error) {
+	signer, err := sigstore.NewPrivateKeyFileSigner(privateKeyFile, passphrase)
 	if err != nil {
-		return nil, fmt.Errorf("creating signature: %w", err)
+		return nil, err
 	}
-	return internalsig.SimpleSigningFromBlob(newSig), nil
+	return c.createSignatureWithSigner(manifest, signer, identity, existingSigs)
 }
 
-// createSigstoreSignature creates a new sigstore signature of manifest using privateKeyFile and identity.
-func (c *copier) createSigstoreSignature(manifest []byte, privateKeyFile string, passphrase []byte, identity reference.Named) (internalsig.Signature, error) {
+// createSignatureWithSigner creates a new signature of manifest using signer and identity.
+// If one of existingSigs is already a valid signature of manifest by signer, for the same identity, it returns nil instead.
+func (c *copier) createSignatureWithSigner(manifest []byte, signer signature.Signer, identity reference.Named, existingSigs []internalsig.Signature) (internalsig.Signature, error) {
 	if identity != nil {
 		if reference.IsNameOnly(identity) {
 			return nil, fmt.Errorf("Sign identity must be a fully specified reference %s", identity.String())
@@ -80,8 +117,24 @@ func (c *copier) createSigstoreSignature(manifest []byte, privateKeyFile string,
 		}
 	}
 
-	c.Printf("Signing manifest using a sigstore signature\n")
-	newSig, err := sigstore.SignDockerManifestWithPrivateKeyFileUnstable(manifest, identity, privateKeyFile, passphrase)
+	for _, sig := range existingSigs {
+		signed, err := signature.DockerManifestSignedBySignerUnstable(sig, manifest, identity, signer)
+		if err != nil {
+			return nil, fmt.Errorf("checking existing signatures: %w", err)
+		}
+		if signed {
+			c.Printf("Skipping signing using %s, the image is already signed\n", signer.KeyIdentity())
+			return nil, nil
+		}
+	}
+
+	switch signer.Format() {
+	case signature.SimpleSigningSignerFormat:
+		c.Printf("Signing manifest using simple signing\n")
+	case signature.SigstoreSignerFormat:
+		c.Printf("Signing manifest using a sigstore signature\n")
+	}
+	newSig, err := signature.SignDockerManifestWithSignerUnstable(manifest, identity, signer)
 	if err != nil {
 		return nil, fmt.Errorf("creating signature: %w", err)
 	}
@@ -2,6 +2,8 @@ package copy
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"io"
 	"testing"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/containers/image/v5/docker"
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/imagedestination"
+	"github.com/containers/image/v5/internal/imagesource"
 	internalsig "github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/signature"
@@ -49,7 +52,7 @@ func TestCreateSignature(t *testing.T) {
 		dest:         imagedestination.FromPublic(dirDest),
 		reportWriter: io.Discard,
 	}
-	_, err = c.createSignature(manifestBlob, testKeyFingerprint, "", nil)
+	_, err = c.createSignature(manifestBlob, testKeyFingerprint, "", nil, nil)
 	assert.Error(t, err)
 
 	// Set up a docker: reference
@@ -65,13 +68,13 @@ func TestCreateSignature(t *testing.T) {
 	}
 
 	// Signing with an unknown key fails
-	_, err = c.createSignature(manifestBlob, "this key does not exist", "", nil)
+	_, err = c.createSignature(manifestBlob, "this key does not exist", "", nil, nil)
 	assert.Error(t, err)
 
 	// Can't sign without a full reference
 	ref, err := reference.ParseNamed("myregistry.io/myrepo")
 	require.NoError(t, err)
-	_, err = c.createSignature(manifestBlob, testKeyFingerprint, "", ref)
+	_, err = c.createSignature(manifestBlob, testKeyFingerprint, "", ref, nil)
 	assert.Error(t, err)
 
 	// Mechanism for verifying the signatures
@@ -80,7 +83,7 @@ func TestCreateSignature(t *testing.T) {
 	defer mech.Close()
 
 	// Signing without overriding the identity uses the docker reference
-	sig, err := c.createSignature(manifestBlob, testKeyFingerprint, "", nil)
+	sig, err := c.createSignature(manifestBlob, testKeyFingerprint, "", nil, nil)
 	require.NoError(t, err)
 	simpleSig, ok := sig.(internalsig.SimpleSigning)
 	require.True(t, ok)
@@ -92,7 +95,7 @@ func TestCreateSignature(t *testing.T) {
 	// Can override the identity with own
 	ref, err = reference.ParseNamed("myregistry.io/myrepo:mytag")
 	require.NoError(t, err)
-	sig, err = c.createSignature(manifestBlob, testKeyFingerprint, "", ref)
+	sig, err = c.createSignature(manifestBlob, testKeyFingerprint, "", ref, nil)
 	require.NoError(t, err)
 	simpleSig, ok = sig.(internalsig.SimpleSigning)
 	require.True(t, ok)
@@ -101,3 +104,157 @@ func TestCreateSignature(t *testing.T) {
 	assert.Equal(t, "myregistry.io/myrepo:mytag", verified.DockerReference)
 	assert.Equal(t, manifestDigest, verified.DockerManifestDigest)
 }
+
+// fakeSigner is a signature.Signer creating fake sigstore signatures, recording the payloads it has signed.
+type fakeSigner struct {
+	key      string // Signatures by fakeSigners with different keys are distinct
+	payloads [][]byte
+}
+
+func (s *fakeSigner) Format() signature.SignerFormat {
+	return signature.SigstoreSignerFormat
+}
+
+func (s *fakeSigner) KeyIdentity() string {
+	return "fake"
+}
+
+func (s *fakeSigner) Sign(payload []byte) ([]byte, error) {
+	s.payloads = append(s.payloads, payload)
+	return []byte("fake signature" + s.key), nil
+}
+
+func (s *fakeSigner) Verify(unverifiedSignature, unverifiedPayload []byte) ([]byte, error) {
+	if string(unverifiedSignature) != "fake signature"+s.key {
+		return nil, errors.New("fake signature mismatch")
+	}
+	return unverifiedPayload, nil
+}
+
+func TestImageWithSigners(t *testing.T) {
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	signIdentity, err := reference.ParseNormalizedNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+
+	// A dir: destination has no Docker reference, so we can’t sign without an explicit identity.
+	signer := &fakeSigner{}
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{Signers: []signature.Signer{signer}})
+	assert.Error(t, err)
+
+	manifestBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), destRef, srcRef, &Options{
+		Signers:      []signature.Signer{signer},
+		SignIdentity: signIdentity,
+	})
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+	require.Len(t, signer.payloads, 1)
+	assert.Contains(t, string(signer.payloads[0]), manifestDigest.String())
+	assert.Contains(t, string(signer.payloads[0]), signIdentity.String())
+
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	sigs, err := imagesource.FromPublic(src).GetSignaturesWithFormat(context.Background(), nil)
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+	sigstoreSig, ok := sigs[0].(internalsig.Sigstore)
+	require.True(t, ok)
+	assert.Equal(t, signer.payloads[0], sigstoreSig.UntrustedPayload())
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("fake signature")),
+		sigstoreSig.UntrustedAnnotations()[internalsig.SigstoreSignatureAnnotationKey])
+}
+
+// destSignatures returns the signatures stored in ref.
+func destSignatures(t *testing.T, ref types.ImageReference) []internalsig.Signature {
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	sigs, err := imagesource.FromPublic(src).GetSignaturesWithFormat(context.Background(), nil)
+	require.NoError(t, err)
+	return sigs
+}
+
+func TestImageSkipSigningIfSourceSigned(t *testing.T) {
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	signIdentity, err := reference.ParseNormalizedNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+	otherIdentity, err := reference.ParseNormalizedNamed("example.com/ns/repo:other")
+	require.NoError(t, err)
+
+	signer := &fakeSigner{}
+	signedRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), signedRef, srcRef, &Options{
+		Signers:                   []signature.Signer{signer},
+		SignIdentity:              signIdentity,
+		SkipSigningIfSourceSigned: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, signer.payloads, 1)
+	require.Len(t, destSignatures(t, signedRef), 1)
+
+	for _, c := range []struct {
+		name         string
+		signer       *fakeSigner
+		identity     reference.Named
+		skip         bool
+		expectedSigs int
+	}{
+		{"already signed", signer, signIdentity, true, 1},
+		{"option not set", signer, signIdentity, false, 2},
+		{"different identity", signer, otherIdentity, true, 2},
+		{"different key", &fakeSigner{key: "2"}, signIdentity, true, 2},
+	} {
+		signedPayloads := len(c.signer.payloads)
+		destRef, err := directory.NewReference(t.TempDir())
+		require.NoError(t, err)
+		_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, signedRef, &Options{
+			Signers:                   []signature.Signer{c.signer},
+			SignIdentity:              c.identity,
+			SkipSigningIfSourceSigned: c.skip,
+		})
+		require.NoError(t, err, c.name)
+		sigs := destSignatures(t, destRef)
+		assert.Len(t, sigs, c.expectedSigs, c.name)
+		assert.Len(t, c.signer.payloads, signedPayloads+c.expectedSigs-1, c.name)
+	}
+
+	// A signer which can’t verify signatures is rejected.
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, signedRef, &Options{
+		Signers:                   []signature.Signer{struct{ signature.Signer }{signer}},
+		SignIdentity:              signIdentity,
+		SkipSigningIfSourceSigned: true,
+	})
+	assert.Error(t, err)
+
+	// Simple signing, using GPG
+	mech, err := signature.NewGPGSigningMechanism()
+	require.NoError(t, err)
+	defer mech.Close()
+	if err := mech.SupportsSigning(); err != nil {
+		t.Skipf("Signing not supported: %v", err)
+	}
+	t.Setenv("GNUPGHOME", testGPGHomeDirectory)
+	gpgSignedRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), gpgSignedRef, srcRef, &Options{
+		SignBy:       testKeyFingerprint,
+		SignIdentity: signIdentity,
+	})
+	require.NoError(t, err)
+	require.Len(t, destSignatures(t, gpgSignedRef), 1)
+	destRef, err = directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), destRef, gpgSignedRef, &Options{
+		SignBy:                    testKeyFingerprint,
+		SignIdentity:              signIdentity,
+		SkipSigningIfSourceSigned: true,
+	})
+	require.NoError(t, err)
+	assert.Len(t, destSignatures(t, destRef), 1)
+}
@@ -0,0 +1,174 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/v5/internal/imagedestination"
+	"github.com/containers/image/v5/internal/imagesource"
+	"github.com/containers/image/v5/internal/private"
+	internalsig "github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// SignaturesOptions allows supplying non-default configuration modifying the behavior of Signatures.
+type SignaturesOptions struct {
+	ReportWriter   io.Writer
+	SourceCtx      *types.SystemContext
+	DestinationCtx *types.SystemContext
+}
+
+// Signatures copies the signatures of the image at srcRef to destRef, without copying the image itself;
+// destRef must already contain an image with the same manifest digest (e.g. if the image has been pushed there by a third party).
+// Both simple signing and sigstore signatures are copied, and signatures already present at destRef are preserved.
+// Only signatures of the top-level manifest are copied; with manifest lists, signatures of individual instances are not.
+// The destination transport must support adding signatures to an existing image; currently, only docker: does.
+func Signatures(ctx context.Context, destRef, srcRef types.ImageReference, options *SignaturesOptions) (retErr error) {
+	if options == nil {
+		options = &SignaturesOptions{}
+	}
+	reportWriter := io.Discard
+	if options.ReportWriter != nil {
+		reportWriter = options.ReportWriter
+	}
+
+	publicRawSource, err := srcRef.NewImageSource(ctx, options.SourceCtx)
+	if err != nil {
+		return fmt.Errorf("initializing source %s: %w", transports.ImageName(srcRef), err)
+	}
+	rawSource := imagesource.FromPublic(publicRawSource)
+	defer func() {
+		if err := rawSource.Close(); err != nil {
+			if retErr != nil {
+				retErr = fmt.Errorf(" (src: %v): %w", err, retErr)
+			} else {
+				retErr = fmt.Errorf(" (src: %v)", err)
+			}
+		}
+	}()
+	srcDigest, err := topLevelManifestDigest(ctx, rawSource)
+	if err != nil {
+		return fmt.Errorf("reading manifest of %s: %w", transports.ImageName(srcRef), err)
+	}
+
+	publicDestSource, err := destRef.NewImageSource(ctx, options.DestinationCtx)
+	if err != nil {
+		return fmt.Errorf("initializing %s for reading: %w", transports.ImageName(destRef), err)
+	}
+	destSource := imagesource.FromPublic(publicDestSource)
+	defer func() {
+		if err := destSource.Close(); err != nil {
+			if retErr != nil {
+				retErr = fmt.Errorf(" (dest: %v): %w", err, retErr)
+			} else {
+				retErr = fmt.Errorf(" (dest: %v)", err)
+			}
+		}
+	}()
+	destDigest, err := topLevelManifestDigest(ctx, destSource)
+	if err != nil {
+		return fmt.Errorf("reading manifest of %s: %w", transports.ImageName(destRef), err)
+	}
+	if destDigest != srcDigest {
+		return fmt.Errorf("Manifest digest %s of %s does not match manifest digest %s of %s",
+			destDigest.String(), transports.ImageName(destRef), srcDigest.String(), transports.ImageName(srcRef))
+	}
+
+	fmt.Fprintf(reportWriter, "Getting image source signatures\n")
+	srcSigs, err := rawSource.GetSignaturesWithFormat(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("reading signatures: %w", err)
+	}
+	if len(srcSigs) == 0 {
+		fmt.Fprintf(reportWriter, "No signatures to copy\n")
+		return nil
+	}
+	existingSigs, err := destSource.GetSignaturesWithFormat(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("reading signatures of %s: %w", transports.ImageName(destRef), err)
+	}
+	sigs, added, err := mergeSignatures(existingSigs, srcSigs)
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		fmt.Fprintf(reportWriter, "Skipping: signatures already present at destination\n")
+		return nil
+	}
+
+	publicDest, err := destRef.NewImageDestination(ctx, options.DestinationCtx)
+	if err != nil {
+		return fmt.Errorf("initializing destination %s: %w", transports.ImageName(destRef), err)
+	}
+	dest := imagedestination.FromPublic(publicDest)
+	defer func() {
+		if err := dest.Close(); err != nil {
+			if retErr != nil {
+				retErr = fmt.Errorf(" (dest: %v): %w", err, retErr)
+			} else {
+				retErr = fmt.Errorf(" (dest: %v)", err)
+			}
+		}
+	}()
+	existingImageDest, ok := dest.(private.ImageDestinationWithExistingImageSignatures)
+	if !ok {
+		return fmt.Errorf("Adding signatures to an existing image is not supported for %s", transports.ImageName(destRef))
+	}
+	if err := dest.SupportsSignatures(ctx); err != nil {
+		return fmt.Errorf("Can not copy signatures to %s: %w", transports.ImageName(destRef), err)
+	}
+	fmt.Fprintf(reportWriter, "Storing signatures\n")
+	if err := existingImageDest.PutSignaturesForExistingImage(ctx, sigs, destDigest); err != nil {
+		return fmt.Errorf("writing signatures: %w", err)
+	}
+	return nil
+}
+
+// topLevelManifestDigest returns the digest of the top-level manifest of src.
+func topLevelManifestDigest(ctx context.Context, src types.ImageSource) (digest.Digest, error) {
+	manifestBlob, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Digest(manifestBlob)
+}
+
+// mergeSignatures returns existing, followed by those of added which are not already in existing,
+// and the number of such new signatures.
+func mergeSignatures(existing, added []internalsig.Signature) ([]internalsig.Signature, int, error) {
+	res := append([]internalsig.Signature{}, existing...)
+	blobs := make([][]byte, 0, len(existing)+len(added))
+	for _, sig := range existing {
+		blob, err := internalsig.Blob(sig)
+		if err != nil {
+			return nil, 0, err
+		}
+		blobs = append(blobs, blob)
+	}
+	newSigs := 0
+	for _, sig := range added {
+		blob, err := internalsig.Blob(sig)
+		if err != nil {
+			return nil, 0, err
+		}
+		alreadyPresent := false
+		for _, b := range blobs {
+			if bytes.Equal(b, blob) {
+				alreadyPresent = true
+				break
+			}
+		}
+		if alreadyPresent {
+			continue
+		}
+		blobs = append(blobs, blob)
+		res = append(res, sig)
+		newSigs++
+	}
+	return res, newSigs, nil
+}
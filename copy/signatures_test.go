@@ -0,0 +1,121 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/imagesource"
+	internalsig "github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatures(t *testing.T) {
+	// Two registries, each with a separate lookaside storage for simple signatures, and with sigstore attachments enabled.
+	_, server1 := newTestRegistry(t)
+	registry2, server2 := newTestRegistry(t)
+	registriesDir := t.TempDir()
+	registriesConfig := "docker:\n"
+	hosts := []string{}
+	for _, server := range []string{server1.URL, server2.URL} {
+		u, err := url.Parse(server)
+		require.NoError(t, err)
+		hosts = append(hosts, u.Host)
+		registriesConfig += fmt.Sprintf("  %s:\n    lookaside: file://%s\n    use-sigstore-attachments: true\n", u.Host, t.TempDir())
+	}
+	err := os.WriteFile(filepath.Join(registriesDir, "registries.yaml"), []byte(registriesConfig), 0o600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		BlobInfoCacheDir:            t.TempDir(),
+		RegistriesDirPath:           registriesDir,
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	registryRef := func(host, repo string) types.ImageReference {
+		ref, err := docker.ParseReference("//" + host + "/" + repo + ":tag")
+		require.NoError(t, err)
+		return ref
+	}
+	ref1 := registryRef(hosts[0], "original")
+	ref2 := registryRef(hosts[1], "mirror")
+
+	// A dir: image with a sigstore and a simple signature
+	unsignedRef, _, _ := newTestDirImage(t, "layer")
+	signedDir := t.TempDir()
+	signedRef, err := directory.NewReference(signedDir)
+	require.NoError(t, err)
+	signIdentity, err := reference.ParseNormalizedNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+	manifestBlob, err := Image(context.Background(), acceptAnythingPolicyContext(t), signedRef, unsignedRef, &Options{
+		Signers:      []signature.Signer{&fakeSigner{}},
+		SignIdentity: signIdentity,
+	})
+	require.NoError(t, err)
+	simpleSig, err := os.ReadFile("../signature/fixtures/image.signature") // The signature is not verified, it can be of any image.
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(signedDir, "signature-2"), simpleSig, 0o644)
+	require.NoError(t, err)
+	sigs := destSignatures(t, signedRef)
+	require.Len(t, sigs, 2)
+
+	// The signed image is pushed to one registry, and the same image, without signatures, to the other one.
+	options := &Options{SourceCtx: sys, DestinationCtx: sys}
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), ref1, signedRef, options)
+	require.NoError(t, err)
+	mirroredManifest, err := Image(context.Background(), acceptAnythingPolicyContext(t), ref2, unsignedRef, options)
+	require.NoError(t, err)
+	require.Equal(t, manifestBlob, mirroredManifest)
+	assert.ElementsMatch(t, sigs, registrySignatures(t, ref1, sys))
+	assert.Empty(t, registrySignatures(t, ref2, sys))
+	blobs2 := len(registry2.blobs)
+
+	// The signatures are copied, and copying them again changes nothing.
+	for i := 0; i < 2; i++ {
+		err = Signatures(context.Background(), ref2, ref1, &SignaturesOptions{SourceCtx: sys, DestinationCtx: sys})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, sigs, registrySignatures(t, ref2, sys))
+		assert.Equal(t, blobs2+2, len(registry2.blobs)) // Only the sigstore attachment payload and config have been added
+	}
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+	assert.Contains(t, registry2.manifests, fmt.Sprintf("sha256-%s.sig", manifestDigest.Encoded()))
+
+	// Signatures can't be copied to an image with a different digest.
+	otherRef := registryRef(hosts[1], "other")
+	otherImage, _, _ := newTestDirImage(t, "another layer")
+	_, err = Image(context.Background(), acceptAnythingPolicyContext(t), otherRef, otherImage, options)
+	require.NoError(t, err)
+	err = Signatures(context.Background(), otherRef, ref1, &SignaturesOptions{SourceCtx: sys, DestinationCtx: sys})
+	assert.Error(t, err)
+	assert.Empty(t, registrySignatures(t, otherRef, sys))
+
+	// Nor to an image which does not exist.
+	err = Signatures(context.Background(), registryRef(hosts[1], "missing"), ref1, &SignaturesOptions{SourceCtx: sys, DestinationCtx: sys})
+	assert.Error(t, err)
+
+	// A destination which does not support adding signatures to an existing image is rejected.
+	err = Signatures(context.Background(), unsignedRef, ref1, &SignaturesOptions{SourceCtx: sys})
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(unsignedRef.StringWithinTransport(), "signature-1"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// registrySignatures returns the signatures of the image at ref, a docker: reference, using sys.
+func registrySignatures(t *testing.T, ref types.ImageReference, sys *types.SystemContext) []internalsig.Signature {
+	src, err := ref.NewImageSource(context.Background(), sys)
+	require.NoError(t, err)
+	defer src.Close()
+	sigs, err := imagesource.FromPublic(src).GetSignaturesWithFormat(context.Background(), nil)
+	require.NoError(t, err)
+	return sigs
+}
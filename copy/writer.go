@@ -0,0 +1,22 @@
+package copy
+
+import (
+	"context"
+	"io"
+
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+)
+
+// ImageToWriter copies the image at srcRef to w, using policyContext and options (which may be nil) as Image does.
+// The destination is the reference returned by newDestRef for w, e.g. using oci/archive.NewWriterReference;
+// it determines the format of the stream, and when it is written.
+// It returns the manifest which was written.
+func ImageToWriter(ctx context.Context, policyContext *signature.PolicyContext, w io.Writer, newDestRef func(w io.Writer) (types.ImageReference, error),
+	srcRef types.ImageReference, options *Options) ([]byte, error) {
+	destRef, err := newDestRef(w)
+	if err != nil {
+		return nil, err
+	}
+	return Image(ctx, policyContext, destRef, srcRef, options)
+}
@@ -0,0 +1,79 @@
+package copy
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/archive"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWriter is an io.Writer which fails all writes.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("writing failed")
+}
+
+func TestImageToWriter(t *testing.T) {
+	srcRef, _, _ := newTestDirImage(t, "layer")
+	ociArchive := func(image string) func(w io.Writer) (types.ImageReference, error) {
+		return func(w io.Writer) (types.ImageReference, error) {
+			return archive.NewWriterReference(w, image)
+		}
+	}
+
+	var buf bytes.Buffer
+	manifestBlob, err := ImageToWriter(context.Background(), acceptAnythingPolicyContext(t), &buf, ociArchive("example:latest"), srcRef, nil)
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+
+	// The stream is a tar archive, with the blobs before the index.
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	names := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeReg {
+			names = append(names, hdr.Name)
+		}
+	}
+	require.True(t, len(names) >= 3)
+	assert.Equal(t, []string{"index.json", "oci-layout"}, names[len(names)-2:])
+	assert.Contains(t, names, "blobs/sha256/"+manifestDigest.Encoded())
+
+	// The stream can be read back as an oci-archive.
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	require.NoError(t, os.WriteFile(archivePath, buf.Bytes(), 0o600))
+	ref, err := archive.NewReference(archivePath, "example:latest")
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	readManifest, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBlob, readManifest)
+
+	// Failures to create the destination are reported, without writing anything.
+	buf.Reset()
+	_, err = ImageToWriter(context.Background(), acceptAnythingPolicyContext(t), &buf, ociArchive("UPPERCASE:invalid!"), srcRef, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 0, buf.Len())
+
+	// A failure to write the stream is reported
+	_, err = ImageToWriter(context.Background(), acceptAnythingPolicyContext(t), failingWriter{}, ociArchive(""), srcRef, nil)
+	assert.Error(t, err)
+}
@@ -8,18 +8,25 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/containers/image/v5/internal/imagedestination/impl"
 	"github.com/containers/image/v5/internal/imagedestination/stubs"
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/internal/putblobdigest"
 	"github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 )
 
-const version = "Directory Transport Version: 1.1\n"
+const (
+	// version is the contents of the version file of a directory containing a complete image.
+	version = "Directory Transport Version: 1.1\n"
+	// incompleteVersion is the contents of the version file while an image is being written, until Commit.
+	incompleteVersion = "Directory Transport Version: 1.1 (incomplete)\n"
+)
 
 // ErrNotContainerImageDir indicates that the directory doesn't match the expected contents of a directory created
 // using the 'dir' transport
@@ -31,7 +38,8 @@ type dirImageDestination struct {
 	stubs.NoPutBlobPartialInitialize
 	stubs.AlwaysSupportsSignatures
 
-	ref dirReference
+	ref           dirReference
+	extractLayers bool
 }
 
 // newImageDestination returns an ImageDestination for writing to a directory.
@@ -74,7 +82,7 @@ func newImageDestination(sys *types.SystemContext, ref dirReference) (private.Im
 					return nil, err
 				}
 				// check if contents of version file is what we expect it to be
-				if string(contents) != version {
+				if string(contents) != version && string(contents) != incompleteVersion {
 					return nil, ErrNotContainerImageDir
 				}
 			} else {
@@ -92,8 +100,8 @@ func newImageDestination(sys *types.SystemContext, ref dirReference) (private.Im
 			return nil, fmt.Errorf("unable to create directory %q: %w", ref.resolvedPath, err)
 		}
 	}
-	// create version file
-	err = os.WriteFile(ref.versionPath(), []byte(version), 0644)
+	// create version file; it is updated to the final version on Commit
+	err = os.WriteFile(ref.versionPath(), []byte(incompleteVersion), 0644)
 	if err != nil {
 		return nil, fmt.Errorf("creating version file %q: %w", ref.versionPath(), err)
 	}
@@ -109,7 +117,8 @@ func newImageDestination(sys *types.SystemContext, ref dirReference) (private.Im
 		}),
 		NoPutBlobPartialInitialize: stubs.NoPutBlobPartial(ref),
 
-		ref: ref,
+		ref:           ref,
+		extractLayers: sys != nil && sys.DirExtractLayers,
 	}
 	d.Compat = impl.AddCompat(d)
 	return d, nil
@@ -149,7 +158,7 @@ func (d *dirImageDestination) PutBlobWithOptions(ctx context.Context, stream io.
 		}
 	}()
 
-	digester, stream := putblobdigest.DigestIfCanonicalUnknown(stream, inputInfo)
+	digester, stream := putblobdigest.DigestIfAlgorithmUnknown(stream, inputInfo, options.DigestAlgorithm)
 	// TODO: This can take quite some time, and should ideally be cancellable using ctx.Done().
 	size, err := io.Copy(blobFile, stream)
 	if err != nil {
@@ -181,7 +190,13 @@ func (d *dirImageDestination) PutBlobWithOptions(ctx context.Context, stream io.
 		return types.BlobInfo{}, err
 	}
 	succeeded = true
-	return types.BlobInfo{Digest: blobDigest, Size: size}, nil
+	return types.BlobInfo{Digest: blobDigest, Size: size, MediaType: inputInfo.MediaType}, nil
+}
+
+// SupportsDigestAlgorithm returns true if blobs and manifests with digests using algorithm can be stored,
+// and PutBlobOptions.DigestAlgorithm can be set to algorithm.
+func (d *dirImageDestination) SupportsDigestAlgorithm(algorithm digest.Algorithm) bool {
+	return algorithm.Available()
 }
 
 // TryReusingBlobWithOptions checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
@@ -203,7 +218,7 @@ func (d *dirImageDestination) TryReusingBlobWithOptions(ctx context.Context, inf
 	if err != nil {
 		return false, types.BlobInfo{}, err
 	}
-	return true, types.BlobInfo{Digest: info.Digest, Size: finfo.Size()}, nil
+	return true, types.BlobInfo{Digest: info.Digest, Size: finfo.Size(), MediaType: info.MediaType}, nil
 }
 
 // PutManifest writes manifest to the destination.
@@ -214,8 +229,37 @@ func (d *dirImageDestination) TryReusingBlobWithOptions(ctx context.Context, inf
 // FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
 // If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
 // but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
-func (d *dirImageDestination) PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error {
-	return os.WriteFile(d.ref.manifestPath(instanceDigest), manifest, 0644)
+// If d.extractLayers, the layers of manifest are also extracted.
+func (d *dirImageDestination) PutManifest(ctx context.Context, manifestBlob []byte, instanceDigest *digest.Digest) error {
+	if err := os.WriteFile(d.ref.manifestPath(instanceDigest), manifestBlob, 0644); err != nil {
+		return err
+	}
+	if !d.extractLayers {
+		return nil
+	}
+	mimeType := manifest.GuessMIMEType(manifestBlob)
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		return nil // The instances are extracted when their manifests are written.
+	}
+	m, err := manifest.FromBlob(manifestBlob, mimeType)
+	if err != nil {
+		return fmt.Errorf("parsing manifest to extract layers: %w", err)
+	}
+	for _, layer := range m.LayerInfos() {
+		if strings.HasSuffix(layer.MediaType, "+encrypted") {
+			return fmt.Errorf("layer %s is encrypted, and can't be extracted", layer.Digest)
+		}
+		if err := extractLayer(d.ref.path, d.ref.layerPath(layer.Digest), d.ref.extractedLayerPath(layer.Digest)); err != nil {
+			return fmt.Errorf("extracting layer %s: %w", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+// PutManifestWithDigestAlgorithm is PutManifest; the directory does not record the digest of the top-level manifest,
+// so algorithm is not used.
+func (d *dirImageDestination) PutManifestWithDigestAlgorithm(ctx context.Context, manifestBlob []byte, instanceDigest *digest.Digest, algorithm digest.Algorithm) error {
+	return d.PutManifest(ctx, manifestBlob, instanceDigest)
 }
 
 // PutSignaturesWithFormat writes a set of signatures to the destination.
@@ -243,6 +287,9 @@ func (d *dirImageDestination) PutSignaturesWithFormat(ctx context.Context, signa
 // - Uploaded data MAY be visible to others before Commit() is called
 // - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
 func (d *dirImageDestination) Commit(context.Context, types.UnparsedImage) error {
+	if err := os.WriteFile(d.ref.versionPath(), []byte(version), 0644); err != nil {
+		return fmt.Errorf("updating version file %q: %w", d.ref.versionPath(), err)
+	}
 	return nil
 }
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/containers/image/v5/internal/imagesource/impl"
 	"github.com/containers/image/v5/internal/imagesource/stubs"
@@ -26,7 +27,10 @@ type dirImageSource struct {
 
 // newImageSource returns an ImageSource reading from an existing directory.
 // The caller must call .Close() on the returned ImageSource.
-func newImageSource(ref dirReference) private.ImageSource {
+func newImageSource(ref dirReference) (private.ImageSource, error) {
+	if err := validateVersion(ref); err != nil {
+		return nil, err
+	}
 	s := &dirImageSource{
 		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
 			HasThreadSafeGetBlob: false,
@@ -36,7 +40,27 @@ func newImageSource(ref dirReference) private.ImageSource {
 		ref: ref,
 	}
 	s.Compat = impl.AddCompat(s)
-	return s
+	return s, nil
+}
+
+// validateVersion returns an error unless the version file in ref marks the directory as containing a complete image
+// in a supported format.
+func validateVersion(ref dirReference) error {
+	contents, err := os.ReadFile(ref.versionPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%q is not a containers image directory, version file %q is missing", ref.resolvedPath, ref.versionPath())
+		}
+		return fmt.Errorf("reading version file %q: %w", ref.versionPath(), err)
+	}
+	switch string(contents) {
+	case version, "Directory Transport Version: 1.0\n":
+		return nil
+	case incompleteVersion:
+		return fmt.Errorf("the image in %q is incomplete, it was not completely written", ref.resolvedPath)
+	default:
+		return fmt.Errorf("unsupported version %q of the image directory %q", strings.TrimSpace(string(contents)), ref.resolvedPath)
+	}
 }
 
 // Reference returns the reference used to set up this source, _as specified by the user_
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/containers/image/v5/internal/private"
@@ -19,6 +20,7 @@ import (
 
 var _ private.ImageSource = (*dirImageSource)(nil)
 var _ private.ImageDestination = (*dirImageDestination)(nil)
+var _ private.ImageReferenceWithExistenceCheck = dirReference{}
 
 func TestDestinationReference(t *testing.T) {
 	ref, tmpDir := refToTempDir(t)
@@ -83,6 +85,12 @@ func TestGetPutBlob(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(len(computedBlob)), computedInfo.Size)
 	assert.Equal(t, digest.FromBytes(computedBlob), computedInfo.Digest)
+	// PutBlob preserves a caller-provided MediaType
+	customMediaTypeBlob := []byte("custom-media-type-blob")
+	customMediaTypeInfo, err := dest.PutBlob(context.Background(), bytes.NewReader(customMediaTypeBlob), types.BlobInfo{Digest: "", Size: int64(-1), MediaType: "application/vnd.example.custom"}, cache, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/vnd.example.custom", customMediaTypeInfo.MediaType)
+	assert.Equal(t, digest.FromBytes(customMediaTypeBlob), customMediaTypeInfo.Digest)
 	err = dest.Commit(context.Background(), nil) // nil unparsedToplevel is invalid, we don’t currently use the value
 	assert.NoError(t, err)
 
@@ -200,6 +208,7 @@ func TestGetPutSignatures(t *testing.T) {
 
 func TestSourceReference(t *testing.T) {
 	ref, tmpDir := refToTempDir(t)
+	commitEmptyImage(t, ref)
 
 	src, err := ref.NewImageSource(context.Background(), nil)
 	require.NoError(t, err)
@@ -207,3 +216,48 @@ func TestSourceReference(t *testing.T) {
 	ref2 := src.Reference()
 	assert.Equal(t, tmpDir, ref2.StringWithinTransport())
 }
+
+func TestSourceVersion(t *testing.T) {
+	// A complete image, in the current or an older format
+	for _, contents := range []string{version, "Directory Transport Version: 1.0\n"} {
+		ref, tmpDir := refToTempDir(t)
+		err := os.WriteFile(filepath.Join(tmpDir, "version"), []byte(contents), 0o644)
+		require.NoError(t, err)
+		src, err := ref.NewImageSource(context.Background(), nil)
+		require.NoError(t, err, contents)
+		src.Close()
+	}
+
+	// An image which is still being written, or was not written completely
+	ref, _ := refToTempDir(t)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutManifest(context.Background(), []byte("test-manifest"), nil)
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.ErrorContains(t, err, "incomplete")
+	// ... which can be overwritten
+	dest2, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest2.Close()
+	err = dest2.Commit(context.Background(), nil) // nil unparsedToplevel is invalid, we don’t currently use the value
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	src.Close()
+
+	// A future version
+	ref, tmpDir := refToTempDir(t)
+	err = os.WriteFile(filepath.Join(tmpDir, "version"), []byte("Directory Transport Version: 2.0\n"), 0o644)
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.ErrorContains(t, err, "unsupported version")
+
+	// A missing version file
+	ref, tmpDir = refToTempDir(t)
+	err = os.WriteFile(filepath.Join(tmpDir, "manifest.json"), []byte("test-manifest"), 0o644)
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.ErrorContains(t, err, "is missing")
+}
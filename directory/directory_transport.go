@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -28,6 +29,17 @@ func (t dirTransport) Name() string {
 	return "dir"
 }
 
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "dir:path"
+	ReferenceSyntaxExample = "dir:/var/tmp/busybox"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t dirTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
 func (t dirTransport) ParseReference(reference string) (types.ImageReference, error) {
 	return NewReference(reference)
@@ -146,7 +158,7 @@ func (ref dirReference) NewImage(ctx context.Context, sys *types.SystemContext)
 // NewImageSource returns a types.ImageSource for this reference.
 // The caller must call .Close() on the returned ImageSource.
 func (ref dirReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
-	return newImageSource(ref), nil
+	return newImageSource(ref)
 }
 
 // NewImageDestination returns a types.ImageDestination for this reference.
@@ -155,6 +167,18 @@ func (ref dirReference) NewImageDestination(ctx context.Context, sys *types.Syst
 	return newImageDestination(sys, ref)
 }
 
+// ImageExists returns true if the directory contains an image manifest, and false if it does not.
+func (ref dirReference) ImageExists(ctx context.Context, sys *types.SystemContext) (bool, error) {
+	_, err := os.Stat(ref.manifestPath(nil))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // DeleteImage deletes the named image from the registry, if supported.
 func (ref dirReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	return errors.New("Deleting images not implemented for dir: images")
@@ -174,6 +198,11 @@ func (ref dirReference) layerPath(digest digest.Digest) string {
 	return filepath.Join(ref.path, digest.Encoded())
 }
 
+// extractedLayerPath returns a path for the extracted contents of a layer within a directory using our conventions.
+func (ref dirReference) extractedLayerPath(digest digest.Digest) string {
+	return filepath.Join(ref.path, digest.Encoded()+".rootfs")
+}
+
 // signaturePath returns a path for a signature within a directory using our conventions.
 func (ref dirReference) signaturePath(index int, instanceDigest *digest.Digest) string {
 	if instanceDigest != nil {
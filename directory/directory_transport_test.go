@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/containers/image/v5/internal/private"
 	_ "github.com/containers/image/v5/internal/testing/explicitfilepath-tmpdir"
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
@@ -77,6 +78,15 @@ func refToTempDir(t *testing.T) (types.ImageReference, string) {
 	return ref, tmpDir
 }
 
+// commitEmptyImage writes an empty image, containing only the version file, to ref.
+func commitEmptyImage(t *testing.T, ref types.ImageReference) {
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.Commit(context.Background(), nil) // nil unparsedToplevel is invalid, we don’t currently use the value
+	require.NoError(t, err)
+}
+
 func TestReferenceTransport(t *testing.T) {
 	ref, _ := refToTempDir(t)
 	assert.Equal(t, Transport, ref.Transport())
@@ -173,6 +183,7 @@ func TestReferenceNewImageNoValidManifest(t *testing.T) {
 
 func TestReferenceNewImageSource(t *testing.T) {
 	ref, _ := refToTempDir(t)
+	commitEmptyImage(t, ref)
 	src, err := ref.NewImageSource(context.Background(), nil)
 	assert.NoError(t, err)
 	defer src.Close()
@@ -185,6 +196,24 @@ func TestReferenceNewImageDestination(t *testing.T) {
 	defer dest.Close()
 }
 
+func TestReferenceImageExists(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	exists, err := ref.(private.ImageReferenceWithExistenceCheck).ImageExists(context.Background(), nil)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	err = os.WriteFile(filepath.Join(tmpDir, "manifest.json"), []byte("{}"), 0o644)
+	require.NoError(t, err)
+	exists, err = ref.(private.ImageReferenceWithExistenceCheck).ImageExists(context.Background(), nil)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Failures other than a missing manifest are reported
+	ref = dirReference{path: filepath.Join(tmpDir, "manifest.json")} // NewReference would reject this path
+	_, err = ref.(private.ImageReferenceWithExistenceCheck).ImageExists(context.Background(), nil)
+	assert.Error(t, err)
+}
+
 func TestReferenceDeleteImage(t *testing.T) {
 	ref, _ := refToTempDir(t)
 	err := ref.DeleteImage(context.Background(), nil)
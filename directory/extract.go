@@ -0,0 +1,257 @@
+package directory
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/layertar"
+	"github.com/sirupsen/logrus"
+)
+
+// xattrPAXPrefix is the prefix of PAX records containing extended attributes.
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// errNotSupported is returned by the platform-specific extraction functions if the operation is not supported on this platform.
+var errNotSupported = errors.New("not supported on this platform")
+
+// extractLayer extracts the layer blob at blobPath into a new directory at destPath, unless destPath already exists.
+// tmpDir is a directory on the same filesystem as destPath, used while extracting.
+// Whiteouts are extracted as their marker files. Device nodes, ownership and extended attributes are restored only
+// if the current user is allowed to do so.
+func extractLayer(tmpDir, blobPath, destPath string) (retErr error) {
+	exists, err := pathExists(destPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil // The layer is shared by several images (e.g. instances of a manifest list).
+	}
+
+	blob, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	r, err := layertar.NewReader(blob)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	root, err := os.MkdirTemp(tmpDir, "dir-extract-layer")
+	if err != nil {
+		return err
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			if err := os.RemoveAll(root); err != nil && retErr == nil {
+				retErr = err
+			}
+		}
+	}()
+
+	// Directory metadata is applied after all contents are extracted, because extracting the contents modifies it.
+	dirs := map[string]*tar.Header{} // Clean names of directories, with the header of the last entry for each.
+	dirNames := []string{}           // Keys of dirs, in the order they were first extracted.
+	addDir := func(name string, hdr *tar.Header) {
+		if _, ok := dirs[name]; !ok {
+			dirNames = append(dirNames, name)
+		}
+		dirs[name] = hdr
+	}
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		name := entry.Path
+		switch {
+		case entry.OpaqueWhiteout:
+			name = path.Join(entry.Path, layertar.WhiteoutOpaqueDir)
+		case entry.Whiteout:
+			name = path.Join(path.Dir(entry.Path), layertar.WhiteoutPrefix+path.Base(entry.Path))
+		}
+		if name == "." {
+			addDir(name, entry.Header)
+			continue
+		}
+		hdr := *entry.Header
+		hdr.Name = name
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = entry.LinkTarget
+		}
+		if err := extractEntry(root, &hdr, r); err != nil {
+			return fmt.Errorf("extracting %q: %w", name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			addDir(name, &hdr)
+		} else {
+			// The entry replaced any directory at name, and everything in it; don’t set metadata through it later,
+			// it may be a symbolic link pointing outside of root.
+			for dir := range dirs {
+				if dir == name || strings.HasPrefix(dir, name+"/") {
+					delete(dirs, dir)
+				}
+			}
+		}
+	}
+	// Apply the metadata of subdirectories before that of their parents, so that restrictive permissions of the parents
+	// don’t prevent updating the subdirectories.
+	for i := len(dirNames) - 1; i >= 0; i-- {
+		name := dirNames[i]
+		hdr, ok := dirs[name]
+		if !ok {
+			continue
+		}
+		if name != "." {
+			if err := checkNoSymlinkParents(root, name); err != nil {
+				return err
+			}
+		}
+		dest := filepath.Join(root, filepath.FromSlash(name))
+		fi, err := os.Lstat(dest)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() { // os.Chmod and os.Chtimes would follow a symbolic link.
+			continue
+		}
+		if err := setMetadata(dest, hdr); err != nil {
+			return fmt.Errorf("setting metadata of %q: %w", name, err)
+		}
+	}
+
+	if err := os.Rename(root, destPath); err != nil {
+		return err
+	}
+	succeeded = true
+	return nil
+}
+
+// checkNoSymlinkParents returns an error if a parent directory of name, a clean path relative to root, is a symbolic link,
+// so that extracting name can't modify files outside of root.
+func checkNoSymlinkParents(root, name string) error {
+	components := strings.Split(path.Dir(name), "/")
+	p := root
+	for _, c := range components {
+		if c == "." {
+			break
+		}
+		p = filepath.Join(p, c)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // The rest of the parent directories will be created.
+			}
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("the parent directory %q is a symbolic link", path.Dir(name))
+		}
+	}
+	return nil
+}
+
+// extractEntry extracts hdr, with a clean name relative to root, and contents, into root.
+// Unlike other entries, directories don’t have their metadata set.
+func extractEntry(root string, hdr *tar.Header, contents io.Reader) error {
+	if err := checkNoSymlinkParents(root, hdr.Name); err != nil {
+		return err
+	}
+	dest := filepath.Join(root, filepath.FromSlash(hdr.Name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	// As with tar, a later entry replaces an earlier one with the same name, unless both are directories.
+	if fi, err := os.Lstat(dest); err == nil {
+		if fi.IsDir() && hdr.Typeflag == tar.TypeDir {
+			return nil
+		}
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.Mkdir(dest, 0o755)
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, contents)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, dest); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		if err := checkNoSymlinkParents(root, hdr.Linkname); err != nil {
+			return err
+		}
+		// Hard links share the metadata of their target.
+		return os.Link(filepath.Join(root, filepath.FromSlash(hdr.Linkname)), dest)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if err := createSpecialFile(dest, hdr); err != nil {
+			if errors.Is(err, os.ErrPermission) || errors.Is(err, errNotSupported) {
+				logrus.Debugf("Skipping special file %q: %v", hdr.Name, err)
+				return nil
+			}
+			return err
+		}
+	default:
+		logrus.Debugf("Skipping %q of unsupported type %q", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+	return setMetadata(dest, hdr)
+}
+
+// setMetadata sets the ownership, extended attributes, permissions and modification time of dest per hdr.
+// Ownership and extended attributes are silently not set if the current user is not allowed to do so.
+func setMetadata(dest string, hdr *tar.Header) error {
+	if runtime.GOOS != "windows" { // os.Lchown always fails on Windows.
+		if err := os.Lchown(dest, hdr.Uid, hdr.Gid); err != nil && !errors.Is(err, os.ErrPermission) {
+			return err
+		}
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPAXPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, xattrPAXPrefix)
+		if err := setXattr(dest, name, []byte(value)); err != nil {
+			if errors.Is(err, os.ErrPermission) || errors.Is(err, errNotSupported) {
+				logrus.Debugf("Skipping extended attribute %q of %q: %v", name, hdr.Name, err)
+				continue
+			}
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil // The permissions of symbolic links are not used, and os.Chtimes follows symbolic links.
+	}
+	// This happens after Lchown, which may clear the setuid and setgid bits.
+	if err := os.Chmod(dest, hdr.FileInfo().Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, hdr.ModTime, hdr.ModTime)
+}
@@ -0,0 +1,34 @@
+package directory
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// createSpecialFile creates a device node or a FIFO at path, per hdr.
+func createSpecialFile(path string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 0o7777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	case tar.TypeFifo:
+		mode |= unix.S_IFIFO
+	default:
+		return fmt.Errorf("Internal error: unexpected special file type %q", hdr.Typeflag)
+	}
+	return unix.Mknod(path, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+}
+
+// setXattr sets the extended attribute name of path, not following symbolic links, to value.
+func setXattr(path, name string, value []byte) error {
+	err := unix.Lsetxattr(path, name, value, 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		return fmt.Errorf("%w: %v", errNotSupported, err)
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package directory
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// testEntry is an entry of a test layer.
+type testEntry struct {
+	hdr      tar.Header
+	contents string
+}
+
+// putTestLayer writes a gzip-compressed layer with entries to dest, and returns its descriptor.
+func putTestLayer(t *testing.T, dest types.ImageDestination, entries ...testEntry) manifest.Schema2Descriptor {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.contents))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(buf.Bytes()), types.BlobInfo{Digest: digest.FromBytes(buf.Bytes()), Size: int64(buf.Len())}, memory.New(), false)
+	require.NoError(t, err)
+	return manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2Schema2LayerMediaType,
+		Size:      info.Size,
+		Digest:    info.Digest,
+	}
+}
+
+// putTestManifest writes a schema2 manifest referring to layers to dest.
+func putTestManifest(t *testing.T, dest types.ImageDestination, layers ...manifest.Schema2Descriptor) error {
+	config := []byte("{}")
+	configInfo, err := dest.PutBlob(context.Background(), bytes.NewReader(config), types.BlobInfo{Digest: digest.FromBytes(config), Size: int64(len(config))}, memory.New(), true)
+	require.NoError(t, err)
+	man, err := manifest.Schema2FromComponents(manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2Schema2ConfigMediaType,
+		Size:      configInfo.Size,
+		Digest:    configInfo.Digest,
+	}, layers).Serialize()
+	require.NoError(t, err)
+	return dest.PutManifest(context.Background(), man, nil)
+}
+
+func TestExtractLayers(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{DirExtractLayers: true})
+	require.NoError(t, err)
+	defer dest.Close()
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	layer1 := putTestLayer(t, dest,
+		testEntry{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o700, ModTime: modTime}},
+		testEntry{hdr: tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: modTime,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.test": "value"}}, contents: "base passwd"},
+		testEntry{hdr: tar.Header{Name: "etc/shadow", Typeflag: tar.TypeReg, Mode: 0o600}, contents: "base shadow"},
+		testEntry{hdr: tar.Header{Name: "etc/passwd.link", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}},
+		testEntry{hdr: tar.Header{Name: "bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin"}},
+		testEntry{hdr: tar.Header{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0o4755}, contents: "tool"},
+		testEntry{hdr: tar.Header{Name: "dev/fifo", Typeflag: tar.TypeFifo, Mode: 0o600}},
+		testEntry{hdr: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3}},
+	)
+	layer2 := putTestLayer(t, dest,
+		testEntry{hdr: tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0o644}, contents: "new passwd"},
+		testEntry{hdr: tar.Header{Name: "etc/.wh.shadow", Typeflag: tar.TypeReg}},
+		testEntry{hdr: tar.Header{Name: "usr/.wh..wh..opq", Typeflag: tar.TypeReg}},
+		testEntry{hdr: tar.Header{Name: "usr/bin/other", Typeflag: tar.TypeReg, Mode: 0o755}, contents: "other"},
+	)
+	err = putTestManifest(t, dest, layer1, layer2)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background(), nil)
+	require.NoError(t, err)
+
+	// The layer blobs are still available.
+	for _, layer := range []manifest.Schema2Descriptor{layer1, layer2} {
+		_, err := os.Stat(filepath.Join(tmpDir, layer.Digest.Encoded()))
+		assert.NoError(t, err)
+	}
+
+	root1 := filepath.Join(tmpDir, layer1.Digest.Encoded()+".rootfs")
+	contents, err := os.ReadFile(filepath.Join(root1, "etc/passwd"))
+	require.NoError(t, err)
+	assert.Equal(t, "base passwd", string(contents))
+	fi, err := os.Stat(filepath.Join(root1, "etc/passwd"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), fi.Mode())
+	assert.True(t, modTime.Equal(fi.ModTime()))
+	value := make([]byte, 100)
+	if n, err := unix.Lgetxattr(filepath.Join(root1, "etc/passwd"), "user.test", value); err == nil { // Not all filesystems support user xattrs.
+		assert.Equal(t, "value", string(value[:n]))
+	}
+	linkFI, err := os.Stat(filepath.Join(root1, "etc/passwd.link"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(fi, linkFI))
+	fi, err = os.Stat(filepath.Join(root1, "etc"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0o700, fi.Mode())
+	assert.True(t, modTime.Equal(fi.ModTime()))
+	target, err := os.Readlink(filepath.Join(root1, "bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "usr/bin", target)
+	fi, err = os.Stat(filepath.Join(root1, "usr/bin/tool"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSetuid|0o755, fi.Mode())
+	fi, err = os.Lstat(filepath.Join(root1, "dev/fifo"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeNamedPipe|0o600, fi.Mode())
+	if fi, err := os.Lstat(filepath.Join(root1, "dev/null")); err == nil { // Creating device nodes requires privileges.
+		assert.Equal(t, os.ModeDevice|os.ModeCharDevice|0o666, fi.Mode())
+	} else {
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// The whiteouts are kept as marker files.
+	root2 := filepath.Join(tmpDir, layer2.Digest.Encoded()+".rootfs")
+	contents, err = os.ReadFile(filepath.Join(root2, "etc/passwd"))
+	require.NoError(t, err)
+	assert.Equal(t, "new passwd", string(contents))
+	for _, marker := range []string{"etc/.wh.shadow", "usr/.wh..wh..opq"} {
+		fi, err := os.Lstat(filepath.Join(root2, marker))
+		require.NoError(t, err, marker)
+		assert.True(t, fi.Mode().IsRegular(), marker)
+	}
+	contents, err = os.ReadFile(filepath.Join(root2, "usr/bin/other"))
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(contents))
+
+	// Nothing else is left in the directory.
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{
+		"version", "manifest.json", digest.FromBytes([]byte("{}")).Encoded(),
+		layer1.Digest.Encoded(), layer1.Digest.Encoded() + ".rootfs",
+		layer2.Digest.Encoded(), layer2.Digest.Encoded() + ".rootfs",
+	}, names)
+}
+
+func TestExtractLayersDisabled(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	layer := putTestLayer(t, dest, testEntry{hdr: tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0o644}, contents: "contents"})
+	err = putTestManifest(t, dest, layer)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(tmpDir, layer.Digest.Encoded()+".rootfs"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtractLayersSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	for _, entries := range [][]testEntry{
+		{ // A file written through a symbolic link
+			{hdr: tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: outside}},
+			{hdr: tar.Header{Name: "escape/file", Typeflag: tar.TypeReg, Mode: 0o644}, contents: "contents"},
+		},
+		{ // A hard link through a symbolic link
+			{hdr: tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/"}},
+			{hdr: tar.Header{Name: "file", Typeflag: tar.TypeLink, Linkname: "escape" + outside + "/target"}},
+		},
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(outside, "target"), []byte("target"), 0o644))
+		ref, tmpDir := refToTempDir(t)
+		dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{DirExtractLayers: true})
+		require.NoError(t, err)
+		layer := putTestLayer(t, dest, entries...)
+		err = putTestManifest(t, dest, layer)
+		assert.Error(t, err)
+		require.NoError(t, dest.Close())
+
+		outsideEntries, err := os.ReadDir(outside)
+		require.NoError(t, err)
+		require.Len(t, outsideEntries, 1)
+		assert.Equal(t, "target", outsideEntries[0].Name())
+		// The partially extracted layer is removed.
+		_, err = os.Stat(filepath.Join(tmpDir, layer.Digest.Encoded()+".rootfs"))
+		assert.True(t, os.IsNotExist(err))
+		dirs, err := filepath.Glob(filepath.Join(tmpDir, "dir-extract-layer*"))
+		require.NoError(t, err)
+		assert.Empty(t, dirs)
+	}
+}
+
+func TestExtractLayersSymlinkReplacingDirectory(t *testing.T) {
+	outside := t.TempDir()
+	outsideFile := filepath.Join(outside, "file")
+	require.NoError(t, os.WriteFile(outsideFile, []byte("outside"), 0o600))
+	require.NoError(t, os.Mkdir(filepath.Join(outside, "subdir"), 0o700))
+	modTime := time.Unix(0, 0)
+	ref, tmpDir := refToTempDir(t)
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{DirExtractLayers: true})
+	require.NoError(t, err)
+	defer dest.Close()
+	layer := putTestLayer(t, dest,
+		testEntry{hdr: tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o777, ModTime: modTime}},
+		testEntry{hdr: tar.Header{Name: "b/", Typeflag: tar.TypeDir, Mode: 0o777, ModTime: modTime}},
+		testEntry{hdr: tar.Header{Name: "b/subdir/", Typeflag: tar.TypeDir, Mode: 0o777, ModTime: modTime}},
+		// The directories are replaced by symbolic links to outside of the layer.
+		testEntry{hdr: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outsideFile}},
+		testEntry{hdr: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: outside}},
+	)
+	err = putTestManifest(t, dest, layer)
+	require.NoError(t, err)
+
+	// The metadata of the replaced directories is not applied to the symbolic link targets.
+	for p, perm := range map[string]os.FileMode{outsideFile: 0o600, filepath.Join(outside, "subdir"): 0o700} {
+		fi, err := os.Stat(p)
+		require.NoError(t, err)
+		assert.Equal(t, perm, fi.Mode().Perm(), p)
+		assert.NotEqual(t, modTime, fi.ModTime(), p)
+	}
+	rootfs := filepath.Join(tmpDir, layer.Digest.Encoded()+".rootfs")
+	target, err := os.Readlink(filepath.Join(rootfs, "a"))
+	require.NoError(t, err)
+	assert.Equal(t, outsideFile, target)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package directory
+
+import "archive/tar"
+
+// createSpecialFile creates a device node or a FIFO at path, per hdr.
+func createSpecialFile(path string, hdr *tar.Header) error {
+	return errNotSupported
+}
+
+// setXattr sets the extended attribute name of path, not following symbolic links, to value.
+func setXattr(path, name string, value []byte) error {
+	return errNotSupported
+}
@@ -35,6 +35,7 @@ func newImageDestination(sys *types.SystemContext, ref archiveReference) (privat
 		closeWriter = true
 	}
 	tarDest := tarfile.NewDestination(sys, writer.archive, ref.Transport().Name(), ref.ref)
+	tarDest.AllowManifestLists()
 	if sys != nil && sys.DockerArchiveAdditionalTags != nil {
 		tarDest.AddRepoTags(sys.DockerArchiveAdditionalTags)
 	}
@@ -1,5 +1,25 @@
 package archive
 
-import "github.com/containers/image/v5/internal/private"
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 var _ private.ImageDestination = (*archiveImageDestination)(nil)
+
+func TestDestinationSupportedManifestMIMETypes(t *testing.T) {
+	ref, err := NewReference(filepath.Join(t.TempDir(), "archive.tar"), nil)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	assert.Equal(t, []string{manifest.DockerV2Schema2MediaType, manifest.DockerV2ListMediaType, imgspecv1.MediaTypeImageIndex},
+		dest.SupportedManifestMIMETypes())
+}
@@ -72,17 +72,9 @@ func (r *Reader) List() ([][]types.ImageReference, error) {
 	for imageIndex, image := range r.archive.Manifest {
 		refs := []types.ImageReference{}
 		for _, tag := range image.RepoTags {
-			parsedTag, err := reference.ParseNormalizedNamed(tag)
+			ref, err := r.newTaggedReference(tag)
 			if err != nil {
-				return nil, fmt.Errorf("Invalid tag %#v in manifest item @%d: %w", tag, imageIndex, err)
-			}
-			nt, ok := parsedTag.(reference.NamedTagged)
-			if !ok {
-				return nil, fmt.Errorf("Invalid tag %s (%s): does not contain a tag", tag, parsedTag.String())
-			}
-			ref, err := newReference(r.path, nt, -1, r.archive, nil)
-			if err != nil {
-				return nil, fmt.Errorf("creating a reference for tag %#v in manifest item @%d: %w", tag, imageIndex, err)
+				return nil, fmt.Errorf("manifest item @%d: %w", imageIndex, err)
 			}
 			refs = append(refs, ref)
 		}
@@ -95,9 +87,40 @@ func (r *Reader) List() ([][]types.ImageReference, error) {
 		}
 		res = append(res, refs)
 	}
+	// Manifest lists can only be referenced using their tags (or as the only "image" in the archive).
+	for listIndex, list := range r.archive.ManifestLists {
+		refs := []types.ImageReference{}
+		for _, tag := range list.RepoTags {
+			ref, err := r.newTaggedReference(tag)
+			if err != nil {
+				return nil, fmt.Errorf("manifest list item @%d: %w", listIndex, err)
+			}
+			refs = append(refs, ref)
+		}
+		if len(refs) != 0 {
+			res = append(res, refs)
+		}
+	}
 	return res, nil
 }
 
+// newTaggedReference returns a reference for a RepoTags value tag.
+func (r *Reader) newTaggedReference(tag string) (types.ImageReference, error) {
+	parsedTag, err := reference.ParseNormalizedNamed(tag)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid tag %#v: %w", tag, err)
+	}
+	nt, ok := parsedTag.(reference.NamedTagged)
+	if !ok {
+		return nil, fmt.Errorf("Invalid tag %s (%s): does not contain a tag", tag, parsedTag.String())
+	}
+	ref, err := newReference(r.path, nt, -1, r.archive, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating a reference for tag %#v: %w", tag, err)
+	}
+	return ref, nil
+}
+
 // ManifestTagsForReference returns the set of tags “matching” ref in reader, as strings
 // (i.e. exposing the short names before normalization).
 // The function reports an error if ref does not identify a single image.
@@ -110,6 +133,16 @@ func (r *Reader) ManifestTagsForReference(ref types.ImageReference) ([]string, e
 	if !ok {
 		return nil, fmt.Errorf("Internal error: ManifestTagsForReference called for a non-docker/archive ImageReference %s", transports.ImageName(ref))
 	}
+	listItem, tagIndex, err := r.archive.ChooseManifestListItem(archiveRef.ref, archiveRef.sourceIndex)
+	if err != nil {
+		return nil, err
+	}
+	if listItem != nil {
+		if tagIndex != -1 {
+			return []string{listItem.RepoTags[tagIndex]}, nil
+		}
+		return listItem.RepoTags, nil
+	}
 	manifestItem, tagIndex, err := r.archive.ChooseManifestItem(archiveRef.ref, archiveRef.sourceIndex)
 	if err != nil {
 		return nil, err
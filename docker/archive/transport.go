@@ -27,6 +27,17 @@ func (t archiveTransport) Name() string {
 	return "docker-archive"
 }
 
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "docker-archive:path[:{docker-reference|@source-index}]"
+	ReferenceSyntaxExample = "docker-archive:/var/tmp/busybox.tar:busybox:latest"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t archiveTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
 func (t archiveTransport) ParseReference(reference string) (types.ImageReference, error) {
 	return ParseReference(reference)
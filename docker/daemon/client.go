@@ -1,17 +1,31 @@
+//go:build !containers_image_docker_daemon_stub
+// +build !containers_image_docker_daemon_stub
+
 package daemon
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
 	"path/filepath"
+	"strings"
 
+	"github.com/containers/image/v5/internal/iolimits"
 	"github.com/containers/image/v5/types"
 	dockerclient "github.com/docker/docker/client"
 	"github.com/docker/go-connections/tlsconfig"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 const (
 	// The default API version to be used in case none is explicitly specified
 	defaultAPIVersion = "1.22"
+	// The API version which added the "platform" parameter of "GET /images/get"
+	platformAPIVersion = "1.48"
 )
 
 // NewDockerClient initializes a new API client based on the passed SystemContext.
@@ -50,6 +64,62 @@ func newDockerClient(sys *types.SystemContext) (*dockerclient.Client, error) {
 	return dockerclient.NewClient(host, defaultAPIVersion, httpClient, nil)
 }
 
+// imageSaveForPlatform returns a tarball of image, like c.ImageSave, but containing only the image for platform
+// from a multi-platform image. This is not supported by the dockerclient we use, so the request is sent directly,
+// imageNotForPlatformError is returned by imageSaveForPlatform if the docker engine has the image, but not for the requested platform.
+type imageNotForPlatformError struct {
+	message string // As returned by the docker engine
+}
+
+func (e imageNotForPlatformError) Error() string {
+	return fmt.Sprintf("docker engine returned status %d: %s", http.StatusNotFound, e.message)
+}
+
+// using platformAPIVersion.
+func imageSaveForPlatform(ctx context.Context, c *dockerclient.Client, image string, platform imgspecv1.Platform) (io.ReadCloser, error) {
+	hostURL, err := dockerclient.ParseHostURL(c.DaemonHost())
+	if err != nil {
+		return nil, err
+	}
+	reqURL := url.URL{Scheme: "https", Host: hostURL.Host, Path: path.Join("/", hostURL.Path, "v"+platformAPIVersion, "images/get")}
+	// This matches the transport created by newDockerClient.
+	switch hostURL.Scheme {
+	case "unix", "npipe":
+		reqURL.Scheme = "http"
+		reqURL.Host = "docker" // The transport ignores the address, as in dockerclient.Client.
+	case "http":
+		reqURL.Scheme = "http"
+	}
+	platformJSON, err := json.Marshal(platform)
+	if err != nil {
+		return nil, err
+	}
+	reqURL.RawQuery = url.Values{"names": {image}, "platform": {string(platformJSON)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		var errorResponse struct {
+			Message string `json:"message"`
+		}
+		body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxErrorBodySize)
+		if err == nil && json.Unmarshal(body, &errorResponse) == nil && errorResponse.Message != "" {
+			if res.StatusCode == http.StatusNotFound && strings.Contains(errorResponse.Message, "does not match the specified platform") {
+				return nil, imageNotForPlatformError{message: errorResponse.Message}
+			}
+			return nil, fmt.Errorf("docker engine returned status %d: %s", res.StatusCode, errorResponse.Message)
+		}
+		return nil, fmt.Errorf("docker engine returned status %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
 func tlsConfig(sys *types.SystemContext) (*http.Client, error) {
 	options := tlsconfig.Options{}
 	if sys != nil && sys.DockerDaemonInsecureSkipTLSVerify {
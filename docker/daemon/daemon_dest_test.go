@@ -1,3 +1,6 @@
+//go:build !containers_image_docker_daemon_stub
+// +build !containers_image_docker_daemon_stub
+
 package daemon
 
 import "github.com/containers/image/v5/internal/private"
@@ -1,17 +1,30 @@
+//go:build !containers_image_docker_daemon_stub
+// +build !containers_image_docker_daemon_stub
+
 package daemon
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/containers/image/v5/docker/internal/tarfile"
+	"github.com/containers/image/v5/internal/logger"
+	"github.com/containers/image/v5/internal/pkg/platform"
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/types"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type daemonImageSource struct {
 	ref             daemonReference
 	*tarfile.Source // Implements most of types.ImageSource
+	metadata        private.ImageMetadata
 }
 
 // newImageSource returns a types.ImageSource for the specified image reference.
@@ -23,16 +36,27 @@ type daemonImageSource struct {
 // (We could, perhaps, expect an exact sequence, assume that the first plaintext file
 // is the config, and that the following len(RootFS) files are the layers, but that feels
 // way too brittle.)
+//
+// If sys selects a platform (using OSChoice, ArchitectureChoice or VariantChoice), only the image for that
+// platform is read from a multi-platform image; see saveImage.
 func newImageSource(ctx context.Context, sys *types.SystemContext, ref daemonReference) (private.ImageSource, error) {
 	c, err := newDockerClient(sys)
 	if err != nil {
 		return nil, fmt.Errorf("initializing docker engine client: %w", err)
 	}
+	inspect, _, err := c.ImageInspectWithRaw(ctx, ref.StringWithinTransport())
+	if err != nil {
+		return nil, fmt.Errorf("inspecting image in docker engine: %w", err)
+	}
+	metadata, err := imageMetadata(inspect)
+	if err != nil {
+		return nil, err
+	}
 	// Per NewReference(), ref.StringWithinTransport() is either an image ID (config digest), or a !reference.NameOnly() reference.
 	// Either way ImageSave should create a tarball with exactly one image.
-	inputStream, err := c.ImageSave(ctx, []string{ref.StringWithinTransport()})
+	inputStream, err := saveImage(ctx, sys, c, ref.StringWithinTransport(), inspect)
 	if err != nil {
-		return nil, fmt.Errorf("loading image from docker engine: %w", err)
+		return nil, err
 	}
 	defer inputStream.Close()
 
@@ -42,13 +66,94 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref daemonRef
 	}
 	src := tarfile.NewSource(archive, true, ref.Transport().Name(), nil, -1)
 	return &daemonImageSource{
-		ref:    ref,
-		Source: src,
+		ref:      ref,
+		Source:   src,
+		metadata: metadata,
 	}, nil
 }
 
+// saveImage returns a tarball containing image, as returned by the docker engine, with the inspect data in inspect.
+// If sys selects a platform, the docker engine is asked to only include the image for that platform (or, if it does not have
+// the image for that platform, for the other compatible platforms, from the most preferred one), which requires
+// platformAPIVersion; older docker engines, which can only export the default image, are accepted only if inspect
+// shows that the default image matches the platform.
+func saveImage(ctx context.Context, sys *types.SystemContext, c *client.Client, image string, inspect dockertypes.ImageInspect) (io.ReadCloser, error) {
+	if sys == nil || (sys.OSChoice == "" && sys.ArchitectureChoice == "" && sys.VariantChoice == "") {
+		inputStream, err := c.ImageSave(ctx, []string{image})
+		if err != nil {
+			return nil, fmt.Errorf("loading image from docker engine: %w", err)
+		}
+		return inputStream, nil
+	}
+
+	wantedPlatforms, err := platform.WantedPlatforms(sys)
+	if err != nil {
+		return nil, fmt.Errorf("getting platform information %#v: %w", sys, err)
+	}
+	version, err := c.ServerVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("determining docker engine API version: %w", err)
+	}
+	if versions.LessThan(version.APIVersion, platformAPIVersion) {
+		imagePlatform := imgspecv1.Platform{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant}
+		for _, wantedPlatform := range wantedPlatforms {
+			if platform.MatchesPlatform(imagePlatform, wantedPlatform) {
+				inputStream, err := c.ImageSave(ctx, []string{image})
+				if err != nil {
+					return nil, fmt.Errorf("loading image from docker engine: %w", err)
+				}
+				return inputStream, nil
+			}
+		}
+		return nil, fmt.Errorf("image %s in docker engine is for platform %s, but %s was requested, and selecting a platform requires docker engine API version %s or later (available: %s)",
+			image, platformString(imagePlatform), platformString(wantedPlatforms[0]), platformAPIVersion, version.APIVersion)
+	}
+	for _, wantedPlatform := range wantedPlatforms {
+		var inputStream io.ReadCloser
+		inputStream, err = imageSaveForPlatform(ctx, c, image, wantedPlatform)
+		if err == nil {
+			return inputStream, nil
+		}
+		if !errors.As(err, &imageNotForPlatformError{}) {
+			return nil, fmt.Errorf("loading image for platform %s from docker engine: %w", platformString(wantedPlatform), err)
+		}
+		logger.FromSystemContext(sys).Debugf("Image %s in docker engine is not available for platform %s", image, platformString(wantedPlatform))
+	}
+	return nil, fmt.Errorf("loading image for platform %s from docker engine: %w", platformString(wantedPlatforms[0]), err)
+}
+
+// platformString returns a human-readable representation of p.
+func platformString(p imgspecv1.Platform) string {
+	res := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		res += "/" + p.Variant
+	}
+	return res
+}
+
+// imageMetadata returns the metadata recorded by the docker engine in inspect.
+func imageMetadata(inspect dockertypes.ImageInspect) (private.ImageMetadata, error) {
+	res := private.ImageMetadata{
+		RepoTags:    inspect.RepoTags,
+		RepoDigests: inspect.RepoDigests,
+	}
+	if inspect.Created != "" {
+		created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+		if err != nil {
+			return private.ImageMetadata{}, fmt.Errorf("parsing creation time %q of image in docker engine: %w", inspect.Created, err)
+		}
+		res.Created = &created
+	}
+	return res, nil
+}
+
 // Reference returns the reference used to set up this source, _as specified by the user_
 // (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
 func (s *daemonImageSource) Reference() types.ImageReference {
 	return s.ref
 }
+
+// ImageMetadata returns the metadata recorded by the transport about the image.
+func (s *daemonImageSource) ImageMetadata(ctx context.Context) (private.ImageMetadata, error) {
+	return s.metadata, nil
+}
@@ -1,5 +1,149 @@
+//go:build !containers_image_docker_daemon_stub
+// +build !containers_image_docker_daemon_stub
+
 package daemon
 
-import "github.com/containers/image/v5/internal/private"
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/types"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 var _ private.ImageSource = (*daemonImageSource)(nil)
+var _ private.ImageSourceWithMetadata = (*daemonImageSource)(nil)
+
+// newTestDaemon returns a server implementing the parts of the docker engine API used to read an image,
+// which returns almostempty.tar for any image, with inspect.
+func newTestDaemon(t *testing.T, inspect dockertypes.ImageInspect) *httptest.Server {
+	archive, err := os.ReadFile("../archive/fixtures/almostempty.tar")
+	require.NoError(t, err)
+	inspectJSON, err := json.Marshal(inspect)
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v"+defaultAPIVersion+"/images/get", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	})
+	mux.HandleFunc("/v"+defaultAPIVersion+"/images/emptyimage:latest/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(inspectJSON)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDaemonImageSourceImageMetadata(t *testing.T) {
+	created := time.Date(2023, 4, 5, 6, 7, 8, 9, time.UTC)
+	repoTags := []string{"emptyimage:latest", "example.com/emptyimage:v1", "example.com/emptyimage:v1.0"}
+	repoDigests := []string{"example.com/emptyimage@sha256:0000000000000000000000000000000000000000000000000000000000000000"}
+	server := newTestDaemon(t, dockertypes.ImageInspect{
+		ID:          "sha256:9d7f147c0d0c4d4538a04c7ef385809e56eb1aac7bf800fbe976612188025b68",
+		RepoTags:    repoTags,
+		RepoDigests: repoDigests,
+		Created:     created.Format(time.RFC3339Nano),
+	})
+	sys := &types.SystemContext{DockerDaemonHost: server.URL}
+	ref, err := ParseReference("emptyimage:latest")
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), sys)
+	require.NoError(t, err)
+	defer src.Close()
+	metadataSource, ok := src.(private.ImageSourceWithMetadata)
+	require.True(t, ok)
+	metadata, err := metadataSource.ImageMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, repoTags, metadata.RepoTags)
+	assert.Equal(t, repoDigests, metadata.RepoDigests)
+	require.NotNil(t, metadata.Created)
+	assert.True(t, created.Equal(*metadata.Created))
+
+	// The metadata is included in the output of Inspect.
+	img, err := ref.NewImage(context.Background(), sys)
+	require.NoError(t, err)
+	defer img.Close()
+	info, err := img.Inspect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, repoTags, info.RepoTags)
+	assert.Equal(t, repoDigests, info.RepoDigests)
+	assert.NotNil(t, info.Created)
+}
+
+func TestDaemonImageSourcePlatform(t *testing.T) {
+	archive, err := os.ReadFile("../archive/fixtures/almostempty.tar")
+	require.NoError(t, err)
+	// newMultiPlatformDaemon returns a server with the API version apiVersion, which contains an image for linux/amd64 (the default)
+	// and linux/arm64, and records the platforms requested when exporting the image.
+	newMultiPlatformDaemon := func(apiVersion string) (*httptest.Server, *[]string) {
+		requestedPlatforms := []string{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/v"+defaultAPIVersion+"/version", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"ApiVersion":"` + apiVersion + `"}`))
+		})
+		mux.HandleFunc("/v"+defaultAPIVersion+"/images/emptyimage:latest/json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"Id":"sha256:9d7f147c0d0c4d4538a04c7ef385809e56eb1aac7bf800fbe976612188025b68","Os":"linux","Architecture":"amd64"}`))
+		})
+		mux.HandleFunc("/v"+defaultAPIVersion+"/images/get", func(w http.ResponseWriter, r *http.Request) {
+			requestedPlatforms = append(requestedPlatforms, "")
+			_, _ = w.Write(archive)
+		})
+		mux.HandleFunc("/v"+platformAPIVersion+"/images/get", func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, []string{"emptyimage:latest"}, r.URL.Query()["names"])
+			platform := r.URL.Query().Get("platform")
+			requestedPlatforms = append(requestedPlatforms, platform)
+			switch platform {
+			case `{"architecture":"amd64","os":"linux"}`, `{"architecture":"arm64","os":"linux"}`:
+				_, _ = w.Write(archive)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"message":"image with reference emptyimage:latest was found but does not match the specified platform"}`))
+			}
+		})
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		return server, &requestedPlatforms
+	}
+	ref, err := ParseReference("emptyimage:latest")
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		apiVersion, os, arch, variant string
+		expectedPlatforms             []string // "" if the default image is exported
+		expectedError                 string   // "" on success
+	}{
+		{"1.48", "", "", "", []string{""}, ""},
+		{"1.48", "linux", "arm64", "", []string{`{"architecture":"arm64","os":"linux"}`}, ""},
+		{"1.49", "linux", "amd64", "", []string{`{"architecture":"amd64","os":"linux"}`}, ""},
+		{"1.48", "linux", "s390x", "", []string{`{"architecture":"s390x","os":"linux"}`}, "does not match the specified platform"},
+		// Other compatible platforms are tried if the image is not available for the preferred one
+		{"1.48", "linux", "arm64", "v8", []string{`{"architecture":"arm64","os":"linux","variant":"v8"}`, `{"architecture":"arm64","os":"linux"}`}, ""},
+		// Older daemons can only export the default image
+		{"1.43", "", "", "", []string{""}, ""},
+		{"1.43", "linux", "amd64", "", []string{""}, ""},
+		{"1.43", "linux", "arm64", "", nil, "requires docker engine API version 1.48 or later"},
+	} {
+		server, requestedPlatforms := newMultiPlatformDaemon(c.apiVersion)
+		sys := &types.SystemContext{DockerDaemonHost: server.URL, OSChoice: c.os, ArchitectureChoice: c.arch, VariantChoice: c.variant}
+		src, err := ref.NewImageSource(context.Background(), sys)
+		if c.expectedError != "" {
+			assert.ErrorContains(t, err, c.expectedError, c.apiVersion+" "+c.arch)
+		} else {
+			require.NoError(t, err, c.apiVersion+" "+c.arch)
+			src.Close()
+			assert.Equal(t, c.expectedPlatforms, *requestedPlatforms, c.apiVersion+" "+c.arch)
+		}
+	}
+}
@@ -1,3 +1,6 @@
+//go:build !containers_image_docker_daemon_stub
+// +build !containers_image_docker_daemon_stub
+
 package daemon
 
 import (
@@ -27,6 +30,11 @@ func (t daemonTransport) Name() string {
 	return "docker-daemon"
 }
 
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t daemonTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
 func (t daemonTransport) ParseReference(reference string) (types.ImageReference, error) {
 	return ParseReference(reference)
@@ -0,0 +1,8 @@
+package daemon
+
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+// They are defined without build constraints, so that the stub transport in transports/alltransports can use them.
+const (
+	ReferenceSyntaxFormat  = "docker-daemon:{docker-reference|algo:digest}"
+	ReferenceSyntaxExample = "docker-daemon:busybox:latest"
+)
@@ -2,12 +2,14 @@ package docker
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -19,6 +21,8 @@ import (
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/logger"
+	internalManifest "github.com/containers/image/v5/internal/manifest"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/pkg/docker/config"
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
@@ -30,6 +34,7 @@ import (
 	v2 "github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/go-connections/tlsconfig"
 	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 )
@@ -41,17 +46,22 @@ const (
 
 	resolvedPingV2URL       = "%s://%s/v2/"
 	resolvedPingV1URL       = "%s://%s/v1/_ping"
+	catalogPath             = "/v2/_catalog"
 	tagsPath                = "/v2/%s/tags/list"
 	manifestPath            = "/v2/%s/manifests/%s"
 	blobsPath               = "/v2/%s/blobs/%s"
 	blobUploadPath          = "/v2/%s/blobs/uploads/"
 	extensionsSignaturePath = "/extensions/v2/%s/signatures/%s"
+	referrersPath           = "/v2/%s/referrers/%s"
 
 	minimumTokenLifetimeSeconds = 60
 
 	extensionSignatureSchemaVersion = 2        // extensionSignature.Version
 	extensionSignatureTypeAtomic    = "atomic" // extensionSignature.Type
 
+	// sigstoreSignatureArtifactType is the artifact type of sigstore signatures stored as OCI referrers, as used by cosign.
+	sigstoreSignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
 	backoffNumIterations = 5
 	backoffInitialDelay  = 2 * time.Second
 	backoffMaxDelay      = 60 * time.Second
@@ -100,15 +110,25 @@ type dockerClient struct {
 	sys       *types.SystemContext
 	registry  string
 	userAgent string
+	logger    logrus.FieldLogger // See types.SystemContext.Logger; never nil
 
 	// tlsClientConfig is setup by newDockerClient and will be used and updated
 	// by detectProperties(). Callers can edit tlsClientConfig.InsecureSkipVerify in the meantime.
-	tlsClientConfig *tls.Config
+	tlsClientConfig  *tls.Config
+	idleTimeout      time.Duration // 0 if not enforcing an idle timeout, see types.SystemContext.DockerRequestIdleTimeout
+	maxManifestSize  int           // See types.SystemContext.DockerMaxManifestSize; never 0
+	maxSignatureSize int           // See types.SystemContext.DockerMaxSignatureSize; never 0
+	extraHeaders     http.Header   // See types.SystemContext.DockerRegistryHeaders; may be nil
+	// If true, extraHeaders are also sent to the authentication server, see types.SystemContext.DockerRegistryHeadersForAuthServer
+	extraHeadersForAuthServer bool
 	// The following members are not set by newDockerClient and must be set by callers if needed.
 	auth                   types.DockerAuthConfig
 	registryToken          string
 	signatureBase          lookasideStorageBase
+	signatureStagingBase   lookasideStorageBase // If not nil, signatures are also read from this staging storage
+	readStagingFirst       bool                 // If signatureStagingBase is not nil, staged signatures are read before other signatures
 	useSigstoreAttachments bool
+	writeHTTPLookaside     bool // If true, signatures may be written to http(s) signature storage, see registryNamespace.WriteHTTPLookaside
 	scope                  authScope
 
 	// The following members are detected registry properties:
@@ -144,7 +164,7 @@ const (
 	noAuth
 )
 
-func newBearerTokenFromJSONBlob(blob []byte) (*bearerToken, error) {
+func newBearerTokenFromJSONBlob(logger logrus.FieldLogger, blob []byte) (*bearerToken, error) {
 	token := new(bearerToken)
 	if err := json.Unmarshal(blob, &token); err != nil {
 		return nil, err
@@ -154,7 +174,7 @@ func newBearerTokenFromJSONBlob(blob []byte) (*bearerToken, error) {
 	}
 	if token.ExpiresIn < minimumTokenLifetimeSeconds {
 		token.ExpiresIn = minimumTokenLifetimeSeconds
-		logrus.Debugf("Increasing token expiration to: %d seconds", token.ExpiresIn)
+		logger.Debugf("Increasing token expiration to: %d seconds", token.ExpiresIn)
 	}
 	if token.IssuedAt.IsZero() {
 		token.IssuedAt = time.Now().UTC()
@@ -204,7 +224,7 @@ func dockerCertDir(sys *types.SystemContext, hostPort string) (string, error) {
 			continue
 		}
 		if os.IsPermission(err) {
-			logrus.Debugf("error accessing certs directory due to permissions: %v", err)
+			logger.FromSystemContext(sys).Debugf("error accessing certs directory due to permissions: %v", err)
 			continue
 		}
 		return "", err
@@ -212,19 +232,82 @@ func dockerCertDir(sys *types.SystemContext, hostPort string) (string, error) {
 	return fullCertDirPath, nil
 }
 
+// clientCertificate returns the client certificate configuration for hostPort from sys or reg (which may be nil),
+// or an empty value if none is configured.
+// Settings in sys take precedence: reg.ClientCertDir is not used if sys specifies a certificate directory.
+func clientCertificate(sys *types.SystemContext, hostPort string, reg *sysregistriesv2.Registry) types.DockerClientCertificate {
+	if sys != nil {
+		if cert, ok := sys.DockerClientCertificates[hostPort]; ok {
+			return cert
+		}
+	}
+	if reg != nil {
+		cert := types.DockerClientCertificate{
+			CertDir:  reg.ClientCertDir,
+			CertFile: reg.ClientCert,
+			KeyFile:  reg.ClientKey,
+		}
+		if sys != nil && (sys.DockerCertPath != "" || sys.DockerPerHostCertDirPath != "") {
+			cert.CertDir = ""
+		}
+		return cert
+	}
+	return types.DockerClientCertificate{}
+}
+
+// setupClientCertificates sets up CA and client certificates in tlsc for connecting to hostPort,
+// matching sys and reg (which may be nil).
+func setupClientCertificates(sys *types.SystemContext, hostPort string, reg *sysregistriesv2.Registry, tlsc *tls.Config) error {
+	cert := clientCertificate(sys, hostPort, reg)
+	if (cert.CertFile == "") != (cert.KeyFile == "") {
+		return fmt.Errorf("both a client certificate and a key must be specified for %s", hostPort)
+	}
+	certDir := cert.CertDir
+	if certDir == "" {
+		d, err := dockerCertDir(sys, hostPort)
+		if err != nil {
+			return err
+		}
+		certDir = d
+	}
+	if err := tlsclientconfig.SetupCertificates(certDir, tlsc); err != nil {
+		return err
+	}
+	if cert.CertFile != "" {
+		keyPair, err := tls.LoadX509KeyPair(cert.CertFile, cert.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate %s for %s: %w", cert.CertFile, hostPort, err)
+		}
+		tlsc.Certificates = []tls.Certificate{keyPair}
+	}
+	return nil
+}
+
 // newDockerClientFromRef returns a new dockerClient instance for refHostname (a host a specified in the Docker image reference, not canonicalized to dockerRegistry)
 // “write” specifies whether the client will be used for "write" access (in particular passed to lookaside.go:toplevelFromSection)
 // signatureBase is always set in the return value
-func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, registryConfig *registryConfiguration, write bool, actions string) (*dockerClient, error) {
-	auth, err := config.GetCredentialsForRef(sys, ref.ref)
+func newDockerClientFromRef(ctx context.Context, sys *types.SystemContext, ref dockerReference, registryConfig *registryConfiguration, write bool, actions string) (*dockerClient, error) {
+	auth, err := config.GetCredentialsForRefWithContext(ctx, sys, ref.ref)
 	if err != nil {
 		return nil, fmt.Errorf("getting username and password: %w", err)
 	}
 
-	sigBase, err := registryConfig.lookasideStorageBaseURL(ref, write)
+	log := logger.FromSystemContext(sys)
+	sigBase, err := registryConfig.lookasideStorageBaseURL(log, ref, write)
 	if err != nil {
 		return nil, err
 	}
+	var stagingBase *url.URL
+	readStagingFirst := false
+	if !write {
+		stagingBase, readStagingFirst, err = registryConfig.lookasideStagingBaseURLForReading(log, ref)
+		if err != nil {
+			return nil, err
+		}
+		if stagingBase != nil && stagingBase.String() == sigBase.String() {
+			stagingBase = nil // The signatures are read from there anyway.
+		}
+	}
 
 	registry := reference.Domain(ref.ref)
 	client, err := newDockerClient(sys, registry, ref.ref.Name())
@@ -236,13 +319,21 @@ func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, regis
 		client.registryToken = sys.DockerBearerRegistryToken
 	}
 	client.signatureBase = sigBase
-	client.useSigstoreAttachments = registryConfig.useSigstoreAttachments(ref)
+	client.signatureStagingBase = stagingBase
+	client.readStagingFirst = readStagingFirst
+	client.useSigstoreAttachments = registryConfig.useSigstoreAttachments(log, ref)
+	client.writeHTTPLookaside = registryConfig.writeHTTPLookaside(log, ref)
 	client.scope.resourceType = "repository"
 	client.scope.actions = actions
 	client.scope.remoteName = reference.Path(ref.ref)
 	return client, nil
 }
 
+// blockedRegistryError returns an error refusing access to reg, which is blocked in the registries configuration.
+func blockedRegistryError(sys *types.SystemContext, reg *sysregistriesv2.Registry) error {
+	return fmt.Errorf("registry %s is blocked in %s or %s", reg.Prefix, sysregistriesv2.ConfigPath(sys), sysregistriesv2.ConfigDirPath(sys))
+}
+
 // newDockerClient returns a new dockerClient instance for the given registry
 // and reference.  The reference is used to query the registry configuration
 // and can either be a registry (e.g, "registry.com[:5000]"), a repository
@@ -255,18 +346,16 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 		registry = dockerRegistry
 	}
 	tlsClientConfig := serverDefault()
-
-	// It is undefined whether the host[:port] string for dockerHostname should be dockerHostname or dockerRegistry,
-	// because docker/docker does not read the certs.d subdirectory at all in that case.  We use the user-visible
-	// dockerHostname here, because it is more symmetrical to read the configuration in that case as well, and because
-	// generally the UI hides the existence of the different dockerRegistry.  But note that this behavior is
-	// undocumented and may change if docker/docker changes.
-	certDir, err := dockerCertDir(sys, hostName)
-	if err != nil {
-		return nil, err
+	if sys != nil && sys.DockerMinimumTLSVersion != 0 {
+		switch sys.DockerMinimumTLSVersion {
+		case tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+			tlsClientConfig.MinVersion = sys.DockerMinimumTLSVersion
+		default:
+			return nil, fmt.Errorf("unsupported minimum TLS version 0x%04x", sys.DockerMinimumTLSVersion)
+		}
 	}
-	if err := tlsclientconfig.SetupCertificates(certDir, tlsClientConfig); err != nil {
-		return nil, err
+	if sys != nil && sys.DockerNetwork != "" && sys.DockerNetwork != "tcp4" && sys.DockerNetwork != "tcp6" {
+		return nil, fmt.Errorf("unsupported network %q, only tcp4 and tcp6 can be used", sys.DockerNetwork)
 	}
 
 	// Check if TLS verification shall be skipped (default=false) which can
@@ -278,22 +367,56 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 	}
 	if reg != nil {
 		if reg.Blocked {
-			return nil, fmt.Errorf("registry %s is blocked in %s or %s", reg.Prefix, sysregistriesv2.ConfigPath(sys), sysregistriesv2.ConfigDirPath(sys))
+			return nil, blockedRegistryError(sys, reg)
 		}
 		skipVerify = reg.Insecure
 	}
 	tlsClientConfig.InsecureSkipVerify = skipVerify
 
+	// It is undefined whether the host[:port] string for dockerHostname should be dockerHostname or dockerRegistry,
+	// because docker/docker does not read the certs.d subdirectory at all in that case.  We use the user-visible
+	// dockerHostname here, because it is more symmetrical to read the configuration in that case as well, and because
+	// generally the UI hides the existence of the different dockerRegistry.  But note that this behavior is
+	// undocumented and may change if docker/docker changes.
+	if err := setupClientCertificates(sys, hostName, reg, tlsClientConfig); err != nil {
+		return nil, err
+	}
+
 	userAgent := defaultUserAgent
 	if sys != nil && sys.DockerRegistryUserAgent != "" {
 		userAgent = sys.DockerRegistryUserAgent
 	}
 
+	var idleTimeout time.Duration
+	maxManifestSize := iolimits.MaxManifestBodySize
+	maxSignatureSize := iolimits.MaxSignatureBodySize
+	var extraHeaders http.Header
+	extraHeadersForAuthServer := false
+	if sys != nil {
+		idleTimeout = sys.DockerRequestIdleTimeout
+		// As with setupClientCertificates above, this uses the user-visible host name.
+		extraHeaders = sys.DockerRegistryHeaders[hostName]
+		extraHeadersForAuthServer = sys.DockerRegistryHeadersForAuthServer
+		if sys.DockerMaxManifestSize > 0 {
+			maxManifestSize = sys.DockerMaxManifestSize
+		}
+		if sys.DockerMaxSignatureSize > 0 {
+			maxSignatureSize = sys.DockerMaxSignatureSize
+		}
+	}
+
 	return &dockerClient{
-		sys:             sys,
-		registry:        registry,
-		userAgent:       userAgent,
-		tlsClientConfig: tlsClientConfig,
+		sys:              sys,
+		registry:         registry,
+		userAgent:        userAgent,
+		logger:           logger.FromSystemContext(sys),
+		tlsClientConfig:  tlsClientConfig,
+		idleTimeout:      idleTimeout,
+		maxManifestSize:  maxManifestSize,
+		maxSignatureSize: maxSignatureSize,
+
+		extraHeaders:              extraHeaders,
+		extraHeadersForAuthServer: extraHeadersForAuthServer,
 	}, nil
 }
 
@@ -315,7 +438,7 @@ func CheckAuth(ctx context.Context, sys *types.SystemContext, username, password
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		err := registryHTTPResponseToError(resp)
+		err := registryHTTPResponseToError(client.logger, resp)
 		if resp.StatusCode == http.StatusUnauthorized {
 			err = ErrUnauthorizedForCredentials{Err: err}
 		}
@@ -353,29 +476,9 @@ func SearchRegistry(ctx context.Context, sys *types.SystemContext, registry, ima
 	}
 	v1Res := &V1Results{}
 
-	// Get credentials from authfile for the underlying hostname
-	// We can't use GetCredentialsForRef here because we want to search the whole registry.
-	auth, err := config.GetCredentials(sys, registry)
-	if err != nil {
-		return nil, fmt.Errorf("getting username and password: %w", err)
-	}
-
-	// The /v2/_catalog endpoint has been disabled for docker.io therefore
-	// the call made to that endpoint will fail.  So using the v1 hostname
-	// for docker.io for simplicity of implementation and the fact that it
-	// returns search results.
-	hostname := registry
-	if registry == dockerHostname {
-		hostname = dockerV1Hostname
-	}
-
-	client, err := newDockerClient(sys, hostname, registry)
+	client, err := newRegistryClient(ctx, sys, registry)
 	if err != nil {
-		return nil, fmt.Errorf("creating new docker client: %w", err)
-	}
-	client.auth = auth
-	if sys != nil {
-		client.registryToken = sys.DockerBearerRegistryToken
+		return nil, err
 	}
 
 	// Only try the v1 search endpoint if the search query is not empty. If it is
@@ -390,14 +493,14 @@ func SearchRegistry(ctx context.Context, sys *types.SystemContext, registry, ima
 		q.Set("n", strconv.Itoa(limit))
 		u.RawQuery = q.Encode()
 
-		logrus.Debugf("trying to talk to v1 search endpoint")
+		client.logger.Debugf("trying to talk to v1 search endpoint")
 		resp, err := client.makeRequest(ctx, http.MethodGet, u.String(), nil, nil, noAuth, nil)
 		if err != nil {
-			logrus.Debugf("error getting search results from v1 endpoint %q: %v", registry, err)
+			client.logger.Debugf("error getting search results from v1 endpoint %q: %v", registry, err)
 		} else {
 			defer resp.Body.Close()
 			if resp.StatusCode != http.StatusOK {
-				logrus.Debugf("error getting search results from v1 endpoint %q: %v", registry, httpResponseToError(resp, ""))
+				client.logger.Debugf("error getting search results from v1 endpoint %q: %v", registry, httpResponseToError(client.logger, resp, ""))
 			} else {
 				if err := json.NewDecoder(resp.Body).Decode(v1Res); err != nil {
 					return nil, err
@@ -407,19 +510,19 @@ func SearchRegistry(ctx context.Context, sys *types.SystemContext, registry, ima
 		}
 	}
 
-	logrus.Debugf("trying to talk to v2 search endpoint")
+	client.logger.Debugf("trying to talk to v2 search endpoint")
 	searchRes := []SearchResult{}
-	path := "/v2/_catalog"
+	path := catalogPath
 	for len(searchRes) < limit {
 		resp, err := client.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, nil)
 		if err != nil {
-			logrus.Debugf("error getting search results from v2 endpoint %q: %v", registry, err)
+			client.logger.Debugf("error getting search results from v2 endpoint %q: %v", registry, err)
 			return nil, fmt.Errorf("couldn't search registry %q: %w", registry, err)
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			err := registryHTTPResponseToError(resp)
-			logrus.Errorf("error getting search results from v2 endpoint %q: %v", registry, err)
+			err := registryHTTPResponseToError(client.logger, resp)
+			client.logger.Errorf("error getting search results from v2 endpoint %q: %v", registry, err)
 			return nil, fmt.Errorf("couldn't search registry %q: %w", registry, err)
 		}
 		v2Res := &V2Results{}
@@ -446,25 +549,234 @@ func SearchRegistry(ctx context.Context, sys *types.SystemContext, registry, ima
 			}
 		}
 
-		link := resp.Header.Get("Link")
-		if link == "" {
+		nextPath, err := nextPagePath(resp)
+		if err != nil {
+			return searchRes, err
+		}
+		if nextPath == "" {
 			break
 		}
-		linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
-		linkURL, err := url.Parse(linkURLStr)
+		path = nextPath
+	}
+	return searchRes, nil
+}
+
+// GetRepositories returns the names of all repositories in registry, as listed by the /v2/_catalog endpoint,
+// following all of the pages of the response.
+// Note that many registries restrict or disable the catalog endpoint; docker.io does not support it at all.
+func GetRepositories(ctx context.Context, sys *types.SystemContext, registry string) ([]string, error) {
+	client, err := newRegistryClient(ctx, sys, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	repositories := []string{}
+	visited := map[string]struct{}{}
+	path := catalogPath
+	for {
+		if _, ok := visited[path]; ok {
+			return nil, fmt.Errorf("listing repositories in registry %q: pagination loop detected at %q", registry, path)
+		}
+		visited[path] = struct{}{}
+
+		pageRepositories, nextPath, err := client.getCatalogPage(ctx, path)
 		if err != nil {
-			return searchRes, err
+			return nil, fmt.Errorf("listing repositories in registry %q: %w", registry, err)
+		}
+		repositories = append(repositories, pageRepositories...)
+		if nextPath == "" {
+			break
 		}
+		path = nextPath
+	}
+	return repositories, nil
+}
+
+// newRegistryClient returns a client for registry-wide operations, not specific to any one repository.
+func newRegistryClient(ctx context.Context, sys *types.SystemContext, registry string) (*dockerClient, error) {
+	// Get credentials from authfile for the underlying hostname
+	// We can't use GetCredentialsForRef here because we want to search the whole registry.
+	auth, err := config.GetCredentialsWithContext(ctx, sys, registry)
+	if err != nil {
+		return nil, fmt.Errorf("getting username and password: %w", err)
+	}
+
+	// The /v2/_catalog endpoint has been disabled for docker.io therefore
+	// the call made to that endpoint will fail.  So using the v1 hostname
+	// for docker.io for simplicity of implementation and the fact that it
+	// returns search results.
+	hostname := registry
+	if registry == dockerHostname {
+		hostname = dockerV1Hostname
+	}
 
-		// can be relative or absolute, but we only want the path (and I
-		// guess we're in trouble if it forwards to a new place...)
-		path = linkURL.Path
-		if linkURL.RawQuery != "" {
-			path += "?"
-			path += linkURL.RawQuery
+	client, err := newDockerClient(sys, hostname, registry)
+	if err != nil {
+		return nil, fmt.Errorf("creating new docker client: %w", err)
+	}
+	client.auth = auth
+	if sys != nil {
+		client.registryToken = sys.DockerBearerRegistryToken
+	}
+	return client, nil
+}
+
+// getCatalogPage fetches a single page of the /v2/_catalog response from path,
+// and returns the repositories it lists, and the path of the next page, or "" if this is the last page.
+//
+// Registries may require a separate token for each page (e.g. because they are scoped to the
+// `last` parameter, or because they are very short-lived); if a page is rejected as unauthorized,
+// the cached token is discarded and the request is retried once with a freshly obtained one.
+func (c *dockerClient) getCatalogPage(ctx context.Context, path string) ([]string, string, error) {
+	scope := &authScope{resourceType: "registry", remoteName: "catalog", actions: "*"}
+	res, err := c.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, scope)
+	if err != nil {
+		return nil, "", err
+	}
+	if res.StatusCode == http.StatusUnauthorized && c.registryToken == "" {
+		if _, newScope := needsRetryWithUpdatedScope(c.logger, nil, res); newScope != nil {
+			scope = newScope
+		}
+		res.Body.Close()
+		c.logger.Debugf("Catalog page %s rejected as unauthorized, retrying with a new token", path)
+		if err := c.invalidateCachedToken(scope); err != nil {
+			return nil, "", err
+		}
+		res, err = c.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, scope)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, "", registryHTTPResponseToError(c.logger, res)
+	}
+
+	var catalog struct {
+		// Repositories holds the results returned by the /v2/_catalog endpoint
+		Repositories []string `json:"repositories"`
+	}
+	body, err := decodedResponseBody(res)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.NewDecoder(body).Decode(&catalog); err != nil {
+		return nil, "", err
+	}
+
+	nextPath, err := nextPagePath(res)
+	if err != nil {
+		return nil, "", err
+	}
+	return catalog.Repositories, nextPath, nil
+}
+
+// nextPagePath returns the path (with a query, if any) of the next page of a paginated API response res, as specified by its Link header,
+// or "" if res is the last page.
+func nextPagePath(res *http.Response) (string, error) {
+	linkURLStr := ""
+	for _, header := range res.Header.Values("Link") {
+		target, found, err := nextLinkTarget(header)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			linkURLStr = target
+			break
+		}
+	}
+	if linkURLStr == "" {
+		return "", nil
+	}
+	linkURL, err := url.Parse(linkURLStr)
+	if err != nil {
+		return "", err
+	}
+
+	// can be relative or absolute, but we only want the path (and I
+	// guess we're in trouble if it forwards to a new place...)
+	nextPath := linkURL.Path
+	if linkURL.RawQuery != "" {
+		nextPath += "?"
+		nextPath += linkURL.RawQuery
+	}
+	return nextPath, nil
+}
+
+// nextLinkTarget returns the target of the link with a rel="next" parameter in header, the value of a Link header (RFC 8288),
+// which may contain several comma-separated links, and true; or false if there is no such link.
+func nextLinkTarget(header string) (string, bool, error) {
+	original := header
+	for {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			return "", false, nil
+		}
+		if header[0] != '<' {
+			return "", false, fmt.Errorf("invalid Link header %q", original)
+		}
+		end := strings.IndexByte(header, '>')
+		if end == -1 {
+			return "", false, fmt.Errorf("invalid Link header %q", original)
+		}
+		target := header[1:end]
+		header = header[end+1:]
+
+		isNext := false
+		for {
+			header = strings.TrimLeft(header, " \t")
+			if header == "" || header[0] != ';' {
+				break
+			}
+			header = strings.TrimLeft(header[1:], " \t")
+			nameEnd := strings.IndexAny(header, "=;,")
+			if nameEnd == -1 {
+				nameEnd = len(header)
+			}
+			name := strings.TrimSpace(header[:nameEnd])
+			header = header[nameEnd:]
+			value := ""
+			if header != "" && header[0] == '=' {
+				header = strings.TrimLeft(header[1:], " \t")
+				if header != "" && header[0] == '"' {
+					var sb strings.Builder
+					i := 1
+					for ; i < len(header) && header[i] != '"'; i++ {
+						if header[i] == '\\' && i+1 < len(header) {
+							i++
+						}
+						sb.WriteByte(header[i])
+					}
+					if i == len(header) {
+						return "", false, fmt.Errorf("invalid Link header %q", original)
+					}
+					value = sb.String()
+					header = header[i+1:]
+				} else {
+					valueEnd := strings.IndexAny(header, ";,")
+					if valueEnd == -1 {
+						valueEnd = len(header)
+					}
+					value = strings.TrimSpace(header[:valueEnd])
+					header = header[valueEnd:]
+				}
+			}
+			if strings.EqualFold(name, "rel") {
+				// The value is a space-separated list of relation types.
+				for _, rel := range strings.Fields(value) {
+					if strings.EqualFold(rel, "next") {
+						isNext = true
+					}
+				}
+			}
+		}
+		if isNext {
+			return target, true, nil
+		}
+		if header != "" && header[0] != ',' {
+			return "", false, fmt.Errorf("invalid Link header %q", original)
 		}
 	}
-	return searchRes, nil
 }
 
 // makeRequest creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
@@ -485,7 +797,7 @@ func (c *dockerClient) makeRequest(ctx context.Context, method, path string, hea
 // Checks if the auth headers in the response contain an indication of a failed
 // authorizdation because of an "insufficient_scope" error. If that's the case,
 // returns the required scope to be used for fetching a new token.
-func needsRetryWithUpdatedScope(err error, res *http.Response) (bool, *authScope) {
+func needsRetryWithUpdatedScope(logger logrus.FieldLogger, err error, res *http.Response) (bool, *authScope) {
 	if err == nil && res.StatusCode == http.StatusUnauthorized {
 		challenges := parseAuthHeader(res.Header)
 		for _, challenge := range challenges {
@@ -495,7 +807,7 @@ func needsRetryWithUpdatedScope(err error, res *http.Response) (bool, *authScope
 						if newScope, err := parseAuthScope(scope); err == nil {
 							return true, newScope
 						} else {
-							logrus.WithFields(logrus.Fields{
+							logger.WithFields(logrus.Fields{
 								"error":     err,
 								"scope":     scope,
 								"challenge": challenge,
@@ -510,13 +822,13 @@ func needsRetryWithUpdatedScope(err error, res *http.Response) (bool, *authScope
 }
 
 // parseRetryAfter determines the delay required by the "Retry-After" header in res and returns it,
-// silently falling back to fallbackDelay if the header is missing or invalid.
-func parseRetryAfter(res *http.Response, fallbackDelay time.Duration) time.Duration {
+// falling back to fallbackDelay if the header is missing or invalid; the header is only reported using logger.
+func parseRetryAfter(logger logrus.FieldLogger, res *http.Response, fallbackDelay time.Duration) time.Duration {
 	after := res.Header.Get("Retry-After")
 	if after == "" {
 		return fallbackDelay
 	}
-	logrus.Debugf("Detected 'Retry-After' header %q", after)
+	logger.Debugf("Detected 'Retry-After' header %q", after)
 	// First, check if we have a numerical value.
 	if num, err := strconv.ParseInt(after, 10, 64); err == nil {
 		return time.Duration(num) * time.Second
@@ -529,11 +841,11 @@ func parseRetryAfter(res *http.Response, fallbackDelay time.Duration) time.Durat
 		if delta > 0 {
 			return delta
 		}
-		logrus.Debugf("Retry-After date in the past, ignoring it")
+		logger.Debugf("Retry-After date in the past, ignoring it")
 		return fallbackDelay
 	}
 	// If the header contents are bogus, fall back to using the default exponential back off.
-	logrus.Debugf("Invalid Retry-After format, ignoring it")
+	logger.Debugf("Invalid Retry-After format, ignoring it")
 	return fallbackDelay
 }
 
@@ -562,8 +874,8 @@ func (c *dockerClient) makeRequestToResolvedURL(ctx context.Context, method stri
 		// We also cannot retry with a body (stream != nil) as stream
 		// was already read
 		if attempts == 1 && stream == nil && auth != noAuth {
-			if retry, newScope := needsRetryWithUpdatedScope(err, res); retry {
-				logrus.Debug("Detected insufficient_scope error, will retry request with updated scope")
+			if retry, newScope := needsRetryWithUpdatedScope(c.logger, err, res); retry {
+				c.logger.Debug("Detected insufficient_scope error, will retry request with updated scope")
 				// Note: This retry ignores extraScope. That’s, strictly speaking, incorrect, but we don’t currently
 				// expect the insufficient_scope errors to happen for those callers. If that changes, we can add support
 				// for more than one extra scope.
@@ -571,6 +883,13 @@ func (c *dockerClient) makeRequestToResolvedURL(ctx context.Context, method stri
 				extraScope = newScope
 			}
 		}
+		if err != nil && isIdleTimeoutError(err) && stream == nil && attempts < backoffNumIterations {
+			c.logger.Debugf("Request to %s stalled, retrying: %v", requestURL.Redacted(), err)
+			if sleepErr := sleepBeforeRetry(ctx, attempts); sleepErr != nil {
+				return nil, sleepErr
+			}
+			continue
+		}
 		if res == nil || res.StatusCode != http.StatusTooManyRequests || // Only retry on StatusTooManyRequests, success or other failure is returned to caller immediately
 			stream != nil || // We can't retry with a body (which is not restartable in the general case)
 			attempts == backoffNumIterations {
@@ -579,11 +898,11 @@ func (c *dockerClient) makeRequestToResolvedURL(ctx context.Context, method stri
 		// close response body before retry or context done
 		res.Body.Close()
 
-		delay = parseRetryAfter(res, delay)
+		delay = parseRetryAfter(c.logger, res, delay)
 		if delay > backoffMaxDelay {
 			delay = backoffMaxDelay
 		}
-		logrus.Debugf("Too many requests to %s: sleeping for %f seconds before next attempt", requestURL.Redacted(), delay.Seconds())
+		c.logger.Debugf("Too many requests to %s: sleeping for %f seconds before next attempt", requestURL.Redacted(), delay.Seconds())
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -613,19 +932,108 @@ func (c *dockerClient) makeRequestToResolvedURLOnce(ctx context.Context, method
 		}
 	}
 	req.Header.Add("User-Agent", c.userAgent)
+	if resolvedURL.Host == c.registry {
+		addExtraHeaders(req.Header, c.extraHeaders)
+	}
 	if auth == v2Auth {
 		if err := c.setupRequestAuth(req, extraScope); err != nil {
 			return nil, err
 		}
 	}
-	logrus.Debugf("%s %s", method, resolvedURL.Redacted())
+	var watchdog *idleWatchdog
+	if c.idleTimeout > 0 {
+		reqCtx, cancel := context.WithCancel(ctx)
+		watchdog = newIdleWatchdog(c.idleTimeout, cancel)
+		req = req.WithContext(reqCtx)
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &idleTimeoutUploadBody{body: req.Body, watchdog: watchdog}
+		}
+	}
+	c.logger.Debugf("%s %s", method, resolvedURL.Redacted())
 	res, err := c.client.Do(req)
+	if watchdog != nil {
+		watchdog.stop()
+		if err != nil {
+			watchdog.cancel()
+			return nil, watchdog.wrapError(err)
+		}
+		res.Body = &idleTimeoutBody{body: res.Body, watchdog: watchdog}
+	}
 	if err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 
+// addExtraHeaders adds extraHeaders (see types.SystemContext.DockerRegistryHeaders) to header,
+// except for headers which are already set.
+func addExtraHeaders(header http.Header, extraHeaders http.Header) {
+	for n, h := range extraHeaders {
+		if len(header.Values(n)) != 0 {
+			continue
+		}
+		for _, hh := range h {
+			header.Add(n, hh)
+		}
+	}
+}
+
+// checkRedirect is used as http.Client.CheckRedirect if c.extraHeaders is set, to avoid sending the headers to other hosts.
+func (c *dockerClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 { // The default limit of http.Client
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != c.registry {
+		for n, h := range c.extraHeaders {
+			// Headers which were set by us instead of addExtraHeaders are kept.
+			if values := req.Header.Values(n); len(values) == len(h) {
+				same := true
+				for i := range h {
+					if values[i] != h[i] {
+						same = false
+						break
+					}
+				}
+				if same {
+					req.Header.Del(n)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tokenCacheKey returns the key of c.tokenCache used for tokens obtained with extraScope (which may be nil).
+func tokenCacheKey(extraScope *authScope) (string, error) {
+	if extraScope == nil {
+		return "", nil
+	}
+	// Using ':' as a separator here is unambiguous because getBearerToken
+	// uses the same separator when formatting a remote request (and because
+	// repository names that we create can't contain colons, and extraScope values
+	// coming from a server come from `parseAuthScope`, which also splits on colons).
+	cacheKey := fmt.Sprintf("%s:%s:%s", extraScope.resourceType, extraScope.remoteName, extraScope.actions)
+	if colonCount := strings.Count(cacheKey, ":"); colonCount != 2 {
+		return "", fmt.Errorf(
+			"Internal error: there must be exactly 2 colons in the cacheKey ('%s') but got %d",
+			cacheKey,
+			colonCount,
+		)
+	}
+	return cacheKey, nil
+}
+
+// invalidateCachedToken discards a cached bearer token obtained with extraScope (which may be nil), if any,
+// so that the next request using extraScope obtains a new one.
+func (c *dockerClient) invalidateCachedToken(extraScope *authScope) error {
+	cacheKey, err := tokenCacheKey(extraScope)
+	if err != nil {
+		return err
+	}
+	c.tokenCache.Delete(cacheKey)
+	return nil
+}
+
 // we're using the challenges from the /v2/ ping response and not the one from the destination
 // URL in this request because:
 //
@@ -647,21 +1055,12 @@ func (c *dockerClient) setupRequestAuth(req *http.Request, extraScope *authScope
 		case "bearer":
 			registryToken := c.registryToken
 			if registryToken == "" {
-				cacheKey := ""
+				cacheKey, err := tokenCacheKey(extraScope)
+				if err != nil {
+					return err
+				}
 				scopes := []authScope{c.scope}
 				if extraScope != nil {
-					// Using ':' as a separator here is unambiguous because getBearerToken below
-					// uses the same separator when formatting a remote request (and because
-					// repository names that we create can't contain colons, and extraScope values
-					// coming from a server come from `parseAuthScope`, which also splits on colons).
-					cacheKey = fmt.Sprintf("%s:%s:%s", extraScope.resourceType, extraScope.remoteName, extraScope.actions)
-					if colonCount := strings.Count(cacheKey, ":"); colonCount != 2 {
-						return fmt.Errorf(
-							"Internal error: there must be exactly 2 colons in the cacheKey ('%s') but got %d",
-							cacheKey,
-							colonCount,
-						)
-					}
 					scopes = append(scopes, *extraScope)
 				}
 				var token bearerToken
@@ -691,10 +1090,10 @@ func (c *dockerClient) setupRequestAuth(req *http.Request, extraScope *authScope
 			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", registryToken))
 			return nil
 		default:
-			logrus.Debugf("no handler for %s authentication", challenge.Scheme)
+			c.logger.Debugf("no handler for %s authentication", challenge.Scheme)
 		}
 	}
-	logrus.Infof("None of the challenges sent by server (%s) are supported, trying an unauthenticated request anyway", strings.Join(schemeNames, ", "))
+	c.logger.Infof("None of the challenges sent by server (%s) are supported, trying an unauthenticated request anyway", strings.Join(schemeNames, ", "))
 	return nil
 }
 
@@ -729,13 +1128,16 @@ func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, challenge chall
 	authReq.Body = io.NopCloser(strings.NewReader(params.Encode()))
 	authReq.Header.Add("User-Agent", c.userAgent)
 	authReq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	logrus.Debugf("%s %s", authReq.Method, authReq.URL.Redacted())
+	if c.extraHeadersForAuthServer {
+		addExtraHeaders(authReq.Header, c.extraHeaders)
+	}
+	c.logger.Debugf("%s %s", authReq.Method, authReq.URL.Redacted())
 	res, err := c.client.Do(authReq)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
-	if err := httpResponseToError(res, "Trying to obtain access token"); err != nil {
+	if err := httpResponseToError(c.logger, res, "Trying to obtain access token"); err != nil {
 		return nil, err
 	}
 
@@ -744,7 +1146,7 @@ func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, challenge chall
 		return nil, err
 	}
 
-	return newBearerTokenFromJSONBlob(tokenBlob)
+	return newBearerTokenFromJSONBlob(c.logger, tokenBlob)
 }
 
 func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
@@ -780,14 +1182,17 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 		authReq.SetBasicAuth(c.auth.Username, c.auth.Password)
 	}
 	authReq.Header.Add("User-Agent", c.userAgent)
+	if c.extraHeadersForAuthServer {
+		addExtraHeaders(authReq.Header, c.extraHeaders)
+	}
 
-	logrus.Debugf("%s %s", authReq.Method, authReq.URL.Redacted())
+	c.logger.Debugf("%s %s", authReq.Method, authReq.URL.Redacted())
 	res, err := c.client.Do(authReq)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
-	if err := httpResponseToError(res, "Requesting bearer token"); err != nil {
+	if err := httpResponseToError(c.logger, res, "Requesting bearer token"); err != nil {
 		return nil, err
 	}
 	tokenBlob, err := iolimits.ReadAtMost(res.Body, iolimits.MaxAuthTokenBodySize)
@@ -795,7 +1200,75 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 		return nil, err
 	}
 
-	return newBearerTokenFromJSONBlob(tokenBlob)
+	return newBearerTokenFromJSONBlob(c.logger, tokenBlob)
+}
+
+// unixSocketOverridePrefix is the prefix of types.SystemContext.DockerHostOverrides values which specify a Unix domain socket.
+const unixSocketOverridePrefix = "unix://"
+
+// dialContextWithHostOverrides returns a DialContext function which connects using dial, to the addresses
+// specified in overrides (as in types.SystemContext.DockerHostOverrides) instead of the host names, if any.
+// Overrides starting with unixSocketOverridePrefix connect to the specified Unix domain socket.
+// The TLS server name used for SNI and certificate verification is not affected, because http.Transport
+// determines it from the request URL, not from the dialed address.
+// The overrides are reported to logger.
+func dialContextWithHostOverrides(logger logrus.FieldLogger, dial func(ctx context.Context, network, addr string) (net.Conn, error), overrides map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		override, ok := overrides[addr]
+		if !ok {
+			override, ok = overrides[host]
+		}
+		if ok {
+			if socketPath := strings.TrimPrefix(override, unixSocketOverridePrefix); socketPath != override {
+				logger.Debugf("Connecting to Unix socket %s instead of %s", socketPath, addr)
+				return dial(ctx, "unix", socketPath)
+			}
+			newAddr := net.JoinHostPort(override, port)
+			logger.Debugf("Connecting to %s instead of %s", newAddr, addr)
+			addr = newAddr
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// dialContextWithNetwork returns a DialContext function which connects using dial, using network (as in types.SystemContext.DockerNetwork)
+// instead of the generic "tcp" network. Connections to other networks (e.g. to Unix domain sockets) are not affected.
+func dialContextWithNetwork(dial func(ctx context.Context, network, addr string) (net.Conn, error), network string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, requestedNetwork, addr string) (net.Conn, error) {
+		if requestedNetwork == "tcp" {
+			requestedNetwork = network
+		}
+		return dial(ctx, requestedNetwork, addr)
+	}
+}
+
+// proxyWithOverrides returns a http.Transport.Proxy function which uses proxyURL, if not nil, instead of calling proxy,
+// and which sends the credentials in auth, if not nil, to the proxy.
+// net/http sends credentials included in the proxy URL in the Proxy-Authorization header, both for CONNECT requests
+// and for plain HTTP requests forwarded by the proxy.
+func proxyWithOverrides(proxy func(*http.Request) (*url.URL, error), proxyURL *url.URL, auth *types.DockerAuthConfig) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		var res *url.URL
+		if proxyURL != nil {
+			res = proxyURL
+		} else {
+			u, err := proxy(req)
+			if err != nil {
+				return nil, err
+			}
+			res = u
+		}
+		if res == nil || auth == nil {
+			return res, nil
+		}
+		withAuth := *res
+		withAuth.User = url.UserPassword(auth.Username, auth.Password)
+		return &withAuth, nil
+	}
 }
 
 // detectPropertiesHelper performs the work of detectProperties which executes
@@ -808,7 +1281,30 @@ func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 	}
 	tr := tlsclientconfig.NewTransport()
 	tr.TLSClientConfig = c.tlsClientConfig
+	// Don’t let net/http transparently decompress responses: blobs must be read exactly as stored, and a proxy
+	// could otherwise apply, or undo, a Content-Encoding on a compressed layer. Bodies of manifests and other
+	// API responses are decoded explicitly, using decodedResponseBody.
+	tr.DisableCompression = true
+	if c.sys != nil && c.sys.DockerNetwork != "" {
+		tr.DialContext = dialContextWithNetwork(tr.DialContext, c.sys.DockerNetwork)
+	}
+	if c.sys != nil && len(c.sys.DockerHostOverrides) != 0 {
+		tr.DialContext = dialContextWithHostOverrides(c.logger, tr.DialContext, c.sys.DockerHostOverrides)
+	}
+	if c.sys != nil && (c.sys.DockerProxyURL != nil || c.sys.DockerProxyAuthConfig != nil) {
+		tr.Proxy = proxyWithOverrides(tr.Proxy, c.sys.DockerProxyURL, c.sys.DockerProxyAuthConfig)
+	}
+	if c.sys != nil && c.sys.DockerMaxIdleConnsPerHost > 0 {
+		tr.DisableKeepAlives = false
+		tr.MaxIdleConnsPerHost = c.sys.DockerMaxIdleConnsPerHost
+	}
+	if c.sys != nil && c.sys.DockerMaxConnsPerHost > 0 {
+		tr.MaxConnsPerHost = c.sys.DockerMaxConnsPerHost
+	}
 	c.client = &http.Client{Transport: tr}
+	if len(c.extraHeaders) != 0 {
+		c.client.CheckRedirect = c.checkRedirect
+	}
 
 	ping := func(scheme string) error {
 		pingURL, err := url.Parse(fmt.Sprintf(resolvedPingV2URL, scheme, c.registry))
@@ -817,13 +1313,13 @@ func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 		}
 		resp, err := c.makeRequestToResolvedURL(ctx, http.MethodGet, pingURL, nil, nil, -1, noAuth, nil)
 		if err != nil {
-			logrus.Debugf("Ping %s err %s (%#v)", pingURL.Redacted(), err.Error(), err)
+			c.logger.Debugf("Ping %s err %s (%#v)", pingURL.Redacted(), err.Error(), err)
 			return err
 		}
 		defer resp.Body.Close()
-		logrus.Debugf("Ping %s status %d", pingURL.Redacted(), resp.StatusCode)
+		c.logger.Debugf("Ping %s status %d", pingURL.Redacted(), resp.StatusCode)
 		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
-			return registryHTTPResponseToError(resp)
+			return registryHTTPResponseToError(c.logger, resp)
 		}
 		c.challenges = parseAuthHeader(resp.Header)
 		c.scheme = scheme
@@ -847,11 +1343,11 @@ func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 			}
 			resp, err := c.makeRequestToResolvedURL(ctx, http.MethodGet, pingURL, nil, nil, -1, noAuth, nil)
 			if err != nil {
-				logrus.Debugf("Ping %s err %s (%#v)", pingURL.Redacted(), err.Error(), err)
+				c.logger.Debugf("Ping %s err %s (%#v)", pingURL.Redacted(), err.Error(), err)
 				return false
 			}
 			defer resp.Body.Close()
-			logrus.Debugf("Ping %s status %d", pingURL.Redacted(), resp.StatusCode)
+			c.logger.Debugf("Ping %s status %d", pingURL.Redacted(), resp.StatusCode)
 			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
 				return false
 			}
@@ -875,26 +1371,101 @@ func (c *dockerClient) detectProperties(ctx context.Context) error {
 	return c.detectPropertiesError
 }
 
-func (c *dockerClient) fetchManifest(ctx context.Context, ref dockerReference, tagOrDigest string) ([]byte, string, error) {
+// fetchManifest fetches the manifest for tagOrDigest in ref, and returns it along with its MIME type.
+// If the registry provided a Docker-Content-Digest header which matches the manifest, the returned digest is that value;
+// otherwise it is "".
+func (c *dockerClient) fetchManifest(ctx context.Context, ref dockerReference, tagOrDigest string) ([]byte, string, digest.Digest, error) {
 	path := fmt.Sprintf(manifestPath, reference.Path(ref.ref), tagOrDigest)
+	accept := manifest.DefaultRequestedManifestMIMETypes
+	if c.rejectsSchema1() {
+		accept = []string{}
+		for _, t := range manifest.DefaultRequestedManifestMIMETypes {
+			if !internalManifest.IsSchema1MIMEType(t) {
+				accept = append(accept, t)
+			}
+		}
+	}
 	headers := map[string][]string{
-		"Accept": manifest.DefaultRequestedManifestMIMETypes,
+		"Accept": accept,
 	}
 	res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, "", "", err
 	}
-	logrus.Debugf("Content-Type from manifest GET is %q", res.Header.Get("Content-Type"))
+	c.logger.Debugf("Content-Type from manifest GET is %q", res.Header.Get("Content-Type"))
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("reading manifest %s in %s: %w", tagOrDigest, ref.ref.Name(), registryHTTPResponseToError(res))
+		return nil, "", "", fmt.Errorf("reading manifest %s in %s: %w", tagOrDigest, ref.ref.Name(), registryHTTPResponseToError(c.logger, res))
 	}
 
-	manblob, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+	body, err := decodedResponseBody(res)
 	if err != nil {
-		return nil, "", err
+		return nil, "", "", fmt.Errorf("reading manifest %s in %s: %w", tagOrDigest, ref.ref.Name(), err)
 	}
-	return manblob, simplifyContentType(res.Header.Get("Content-Type")), nil
+	manblob, err := iolimits.ReadAtMost(body, c.maxManifestSize)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("reading manifest %s in %s: %w", tagOrDigest, ref.ref.Name(), err)
+	}
+	verifiedDigest, err := c.verifyManifestDigestHeader(ref, tagOrDigest, manblob, res.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return nil, "", "", err
+	}
+	mimeType := simplifyContentType(res.Header.Get("Content-Type"))
+	if c.rejectsSchema1() && (internalManifest.IsSchema1MIMEType(mimeType) || internalManifest.IsSchema1MIMEType(manifest.GuessMIMEType(manblob))) {
+		return nil, "", "", fmt.Errorf("reading manifest %s in %s: Docker schema1 manifests are disabled", tagOrDigest, ref.ref.Name())
+	}
+	return manblob, mimeType, verifiedDigest, nil
+}
+
+// decodedResponseBody returns the body of res, a response containing a manifest or another JSON document,
+// decoded according to its Content-Encoding header.
+// This must not be used for blobs: their compression, if any, is a part of their contents, verified by the digest.
+func decodedResponseBody(res *http.Response) (io.Reader, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return res.Body, nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding gzip-encoded response: %w", err)
+		}
+		return r, nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
+
+// rejectsSchema1 returns true if Docker schema1 manifests must not be read nor written.
+func (c *dockerClient) rejectsSchema1() bool {
+	return c.sys != nil && c.sys.DockerRejectSchema1Manifests
+}
+
+// verifyManifestDigestHeader checks that manblob, fetched for tagOrDigest in ref, matches headerValue of a Docker-Content-Digest header,
+// which protects against corrupted responses, e.g. by a misbehaving proxy.
+// It returns the verified digest, or "" if there is no header value to verify.
+// An invalid header, or a manifest which does not match it, is always an error. A missing header is accepted unless
+// c.sys.DockerStrictManifestDigestVerification; callers that need a trusted manifest must still verify it against a known digest.
+func (c *dockerClient) verifyManifestDigestHeader(ref dockerReference, tagOrDigest string, manblob []byte, headerValue string) (digest.Digest, error) {
+	if headerValue == "" {
+		// When fetching by digest, the caller will verify the manifest against that value.
+		if _, err := digest.Parse(tagOrDigest); err != nil && c.sys != nil && c.sys.DockerStrictManifestDigestVerification {
+			return "", fmt.Errorf("reading manifest %s in %s: the registry did not provide a Docker-Content-Digest header", tagOrDigest, ref.ref.Name())
+		}
+		return "", nil
+	}
+
+	expectedDigest, err := digest.Parse(headerValue)
+	if err != nil {
+		return "", fmt.Errorf("reading manifest %s in %s: invalid Docker-Content-Digest header %q: %w", tagOrDigest, ref.ref.Name(), headerValue, err)
+	}
+	matches, err := manifest.MatchesDigest(manblob, expectedDigest)
+	if err != nil {
+		return "", fmt.Errorf("verifying manifest %s in %s against Docker-Content-Digest %s: %w", tagOrDigest, ref.ref.Name(), expectedDigest, err)
+	}
+	if !matches {
+		return "", fmt.Errorf("reading manifest %s in %s: manifest does not match Docker-Content-Digest %s", tagOrDigest, ref.ref.Name(), expectedDigest)
+	}
+	return expectedDigest, nil
 }
 
 // getExternalBlob returns the reader of the first available blob URL from urls, which must not be empty.
@@ -920,7 +1491,7 @@ func (c *dockerClient) getExternalBlob(ctx context.Context, urls []string) (io.R
 		if err == nil {
 			if resp.StatusCode != http.StatusOK {
 				err = fmt.Errorf("error fetching external blob from %q: %d (%s)", u, resp.StatusCode, http.StatusText(resp.StatusCode))
-				logrus.Debug(err)
+				c.logger.Debug(err)
 				resp.Body.Close()
 				continue
 			}
@@ -958,17 +1529,21 @@ func (c *dockerClient) getBlob(ctx context.Context, ref dockerReference, info ty
 	}
 
 	path := fmt.Sprintf(blobsPath, reference.Path(ref.ref), info.Digest.String())
-	logrus.Debugf("Downloading %s", path)
+	c.logger.Debugf("Downloading %s", path)
 	res, err := c.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, nil)
 	if err != nil {
 		return nil, 0, err
 	}
 	if res.StatusCode != http.StatusOK {
-		err := registryHTTPResponseToError(res)
+		err := registryHTTPResponseToError(c.logger, res)
 		res.Body.Close()
 		return nil, 0, fmt.Errorf("fetching blob: %w", err)
 	}
 	cache.RecordKnownLocation(ref.Transport(), bicTransportScope(ref), info.Digest, newBICLocationReference(ref))
+	if supportsByteRanges(res) {
+		return &resumingBlobReader{c: c, ctx: ctx, path: path, body: res.Body}, getBlobSize(res), nil
+	}
+	c.logger.Debugf("The registry does not indicate support for range requests for %s, an interrupted download can't be resumed", path)
 	return res.Body, getBlobSize(res), nil
 }
 
@@ -1008,6 +1583,31 @@ func isManifestUnknownError(err error) bool {
 	return false
 }
 
+// imageExists returns true if the registry contains a manifest for ref, and false if it does not.
+// Failures other than the registry reporting that the manifest (or the whole repository) is unknown are returned as errors.
+func imageExists(ctx context.Context, sys *types.SystemContext, ref dockerReference) (bool, error) {
+	registryConfig, err := loadRegistryConfiguration(sys)
+	if err != nil {
+		return false, err
+	}
+	c, err := newDockerClientFromRef(ctx, sys, ref, registryConfig, false, "pull")
+	if err != nil {
+		return false, err
+	}
+	tagOrDigest, err := ref.tagOrDigest()
+	if err != nil {
+		return false, err
+	}
+	if _, _, _, err := c.fetchManifest(ctx, ref, tagOrDigest); err != nil {
+		var ec errcode.ErrorCoder
+		if isManifestUnknownError(err) || (errors.As(err, &ec) && ec.ErrorCode() == v2.ErrorCodeNameUnknown) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // getSigstoreAttachmentManifest loads and parses the manifest for sigstore attachments for
 // digest in ref.
 // It returns (nil, nil) if the manifest does not exist.
@@ -1017,17 +1617,17 @@ func (c *dockerClient) getSigstoreAttachmentManifest(ctx context.Context, ref do
 	if err != nil {
 		return nil, err
 	}
-	logrus.Debugf("Looking for sigstore attachments in %s", sigstoreRef.String())
-	manifestBlob, mimeType, err := c.fetchManifest(ctx, ref, tag)
+	c.logger.Debugf("Looking for sigstore attachments in %s", sigstoreRef.String())
+	manifestBlob, mimeType, _, err := c.fetchManifest(ctx, ref, tag)
 	if err != nil {
 		// FIXME: Are we going to need better heuristics??
 		// This alone is probably a good enough reason for sigstore to be opt-in only,
 		// otherwise we would just break ordinary copies.
 		if isManifestUnknownError(err) {
-			logrus.Debugf("Fetching sigstore attachment manifest failed, assuming it does not exist: %v", err)
+			c.logger.Debugf("Fetching sigstore attachment manifest failed, assuming it does not exist: %v", err)
 			return nil, nil
 		}
-		logrus.Debugf("Fetching sigstore attachment manifest failed: %v", err)
+		c.logger.Debugf("Fetching sigstore attachment manifest failed: %v", err)
 		return nil, err
 	}
 	if mimeType != imgspecv1.MediaTypeImageManifest {
@@ -1042,6 +1642,115 @@ func (c *dockerClient) getSigstoreAttachmentManifest(ctx context.Context, ref do
 	return res, nil
 }
 
+// getReferrersTagIndex loads and parses the referrers index at tag in ref, as defined by the
+// “referrers tag schema” of the OCI distribution spec.
+// It returns an empty index if the tag does not exist.
+func (c *dockerClient) getReferrersTagIndex(ctx context.Context, ref dockerReference, tag string) (*imgspecv1.Index, error) {
+	manifestBlob, mimeType, _, err := c.fetchManifest(ctx, ref, tag)
+	if err != nil {
+		if isManifestUnknownError(err) {
+			c.logger.Debugf("Fetching referrers index failed, assuming it does not exist: %v", err)
+			return &imgspecv1.Index{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: imgspecv1.MediaTypeImageIndex,
+				Manifests: []imgspecv1.Descriptor{},
+			}, nil
+		}
+		return nil, err
+	}
+	if mimeType != imgspecv1.MediaTypeImageIndex {
+		return nil, fmt.Errorf("unexpected MIME type for referrers index %s:%s: %q", ref.ref.Name(), tag, mimeType)
+	}
+	index := imgspecv1.Index{}
+	if err := json.Unmarshal(manifestBlob, &index); err != nil {
+		return nil, fmt.Errorf("parsing referrers index %s:%s: %w", ref.ref.Name(), tag, err)
+	}
+	return &index, nil
+}
+
+// getReferrers returns descriptors of the manifests of artifacts with artifactType (or of all types, if artifactType is "") referring to manifestDigest in ref,
+// using the referrers API or, if the registry does not support it, the “referrers tag schema” fallback of the OCI distribution spec.
+// If the registry paginates the referrers API response, all pages are read.
+func (c *dockerClient) getReferrers(ctx context.Context, ref dockerReference, manifestDigest digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	path := fmt.Sprintf(referrersPath, reference.Path(ref.ref), manifestDigest.String())
+	if artifactType != "" {
+		path += "?artifactType=" + url.QueryEscape(artifactType)
+	}
+	descriptors := []imgspecv1.Descriptor{}
+	visited := map[string]struct{}{}
+	for path != "" {
+		if _, ok := visited[path]; ok {
+			return nil, fmt.Errorf("fetching referrers of %s in %s: the registry returned a loop of pages, at %s", manifestDigest, ref.ref.Name(), path)
+		}
+		visited[path] = struct{}{}
+		index, nextPath, err := c.getReferrersPage(ctx, ref, manifestDigest, path)
+		if err != nil {
+			return nil, err
+		}
+		if index == nil {
+			if len(visited) != 1 {
+				return nil, fmt.Errorf("fetching referrers of %s in %s: page %s not found", manifestDigest, ref.ref.Name(), path)
+			}
+			c.logger.Debugf("The registry does not support the referrers API, using the referrers tag schema")
+			index, err = c.getReferrersTagIndex(ctx, ref, referrersTag(manifestDigest))
+			if err != nil {
+				return nil, err
+			}
+		}
+		descriptors = append(descriptors, index.Manifests...)
+		path = nextPath
+	}
+
+	// The registry is not required to support filtering, and the referrers tag schema does not support it at all.
+	referrers := []imgspecv1.Descriptor{}
+	for _, desc := range descriptors {
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			referrers = append(referrers, desc)
+		}
+	}
+	return referrers, nil
+}
+
+// getReferrersPage fetches a single page of the referrers API response for manifestDigest in ref from path,
+// and returns the index it contains, and the path of the next page, or "" if this is the last page.
+// It returns a nil index if the registry responds with 404 Not Found, or with another status indicating that the referrers API
+// is not implemented (400 Bad Request, 405 Method Not Allowed or 501 Not Implemented, as returned by some registries).
+func (c *dockerClient) getReferrersPage(ctx context.Context, ref dockerReference, manifestDigest digest.Digest, path string) (*imgspecv1.Index, string, error) {
+	headers := map[string][]string{
+		"Accept": {imgspecv1.MediaTypeImageIndex},
+	}
+	res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		c.logger.Debugf("Fetching referrers of %s in %s: status %d", manifestDigest, ref.ref.Name(), res.StatusCode)
+		return nil, "", nil
+	default:
+		return nil, "", fmt.Errorf("fetching referrers of %s in %s: %w", manifestDigest, ref.ref.Name(), registryHTTPResponseToError(c.logger, res))
+	}
+	decoded, err := decodedResponseBody(res)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading referrers of %s in %s: %w", manifestDigest, ref.ref.Name(), err)
+	}
+	body, err := iolimits.ReadAtMost(decoded, c.maxManifestSize)
+	if err != nil {
+		return nil, "", err
+	}
+	index := imgspecv1.Index{}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, "", fmt.Errorf("parsing referrers of %s in %s: %w", manifestDigest, ref.ref.Name(), err)
+	}
+	nextPath, err := nextPagePath(res)
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing the next page of referrers of %s in %s: %w", manifestDigest, ref.ref.Name(), err)
+	}
+	return &index, nextPath, nil
+}
+
 // getExtensionsSignatures returns signatures from the X-Registry-Supports-Signatures API extension,
 // using the original data structures.
 func (c *dockerClient) getExtensionsSignatures(ctx context.Context, ref dockerReference, manifestDigest digest.Digest) (*extensionSignatureList, error) {
@@ -1052,7 +1761,7 @@ func (c *dockerClient) getExtensionsSignatures(ctx context.Context, ref dockerRe
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("downloading signatures for %s in %s: %w", manifestDigest, ref.ref.Name(), registryHTTPResponseToError(res))
+		return nil, fmt.Errorf("downloading signatures for %s in %s: %w", manifestDigest, ref.ref.Name(), registryHTTPResponseToError(c.logger, res))
 	}
 
 	body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxSignatureListBodySize)
@@ -1067,7 +1776,47 @@ func (c *dockerClient) getExtensionsSignatures(ctx context.Context, ref dockerRe
 	return &parsedBody, nil
 }
 
+// referrersTag returns the tag of the referrers index for the specified digest, as defined by the
+// “referrers tag schema” of the OCI distribution spec.
+func referrersTag(d digest.Digest) string {
+	algorithm, encoded := d.Algorithm().String(), d.Encoded()
+	// The spec truncates the components to fit in the maximum tag length.
+	if len(algorithm) > 32 {
+		algorithm = algorithm[:32]
+	}
+	if len(encoded) > 64 {
+		encoded = encoded[:64]
+	}
+	return algorithm + "-" + encoded
+}
+
 // sigstoreAttachmentTag returns a sigstore attachment tag for the specified digest.
 func sigstoreAttachmentTag(d digest.Digest) string {
 	return strings.Replace(d.String(), ":", "-", 1) + ".sig"
 }
+
+// blobExists returns true iff repo contains a blob with digest, and if so, also its size.
+// If the destination does not contain the blob, or it is unknown, blobExists ordinarily returns (false, -1, nil);
+// it returns a non-nil error only on an unexpected failure.
+func (c *dockerClient) blobExists(ctx context.Context, repo reference.Named, digest digest.Digest, extraScope *authScope) (bool, int64, error) {
+	checkPath := fmt.Sprintf(blobsPath, reference.Path(repo), digest.String())
+	c.logger.Debugf("Checking %s", checkPath)
+	res, err := c.makeRequest(ctx, http.MethodHead, checkPath, nil, nil, v2Auth, extraScope)
+	if err != nil {
+		return false, -1, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		c.logger.Debugf("... already exists")
+		return true, getBlobSize(res), nil
+	case http.StatusUnauthorized:
+		c.logger.Debugf("... not authorized")
+		return false, -1, fmt.Errorf("checking whether a blob %s exists in %s: %w", digest, repo.Name(), registryHTTPResponseToError(c.logger, res))
+	case http.StatusNotFound:
+		c.logger.Debugf("... not present")
+		return false, -1, nil
+	default:
+		return false, -1, fmt.Errorf("checking whether a blob %s exists in %s: %w", digest, repo.Name(), registryHTTPResponseToError(c.logger, res))
+	}
+}
@@ -4,16 +4,36 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -94,7 +114,7 @@ func TestDockerCertDir(t *testing.T) {
 func TestNewBearerTokenFromJsonBlob(t *testing.T) {
 	expected := &bearerToken{Token: "IAmAToken", ExpiresIn: 100, IssuedAt: time.Unix(1514800802, 0)}
 	tokenBlob := []byte(`{"token":"IAmAToken","expires_in":100,"issued_at":"2018-01-01T10:00:02+00:00"}`)
-	token, err := newBearerTokenFromJSONBlob(tokenBlob)
+	token, err := newBearerTokenFromJSONBlob(logrus.StandardLogger(), tokenBlob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -105,7 +125,7 @@ func TestNewBearerTokenFromJsonBlob(t *testing.T) {
 func TestNewBearerAccessTokenFromJsonBlob(t *testing.T) {
 	expected := &bearerToken{Token: "IAmAToken", ExpiresIn: 100, IssuedAt: time.Unix(1514800802, 0)}
 	tokenBlob := []byte(`{"access_token":"IAmAToken","expires_in":100,"issued_at":"2018-01-01T10:00:02+00:00"}`)
-	token, err := newBearerTokenFromJSONBlob(tokenBlob)
+	token, err := newBearerTokenFromJSONBlob(logrus.StandardLogger(), tokenBlob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -115,7 +135,7 @@ func TestNewBearerAccessTokenFromJsonBlob(t *testing.T) {
 
 func TestNewBearerTokenFromInvalidJsonBlob(t *testing.T) {
 	tokenBlob := []byte("IAmNotJson")
-	_, err := newBearerTokenFromJSONBlob(tokenBlob)
+	_, err := newBearerTokenFromJSONBlob(logrus.StandardLogger(), tokenBlob)
 	if err == nil {
 		t.Fatalf("unexpected an error unmarshaling JSON")
 	}
@@ -124,7 +144,7 @@ func TestNewBearerTokenFromInvalidJsonBlob(t *testing.T) {
 func TestNewBearerTokenSmallExpiryFromJsonBlob(t *testing.T) {
 	expected := &bearerToken{Token: "IAmAToken", ExpiresIn: 60, IssuedAt: time.Unix(1514800802, 0)}
 	tokenBlob := []byte(`{"token":"IAmAToken","expires_in":1,"issued_at":"2018-01-01T10:00:02+00:00"}`)
-	token, err := newBearerTokenFromJSONBlob(tokenBlob)
+	token, err := newBearerTokenFromJSONBlob(logrus.StandardLogger(), tokenBlob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -136,7 +156,7 @@ func TestNewBearerTokenIssuedAtZeroFromJsonBlob(t *testing.T) {
 	zeroTime := time.Time{}.Format(time.RFC3339)
 	now := time.Now()
 	tokenBlob := []byte(fmt.Sprintf(`{"token":"IAmAToken","expires_in":100,"issued_at":"%s"}`, zeroTime))
-	token, err := newBearerTokenFromJSONBlob(tokenBlob)
+	token, err := newBearerTokenFromJSONBlob(logrus.StandardLogger(), tokenBlob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -192,7 +212,7 @@ func TestUserAgent(t *testing.T) {
 }
 
 func TestNeedsRetryOnError(t *testing.T) {
-	needsRetry, _ := needsRetryWithUpdatedScope(errors.New("generic"), nil)
+	needsRetry, _ := needsRetryWithUpdatedScope(logrus.StandardLogger(), errors.New("generic"), nil)
 	if needsRetry {
 		t.Fatal("Got needRetry for a connection that included an error")
 	}
@@ -226,7 +246,7 @@ func TestNeedsRetryOnInsuficientScope(t *testing.T) {
 		actions:      "*",
 	}
 
-	needsRetry, scope := needsRetryWithUpdatedScope(nil, &resp)
+	needsRetry, scope := needsRetryWithUpdatedScope(logrus.StandardLogger(), nil, &resp)
 
 	if !needsRetry {
 		t.Fatal("Expected needing to retry")
@@ -241,7 +261,7 @@ func TestNeedsRetryNoRetryWhenNoAuthHeader(t *testing.T) {
 	resp := registrySuseComResp
 	delete(resp.Header, "Www-Authenticate")
 
-	needsRetry, _ := needsRetryWithUpdatedScope(nil, &resp)
+	needsRetry, _ := needsRetryWithUpdatedScope(logrus.StandardLogger(), nil, &resp)
 
 	if needsRetry {
 		t.Fatal("Expected no need to retry, as no Authentication headers are present")
@@ -254,7 +274,7 @@ func TestNeedsRetryNoRetryWhenNoBearerAuthHeader(t *testing.T) {
 		`OAuth2 realm="https://registry.suse.com/auth",service="SUSE Linux Docker Registry",scope="registry:catalog:*"`,
 	}
 
-	needsRetry, _ := needsRetryWithUpdatedScope(nil, &resp)
+	needsRetry, _ := needsRetryWithUpdatedScope(logrus.StandardLogger(), nil, &resp)
 
 	if needsRetry {
 		t.Fatal("Expected no need to retry, as no bearer authentication header is present")
@@ -267,7 +287,7 @@ func TestNeedsRetryNoRetryWhenNoErrorInBearer(t *testing.T) {
 		`Bearer realm="https://registry.suse.com/auth",service="SUSE Linux Docker Registry",scope="registry:catalog:*"`,
 	}
 
-	needsRetry, _ := needsRetryWithUpdatedScope(nil, &resp)
+	needsRetry, _ := needsRetryWithUpdatedScope(logrus.StandardLogger(), nil, &resp)
 
 	if needsRetry {
 		t.Fatal("Expected no need to retry, as no insufficient error is present in the authentication header")
@@ -280,7 +300,7 @@ func TestNeedsRetryNoRetryWhenInvalidErrorInBearer(t *testing.T) {
 		`Bearer realm="https://registry.suse.com/auth",service="SUSE Linux Docker Registry",scope="registry:catalog:*,error="random_error"`,
 	}
 
-	needsRetry, _ := needsRetryWithUpdatedScope(nil, &resp)
+	needsRetry, _ := needsRetryWithUpdatedScope(logrus.StandardLogger(), nil, &resp)
 
 	if needsRetry {
 		t.Fatal("Expected no need to retry, as no insufficient_error is present in the authentication header")
@@ -293,7 +313,7 @@ func TestNeedsRetryNoRetryWhenInvalidScope(t *testing.T) {
 		`Bearer realm="https://registry.suse.com/auth",service="SUSE Linux Docker Registry",scope="foo:bar",error="insufficient_scope"`,
 	}
 
-	needsRetry, _ := needsRetryWithUpdatedScope(nil, &resp)
+	needsRetry, _ := needsRetryWithUpdatedScope(logrus.StandardLogger(), nil, &resp)
 
 	if needsRetry {
 		t.Fatal("Expected no need to retry, as no insufficient_error is present in the authentication header")
@@ -325,12 +345,47 @@ func TestNeedsNoRetry(t *testing.T) {
 		},
 	}
 
-	needsRetry, _ := needsRetryWithUpdatedScope(nil, &resp)
+	needsRetry, _ := needsRetryWithUpdatedScope(logrus.StandardLogger(), nil, &resp)
 	if needsRetry {
 		t.Fatal("Got the need to retry, but none should be required")
 	}
 }
 
+func TestNextPagePath(t *testing.T) {
+	for _, c := range []struct {
+		headers  []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{`</v2/_catalog?last=a&n=100>; rel="next"`}, "/v2/_catalog?last=a&n=100"},
+		{[]string{`<https://registry.example/v2/_catalog?last=a>; rel=next`}, "/v2/_catalog?last=a"},
+		{[]string{`</v2/_catalog>; rel="prev"`}, ""},
+		{[]string{`</v2/_catalog?first>; rel="prev", </v2/_catalog?last=a>; rel="next"`}, "/v2/_catalog?last=a"},
+		{[]string{`</v2/_catalog?first>; rel="first prev"; title="a, \"b\"", </v2/_catalog?last=a>; title=x; REL="last next"`}, "/v2/_catalog?last=a"},
+		{[]string{`</v2/docs>; rel="help"`, `</v2/_catalog?last=a>; rel="next"`}, "/v2/_catalog?last=a"},
+		{[]string{`</v2/_catalog?next>; rel="nextpage"`}, ""},
+	} {
+		res := &http.Response{Header: http.Header{}}
+		for _, h := range c.headers {
+			res.Header.Add("Link", h)
+		}
+		path, err := nextPagePath(res)
+		require.NoError(t, err, c.headers)
+		assert.Equal(t, c.expected, path, c.headers)
+	}
+
+	for _, header := range []string{
+		`/v2/_catalog; rel="next"`,
+		`</v2/_catalog; rel="next"`,
+		`</v2/_catalog>; rel="next`,
+		`</v2/_catalog>; rel="prev" </v2/_catalog?last=a>; rel="next"`,
+	} {
+		res := &http.Response{Header: http.Header{"Link": {header}}}
+		_, err := nextPagePath(res)
+		assert.Error(t, err, header)
+	}
+}
+
 func TestIsManifestUnknownError(t *testing.T) {
 	// Mostly a smoke test; we can add more registries here if they need special handling.
 
@@ -380,9 +435,774 @@ func TestIsManifestUnknownError(t *testing.T) {
 	} {
 		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(c.response))), nil)
 		require.NoError(t, err, c.name)
-		err = fmt.Errorf("wrapped: %w", registryHTTPResponseToError(resp))
+		err = fmt.Errorf("wrapped: %w", registryHTTPResponseToError(logrus.StandardLogger(), resp))
 
 		res := isManifestUnknownError(err)
 		assert.True(t, res, "%#v", err, c.name)
 	}
 }
+
+func TestGetRepositoriesPerPageTokens(t *testing.T) {
+	pages := map[string]struct {
+		repositories []string
+		next         string
+	}{
+		"":  {[]string{"a", "b"}, "/v2/_catalog?last=b&n=2"},
+		"b": {[]string{"c", "d"}, "/v2/_catalog?last=d&n=2"},
+		"d": {[]string{"e"}, ""},
+	}
+	var (
+		mu           sync.Mutex
+		issuedTokens = 0
+		tokenPages   = map[string]string{} // Tokens that have been used, and the "last" values of the pages they were used for.
+		serverURL    string
+	)
+	challenge := func(rw http.ResponseWriter, extra string) {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"%s`, serverURL, extra))
+		rw.WriteHeader(http.StatusUnauthorized)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			challenge(rw, "")
+		case r.Method == http.MethodGet && r.URL.Path == "/token":
+			assert.Contains(t, r.URL.Query()["scope"], "registry:catalog:*")
+			issuedTokens++
+			fmt.Fprintf(rw, `{"token":"token-%d","expires_in":3600}`, issuedTokens)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/_catalog":
+			last := r.URL.Query().Get("last")
+			page, ok := pages[last]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			// Every page requires a token which has not been used for any other page.
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				challenge(rw, `,scope="registry:catalog:*"`)
+				return
+			}
+			if usedFor, ok := tokenPages[token]; ok && usedFor != last {
+				challenge(rw, `,scope="registry:catalog:*",error="insufficient_scope"`)
+				return
+			}
+			tokenPages[token] = last
+			if page.next != "" {
+				rw.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, page.next))
+			}
+			fmt.Fprintf(rw, `{"repositories":["%s"]}`, strings.Join(page.repositories, `","`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverURL = server.URL
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	sys := &types.SystemContext{
+		AuthFilePath:                filepath.Join(t.TempDir(), "auth.json"),
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+	repositories, err := GetRepositories(context.Background(), sys, registry)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, repositories)
+	assert.Equal(t, 3, issuedTokens)
+	assert.Len(t, tokenPages, 3)
+}
+
+func TestDockerHostOverrides(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(serverURL.Host)
+	require.NoError(t, err)
+
+	// The httptest certificate is valid for example.com, but not for the other host names.
+	certDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(certDir, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		registry  string
+		overrides map[string]string
+		success   bool
+	}{
+		{"example.com:" + port, map[string]string{"example.com": "127.0.0.1"}, true},
+		{"example.com:" + port, map[string]string{"example.com:" + port: "127.0.0.1", "example.com": "192.0.2.1"}, true},
+		{"registry.invalid:" + port, map[string]string{"registry.invalid": "127.0.0.1"}, false}, // Certificate verification fails
+	} {
+		client, err := newDockerClient(&types.SystemContext{
+			DockerCertPath:      certDir,
+			DockerHostOverrides: c.overrides,
+		}, c.registry, c.registry)
+		require.NoError(t, err, c.registry)
+		err = client.detectProperties(context.Background())
+		if c.success {
+			require.NoError(t, err, c.registry)
+			assert.Equal(t, "https", client.scheme, c.registry)
+		} else {
+			assert.Error(t, err, c.registry)
+		}
+	}
+}
+
+func TestDockerHostOverridesUnixSocket(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/tag":
+			rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(manifestBlob)
+			assert.NoError(t, err)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	// newUnixServer returns a server listening on a Unix domain socket, and the socket’s path.
+	newUnixServer := func(startTLS bool) (*httptest.Server, string) {
+		socketPath := filepath.Join(t.TempDir(), "registry.sock")
+		listener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		server := httptest.NewUnstartedServer(handler)
+		server.Listener.Close()
+		server.Listener = listener
+		if startTLS {
+			server.StartTLS()
+		} else {
+			server.Start()
+		}
+		return server, socketPath
+	}
+
+	plainServer, plainSocket := newUnixServer(false)
+	defer plainServer.Close()
+	tlsServer, tlsSocket := newUnixServer(true)
+	defer tlsServer.Close()
+	// The httptest certificate is valid for example.com.
+	certDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(certDir, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0o644)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name, host string
+		sys        types.SystemContext
+	}{
+		{"HTTP", "registry.invalid", types.SystemContext{
+			DockerHostOverrides:         map[string]string{"registry.invalid": "unix://" + plainSocket},
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		}},
+		{"TLS", "example.com", types.SystemContext{
+			DockerHostOverrides: map[string]string{"example.com": "unix://" + tlsSocket},
+			DockerCertPath:      certDir,
+		}},
+	} {
+		sys := c.sys
+		sys.RegistriesDirPath = "/this/does/not/exist"
+		sys.DockerPerHostCertDirPath = "/this/does/not/exist"
+		ref, err := ParseReference("//" + c.host + "/repo:tag")
+		require.NoError(t, err, c.name)
+		src, err := ref.NewImageSource(context.Background(), &sys)
+		require.NoError(t, err, c.name)
+		m, mimeType, err := src.GetManifest(context.Background(), nil)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, manifestBlob, m, c.name)
+		assert.Equal(t, manifest.DockerV2Schema2MediaType, mimeType, c.name)
+		src.Close()
+	}
+}
+
+func TestDockerProxy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	certDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(certDir, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644)
+	require.NoError(t, err)
+
+	// A forward proxy which only accepts CONNECT requests with the expected basic authentication credentials.
+	var mutex sync.Mutex
+	tunnels := 0
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNzd29yZA==" { // base64("user:password")
+			rw.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			rw.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		mutex.Lock()
+		tunnels++
+		mutex.Unlock()
+		if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); !assert.NoError(t, err) {
+			return
+		}
+		done := make(chan struct{})
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			close(done)
+		}()
+		_, _ = io.Copy(conn, upstream)
+		<-done
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+	withUser := func(user *url.Userinfo) *url.URL {
+		u := *proxyURL
+		u.User = user
+		return &u
+	}
+
+	for i, c := range []struct {
+		proxyURL *url.URL
+		auth     *types.DockerAuthConfig
+		success  bool
+	}{
+		{withUser(url.UserPassword("user", "password")), nil, true},
+		{proxyURL, &types.DockerAuthConfig{Username: "user", Password: "password"}, true},
+		{withUser(url.UserPassword("user", "wrong")), &types.DockerAuthConfig{Username: "user", Password: "password"}, true},
+		{proxyURL, nil, false},
+		{withUser(url.UserPassword("user", "wrong")), nil, false},
+		{proxyURL, &types.DockerAuthConfig{Username: "user", Password: "wrong"}, false},
+	} {
+		mutex.Lock()
+		tunnels = 0
+		mutex.Unlock()
+		client, err := newDockerClient(&types.SystemContext{
+			DockerCertPath:        certDir,
+			DockerProxyURL:        c.proxyURL,
+			DockerProxyAuthConfig: c.auth,
+		}, serverURL.Host, serverURL.Host)
+		require.NoError(t, err, i)
+		err = client.detectProperties(context.Background())
+		client.client.CloseIdleConnections()
+		if c.success {
+			require.NoError(t, err, i)
+			assert.Equal(t, "https", client.scheme, i)
+			mutex.Lock()
+			assert.Equal(t, 1, tunnels, i)
+			mutex.Unlock()
+		} else {
+			assert.Error(t, err, i)
+		}
+	}
+}
+
+func TestDockerConnectionLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		maxIdle, maxConns                 int
+		keepAlives                        bool
+		expectedMaxIdle, expectedMaxConns int
+	}{
+		{0, 0, false, 0, 0}, // The defaults
+		{4, 0, true, 4, 0},
+		{0, 8, false, 0, 8},
+		{4, 8, true, 4, 8},
+		{-1, -1, false, 0, 0},
+	} {
+		client, err := newDockerClient(&types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			DockerPerHostCertDirPath:    "/this/does/not/exist",
+			DockerMaxIdleConnsPerHost:   c.maxIdle,
+			DockerMaxConnsPerHost:       c.maxConns,
+		}, serverURL.Host, serverURL.Host)
+		require.NoError(t, err)
+		err = client.detectProperties(context.Background())
+		require.NoError(t, err)
+		tr, ok := client.client.Transport.(*http.Transport)
+		require.True(t, ok)
+		assert.Equal(t, !c.keepAlives, tr.DisableKeepAlives, c)
+		assert.Equal(t, c.expectedMaxIdle, tr.MaxIdleConnsPerHost, c)
+		assert.Equal(t, c.expectedMaxConns, tr.MaxConnsPerHost, c)
+	}
+}
+
+func TestDialContextWithNetwork(t *testing.T) {
+	for _, c := range []struct {
+		network, requested, expected string
+	}{
+		{"tcp4", "tcp", "tcp4"},
+		{"tcp6", "tcp", "tcp6"},
+		{"tcp4", "unix", "unix"},
+	} {
+		var dialed string
+		dial := dialContextWithNetwork(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = network
+			return nil, errors.New("not connecting")
+		}, c.network)
+		_, err := dial(context.Background(), c.requested, "registry.example.com:443")
+		assert.Error(t, err)
+		assert.Equal(t, c.expected, dialed, c.network)
+	}
+}
+
+func TestDockerNetwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		network string
+		success bool
+	}{
+		{"", true},
+		{"tcp4", true},
+		{"tcp6", false}, // The server only listens on an IPv4 address
+	} {
+		client, err := newDockerClient(&types.SystemContext{
+			RegistriesDirPath:           "/this/does/not/exist",
+			DockerPerHostCertDirPath:    "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			DockerNetwork:               c.network,
+		}, serverURL.Host, serverURL.Host)
+		require.NoError(t, err, c.network)
+		err = client.detectProperties(context.Background())
+		if c.success {
+			assert.NoError(t, err, c.network)
+		} else {
+			assert.Error(t, err, c.network)
+		}
+	}
+
+	_, err = newDockerClient(&types.SystemContext{DockerNetwork: "udp"}, serverURL.Host, serverURL.Host)
+	assert.Error(t, err)
+}
+
+func TestDockerMinimumTLSVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	// Current Go versions can not run a TLS 1.0 or TLS 1.1 server, so test the enforcement with TLS 1.2 and 1.3.
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	certDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(certDir, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		minVersion uint16
+		success    bool
+	}{
+		{0, true},
+		{tls.VersionTLS12, true},
+		{tls.VersionTLS13, false},
+	} {
+		client, err := newDockerClient(&types.SystemContext{
+			DockerCertPath:          certDir,
+			DockerMinimumTLSVersion: c.minVersion,
+		}, serverURL.Host, serverURL.Host)
+		require.NoError(t, err)
+		err = client.detectProperties(context.Background())
+		if c.success {
+			assert.NoError(t, err, c.minVersion)
+		} else {
+			assert.Error(t, err, c.minVersion)
+		}
+	}
+
+	_, err = newDockerClient(&types.SystemContext{DockerMinimumTLSVersion: 0x1234}, serverURL.Host, serverURL.Host)
+	assert.Error(t, err)
+}
+
+// newTestClientCertificate creates a new CA and a client certificate issued by it, writes the client certificate and
+// its key to dir as client.cert and client.key, and returns a pool containing the CA certificate.
+func newTestClientCertificate(t *testing.T, dir string) *x509.CertPool {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, clientKey.Public(), caKey)
+	require.NoError(t, err)
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "client.cert"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}), 0o644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "client.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: clientKeyDER}), 0o600)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return pool
+}
+
+// newMutualTLSTestServer returns a running registry server which requires client certificates issued by a CA in clientCAs,
+// and writes its CA certificate, as ca.crt, to a host:port subdirectory of perHostCertDir.
+func newMutualTLSTestServer(t *testing.T, clientCAs *x509.CertPool, perHostCertDir string) string {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	hostCertDir := filepath.Join(perHostCertDir, serverURL.Host)
+	err = os.Mkdir(hostCertDir, 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(hostCertDir, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644)
+	require.NoError(t, err)
+	return serverURL.Host
+}
+
+func TestDockerClientCertificates(t *testing.T) {
+	perHostCertDir := t.TempDir()
+	certDir1, certDir2 := t.TempDir(), t.TempDir()
+	host1 := newMutualTLSTestServer(t, newTestClientCertificate(t, certDir1), perHostCertDir)
+	host2 := newMutualTLSTestServer(t, newTestClientCertificate(t, certDir2), perHostCertDir)
+	// A client certificate directory must also contain the CA of the server, because it is used instead of the per-host directory.
+	serverCA, err := os.ReadFile(filepath.Join(perHostCertDir, host2, "ca.crt"))
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(certDir2, "ca.crt"), serverCA, 0o644)
+	require.NoError(t, err)
+
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err = os.WriteFile(registriesConf, []byte(fmt.Sprintf(`
+[[registry]]
+location = "%s"
+client-cert = "%s"
+client-key = "%s"
+
+[[registry]]
+location = "%s"
+client-cert-dir = "%s"
+`, host1, filepath.Join(certDir1, "client.cert"), filepath.Join(certDir1, "client.key"), host2, certDir2)), 0o644)
+	require.NoError(t, err)
+	emptyRegistriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err = os.WriteFile(emptyRegistriesConf, []byte{}, 0o644)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name           string
+		registriesConf string
+		perHostCertDir string
+		certs          map[string]types.DockerClientCertificate
+		success1       bool
+		success2       bool
+	}{
+		{"no client certificates", emptyRegistriesConf, perHostCertDir, nil, false, false},
+		// host1 has no CA certificate without perHostCertDir.
+		{"registries.conf", registriesConf, "", nil, false, true},
+		// An explicit DockerPerHostCertDirPath takes precedence over client-cert-dir, so host2 has no client certificate.
+		{"registries.conf with DockerPerHostCertDirPath", registriesConf, perHostCertDir, nil, true, false},
+		{"SystemContext", emptyRegistriesConf, perHostCertDir, map[string]types.DockerClientCertificate{
+			host1: {CertFile: filepath.Join(certDir1, "client.cert"), KeyFile: filepath.Join(certDir1, "client.key")},
+			host2: {CertDir: certDir2},
+		}, true, true},
+		{"SystemContext overrides registries.conf", registriesConf, perHostCertDir, map[string]types.DockerClientCertificate{
+			host1: {CertFile: filepath.Join(certDir2, "client.cert"), KeyFile: filepath.Join(certDir2, "client.key")},
+			host2: {CertDir: certDir2},
+		}, false, true},
+	} {
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath:    c.registriesConf,
+			SystemRegistriesConfDirPath: "/this/does/not/exist",
+			DockerPerHostCertDirPath:    c.perHostCertDir,
+			DockerClientCertificates:    c.certs,
+		}
+		for _, h := range []struct {
+			host    string
+			success bool
+		}{{host1, c.success1}, {host2, c.success2}} {
+			client, err := newDockerClient(sys, h.host, h.host)
+			require.NoError(t, err, c.name)
+			err = client.detectProperties(context.Background())
+			if h.success {
+				assert.NoError(t, err, "%s %s", c.name, h.host)
+			} else {
+				assert.Error(t, err, "%s %s", c.name, h.host)
+			}
+		}
+	}
+
+	// A certificate without a key is rejected
+	_, err = newDockerClient(&types.SystemContext{
+		SystemRegistriesConfPath:    emptyRegistriesConf,
+		SystemRegistriesConfDirPath: "/this/does/not/exist",
+		DockerClientCertificates: map[string]types.DockerClientCertificate{
+			host1: {CertFile: filepath.Join(certDir1, "client.cert")},
+		},
+	}, host1, host1)
+	assert.Error(t, err)
+}
+
+func TestDockerRegistryHeaders(t *testing.T) {
+	const tenantHeader = "X-Tenant-Id"
+
+	var mu sync.Mutex
+	authServerTenants := []string{}
+	authServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		authServerTenants = append(authServerTenants, r.Header.Get(tenantHeader))
+		fmt.Fprint(rw, `{"token":"sentinel-token","expires_in":3600}`)
+	}))
+	defer authServer.Close()
+	otherHostTenants := []string{}
+	otherHost := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		otherHostTenants = append(otherHostTenants, r.Header.Get(tenantHeader))
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer otherHost.Close()
+	registryTenants := map[string][]string{}
+	registry := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		registryTenants[r.URL.Path] = append(registryTenants[r.URL.Path], r.Header.Get(tenantHeader))
+		assert.Equal(t, defaultUserAgent, r.Header.Get("User-Agent"))
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, authServer.URL))
+			rw.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/latest":
+			assert.Equal(t, "Bearer sentinel-token", r.Header.Get("Authorization"))
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/blobs/redirected":
+			http.Redirect(rw, r, otherHost.URL+"/blob", http.StatusTemporaryRedirect)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer registry.Close()
+	registryHost := strings.TrimPrefix(registry.URL, "http://")
+
+	for _, c := range []struct {
+		name             string
+		headers          map[string]http.Header
+		forAuthServer    bool
+		expectedRegistry string
+		expectedAuth     string
+	}{
+		{"no headers", nil, false, "", ""},
+		{"other registry", map[string]http.Header{"registry.example.com": {tenantHeader: {"t1"}}}, false, "", ""},
+		{"registry only", map[string]http.Header{registryHost: {tenantHeader: {"t1"}, "User-Agent": {"ignored"}}}, false, "t1", ""},
+		{"with auth server", map[string]http.Header{registryHost: {tenantHeader: {"t1"}}}, true, "t1", "t1"},
+	} {
+		mu.Lock()
+		authServerTenants = []string{}
+		otherHostTenants = []string{}
+		registryTenants = map[string][]string{}
+		mu.Unlock()
+
+		client, err := newDockerClient(&types.SystemContext{
+			RegistriesDirPath:                  "/this/does/not/exist",
+			DockerPerHostCertDirPath:           "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify:        types.OptionalBoolTrue,
+			DockerRegistryHeaders:              c.headers,
+			DockerRegistryHeadersForAuthServer: c.forAuthServer,
+		}, registryHost, registryHost)
+		require.NoError(t, err, c.name)
+		require.NoError(t, client.detectProperties(context.Background()), c.name)
+		for _, path := range []string{"/v2/repo/manifests/latest", "/v2/repo/blobs/redirected"} {
+			res, err := client.makeRequest(context.Background(), http.MethodGet, path, nil, nil, v2Auth, nil)
+			require.NoError(t, err, c.name)
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode, c.name)
+		}
+
+		mu.Lock()
+		assert.Equal(t, map[string][]string{
+			"/v2/":                      {c.expectedRegistry},
+			"/v2/repo/manifests/latest": {c.expectedRegistry},
+			"/v2/repo/blobs/redirected": {c.expectedRegistry},
+		}, registryTenants, c.name)
+		assert.Equal(t, []string{c.expectedAuth}, authServerTenants, c.name)
+		assert.Equal(t, []string{""}, otherHostTenants, c.name)
+		mu.Unlock()
+	}
+}
+
+func TestDockerClientLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	logger, hook := logrustest.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	client, err := newDockerClient(&types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		Logger:                      logger,
+	}, serverURL.Host, serverURL.Host)
+	require.NoError(t, err)
+	require.NoError(t, client.detectProperties(context.Background()))
+	res, err := client.makeRequest(context.Background(), http.MethodGet, "/v2/repo/manifests/latest", nil, nil, v2Auth, nil)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	messages := []string{}
+	for _, entry := range hook.AllEntries() {
+		assert.Equal(t, logrus.DebugLevel, entry.Level)
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(t, messages, "Ping "+server.URL+"/v2/ status 200")
+	assert.Contains(t, messages, "GET "+server.URL+"/v2/repo/manifests/latest")
+}
+
+func TestDockerClientGetReferrersPagination(t *testing.T) {
+	manifestDigest := digest.FromString("subject")
+	referrersPath := "/v2/repo/referrers/" + manifestDigest.String()
+	referrer := func(name, artifactType string) imgspecv1.Descriptor {
+		return imgspecv1.Descriptor{
+			MediaType:    imgspecv1.MediaTypeImageManifest,
+			ArtifactType: artifactType,
+			Digest:       digest.FromString(name),
+			Size:         int64(len(name)),
+		}
+	}
+	pages := map[string][]imgspecv1.Descriptor{
+		"":  {referrer("sig 1", "application/vnd.example.sig"), referrer("sbom", "application/vnd.example.sbom")},
+		"2": {referrer("sig 2", "application/vnd.example.sig")},
+	}
+	loop := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == referrersPath:
+			page := r.URL.Query().Get("page")
+			descriptors, ok := pages[page]
+			if !assert.True(t, ok, page) {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if page == "" {
+				rw.Header().Set("Link", fmt.Sprintf(`<%s?page=2>; rel="next"`, referrersPath))
+			} else if loop {
+				rw.Header().Set("Link", fmt.Sprintf(`<%s?page=2>; rel="next"`, referrersPath))
+			}
+			index, err := json.Marshal(imgspecv1.Index{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: imgspecv1.MediaTypeImageIndex,
+				Manifests: descriptors,
+			})
+			assert.NoError(t, err)
+			rw.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+			rw.WriteHeader(http.StatusOK)
+			_, err = rw.Write(index)
+			assert.NoError(t, err)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	ref, err := ParseReference("//" + serverURL.Host + "/repo:tag")
+	require.NoError(t, err)
+	dockerRef, ok := ref.(dockerReference)
+	require.True(t, ok)
+	client, err := newDockerClient(&types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}, serverURL.Host, serverURL.Host)
+	require.NoError(t, err)
+
+	referrers, err := client.getReferrers(context.Background(), dockerRef, manifestDigest, "")
+	require.NoError(t, err)
+	assert.Equal(t, []imgspecv1.Descriptor{pages[""][0], pages[""][1], pages["2"][0]}, referrers)
+	// Filtering applies to all pages
+	referrers, err = client.getReferrers(context.Background(), dockerRef, manifestDigest, "application/vnd.example.sig")
+	require.NoError(t, err)
+	assert.Equal(t, []imgspecv1.Descriptor{pages[""][0], pages["2"][0]}, referrers)
+
+	// A loop of pages is detected
+	loop = true
+	_, err = client.getReferrers(context.Background(), dockerRef, manifestDigest, "")
+	assert.ErrorContains(t, err, "loop")
+}
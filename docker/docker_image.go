@@ -6,14 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
-	"strings"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/image"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // Image is a Docker-specific implementation of types.ImageCloser with a few extra methods
@@ -64,7 +63,7 @@ func GetRepositoryTags(ctx context.Context, sys *types.SystemContext, ref types.
 		return nil, err
 	}
 	path := fmt.Sprintf(tagsPath, reference.Path(dr.ref))
-	client, err := newDockerClientFromRef(sys, dr, registryConfig, false, "pull")
+	client, err := newDockerClientFromRef(ctx, sys, dr, registryConfig, false, "pull")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
@@ -78,34 +77,27 @@ func GetRepositoryTags(ctx context.Context, sys *types.SystemContext, ref types.
 		}
 		defer res.Body.Close()
 		if res.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("fetching tags list: %w", registryHTTPResponseToError(res))
+			return nil, fmt.Errorf("fetching tags list: %w", registryHTTPResponseToError(client.logger, res))
 		}
 
 		var tagsHolder struct {
 			Tags []string
 		}
-		if err = json.NewDecoder(res.Body).Decode(&tagsHolder); err != nil {
+		body, err := decodedResponseBody(res)
+		if err != nil {
+			return nil, fmt.Errorf("fetching tags list: %w", err)
+		}
+		if err = json.NewDecoder(body).Decode(&tagsHolder); err != nil {
 			return nil, err
 		}
 		tags = append(tags, tagsHolder.Tags...)
 
-		link := res.Header.Get("Link")
-		if link == "" {
-			break
-		}
-
-		linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
-		linkURL, err := url.Parse(linkURLStr)
+		path, err = nextPagePath(res)
 		if err != nil {
 			return tags, err
 		}
-
-		// can be relative or absolute, but we only want the path (and I
-		// guess we're in trouble if it forwards to a new place...)
-		path = linkURL.Path
-		if linkURL.RawQuery != "" {
-			path += "?"
-			path += linkURL.RawQuery
+		if path == "" {
+			break
 		}
 	}
 	return tags, nil
@@ -132,7 +124,7 @@ func GetDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageRef
 	if err != nil {
 		return "", err
 	}
-	client, err := newDockerClientFromRef(sys, dr, registryConfig, false, "pull")
+	client, err := newDockerClientFromRef(ctx, sys, dr, registryConfig, false, "pull")
 	if err != nil {
 		return "", fmt.Errorf("failed to create client: %w", err)
 	}
@@ -149,7 +141,7 @@ func GetDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageRef
 
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("reading digest %s in %s: %w", tagOrDigest, dr.ref.Name(), registryHTTPResponseToError(res))
+		return "", fmt.Errorf("reading digest %s in %s: %w", tagOrDigest, dr.ref.Name(), registryHTTPResponseToError(client.logger, res))
 	}
 
 	dig, err := digest.Parse(res.Header.Get("Docker-Content-Digest"))
@@ -159,3 +151,113 @@ func GetDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageRef
 
 	return dig, nil
 }
+
+// GetImageConfig returns the config of the image at ref, parsed as an OCI config.
+// If ref refers to a manifest list, the instance appropriate for sys is used.
+// For Docker schema1 images, which don't have a config blob, the config is synthesized from the manifest.
+func GetImageConfig(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (_ *imgspecv1.Image, retErr error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return nil, errors.New("ref must be a dockerReference")
+	}
+	src, err := newImageSource(ctx, sys, dr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := src.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	img, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return nil, err
+	}
+	return img.OCIConfig(ctx)
+}
+
+// GetManifestListPlatforms returns true if ref refers to a manifest list (a Docker schema2 list or an OCI index), and the
+// platforms of the images in the list, in list order; or false and nil if ref refers to a single image.
+// Only the top-level manifest is read: the media type is determined using a HEAD request, and the manifest is only
+// downloaded if it is a manifest list (or if the registry doesn't report the media type); no configs or layers are read.
+// Images in an OCI index without a recorded platform are reported with an empty platform.
+func GetManifestListPlatforms(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (bool, []imgspecv1.Platform, error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return false, nil, errors.New("ref must be a dockerReference")
+	}
+
+	tagOrDigest, err := dr.tagOrDigest()
+	if err != nil {
+		return false, nil, err
+	}
+
+	registryConfig, err := loadRegistryConfiguration(sys)
+	if err != nil {
+		return false, nil, err
+	}
+	client, err := newDockerClientFromRef(ctx, sys, dr, registryConfig, false, "pull")
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	path := fmt.Sprintf(manifestPath, reference.Path(dr.ref), tagOrDigest)
+	headers := map[string][]string{
+		"Accept": manifest.DefaultRequestedManifestMIMETypes,
+	}
+	res, err := client.makeRequest(ctx, http.MethodHead, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, nil, fmt.Errorf("reading manifest %s in %s: %w", tagOrDigest, dr.ref.Name(), registryHTTPResponseToError(client.logger, res))
+	}
+	switch mimeType := simplifyContentType(res.Header.Get("Content-Type")); mimeType {
+	case manifest.DockerV2Schema1MediaType, manifest.DockerV2Schema1SignedMediaType, manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest:
+		return false, nil, nil
+	case manifest.DockerV2ListMediaType, imgspecv1.MediaTypeImageIndex:
+	default:
+		// Some registries don't report a meaningful media type; download the manifest to determine it.
+		client.logger.Debugf("Manifest %s in %s has an unrecognized type %q, reading it", tagOrDigest, dr.ref.Name(), mimeType)
+	}
+
+	manblob, mimeType, _, err := client.fetchManifest(ctx, dr, tagOrDigest)
+	if err != nil {
+		return false, nil, err
+	}
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		mimeType = manifest.GuessMIMEType(manblob) // The registry may not have reported a meaningful type.
+		if !manifest.MIMETypeIsMultiImage(mimeType) {
+			return false, nil, nil
+		}
+	}
+	list, err := manifest.ListFromBlob(manblob, mimeType)
+	if err != nil {
+		return false, nil, err
+	}
+	platforms := []imgspecv1.Platform{}
+	switch l := list.(type) {
+	case *manifest.Schema2List:
+		for _, m := range l.Manifests {
+			platforms = append(platforms, imgspecv1.Platform{
+				Architecture: m.Platform.Architecture,
+				OS:           m.Platform.OS,
+				OSVersion:    m.Platform.OSVersion,
+				OSFeatures:   m.Platform.OSFeatures,
+				Variant:      m.Platform.Variant,
+			})
+		}
+	case *manifest.OCI1Index:
+		for _, m := range l.Manifests {
+			if m.Platform == nil {
+				platforms = append(platforms, imgspecv1.Platform{})
+			} else {
+				platforms = append(platforms, *m.Platform)
+			}
+		}
+	default:
+		return false, nil, fmt.Errorf("Internal error: unexpected manifest list type %T", list)
+	}
+	return true, platforms, nil
+}
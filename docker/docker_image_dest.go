@@ -19,6 +19,7 @@ import (
 	"github.com/containers/image/v5/internal/imagedestination/impl"
 	"github.com/containers/image/v5/internal/imagedestination/stubs"
 	"github.com/containers/image/v5/internal/iolimits"
+	internalManifest "github.com/containers/image/v5/internal/manifest"
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/internal/putblobdigest"
 	"github.com/containers/image/v5/internal/signature"
@@ -31,7 +32,6 @@ import (
 	v2 "github.com/docker/distribution/registry/api/v2"
 	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
-	"github.com/sirupsen/logrus"
 )
 
 type dockerImageDestination struct {
@@ -46,12 +46,12 @@ type dockerImageDestination struct {
 }
 
 // newImageDestination creates a new ImageDestination for the specified image reference.
-func newImageDestination(sys *types.SystemContext, ref dockerReference) (private.ImageDestination, error) {
+func newImageDestination(ctx context.Context, sys *types.SystemContext, ref dockerReference) (private.ImageDestination, error) {
 	registryConfig, err := loadRegistryConfiguration(sys)
 	if err != nil {
 		return nil, err
 	}
-	c, err := newDockerClientFromRef(sys, ref, registryConfig, true, "pull,push")
+	c, err := newDockerClientFromRef(ctx, sys, ref, registryConfig, true, "pull,push")
 	if err != nil {
 		return nil, err
 	}
@@ -61,9 +61,12 @@ func newImageDestination(sys *types.SystemContext, ref dockerReference) (private
 		imgspecv1.MediaTypeImageIndex,
 		manifest.DockerV2ListMediaType,
 	}
-	if c.sys == nil || !c.sys.DockerDisableDestSchema1MIMETypes {
+	if (c.sys == nil || !c.sys.DockerDisableDestSchema1MIMETypes) && !c.rejectsSchema1() {
 		mimeTypes = append(mimeTypes, manifest.DockerV2Schema1SignedMediaType, manifest.DockerV2Schema1MediaType)
 	}
+	if sys != nil && sys.DockerProbeManifestMIMETypes {
+		mimeTypes = c.filterAcceptedManifestMIMETypes(ctx, ref, mimeTypes)
+	}
 
 	dest := &dockerImageDestination{
 		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
@@ -135,7 +138,7 @@ func (d *dockerImageDestination) PutBlobWithOptions(ctx context.Context, stream
 	// This functionality is particularly useful when BlobInfoCache has not been populated with compressed digests,
 	// the source blob is uncompressed, and the destination blob is being compressed "on the fly".
 	if inputInfo.Digest == "" && d.c.sys.DockerRegistryPushPrecomputeDigests {
-		logrus.Debugf("Precomputing digest layer for %s", reference.Path(d.ref.ref))
+		d.c.logger.Debugf("Precomputing digest layer for %s", reference.Path(d.ref.ref))
 		streamCopy, cleanup, err := streamdigest.ComputeBlobInfo(d.c.sys, stream, &inputInfo)
 		if err != nil {
 			return types.BlobInfo{}, err
@@ -158,15 +161,15 @@ func (d *dockerImageDestination) PutBlobWithOptions(ctx context.Context, stream
 
 	// FIXME? Chunked upload, progress reporting, etc.
 	uploadPath := fmt.Sprintf(blobUploadPath, reference.Path(d.ref.ref))
-	logrus.Debugf("Uploading %s", uploadPath)
+	d.c.logger.Debugf("Uploading %s", uploadPath)
 	res, err := d.c.makeRequest(ctx, http.MethodPost, uploadPath, nil, nil, v2Auth, nil)
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusAccepted {
-		logrus.Debugf("Error initiating layer upload, response %#v", *res)
-		return types.BlobInfo{}, fmt.Errorf("initiating layer upload to %s in %s: %w", uploadPath, d.c.registry, registryHTTPResponseToError(res))
+		d.c.logger.Debugf("Error initiating layer upload, response %#v", *res)
+		return types.BlobInfo{}, fmt.Errorf("initiating layer upload to %s in %s: %w", uploadPath, d.c.registry, registryHTTPResponseToError(d.c.logger, res))
 	}
 	uploadLocation, err := res.Location()
 	if err != nil {
@@ -184,12 +187,12 @@ func (d *dockerImageDestination) PutBlobWithOptions(ctx context.Context, stream
 		defer uploadReader.Terminate(errors.New("Reading data from an already terminated upload"))
 		res, err = d.c.makeRequestToResolvedURL(ctx, http.MethodPatch, uploadLocation, map[string][]string{"Content-Type": {"application/octet-stream"}}, uploadReader, inputInfo.Size, v2Auth, nil)
 		if err != nil {
-			logrus.Debugf("Error uploading layer chunked %v", err)
+			d.c.logger.Debugf("Error uploading layer chunked %v", err)
 			return nil, err
 		}
 		defer res.Body.Close()
 		if !successStatus(res.StatusCode) {
-			return nil, fmt.Errorf("uploading layer chunked: %w", registryHTTPResponseToError(res))
+			return nil, fmt.Errorf("uploading layer chunked: %w", registryHTTPResponseToError(d.c.logger, res))
 		}
 		uploadLocation, err := res.Location()
 		if err != nil {
@@ -213,39 +216,13 @@ func (d *dockerImageDestination) PutBlobWithOptions(ctx context.Context, stream
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusCreated {
-		logrus.Debugf("Error uploading layer, response %#v", *res)
-		return types.BlobInfo{}, fmt.Errorf("uploading layer to %s: %w", uploadLocation, registryHTTPResponseToError(res))
+		d.c.logger.Debugf("Error uploading layer, response %#v", *res)
+		return types.BlobInfo{}, fmt.Errorf("uploading layer to %s: %w", uploadLocation, registryHTTPResponseToError(d.c.logger, res))
 	}
 
-	logrus.Debugf("Upload of layer %s complete", blobDigest)
+	d.c.logger.Debugf("Upload of layer %s complete", blobDigest)
 	options.Cache.RecordKnownLocation(d.ref.Transport(), bicTransportScope(d.ref), blobDigest, newBICLocationReference(d.ref))
-	return types.BlobInfo{Digest: blobDigest, Size: sizeCounter.size}, nil
-}
-
-// blobExists returns true iff repo contains a blob with digest, and if so, also its size.
-// If the destination does not contain the blob, or it is unknown, blobExists ordinarily returns (false, -1, nil);
-// it returns a non-nil error only on an unexpected failure.
-func (d *dockerImageDestination) blobExists(ctx context.Context, repo reference.Named, digest digest.Digest, extraScope *authScope) (bool, int64, error) {
-	checkPath := fmt.Sprintf(blobsPath, reference.Path(repo), digest.String())
-	logrus.Debugf("Checking %s", checkPath)
-	res, err := d.c.makeRequest(ctx, http.MethodHead, checkPath, nil, nil, v2Auth, extraScope)
-	if err != nil {
-		return false, -1, err
-	}
-	defer res.Body.Close()
-	switch res.StatusCode {
-	case http.StatusOK:
-		logrus.Debugf("... already exists")
-		return true, getBlobSize(res), nil
-	case http.StatusUnauthorized:
-		logrus.Debugf("... not authorized")
-		return false, -1, fmt.Errorf("checking whether a blob %s exists in %s: %w", digest, repo.Name(), registryHTTPResponseToError(res))
-	case http.StatusNotFound:
-		logrus.Debugf("... not present")
-		return false, -1, nil
-	default:
-		return false, -1, fmt.Errorf("checking whether a blob %s exists in %s: %w", digest, repo.Name(), registryHTTPResponseToError(res))
-	}
+	return types.BlobInfo{Digest: blobDigest, Size: sizeCounter.size, MediaType: inputInfo.MediaType}, nil
 }
 
 // mountBlob tries to mount blob srcDigest from srcRepo to the current destination.
@@ -257,7 +234,7 @@ func (d *dockerImageDestination) mountBlob(ctx context.Context, srcRepo referenc
 			"from":  {reference.Path(srcRepo)},
 		}.Encode(),
 	}
-	logrus.Debugf("Trying to mount %s", u.Redacted())
+	d.c.logger.Debugf("Trying to mount %s", u.Redacted())
 	res, err := d.c.makeRequest(ctx, http.MethodPost, u.String(), nil, nil, v2Auth, extraScope)
 	if err != nil {
 		return err
@@ -265,7 +242,7 @@ func (d *dockerImageDestination) mountBlob(ctx context.Context, srcRepo referenc
 	defer res.Body.Close()
 	switch res.StatusCode {
 	case http.StatusCreated:
-		logrus.Debugf("... mount OK")
+		d.c.logger.Debugf("... mount OK")
 		return nil
 	case http.StatusAccepted:
 		// Oops, the mount was ignored - either the registry does not support that yet, or the blob does not exist; the registry has started an ordinary upload process.
@@ -275,21 +252,21 @@ func (d *dockerImageDestination) mountBlob(ctx context.Context, srcRepo referenc
 		if err != nil {
 			return fmt.Errorf("determining upload URL after a mount attempt: %w", err)
 		}
-		logrus.Debugf("... started an upload instead of mounting, trying to cancel at %s", uploadLocation.Redacted())
+		d.c.logger.Debugf("... started an upload instead of mounting, trying to cancel at %s", uploadLocation.Redacted())
 		res2, err := d.c.makeRequestToResolvedURL(ctx, http.MethodDelete, uploadLocation, nil, nil, -1, v2Auth, extraScope)
 		if err != nil {
-			logrus.Debugf("Error trying to cancel an inadvertent upload: %s", err)
+			d.c.logger.Debugf("Error trying to cancel an inadvertent upload: %s", err)
 		} else {
 			defer res2.Body.Close()
 			if res2.StatusCode != http.StatusNoContent {
-				logrus.Debugf("Error trying to cancel an inadvertent upload, status %s", http.StatusText(res.StatusCode))
+				d.c.logger.Debugf("Error trying to cancel an inadvertent upload, status %s", http.StatusText(res.StatusCode))
 			}
 		}
 		// Anyway, if canceling the upload fails, ignore it and return the more important error:
 		return fmt.Errorf("Mounting %s from %s to %s started an upload instead", srcDigest, srcRepo.Name(), d.ref.ref.Name())
 	default:
-		logrus.Debugf("Error mounting, response %#v", *res)
-		return fmt.Errorf("mounting %s from %s to %s: %w", srcDigest, srcRepo.Name(), d.ref.ref.Name(), registryHTTPResponseToError(res))
+		d.c.logger.Debugf("Error mounting, response %#v", *res)
+		return fmt.Errorf("mounting %s from %s to %s: %w", srcDigest, srcRepo.Name(), d.ref.ref.Name(), registryHTTPResponseToError(d.c.logger, res))
 	}
 }
 
@@ -297,7 +274,7 @@ func (d *dockerImageDestination) mountBlob(ctx context.Context, srcRepo referenc
 // blob in the current repository, with no cross-repo reuse or mounting; cache may be updated, it is not read.
 // The caller must ensure info.Digest is set.
 func (d *dockerImageDestination) tryReusingExactBlob(ctx context.Context, info types.BlobInfo, cache blobinfocache.BlobInfoCache2) (bool, types.BlobInfo, error) {
-	exists, size, err := d.blobExists(ctx, d.ref.ref, info.Digest, nil)
+	exists, size, err := d.c.blobExists(ctx, d.ref.ref, info.Digest, nil)
 	if err != nil {
 		return false, types.BlobInfo{}, err
 	}
@@ -334,22 +311,22 @@ func (d *dockerImageDestination) TryReusingBlobWithOptions(ctx context.Context,
 	for _, candidate := range candidates {
 		candidateRepo, err := parseBICLocationReference(candidate.Location)
 		if err != nil {
-			logrus.Debugf("Error parsing BlobInfoCache location reference: %s", err)
+			d.c.logger.Debugf("Error parsing BlobInfoCache location reference: %s", err)
 			continue
 		}
 		if candidate.CompressorName != blobinfocache.Uncompressed {
-			logrus.Debugf("Trying to reuse cached location %s compressed with %s in %s", candidate.Digest.String(), candidate.CompressorName, candidateRepo.Name())
+			d.c.logger.Debugf("Trying to reuse cached location %s compressed with %s in %s", candidate.Digest.String(), candidate.CompressorName, candidateRepo.Name())
 		} else {
-			logrus.Debugf("Trying to reuse cached location %s with no compression in %s", candidate.Digest.String(), candidateRepo.Name())
+			d.c.logger.Debugf("Trying to reuse cached location %s with no compression in %s", candidate.Digest.String(), candidateRepo.Name())
 		}
 
 		// Sanity checks:
 		if reference.Domain(candidateRepo) != reference.Domain(d.ref.ref) {
-			logrus.Debugf("... Internal error: domain %s does not match destination %s", reference.Domain(candidateRepo), reference.Domain(d.ref.ref))
+			d.c.logger.Debugf("... Internal error: domain %s does not match destination %s", reference.Domain(candidateRepo), reference.Domain(d.ref.ref))
 			continue
 		}
 		if candidateRepo.Name() == d.ref.ref.Name() && candidate.Digest == info.Digest {
-			logrus.Debug("... Already tried the primary destination")
+			d.c.logger.Debug("... Already tried the primary destination")
 			continue
 		}
 
@@ -369,18 +346,18 @@ func (d *dockerImageDestination) TryReusingBlobWithOptions(ctx context.Context,
 		// Even worse, docker/distribution does not actually reasonably implement canceling uploads
 		// (it would require a "delete" action in the token, and Quay does not give that to anyone, so we can't ask);
 		// so, be a nice client and don't create unnecessary upload sessions on the server.
-		exists, size, err := d.blobExists(ctx, candidateRepo, candidate.Digest, extraScope)
+		exists, size, err := d.c.blobExists(ctx, candidateRepo, candidate.Digest, extraScope)
 		if err != nil {
-			logrus.Debugf("... Failed: %v", err)
+			d.c.logger.Debugf("... Failed: %v", err)
 			continue
 		}
 		if !exists {
 			// FIXME? Should we drop the blob from cache here (and elsewhere?)?
-			continue // logrus.Debug() already happened in blobExists
+			continue // d.c.logger.Debug() already happened in blobExists
 		}
 		if candidateRepo.Name() != d.ref.ref.Name() {
 			if err := d.mountBlob(ctx, candidateRepo, candidate.Digest, extraScope); err != nil {
-				logrus.Debugf("... Mount failed: %v", err)
+				d.c.logger.Debugf("... Mount failed: %v", err)
 				continue
 			}
 		}
@@ -389,7 +366,7 @@ func (d *dockerImageDestination) TryReusingBlobWithOptions(ctx context.Context,
 
 		compressionOperation, compressionAlgorithm, err := blobinfocache.OperationAndAlgorithmForCompressor(candidate.CompressorName)
 		if err != nil {
-			logrus.Debugf("... Failed: %v", err)
+			d.c.logger.Debugf("... Failed: %v", err)
 			continue
 		}
 
@@ -407,6 +384,9 @@ func (d *dockerImageDestination) TryReusingBlobWithOptions(ctx context.Context,
 // If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
 // but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
 func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	if d.c.rejectsSchema1() && internalManifest.IsSchema1MIMEType(manifest.GuessMIMEType(m)) {
+		return errors.New("writing a Docker schema1 manifest: Docker schema1 manifests are disabled")
+	}
 	var refTail string
 	if instanceDigest != nil {
 		// If the instanceDigest is provided, then use it as the refTail, because the reference,
@@ -441,11 +421,48 @@ func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, inst
 		}
 	}
 
-	return d.uploadManifest(ctx, m, refTail)
+	responseHeaders, err := d.uploadManifest(ctx, m, refTail)
+	if err != nil {
+		return err
+	}
+	manifestDigest := d.manifestDigest
+	if instanceDigest != nil {
+		manifestDigest = *instanceDigest
+	}
+	return d.maintainReferrersTagSchema(ctx, m, manifestDigest, responseHeaders)
+}
+
+// PutReferrerManifest writes m, an OCI image manifest with a subject, which has manifestDigest, and records it as a referrer of the subject.
+// The blobs m refers to must have already been written using PutBlobWithOptions.
+// Unlike PutManifest, this does not affect the manifest of the image being written, and it can be called after it
+// (but before Commit), whether or not the primary manifest is a manifest list.
+func (d *dockerImageDestination) PutReferrerManifest(ctx context.Context, m []byte, manifestDigest digest.Digest) error {
+	matches, err := manifest.MatchesDigest(m, manifestDigest)
+	if err != nil {
+		return fmt.Errorf("digesting referrer manifest: %w", err)
+	}
+	if !matches {
+		return fmt.Errorf("referrer manifest does not match digest %s", manifestDigest.String())
+	}
+	responseHeaders, err := d.uploadManifest(ctx, m, manifestDigest.String())
+	if err != nil {
+		return err
+	}
+	return d.maintainReferrersTagSchema(ctx, m, manifestDigest, responseHeaders)
+}
+
+// PutManifestWithTag writes manifest m, which was already written using PutManifest with instanceDigest == nil,
+// under tag in the same repository.
+func (d *dockerImageDestination) PutManifestWithTag(ctx context.Context, m []byte, tag string) error {
+	if _, err := reference.WithTag(reference.TrimNamed(d.ref.ref), tag); err != nil {
+		return err
+	}
+	_, err := d.uploadManifest(ctx, m, tag)
+	return err
 }
 
-// uploadManifest writes manifest to tagOrDigest.
-func (d *dockerImageDestination) uploadManifest(ctx context.Context, m []byte, tagOrDigest string) error {
+// uploadManifest writes manifest to tagOrDigest, and returns the headers of the registry’s response.
+func (d *dockerImageDestination) uploadManifest(ctx context.Context, m []byte, tagOrDigest string) (http.Header, error) {
 	path := fmt.Sprintf(manifestPath, reference.Path(d.ref.ref), tagOrDigest)
 
 	headers := map[string][]string{}
@@ -455,16 +472,16 @@ func (d *dockerImageDestination) uploadManifest(ctx context.Context, m []byte, t
 	}
 	res, err := d.c.makeRequest(ctx, http.MethodPut, path, headers, bytes.NewReader(m), v2Auth, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer res.Body.Close()
 	if !successStatus(res.StatusCode) {
-		rawErr := registryHTTPResponseToError(res)
+		rawErr := registryHTTPResponseToError(d.c.logger, res)
 		err := fmt.Errorf("uploading manifest %s to %s: %w", tagOrDigest, d.ref.ref.Name(), rawErr)
 		if isManifestInvalidError(rawErr) {
 			err = types.ManifestTypeRejectedError{Err: err}
 		}
-		return err
+		return nil, err
 	}
 	// A HTTP server may not be a registry at all, and just return 200 OK to everything
 	// (in particular that can fairly easily happen after tearing down a website and
@@ -476,7 +493,70 @@ func (d *dockerImageDestination) uploadManifest(ctx context.Context, m []byte, t
 	// https://github.com/opencontainers/distribution-spec/blob/ec90a2af85fe4d612cf801e1815b95bfa40ae72b/spec.md#legacy-docker-support-http-headers
 	// So, just note the missing header in a debug log.
 	if v := res.Header.Values("Docker-Content-Digest"); len(v) == 0 {
-		logrus.Debugf("Manifest upload response didn’t contain a Docker-Content-Digest header, it might not be a container registry")
+		d.c.logger.Debugf("Manifest upload response didn’t contain a Docker-Content-Digest header, it might not be a container registry")
+	}
+	return res.Header, nil
+}
+
+// referrersManifest contains the fields of an OCI manifest relevant for the referrers API.
+type referrersManifest struct {
+	MediaType    string                `json:"mediaType"`
+	ArtifactType string                `json:"artifactType,omitempty"`
+	Config       imgspecv1.Descriptor  `json:"config"`
+	Subject      *imgspecv1.Descriptor `json:"subject,omitempty"`
+	Annotations  map[string]string     `json:"annotations,omitempty"`
+}
+
+// maintainReferrersTagSchema, if m, with manifestDigest, is an OCI manifest with a subject, and the registry did not indicate
+// support for the referrers API in responseHeaders of the manifest upload, adds m to the index of referrers
+// of its subject, using the “referrers tag schema” fallback of the OCI distribution spec.
+func (d *dockerImageDestination) maintainReferrersTagSchema(ctx context.Context, m []byte, manifestDigest digest.Digest, responseHeaders http.Header) error {
+	mimeType := manifest.GuessMIMEType(m)
+	if mimeType != imgspecv1.MediaTypeImageManifest && mimeType != imgspecv1.MediaTypeImageIndex {
+		return nil
+	}
+	var parsed referrersManifest
+	if err := json.Unmarshal(m, &parsed); err != nil {
+		return fmt.Errorf("parsing manifest: %w", err)
+	}
+	if parsed.Subject == nil {
+		return nil
+	}
+	if responseHeaders.Get("OCI-Subject") != "" {
+		d.c.logger.Debugf("The registry supports the referrers API, not updating the referrers tag of %s", parsed.Subject.Digest.String())
+		return nil
+	}
+
+	artifactType := parsed.ArtifactType
+	if artifactType == "" {
+		artifactType = parsed.Config.MediaType
+	}
+	tag := referrersTag(parsed.Subject.Digest)
+	d.c.logger.Debugf("Adding %s to referrers of %s in %s:%s", manifestDigest.String(), parsed.Subject.Digest.String(), d.ref.ref.Name(), tag)
+
+	index, err := d.c.getReferrersTagIndex(ctx, d.ref, tag)
+	if err != nil {
+		return err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Digest == manifestDigest {
+			d.c.logger.Debugf("Manifest %s is already a referrer of %s", manifestDigest.String(), parsed.Subject.Digest.String())
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, imgspecv1.Descriptor{
+		MediaType:    mimeType,
+		Digest:       manifestDigest,
+		Size:         int64(len(m)),
+		Annotations:  parsed.Annotations,
+		ArtifactType: artifactType,
+	})
+	indexBlob, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if _, err := d.uploadManifest(ctx, indexBlob, tag); err != nil {
+		return fmt.Errorf("updating referrers of %s: %w", parsed.Subject.Digest.String(), err)
 	}
 	return nil
 }
@@ -552,11 +632,11 @@ func (d *dockerImageDestination) PutSignaturesWithFormat(ctx context.Context, si
 		}
 		switch {
 		case d.c.supportsSignatures:
-			if err := d.putSignaturesToAPIExtension(ctx, signatures, *instanceDigest); err != nil {
+			if err := d.putSignaturesToAPIExtension(ctx, otherSignatures, *instanceDigest); err != nil {
 				return err
 			}
 		case d.c.signatureBase != nil:
-			if err := d.putSignaturesToLookaside(signatures, *instanceDigest); err != nil {
+			if err := d.putSignaturesToLookaside(ctx, otherSignatures, *instanceDigest); err != nil {
 				return err
 			}
 		default:
@@ -567,9 +647,16 @@ func (d *dockerImageDestination) PutSignaturesWithFormat(ctx context.Context, si
 	return nil
 }
 
+// PutSignaturesForExistingImage writes a set of signatures for an existing manifest with manifestDigest,
+// replacing the signatures already stored for it.
+// Unlike PutSignaturesWithFormat, this does not require PutManifest to be called first.
+func (d *dockerImageDestination) PutSignaturesForExistingImage(ctx context.Context, signatures []signature.Signature, manifestDigest digest.Digest) error {
+	return d.PutSignaturesWithFormat(ctx, signatures, &manifestDigest)
+}
+
 // putSignaturesToLookaside implements PutSignaturesWithFormat() from the lookaside location configured in s.c.signatureBase,
 // which is not nil, for a manifest with manifestDigest.
-func (d *dockerImageDestination) putSignaturesToLookaside(signatures []signature.Signature, manifestDigest digest.Digest) error {
+func (d *dockerImageDestination) putSignaturesToLookaside(ctx context.Context, signatures []signature.Signature, manifestDigest digest.Digest) error {
 	// FIXME? This overwrites files one at a time, definitely not atomic.
 	// A failure when updating signatures with a reordered copy could lose some of them.
 
@@ -581,7 +668,7 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures []signature
 	// NOTE: Keep this in sync with docs/signature-protocols.md!
 	for i, signature := range signatures {
 		sigURL := lookasideStorageURL(d.c.signatureBase, manifestDigest, i)
-		err := d.putOneSignature(sigURL, signature)
+		err := d.putOneSignature(ctx, sigURL, signature)
 		if err != nil {
 			return err
 		}
@@ -593,7 +680,7 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures []signature
 	// is sufficient.
 	for i := len(signatures); ; i++ {
 		sigURL := lookasideStorageURL(d.c.signatureBase, manifestDigest, i)
-		missing, err := d.c.deleteOneSignature(sigURL)
+		missing, err := d.c.deleteOneSignature(ctx, sigURL)
 		if err != nil {
 			return err
 		}
@@ -607,10 +694,10 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures []signature
 
 // putOneSignature stores sig to sigURL.
 // NOTE: Keep this in sync with docs/signature-protocols.md!
-func (d *dockerImageDestination) putOneSignature(sigURL *url.URL, sig signature.Signature) error {
+func (d *dockerImageDestination) putOneSignature(ctx context.Context, sigURL *url.URL, sig signature.Signature) error {
 	switch sigURL.Scheme {
 	case "file":
-		logrus.Debugf("Writing to %s", sigURL.Path)
+		d.c.logger.Debugf("Writing to %s", sigURL.Path)
 		err := os.MkdirAll(filepath.Dir(sigURL.Path), 0755)
 		if err != nil {
 			return err
@@ -626,7 +713,21 @@ func (d *dockerImageDestination) putOneSignature(sigURL *url.URL, sig signature.
 		return nil
 
 	case "http", "https":
-		return fmt.Errorf("Writing directly to a %s lookaside %s is not supported. Configure a lookaside-staging: location", sigURL.Scheme, sigURL.Redacted())
+		blob, err := signature.Blob(sig)
+		if err != nil {
+			return err
+		}
+		res, err := d.c.modifyHTTPLookaside(ctx, http.MethodPut, sigURL, bytes.NewReader(blob))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		switch res.StatusCode {
+		case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+			return nil
+		default:
+			return fmt.Errorf("writing signature to %s: status %d (%s)", sigURL.Redacted(), res.StatusCode, http.StatusText(res.StatusCode))
+		}
 	default:
 		return fmt.Errorf("Unsupported scheme when writing signature to %s", sigURL.Redacted())
 	}
@@ -650,7 +751,7 @@ func (d *dockerImageDestination) putSignaturesToSigstoreAttachments(ctx context.
 		}, nil)
 		ociConfig.RootFS.Type = "layers"
 	} else {
-		logrus.Debugf("Fetching sigstore attachment config %s", ociManifest.Config.Digest.String())
+		d.c.logger.Debugf("Fetching sigstore attachment config %s", ociManifest.Config.Digest.String())
 		// We don’t benefit from a real BlobInfoCache here because we never try to reuse/mount configs.
 		configBlob, err := d.c.getOCIDescriptorContents(ctx, d.ref, ociManifest.Config, iolimits.MaxConfigBodySize,
 			none.NoCache)
@@ -671,7 +772,7 @@ func (d *dockerImageDestination) putSignaturesToSigstoreAttachments(ctx context.
 		alreadyOnRegistry := false
 		for _, layer := range ociManifest.Layers {
 			if layerMatchesSigstoreSignature(layer, mimeType, payloadBlob, annotations) {
-				logrus.Debugf("Signature with digest %s already exists on the registry", layer.Digest.String())
+				d.c.logger.Debugf("Signature with digest %s already exists on the registry", layer.Digest.String())
 				alreadyOnRegistry = true
 				break
 			}
@@ -695,14 +796,14 @@ func (d *dockerImageDestination) putSignaturesToSigstoreAttachments(ctx context.
 		sigDesc.Annotations = annotations
 		ociManifest.Layers = append(ociManifest.Layers, sigDesc)
 		ociConfig.RootFS.DiffIDs = append(ociConfig.RootFS.DiffIDs, sigDesc.Digest)
-		logrus.Debugf("Adding new signature, digest %s", sigDesc.Digest.String())
+		d.c.logger.Debugf("Adding new signature, digest %s", sigDesc.Digest.String())
 	}
 
 	configBlob, err := json.Marshal(ociConfig)
 	if err != nil {
 		return err
 	}
-	logrus.Debugf("Uploading updated sigstore attachment config")
+	d.c.logger.Debugf("Uploading updated sigstore attachment config")
 	// We don’t benefit from a real BlobInfoCache here because we never try to reuse/mount configs.
 	configDesc, err := d.putBlobBytesAsOCI(ctx, configBlob, imgspecv1.MediaTypeImageConfig, private.PutBlobOptions{
 		Cache:      none.NoCache,
@@ -719,8 +820,9 @@ func (d *dockerImageDestination) putSignaturesToSigstoreAttachments(ctx context.
 	if err != nil {
 		return nil
 	}
-	logrus.Debugf("Uploading sigstore attachment manifest")
-	return d.uploadManifest(ctx, manifestBlob, sigstoreAttachmentTag(manifestDigest))
+	d.c.logger.Debugf("Uploading sigstore attachment manifest")
+	_, err = d.uploadManifest(ctx, manifestBlob, sigstoreAttachmentTag(manifestDigest))
+	return err
 }
 
 func layerMatchesSigstoreSignature(layer imgspecv1.Descriptor, mimeType string,
@@ -761,7 +863,7 @@ func (d *dockerImageDestination) putBlobBytesAsOCI(ctx context.Context, contents
 		return imgspecv1.Descriptor{}, fmt.Errorf("writing blob %s: %w", blobDigest.String(), err)
 	}
 	return imgspecv1.Descriptor{
-		MediaType: mimeType,
+		MediaType: info.MediaType,
 		Digest:    info.Digest,
 		Size:      info.Size,
 	}, nil
@@ -770,10 +872,10 @@ func (d *dockerImageDestination) putBlobBytesAsOCI(ctx context.Context, contents
 // deleteOneSignature deletes a signature from sigURL, if it exists.
 // If it successfully determines that the signature does not exist, returns (true, nil)
 // NOTE: Keep this in sync with docs/signature-protocols.md!
-func (c *dockerClient) deleteOneSignature(sigURL *url.URL) (missing bool, err error) {
+func (c *dockerClient) deleteOneSignature(ctx context.Context, sigURL *url.URL) (missing bool, err error) {
 	switch sigURL.Scheme {
 	case "file":
-		logrus.Debugf("Deleting %s", sigURL.Path)
+		c.logger.Debugf("Deleting %s", sigURL.Path)
 		err := os.Remove(sigURL.Path)
 		if err != nil && os.IsNotExist(err) {
 			return true, nil
@@ -781,12 +883,45 @@ func (c *dockerClient) deleteOneSignature(sigURL *url.URL) (missing bool, err er
 		return false, err
 
 	case "http", "https":
-		return false, fmt.Errorf("Writing directly to a %s lookaside %s is not supported. Configure a lookaside-staging: location", sigURL.Scheme, sigURL.Redacted())
+		res, err := c.modifyHTTPLookaside(ctx, http.MethodDelete, sigURL, nil)
+		if err != nil {
+			return false, err
+		}
+		defer res.Body.Close()
+		switch res.StatusCode {
+		case http.StatusNotFound:
+			return true, nil
+		case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+			return false, nil
+		default:
+			return false, fmt.Errorf("deleting signature %s: status %d (%s)", sigURL.Redacted(), res.StatusCode, http.StatusText(res.StatusCode))
+		}
 	default:
 		return false, fmt.Errorf("Unsupported scheme when deleting signature from %s", sigURL.Redacted())
 	}
 }
 
+// modifyHTTPLookaside makes a request with method, and body if it is not nil, to modify the signature at sigURL in http(s)
+// signature storage, if the configuration allows that. The request includes the registry credentials.
+// NOTE: Keep this in sync with docs/signature-protocols.md!
+func (c *dockerClient) modifyHTTPLookaside(ctx context.Context, method string, sigURL *url.URL, body io.Reader) (*http.Response, error) {
+	if !c.writeHTTPLookaside {
+		return nil, fmt.Errorf("modifying signatures at %s is not enabled; set write-http-lookaside in registries.d to allow it", sigURL.Redacted())
+	}
+	c.logger.Debugf("%s %s", method, sigURL.Redacted())
+	req, err := http.NewRequestWithContext(ctx, method, sigURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if c.auth.Username != "" {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+	return c.client.Do(req)
+}
+
 // putSignaturesToAPIExtension implements PutSignaturesWithFormat() using the X-Registry-Supports-Signatures API extension,
 // for a manifest with manifestDigest.
 func (d *dockerImageDestination) putSignaturesToAPIExtension(ctx context.Context, signatures []signature.Signature, manifestDigest digest.Digest) error {
@@ -853,8 +988,8 @@ sigExists:
 		}
 		defer res.Body.Close()
 		if res.StatusCode != http.StatusCreated {
-			logrus.Debugf("Error uploading signature, status %d, %#v", res.StatusCode, res)
-			return fmt.Errorf("uploading signature to %s in %s: %w", path, d.c.registry, registryHTTPResponseToError(res))
+			d.c.logger.Debugf("Error uploading signature, status %d, %#v", res.StatusCode, res)
+			return fmt.Errorf("uploading signature to %s in %s: %w", path, d.c.registry, registryHTTPResponseToError(d.c.logger, res))
 		}
 	}
 
@@ -871,3 +1006,42 @@ sigExists:
 func (d *dockerImageDestination) Commit(context.Context, types.UnparsedImage) error {
 	return nil
 }
+
+// dockerBlobProbe is a private.BlobProbe for a docker reference.
+type dockerBlobProbe struct {
+	ref dockerReference
+	c   *dockerClient
+}
+
+// newBlobProbe returns a private.BlobProbe for ref.
+func newBlobProbe(ctx context.Context, sys *types.SystemContext, ref dockerReference) (private.BlobProbe, error) {
+	registryConfig, err := loadRegistryConfiguration(sys)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newDockerClientFromRef(ctx, sys, ref, registryConfig, true, "pull")
+	if err != nil {
+		return nil, err
+	}
+	return &dockerBlobProbe{ref: ref, c: c}, nil
+}
+
+// BlobExists returns true if the repository already contains the blob with info.Digest.
+func (p *dockerBlobProbe) BlobExists(ctx context.Context, info types.BlobInfo) (bool, error) {
+	if info.Digest == "" {
+		return false, errors.New("Can not check for a blob with unknown digest")
+	}
+	exists, _, err := p.c.blobExists(ctx, p.ref.ref, info.Digest, nil)
+	return exists, err
+}
+
+// AcceptsForeignLayerURLs returns false iff foreign layers in manifest should be actually
+// uploaded to the image destination, true otherwise.
+func (p *dockerBlobProbe) AcceptsForeignLayerURLs() bool {
+	return true
+}
+
+// Close removes resources associated with the probe, if any.
+func (p *dockerBlobProbe) Close() error {
+	return nil
+}
@@ -3,15 +3,34 @@ package docker
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/containers/image/v5/internal/private"
+	internalsig "github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 var _ private.ImageDestination = (*dockerImageDestination)(nil)
+var _ private.ImageDestinationWithReferrers = (*dockerImageDestination)(nil)
+var _ private.ImageReferenceWithBlobProbes = dockerReference{}
+var _ private.ImageReferenceWithExistenceCheck = dockerReference{}
 
 func TestIsManifestInvalidError(t *testing.T) {
 	// Sadly only a smoke test; this really should record all known errors exactly as they happen.
@@ -28,8 +47,190 @@ func TestIsManifestInvalidError(t *testing.T) {
 		"{\"errors\":[{\"code\":\"TAG_INVALID\",\"message\":\"manifest tag did not match URI\"}]}\n"
 	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(response))), nil)
 	require.NoError(t, err)
-	err = registryHTTPResponseToError(resp)
+	err = registryHTTPResponseToError(logrus.StandardLogger(), resp)
 
 	res := isManifestInvalidError(err)
 	assert.True(t, res, "%#v", err)
 }
+
+// newBlobUploadTestRegistry returns a httptest.Server accepting blob uploads to any repository,
+// which never contains any blobs beforehand.
+func newBlobUploadTestRegistry(t *testing.T) *httptest.Server {
+	uploadPathRegex := regexp.MustCompile("^/v2/.*/blobs/uploads/")
+	blobPathRegex := regexp.MustCompile("^/v2/.*/blobs/sha256:[0-9a-f]{64}$")
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead && blobPathRegex.MatchString(r.URL.Path):
+			rw.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && uploadPathRegex.MatchString(r.URL.Path):
+			rw.Header().Set("Location", r.URL.Path+"upload-id")
+			rw.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPatch && uploadPathRegex.MatchString(r.URL.Path):
+			_, err := io.Copy(io.Discard, r.Body)
+			assert.NoError(t, err)
+			rw.Header().Set("Location", r.URL.Path)
+			rw.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut && uploadPathRegex.MatchString(r.URL.Path):
+			rw.WriteHeader(http.StatusCreated)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestDockerImageDestinationPutBlobMediaType(t *testing.T) {
+	const customMediaType = "application/vnd.example.custom.blob.v1+json"
+
+	server := newBlobUploadTestRegistry(t)
+	defer server.Close()
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+	require.NoError(t, err)
+	publicDest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	})
+	require.NoError(t, err)
+	defer publicDest.Close()
+	dest, ok := publicDest.(*dockerImageDestination)
+	require.True(t, ok)
+
+	blob := []byte("custom blob contents")
+	info, err := dest.PutBlobWithOptions(context.Background(), bytes.NewReader(blob), types.BlobInfo{
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+		MediaType: customMediaType,
+	}, private.PutBlobOptions{Cache: none.NoCache})
+	require.NoError(t, err)
+	assert.Equal(t, customMediaType, info.MediaType)
+	assert.Equal(t, digest.FromBytes(blob), info.Digest)
+	assert.Equal(t, int64(len(blob)), info.Size)
+
+	desc, err := dest.putBlobBytesAsOCI(context.Background(), blob, customMediaType, private.PutBlobOptions{Cache: none.NoCache})
+	require.NoError(t, err)
+	assert.Equal(t, customMediaType, desc.MediaType)
+	assert.Equal(t, digest.FromBytes(blob), desc.Digest)
+	assert.Equal(t, int64(len(blob)), desc.Size)
+}
+
+func TestDockerImageDestinationRejectSchema1(t *testing.T) {
+	ref, err := ParseReference("//registry.example.com/repo:tag")
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		RegistriesDirPath:            "/this/does/not/exist",
+		DockerPerHostCertDirPath:     "/this/does/not/exist",
+		DockerRejectSchema1Manifests: true,
+	}
+	dest, err := ref.NewImageDestination(context.Background(), sys)
+	require.NoError(t, err)
+	defer dest.Close()
+	assert.NotContains(t, dest.SupportedManifestMIMETypes(), manifest.DockerV2Schema1SignedMediaType)
+	assert.NotContains(t, dest.SupportedManifestMIMETypes(), manifest.DockerV2Schema1MediaType)
+	assert.Contains(t, dest.SupportedManifestMIMETypes(), manifest.DockerV2Schema2MediaType)
+
+	// The manifest is rejected before contacting the registry.
+	err = dest.PutManifest(context.Background(), []byte(`{"schemaVersion":1,"name":"repo","tag":"tag"}`), nil)
+	assert.ErrorContains(t, err, "schema1 manifests are disabled")
+}
+
+func TestDockerImageDestinationPutSignaturesToLookaside(t *testing.T) {
+	manifestDigest := digest.FromString("manifest")
+	sigDir := "repo@" + manifestDigest.Algorithm().String() + "=" + manifestDigest.Encoded()
+	sigs := []internalsig.Signature{
+		internalsig.SimpleSigningFromBlob([]byte{0xA3, 'A'}),
+		internalsig.SimpleSigningFromBlob([]byte{0xA3, 'B'}),
+	}
+
+	var mutex sync.Mutex
+	stored := map[string][]byte{} // Signatures stored on the HTTP server, indexed by path
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/lookaside/"):
+			user, password, ok := r.BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "user", user)
+			assert.Equal(t, "password", password)
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "application/octet-stream", r.Header.Get("Content-Type"))
+			stored[r.URL.Path] = body
+			rw.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/lookaside/"):
+			if _, ok := stored[r.URL.Path]; !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(stored, r.URL.Path)
+			rw.WriteHeader(http.StatusNoContent)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// putSignatures writes sigs using a registries.d configuration with lookaside-staging set to staging,
+	// and write-http-lookaside set to writeHTTP.
+	putSignatures := func(staging string, writeHTTP bool) error {
+		registriesDir := t.TempDir()
+		config := fmt.Sprintf("docker:\n  %s/repo:\n    lookaside: %s/lookaside\n    lookaside-staging: %s\n    write-http-lookaside: %v\n",
+			registryURL.Host, server.URL, staging, writeHTTP)
+		err := os.WriteFile(filepath.Join(registriesDir, "default.yaml"), []byte(config), 0600)
+		require.NoError(t, err)
+		ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+		require.NoError(t, err)
+		dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{
+			RegistriesDirPath:           registriesDir,
+			DockerPerHostCertDirPath:    "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			DockerAuthConfig:            &types.DockerAuthConfig{Username: "user", Password: "password"},
+		})
+		require.NoError(t, err)
+		defer dest.Close()
+		dest2, ok := dest.(*dockerImageDestination)
+		require.True(t, ok)
+		return dest2.PutSignaturesForExistingImage(context.Background(), sigs, manifestDigest)
+	}
+
+	// A file: staging directory; a signature which is no longer present is removed.
+	staging := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(staging, sigDir), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(staging, sigDir, "signature-3"), []byte{0xA3, 'C'}, 0o644))
+	err = putSignatures("file://"+staging, false)
+	require.NoError(t, err)
+	for i, expected := range [][]byte{{0xA3, 'A'}, {0xA3, 'B'}} {
+		contents, err := os.ReadFile(filepath.Join(staging, sigDir, fmt.Sprintf("signature-%d", i+1)))
+		require.NoError(t, err)
+		assert.Equal(t, expected, contents)
+	}
+	_, err = os.Stat(filepath.Join(staging, sigDir, "signature-3"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, stored)
+
+	// A HTTP lookaside-staging server
+	mutex.Lock()
+	stored["/lookaside/"+sigDir+"/signature-3"] = []byte{0xA3, 'C'}
+	mutex.Unlock()
+	err = putSignatures(server.URL+"/lookaside", false) // Not allowed without write-http-lookaside
+	assert.Error(t, err)
+	err = putSignatures(server.URL+"/lookaside", true)
+	require.NoError(t, err)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, map[string][]byte{
+		"/lookaside/" + sigDir + "/signature-1": {0xA3, 'A'},
+		"/lookaside/" + sigDir + "/signature-2": {0xA3, 'B'},
+	}, stored)
+}
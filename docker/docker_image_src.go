@@ -18,6 +18,7 @@ import (
 	"github.com/containers/image/v5/internal/imagesource/impl"
 	"github.com/containers/image/v5/internal/imagesource/stubs"
 	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/logger"
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/manifest"
@@ -25,7 +26,7 @@ import (
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
-	"github.com/sirupsen/logrus"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // maxLookasideSignatures is an arbitrary limit for the total number of signatures we would try to read from a lookaside server,
@@ -42,8 +43,9 @@ type dockerImageSource struct {
 	physicalRef dockerReference // The actual reference we are accessing (possibly a mirror)
 	c           *dockerClient
 	// State
-	cachedManifest         []byte // nil if not loaded yet
-	cachedManifestMIMEType string // Only valid if cachedManifest != nil
+	cachedManifest         []byte        // nil if not loaded yet
+	cachedManifestMIMEType string        // Only valid if cachedManifest != nil
+	cachedManifestDigest   digest.Digest // Only valid if cachedManifest != nil; "" if the registry did not provide a matching Docker-Content-Digest
 }
 
 // newImageSource creates a new ImageSource for the specified image reference.
@@ -67,6 +69,11 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref dockerRef
 			Prefix: ref.ref.String(),
 		}
 	}
+	// Don’t bypass the block by reading from mirrors or a pull-through cache, either.
+	// (Mirrors which are themselves blocked are refused in newDockerClient.)
+	if registry.Blocked {
+		return nil, blockedRegistryError(sys, registry)
+	}
 
 	// Check all endpoints for the manifest availability. If we find one that does
 	// contain the image, it will be used for all future pull actions.  Always try the
@@ -77,6 +84,13 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref dockerRef
 	if err != nil {
 		return nil, err
 	}
+	if sys != nil && sys.DockerPullThroughCache != "" {
+		cacheSource, err := pullThroughCacheSource(sys.DockerPullThroughCache, ref.ref)
+		if err != nil {
+			return nil, err
+		}
+		pullSources = append([]sysregistriesv2.PullSource{cacheSource}, pullSources...)
+	}
 	type attempt struct {
 		ref reference.Named
 		err error
@@ -84,15 +98,15 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref dockerRef
 	attempts := []attempt{}
 	for _, pullSource := range pullSources {
 		if sys != nil && sys.DockerLogMirrorChoice {
-			logrus.Infof("Trying to access %q", pullSource.Reference)
+			logger.FromSystemContext(sys).Infof("Trying to access %q", pullSource.Reference)
 		} else {
-			logrus.Debugf("Trying to access %q", pullSource.Reference)
+			logger.FromSystemContext(sys).Debugf("Trying to access %q", pullSource.Reference)
 		}
 		s, err := newImageSourceAttempt(ctx, sys, ref, pullSource, registryConfig)
 		if err == nil {
 			return s, nil
 		}
-		logrus.Debugf("Accessing %q failed: %v", pullSource.Reference, err)
+		logger.FromSystemContext(sys).Debugf("Accessing %q failed: %v", pullSource.Reference, err)
 		attempts = append(attempts, attempt{
 			ref: pullSource.Reference,
 			err: err,
@@ -116,6 +130,19 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref dockerRef
 	}
 }
 
+// pullThroughCacheSource returns a PullSource for reading ref from a pull-through cache at location, as set in types.SystemContext.DockerPullThroughCache.
+func pullThroughCacheSource(location string, ref reference.Named) (sysregistriesv2.PullSource, error) {
+	refString := ref.String()
+	cacheRef, err := reference.ParseNamed(strings.TrimSuffix(location, "/") + refString[len(reference.Domain(ref)):])
+	if err != nil {
+		return sysregistriesv2.PullSource{}, fmt.Errorf("invalid pull-through cache location %q: %w", location, err)
+	}
+	return sysregistriesv2.PullSource{
+		Endpoint:  sysregistriesv2.Endpoint{Location: location},
+		Reference: cacheRef,
+	}, nil
+}
+
 // newImageSourceAttempt is an internal helper for newImageSource. Everyone else must call newImageSource.
 // Given a logicalReference and a pullSource, return a dockerImageSource if it is reachable.
 // The caller must call .Close() on the returned ImageSource.
@@ -135,7 +162,7 @@ func newImageSourceAttempt(ctx context.Context, sys *types.SystemContext, logica
 		endpointSys = &copy
 	}
 
-	client, err := newDockerClientFromRef(endpointSys, physicalRef, registryConfig, false, "pull")
+	client, err := newDockerClientFromRef(ctx, endpointSys, physicalRef, registryConfig, false, "pull")
 	if err != nil {
 		return nil, err
 	}
@@ -188,7 +215,8 @@ func simplifyContentType(contentType string) string {
 // this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
 func (s *dockerImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
 	if instanceDigest != nil {
-		return s.fetchManifest(ctx, instanceDigest.String())
+		manblob, mt, _, err := s.fetchManifest(ctx, instanceDigest.String())
+		return manblob, mt, err
 	}
 	err := s.ensureManifestIsLoaded(ctx)
 	if err != nil {
@@ -197,11 +225,13 @@ func (s *dockerImageSource) GetManifest(ctx context.Context, instanceDigest *dig
 	return s.cachedManifest, s.cachedManifestMIMEType, nil
 }
 
-func (s *dockerImageSource) fetchManifest(ctx context.Context, tagOrDigest string) ([]byte, string, error) {
+// fetchManifest fetches the manifest for tagOrDigest, and returns it along with its MIME type and, if verified, a digest
+// provided by the registry (or "" if none).
+func (s *dockerImageSource) fetchManifest(ctx context.Context, tagOrDigest string) ([]byte, string, digest.Digest, error) {
 	return s.c.fetchManifest(ctx, s.physicalRef, tagOrDigest)
 }
 
-// ensureManifestIsLoaded sets s.cachedManifest and s.cachedManifestMIMEType
+// ensureManifestIsLoaded sets s.cachedManifest, s.cachedManifestMIMEType and s.cachedManifestDigest
 //
 // ImageSource implementations are not required or expected to do any caching,
 // but because our signatures are “attached” to the manifest digest,
@@ -218,13 +248,13 @@ func (s *dockerImageSource) ensureManifestIsLoaded(ctx context.Context) error {
 		return err
 	}
 
-	manblob, mt, err := s.fetchManifest(ctx, reference)
+	manblob, mt, verifiedDigest, err := s.fetchManifest(ctx, reference)
 	if err != nil {
 		return err
 	}
-	// We might validate manblob against the Docker-Content-Digest header here to protect against transport errors.
 	s.cachedManifest = manblob
 	s.cachedManifestMIMEType = mt
+	s.cachedManifestDigest = verifiedDigest
 	return nil
 }
 
@@ -347,7 +377,7 @@ func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo,
 	}
 
 	path := fmt.Sprintf(blobsPath, reference.Path(s.physicalRef.ref), info.Digest.String())
-	logrus.Debugf("Downloading %s", path)
+	s.c.logger.Debugf("Downloading %s", path)
 	res, err := s.c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
 	if err != nil {
 		return nil, nil, err
@@ -376,7 +406,7 @@ func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo,
 		res.Body.Close()
 		return nil, nil, private.BadPartialRequestError{Status: res.Status}
 	default:
-		err := registryHTTPResponseToError(res)
+		err := registryHTTPResponseToError(s.c.logger, res)
 		res.Body.Close()
 		return nil, nil, fmt.Errorf("fetching partial blob: %w", err)
 	}
@@ -389,6 +419,27 @@ func (s *dockerImageSource) GetBlob(ctx context.Context, info types.BlobInfo, ca
 	return s.c.getBlob(ctx, s.physicalRef, info, cache)
 }
 
+// BlobSize returns the size of the blob described by info, without reading its contents, or -1 if it is unknown.
+// The Digest field in BlobInfo is guaranteed to be provided, Size may be -1 and MediaType may be optionally provided.
+// It may use a remote (= slow) service.
+func (s *dockerImageSource) BlobSize(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (int64, error) {
+	if len(info.URLs) != 0 {
+		return -1, nil // GetBlob may read the blob from one of the URLs instead of the registry.
+	}
+	path := fmt.Sprintf(blobsPath, reference.Path(s.physicalRef.ref), info.Digest.String())
+	s.c.logger.Debugf("Checking size of %s", path)
+	res, err := s.c.makeRequest(ctx, http.MethodHead, path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return -1, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return -1, fmt.Errorf("checking size of blob %s: %w", info.Digest, registryHTTPResponseToError(s.c.logger, res))
+	}
+	cache.RecordKnownLocation(s.physicalRef.Transport(), bicTransportScope(s.physicalRef), info.Digest, newBICLocationReference(s.physicalRef))
+	return getBlobSize(res), nil
+}
+
 // GetSignaturesWithFormat returns the image's signatures.  It may use a remote (= slow) service.
 // If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve signatures for
 // (when the primary manifest is a manifest list); this never happens if the primary manifest is not a manifest list
@@ -406,7 +457,7 @@ func (s *dockerImageSource) GetSignaturesWithFormat(ctx context.Context, instanc
 		}
 		res = append(res, sigs...)
 	case s.c.signatureBase != nil:
-		sigs, err := s.getSignaturesFromLookaside(ctx, instanceDigest)
+		sigs, err := s.getSignaturesFromLookaside(ctx, s.c.signatureBase, instanceDigest)
 		if err != nil {
 			return nil, err
 		}
@@ -420,9 +471,67 @@ func (s *dockerImageSource) GetSignaturesWithFormat(ctx context.Context, instanc
 		return nil, err
 	}
 	res = append(res, sigstoreSigs...)
+
+	if s.c.signatureStagingBase != nil {
+		stagedSigs, err := s.getSignaturesFromLookaside(ctx, s.c.signatureStagingBase, instanceDigest)
+		if err != nil {
+			return nil, fmt.Errorf("reading signatures from lookaside-staging: %w", err)
+		}
+		if s.c.readStagingFirst {
+			res, err = mergeSignatures(stagedSigs, res)
+		} else {
+			res, err = mergeSignatures(res, stagedSigs)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// mergeSignatures returns first, followed by the signatures in second which are not included in first.
+func mergeSignatures(first, second []signature.Signature) ([]signature.Signature, error) {
+	seen := map[string]struct{}{}
+	key := func(sig signature.Signature) (string, error) {
+		blob, err := signature.Blob(sig)
+		if err != nil {
+			return "", err
+		}
+		return string(blob), nil
+	}
+	res := make([]signature.Signature, 0, len(first)+len(second))
+	for _, sig := range first {
+		k, err := key(sig)
+		if err != nil {
+			return nil, err
+		}
+		seen[k] = struct{}{}
+		res = append(res, sig)
+	}
+	for _, sig := range second {
+		k, err := key(sig)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		res = append(res, sig)
+	}
 	return res, nil
 }
 
+// GetReferrers returns descriptors of the manifests of artifacts with artifactType which refer to the manifest with manifestDigest,
+// or of artifacts of all types if artifactType is "".
+// It may use a remote (= slow) service.
+func (s *dockerImageSource) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	if err := s.c.detectProperties(ctx); err != nil {
+		return nil, err
+	}
+	return s.c.getReferrers(ctx, s.physicalRef, manifestDigest, artifactType)
+}
+
 // manifestDigest returns a digest of the manifest, from instanceDigest if non-nil; or from the supplied reference,
 // or finally, from a fetched manifest.
 func (s *dockerImageSource) manifestDigest(ctx context.Context, instanceDigest *digest.Digest) (digest.Digest, error) {
@@ -438,12 +547,15 @@ func (s *dockerImageSource) manifestDigest(ctx context.Context, instanceDigest *
 	if err := s.ensureManifestIsLoaded(ctx); err != nil {
 		return "", err
 	}
+	if s.cachedManifestDigest != "" && s.cachedManifestDigest.Algorithm() == digest.Canonical {
+		return s.cachedManifestDigest, nil
+	}
 	return manifest.Digest(s.cachedManifest)
 }
 
-// getSignaturesFromLookaside implements GetSignaturesWithFormat() from the lookaside location configured in s.c.signatureBase,
-// which is not nil.
-func (s *dockerImageSource) getSignaturesFromLookaside(ctx context.Context, instanceDigest *digest.Digest) ([]signature.Signature, error) {
+// getSignaturesFromLookaside implements GetSignaturesWithFormat() from the lookaside location base (s.c.signatureBase or
+// s.c.signatureStagingBase), which is not nil.
+func (s *dockerImageSource) getSignaturesFromLookaside(ctx context.Context, base lookasideStorageBase, instanceDigest *digest.Digest) ([]signature.Signature, error) {
 	manifestDigest, err := s.manifestDigest(ctx, instanceDigest)
 	if err != nil {
 		return nil, err
@@ -456,7 +568,7 @@ func (s *dockerImageSource) getSignaturesFromLookaside(ctx context.Context, inst
 			return nil, fmt.Errorf("server provided %d signatures, assuming that's unreasonable and a server error", maxLookasideSignatures)
 		}
 
-		sigURL := lookasideStorageURL(s.c.signatureBase, manifestDigest, i)
+		sigURL := lookasideStorageURL(base, manifestDigest, i)
 		signature, missing, err := s.getOneSignature(ctx, sigURL)
 		if err != nil {
 			return nil, err
@@ -475,7 +587,7 @@ func (s *dockerImageSource) getSignaturesFromLookaside(ctx context.Context, inst
 func (s *dockerImageSource) getOneSignature(ctx context.Context, sigURL *url.URL) (signature.Signature, bool, error) {
 	switch sigURL.Scheme {
 	case "file":
-		logrus.Debugf("Reading %s", sigURL.Path)
+		s.c.logger.Debugf("Reading %s", sigURL.Path)
 		sigBlob, err := os.ReadFile(sigURL.Path)
 		if err != nil {
 			if os.IsNotExist(err) {
@@ -490,7 +602,7 @@ func (s *dockerImageSource) getOneSignature(ctx context.Context, sigURL *url.URL
 		return sig, false, nil
 
 	case "http", "https":
-		logrus.Debugf("GET %s", sigURL.Redacted())
+		s.c.logger.Debugf("GET %s", sigURL.Redacted())
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, sigURL.String(), nil)
 		if err != nil {
 			return nil, false, err
@@ -501,7 +613,7 @@ func (s *dockerImageSource) getOneSignature(ctx context.Context, sigURL *url.URL
 		}
 		defer res.Body.Close()
 		if res.StatusCode == http.StatusNotFound {
-			logrus.Debugf("... got status 404, as expected = end of signatures")
+			s.c.logger.Debugf("... got status 404, as expected = end of signatures")
 			return nil, true, nil
 		} else if res.StatusCode != http.StatusOK {
 			return nil, false, fmt.Errorf("reading signature from %s: status %d (%s)", sigURL.Redacted(), res.StatusCode, http.StatusText(res.StatusCode))
@@ -509,12 +621,12 @@ func (s *dockerImageSource) getOneSignature(ctx context.Context, sigURL *url.URL
 
 		contentType := res.Header.Get("Content-Type")
 		if mimeType := simplifyContentType(contentType); mimeType == "text/html" {
-			logrus.Warnf("Signature %q has Content-Type %q, unexpected for a signature", sigURL.Redacted(), contentType)
+			s.c.logger.Warnf("Signature %q has Content-Type %q, unexpected for a signature", sigURL.Redacted(), contentType)
 			// Don’t immediately fail; the lookaside spec does not place any requirements on Content-Type.
 			// If the content really is HTML, it’s going to fail in signature.FromBlob.
 		}
 
-		sigBlob, err := iolimits.ReadAtMost(res.Body, iolimits.MaxSignatureBodySize)
+		sigBlob, err := iolimits.ReadAtMost(res.Body, s.c.maxSignatureSize)
 		if err != nil {
 			return nil, false, err
 		}
@@ -552,7 +664,7 @@ func (s *dockerImageSource) getSignaturesFromAPIExtension(ctx context.Context, i
 
 func (s *dockerImageSource) getSignaturesFromSigstoreAttachments(ctx context.Context, instanceDigest *digest.Digest) ([]signature.Signature, error) {
 	if !s.c.useSigstoreAttachments {
-		logrus.Debugf("Not looking for sigstore attachments: disabled by configuration")
+		s.c.logger.Debugf("Not looking for sigstore attachments: disabled by configuration")
 		return nil, nil
 	}
 
@@ -561,29 +673,74 @@ func (s *dockerImageSource) getSignaturesFromSigstoreAttachments(ctx context.Con
 		return nil, err
 	}
 
+	res := []signature.Signature{}
+	seen := map[digest.Digest]struct{}{} // Layer digests of the attachments in res
 	ociManifest, err := s.c.getSigstoreAttachmentManifest(ctx, s.physicalRef, manifestDigest)
 	if err != nil {
 		return nil, err
 	}
-	if ociManifest == nil {
-		return nil, nil
+	if ociManifest != nil {
+		s.c.logger.Debugf("Found a sigstore attachment manifest with %d layers", len(ociManifest.Layers))
+		res, err = s.appendSigstoreAttachments(ctx, res, seen, ociManifest)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	logrus.Debugf("Found a sigstore attachment manifest with %d layers", len(ociManifest.Layers))
-	res := []signature.Signature{}
+	// Signatures can also be stored as OCI referrers of the image, instead of (or in addition to) the attachment tag.
+	referrers, err := s.c.getReferrers(ctx, s.physicalRef, manifestDigest, sigstoreSignatureArtifactType)
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range referrers {
+		s.c.logger.Debugf("Found a sigstore signature referrer %s", referrer.Digest.String())
+		manifestBlob, mimeType, _, err := s.c.fetchManifest(ctx, s.physicalRef, referrer.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		matches, err := manifest.MatchesDigest(manifestBlob, referrer.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("computing digest of sigstore signature referrer %s: %w", referrer.Digest.String(), err)
+		}
+		if !matches {
+			return nil, fmt.Errorf("sigstore signature referrer does not match digest %s", referrer.Digest.String())
+		}
+		if mimeType != imgspecv1.MediaTypeImageManifest {
+			return nil, fmt.Errorf("unexpected MIME type for sigstore signature referrer %s: %q", referrer.Digest.String(), mimeType)
+		}
+		referrerManifest, err := manifest.OCI1FromManifest(manifestBlob)
+		if err != nil {
+			return nil, fmt.Errorf("parsing sigstore signature referrer %s: %w", referrer.Digest.String(), err)
+		}
+		res, err = s.appendSigstoreAttachments(ctx, res, seen, referrerManifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// appendSigstoreAttachments returns res extended with the attachments in the layers of ociManifest,
+// skipping layers with digests in seen, and updating seen.
+func (s *dockerImageSource) appendSigstoreAttachments(ctx context.Context, res []signature.Signature, seen map[digest.Digest]struct{}, ociManifest *manifest.OCI1) ([]signature.Signature, error) {
 	for layerIndex, layer := range ociManifest.Layers {
+		if _, ok := seen[layer.Digest]; ok {
+			s.c.logger.Debugf("Skipping sigstore attachment %d/%d: %s, already found", layerIndex+1, len(ociManifest.Layers), layer.Digest.String())
+			continue
+		}
 		// Note that this copies all kinds of attachments: attestations, and whatever else is there,
 		// not just signatures. We leave the signature consumers to decide based on the MIME type.
-		logrus.Debugf("Fetching sigstore attachment %d/%d: %s", layerIndex+1, len(ociManifest.Layers), layer.Digest.String())
+		s.c.logger.Debugf("Fetching sigstore attachment %d/%d: %s", layerIndex+1, len(ociManifest.Layers), layer.Digest.String())
 		// We don’t benefit from a real BlobInfoCache here because we never try to reuse/mount attachment payloads.
 		// That might eventually need to change if payloads grow to be not just signatures, but something
 		// significantly large.
-		payload, err := s.c.getOCIDescriptorContents(ctx, s.physicalRef, layer, iolimits.MaxSignatureBodySize,
+		payload, err := s.c.getOCIDescriptorContents(ctx, s.physicalRef, layer, s.c.maxSignatureSize,
 			none.NoCache)
 		if err != nil {
 			return nil, err
 		}
 		res = append(res, signature.SigstoreFromComponents(layer.MediaType, payload, layer.Annotations))
+		seen[layer.Digest] = struct{}{}
 	}
 	return res, nil
 }
@@ -601,7 +758,7 @@ func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerRefere
 	// OpenShift ignores the action string (both the password and the token is an OpenShift API token identifying a user).
 	//
 	// We have to hard-code a single string, luckily both docker/distribution and quay.io support "*" to mean "everything".
-	c, err := newDockerClientFromRef(sys, ref, registryConfig, true, "*")
+	c, err := newDockerClientFromRef(ctx, sys, ref, registryConfig, true, "*")
 	if err != nil {
 		return err
 	}
@@ -624,9 +781,9 @@ func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerRefere
 	case http.StatusNotFound:
 		return fmt.Errorf("Unable to delete %v. Image may not exist or is not stored with a v2 Schema in a v2 registry", ref.ref)
 	default:
-		return fmt.Errorf("deleting %v: %w", ref.ref, registryHTTPResponseToError(get))
+		return fmt.Errorf("deleting %v: %w", ref.ref, registryHTTPResponseToError(c.logger, get))
 	}
-	manifestBody, err := iolimits.ReadAtMost(get.Body, iolimits.MaxManifestBodySize)
+	manifestBody, err := iolimits.ReadAtMost(get.Body, c.maxManifestSize)
 	if err != nil {
 		return err
 	}
@@ -645,12 +802,12 @@ func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerRefere
 	}
 	defer delete.Body.Close()
 	if delete.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("deleting %v: %w", ref.ref, registryHTTPResponseToError(delete))
+		return fmt.Errorf("deleting %v: %w", ref.ref, registryHTTPResponseToError(c.logger, delete))
 	}
 
 	for i := 0; ; i++ {
 		sigURL := lookasideStorageURL(c.signatureBase, manifestDigest, i)
-		missing, err := c.deleteOneSignature(sigURL)
+		missing, err := c.deleteOneSignature(ctx, sigURL)
 		if err != nil {
 			return err
 		}
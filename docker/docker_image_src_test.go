@@ -2,23 +2,34 @@ package docker
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/containers/image/v5/internal/private"
+	internalsig "github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 var _ private.ImageSource = (*dockerImageSource)(nil)
+var _ private.ImageSourceWithBlobSizes = (*dockerImageSource)(nil)
 
 func TestDockerImageSourceReference(t *testing.T) {
 	manifestPathRegex := regexp.MustCompile("^/v2/.*/manifests/latest$")
@@ -83,6 +94,234 @@ location = "@REGISTRY@/with-mirror"
 	}
 }
 
+func TestDockerImageSourceManifestDigestVerification(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+	corruptedBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","corrupted":true}`)
+
+	for _, c := range []struct {
+		name           string
+		body           []byte
+		header         string
+		strict         bool
+		expectedDigest digest.Digest // "" if an error is expected, or if the header is missing and !strict
+	}{
+		{"matching header", manifestBlob, manifestDigest.String(), false, manifestDigest},
+		{"matching header, strict", manifestBlob, manifestDigest.String(), true, manifestDigest},
+		{"corrupted body", corruptedBlob, manifestDigest.String(), false, ""},
+		{"corrupted body, strict", corruptedBlob, manifestDigest.String(), true, ""},
+		{"invalid header", manifestBlob, "this is invalid", false, ""},
+		{"invalid header, strict", manifestBlob, "this is invalid", true, ""},
+		{"missing header", manifestBlob, "", false, ""},
+		{"missing header, strict", manifestBlob, "", true, ""},
+	} {
+		missingHeaderAccepted := c.header == "" && !c.strict
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+				rw.WriteHeader(http.StatusOK)
+			case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/tag":
+				rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+				if c.header != "" {
+					rw.Header().Set("Docker-Content-Digest", c.header)
+				}
+				rw.WriteHeader(http.StatusOK)
+				_, err := rw.Write(c.body)
+				assert.NoError(t, err)
+			default:
+				assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+				rw.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		registryURL, err := url.Parse(server.URL)
+		require.NoError(t, err, c.name)
+		ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+		require.NoError(t, err, c.name)
+
+		src, err := ref.NewImageSource(context.Background(), &types.SystemContext{
+			RegistriesDirPath:                      "/this/does/not/exist",
+			DockerPerHostCertDirPath:               "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify:            types.OptionalBoolTrue,
+			DockerStrictManifestDigestVerification: c.strict,
+		})
+		server.Close()
+		if c.expectedDigest == "" && !missingHeaderAccepted {
+			assert.Error(t, err, c.name)
+			continue
+		}
+		require.NoError(t, err, c.name)
+		src2, ok := src.(*dockerImageSource)
+		require.True(t, ok, c.name)
+		assert.Equal(t, c.expectedDigest, src2.cachedManifestDigest, c.name)
+		manblob, _, err := src.GetManifest(context.Background(), nil)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.body, manblob, c.name)
+		src.Close()
+	}
+}
+
+func TestDockerImageSourceMaxSizes(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+	signatureBlob := []byte("\xA3not really a signature") // Accepted by signature.FromBlob as a simple signing signature.
+	hugeManifestBlob := bytes.Repeat([]byte(" "), 4*1024*1024+1)
+
+	var servedManifest []byte
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/tag":
+			rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			rw.WriteHeader(http.StatusOK)
+			_, _ = rw.Write(servedManifest) // This may fail if the client aborts reading the body.
+		case r.Method == http.MethodGet && r.URL.Path == "/sigs/repo@sha256="+manifestDigest.Hex()+"/signature-1":
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(signatureBlob)
+			assert.NoError(t, err)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/sigs/"):
+			rw.WriteHeader(http.StatusNotFound)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+	require.NoError(t, err)
+	registriesDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(registriesDir, "registry.yaml"),
+		[]byte(fmt.Sprintf("docker:\n  %s:\n    lookaside: %s/sigs\n", registryURL.Host, server.URL)), 0o644)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name             string
+		manifest         []byte
+		maxManifestSize  int
+		maxSignatureSize int
+		success          bool
+	}{
+		{"defaults", manifestBlob, 0, 0, true},
+		{"manifest over the default limit", hugeManifestBlob, 0, 0, false},
+		{"manifest at the limit", manifestBlob, len(manifestBlob), 0, true},
+		{"manifest over the limit", manifestBlob, len(manifestBlob) - 1, 0, false},
+		{"signature at the limit", manifestBlob, 0, len(signatureBlob), true},
+		{"signature over the limit", manifestBlob, 0, len(signatureBlob) - 1, false},
+	} {
+		servedManifest = c.manifest
+		src, err := ref.NewImageSource(context.Background(), &types.SystemContext{
+			RegistriesDirPath:           registriesDir,
+			DockerPerHostCertDirPath:    "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			DockerMaxManifestSize:       c.maxManifestSize,
+			DockerMaxSignatureSize:      c.maxSignatureSize,
+		})
+		if err == nil {
+			var sigs [][]byte
+			sigs, err = src.GetSignatures(context.Background(), nil)
+			if err == nil {
+				assert.Equal(t, [][]byte{signatureBlob}, sigs, c.name)
+			}
+			src.Close()
+		}
+		if c.success {
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.ErrorContains(t, err, "exceeded maximum allowed size", c.name)
+		}
+	}
+}
+
+// gzipBytes returns data compressed using gzip.
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	_, err := gzw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	return buf.Bytes()
+}
+
+func TestDockerImageSourceGzipContentEncoding(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+	// A compressed layer, which a proxy has labeled with Content-Encoding: gzip
+	layerBlob := gzipBytes(t, []byte("layer contents"))
+	layerDigest := digest.FromBytes(layerBlob)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/tag":
+			rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			rw.Header().Set("Content-Encoding", "gzip")
+			rw.Header().Set("Docker-Content-Digest", manifestDigest.String())
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(gzipBytes(t, manifestBlob))
+			assert.NoError(t, err)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/unsupported":
+			rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			rw.Header().Set("Content-Encoding", "br")
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(manifestBlob)
+			assert.NoError(t, err)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/tags/list":
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("Content-Encoding", "gzip")
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(gzipBytes(t, []byte(`{"name":"repo","tags":["tag","unsupported"]}`)))
+			assert.NoError(t, err)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/blobs/"+layerDigest.String():
+			assert.Empty(t, r.Header.Get("Accept-Encoding"))
+			rw.Header().Set("Content-Encoding", "gzip")
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(layerBlob)
+			assert.NoError(t, err)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+
+	ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), sys)
+	require.NoError(t, err)
+	defer src.Close()
+	manblob, mimeType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBlob, manblob)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, mimeType)
+
+	// Blobs are returned exactly as sent, regardless of Content-Encoding.
+	reader, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: layerDigest, Size: -1}, none.NoCache)
+	require.NoError(t, err)
+	blob, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, layerBlob, blob)
+
+	tags, err := GetRepositoryTags(context.Background(), sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tag", "unsupported"}, tags)
+
+	ref, err = ParseReference("//" + registryURL.Host + "/repo:unsupported")
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), sys)
+	assert.ErrorContains(t, err, "unsupported Content-Encoding")
+}
+
 func TestSimplifyContentType(t *testing.T) {
 	for _, c := range []struct{ input, expected string }{
 		{"", ""},
@@ -210,3 +449,492 @@ func TestParseMediaType(t *testing.T) {
 	_, _, err = parseMediaType("multipart/byteranges; boundary=@")
 	require.Error(t, err)
 }
+
+func TestDockerImageSourceRejectSchema1(t *testing.T) {
+	manifests := map[string]struct {
+		mimeType string
+		blob     []byte
+	}{
+		"schema1": {manifest.DockerV2Schema1SignedMediaType, []byte(`{"schemaVersion":1,"name":"repo","tag":"schema1"}`)},
+		// A schema1 manifest is detected even if the registry reports an unexpected MIME type.
+		"schema1-text": {"text/plain", []byte(`{"schemaVersion":1,"name":"repo","tag":"schema1-text"}`)},
+		"schema2":      {manifest.DockerV2Schema2MediaType, []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)},
+		"oci":          {imgspecv1.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)},
+	}
+	for _, reject := range []bool{false, true} {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+				rw.WriteHeader(http.StatusOK)
+			case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/repo/manifests/"):
+				accept := strings.Join(r.Header.Values("Accept"), ",")
+				assert.Equal(t, !reject, strings.Contains(accept, manifest.DockerV2Schema1SignedMediaType), accept)
+				m, ok := manifests[strings.TrimPrefix(r.URL.Path, "/v2/repo/manifests/")]
+				if !assert.True(t, ok, r.URL.Path) {
+					rw.WriteHeader(http.StatusNotFound)
+					return
+				}
+				rw.Header().Set("Content-Type", m.mimeType)
+				rw.WriteHeader(http.StatusOK)
+				_, err := rw.Write(m.blob)
+				assert.NoError(t, err)
+			default:
+				assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+				rw.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		defer server.Close()
+		registryURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		for tag := range manifests {
+			ref, err := ParseReference("//" + registryURL.Host + "/repo:" + tag)
+			require.NoError(t, err)
+			src, err := ref.NewImageSource(context.Background(), &types.SystemContext{
+				RegistriesDirPath:            "/this/does/not/exist",
+				DockerPerHostCertDirPath:     "/this/does/not/exist",
+				DockerInsecureSkipTLSVerify:  types.OptionalBoolTrue,
+				DockerRejectSchema1Manifests: reject,
+			})
+			if err == nil {
+				_, _, err = src.GetManifest(context.Background(), nil)
+				src.Close()
+			}
+			if reject && strings.HasPrefix(tag, "schema1") {
+				assert.ErrorContains(t, err, "schema1 manifests are disabled", tag)
+			} else {
+				assert.NoError(t, err, tag)
+			}
+		}
+	}
+}
+
+func TestDockerImageSourcePullThroughCache(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+
+	var mutex sync.Mutex
+	cacheRequests := []string{}
+	cache := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		mutex.Lock()
+		cacheRequests = append(cacheRequests, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/repo/manifests/tag"):
+			rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(manifestBlob)
+			assert.NoError(t, err)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/repo/blobs/"+blobDigest.String()):
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(blob)
+			assert.NoError(t, err)
+		default:
+			assert.Failf(t, "Unexpected request to the cache", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer cache.Close()
+	cacheURL, err := url.Parse(cache.URL)
+	require.NoError(t, err)
+
+	uploadPathRegex := regexp.MustCompile("^/v2/repo/blobs/uploads/")
+	originRequests := []string{}
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		mutex.Lock()
+		originRequests = append(originRequests, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == "/v2/repo/blobs/"+blobDigest.String():
+			rw.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && uploadPathRegex.MatchString(r.URL.Path):
+			rw.Header().Set("Location", r.URL.Path+"upload-id")
+			rw.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPatch && uploadPathRegex.MatchString(r.URL.Path):
+			_, err := io.Copy(io.Discard, r.Body)
+			assert.NoError(t, err)
+			rw.Header().Set("Location", r.URL.Path)
+			rw.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut && uploadPathRegex.MatchString(r.URL.Path):
+			rw.WriteHeader(http.StatusCreated)
+		default:
+			assert.Failf(t, "Unexpected request to the origin", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer origin.Close()
+	originURL, err := url.Parse(origin.URL)
+	require.NoError(t, err)
+
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err = os.WriteFile(registriesConf, []byte(fmt.Sprintf(`[[registry]]
+location = "%s"
+
+[registry.pull-through-cache]
+location = "%s/from-config"
+`, originURL.Host, cacheURL.Host)), 0600)
+	require.NoError(t, err)
+	emptyRegistriesConf := filepath.Join(t.TempDir(), "empty.conf")
+	err = os.WriteFile(emptyRegistriesConf, []byte{}, 0600)
+	require.NoError(t, err)
+
+	ref, err := ParseReference("//" + originURL.Host + "/repo:tag")
+	require.NoError(t, err)
+	for _, c := range []struct {
+		name, cachePath string
+		sys             types.SystemContext
+	}{
+		{"registries.conf", "/v2/from-config/repo", types.SystemContext{SystemRegistriesConfPath: registriesConf}},
+		{"SystemContext", "/v2/from-sys/repo", types.SystemContext{SystemRegistriesConfPath: emptyRegistriesConf, DockerPullThroughCache: cacheURL.Host + "/from-sys"}},
+	} {
+		cacheRequests, originRequests = []string{}, []string{}
+		sys := c.sys
+		sys.SystemRegistriesConfDirPath = "/this/does/not/exist"
+		sys.RegistriesDirPath = "/this/does/not/exist"
+		sys.DockerPerHostCertDirPath = "/this/does/not/exist"
+		sys.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+
+		// Reads hit the cache
+		src, err := ref.NewImageSource(context.Background(), &sys)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, ref.StringWithinTransport(), src.Reference().StringWithinTransport(), c.name)
+		m, _, err := src.GetManifest(context.Background(), nil)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, manifestBlob, m, c.name)
+		stream, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+		require.NoError(t, err, c.name)
+		data, err := io.ReadAll(stream)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, blob, data, c.name)
+		stream.Close()
+		src.Close()
+		assert.Equal(t, []string{
+			"GET " + c.cachePath + "/manifests/tag",
+			"GET " + c.cachePath + "/blobs/" + blobDigest.String(),
+		}, cacheRequests, c.name)
+		assert.Equal(t, []string{}, originRequests, c.name)
+
+		// Writes hit the origin
+		dest, err := ref.NewImageDestination(context.Background(), &sys)
+		require.NoError(t, err, c.name)
+		_, err = dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, none.NoCache, false)
+		require.NoError(t, err, c.name)
+		dest.Close()
+		assert.Equal(t, []string{
+			"GET " + c.cachePath + "/manifests/tag",
+			"GET " + c.cachePath + "/blobs/" + blobDigest.String(),
+		}, cacheRequests, c.name)
+		assert.NotEmpty(t, originRequests, c.name)
+	}
+}
+
+func TestDockerImageSourceBlockedRegistry(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	blocked := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Failf(t, "Unexpected request to a blocked registry", "%v %v", r.Method, r.URL.Path)
+		rw.WriteHeader(http.StatusBadRequest)
+	}))
+	defer blocked.Close()
+	blockedURL, err := url.Parse(blocked.URL)
+	require.NoError(t, err)
+	allowed := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/tag":
+			rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(manifestBlob)
+			assert.NoError(t, err)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer allowed.Close()
+	allowedURL, err := url.Parse(allowed.URL)
+	require.NoError(t, err)
+
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err = os.WriteFile(registriesConf, []byte(fmt.Sprintf(`[[registry]]
+location = "%s"
+blocked = true
+
+[[registry]]
+prefix = "blocked-mirror.example.com"
+location = "%s"
+
+[[registry.mirror]]
+location = "%s"
+
+[[registry]]
+prefix = "blocked-origin.example.com"
+location = "%s"
+blocked = true
+
+[[registry.mirror]]
+location = "%s"
+`, blockedURL.Host, allowedURL.Host, blockedURL.Host, blockedURL.Host, allowedURL.Host)), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    registriesConf,
+		SystemRegistriesConfDirPath: "/this/does/not/exist",
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+
+	// Both pulls and pushes are refused.
+	ref, err := ParseReference("//" + blockedURL.Host + "/repo:tag")
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), sys)
+	assert.ErrorContains(t, err, "is blocked")
+	_, err = ref.NewImageDestination(context.Background(), sys)
+	assert.ErrorContains(t, err, "is blocked")
+
+	// A blocked mirror is skipped.
+	ref, err = ParseReference("//blocked-mirror.example.com/repo:tag")
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), sys)
+	require.NoError(t, err)
+	m, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBlob, m)
+	src.Close()
+
+	// A blocked registry is not accessed through its mirrors.
+	ref, err = ParseReference("//blocked-origin.example.com/repo:tag")
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), sys)
+	assert.ErrorContains(t, err, "is blocked")
+}
+
+func TestDockerImageSourceSigstoreReferrers(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+
+	blobs := map[digest.Digest][]byte{}
+	manifests := map[string][]byte{} // Indexed by tag or digest
+	sigLayer := func(payload string) imgspecv1.Descriptor {
+		blobs[digest.FromString(payload)] = []byte(payload)
+		return imgspecv1.Descriptor{
+			MediaType:   internalsig.SigstoreSignatureMIMEType,
+			Digest:      digest.FromString(payload),
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{internalsig.SigstoreSignatureAnnotationKey: "sig-" + payload},
+		}
+	}
+	putManifest := func(tag string, layers ...imgspecv1.Descriptor) imgspecv1.Descriptor {
+		m, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageConfig,
+			Digest:    digest.FromString("{}"),
+			Size:      2,
+		}, layers).Serialize()
+		require.NoError(t, err)
+		d := digest.FromBytes(m)
+		manifests[d.String()] = m
+		if tag != "" {
+			manifests[tag] = m
+		}
+		return imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: d, Size: int64(len(m))}
+	}
+	layer1, layer2 := sigLayer("payload 1"), sigLayer("payload 2")
+	putManifest(strings.Replace(manifestDigest.String(), ":", "-", 1)+".sig", layer1)
+	sigReferrer := putManifest("", layer1, layer2)
+	sigReferrer.ArtifactType = sigstoreSignatureArtifactType
+	sbomReferrer := putManifest("", sigLayer("not a signature"))
+	sbomReferrer.ArtifactType = "application/spdx+json"
+	referrersIndex, err := json.Marshal(imgspecv1.Index{
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{sigReferrer, sbomReferrer},
+	})
+	require.NoError(t, err)
+
+	registriesDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(registriesDir, "default.yaml"), []byte("default-docker:\n  use-sigstore-attachments: true\n"), 0600)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name              string
+		referrersStatus   int // The status of referrers API responses; http.StatusOK if it is supported
+		attachmentTag     bool
+		expectedPayloads  []string
+		expectedRequested string // A request which must have been made
+	}{
+		{"attachment tag and referrers API", http.StatusOK, true, []string{"payload 1", "payload 2"}, "/v2/repo/referrers/" + manifestDigest.String()},
+		{"referrers API only", http.StatusOK, false, []string{"payload 1", "payload 2"}, "/v2/repo/referrers/" + manifestDigest.String()},
+		{"referrers tag schema", http.StatusNotFound, true, []string{"payload 1", "payload 2"}, "/v2/repo/manifests/" + referrersTag(manifestDigest)},
+		// Registries which don't implement the referrers API may respond with other errors.
+		{"405 Method Not Allowed", http.StatusMethodNotAllowed, true, []string{"payload 1", "payload 2"}, "/v2/repo/manifests/" + referrersTag(manifestDigest)},
+		{"400 Bad Request", http.StatusBadRequest, true, []string{"payload 1", "payload 2"}, "/v2/repo/manifests/" + referrersTag(manifestDigest)},
+		{"501 Not Implemented", http.StatusNotImplemented, false, []string{"payload 1", "payload 2"}, "/v2/repo/manifests/" + referrersTag(manifestDigest)},
+	} {
+		referrersAPI := c.referrersStatus == http.StatusOK
+		var mutex sync.Mutex
+		requested := map[string]bool{}
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requested[r.URL.Path] = true
+			mutex.Unlock()
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+				rw.WriteHeader(http.StatusOK)
+			case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/tag":
+				rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+				rw.WriteHeader(http.StatusOK)
+				_, err := rw.Write(manifestBlob)
+				assert.NoError(t, err)
+			case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/referrers/"+manifestDigest.String():
+				if !referrersAPI {
+					rw.WriteHeader(c.referrersStatus)
+					return
+				}
+				assert.Equal(t, sigstoreSignatureArtifactType, r.URL.Query().Get("artifactType"))
+				rw.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+				rw.WriteHeader(http.StatusOK)
+				_, err := rw.Write(referrersIndex)
+				assert.NoError(t, err)
+			case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/"+referrersTag(manifestDigest) && !referrersAPI:
+				rw.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+				rw.WriteHeader(http.StatusOK)
+				_, err := rw.Write(referrersIndex)
+				assert.NoError(t, err)
+			case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/repo/manifests/"):
+				m, ok := manifests[strings.TrimPrefix(r.URL.Path, "/v2/repo/manifests/")]
+				if !ok || (!c.attachmentTag && strings.HasSuffix(r.URL.Path, ".sig")) {
+					rw.Header().Set("Content-Type", "application/json")
+					rw.WriteHeader(http.StatusNotFound)
+					_, err := rw.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+					assert.NoError(t, err)
+					return
+				}
+				rw.Header().Set("Content-Type", imgspecv1.MediaTypeImageManifest)
+				rw.WriteHeader(http.StatusOK)
+				_, err := rw.Write(m)
+				assert.NoError(t, err)
+			case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/repo/blobs/"):
+				blob, ok := blobs[digest.Digest(strings.TrimPrefix(r.URL.Path, "/v2/repo/blobs/"))]
+				if !ok {
+					rw.WriteHeader(http.StatusNotFound)
+					return
+				}
+				rw.WriteHeader(http.StatusOK)
+				_, err := rw.Write(blob)
+				assert.NoError(t, err)
+			default:
+				assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+				rw.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		registryURL, err := url.Parse(server.URL)
+		require.NoError(t, err, c.name)
+		ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+		require.NoError(t, err, c.name)
+		src, err := ref.NewImageSource(context.Background(), &types.SystemContext{
+			RegistriesDirPath:           registriesDir,
+			DockerPerHostCertDirPath:    "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		})
+		require.NoError(t, err, c.name)
+		src2, ok := src.(*dockerImageSource)
+		require.True(t, ok, c.name)
+
+		sigs, err := src2.getSignaturesFromSigstoreAttachments(context.Background(), nil)
+		require.NoError(t, err, c.name)
+		payloads := []string{}
+		for _, sig := range sigs {
+			sigstoreSig, ok := sig.(internalsig.Sigstore)
+			require.True(t, ok, c.name)
+			assert.Equal(t, internalsig.SigstoreSignatureMIMEType, sigstoreSig.UntrustedMIMEType(), c.name)
+			assert.Equal(t, "sig-"+string(sigstoreSig.UntrustedPayload()), sigstoreSig.UntrustedAnnotations()[internalsig.SigstoreSignatureAnnotationKey], c.name)
+			payloads = append(payloads, string(sigstoreSig.UntrustedPayload()))
+		}
+		assert.Equal(t, c.expectedPayloads, payloads, c.name)
+		assert.True(t, requested[c.expectedRequested], c.name)
+		src.Close()
+		server.Close()
+	}
+}
+
+func TestDockerImageSourceLookasideStaging(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/tag":
+			rw.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(manifestBlob)
+			assert.NoError(t, err)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// writeSignatures writes simple signing signatures with contents to the lookaside storage at topLevel.
+	writeSignatures := func(topLevel string, contents ...string) {
+		dir := filepath.Join(topLevel, "repo@"+manifestDigest.Algorithm().String()+"="+manifestDigest.Encoded())
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		for i, c := range contents {
+			err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("signature-%d", i+1)), append([]byte{0xA3}, []byte(c)...), 0o644)
+			require.NoError(t, err)
+		}
+	}
+	lookaside, staging := t.TempDir(), t.TempDir()
+	writeSignatures(lookaside, "A", "C")
+	writeSignatures(staging, "B", "A")
+
+	for _, c := range []struct {
+		readStaging string
+		expected    []string
+	}{
+		{"", []string{"A", "C"}},
+		{"after", []string{"A", "C", "B"}},
+		{"before", []string{"B", "A", "C"}},
+	} {
+		registriesDir := t.TempDir()
+		config := fmt.Sprintf("docker:\n  %s/repo:\n    lookaside: file://%s\n    lookaside-staging: file://%s\n", registryURL.Host, lookaside, staging)
+		if c.readStaging != "" {
+			config += "    read-lookaside-staging: " + c.readStaging + "\n"
+		}
+		err = os.WriteFile(filepath.Join(registriesDir, "default.yaml"), []byte(config), 0600)
+		require.NoError(t, err)
+
+		ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+		require.NoError(t, err)
+		src, err := ref.NewImageSource(context.Background(), &types.SystemContext{
+			RegistriesDirPath:           registriesDir,
+			DockerPerHostCertDirPath:    "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		})
+		require.NoError(t, err, c.readStaging)
+		src2, ok := src.(*dockerImageSource)
+		require.True(t, ok, c.readStaging)
+		sigs, err := src2.GetSignaturesWithFormat(context.Background(), nil)
+		require.NoError(t, err, c.readStaging)
+		contents := []string{}
+		for _, sig := range sigs {
+			simpleSig, ok := sig.(internalsig.SimpleSigning)
+			require.True(t, ok, c.readStaging)
+			contents = append(contents, string(simpleSig.UntrustedSignature()[1:]))
+		}
+		assert.Equal(t, c.expected, contents, c.readStaging)
+		src.Close()
+	}
+}
@@ -0,0 +1,276 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetImageConfig(t *testing.T) {
+	type testManifest struct {
+		mimeType string
+		blob     []byte
+	}
+	manifests := map[string]testManifest{}
+	blobs := map[digest.Digest][]byte{}
+	layerDigest := digest.FromString("layer")
+	addImage := func(arch string) (configDigest digest.Digest, configSize int64) {
+		config, err := json.Marshal(imgspecv1.Image{
+			Architecture: arch,
+			OS:           "linux",
+			RootFS:       imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{layerDigest}},
+		})
+		require.NoError(t, err)
+		configDigest = digest.FromBytes(config)
+		blobs[configDigest] = config
+		return configDigest, int64(len(config))
+	}
+
+	configDigest, configSize := addImage("amd64")
+	schema2, err := manifest.Schema2FromComponents(manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2Schema2ConfigMediaType,
+		Size:      configSize,
+		Digest:    configDigest,
+	}, []manifest.Schema2Descriptor{{
+		MediaType: manifest.DockerV2Schema2LayerMediaType,
+		Size:      5,
+		Digest:    layerDigest,
+	}}).Serialize()
+	require.NoError(t, err)
+	manifests["schema2"] = testManifest{manifest.DockerV2Schema2MediaType, schema2}
+
+	configDigest, configSize = addImage("ppc64le")
+	oci, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Size:      configSize,
+		Digest:    configDigest,
+	}, []imgspecv1.Descriptor{{
+		MediaType: imgspecv1.MediaTypeImageLayerGzip,
+		Size:      5,
+		Digest:    layerDigest,
+	}}).Serialize()
+	require.NoError(t, err)
+	manifests["oci"] = testManifest{imgspecv1.MediaTypeImageManifest, oci}
+
+	s1, err := manifest.Schema1FromComponents(nil, []manifest.Schema1FSLayers{{BlobSum: layerDigest}}, []manifest.Schema1History{
+		{V1Compatibility: `{"id":"d3da27a4dbcbd2ab5e649bd2bc1b2f1b6fa0d2c7f2c0dfd9c3f1b4a9f2e3c4d5","architecture":"s390x","os":"linux","created":"2020-01-01T00:00:00Z"}`},
+	}, "s390x")
+	require.NoError(t, err)
+	schema1, err := s1.Serialize()
+	require.NoError(t, err)
+	manifests["schema1"] = testManifest{manifest.DockerV2Schema1MediaType, schema1}
+
+	instanceDigest, instanceSize := digest.FromBytes(schema2), int64(len(schema2))
+	manifests[instanceDigest.String()] = manifests["schema2"]
+	configDigest, configSize = addImage("arm64")
+	arm64, err := manifest.Schema2FromComponents(manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2Schema2ConfigMediaType,
+		Size:      configSize,
+		Digest:    configDigest,
+	}, []manifest.Schema2Descriptor{{
+		MediaType: manifest.DockerV2Schema2LayerMediaType,
+		Size:      5,
+		Digest:    layerDigest,
+	}}).Serialize()
+	require.NoError(t, err)
+	arm64Digest := digest.FromBytes(arm64)
+	manifests[arm64Digest.String()] = testManifest{manifest.DockerV2Schema2MediaType, arm64}
+	list, err := manifest.Schema2ListFromComponents([]manifest.Schema2ManifestDescriptor{
+		{
+			Schema2Descriptor: manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Size: instanceSize, Digest: instanceDigest},
+			Platform:          manifest.Schema2PlatformSpec{Architecture: "amd64", OS: "linux"},
+		},
+		{
+			Schema2Descriptor: manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Size: int64(len(arm64)), Digest: arm64Digest},
+			Platform:          manifest.Schema2PlatformSpec{Architecture: "arm64", OS: "linux"},
+		},
+	}).Serialize()
+	require.NoError(t, err)
+	manifests["list"] = testManifest{manifest.DockerV2ListMediaType, list}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/repo/manifests/"):
+			m, ok := manifests[strings.TrimPrefix(r.URL.Path, "/v2/repo/manifests/")]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			rw.Header().Set("Content-Type", m.mimeType)
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(m.blob)
+			assert.NoError(t, err)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/repo/blobs/"):
+			blob, ok := blobs[digest.Digest(strings.TrimPrefix(r.URL.Path, "/v2/repo/blobs/"))]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			rw.WriteHeader(http.StatusOK)
+			_, err := rw.Write(blob)
+			assert.NoError(t, err)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		tag, arch, expectedArch string
+	}{
+		{"schema2", "", "amd64"},
+		{"oci", "", "ppc64le"},
+		{"schema1", "", "s390x"},
+		{"list", "amd64", "amd64"},
+		{"list", "arm64", "arm64"},
+	} {
+		ref, err := ParseReference("//" + registryURL.Host + "/repo:" + c.tag)
+		require.NoError(t, err, c.tag)
+		config, err := GetImageConfig(context.Background(), &types.SystemContext{
+			RegistriesDirPath:           "/this/does/not/exist",
+			DockerPerHostCertDirPath:    "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			ArchitectureChoice:          c.arch,
+			OSChoice:                    "linux",
+		}, ref)
+		require.NoError(t, err, c.tag)
+		assert.Equal(t, c.expectedArch, config.Architecture, c.tag)
+		assert.Equal(t, "linux", config.OS, c.tag)
+		if c.tag != "schema1" { // The DiffIDs of schema1 images are not known without pulling the layers.
+			assert.Equal(t, []digest.Digest{layerDigest}, config.RootFS.DiffIDs, c.tag)
+		}
+	}
+
+	// A missing image is reported.
+	ref, err := ParseReference("//" + registryURL.Host + "/repo:missing")
+	require.NoError(t, err)
+	_, err = GetImageConfig(context.Background(), &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}, ref)
+	assert.Error(t, err)
+}
+
+func TestGetManifestListPlatforms(t *testing.T) {
+	type testManifest struct {
+		mimeType string
+		blob     []byte
+	}
+	schema2, err := manifest.Schema2FromComponents(manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2Schema2ConfigMediaType,
+		Size:      2,
+		Digest:    digest.FromString("{}"),
+	}, []manifest.Schema2Descriptor{}).Serialize()
+	require.NoError(t, err)
+	schema2Digest := digest.FromBytes(schema2)
+	schema2List, err := manifest.Schema2ListFromComponents([]manifest.Schema2ManifestDescriptor{
+		{
+			Schema2Descriptor: manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Size: int64(len(schema2)), Digest: schema2Digest},
+			Platform:          manifest.Schema2PlatformSpec{Architecture: "amd64", OS: "linux"},
+		},
+		{
+			Schema2Descriptor: manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Size: int64(len(schema2)), Digest: schema2Digest},
+			Platform:          manifest.Schema2PlatformSpec{Architecture: "arm", OS: "linux", Variant: "v7"},
+		},
+	}).Serialize()
+	require.NoError(t, err)
+	ociIndex, err := manifest.OCI1IndexFromComponents([]imgspecv1.Descriptor{
+		{MediaType: imgspecv1.MediaTypeImageManifest, Size: int64(len(schema2)), Digest: schema2Digest, Platform: &imgspecv1.Platform{Architecture: "s390x", OS: "linux"}},
+		{MediaType: imgspecv1.MediaTypeImageManifest, Size: int64(len(schema2)), Digest: schema2Digest},
+	}, nil).Serialize()
+	require.NoError(t, err)
+	manifests := map[string]testManifest{
+		"schema2":    {manifest.DockerV2Schema2MediaType, schema2},
+		"list":       {manifest.DockerV2ListMediaType, schema2List},
+		"index":      {imgspecv1.MediaTypeImageIndex, ociIndex},
+		"untyped":    {"text/plain", schema2List},
+		"untyped-s2": {"text/plain", schema2},
+	}
+
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case (r.Method == http.MethodHead || r.Method == http.MethodGet) && strings.HasPrefix(r.URL.Path, "/v2/repo/manifests/"):
+			requests[r.Method]++
+			m, ok := manifests[strings.TrimPrefix(r.URL.Path, "/v2/repo/manifests/")]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			rw.Header().Set("Content-Type", m.mimeType)
+			rw.WriteHeader(http.StatusOK)
+			if r.Method == http.MethodGet {
+				_, err := rw.Write(m.blob)
+				assert.NoError(t, err)
+			}
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+	}
+
+	for _, c := range []struct {
+		tag               string
+		expectedList      bool
+		expectedPlatforms []imgspecv1.Platform
+		expectedGETs      int
+	}{
+		{"schema2", false, nil, 0},
+		{"list", true, []imgspecv1.Platform{
+			{Architecture: "amd64", OS: "linux"},
+			{Architecture: "arm", OS: "linux", Variant: "v7"},
+		}, 1},
+		{"index", true, []imgspecv1.Platform{
+			{Architecture: "s390x", OS: "linux"},
+			{},
+		}, 1},
+		{"untyped", true, []imgspecv1.Platform{
+			{Architecture: "amd64", OS: "linux"},
+			{Architecture: "arm", OS: "linux", Variant: "v7"},
+		}, 1},
+		{"untyped-s2", false, nil, 1},
+	} {
+		requests = map[string]int{}
+		ref, err := ParseReference("//" + registryURL.Host + "/repo:" + c.tag)
+		require.NoError(t, err, c.tag)
+		isList, platforms, err := GetManifestListPlatforms(context.Background(), sys, ref)
+		require.NoError(t, err, c.tag)
+		assert.Equal(t, c.expectedList, isList, c.tag)
+		assert.Equal(t, c.expectedPlatforms, platforms, c.tag)
+		assert.Equal(t, 1, requests[http.MethodHead], c.tag)
+		assert.Equal(t, c.expectedGETs, requests[http.MethodGet], c.tag)
+	}
+
+	// A missing image is reported.
+	ref, err := ParseReference("//" + registryURL.Host + "/repo:missing")
+	require.NoError(t, err)
+	_, _, err = GetManifestListPlatforms(context.Background(), sys, ref)
+	assert.Error(t, err)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/containers/image/v5/docker/policyconfiguration"
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/types"
 )
@@ -25,6 +26,17 @@ func (t dockerTransport) Name() string {
 	return "docker"
 }
 
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "docker://docker-reference"
+	ReferenceSyntaxExample = "docker://quay.io/podman/stable:latest"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t dockerTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
 func (t dockerTransport) ParseReference(reference string) (types.ImageReference, error) {
 	return ParseReference(reference)
@@ -64,6 +76,23 @@ func NewReference(ref reference.Named) (types.ImageReference, error) {
 	return newReference(ref)
 }
 
+// NormalizeReference returns the canonical, fully-qualified form of refString, a Docker reference which should not start
+// with the "//" prefix used by ParseReference; equivalent references, e.g. "nginx", "nginx:latest" and
+// "docker.io/library/nginx:latest", all return the same value, so it can be used e.g. to deduplicate references.
+// As in ParseReference, a reference without a tag nor a digest implies the "latest" tag, and references with
+// both a tag and a digest are rejected.
+func NormalizeReference(refString string) (string, error) {
+	ref, err := reference.ParseNormalizedNamed(refString)
+	if err != nil {
+		return "", err
+	}
+	dockerRef, err := newReference(reference.TagNameOnly(ref))
+	if err != nil {
+		return "", err
+	}
+	return dockerRef.ref.String(), nil
+}
+
 // newReference returns a dockerReference for a named reference.
 func newReference(ref reference.Named) (dockerReference, error) {
 	if reference.IsNameOnly(ref) {
@@ -147,7 +176,18 @@ func (ref dockerReference) NewImageSource(ctx context.Context, sys *types.System
 // NewImageDestination returns a types.ImageDestination for this reference.
 // The caller must call .Close() on the returned ImageDestination.
 func (ref dockerReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
-	return newImageDestination(sys, ref)
+	return newImageDestination(ctx, sys, ref)
+}
+
+// NewBlobProbe returns a private.BlobProbe for this reference, which checks for blobs in the repository without modifying it.
+// The caller must call .Close() on the returned BlobProbe.
+func (ref dockerReference) NewBlobProbe(ctx context.Context, sys *types.SystemContext) (private.BlobProbe, error) {
+	return newBlobProbe(ctx, sys, ref)
+}
+
+// ImageExists returns true if the registry contains a manifest for the reference, and false if it does not.
+func (ref dockerReference) ImageExists(ctx context.Context, sys *types.SystemContext) (bool, error) {
+	return imageExists(ctx, sys, ref)
 }
 
 // DeleteImage deletes the named image from the registry, if supported.
@@ -67,6 +67,39 @@ func testParseReference(t *testing.T, fn func(string) (types.ImageReference, err
 	}
 }
 
+func TestNormalizeReference(t *testing.T) {
+	for _, c := range []struct {
+		expected string
+		inputs   []string
+	}{
+		{"docker.io/library/nginx:latest", []string{ // Default tag, domain and namespace
+			"nginx", "nginx:latest", "library/nginx", "library/nginx:latest", "docker.io/nginx",
+			"docker.io/library/nginx", "docker.io/library/nginx:latest", "index.docker.io/library/nginx:latest",
+		}},
+		{"docker.io/library/nginx:1.23", []string{"nginx:1.23", "docker.io/library/nginx:1.23"}},                       // Explicit tag
+		{"docker.io/library/nginx" + sha256digest, []string{"nginx" + sha256digest, "docker.io/nginx" + sha256digest}}, // Explicit digest
+		{"docker.io/user/nginx:latest", []string{"user/nginx", "docker.io/user/nginx:latest"}},                         // Not in library/
+		{"example.com/nginx:latest", []string{"example.com/nginx", "example.com/nginx:latest"}},                        // Other registries have no implied namespace
+		{"localhost:5000/ns/nginx:latest", []string{"localhost:5000/ns/nginx"}},                                        // Registry with a port
+	} {
+		for _, input := range c.inputs {
+			res, err := NormalizeReference(input)
+			require.NoError(t, err, input)
+			assert.Equal(t, c.expected, res, input)
+		}
+	}
+
+	for _, input := range []string{
+		"nginx:latest" + sha256digest, // Both tag and digest
+		"UPPERCASEISINVALID",          // Invalid input
+		"//nginx",                     // ParseReference syntax
+		"",
+	} {
+		_, err := NormalizeReference(input)
+		assert.Error(t, err, input)
+	}
+}
+
 // A common list of reference formats to test for the various ImageReference methods.
 var validReferenceTestCases = []struct{ input, dockerRef, stringWithinTransport string }{
 	{"busybox:notlatest", "docker.io/library/busybox:notlatest", "//busybox:notlatest"},                // Explicit tag
@@ -27,16 +27,16 @@ func (e ErrUnauthorizedForCredentials) Error() string {
 }
 
 // httpResponseToError translates the https.Response into an error, possibly prefixing it with the supplied context. It returns
-// nil if the response is not considered an error.
+// nil if the response is not considered an error. Details which are not a part of the error are logged using logger.
 // NOTE: Almost all callers in this package should use registryHTTPResponseToError instead.
-func httpResponseToError(res *http.Response, context string) error {
+func httpResponseToError(logger logrus.FieldLogger, res *http.Response, context string) error {
 	switch res.StatusCode {
 	case http.StatusOK:
 		return nil
 	case http.StatusTooManyRequests:
 		return ErrTooManyRequests
 	case http.StatusUnauthorized:
-		err := registryHTTPResponseToError(res)
+		err := registryHTTPResponseToError(logger, res)
 		return ErrUnauthorizedForCredentials{Err: err}
 	default:
 		if context != "" {
@@ -47,8 +47,8 @@ func httpResponseToError(res *http.Response, context string) error {
 }
 
 // registryHTTPResponseToError creates a Go error from an HTTP error response of a docker/distribution
-// registry
-func registryHTTPResponseToError(res *http.Response) error {
+// registry. Details which are not a part of the error are logged using logger.
+func registryHTTPResponseToError(logger logrus.FieldLogger, res *http.Response) error {
 	err := handleErrorResponse(res)
 	// len(errs) == 0 should never be returned by handleErrorResponse; if it does, we don't modify it and let the caller report it as is.
 	if errs, ok := err.(errcode.Errors); ok && len(errs) > 0 {
@@ -69,9 +69,9 @@ func registryHTTPResponseToError(res *http.Response) error {
 		// Also, docker/docker similarly only logs the other errors and returns the
 		// first one.
 		if len(errs) > 1 {
-			logrus.Debugf("Discarding non-primary errors:")
+			logger.Debugf("Discarding non-primary errors:")
 			for _, err := range errs[1:] {
-				logrus.Debugf("  %s", err.Error())
+				logger.Debugf("  %s", err.Error())
 			}
 		}
 		err = errs[0]
@@ -9,6 +9,7 @@ import (
 
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -177,7 +178,7 @@ func TestRegistryHTTPResponseToError(t *testing.T) {
 		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(c.response))), nil)
 		require.NoError(t, err, c.name)
 
-		err = registryHTTPResponseToError(res)
+		err = registryHTTPResponseToError(logrus.StandardLogger(), res)
 		assert.Equal(t, c.errorString, err.Error(), c.name)
 		if c.errorType != nil {
 			assert.IsType(t, c.errorType, err, c.name)
@@ -0,0 +1,229 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryBackoffInitialDelay is the delay before the first retry of a stalled request or an interrupted blob read;
+// it doubles with each consecutive retry without progress, up to backoffMaxDelay.
+// This is a variable only to allow tests to shorten it.
+var retryBackoffInitialDelay = 1 * time.Second
+
+// retryBackoffDelay returns the delay before the retry-th consecutive retry (starting at 1) of a request or read.
+func retryBackoffDelay(retry int) time.Duration {
+	delay := retryBackoffInitialDelay
+	for i := 1; i < retry && delay < backoffMaxDelay; i++ {
+		delay *= 2 // exponential back off
+	}
+	if delay > backoffMaxDelay {
+		delay = backoffMaxDelay
+	}
+	return delay
+}
+
+// sleepBeforeRetry waits before the retry-th consecutive retry (starting at 1) of a request or read, or until ctx is done.
+func sleepBeforeRetry(ctx context.Context, retry int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(retryBackoffDelay(retry)):
+		return nil
+	}
+}
+
+// idleTimeoutError is returned when no data was received for a request for the duration of types.SystemContext.DockerRequestIdleTimeout.
+type idleTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e idleTimeoutError) Error() string {
+	return fmt.Sprintf("no data received for %s", e.timeout)
+}
+
+// isIdleTimeoutError returns true if err was caused by an idle timeout.
+func isIdleTimeoutError(err error) bool {
+	var e idleTimeoutError
+	return errors.As(err, &e)
+}
+
+// idleWatchdog cancels a request if it is running, and not stopped, for longer than a timeout.
+type idleWatchdog struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+
+	mutex   sync.Mutex
+	expired bool
+}
+
+// newIdleWatchdog returns an idleWatchdog for a request with cancel, which is already running.
+func newIdleWatchdog(timeout time.Duration, cancel context.CancelFunc) *idleWatchdog {
+	w := &idleWatchdog{
+		timeout: timeout,
+		cancel:  cancel,
+	}
+	w.timer = time.AfterFunc(timeout, w.expire)
+	return w
+}
+
+// expire is called by w.timer.
+func (w *idleWatchdog) expire() {
+	w.mutex.Lock()
+	w.expired = true
+	w.mutex.Unlock()
+	w.cancel()
+}
+
+// start restarts the timeout, unless it has already expired.
+func (w *idleWatchdog) start() {
+	w.timer.Reset(w.timeout)
+}
+
+// stop stops the timeout, e.g. while no data is being waited for.
+func (w *idleWatchdog) stop() {
+	w.timer.Stop()
+}
+
+// wrapError returns an idleTimeoutError wrapping err if the timeout has expired, or err unmodified otherwise.
+func (w *idleWatchdog) wrapError(err error) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.expired {
+		return err
+	}
+	return fmt.Errorf("%w: %v", idleTimeoutError{timeout: w.timeout}, err)
+}
+
+// idleTimeoutBody is a response body for a request using an idleWatchdog, which applies the timeout to each Read.
+type idleTimeoutBody struct {
+	body     io.ReadCloser
+	watchdog *idleWatchdog
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.watchdog.start()
+	n, err := b.body.Read(p)
+	b.watchdog.stop()
+	if err != nil && err != io.EOF {
+		err = b.watchdog.wrapError(err)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.watchdog.stop()
+	err := b.body.Close()
+	b.watchdog.cancel()
+	return err
+}
+
+// idleTimeoutUploadBody is a request body for a request using an idleWatchdog, which restarts the timeout whenever data is sent.
+type idleTimeoutUploadBody struct {
+	body     io.ReadCloser
+	watchdog *idleWatchdog
+}
+
+func (b *idleTimeoutUploadBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.watchdog.start()
+	}
+	return n, err
+}
+
+func (b *idleTimeoutUploadBody) Close() error {
+	return b.body.Close()
+}
+
+// supportsByteRanges returns true if res has an Accept-Ranges header indicating support for range requests in bytes.
+func supportsByteRanges(res *http.Response) bool {
+	for _, h := range res.Header.Values("Accept-Ranges") {
+		for _, unit := range strings.Split(h, ",") {
+			if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resumingBlobReader reads a blob from a response body, and if reading it stalls for longer than the idle timeout,
+// or fails otherwise (e.g. because the connection was interrupted), continues reading the rest of the blob using a range request.
+// It should only be used if the registry has indicated support for range requests, see supportsByteRanges.
+type resumingBlobReader struct {
+	c       *dockerClient
+	ctx     context.Context
+	path    string
+	body    io.ReadCloser
+	offset  int64 // The number of bytes of the blob read so far
+	retries int   // The number of consecutive retries without progress
+}
+
+func (r *resumingBlobReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.retries = 0
+		}
+		if err == nil || err == io.EOF || r.ctx.Err() != nil || r.retries >= backoffNumIterations {
+			return n, err
+		}
+		if n > 0 { // Return the data now, and resume on the next Read.
+			return n, nil
+		}
+		r.c.logger.Debugf("Reading %s failed at offset %d, resuming: %v", r.path, r.offset, err)
+		// The range request may fail as well, e.g. while the connection is still down; retry it, with the same bound.
+		for {
+			r.retries++
+			if sleepErr := sleepBeforeRetry(r.ctx, r.retries); sleepErr != nil {
+				return 0, err
+			}
+			resumeErr := r.resume()
+			if resumeErr == nil {
+				break
+			}
+			r.c.logger.Debugf("Resuming %s failed: %v", r.path, resumeErr)
+			if r.retries >= backoffNumIterations {
+				return 0, err
+			}
+		}
+	}
+}
+
+// resume replaces r.body with a stream of the blob starting at r.offset.
+func (r *resumingBlobReader) resume() error {
+	headers := map[string][]string{
+		"Range": {fmt.Sprintf("bytes=%d-", r.offset)},
+	}
+	res, err := r.c.makeRequest(r.ctx, http.MethodGet, r.path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return fmt.Errorf("range request returned status %s", res.Status)
+	}
+	// Content-Range: bytes $start-$end/$size
+	contentRange := res.Header.Get("Content-Range")
+	expectedPrefix := "bytes " + strconv.FormatInt(r.offset, 10) + "-"
+	if !strings.HasPrefix(contentRange, expectedPrefix) {
+		res.Body.Close()
+		return fmt.Errorf("unexpected Content-Range %q in response to a request for offset %d", contentRange, r.offset)
+	}
+	r.body.Close()
+	r.body = res.Body
+	return nil
+}
+
+func (r *resumingBlobReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,393 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIdleTimeout = 200 * time.Millisecond
+
+// stall blocks until the client gives up on r.
+func stall(r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(10 * time.Second):
+	}
+}
+
+// shortenRetryBackoff shortens retryBackoffInitialDelay for the duration of t.
+func shortenRetryBackoff(t *testing.T) {
+	savedDelay := retryBackoffInitialDelay
+	retryBackoffInitialDelay = 10 * time.Millisecond
+	t.Cleanup(func() { retryBackoffInitialDelay = savedDelay })
+}
+
+var testIdleTimeoutManifest = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+
+// writeTestIdleTimeoutManifest writes testIdleTimeoutManifest as a response.
+func writeTestIdleTimeoutManifest(t *testing.T, rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+	rw.WriteHeader(http.StatusOK)
+	_, err := rw.Write(testIdleTimeoutManifest)
+	assert.NoError(t, err)
+}
+
+// newIdleTimeoutTestSource returns an image source for repo:tag on a server with an idle timeout.
+// The manifest requests are handled by manifestHandler, or return testIdleTimeoutManifest if it is nil; blob requests are handled by blobHandler.
+// The delays before retries are shortened for the duration of the test.
+func newIdleTimeoutTestSource(t *testing.T, manifestHandler, blobHandler http.HandlerFunc) types.ImageSource {
+	shortenRetryBackoff(t)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/repo/manifests/tag":
+			if manifestHandler != nil {
+				manifestHandler(rw, r)
+			} else {
+				writeTestIdleTimeoutManifest(t, rw)
+			}
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/repo/blobs/") && blobHandler != nil:
+			blobHandler(rw, r)
+		default:
+			assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	registryURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerRequestIdleTimeout:    testIdleTimeout,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { src.Close() })
+	return src
+}
+
+func TestIdleTimeoutRetriesRequests(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	src := newIdleTimeoutTestSource(t, func(rw http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		attempt := attempts
+		mutex.Unlock()
+		if attempt == 1 {
+			stall(r)
+			return
+		}
+		writeTestIdleTimeoutManifest(t, rw)
+	}, nil)
+
+	m, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, testIdleTimeoutManifest, m)
+	assert.Equal(t, 2, attempts)
+}
+
+// writeBlobRange writes blob starting at offset, as a response to a request with a Range header if partial, and then stalls
+// if stallAfter is not -1, after writing a total of that many bytes of the blob.
+func writeBlobRange(t *testing.T, rw http.ResponseWriter, r *http.Request, blob []byte, offset int64, partial bool, stallAfter int64) {
+	rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(blob))-offset, 10))
+	if partial {
+		rw.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(blob)-1, len(blob)))
+		rw.WriteHeader(http.StatusPartialContent)
+	} else {
+		rw.WriteHeader(http.StatusOK)
+	}
+	end := int64(len(blob))
+	if stallAfter != -1 {
+		end = stallAfter
+	}
+	_, err := rw.Write(blob[offset:end])
+	assert.NoError(t, err)
+	if stallAfter != -1 {
+		rw.(http.Flusher).Flush()
+		stall(r)
+	}
+}
+
+func TestIdleTimeoutResumesBlobs(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 10000)
+	blobDigest := digest.FromBytes(blob)
+
+	var mutex sync.Mutex
+	ranges := []string{}
+	src := newIdleTimeoutTestSource(t, nil, func(rw http.ResponseWriter, r *http.Request) {
+		if !assert.Equal(t, "/v2/repo/blobs/"+blobDigest.String(), r.URL.Path) {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rangeHeader := r.Header.Get("Range")
+		mutex.Lock()
+		ranges = append(ranges, rangeHeader)
+		attempt := len(ranges)
+		mutex.Unlock()
+		rw.Header().Set("Accept-Ranges", "bytes")
+		switch attempt {
+		case 1:
+			writeBlobRange(t, rw, r, blob, 0, false, 30000)
+		case 2:
+			assert.Equal(t, "bytes=30000-", rangeHeader)
+			writeBlobRange(t, rw, r, blob, 30000, true, 60000)
+		default:
+			assert.Equal(t, "bytes=60000-", rangeHeader)
+			writeBlobRange(t, rw, r, blob, 60000, true, -1)
+		}
+	})
+
+	stream, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Equal(t, int64(len(blob)), size)
+	data, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, blob, data)
+	assert.Equal(t, []string{"", "bytes=30000-", "bytes=60000-"}, ranges)
+}
+
+func TestIdleTimeoutWithoutRangeSupport(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 10000)
+	blobDigest := digest.FromBytes(blob)
+
+	src := newIdleTimeoutTestSource(t, nil, func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			writeBlobRange(t, rw, r, blob, 0, false, -1) // Ignore the Range header
+			return
+		}
+		writeBlobRange(t, rw, r, blob, 0, false, 30000)
+	})
+
+	stream, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+	require.NoError(t, err)
+	defer stream.Close()
+	_, err = io.ReadAll(stream)
+	assert.Error(t, err)
+	assert.True(t, isIdleTimeoutError(err), err.Error())
+}
+
+func TestRetryBackoffDelay(t *testing.T) {
+	assert.Equal(t, retryBackoffInitialDelay, retryBackoffDelay(1))
+	assert.Equal(t, 2*retryBackoffInitialDelay, retryBackoffDelay(2))
+	assert.Equal(t, 4*retryBackoffInitialDelay, retryBackoffDelay(3))
+	assert.Equal(t, backoffMaxDelay, retryBackoffDelay(100))
+}
+
+func TestIdleTimeoutResumeRespectsContext(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 10000)
+	blobDigest := digest.FromBytes(blob)
+
+	var mutex sync.Mutex
+	attempts := 0
+	src := newIdleTimeoutTestSource(t, nil, func(rw http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		mutex.Unlock()
+		rw.Header().Set("Accept-Ranges", "bytes")
+		writeBlobRange(t, rw, r, blob, 0, false, 30000)
+	})
+	retryBackoffInitialDelay = time.Hour // Restored by newIdleTimeoutTestSource
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+	require.NoError(t, err)
+	defer stream.Close()
+	timer := time.AfterFunc(2*testIdleTimeout, cancel)
+	defer timer.Stop()
+	start := time.Now()
+	_, err = io.ReadAll(stream)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	// The backoff was interrupted by the cancellation, without making another request.
+	assert.Equal(t, 1, attempts)
+}
+
+// interruptBlobResponse writes blob starting at offset, as a response to a request with a Range header if partial,
+// and closes the connection after writing a total of interruptAfter bytes of the blob.
+func interruptBlobResponse(t *testing.T, rw http.ResponseWriter, blob []byte, offset int64, partial bool, interruptAfter int64) {
+	rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(blob))-offset, 10))
+	if partial {
+		rw.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(blob)-1, len(blob)))
+		rw.WriteHeader(http.StatusPartialContent)
+	} else {
+		rw.WriteHeader(http.StatusOK)
+	}
+	_, err := rw.Write(blob[offset:interruptAfter])
+	assert.NoError(t, err)
+	rw.(http.Flusher).Flush()
+	conn, _, err := rw.(http.Hijacker).Hijack()
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestResumeInterruptedBlobDownloads(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 10000)
+	blobDigest := digest.FromBytes(blob)
+
+	for _, c := range []struct {
+		acceptRanges string
+		resumable    bool
+	}{
+		{"bytes", true},
+		{"none, Bytes", true},
+		{"none", false},
+		{"", false},
+	} {
+		var mutex sync.Mutex
+		ranges := []string{}
+		src := newIdleTimeoutTestSource(t, nil, func(rw http.ResponseWriter, r *http.Request) {
+			rangeHeader := r.Header.Get("Range")
+			mutex.Lock()
+			ranges = append(ranges, rangeHeader)
+			attempt := len(ranges)
+			mutex.Unlock()
+			if c.acceptRanges != "" {
+				rw.Header().Set("Accept-Ranges", c.acceptRanges)
+			}
+			switch attempt {
+			case 1:
+				interruptBlobResponse(t, rw, blob, 0, false, 30000)
+			case 2:
+				assert.Equal(t, "bytes=30000-", rangeHeader)
+				interruptBlobResponse(t, rw, blob, 30000, true, 60000)
+			default:
+				assert.Equal(t, "bytes=60000-", rangeHeader)
+				writeBlobRange(t, rw, r, blob, 60000, true, -1)
+			}
+		})
+
+		stream, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+		require.NoError(t, err, c.acceptRanges)
+		assert.Equal(t, int64(len(blob)), size, c.acceptRanges)
+		data, err := io.ReadAll(stream)
+		stream.Close()
+		if c.resumable {
+			require.NoError(t, err, c.acceptRanges)
+			assert.Equal(t, blob, data, c.acceptRanges)
+			assert.Equal(t, []string{"", "bytes=30000-", "bytes=60000-"}, ranges, c.acceptRanges)
+		} else {
+			// Without Accept-Ranges, no range request is attempted, and the failure is reported.
+			assert.Error(t, err, c.acceptRanges)
+			assert.Equal(t, []string{""}, ranges, c.acceptRanges)
+		}
+	}
+}
+
+func TestResumeRetriesFailedRangeRequests(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 10000)
+	blobDigest := digest.FromBytes(blob)
+
+	for _, c := range []struct {
+		failures int
+		succeeds bool
+		requests int
+	}{
+		{failures: 2, succeeds: true, requests: 4},
+		{failures: 100, succeeds: false, requests: 1 + backoffNumIterations},
+	} {
+		var mutex sync.Mutex
+		requests := 0
+		src := newIdleTimeoutTestSource(t, nil, func(rw http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests++
+			request := requests
+			mutex.Unlock()
+			rw.Header().Set("Accept-Ranges", "bytes")
+			switch {
+			case request == 1:
+				interruptBlobResponse(t, rw, blob, 0, false, 30000)
+			case request <= 1+c.failures:
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				assert.Equal(t, "bytes=30000-", r.Header.Get("Range"))
+				writeBlobRange(t, rw, r, blob, 30000, true, -1)
+			}
+		})
+
+		stream, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+		require.NoError(t, err)
+		data, err := io.ReadAll(stream)
+		stream.Close()
+		if c.succeeds {
+			require.NoError(t, err)
+			assert.Equal(t, blob, data)
+		} else {
+			assert.Error(t, err)
+		}
+		mutex.Lock()
+		assert.Equal(t, c.requests, requests)
+		mutex.Unlock()
+	}
+}
+
+func TestSupportsByteRanges(t *testing.T) {
+	for _, c := range []struct {
+		headers  []string
+		expected bool
+	}{
+		{nil, false},
+		{[]string{"bytes"}, true},
+		{[]string{"Bytes"}, true},
+		{[]string{"none"}, false},
+		{[]string{"none, bytes"}, true},
+		{[]string{"none", "bytes"}, true},
+		{[]string{"bytesx"}, false},
+	} {
+		res := &http.Response{Header: http.Header{}}
+		for _, h := range c.headers {
+			res.Header.Add("Accept-Ranges", h)
+		}
+		assert.Equal(t, c.expected, supportsByteRanges(res), c.headers)
+	}
+}
+
+func TestIdleTimeoutSlowProgress(t *testing.T) {
+	blob := []byte(strings.Repeat("x", 10))
+	blobDigest := digest.FromBytes(blob)
+
+	requests := 0
+	src := newIdleTimeoutTestSource(t, nil, func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		rw.WriteHeader(http.StatusOK)
+		// The whole response takes much longer than the idle timeout, but there is regular progress.
+		for i := range blob {
+			time.Sleep(testIdleTimeout / 4)
+			_, err := rw.Write(blob[i : i+1])
+			assert.NoError(t, err)
+			rw.(http.Flusher).Flush()
+		}
+	})
+
+	stream, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+	require.NoError(t, err)
+	defer stream.Close()
+	// Also, time spent by the consumer between reads does not count towards the timeout.
+	time.Sleep(2 * testIdleTimeout)
+	data, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, blob, data)
+	assert.Equal(t, 1, requests)
+}
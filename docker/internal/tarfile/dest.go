@@ -17,6 +17,7 @@ import (
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 )
 
@@ -27,8 +28,9 @@ type Destination struct {
 	stubs.NoPutBlobPartialInitialize
 	stubs.NoSignaturesInitialize
 
-	archive  *Writer
-	repoTags []reference.NamedTagged
+	archive               *Writer
+	repoTags              []reference.NamedTagged
+	supportsManifestLists bool // See AllowManifestLists
 	// Other state.
 	config []byte
 	sysCtx *types.SystemContext
@@ -70,6 +72,23 @@ func (d *Destination) AddRepoTags(tags []reference.NamedTagged) {
 	d.repoTags = append(d.repoTags, tags...)
 }
 
+// AllowManifestLists allows writing manifest lists, which are stored using an extension of the docker save format, see ManifestListItem.
+// This should only be used if the consumer of the archive can read that extension (i.e. not by docker-daemon:).
+func (d *Destination) AllowManifestLists() {
+	d.supportsManifestLists = true
+}
+
+// SupportedManifestMIMETypes tells which manifest mime types the destination supports
+// If an empty slice or nil it's returned, then any mime type can be tried to upload
+func (d *Destination) SupportedManifestMIMETypes() []string {
+	res := d.PropertyMethodsInitialize.SupportedManifestMIMETypes()
+	if d.supportsManifestLists {
+		// Manifest lists are stored as-is, in addition to the instances, which must use Docker schema 2; see ManifestListItem.
+		res = append(res, manifest.DockerV2ListMediaType, imgspecv1.MediaTypeImageIndex)
+	}
+	return res
+}
+
 // PutBlobWithOptions writes contents of stream and returns data representing the result.
 // inputInfo.Digest can be optionally provided if known; if provided, and stream is read to the end without error, the digest MUST match the stream contents.
 // inputInfo.Size is the expected length of stream, if known.
@@ -120,7 +139,7 @@ func (d *Destination) PutBlobWithOptions(ctx context.Context, stream io.Reader,
 		}
 	}
 	d.archive.recordBlobLocked(types.BlobInfo{Digest: inputInfo.Digest, Size: inputInfo.Size})
-	return types.BlobInfo{Digest: inputInfo.Digest, Size: inputInfo.Size}, nil
+	return types.BlobInfo{Digest: inputInfo.Digest, Size: inputInfo.Size, MediaType: inputInfo.MediaType}, nil
 }
 
 // TryReusingBlobWithOptions checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
@@ -140,15 +159,20 @@ func (d *Destination) TryReusingBlobWithOptions(ctx context.Context, info types.
 }
 
 // PutManifest writes manifest to the destination.
-// The instanceDigest value is expected to always be nil, because this transport does not support manifest lists, so
-// there can be no secondary manifests.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for
+// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+// It is expected but not enforced that the instanceDigest, when specified, matches the digest of `manifest` as generated
+// by `manifest.Digest()`.
 // FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
 // If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
 // but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
 func (d *Destination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
-	if instanceDigest != nil {
+	if !d.supportsManifestLists && (instanceDigest != nil || manifest.MIMETypeIsMultiImage(manifest.GuessMIMEType(m))) {
 		return errors.New(`Manifest lists are not supported for docker tar files`)
 	}
+	if instanceDigest == nil && manifest.MIMETypeIsMultiImage(manifest.GuessMIMEType(m)) {
+		return d.putManifestList(m)
+	}
 	// We do not bother with types.ManifestTypeRejectedError; our .SupportedManifestMIMETypes() above is already providing only one alternative,
 	// so the caller trying a different manifest kind would be pointless.
 	var man manifest.Schema2
@@ -164,9 +188,36 @@ func (d *Destination) PutManifest(ctx context.Context, m []byte, instanceDigest
 	}
 	defer d.archive.unlock()
 
-	if err := d.archive.writeLegacyMetadataLocked(man.LayersDescriptors, d.config, d.repoTags); err != nil {
+	repoTags := d.repoTags
+	if instanceDigest != nil {
+		// The tags are applied to the manifest list, not to the individual instances.
+		repoTags = nil
+	}
+	if err := d.archive.writeLegacyMetadataLocked(man.LayersDescriptors, d.config, repoTags); err != nil {
+		return err
+	}
+
+	if err := d.archive.ensureManifestItemLocked(man.LayersDescriptors, man.ConfigDescriptor.Digest, repoTags); err != nil {
 		return err
 	}
+	if instanceDigest != nil {
+		return d.archive.recordListInstanceLocked(*instanceDigest, m, man.ConfigDescriptor.Digest)
+	}
+	return nil
+}
+
+// putManifestList writes a manifest list to the destination.
+// All of the list’s instances must have already been written using PutManifest.
+func (d *Destination) putManifestList(m []byte) error {
+	list, err := manifest.ListFromBlob(m, manifest.GuessMIMEType(m))
+	if err != nil {
+		return fmt.Errorf("parsing manifest list: %w", err)
+	}
+
+	if err := d.archive.lock(); err != nil {
+		return err
+	}
+	defer d.archive.unlock()
 
-	return d.archive.ensureManifestItemLocked(man.LayersDescriptors, man.ConfigDescriptor.Digest, d.repoTags)
+	return d.archive.ensureManifestListItemLocked(m, list.Instances(), d.repoTags)
 }
@@ -0,0 +1,34 @@
+package tarfile
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestinationManifestLists(t *testing.T) {
+	list := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+	instanceDigest := digest.FromString("instance")
+
+	// By default, manifest lists are neither advertised nor accepted.
+	var buf bytes.Buffer
+	dest := NewDestination(nil, NewWriter(&buf), "transport name", nil)
+	assert.Equal(t, []string{manifest.DockerV2Schema2MediaType}, dest.SupportedManifestMIMETypes())
+	err := dest.PutManifest(context.Background(), list, nil)
+	assert.ErrorContains(t, err, "Manifest lists are not supported")
+	err = dest.PutManifest(context.Background(), []byte(`{}`), &instanceDigest)
+	assert.ErrorContains(t, err, "Manifest lists are not supported")
+
+	// With AllowManifestLists, they are.
+	dest = NewDestination(nil, NewWriter(&buf), "transport name", nil)
+	dest.AllowManifestLists()
+	assert.Equal(t, []string{manifest.DockerV2Schema2MediaType, manifest.DockerV2ListMediaType, imgspecv1.MediaTypeImageIndex},
+		dest.SupportedManifestMIMETypes())
+	err = dest.PutManifest(context.Background(), list, nil)
+	assert.NoError(t, err)
+}
@@ -20,9 +20,10 @@ import (
 type Reader struct {
 	// None of the fields below are modified after the archive is created, until .Close();
 	// this allows concurrent readers of the same archive.
-	path          string         // "" if the archive has already been closed.
-	removeOnClose bool           // Remove file on close if true
-	Manifest      []ManifestItem // Guaranteed to exist after the archive is created.
+	path          string             // "" if the archive has already been closed.
+	removeOnClose bool               // Remove file on close if true
+	Manifest      []ManifestItem     // Guaranteed to exist after the archive is created.
+	ManifestLists []ManifestListItem // Usually empty; only archives created by this library can contain manifest lists.
 }
 
 // NewReaderFromFile returns a Reader for the specified path.
@@ -115,6 +116,17 @@ func newReader(path string, removeOnClose bool) (*Reader, error) {
 	if err := json.Unmarshal(bytes, &r.Manifest); err != nil {
 		return nil, fmt.Errorf("decoding tar manifest.json: %w", err)
 	}
+	bytes, err = r.readTarComponent(manifestListsFileName, iolimits.MaxTarFileManifestSize)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(bytes, &r.ManifestLists); err != nil {
+			return nil, fmt.Errorf("decoding tar %s: %w", manifestListsFileName, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		// A single-image archive, or one created by (docker save); nothing to do.
+	default:
+		return nil, err
+	}
 
 	succeeded = true
 	return &r, nil
@@ -157,7 +169,7 @@ func (r *Reader) ChooseManifestItem(ref reference.NamedTagged, sourceIndex int)
 
 	case sourceIndex != -1:
 		if sourceIndex >= len(r.Manifest) {
-			return nil, -1, fmt.Errorf("Invalid source index @%d, only %d manifest items available",
+			return nil, -1, fmt.Errorf("Invalid source index @%d, only %d manifest items available (source indices are zero-based)",
 				sourceIndex, len(r.Manifest))
 		}
 		return &r.Manifest[sourceIndex], -1, nil
@@ -170,6 +182,44 @@ func (r *Reader) ChooseManifestItem(ref reference.NamedTagged, sourceIndex int)
 	}
 }
 
+// ChooseManifestListItem selects a manifest list item from r.ManifestLists matching (ref, sourceIndex), one or
+// both of which should be (nil, -1).
+// It returns nil if (ref, sourceIndex) does not refer to a manifest list; in that case, the caller should use ChooseManifestItem.
+// On success, it returns the manifest list item and an index of the matching tag, if a tag was used
+// for matching; the index is -1 if a tag was not used.
+func (r *Reader) ChooseManifestListItem(ref reference.NamedTagged, sourceIndex int) (*ManifestListItem, int, error) {
+	switch {
+	case ref != nil && sourceIndex != -1:
+		return nil, -1, fmt.Errorf("Internal error: Cannot have both ref %s and source index @%d",
+			ref.String(), sourceIndex)
+
+	case ref != nil:
+		refString := ref.String()
+		for i := range r.ManifestLists {
+			for tagIndex, tag := range r.ManifestLists[i].RepoTags {
+				parsedTag, err := reference.ParseNormalizedNamed(tag)
+				if err != nil {
+					return nil, -1, fmt.Errorf("Invalid tag %#v in %s item @%d: %w", tag, manifestListsFileName, i, err)
+				}
+				if parsedTag.String() == refString {
+					return &r.ManifestLists[i], tagIndex, nil
+				}
+			}
+		}
+		return nil, -1, nil
+
+	case sourceIndex != -1: // Source indexes always refer to r.Manifest.
+		return nil, -1, nil
+
+	default:
+		// The archive contains a single manifest list, and nothing else.
+		if len(r.ManifestLists) == 1 && len(r.ManifestLists[0].Instances) == len(r.Manifest) {
+			return &r.ManifestLists[0], -1, nil
+		}
+		return nil, -1, nil
+	}
+}
+
 // tarReadCloser is a way to close the backing file of a tar.Reader when the user no longer needs the tar component.
 type tarReadCloser struct {
 	*tar.Reader
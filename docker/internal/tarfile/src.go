@@ -41,6 +41,11 @@ type Source struct {
 	configDigest      digest.Digest
 	orderedDiffIDList []digest.Digest
 	knownLayers       map[digest.Digest]*layerInfo
+	configs           map[digest.Digest][]byte // All configs available using GetBlob
+	// Only set if the source refers to a manifest list; in that case, configBytes … orderedDiffIDList are not set,
+	// and configs and knownLayers contain data of all instances.
+	manifestList      []byte
+	instanceManifests map[digest.Digest][]byte
 	// Other state
 	generatedManifest []byte    // Private cache for GetManifest(), nil if not set yet.
 	cacheDataLock     sync.Once // Private state for ensureCachedDataIsPresent to make it concurrency-safe
@@ -83,25 +88,20 @@ func (s *Source) ensureCachedDataIsPresent() error {
 // ensureCachedDataIsPresentPrivate is a private implementation detail of ensureCachedDataIsPresent.
 // Call ensureCachedDataIsPresent instead.
 func (s *Source) ensureCachedDataIsPresentPrivate() error {
-	tarManifest, _, err := s.archive.ChooseManifestItem(s.ref, s.sourceIndex)
+	listItem, _, err := s.archive.ChooseManifestListItem(s.ref, s.sourceIndex)
 	if err != nil {
 		return err
 	}
+	if listItem != nil {
+		return s.ensureManifestListDataIsPresent(listItem)
+	}
 
-	// Read and parse config.
-	configBytes, err := s.archive.readTarComponent(tarManifest.Config, iolimits.MaxConfigBodySize)
+	tarManifest, _, err := s.archive.ChooseManifestItem(s.ref, s.sourceIndex)
 	if err != nil {
 		return err
 	}
-	var parsedConfig manifest.Schema2Image // There's a lot of info there, but we only really care about layer DiffIDs.
-	if err := json.Unmarshal(configBytes, &parsedConfig); err != nil {
-		return fmt.Errorf("decoding tar config %s: %w", tarManifest.Config, err)
-	}
-	if parsedConfig.RootFS == nil {
-		return fmt.Errorf("Invalid image config (rootFS is not set): %s", tarManifest.Config)
-	}
 
-	knownLayers, err := s.prepareLayerData(tarManifest, &parsedConfig)
+	configBytes, parsedConfig, knownLayers, err := s.readImageData(tarManifest)
 	if err != nil {
 		return err
 	}
@@ -112,9 +112,76 @@ func (s *Source) ensureCachedDataIsPresentPrivate() error {
 	s.configDigest = digest.FromBytes(configBytes)
 	s.orderedDiffIDList = parsedConfig.RootFS.DiffIDs
 	s.knownLayers = knownLayers
+	s.configs = map[digest.Digest][]byte{s.configDigest: configBytes}
 	return nil
 }
 
+// ensureManifestListDataIsPresent is a part of ensureCachedDataIsPresentPrivate, loading data of listItem and all of its instances.
+func (s *Source) ensureManifestListDataIsPresent(listItem *ManifestListItem) error {
+	manifestList, err := s.archive.readTarComponent(listItem.Manifest, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return err
+	}
+	instanceManifests := map[digest.Digest][]byte{}
+	configs := map[digest.Digest][]byte{}
+	knownLayers := map[digest.Digest]*layerInfo{}
+	for _, instance := range listItem.Instances {
+		instanceManifest, err := s.archive.readTarComponent(instance.Manifest, iolimits.MaxManifestBodySize)
+		if err != nil {
+			return err
+		}
+		instanceManifests[instance.Digest] = instanceManifest
+
+		var tarManifest *ManifestItem
+		for i := range s.archive.Manifest {
+			if s.archive.Manifest[i].Config == instance.Config {
+				tarManifest = &s.archive.Manifest[i]
+				break
+			}
+		}
+		if tarManifest == nil {
+			return fmt.Errorf("Manifest list instance %s with config %s not found in manifest.json", instance.Digest, instance.Config)
+		}
+		configBytes, _, instanceLayers, err := s.readImageData(tarManifest)
+		if err != nil {
+			return err
+		}
+		configs[digest.FromBytes(configBytes)] = configBytes
+		for diffID, li := range instanceLayers {
+			knownLayers[diffID] = li
+		}
+	}
+
+	// Success; commit.
+	s.manifestList = manifestList
+	s.instanceManifests = instanceManifests
+	s.configs = configs
+	s.knownLayers = knownLayers
+	return nil
+}
+
+// readImageData reads and parses the config of tarManifest, and collects data about its layers.
+func (s *Source) readImageData(tarManifest *ManifestItem) ([]byte, *manifest.Schema2Image, map[digest.Digest]*layerInfo, error) {
+	// Read and parse config.
+	configBytes, err := s.archive.readTarComponent(tarManifest.Config, iolimits.MaxConfigBodySize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var parsedConfig manifest.Schema2Image // There's a lot of info there, but we only really care about layer DiffIDs.
+	if err := json.Unmarshal(configBytes, &parsedConfig); err != nil {
+		return nil, nil, nil, fmt.Errorf("decoding tar config %s: %w", tarManifest.Config, err)
+	}
+	if parsedConfig.RootFS == nil {
+		return nil, nil, nil, fmt.Errorf("Invalid image config (rootFS is not set): %s", tarManifest.Config)
+	}
+
+	knownLayers, err := s.prepareLayerData(tarManifest, &parsedConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return configBytes, &parsedConfig, knownLayers, nil
+}
+
 // Close removes resources associated with an initialized Source, if any.
 func (s *Source) Close() error {
 	if s.closeArchive {
@@ -205,17 +272,27 @@ func (s *Source) prepareLayerData(tarManifest *ManifestItem, parsedConfig *manif
 // It may use a remote (= slow) service.
 // If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
 // this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
-// This source implementation does not support manifest lists, so the passed-in instanceDigest should always be nil,
-// as the primary manifest can not be a list, so there can be no secondary instances.
+// Manifest lists are only available in archives created by this library, see ManifestListItem; for other archives,
+// the passed-in instanceDigest should always be nil, as the primary manifest can not be a list, so there can be no secondary instances.
 func (s *Source) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if err := s.ensureCachedDataIsPresent(); err != nil {
+		return nil, "", err
+	}
+	if s.manifestList != nil {
+		if instanceDigest == nil {
+			return s.manifestList, manifest.GuessMIMEType(s.manifestList), nil
+		}
+		instanceManifest, ok := s.instanceManifests[*instanceDigest]
+		if !ok {
+			return nil, "", fmt.Errorf("Manifest list instance %s not found", instanceDigest.String())
+		}
+		return instanceManifest, manifest.GuessMIMEType(instanceManifest), nil
+	}
 	if instanceDigest != nil {
 		// How did we even get here? GetManifest(ctx, nil) has returned a manifest.DockerV2Schema2MediaType.
 		return nil, "", errors.New(`Manifest lists are not supported by "docker-daemon:"`)
 	}
 	if s.generatedManifest == nil {
-		if err := s.ensureCachedDataIsPresent(); err != nil {
-			return nil, "", err
-		}
 		m := manifest.Schema2{
 			SchemaVersion: 2,
 			MediaType:     manifest.DockerV2Schema2MediaType,
@@ -272,8 +349,8 @@ func (s *Source) GetBlob(ctx context.Context, info types.BlobInfo, cache types.B
 		return nil, 0, err
 	}
 
-	if info.Digest == s.configDigest { // FIXME? Implement a more general algorithm matching instead of assuming sha256.
-		return io.NopCloser(bytes.NewReader(s.configBytes)), int64(len(s.configBytes)), nil
+	if configBytes, ok := s.configs[info.Digest]; ok { // FIXME? Implement a more general algorithm matching instead of assuming sha256.
+		return io.NopCloser(bytes.NewReader(configBytes)), int64(len(configBytes)), nil
 	}
 
 	if li, ok := s.knownLayers[info.Digest]; ok { // diffID is a digest of the uncompressed tarball,
@@ -16,6 +16,9 @@ const (
 	legacyRepositoriesFileName = "repositories"
 )
 
+// manifestListsFileName is not a part of the (docker save) format; see ManifestListItem.
+const manifestListsFileName = "manifest-lists.json"
+
 // ManifestItem is an element of the array stored in the top-level manifest.json file.
 type ManifestItem struct { // NOTE: This is visible as docker/tarfile.ManifestItem, and a part of the stable API.
 	Config       string
@@ -26,3 +29,21 @@ type ManifestItem struct { // NOTE: This is visible as docker/tarfile.ManifestIt
 }
 
 type imageID string
+
+// ManifestListItem is an element of the array stored in the top-level manifest-lists.json file.
+// That file is not a part of the (docker save) format; we only create it when storing a manifest list,
+// so that the list and the original instance manifests can be read back. Each instance is also stored
+// as an ordinary image in manifest.json, so consumers that don’t know about manifest-lists.json (e.g. docker load)
+// can still use the individual images.
+type ManifestListItem struct {
+	Manifest  string // Path to the manifest list
+	RepoTags  []string
+	Instances []ManifestListInstance
+}
+
+// ManifestListInstance describes a single instance of a ManifestListItem.
+type ManifestListInstance struct {
+	Digest   digest.Digest // Digest of the instance manifest, as referenced by the manifest list
+	Manifest string        // Path to the instance manifest
+	Config   string        // Path to the config of the instance; the same as Config of the corresponding ManifestItem
+}
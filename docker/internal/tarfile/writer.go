@@ -32,6 +32,10 @@ type Writer struct {
 	legacyLayers     map[string]struct{} // A set of IDs of legacy layers that have been already sent.
 	manifest         []ManifestItem
 	manifestByConfig map[digest.Digest]int // A map from config digest to an entry index in manifest above.
+	// Manifest list data, only written if at least one manifest list is stored.
+	manifestLists       []ManifestListItem
+	manifestListsByPath map[string]int                         // A map from manifest list path to an entry index in manifestLists above.
+	listInstances       map[digest.Digest]ManifestListInstance // Manifest list instances that have already been sent, by instance digest.
 }
 
 // NewWriter returns a Writer for the specified io.Writer.
@@ -44,6 +48,9 @@ func NewWriter(dest io.Writer) *Writer {
 		repositories:     map[string]map[string]string{},
 		legacyLayers:     map[string]struct{}{},
 		manifestByConfig: map[digest.Digest]int{},
+
+		manifestListsByPath: map[string]int{},
+		listInstances:       map[digest.Digest]ManifestListInstance{},
 	}
 }
 
@@ -75,7 +82,7 @@ func (w *Writer) tryReusingBlobLocked(info types.BlobInfo) (bool, types.BlobInfo
 		return false, types.BlobInfo{}, errors.New("Can not check for a blob with unknown digest")
 	}
 	if blob, ok := w.blobs[info.Digest]; ok {
-		return true, types.BlobInfo{Digest: info.Digest, Size: blob.Size}, nil
+		return true, types.BlobInfo{Digest: info.Digest, Size: blob.Size, MediaType: info.MediaType}, nil
 	}
 	return false, types.BlobInfo{}, nil
 }
@@ -229,8 +236,14 @@ func (w *Writer) ensureManifestItemLocked(layerDescriptors []manifest.Schema2Des
 		item = &w.manifest[i]
 	}
 
+	item.RepoTags = mergeRepoTags(item.RepoTags, repoTags)
+	return nil
+}
+
+// mergeRepoTags returns existing, extended with any of repoTags not already present, in the RepoTags format.
+func mergeRepoTags(existing []string, repoTags []reference.NamedTagged) []string {
 	knownRepoTags := map[string]struct{}{}
-	for _, repoTag := range item.RepoTags {
+	for _, repoTag := range existing {
 		knownRepoTags[repoTag] = struct{}{}
 	}
 	for _, tag := range repoTags {
@@ -253,11 +266,62 @@ func (w *Writer) ensureManifestItemLocked(layerDescriptors []manifest.Schema2Des
 		refString := fmt.Sprintf("%s:%s", tag.Name(), tag.Tag())
 
 		if _, ok := knownRepoTags[refString]; !ok {
-			item.RepoTags = append(item.RepoTags, refString)
+			existing = append(existing, refString)
 			knownRepoTags[refString] = struct{}{}
 		}
 	}
+	return existing
+}
+
+// recordListInstanceLocked stores the manifest of a manifest list instance with instanceDigest, which uses the config with configDigest.
+// The corresponding image must also be recorded using ensureManifestItemLocked.
+// The caller must have locked the Writer.
+func (w *Writer) recordListInstanceLocked(instanceDigest digest.Digest, manifestBytes []byte, configDigest digest.Digest) error {
+	if _, ok := w.listInstances[instanceDigest]; ok {
+		return nil
+	}
+	instance := ManifestListInstance{
+		Digest:   instanceDigest,
+		Manifest: w.manifestPath(instanceDigest),
+		Config:   w.configPath(configDigest),
+	}
+	if err := w.sendBytesLocked(instance.Manifest, manifestBytes); err != nil {
+		return fmt.Errorf("writing manifest of instance %s: %w", instanceDigest, err)
+	}
+	w.listInstances[instanceDigest] = instance
+	return nil
+}
 
+// ensureManifestListItemLocked ensures that there is a manifest list item for listBytes, which references instanceDigests, with repoTags.
+// All of instanceDigests must have been recorded using recordListInstanceLocked.
+// The caller must have locked the Writer.
+func (w *Writer) ensureManifestListItemLocked(listBytes []byte, instanceDigests []digest.Digest, repoTags []reference.NamedTagged) error {
+	listPath := w.manifestPath(digest.FromBytes(listBytes))
+	var item *ManifestListItem
+	if i, ok := w.manifestListsByPath[listPath]; ok {
+		item = &w.manifestLists[i]
+	} else {
+		instances := []ManifestListInstance{}
+		for _, d := range instanceDigests {
+			instance, ok := w.listInstances[d]
+			if !ok {
+				return fmt.Errorf("manifest list refers to instance %s, which was not written; docker tar files can only store complete manifest lists", d)
+			}
+			instances = append(instances, instance)
+		}
+		if err := w.sendBytesLocked(listPath, listBytes); err != nil {
+			return fmt.Errorf("writing manifest list: %w", err)
+		}
+		i := len(w.manifestLists)
+		w.manifestListsByPath[listPath] = i
+		w.manifestLists = append(w.manifestLists, ManifestListItem{
+			Manifest:  listPath,
+			RepoTags:  []string{},
+			Instances: instances,
+		})
+		item = &w.manifestLists[i]
+	}
+	item.RepoTags = mergeRepoTags(item.RepoTags, repoTags)
 	return nil
 }
 
@@ -278,6 +342,16 @@ func (w *Writer) Close() error {
 		return err
 	}
 
+	if len(w.manifestLists) != 0 {
+		b, err = json.Marshal(&w.manifestLists)
+		if err != nil {
+			return fmt.Errorf("marshaling manifest lists: %w", err)
+		}
+		if err := w.sendBytesLocked(manifestListsFileName, b); err != nil {
+			return fmt.Errorf("writing manifest lists: %w", err)
+		}
+	}
+
 	b, err = json.Marshal(w.repositories)
 	if err != nil {
 		return fmt.Errorf("marshaling repositories: %w", err)
@@ -300,6 +374,14 @@ func (w *Writer) configPath(configDigest digest.Digest) string {
 	return configDigest.Hex() + ".json"
 }
 
+// manifestPath returns a path we choose for storing a manifest (of a manifest list instance, or a manifest list itself)
+// with the specified digest.
+// NOTE: This is an internal implementation detail, not a format property, and can change
+// any time.
+func (w *Writer) manifestPath(manifestDigest digest.Digest) string {
+	return manifestDigest.Hex() + ".manifest.json"
+}
+
 // physicalLayerPath returns a path we choose for storing a layer with the specified digest
 // (the actual path, i.e. a regular file, not a symlink that may be used in the legacy format).
 // NOTE: This is an internal implementation detail, not a format property, and can change
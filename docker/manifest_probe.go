@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/docker/reference"
+)
+
+// acceptedManifestMIMETypesCache contains the results of probeAcceptedManifestMIMETypes, indexed by registry.
+// A nil value means that the registry does not list the accepted types.
+var acceptedManifestMIMETypesCache = struct {
+	sync.Mutex
+	m map[string][]string
+}{m: map[string][]string{}}
+
+// filterAcceptedManifestMIMETypes returns those of mimeTypes which the registry of ref accepts, as determined
+// by probeAcceptedManifestMIMETypes, in the same order; or mimeTypes unmodified, if that can't be determined.
+func (c *dockerClient) filterAcceptedManifestMIMETypes(ctx context.Context, ref dockerReference, mimeTypes []string) []string {
+	acceptedManifestMIMETypesCache.Lock()
+	accepted, ok := acceptedManifestMIMETypesCache.m[c.registry]
+	acceptedManifestMIMETypesCache.Unlock()
+	if !ok {
+		var err error
+		accepted, err = c.probeAcceptedManifestMIMETypes(ctx, ref)
+		if err != nil {
+			// Don't cache the failure, it may be transient.
+			c.logger.Debugf("Error determining manifest MIME types accepted by %s: %v", c.registry, err)
+			return mimeTypes
+		}
+		acceptedManifestMIMETypesCache.Lock()
+		acceptedManifestMIMETypesCache.m[c.registry] = accepted
+		acceptedManifestMIMETypesCache.Unlock()
+	}
+	if accepted == nil {
+		return mimeTypes
+	}
+
+	res := []string{}
+	for _, t := range mimeTypes {
+		for _, a := range accepted {
+			if t == a {
+				res = append(res, t)
+				break
+			}
+		}
+	}
+	if len(res) == 0 {
+		c.logger.Debugf("Registry %s does not accept any of the manifest MIME types %v, ignoring its list of accepted types %v", c.registry, mimeTypes, accepted)
+		return mimeTypes
+	}
+	c.logger.Debugf("Registry %s accepts manifest MIME types %v", c.registry, res)
+	return res
+}
+
+// probeAcceptedManifestMIMETypes returns the manifest MIME types which the registry of ref lists in the Accept header
+// of a response to an OPTIONS request for the manifest of ref, or nil if the response doesn't list any.
+func (c *dockerClient) probeAcceptedManifestMIMETypes(ctx context.Context, ref dockerReference) ([]string, error) {
+	if err := c.detectProperties(ctx); err != nil {
+		return nil, err
+	}
+	tagOrDigest, err := ref.tagOrDigest()
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf(manifestPath, reference.Path(ref.ref), tagOrDigest)
+	res, err := c.makeRequest(ctx, http.MethodOptions, path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	default:
+		c.logger.Debugf("OPTIONS %s returned status %d (%s), not using it to determine accepted manifest MIME types", path, res.StatusCode, http.StatusText(res.StatusCode))
+		return nil, nil
+	}
+
+	var accepted []string
+	for _, value := range res.Header.Values("Accept") {
+		for _, item := range strings.Split(value, ",") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			mimeType, _, err := mime.ParseMediaType(item)
+			if err != nil {
+				c.logger.Debugf("Ignoring invalid Accept value %q: %v", item, err)
+				continue
+			}
+			accepted = append(accepted, mimeType)
+		}
+	}
+	return accepted, nil
+}
@@ -0,0 +1,113 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerImageDestinationProbeManifestMIMETypes(t *testing.T) {
+	allTypes := []string{
+		imgspecv1.MediaTypeImageManifest,
+		manifest.DockerV2Schema2MediaType,
+		imgspecv1.MediaTypeImageIndex,
+		manifest.DockerV2ListMediaType,
+		manifest.DockerV2Schema1SignedMediaType,
+		manifest.DockerV2Schema1MediaType,
+	}
+	for _, c := range []struct {
+		name     string
+		status   int
+		accept   []string
+		probe    bool
+		expected []string
+	}{
+		{
+			name:     "subset",
+			status:   http.StatusOK,
+			accept:   []string{manifest.DockerV2Schema2MediaType + ", " + imgspecv1.MediaTypeImageIndex, manifest.DockerV2ListMediaType + "; q=0.5"},
+			probe:    true,
+			expected: []string{manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageIndex, manifest.DockerV2ListMediaType},
+		},
+		{
+			name:     "probing disabled",
+			status:   http.StatusOK,
+			accept:   []string{manifest.DockerV2Schema2MediaType},
+			probe:    false,
+			expected: allTypes,
+		},
+		{
+			name:     "no Accept header",
+			status:   http.StatusNoContent,
+			accept:   nil,
+			probe:    true,
+			expected: allTypes,
+		},
+		{
+			name:     "OPTIONS not supported",
+			status:   http.StatusMethodNotAllowed,
+			accept:   []string{manifest.DockerV2Schema2MediaType},
+			probe:    true,
+			expected: allTypes,
+		},
+		{
+			name:     "no known types",
+			status:   http.StatusOK,
+			accept:   []string{"application/vnd.example.manifest+json"},
+			probe:    true,
+			expected: allTypes,
+		},
+	} {
+		var mutex sync.Mutex
+		probes := 0
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/v2/":
+				rw.WriteHeader(http.StatusOK)
+			case r.Method == http.MethodOptions && r.URL.Path == "/v2/repo/manifests/tag":
+				mutex.Lock()
+				probes++
+				mutex.Unlock()
+				for _, a := range c.accept {
+					rw.Header().Add("Accept", a)
+				}
+				rw.WriteHeader(c.status)
+			default:
+				assert.Failf(t, "Unexpected request", "%v %v", r.Method, r.URL.Path)
+				rw.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		registryURL, err := url.Parse(server.URL)
+		require.NoError(t, err, c.name)
+		ref, err := ParseReference("//" + registryURL.Host + "/repo:tag")
+		require.NoError(t, err, c.name)
+		sys := &types.SystemContext{
+			RegistriesDirPath:            "/this/does/not/exist",
+			DockerPerHostCertDirPath:     "/this/does/not/exist",
+			DockerInsecureSkipTLSVerify:  types.OptionalBoolTrue,
+			DockerProbeManifestMIMETypes: c.probe,
+		}
+		// The result is cached, so the registry is only probed once.
+		for i := 0; i < 2; i++ {
+			dest, err := ref.NewImageDestination(context.Background(), sys)
+			require.NoError(t, err, c.name)
+			assert.Equal(t, c.expected, dest.SupportedManifestMIMETypes(), c.name)
+			dest.Close()
+		}
+		expectedProbes := 0
+		if c.probe {
+			expectedProbes = 1 // Even responses which don't list the accepted types are cached.
+		}
+		assert.Equal(t, expectedProbes, probes, c.name)
+		server.Close()
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/logger"
 	"github.com/containers/image/v5/internal/rootless"
 	"github.com/containers/image/v5/types"
 	"github.com/containers/storage/pkg/homedir"
@@ -51,8 +52,18 @@ type registryNamespace struct {
 	SigStore               string `json:"sigstore"`          // For compatibility, deprecated in favor of Lookaside.
 	SigStoreStaging        string `json:"sigstore-staging"`  // For compatibility, deprecated in favor of LookasideStaging.
 	UseSigstoreAttachments *bool  `json:"use-sigstore-attachments,omitempty"`
+	// If set to readLookasideStagingBefore or readLookasideStagingAfter, signatures are also read from LookasideStaging.
+	ReadLookasideStaging string `json:"read-lookaside-staging,omitempty"`
+	// If true, signatures may be written to, and deleted from, http(s) signature storage, sending the registry credentials.
+	WriteHTTPLookaside *bool `json:"write-http-lookaside,omitempty"`
 }
 
+// Values of registryNamespace.ReadLookasideStaging.
+const (
+	readLookasideStagingBefore = "before" // Signatures in the lookaside-staging storage are read before all other signatures.
+	readLookasideStagingAfter  = "after"  // Signatures in the lookaside-staging storage are read after all other signatures.
+)
+
 // lookasideStorageBase is an "opaque" type representing a lookaside Docker signature storage.
 // Users outside of this file should use SignatureStorageBaseURL and lookasideStorageURL below.
 type lookasideStorageBase *url.URL
@@ -71,13 +82,13 @@ func SignatureStorageBaseURL(sys *types.SystemContext, ref types.ImageReference,
 		return nil, err
 	}
 
-	return config.lookasideStorageBaseURL(dr, write)
+	return config.lookasideStorageBaseURL(logger.FromSystemContext(sys), dr, write)
 }
 
 // loadRegistryConfiguration returns a registryConfiguration appropriate for sys.
 func loadRegistryConfiguration(sys *types.SystemContext) (*registryConfiguration, error) {
 	dirPath := registriesDirPath(sys)
-	logrus.Debugf(`Using registries.d directory %s`, dirPath)
+	logger.FromSystemContext(sys).Debugf(`Using registries.d directory %s`, dirPath)
 	return loadAndMergeConfig(dirPath)
 }
 
@@ -161,8 +172,8 @@ func loadAndMergeConfig(dirPath string) (*registryConfiguration, error) {
 
 // lookasideStorageBaseURL returns an appropriate signature storage URL for ref, for write access if “write”.
 // the usage of the BaseURL is defined under docker/distribution registries—separate storage of docs/signature-protocols.md
-func (config *registryConfiguration) lookasideStorageBaseURL(dr dockerReference, write bool) (*url.URL, error) {
-	topLevel := config.signatureTopLevel(dr, write)
+func (config *registryConfiguration) lookasideStorageBaseURL(logger logrus.FieldLogger, dr dockerReference, write bool) (*url.URL, error) {
+	topLevel := config.signatureTopLevel(logger, dr, write)
 	var baseURL *url.URL
 	if topLevel != "" {
 		u, err := url.Parse(topLevel)
@@ -173,8 +184,40 @@ func (config *registryConfiguration) lookasideStorageBaseURL(dr dockerReference,
 	} else {
 		// returns default directory if no lookaside specified in configuration file
 		baseURL = builtinDefaultLookasideStorageDir(rootless.GetRootlessEUID())
-		logrus.Debugf(" No signature storage configuration found for %s, using built-in default %s", dr.PolicyConfigurationIdentity(), baseURL.Redacted())
+		logger.Debugf(" No signature storage configuration found for %s, using built-in default %s", dr.PolicyConfigurationIdentity(), baseURL.Redacted())
+	}
+	return lookasideStorageRepoURL(baseURL, dr)
+}
+
+// lookasideStagingBaseURLForReading returns the staging signature storage URL for dr, and true if signatures in it should be
+// read before other signatures, if the configuration requires reading signatures from it; or nil if it should not be read.
+func (config *registryConfiguration) lookasideStagingBaseURLForReading(logger logrus.FieldLogger, dr dockerReference) (*url.URL, bool, error) {
+	mode := config.readLookasideStaging(logger, dr)
+	switch mode {
+	case "":
+		return nil, false, nil
+	case readLookasideStagingBefore, readLookasideStagingAfter:
+	default:
+		return nil, false, fmt.Errorf("Invalid read-lookaside-staging value %q for %s", mode, dr.PolicyConfigurationIdentity())
+	}
+	topLevel := config.signatureStagingTopLevel(logger, dr)
+	if topLevel == "" {
+		logger.Debugf(" No lookaside-staging configuration found for %s, not reading staged signatures", dr.PolicyConfigurationIdentity())
+		return nil, false, nil
+	}
+	baseURL, err := url.Parse(topLevel)
+	if err != nil {
+		return nil, false, fmt.Errorf("Invalid signature storage URL %s: %w", topLevel, err)
 	}
+	repoURL, err := lookasideStorageRepoURL(baseURL, dr)
+	if err != nil {
+		return nil, false, err
+	}
+	return repoURL, mode == readLookasideStagingBefore, nil
+}
+
+// lookasideStorageRepoURL returns the signature storage URL for dr within the top level of the storage at baseURL, which it modifies.
+func lookasideStorageRepoURL(baseURL *url.URL, dr dockerReference) (*url.URL, error) {
 	// NOTE: Keep this in sync with docs/signature-protocols.md!
 	// FIXME? Restrict to explicitly supported schemes?
 	repo := reference.Path(dr.ref) // Note that this is without a tag or digest.
@@ -195,13 +238,37 @@ func builtinDefaultLookasideStorageDir(euid int) *url.URL {
 
 // config.signatureTopLevel returns an URL string configured in config for ref, for write access if “write”.
 // (the top level of the storage, namespaced by repo.FullName etc.), or "" if nothing has been configured.
-func (config *registryConfiguration) signatureTopLevel(ref dockerReference, write bool) string {
+func (config *registryConfiguration) signatureTopLevel(logger logrus.FieldLogger, ref dockerReference, write bool) string {
+	return config.namespaceValue(logger, ref, "Lookaside configuration", func(ns registryNamespace) string {
+		return ns.signatureTopLevel(logger, write)
+	})
+}
+
+// config.signatureStagingTopLevel returns an URL string of the staging signature storage configured in config for ref,
+// or "" if nothing has been configured.
+func (config *registryConfiguration) signatureStagingTopLevel(logger logrus.FieldLogger, ref dockerReference) string {
+	return config.namespaceValue(logger, ref, "Lookaside staging configuration", func(ns registryNamespace) string {
+		return ns.signatureStagingTopLevel(logger)
+	})
+}
+
+// config.readLookasideStaging returns the read-lookaside-staging value configured in config for ref, or "" if nothing has been configured.
+func (config *registryConfiguration) readLookasideStaging(logger logrus.FieldLogger, ref dockerReference) string {
+	return config.namespaceValue(logger, ref, "Reading lookaside staging", func(ns registryNamespace) string {
+		return ns.ReadLookasideStaging
+	})
+}
+
+// config.namespaceValue returns the first non-empty result of value for the namespaces configured in config which match ref,
+// from the most specific one to the default-docker configuration, or "" if there is none.
+// description is used in debug logs, written using logger.
+func (config *registryConfiguration) namespaceValue(logger logrus.FieldLogger, ref dockerReference, description string, value func(ns registryNamespace) string) string {
 	if config.Docker != nil {
 		// Look for a full match.
 		identity := ref.PolicyConfigurationIdentity()
 		if ns, ok := config.Docker[identity]; ok {
-			logrus.Debugf(` Lookaside configuration: using "docker" namespace %s`, identity)
-			if ret := ns.signatureTopLevel(write); ret != "" {
+			logger.Debugf(` %s: using "docker" namespace %s`, description, identity)
+			if ret := value(ns); ret != "" {
 				return ret
 			}
 		}
@@ -209,8 +276,8 @@ func (config *registryConfiguration) signatureTopLevel(ref dockerReference, writ
 		// Look for a match of the possible parent namespaces.
 		for _, name := range ref.PolicyConfigurationNamespaces() {
 			if ns, ok := config.Docker[name]; ok {
-				logrus.Debugf(` Lookaside configuration: using "docker" namespace %s`, name)
-				if ret := ns.signatureTopLevel(write); ret != "" {
+				logger.Debugf(` %s: using "docker" namespace %s`, description, name)
+				if ret := value(ns); ret != "" {
 					return ret
 				}
 			}
@@ -218,8 +285,8 @@ func (config *registryConfiguration) signatureTopLevel(ref dockerReference, writ
 	}
 	// Look for a default location
 	if config.DefaultDocker != nil {
-		logrus.Debugf(` Lookaside configuration: using "default-docker" configuration`)
-		if ret := config.DefaultDocker.signatureTopLevel(write); ret != "" {
+		logger.Debugf(` %s: using "default-docker" configuration`, description)
+		if ret := value(*config.DefaultDocker); ret != "" {
 			return ret
 		}
 	}
@@ -228,32 +295,48 @@ func (config *registryConfiguration) signatureTopLevel(ref dockerReference, writ
 
 // config.useSigstoreAttachments returns whether we should look for and write sigstore attachments.
 // for ref.
-func (config *registryConfiguration) useSigstoreAttachments(ref dockerReference) bool {
+func (config *registryConfiguration) useSigstoreAttachments(logger logrus.FieldLogger, ref dockerReference) bool {
+	return config.namespaceBool(logger, ref, "Sigstore attachments", func(ns registryNamespace) *bool {
+		return ns.UseSigstoreAttachments
+	})
+}
+
+// config.writeHTTPLookaside returns whether signatures for ref may be written to, and deleted from, http(s) signature storage.
+func (config *registryConfiguration) writeHTTPLookaside(logger logrus.FieldLogger, ref dockerReference) bool {
+	return config.namespaceBool(logger, ref, "Writing http(s) lookaside", func(ns registryNamespace) *bool {
+		return ns.WriteHTTPLookaside
+	})
+}
+
+// config.namespaceBool returns the first non-nil result of value for the namespaces configured in config which match ref,
+// from the most specific one to the default-docker configuration, or false if there is none.
+// description is used in debug logs, written using logger.
+func (config *registryConfiguration) namespaceBool(logger logrus.FieldLogger, ref dockerReference, description string, value func(ns registryNamespace) *bool) bool {
 	if config.Docker != nil {
 		// Look for a full match.
 		identity := ref.PolicyConfigurationIdentity()
 		if ns, ok := config.Docker[identity]; ok {
-			logrus.Debugf(` Sigstore attachments: using "docker" namespace %s`, identity)
-			if ns.UseSigstoreAttachments != nil {
-				return *ns.UseSigstoreAttachments
+			logger.Debugf(` %s: using "docker" namespace %s`, description, identity)
+			if ret := value(ns); ret != nil {
+				return *ret
 			}
 		}
 
 		// Look for a match of the possible parent namespaces.
 		for _, name := range ref.PolicyConfigurationNamespaces() {
 			if ns, ok := config.Docker[name]; ok {
-				logrus.Debugf(` Sigstore attachments: using "docker" namespace %s`, name)
-				if ns.UseSigstoreAttachments != nil {
-					return *ns.UseSigstoreAttachments
+				logger.Debugf(` %s: using "docker" namespace %s`, description, name)
+				if ret := value(ns); ret != nil {
+					return *ret
 				}
 			}
 		}
 	}
 	// Look for a default location
 	if config.DefaultDocker != nil {
-		logrus.Debugf(` Sigstore attachments: using "default-docker" configuration`)
-		if config.DefaultDocker.UseSigstoreAttachments != nil {
-			return *config.DefaultDocker.UseSigstoreAttachments
+		logger.Debugf(` %s: using "default-docker" configuration`, description)
+		if ret := value(*config.DefaultDocker); ret != nil {
+			return *ret
 		}
 	}
 	return false
@@ -261,28 +344,37 @@ func (config *registryConfiguration) useSigstoreAttachments(ref dockerReference)
 
 // ns.signatureTopLevel returns an URL string configured in ns for ref, for write access if “write”.
 // or "" if nothing has been configured.
-func (ns registryNamespace) signatureTopLevel(write bool) string {
+func (ns registryNamespace) signatureTopLevel(logger logrus.FieldLogger, write bool) string {
 	if write {
-		if ns.LookasideStaging != "" {
-			logrus.Debugf(`  Using "lookaside-staging" %s`, ns.LookasideStaging)
-			return ns.LookasideStaging
-		}
-		if ns.SigStoreStaging != "" {
-			logrus.Debugf(`  Using "sigstore-staging" %s`, ns.SigStoreStaging)
-			return ns.SigStoreStaging
+		if ret := ns.signatureStagingTopLevel(logger); ret != "" {
+			return ret
 		}
 	}
 	if ns.Lookaside != "" {
-		logrus.Debugf(`  Using "lookaside" %s`, ns.Lookaside)
+		logger.Debugf(`  Using "lookaside" %s`, ns.Lookaside)
 		return ns.Lookaside
 	}
 	if ns.SigStore != "" {
-		logrus.Debugf(`  Using "sigstore" %s`, ns.SigStore)
+		logger.Debugf(`  Using "sigstore" %s`, ns.SigStore)
 		return ns.SigStore
 	}
 	return ""
 }
 
+// ns.signatureStagingTopLevel returns an URL string of the staging signature storage configured in ns,
+// or "" if nothing has been configured.
+func (ns registryNamespace) signatureStagingTopLevel(logger logrus.FieldLogger) string {
+	if ns.LookasideStaging != "" {
+		logger.Debugf(`  Using "lookaside-staging" %s`, ns.LookasideStaging)
+		return ns.LookasideStaging
+	}
+	if ns.SigStoreStaging != "" {
+		logger.Debugf(`  Using "sigstore-staging" %s`, ns.SigStoreStaging)
+		return ns.SigStoreStaging
+	}
+	return ""
+}
+
 // lookasideStorageURL returns an URL usable for accessing signature index in base with known manifestDigest.
 // base is not nil from the caller
 // NOTE: Keep this in sync with docs/signature-protocols.md!
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -252,9 +253,9 @@ func TestRegistryConfigurationSignatureTopLevel(t *testing.T) {
 	} {
 		dr := dockerRefFromString(t, "//"+c.input)
 
-		res := config.signatureTopLevel(dr, false)
+		res := config.signatureTopLevel(logrus.StandardLogger(), dr, false)
 		assert.Equal(t, c.expected, res, c.input)
-		res = config.signatureTopLevel(dr, true) // test that forWriting is correctly propagated
+		res = config.signatureTopLevel(logrus.StandardLogger(), dr, true) // test that forWriting is correctly propagated
 		assert.Equal(t, c.expected+"+w", res, c.input)
 	}
 
@@ -264,12 +265,58 @@ func TestRegistryConfigurationSignatureTopLevel(t *testing.T) {
 		},
 	}
 	dr := dockerRefFromString(t, "//thisisnotmatched")
-	res := config.signatureTopLevel(dr, false)
+	res := config.signatureTopLevel(logrus.StandardLogger(), dr, false)
 	assert.Equal(t, "", res)
-	res = config.signatureTopLevel(dr, true)
+	res = config.signatureTopLevel(logrus.StandardLogger(), dr, true)
 	assert.Equal(t, "", res)
 }
 
+func TestRegistryConfigurationLookasideStagingBaseURLForReading(t *testing.T) {
+	config := registryConfiguration{
+		DefaultDocker: &registryNamespace{Lookaside: "https://lookaside.example.com", LookasideStaging: "file:///default-staging"},
+		Docker: map[string]registryNamespace{
+			"example.com":             {ReadLookasideStaging: "after"},
+			"example.com/before":      {LookasideStaging: "file:///before-staging", ReadLookasideStaging: "before"},
+			"example.com/sigstore":    {SigStoreStaging: "file:///sigstore-staging"},
+			"example.com/invalid":     {ReadLookasideStaging: "always"},
+			"example.com/invalid-url": {LookasideStaging: "file:///\x00", ReadLookasideStaging: "after"},
+		},
+	}
+	for _, c := range []struct {
+		ref         string
+		expected    string // "" if the staging storage should not be read
+		first       bool
+		expectError bool
+	}{
+		{"//not.example.com/busybox", "", false, false},
+		{"//example.com/busybox", "file:///default-staging/busybox", false, false},
+		{"//example.com/before/repo", "file:///before-staging/before/repo", true, false},
+		{"//example.com/sigstore/repo", "file:///sigstore-staging/sigstore/repo", false, false},
+		{"//example.com/invalid/repo", "", false, true},
+		{"//example.com/invalid-url/repo", "", false, true},
+	} {
+		res, first, err := config.lookasideStagingBaseURLForReading(logrus.StandardLogger(), dockerRefFromString(t, c.ref))
+		if c.expectError {
+			assert.Error(t, err, c.ref)
+			continue
+		}
+		require.NoError(t, err, c.ref)
+		if c.expected == "" {
+			assert.Nil(t, res, c.ref)
+		} else {
+			require.NotNil(t, res, c.ref)
+			assert.Equal(t, c.expected, res.String(), c.ref)
+			assert.Equal(t, c.first, first, c.ref)
+		}
+	}
+
+	// No staging storage is configured.
+	config = registryConfiguration{DefaultDocker: &registryNamespace{Lookaside: "https://lookaside.example.com", ReadLookasideStaging: "after"}}
+	res, _, err := config.lookasideStagingBaseURLForReading(logrus.StandardLogger(), dockerRefFromString(t, "//example.com/busybox"))
+	require.NoError(t, err)
+	assert.Nil(t, res)
+}
+
 func TestRegistryNamespaceSignatureTopLevel(t *testing.T) {
 	for _, c := range []struct {
 		ns         registryNamespace
@@ -295,7 +342,7 @@ func TestRegistryNamespaceSignatureTopLevel(t *testing.T) {
 		{registryNamespace{Lookaside: "b", SigStore: "d"}, false, "b"},
 		{registryNamespace{SigStore: "d"}, false, "d"},
 	} {
-		res := c.ns.signatureTopLevel(c.forWriting)
+		res := c.ns.signatureTopLevel(logrus.StandardLogger(), c.forWriting)
 		assert.Equal(t, c.expected, res, fmt.Sprintf("%#v %v", c.ns, c.forWriting))
 	}
 }
@@ -5,6 +5,7 @@ package docker
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 )
 
@@ -60,17 +61,35 @@ func init() {
 	}
 }
 
+// parseAuthHeader returns the challenges in all WWW-Authenticate headers in header.
+// Bearer challenges are returned first, because we prefer them over other schemes (notably Basic) if a server offers several.
 func parseAuthHeader(header http.Header) []challenge {
 	challenges := []challenge{}
 	for _, h := range header[http.CanonicalHeaderKey("WWW-Authenticate")] {
-		v, p := parseValueAndParams(h)
-		if v != "" {
-			challenges = append(challenges, challenge{Scheme: v, Parameters: p})
-		}
+		challenges = append(challenges, parseChallenges(h)...)
 	}
+	sort.SliceStable(challenges, func(i, j int) bool {
+		return challenges[i].Scheme == "bearer" && challenges[j].Scheme != "bearer"
+	})
 	return challenges
 }
 
+// parseChallenges returns the challenges in a single WWW-Authenticate header value, which may contain more than one.
+func parseChallenges(header string) []challenge {
+	challenges := []challenge{}
+	s := header
+	for {
+		s = skipSpace(strings.TrimLeft(skipSpace(s), ","))
+		var value string
+		var params map[string]string
+		value, params, s = parseValueAndParams(s)
+		if value == "" {
+			return challenges
+		}
+		challenges = append(challenges, challenge{Scheme: value, Parameters: params})
+	}
+}
+
 // parseAuthScope parses an authentication scope string of the form `$resource:$remote:$actions`
 func parseAuthScope(scopeStr string) (*authScope, error) {
 	if parts := strings.Split(scopeStr, ":"); len(parts) == 3 {
@@ -83,37 +102,43 @@ func parseAuthScope(scopeStr string) (*authScope, error) {
 	return nil, fmt.Errorf("error parsing auth scope: '%s'", scopeStr)
 }
 
+// parseValueAndParams parses a single challenge at the start of header, and returns the rest of header, which
+// may contain further challenges.
 // NOTE: This is not a fully compliant parser per RFC 7235:
-// Most notably it does not support more than one challenge within a single header
-// Some of the whitespace parsing also seems noncompliant.
+// Most notably it does not support the token68 syntax, and
+// some of the whitespace parsing also seems noncompliant.
 // But it is clearly better than what we used to have…
-func parseValueAndParams(header string) (value string, params map[string]string) {
+func parseValueAndParams(header string) (value string, params map[string]string, rest string) {
 	params = make(map[string]string)
 	value, s := expectToken(header)
 	if value == "" {
-		return
+		return "", params, ""
 	}
 	value = strings.ToLower(value)
-	s = "," + skipSpace(s)
-	for strings.HasPrefix(s, ",") {
-		var pkey string
-		pkey, s = expectToken(skipSpace(s[1:]))
+	s = skipSpace(s)
+	for {
+		// An auth-param is "token=value"; a token not followed by "=" is the scheme of the next challenge.
+		pkey, afterKey := expectToken(s)
 		if pkey == "" {
-			return
+			// Either the end of header, or the start of the next challenge after a comma, or invalid input,
+			// which makes the caller stop.
+			return value, params, s
 		}
-		if !strings.HasPrefix(s, "=") {
-			return
+		afterKey = skipSpace(afterKey)
+		if !strings.HasPrefix(afterKey, "=") {
+			return value, params, s
 		}
-		var pvalue string
-		pvalue, s = expectTokenOrQuoted(s[1:])
+		pvalue, afterValue := expectTokenOrQuoted(skipSpace(afterKey[1:]))
 		if pvalue == "" {
-			return
+			return value, params, ""
+		}
+		params[strings.ToLower(pkey)] = pvalue
+		s = skipSpace(afterValue)
+		if !strings.HasPrefix(s, ",") {
+			return value, params, s
 		}
-		pkey = strings.ToLower(pkey)
-		params[pkey] = pvalue
-		s = skipSpace(s)
+		s = skipSpace(s[1:])
 	}
-	return
 }
 
 func skipSpace(s string) (rest string) {
@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -38,8 +39,87 @@ func TestParseValueAndParams(t *testing.T) {
 			map[string]string{"realm": "http://127.0.0.1:5000/openshift/token"},
 		},
 	} {
-		scope, params := parseValueAndParams(c.input)
+		scope, params, rest := parseValueAndParams(c.input)
 		assert.Equal(t, c.scope, scope, c.input)
 		assert.Equal(t, c.params, params, c.input)
+		assert.Equal(t, "", rest, c.input)
+	}
+}
+
+func TestParseAuthHeader(t *testing.T) {
+	for _, c := range []struct {
+		headers  []string
+		expected []challenge
+	}{
+		{ // No header
+			[]string{},
+			[]challenge{},
+		},
+		{ // A single challenge, with a comma in a quoted parameter
+			[]string{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/busybox:pull,push"`},
+			[]challenge{{Scheme: "bearer", Parameters: map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:library/busybox:pull,push",
+			}}},
+		},
+		{ // Basic and Bearer in separate headers; Bearer is preferred
+			[]string{`Basic realm="Registry Realm"`, `Bearer realm="https://registry.example.com/token",service="registry.example.com"`},
+			[]challenge{
+				{Scheme: "bearer", Parameters: map[string]string{"realm": "https://registry.example.com/token", "service": "registry.example.com"}},
+				{Scheme: "basic", Parameters: map[string]string{"realm": "Registry Realm"}},
+			},
+		},
+		{ // Basic and Bearer in a single header, as sent by Artifactory
+			[]string{`Basic realm="Artifactory Realm", Bearer realm="https://artifactory.example.com/artifactory/api/docker/docker/v2/token",service="artifactory.example.com"`},
+			[]challenge{
+				{Scheme: "bearer", Parameters: map[string]string{
+					"realm":   "https://artifactory.example.com/artifactory/api/docker/docker/v2/token",
+					"service": "artifactory.example.com",
+				}},
+				{Scheme: "basic", Parameters: map[string]string{"realm": "Artifactory Realm"}},
+			},
+		},
+		{ // Bearer with an insufficient_scope error, followed by Basic
+			[]string{`Bearer realm="https://harbor.example.com/service/token",service="harbor-registry",error="insufficient_scope",scope="repository:library/alpine:pull,push", Basic realm="harbor"`},
+			[]challenge{
+				{Scheme: "bearer", Parameters: map[string]string{
+					"realm":   "https://harbor.example.com/service/token",
+					"service": "harbor-registry",
+					"error":   "insufficient_scope",
+					"scope":   "repository:library/alpine:pull,push",
+				}},
+				{Scheme: "basic", Parameters: map[string]string{"realm": "harbor"}},
+			},
+		},
+		{ // Unquoted values, extra whitespace, and a scheme without parameters
+			[]string{`Negotiate, Basic realm=nexus ,  Bearer realm = "https://nexus.example.com/token" , service=nexus`},
+			[]challenge{
+				{Scheme: "bearer", Parameters: map[string]string{"realm": "https://nexus.example.com/token", "service": "nexus"}},
+				{Scheme: "negotiate", Parameters: map[string]string{}},
+				{Scheme: "basic", Parameters: map[string]string{"realm": "nexus"}},
+			},
+		},
+		{ // Escaped quotes in a quoted parameter
+			[]string{`Basic realm="a \"quoted\", realm", Bearer realm="https://example.com/token"`},
+			[]challenge{
+				{Scheme: "bearer", Parameters: map[string]string{"realm": "https://example.com/token"}},
+				{Scheme: "basic", Parameters: map[string]string{"realm": `a "quoted", realm`}},
+			},
+		},
+		{ // An unterminated quoted parameter ends parsing
+			[]string{`Bearer realm="https://example.com/token", Basic realm="unterminated, Negotiate`},
+			[]challenge{
+				{Scheme: "bearer", Parameters: map[string]string{"realm": "https://example.com/token"}},
+				{Scheme: "basic", Parameters: map[string]string{}},
+			},
+		},
+	} {
+		header := http.Header{}
+		for _, h := range c.headers {
+			header.Add("WWW-Authenticate", h)
+		}
+		res := parseAuthHeader(header)
+		assert.Equal(t, c.expected, res, c.headers)
 	}
 }
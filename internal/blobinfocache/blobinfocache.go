@@ -1,6 +1,8 @@
 package blobinfocache
 
 import (
+	"fmt"
+
 	"github.com/containers/image/v5/pkg/compression"
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
 	"github.com/containers/image/v5/types"
@@ -43,6 +45,42 @@ func CandidateLocationsFromV2(v2candidates []BICReplacementCandidate2) []types.B
 	return candidates
 }
 
+// DigestsAreRecordable returns true if blob info caches record data about digests.
+// The caches only record digests using digest.Canonical: DiffIDs are always computed using digest.Canonical,
+// and substituting a blob with a digest using a different algorithm would silently change the algorithm used by an image.
+func DigestsAreRecordable(digests ...digest.Digest) bool {
+	for _, d := range digests {
+		if d.Algorithm() != digest.Canonical {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateContents returns an error if any of the digests in contents is invalid, e.g. because contents was read
+// from a corrupted or hand-edited file; such contents must not be imported.
+func ValidateContents(contents *Contents) error {
+	for anyDigest, uncompressed := range contents.UncompressedDigests {
+		if err := anyDigest.Validate(); err != nil {
+			return fmt.Errorf("invalid digest %q: %w", anyDigest, err)
+		}
+		if err := uncompressed.Validate(); err != nil {
+			return fmt.Errorf("invalid uncompressed digest %q of %q: %w", uncompressed, anyDigest, err)
+		}
+	}
+	for d := range contents.Compressors {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("invalid digest %q: %w", d, err)
+		}
+	}
+	for _, l := range contents.KnownLocations {
+		if err := l.Digest.Validate(); err != nil {
+			return fmt.Errorf("invalid digest %q of a known location: %w", l.Digest, err)
+		}
+	}
+	return nil
+}
+
 // OperationAndAlgorithmForCompressor returns CompressionOperation and CompressionAlgorithm
 // values suitable for inclusion in a types.BlobInfo structure, based on the name of the
 // compression algorithm, or Uncompressed, or UnknownCompression.  This is typically used by
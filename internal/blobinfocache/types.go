@@ -1,6 +1,8 @@
 package blobinfocache
 
 import (
+	"time"
+
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
 )
@@ -43,3 +45,31 @@ type BICReplacementCandidate2 struct {
 	CompressorName string // either the Name() of a known pkg/compression.Algorithm, or Uncompressed or UnknownCompression
 	Location       types.BICLocationReference
 }
+
+// BlobInfoCacheWithContents is a BlobInfoCache2 which can also export all of the data it has recorded,
+// and import such data, e.g. to pre-seed a cache on a new machine.
+type BlobInfoCacheWithContents interface {
+	BlobInfoCache2
+	// Export returns all data recorded in the cache.
+	Export() (*Contents, error)
+	// Import records all of contents in the cache, in addition to any data already recorded.
+	// A known location which is already recorded is only updated if contents contains a more recent LastSeen value.
+	// WARNING: Only import data from a trusted source, see RecordDigestUncompressedPair and RecordDigestCompressorName.
+	Import(contents *Contents) error
+}
+
+// Contents is all data recorded in a blob info cache, as returned by BlobInfoCacheWithContents.Export.
+type Contents struct {
+	UncompressedDigests map[digest.Digest]digest.Digest `json:"uncompressedDigests,omitempty"` // anyDigest → its uncompressed digest
+	Compressors         map[digest.Digest]string        `json:"compressors,omitempty"`         // anyDigest → a compressor name, or Uncompressed; never UnknownCompression
+	KnownLocations      []KnownLocation                 `json:"knownLocations,omitempty"`
+}
+
+// KnownLocation is a single location of a blob recorded by BlobInfoCache.RecordKnownLocation.
+type KnownLocation struct {
+	Transport string                     `json:"transport"` // types.ImageTransport.Name()
+	Scope     types.BICTransportScope    `json:"scope"`
+	Digest    digest.Digest              `json:"digest"`
+	Location  types.BICLocationReference `json:"location"`
+	LastSeen  time.Time                  `json:"lastSeen"`
+}
@@ -348,7 +348,9 @@ func TestManifestSchema1Inspect(t *testing.T) {
 					Annotations: emptyAnnotations,
 				},
 			},
-			Author: "",
+			Author:     "",
+			User:       "nova",
+			ParsedUser: &types.ImageInspectUser{User: "nova"},
 			Env: []string{
 				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
 				"container=oci",
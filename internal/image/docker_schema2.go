@@ -111,6 +111,9 @@ func (m *manifestSchema2) ConfigBlob(ctx context.Context) ([]byte, error) {
 			return nil, err
 		}
 		computedDigest := digest.FromBytes(blob)
+		if algorithm := m.m.ConfigDescriptor.Digest.Algorithm(); algorithm != digest.Canonical && algorithm.Available() {
+			computedDigest = algorithm.FromBytes(blob)
+		}
 		if computedDigest != m.m.ConfigDescriptor.Digest {
 			return nil, fmt.Errorf("Download config.json digest %s does not match expected %s", computedDigest, m.m.ConfigDescriptor.Digest)
 		}
@@ -0,0 +1,82 @@
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxListNestingDepth is the maximum number of nested lists (e.g. an OCI index referring to another OCI index)
+// ChooseLeafInstance follows, and copy copies. Because instances are verified against their digests, a list can't refer to itself,
+// directly or indirectly; this limit only guards against unreasonably deep (or, in case of a bug, cyclic) structures.
+const MaxListNestingDepth = 8
+
+// ChooseLeafInstance chooses the instance of list, read from src, which is appropriate for sys, like
+// manifest.List.ChooseInstance. If the chosen instance is itself a list (a nested OCI index), the choice
+// is repeated within it, until an instance which is a single image is found. If no such image is found that way,
+// every other nested list in list is searched, in order.
+// It returns the digest of that instance, its manifest, and the manifest’s MIME type.
+func ChooseLeafInstance(ctx context.Context, sys *types.SystemContext, src types.ImageSource, list manifest.List) (digest.Digest, []byte, string, error) {
+	return chooseLeafInstance(ctx, sys, src, list, 0)
+}
+
+// chooseLeafInstance implements ChooseLeafInstance for a list nested depth levels deep.
+func chooseLeafInstance(ctx context.Context, sys *types.SystemContext, src types.ImageSource, list manifest.List, depth int) (digest.Digest, []byte, string, error) {
+	chosenDigest, firstErr := list.ChooseInstance(sys)
+	if firstErr == nil {
+		instanceDigest, manblob, mt, err := leafInstanceOf(ctx, sys, src, chosenDigest, depth)
+		if err == nil {
+			return instanceDigest, manblob, mt, nil
+		}
+		firstErr = err
+	}
+	for _, d := range list.Instances() {
+		if d == chosenDigest {
+			continue // Already tried above
+		}
+		instance, err := list.Instance(d)
+		if err != nil {
+			return "", nil, "", err
+		}
+		if !manifest.MIMETypeIsMultiImage(instance.MediaType) {
+			continue
+		}
+		instanceDigest, manblob, mt, err := leafInstanceOf(ctx, sys, src, d, depth)
+		if err == nil {
+			return instanceDigest, manblob, mt, nil
+		}
+		logrus.Debugf("No suitable image found in nested manifest list %s: %v", d, err)
+	}
+	return "", nil, "", firstErr
+}
+
+// leafInstanceOf reads the instance with instanceDigest of a list nested depth levels deep, from src, and if it is itself a list,
+// chooses an image from it using chooseLeafInstance.
+func leafInstanceOf(ctx context.Context, sys *types.SystemContext, src types.ImageSource, instanceDigest digest.Digest, depth int) (digest.Digest, []byte, string, error) {
+	manblob, mt, err := src.GetManifest(ctx, &instanceDigest)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("fetching instance %s: %w", instanceDigest, err)
+	}
+	matches, err := manifest.MatchesDigest(manblob, instanceDigest)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("computing manifest digest: %w", err)
+	}
+	if !matches {
+		return "", nil, "", fmt.Errorf("Image manifest does not match selected manifest digest %s", instanceDigest)
+	}
+	if !manifest.MIMETypeIsMultiImage(mt) {
+		return instanceDigest, manblob, mt, nil
+	}
+	if depth+1 >= MaxListNestingDepth {
+		return "", nil, "", fmt.Errorf("instance %s is a manifest list nested more than %d levels deep", instanceDigest, MaxListNestingDepth)
+	}
+	nested, err := manifest.ListFromBlob(manblob, mt)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("parsing nested manifest list %s: %w", instanceDigest, err)
+	}
+	return chooseLeafInstance(ctx, sys, src, nested, depth+1)
+}
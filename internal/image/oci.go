@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/iolimits"
@@ -73,6 +74,9 @@ func (m *manifestOCI1) ConfigBlob(ctx context.Context) ([]byte, error) {
 			return nil, err
 		}
 		computedDigest := digest.FromBytes(blob)
+		if algorithm := m.m.Config.Digest.Algorithm(); algorithm != digest.Canonical && algorithm.Available() {
+			computedDigest = algorithm.FromBytes(blob)
+		}
 		if computedDigest != m.m.Config.Digest {
 			return nil, fmt.Errorf("Download config.json digest %s does not match expected %s", computedDigest, m.m.Config.Digest)
 		}
@@ -198,7 +202,7 @@ func (m *manifestOCI1) convertToManifestSchema2Generic(ctx context.Context, opti
 // It may use options.InformationOnly and also adjust *options to be appropriate for editing the returned
 // value.
 // This does not change the state of the original manifestOCI1 object.
-func (m *manifestOCI1) convertToManifestSchema2(_ context.Context, _ *types.ManifestUpdateOptions) (*manifestSchema2, error) {
+func (m *manifestOCI1) convertToManifestSchema2(_ context.Context, options *types.ManifestUpdateOptions) (*manifestSchema2, error) {
 	if m.m.Config.MediaType != imgspecv1.MediaTypeImageConfig {
 		return nil, internalManifest.NewNonImageArtifactError(m.m.Config.MediaType)
 	}
@@ -214,6 +218,11 @@ func (m *manifestOCI1) convertToManifestSchema2(_ context.Context, _ *types.Mani
 	layers := make([]manifest.Schema2Descriptor, len(m.m.Layers))
 	for idx := range layers {
 		layers[idx] = schema2DescriptorFromOCI1Descriptor(m.m.Layers[idx])
+		// Encrypted layers can't be represented in schema2, but layers which are being decrypted
+		// will be updated to the plaintext form by options.LayerInfos.
+		if options != nil && len(options.LayerInfos) == len(layers) && options.LayerInfos[idx].CryptoOperation == types.Decrypt {
+			layers[idx].MediaType = strings.TrimSuffix(layers[idx].MediaType, "+encrypted")
+		}
 		switch layers[idx].MediaType {
 		case imgspecv1.MediaTypeImageLayerNonDistributable:
 			layers[idx].MediaType = manifest.DockerV2Schema2ForeignLayerMediaType
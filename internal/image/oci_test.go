@@ -321,7 +321,9 @@ func TestManifestOCI1Inspect(t *testing.T) {
 			Annotations: emptyAnnotations,
 		},
 		},
-		Author: "",
+		Author:     "",
+		User:       "",
+		ParsedUser: &types.ImageInspectUser{},
 		Env: []string{
 			"PATH=/usr/local/apache2/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
 			"HTTPD_PREFIX=/usr/local/apache2",
@@ -5,7 +5,9 @@ package image
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/types"
 )
 
@@ -132,3 +134,23 @@ func (i *SourcedImage) Manifest(ctx context.Context) ([]byte, string, error) {
 func (i *SourcedImage) LayerInfosForCopy(ctx context.Context) ([]types.BlobInfo, error) {
 	return i.UnparsedImage.src.LayerInfosForCopy(ctx, i.UnparsedImage.instanceDigest)
 }
+
+// Inspect overrides the Inspect of genericManifest to also include metadata recorded by the source, if any.
+func (i *SourcedImage) Inspect(ctx context.Context) (*types.ImageInspectInfo, error) {
+	info, err := i.genericManifest.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if src, ok := i.UnparsedImage.src.(private.ImageSourceWithMetadata); ok && i.UnparsedImage.instanceDigest == nil {
+		metadata, err := src.ImageMetadata(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("reading image metadata: %w", err)
+		}
+		info.RepoTags = metadata.RepoTags
+		info.RepoDigests = metadata.RepoDigests
+		if info.Created == nil {
+			info.Created = metadata.Created
+		}
+	}
+	return info, nil
+}
@@ -2,15 +2,19 @@ package image
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/imagesource"
+	"github.com/containers/image/v5/internal/iolimits"
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // UnparsedImage implements types.UnparsedImage .
@@ -117,3 +121,87 @@ func (i *UnparsedImage) UntrustedSignatures(ctx context.Context) ([]signature.Si
 	}
 	return i.cachedSignatures, nil
 }
+
+// UntrustedReferrers returns the artifacts with artifactType which refer to this image, or an empty list if the source
+// does not support listing referrers. The contents of the artifacts have not been verified in any way.
+func (i *UnparsedImage) UntrustedReferrers(ctx context.Context, artifactType string) ([]private.Referrer, error) {
+	src, ok := i.src.(private.ImageSourceWithReferrers)
+	if !ok {
+		return []private.Referrer{}, nil
+	}
+	manifestDigest, haveDigest := i.expectedManifestDigest()
+	if !haveDigest {
+		m, _, err := i.Manifest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		manifestDigest, err = manifest.Digest(m)
+		if err != nil {
+			return nil, fmt.Errorf("computing manifest digest: %w", err)
+		}
+	}
+	descriptors, err := src.GetReferrers(ctx, manifestDigest, artifactType)
+	if err != nil {
+		return nil, fmt.Errorf("listing referrers of %s: %w", manifestDigest.String(), err)
+	}
+
+	res := []private.Referrer{}
+	for _, desc := range descriptors {
+		referrer, err := i.readReferrer(ctx, desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("reading referrer %s: %w", desc.Digest.String(), err)
+		}
+		res = append(res, referrer)
+	}
+	return res, nil
+}
+
+// readReferrer returns the artifact with manifestDigest, including the contents of its layers.
+func (i *UnparsedImage) readReferrer(ctx context.Context, manifestDigest digest.Digest) (private.Referrer, error) {
+	manifestBlob, mimeType, err := i.src.GetManifest(ctx, &manifestDigest)
+	if err != nil {
+		return private.Referrer{}, err
+	}
+	matches, err := manifest.MatchesDigest(manifestBlob, manifestDigest)
+	if err != nil {
+		return private.Referrer{}, fmt.Errorf("computing manifest digest: %w", err)
+	}
+	if !matches {
+		return private.Referrer{}, fmt.Errorf("manifest does not match digest %s", manifestDigest.String())
+	}
+	if mimeType != imgspecv1.MediaTypeImageManifest {
+		return private.Referrer{}, fmt.Errorf("unexpected MIME type %q", mimeType)
+	}
+	res := private.Referrer{Digest: manifestDigest}
+	if err := json.Unmarshal(manifestBlob, &res.Manifest); err != nil {
+		return private.Referrer{}, fmt.Errorf("parsing manifest: %w", err)
+	}
+	for _, layer := range res.Manifest.Layers {
+		if err := layer.Digest.Validate(); err != nil {
+			return private.Referrer{}, err
+		}
+		blob, err := i.readReferrerLayer(ctx, layer)
+		if err != nil {
+			return private.Referrer{}, err
+		}
+		res.Layers = append(res.Layers, blob)
+	}
+	return res, nil
+}
+
+// readReferrerLayer returns the contents of layer of a referrer, verifying its digest.
+func (i *UnparsedImage) readReferrerLayer(ctx context.Context, layer imgspecv1.Descriptor) ([]byte, error) {
+	stream, _, err := i.src.GetBlob(ctx, types.BlobInfo{Digest: layer.Digest, Size: layer.Size}, none.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	blob, err := iolimits.ReadAtMost(stream, iolimits.MaxSignatureBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("reading layer %s: %w", layer.Digest.String(), err)
+	}
+	if actual := layer.Digest.Algorithm().FromBytes(blob); actual != layer.Digest {
+		return nil, fmt.Errorf("layer %s does not match digest, got %s", layer.Digest.String(), actual.String())
+	}
+	return blob, nil
+}
@@ -0,0 +1,16 @@
+// Package logger provides access to the logger configured for an operation.
+package logger
+
+import (
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+)
+
+// FromSystemContext returns the logger to use for operations using sys:
+// sys.Logger if it is set, the global logrus logger otherwise.
+func FromSystemContext(sys *types.SystemContext) logrus.FieldLogger {
+	if sys != nil && sys.Logger != nil {
+		return sys.Logger
+	}
+	return logrus.StandardLogger()
+}
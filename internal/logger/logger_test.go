@@ -0,0 +1,21 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromSystemContext(t *testing.T) {
+	assert.Equal(t, logrus.StandardLogger(), FromSystemContext(nil))
+	assert.Equal(t, logrus.StandardLogger(), FromSystemContext(&types.SystemContext{}))
+
+	logger, hook := logrustest.NewNullLogger()
+	res := FromSystemContext(&types.SystemContext{Logger: logger})
+	assert.Equal(t, logger, res)
+	res.Warnf("message %d", 1)
+	assert.Equal(t, "message 1", hook.LastEntry().Message)
+}
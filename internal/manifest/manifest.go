@@ -0,0 +1,14 @@
+package manifest
+
+// FIXME: Move the rest of the MIME type definitions here, as in c/image/manifest.
+const (
+	// DockerV2Schema1MediaType MIME type represents Docker manifest schema 1
+	DockerV2Schema1MediaType = "application/vnd.docker.distribution.manifest.v1+json"
+	// DockerV2Schema1SignedMediaType MIME type represents Docker manifest schema 1 with a JWS signature
+	DockerV2Schema1SignedMediaType = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// IsSchema1MIMEType returns true if mimeType is one of the Docker schema1 manifest MIME types.
+func IsSchema1MIMEType(mimeType string) bool {
+	return mimeType == DockerV2Schema1SignedMediaType || mimeType == DockerV2Schema1MediaType
+}
@@ -0,0 +1,24 @@
+package manifest
+
+import (
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSchema1MIMEType(t *testing.T) {
+	for _, c := range []struct {
+		mimeType string
+		expected bool
+	}{
+		{DockerV2Schema1MediaType, true},
+		{DockerV2Schema1SignedMediaType, true},
+		{"application/vnd.docker.distribution.manifest.v2+json", false},
+		{"application/vnd.docker.distribution.manifest.list.v2+json", false},
+		{imgspecv1.MediaTypeImageManifest, false},
+		{"", false},
+	} {
+		assert.Equal(t, c.expected, IsSchema1MIMEType(c.mimeType), c.mimeType)
+	}
+}
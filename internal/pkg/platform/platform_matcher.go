@@ -123,6 +123,32 @@ var compatibility = map[string][]string{
 	"arm64": {"v8"},
 }
 
+// baseVariants contains, for a specified architecture, the variant implied by a platform which doesn’t specify one;
+// e.g. "arm" images without a variant are, by convention, built for v7, so they can’t run on a v6 CPU.
+// Architectures where a platform without a variant is compatible with all known variants should not have an entry here.
+var baseVariants = map[string]string{
+	"arm":   "v7",
+	"arm64": "v8",
+}
+
+// normalize returns the canonical form of arch and variant, accepting the alternative spellings
+// used by some tools and in /proc/cpuinfo (e.g. "aarch64", or "7" and "armv7" for "v7").
+func normalize(arch, variant string) (string, string) {
+	if arch == "aarch64" {
+		arch = "arm64"
+	}
+	if arch == "arm" || arch == "arm64" {
+		v := strings.TrimPrefix(strings.ToLower(variant), "arm")
+		switch v {
+		case "5", "6", "7", "8":
+			variant = "v" + v
+		case "v5", "v6", "v7", "v8":
+			variant = v
+		}
+	}
+	return arch, variant
+}
+
 // WantedPlatforms returns all compatible platforms with the platform specifics possibly overridden by user,
 // the most compatible platform is first.
 // If some option (arch, os, variant) is not present, a value from current platform is detected.
@@ -143,6 +169,8 @@ func WantedPlatforms(ctx *types.SystemContext) ([]imgspecv1.Platform, error) {
 		wantedVariant = ctx.VariantChoice
 	}
 
+	wantedArch, wantedVariant = normalize(wantedArch, wantedVariant)
+
 	wantedOS := runtime.GOOS
 	if ctx != nil && ctx.OSChoice != "" {
 		wantedOS = ctx.OSChoice
@@ -164,9 +192,23 @@ func WantedPlatforms(ctx *types.SystemContext) ([]imgspecv1.Platform, error) {
 		if variants == nil {
 			// user wants a variant which we know nothing about - not even compatibility
 			variants = []string{wantedVariant}
+			// Make sure to have a candidate with an empty variant as well.
+			variants = append(variants, "")
+		} else if baseVariant, ok := baseVariants[wantedArch]; ok {
+			// A platform with an empty variant is equivalent to baseVariant, so it is a candidate
+			// only if baseVariant is compatible, and it is preferred the same as baseVariant.
+			withEmpty := []string{}
+			for _, v := range variants {
+				withEmpty = append(withEmpty, v)
+				if v == baseVariant {
+					withEmpty = append(withEmpty, "")
+				}
+			}
+			variants = withEmpty
+		} else {
+			// Make sure to have a candidate with an empty variant as well.
+			variants = append(variants, "")
 		}
-		// Make sure to have a candidate with an empty variant as well.
-		variants = append(variants, "")
 	} else {
 		// Make sure to have a candidate with an empty variant as well.
 		variants = append(variants, "")
@@ -190,7 +232,8 @@ func WantedPlatforms(ctx *types.SystemContext) ([]imgspecv1.Platform, error) {
 // MatchesPlatform returns true if a platform descriptor from a multi-arch image matches
 // an item from the return value of WantedPlatforms.
 func MatchesPlatform(image imgspecv1.Platform, wanted imgspecv1.Platform) bool {
-	return image.Architecture == wanted.Architecture &&
+	imageArch, imageVariant := normalize(image.Architecture, image.Variant)
+	return imageArch == wanted.Architecture &&
 		image.OS == wanted.OS &&
-		image.Variant == wanted.Variant
+		imageVariant == wanted.Variant
 }
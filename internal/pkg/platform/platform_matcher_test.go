@@ -7,6 +7,7 @@ import (
 	"github.com/containers/image/v5/types"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWantedPlatforms(t *testing.T) {
@@ -25,7 +26,15 @@ func TestWantedPlatforms(t *testing.T) {
 			[]imgspecv1.Platform{
 				{OS: "linux", Architecture: "arm", Variant: "v6"},
 				{OS: "linux", Architecture: "arm", Variant: "v5"},
+			},
+		},
+		{ // ARM v7, an empty variant is treated as v7
+			types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "v7"},
+			[]imgspecv1.Platform{
+				{OS: "linux", Architecture: "arm", Variant: "v7"},
 				{OS: "linux", Architecture: "arm", Variant: ""},
+				{OS: "linux", Architecture: "arm", Variant: "v6"},
+				{OS: "linux", Architecture: "arm", Variant: "v5"},
 			},
 		},
 		{ // ARM without variant
@@ -45,6 +54,20 @@ func TestWantedPlatforms(t *testing.T) {
 				{OS: "linux", Architecture: "arm64", Variant: "v8"},
 			},
 		},
+		{ // Alternative spellings are normalized
+			types.SystemContext{ArchitectureChoice: "aarch64", OSChoice: "linux", VariantChoice: "armv8"},
+			[]imgspecv1.Platform{
+				{OS: "linux", Architecture: "arm64", Variant: "v8"},
+				{OS: "linux", Architecture: "arm64", Variant: ""},
+			},
+		},
+		{
+			types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "6"},
+			[]imgspecv1.Platform{
+				{OS: "linux", Architecture: "arm", Variant: "v6"},
+				{OS: "linux", Architecture: "arm", Variant: "v5"},
+			},
+		},
 		{ // Custom (completely unrecognized data)
 			types.SystemContext{ArchitectureChoice: "armel", OSChoice: "freeBSD", VariantChoice: "custom"},
 			[]imgspecv1.Platform{
@@ -59,3 +82,53 @@ func TestWantedPlatforms(t *testing.T) {
 		assert.Equal(t, c.expected, platforms, testName)
 	}
 }
+
+func TestARMCompatibility(t *testing.T) {
+	for _, c := range []struct {
+		wantedArch, wantedVariant string
+		imageArch, imageVariant   string
+		matches                   bool
+	}{
+		{"arm", "v5", "arm", "v5", true},
+		{"arm", "v5", "arm", "v6", false},
+		{"arm", "v5", "arm", "v7", false},
+		{"arm", "v5", "arm", "", false},
+		{"arm", "v6", "arm", "v5", true},
+		{"arm", "v6", "arm", "v6", true},
+		{"arm", "v6", "arm", "v7", false},
+		{"arm", "v6", "arm", "v8", false},
+		{"arm", "v6", "arm", "", false},
+		{"arm", "v7", "arm", "v5", true},
+		{"arm", "v7", "arm", "v6", true},
+		{"arm", "v7", "arm", "v7", true},
+		{"arm", "v7", "arm", "v8", false},
+		{"arm", "v7", "arm", "", true},
+		{"arm", "v8", "arm", "v5", true},
+		{"arm", "v8", "arm", "v7", true},
+		{"arm", "v8", "arm", "v8", true},
+		{"arm", "v8", "arm", "", true},
+		{"arm", "v7", "arm", "7", true},
+		{"arm", "v6", "arm", "armv7", false},
+		{"arm", "v7", "arm64", "v8", false},
+		{"arm64", "v8", "arm64", "v8", true},
+		{"arm64", "v8", "arm64", "", true},
+		{"arm64", "v8", "aarch64", "", true},
+		{"arm64", "v8", "arm64", "armv8", true},
+		{"arm64", "", "arm64", "v8", true},
+		{"arm64", "", "aarch64", "8", true},
+		{"aarch64", "", "arm64", "", true},
+		{"arm64", "v8", "arm", "v8", false},
+	} {
+		testName := fmt.Sprintf("%s/%s on %s/%s", c.imageArch, c.imageVariant, c.wantedArch, c.wantedVariant)
+		wanted, err := WantedPlatforms(&types.SystemContext{ArchitectureChoice: c.wantedArch, VariantChoice: c.wantedVariant, OSChoice: "linux"})
+		require.NoError(t, err, testName)
+		matches := false
+		for _, w := range wanted {
+			if MatchesPlatform(imgspecv1.Platform{OS: "linux", Architecture: c.imageArch, Variant: c.imageVariant}, w) {
+				matches = true
+				break
+			}
+		}
+		assert.Equal(t, c.matches, matches, testName)
+	}
+}
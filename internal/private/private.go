@@ -3,12 +3,14 @@ package private
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/blobinfocache"
 	"github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // ImageSourceInternalOnly is the part of private.ImageSource that is not
@@ -32,6 +34,38 @@ type ImageSource interface {
 	ImageSourceInternalOnly
 }
 
+// ImageSourceWithReferrers is an optional extension of ImageSource, implemented by transports which can list
+// the referrers of an image, i.e. artifacts which refer to it using the OCI "subject" field.
+type ImageSourceWithReferrers interface {
+	// GetReferrers returns descriptors of the manifests of artifacts with artifactType which refer to the manifest with manifestDigest,
+	// or of artifacts of all types if artifactType is "".
+	// It may use a remote (= slow) service.
+	GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error)
+}
+
+// ImageSourceWithMetadata is an optional extension of ImageSource, implemented by transports which record metadata
+// about the image outside of the image itself, e.g. the local docker daemon.
+type ImageSourceWithMetadata interface {
+	// ImageMetadata returns the metadata recorded by the transport about the image.
+	ImageMetadata(ctx context.Context) (ImageMetadata, error)
+}
+
+// ImageSourceWithBlobSizes is an optional extension of ImageSource, implemented by transports which can determine
+// the size of a blob without reading it, e.g. using a HEAD request.
+type ImageSourceWithBlobSizes interface {
+	// BlobSize returns the size of the blob described by info, without reading its contents, or -1 if it is unknown.
+	// The Digest field in BlobInfo is guaranteed to be provided, Size may be -1 and MediaType may be optionally provided.
+	// It may use a remote (= slow) service.
+	BlobSize(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (int64, error)
+}
+
+// ImageMetadata is metadata about an image recorded by a transport, as returned by ImageSourceWithMetadata.ImageMetadata.
+type ImageMetadata struct {
+	RepoTags    []string   // Names of the image, in the repository:tag form
+	RepoDigests []string   // Names of the image, in the repository@digest form
+	Created     *time.Time // When the image was created, or nil if unknown
+}
+
 // ImageDestinationInternalOnly is the part of private.ImageDestination that is not
 // a part of types.ImageDestination.
 type ImageDestinationInternalOnly interface {
@@ -72,6 +106,75 @@ type ImageDestinationInternalOnly interface {
 	PutSignaturesWithFormat(ctx context.Context, signatures []signature.Signature, instanceDigest *digest.Digest) error
 }
 
+// ImageDestinationWithDigestAlgorithms is an optional extension of ImageDestination, implemented by transports which can store
+// blobs and manifests with digests using algorithms other than digest.Canonical.
+type ImageDestinationWithDigestAlgorithms interface {
+	// SupportsDigestAlgorithm returns true if blobs and manifests with digests using algorithm can be stored,
+	// and PutBlobOptions.DigestAlgorithm can be set to algorithm.
+	SupportsDigestAlgorithm(algorithm digest.Algorithm) bool
+
+	// PutManifestWithDigestAlgorithm is PutManifest, except that if instanceDigest is nil, the digest of the manifest
+	// (e.g. as recorded in an index of the destination) is computed using algorithm instead of digest.Canonical.
+	// algorithm is either digest.Canonical or an algorithm for which SupportsDigestAlgorithm returned true.
+	PutManifestWithDigestAlgorithm(ctx context.Context, m []byte, instanceDigest *digest.Digest, algorithm digest.Algorithm) error
+}
+
+// ImageDestinationWithExistingImageSignatures is an optional extension of ImageDestination, implemented by transports which
+// can add signatures to an image already stored at the destination, without writing the image again.
+type ImageDestinationWithExistingImageSignatures interface {
+	// PutSignaturesForExistingImage writes a set of signatures for an existing manifest with manifestDigest,
+	// replacing the signatures already stored for it.
+	// Unlike PutSignaturesWithFormat, this does not require PutManifest to be called first.
+	PutSignaturesForExistingImage(ctx context.Context, signatures []signature.Signature, manifestDigest digest.Digest) error
+}
+
+// ImageDestinationWithAdditionalTags is an optional extension of ImageDestination, implemented by transports which can
+// store a manifest under additional tags, without writing the blobs it refers to again.
+type ImageDestinationWithAdditionalTags interface {
+	// PutManifestWithTag writes manifest m, which was already written using PutManifest with instanceDigest == nil,
+	// under tag in the same repository.
+	PutManifestWithTag(ctx context.Context, m []byte, tag string) error
+}
+
+// ImageDestinationWithReferrers is an optional extension of ImageDestination, implemented by transports which can store
+// manifests referring to another manifest using the OCI "subject" field (e.g. SBOMs or signatures), so that they can be
+// found as referrers of the subject.
+type ImageDestinationWithReferrers interface {
+	// PutReferrerManifest writes m, an OCI image manifest with a subject, which has manifestDigest, and records it as a referrer of the subject.
+	// The blobs m refers to must have already been written using PutBlobWithOptions.
+	// Unlike PutManifest, this does not affect the manifest of the image being written, and it can be called after it
+	// (but before Commit), whether or not the primary manifest is a manifest list.
+	PutReferrerManifest(ctx context.Context, m []byte, manifestDigest digest.Digest) error
+}
+
+// ImageReferenceWithBlobProbes is an optional extension of types.ImageReference, implemented by transports which can check
+// whether blobs exist at the destination without the side effects of NewImageDestination.
+type ImageReferenceWithBlobProbes interface {
+	// NewBlobProbe returns a BlobProbe for the destination of the reference, without creating or modifying anything.
+	// The caller must call .Close() on the returned BlobProbe.
+	NewBlobProbe(ctx context.Context, sys *types.SystemContext) (BlobProbe, error)
+}
+
+// ImageReferenceWithExistenceCheck is an optional extension of types.ImageReference, implemented by transports which can
+// tell an image which does not exist from an image which can't be accessed.
+type ImageReferenceWithExistenceCheck interface {
+	// ImageExists returns true if the referenced image exists, and false if it does not exist.
+	// It returns an error if that can't be determined, e.g. because the image can't be accessed.
+	ImageExists(ctx context.Context, sys *types.SystemContext) (bool, error)
+}
+
+// BlobProbe is a read-only view of an image destination, returned by ImageReferenceWithBlobProbes.
+type BlobProbe interface {
+	// BlobExists returns true if the destination already contains the blob with info.Digest, so that copying
+	// the blob would not upload it again.
+	// It must not mount, substitute or otherwise modify anything at the destination.
+	BlobExists(ctx context.Context, info types.BlobInfo) (bool, error)
+	// AcceptsForeignLayerURLs returns the value the destination's ImageDestination.AcceptsForeignLayerURLs would return.
+	AcceptsForeignLayerURLs() bool
+	// Close removes resources associated with the probe, if any.
+	Close() error
+}
+
 // ImageDestination is an internal extension to the types.ImageDestination
 // interface.
 type ImageDestination interface {
@@ -92,6 +195,9 @@ type PutBlobOptions struct {
 
 	EmptyLayer bool // True if the blob is an "empty"/"throwaway" layer, and may not necessarily be physically represented.
 	LayerIndex *int // If the blob is a layer, a zero-based index of the layer within the image; nil otherwise.
+	// If not "", the algorithm to use if the destination computes the digest of the blob, and to prefer over inputInfo.Digest
+	// if that uses a different algorithm. Destinations which don't implement ImageDestinationWithDigestAlgorithms may ignore it.
+	DigestAlgorithm digest.Algorithm
 }
 
 // TryReusingBlobOptions are used in TryReusingBlobWithOptions.
@@ -143,4 +249,14 @@ type UnparsedImage interface {
 	types.UnparsedImage
 	// UntrustedSignatures is like ImageSource.GetSignaturesWithFormat, but the result is cached; it is OK to call this however often you need.
 	UntrustedSignatures(ctx context.Context) ([]signature.Signature, error)
+	// UntrustedReferrers returns the artifacts with artifactType which refer to this image, or an empty list if the source
+	// does not support listing referrers. The contents of the artifacts have not been verified in any way.
+	UntrustedReferrers(ctx context.Context, artifactType string) ([]Referrer, error)
+}
+
+// Referrer is an artifact referring to an image, as returned by UnparsedImage.UntrustedReferrers.
+type Referrer struct {
+	Digest   digest.Digest      // The digest of the artifact manifest
+	Manifest imgspecv1.Manifest // The artifact manifest, matching Digest
+	Layers   [][]byte           // The contents of the layers of Manifest, matching their digests
 }
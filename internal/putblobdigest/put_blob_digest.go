@@ -13,15 +13,15 @@ type Digester struct {
 	digester    digest.Digester // Or nil
 }
 
-// newDigester initiates computation of a digest.Canonical digest of stream,
+// newDigester initiates computation of an algorithm digest of stream,
 // if !validDigest; otherwise it just records knownDigest to be returned later.
 // The caller MUST use the returned stream instead of the original value.
-func newDigester(stream io.Reader, knownDigest digest.Digest, validDigest bool) (Digester, io.Reader) {
+func newDigester(stream io.Reader, knownDigest digest.Digest, validDigest bool, algorithm digest.Algorithm) (Digester, io.Reader) {
 	if validDigest {
 		return Digester{knownDigest: knownDigest}, stream
 	} else {
 		res := Digester{
-			digester: digest.Canonical.Digester(),
+			digester: algorithm.Digester(),
 		}
 		stream = io.TeeReader(stream, res.digester.Hash())
 		return res, stream
@@ -34,7 +34,7 @@ func newDigester(stream io.Reader, knownDigest digest.Digest, validDigest bool)
 // The caller MUST use the returned stream instead of the original value.
 func DigestIfUnknown(stream io.Reader, blobInfo types.BlobInfo) (Digester, io.Reader) {
 	d := blobInfo.Digest
-	return newDigester(stream, d, d != "")
+	return newDigester(stream, d, d != "", digest.Canonical)
 }
 
 // DigestIfCanonicalUnknown initiates computation of a digest.Canonical digest of stream,
@@ -43,7 +43,19 @@ func DigestIfUnknown(stream io.Reader, blobInfo types.BlobInfo) (Digester, io.Re
 // The caller MUST use the returned stream instead of the original value.
 func DigestIfCanonicalUnknown(stream io.Reader, blobInfo types.BlobInfo) (Digester, io.Reader) {
 	d := blobInfo.Digest
-	return newDigester(stream, d, d != "" && d.Algorithm() == digest.Canonical)
+	return newDigester(stream, d, d != "" && d.Algorithm() == digest.Canonical, digest.Canonical)
+}
+
+// DigestIfAlgorithmUnknown initiates computation of an algorithm digest of stream,
+// if an algorithm digest is not supplied in the provided blobInfo; otherwise blobInfo.Digest will be used.
+// If algorithm is "", digest.Canonical is used. algorithm must be available.
+// The caller MUST use the returned stream instead of the original value.
+func DigestIfAlgorithmUnknown(stream io.Reader, blobInfo types.BlobInfo, algorithm digest.Algorithm) (Digester, io.Reader) {
+	if algorithm == "" {
+		algorithm = digest.Canonical
+	}
+	d := blobInfo.Digest
+	return newDigester(stream, d, d != "" && d.Algorithm() == algorithm, algorithm)
 }
 
 // Digest() returns a digest value possibly computed by Digester.
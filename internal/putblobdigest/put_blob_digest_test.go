@@ -72,3 +72,41 @@ func TestDigestIfCanonicalUnknown(t *testing.T) {
 		},
 	})
 }
+
+func TestDigestIfAlgorithmUnknown(t *testing.T) {
+	testDigester(t, func(r io.Reader, bi types.BlobInfo) (Digester, io.Reader) {
+		return DigestIfAlgorithmUnknown(r, bi, digest.SHA512)
+	}, []testCase{
+		{
+			inputDigest:    digest.Digest("sha512:uninspected-value"),
+			computesDigest: false,
+			expectedDigest: digest.Digest("sha512:uninspected-value"),
+		},
+		{
+			inputDigest:    digest.Digest("sha256:uninspected-value"),
+			computesDigest: true,
+			expectedDigest: digest.SHA512.FromBytes(testData),
+		},
+		{
+			inputDigest:    "",
+			computesDigest: true,
+			expectedDigest: digest.SHA512.FromBytes(testData),
+		},
+	})
+
+	// "" means digest.Canonical
+	testDigester(t, func(r io.Reader, bi types.BlobInfo) (Digester, io.Reader) {
+		return DigestIfAlgorithmUnknown(r, bi, "")
+	}, []testCase{
+		{
+			inputDigest:    digest.Digest("sha256:uninspected-value"),
+			computesDigest: false,
+			expectedDigest: digest.Digest("sha256:uninspected-value"),
+		},
+		{
+			inputDigest:    digest.Digest("sha512:uninspected-value"),
+			computesDigest: true,
+			expectedDigest: digest.Canonical.FromBytes(testData),
+		},
+	})
+}
@@ -3,6 +3,7 @@ package mocks
 import (
 	"context"
 
+	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/types"
 )
@@ -29,3 +30,8 @@ func (ref ForbiddenUnparsedImage) Signatures(context.Context) ([][]byte, error)
 func (ref ForbiddenUnparsedImage) UntrustedSignatures(ctx context.Context) ([]signature.Signature, error) {
 	panic("unexpected call to a mock function")
 }
+
+// UntrustedReferrers is a mock that panics.
+func (ref ForbiddenUnparsedImage) UntrustedReferrers(ctx context.Context, artifactType string) ([]private.Referrer, error) {
+	panic("unexpected call to a mock function")
+}
@@ -0,0 +1,148 @@
+// Package testimage creates images for tests.
+package testimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// Entry is an entry of a layer created by Tar.
+type Entry struct {
+	Header   tar.Header // Header.Size is set by Tar
+	Contents string
+}
+
+// File returns an Entry for a regular file name with contents.
+func File(name, contents string) Entry {
+	return Entry{Header: tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644}, Contents: contents}
+}
+
+// Tar returns an uncompressed tar stream containing entries.
+func Tar(t *testing.T, entries ...Entry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.Header
+		hdr.Size = int64(len(e.Contents))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(e.Contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// Gzip returns data compressed using gzip.
+func Gzip(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	_, err := gzw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	return buf.Bytes()
+}
+
+// Layer is a layer of an Image.
+type Layer struct {
+	Blob      []byte
+	MediaType string        // The MIME type of the layer in the manifest
+	DiffID    digest.Digest // The DiffID of the layer; if "", Blob is uncompressed and its digest is used
+}
+
+// Image describes an image written by PutBlobs or NewDirImage.
+type Image struct {
+	ManifestMIMEType string // manifest.DockerV2Schema2MediaType or imgspecv1.MediaTypeImageManifest
+	// Config is used as the image’s config; Architecture, OS and RootFS.Type default to amd64, linux and layers,
+	// and if RootFS.DiffIDs is nil, it is set to the DiffIDs of Layers.
+	Config imgspecv1.Image
+	Layers []Layer
+}
+
+// PutBlobs writes the config and the layers of img to dest, and returns the image’s manifest
+// (which is not written to dest) and the digest of its config.
+func PutBlobs(t *testing.T, dest types.ImageDestination, img Image) ([]byte, digest.Digest) {
+	config := img.Config
+	if config.Architecture == "" {
+		config.Architecture = "amd64"
+	}
+	if config.OS == "" {
+		config.OS = "linux"
+	}
+	if config.RootFS.Type == "" {
+		config.RootFS.Type = "layers"
+	}
+	setDiffIDs := config.RootFS.DiffIDs == nil
+	layerDescriptors := []imgspecv1.Descriptor{}
+	for _, layer := range img.Layers {
+		info := types.BlobInfo{Digest: digest.FromBytes(layer.Blob), Size: int64(len(layer.Blob))}
+		_, err := dest.PutBlob(context.Background(), bytes.NewReader(layer.Blob), info, none.NoCache, false)
+		require.NoError(t, err)
+		if setDiffIDs {
+			diffID := layer.DiffID
+			if diffID == "" {
+				diffID = info.Digest
+			}
+			config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+		}
+		layerDescriptors = append(layerDescriptors, imgspecv1.Descriptor{
+			MediaType: layer.MediaType,
+			Digest:    info.Digest,
+			Size:      info.Size,
+		})
+	}
+	configBlob, err := json.Marshal(config)
+	require.NoError(t, err)
+	configInfo := types.BlobInfo{Digest: digest.FromBytes(configBlob), Size: int64(len(configBlob))}
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(configBlob), configInfo, none.NoCache, true)
+	require.NoError(t, err)
+
+	var manBlob []byte
+	switch img.ManifestMIMEType {
+	case manifest.DockerV2Schema2MediaType:
+		schema2Layers := []manifest.Schema2Descriptor{}
+		for _, d := range layerDescriptors {
+			schema2Layers = append(schema2Layers, manifest.Schema2Descriptor{MediaType: d.MediaType, Size: d.Size, Digest: d.Digest})
+		}
+		manBlob, err = manifest.Schema2FromComponents(manifest.Schema2Descriptor{
+			MediaType: manifest.DockerV2Schema2ConfigMediaType,
+			Size:      configInfo.Size,
+			Digest:    configInfo.Digest,
+		}, schema2Layers).Serialize()
+	case imgspecv1.MediaTypeImageManifest:
+		manBlob, err = manifest.OCI1FromComponents(imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageConfig,
+			Digest:    configInfo.Digest,
+			Size:      configInfo.Size,
+		}, layerDescriptors).Serialize()
+	default:
+		require.FailNow(t, "Unsupported manifest MIME type", img.ManifestMIMEType)
+	}
+	require.NoError(t, err)
+	return manBlob, configInfo.Digest
+}
+
+// NewDirImage writes img to a new dir: directory, and returns a reference to it and the digest of its config.
+func NewDirImage(t *testing.T, img Image) (types.ImageReference, digest.Digest) {
+	ref, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	manBlob, configDigest := PutBlobs(t, dest, img)
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	return ref, configDigest
+}
@@ -36,3 +36,9 @@ func (w *wrapped) UntrustedSignatures(ctx context.Context) ([]signature.Signatur
 	}
 	return res, nil
 }
+
+// UntrustedReferrers returns the artifacts with artifactType which refer to this image.
+// types.UnparsedImage does not provide access to referrers, so this always returns an empty list.
+func (w *wrapped) UntrustedReferrers(ctx context.Context, artifactType string) ([]private.Referrer, error) {
+	return []private.Referrer{}, nil
+}
@@ -229,6 +229,20 @@ func compressionVariantsRecognizeMIMEType(variantTable []compressionMIMETypeSet,
 	return variants != nil // Alternatively, this could be len(variants) > 1, but really the caller should ask about a specific algorithm.
 }
 
+// imgInspectHealthcheck converts a healthcheck configuration into a format suitable for inclusion in a types.ImageInspectInfo structure.
+func imgInspectHealthcheck(config *Schema2HealthConfig) *types.ImageInspectHealthcheck {
+	if config == nil {
+		return nil
+	}
+	return &types.ImageInspectHealthcheck{
+		Test:        config.Test,
+		Interval:    config.Interval,
+		Timeout:     config.Timeout,
+		StartPeriod: config.StartPeriod,
+		Retries:     config.Retries,
+	}
+}
+
 // imgInspectLayersFromLayerInfos converts a list of layer infos, presumably obtained from a Manifest.LayerInfos()
 // method call, into a format suitable for inclusion in a types.ImageInspectInfo structure.
 func imgInspectLayersFromLayerInfos(infos []LayerInfo) []types.ImageInspectLayer {
@@ -6,10 +6,13 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/pkg/compression"
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -376,3 +379,62 @@ func TestUpdatedMIMEType(t *testing.T) {
 
 	require.Equalf(t, len(preserve), len(compressZstdSuccess)+len(compressZstdFailure), "missing some zstd compression tests")
 }
+
+func TestInspectHealthcheck(t *testing.T) {
+	configBlob := []byte(`{"architecture":"amd64","os":"linux","config":{"Healthcheck":{` +
+		`"Test":["CMD-SHELL","curl -f http://localhost/ || exit 1"],` +
+		`"Interval":30000000000,"Timeout":5000000000,"StartPeriod":10000000000,"Retries":3}},` +
+		`"rootfs":{"type":"layers","diff_ids":[]}}`)
+	configDigest := digest.FromBytes(configBlob)
+	configGetter := func(info types.BlobInfo) ([]byte, error) {
+		require.Equal(t, configDigest, info.Digest)
+		return configBlob, nil
+	}
+	expected := &types.ImageInspectHealthcheck{
+		Test:        []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"},
+		Interval:    30 * time.Second,
+		Timeout:     5 * time.Second,
+		StartPeriod: 10 * time.Second,
+		Retries:     3,
+	}
+
+	for _, m := range []Manifest{
+		OCI1FromComponents(imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageConfig,
+			Digest:    configDigest,
+			Size:      int64(len(configBlob)),
+		}, nil),
+		Schema2FromComponents(Schema2Descriptor{
+			MediaType: DockerV2Schema2ConfigMediaType,
+			Digest:    configDigest,
+			Size:      int64(len(configBlob)),
+		}, nil),
+	} {
+		ii, err := m.Inspect(configGetter)
+		require.NoError(t, err)
+		assert.Equal(t, expected, ii.Healthcheck)
+	}
+
+	// No healthcheck in the configuration
+	configBlob = []byte(`{"architecture":"amd64","os":"linux","config":{},"rootfs":{"type":"layers","diff_ids":[]}}`)
+	configDigest = digest.FromBytes(configBlob)
+	ii, err := Schema2FromComponents(Schema2Descriptor{
+		MediaType: DockerV2Schema2ConfigMediaType,
+		Digest:    configDigest,
+		Size:      int64(len(configBlob)),
+	}, nil).Inspect(configGetter)
+	require.NoError(t, err)
+	assert.Nil(t, ii.Healthcheck)
+
+	// Schema1 images never report a healthcheck.
+	ref, err := reference.ParseNormalizedNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+	s1, err := Schema1FromComponents(ref, []Schema1FSLayers{{BlobSum: digest.FromString("layer")}}, []Schema1History{{
+		V1Compatibility: `{"id":"` + digest.FromString("layer").Encoded() + `","architecture":"amd64","os":"linux",` +
+			`"config":{"Healthcheck":{"Test":["CMD","true"]}}}`,
+	}}, "amd64")
+	require.NoError(t, err)
+	ii, err = s1.Inspect(nil)
+	require.NoError(t, err)
+	assert.Nil(t, ii.Healthcheck)
+}
@@ -235,7 +235,10 @@ func (m *Schema1) Inspect(_ func(types.BlobInfo) ([]byte, error)) (*types.ImageI
 	if s1.Config != nil {
 		i.Labels = s1.Config.Labels
 		i.Env = s1.Config.Env
+		i.User = s1.Config.User
 	}
+	i.ParsedUser = imgInspectParsedUser(i.User)
+	i.KnownLabels = ParseKnownLabels(i.Labels)
 	return i, nil
 }
 
@@ -286,7 +286,11 @@ func (m *Schema2) Inspect(configGetter func(types.BlobInfo) ([]byte, error)) (*t
 	if s2.Config != nil {
 		i.Labels = s2.Config.Labels
 		i.Env = s2.Config.Env
+		i.User = s2.Config.User
+		i.Healthcheck = imgInspectHealthcheck(s2.Config.Healthcheck)
 	}
+	i.ParsedUser = imgInspectParsedUser(i.User)
+	i.KnownLabels = ParseKnownLabels(i.Labels)
 	return i, nil
 }
 
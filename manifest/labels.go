@@ -0,0 +1,53 @@
+package manifest
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ParseKnownLabels returns the values of the pre-defined OCI annotation keys in labels, parsing timestamps, URLs and digests.
+// Values which can’t be parsed are ignored; they remain available in labels.
+func ParseKnownLabels(labels map[string]string) types.ImageInspectKnownLabels {
+	res := types.ImageInspectKnownLabels{
+		Authors:     labels[imgspecv1.AnnotationAuthors],
+		Version:     labels[imgspecv1.AnnotationVersion],
+		Revision:    labels[imgspecv1.AnnotationRevision],
+		Vendor:      labels[imgspecv1.AnnotationVendor],
+		Licenses:    labels[imgspecv1.AnnotationLicenses],
+		RefName:     labels[imgspecv1.AnnotationRefName],
+		Title:       labels[imgspecv1.AnnotationTitle],
+		Description: labels[imgspecv1.AnnotationDescription],
+		BaseName:    labels[imgspecv1.AnnotationBaseImageName],
+
+		URL:           parseLabelURL(labels[imgspecv1.AnnotationURL]),
+		Documentation: parseLabelURL(labels[imgspecv1.AnnotationDocumentation]),
+		Source:        parseLabelURL(labels[imgspecv1.AnnotationSource]),
+	}
+	if value, ok := labels[imgspecv1.AnnotationCreated]; ok {
+		if created, err := time.Parse(time.RFC3339, value); err == nil {
+			res.Created = &created
+		}
+	}
+	if value, ok := labels[imgspecv1.AnnotationBaseImageDigest]; ok {
+		if d, err := digest.Parse(value); err == nil {
+			res.BaseDigest = d
+		}
+	}
+	return res
+}
+
+// parseLabelURL returns value parsed as an absolute URL, or nil if it is not one.
+func parseLabelURL(value string) *url.URL {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() {
+		return nil
+	}
+	return u
+}
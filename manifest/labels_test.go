@@ -0,0 +1,125 @@
+package manifest
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKnownLabels(t *testing.T) {
+	mustParseURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		require.NoError(t, err)
+		return u
+	}
+	created := time.Date(2023, 4, 5, 6, 7, 8, 0, time.FixedZone("", 2*60*60))
+
+	// No labels
+	assert.Equal(t, types.ImageInspectKnownLabels{}, ParseKnownLabels(nil))
+	assert.Equal(t, types.ImageInspectKnownLabels{}, ParseKnownLabels(map[string]string{}))
+
+	// All known labels, and some unknown ones
+	res := ParseKnownLabels(map[string]string{
+		"org.opencontainers.image.created":       "2023-04-05T06:07:08+02:00",
+		"org.opencontainers.image.authors":       "Jane Doe <jane@example.com>",
+		"org.opencontainers.image.url":           "https://example.com/app",
+		"org.opencontainers.image.documentation": "https://example.com/app/docs",
+		"org.opencontainers.image.source":        "https://github.com/example/app.git",
+		"org.opencontainers.image.version":       "1.2.3",
+		"org.opencontainers.image.revision":      "0123456789abcdef",
+		"org.opencontainers.image.vendor":        "Example, Inc.",
+		"org.opencontainers.image.licenses":      "Apache-2.0 OR MIT",
+		"org.opencontainers.image.ref.name":      "1.2",
+		"org.opencontainers.image.title":         "App",
+		"org.opencontainers.image.description":   "An example app",
+		"org.opencontainers.image.base.digest":   "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"org.opencontainers.image.base.name":     "docker.io/library/alpine:3",
+		"org.opencontainers.image.unknown":       "unknown",
+		"com.example.label":                      "value",
+	})
+	require.NotNil(t, res.Created)
+	assert.True(t, created.Equal(*res.Created))
+	res.Created = nil
+	assert.Equal(t, types.ImageInspectKnownLabels{
+		Authors:       "Jane Doe <jane@example.com>",
+		URL:           mustParseURL("https://example.com/app"),
+		Documentation: mustParseURL("https://example.com/app/docs"),
+		Source:        mustParseURL("https://github.com/example/app.git"),
+		Version:       "1.2.3",
+		Revision:      "0123456789abcdef",
+		Vendor:        "Example, Inc.",
+		Licenses:      "Apache-2.0 OR MIT",
+		RefName:       "1.2",
+		Title:         "App",
+		Description:   "An example app",
+		BaseDigest:    digest.Digest("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"),
+		BaseName:      "docker.io/library/alpine:3",
+	}, res)
+
+	// Values which can’t be parsed
+	res = ParseKnownLabels(map[string]string{
+		"org.opencontainers.image.created":       "yesterday",
+		"org.opencontainers.image.url":           "example.com/app",
+		"org.opencontainers.image.documentation": "",
+		"org.opencontainers.image.source":        "git@github.com:example/app.git",
+		"org.opencontainers.image.base.digest":   "sha256:invalid",
+		"org.opencontainers.image.version":       "not a version, but not parsed",
+	})
+	assert.Equal(t, types.ImageInspectKnownLabels{
+		Version: "not a version, but not parsed",
+	}, res)
+}
+
+func TestInspectKnownLabels(t *testing.T) {
+	labels := map[string]string{
+		"org.opencontainers.image.created":  "2023-04-05T06:07:08Z",
+		"org.opencontainers.image.source":   "https://github.com/example/app",
+		"org.opencontainers.image.revision": "0123456789abcdef",
+		"com.example.label":                 "value",
+	}
+	config := imgspecv1.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config:       imgspecv1.ImageConfig{Labels: labels},
+		RootFS:       imgspecv1.RootFS{Type: "layers"},
+	}
+	configBlob, err := json.Marshal(config)
+	require.NoError(t, err)
+	configDesc := imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(configBlob),
+		Size:      int64(len(configBlob)),
+	}
+
+	for _, m := range []interface {
+		Inspect(func(types.BlobInfo) ([]byte, error)) (*types.ImageInspectInfo, error)
+	}{
+		OCI1FromComponents(configDesc, nil),
+		Schema2FromComponents(Schema2Descriptor{
+			MediaType: DockerV2Schema2ConfigMediaType,
+			Digest:    configDesc.Digest,
+			Size:      configDesc.Size,
+		}, nil),
+	} {
+		ii, err := m.Inspect(func(info types.BlobInfo) ([]byte, error) {
+			require.Equal(t, configDesc.Digest, info.Digest)
+			return configBlob, nil
+		})
+		require.NoError(t, err)
+		// The raw labels are still available.
+		assert.Equal(t, labels, ii.Labels)
+		require.NotNil(t, ii.KnownLabels.Created)
+		assert.Equal(t, time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC), ii.KnownLabels.Created.UTC())
+		require.NotNil(t, ii.KnownLabels.Source)
+		assert.Equal(t, "https://github.com/example/app", ii.KnownLabels.Source.String())
+		assert.Equal(t, "0123456789abcdef", ii.KnownLabels.Revision)
+		assert.Equal(t, "", ii.KnownLabels.Version)
+	}
+}
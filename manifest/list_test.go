@@ -70,10 +70,13 @@ func TestChooseInstance(t *testing.T) {
 		arch, variant  string
 		instanceDigest digest.Digest
 	}
+	type unmatchedPlatform struct {
+		arch, variant string
+	}
 	for _, manifestList := range []struct {
 		listFile           string
 		matchedInstances   []expectedMatch
-		unmatchedInstances []string
+		unmatchedInstances []unmatchedPlatform
 	}{
 		{
 			listFile: "schema2list.json",
@@ -83,23 +86,24 @@ func TestChooseInstance(t *testing.T) {
 				{"arm", "v7", "sha256:b5dbad4bdb4444d919294afe49a095c23e86782f98cdf0aa286198ddb814b50b"},
 				{"arm64", "", "sha256:dc472a59fb006797aa2a6bfb54cc9c57959bb0a6d11fadaa608df8c16dea39cf"},
 			},
-			unmatchedInstances: []string{
-				"unmatched",
+			unmatchedInstances: []unmatchedPlatform{
+				{"unmatched", ""},
 			},
 		},
 		{ // Focus on ARM variant field testing
 			listFile: "schema2list-variants.json",
 			matchedInstances: []expectedMatch{
 				{"amd64", "", "sha256:59eec8837a4d942cc19a52b8c09ea75121acc38114a2c68b98983ce9356b8610"},
-				{"arm", "v7", "sha256:f365626a556e58189fc21d099fc64603db0f440bff07f77c740989515c544a39"},
+				// The instance without a variant is v7, preferred over v6; it can't run on v5 or v6.
+				{"arm", "v7", "sha256:c84b0a3a07b628bc4d62e5047d0f8dff80f7c00979e1e28a821a033ecda8fe53"},
 				{"arm", "v6", "sha256:f365626a556e58189fc21d099fc64603db0f440bff07f77c740989515c544a39"},
-				{"arm", "v5", "sha256:c84b0a3a07b628bc4d62e5047d0f8dff80f7c00979e1e28a821a033ecda8fe53"},
 				{"arm", "", "sha256:c84b0a3a07b628bc4d62e5047d0f8dff80f7c00979e1e28a821a033ecda8fe53"},
 				{"arm", "unrecognized-present", "sha256:bcf9771c0b505e68c65440474179592ffdfa98790eb54ffbf129969c5e429990"},
 				{"arm", "unrecognized-not-present", "sha256:c84b0a3a07b628bc4d62e5047d0f8dff80f7c00979e1e28a821a033ecda8fe53"},
 			},
-			unmatchedInstances: []string{
-				"unmatched",
+			unmatchedInstances: []unmatchedPlatform{
+				{"unmatched", ""},
+				{"arm", "v5"}, // No instance can run on v5: the one without a variant is v7.
 			},
 		},
 		{
@@ -108,8 +112,8 @@ func TestChooseInstance(t *testing.T) {
 				{"amd64", "", "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270"},
 				{"ppc64le", "", "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"},
 			},
-			unmatchedInstances: []string{
-				"unmatched",
+			unmatchedInstances: []unmatchedPlatform{
+				{"unmatched", ""},
 			},
 		},
 	} {
@@ -129,12 +133,14 @@ func TestChooseInstance(t *testing.T) {
 			assert.Equal(t, match.instanceDigest, digest, testName)
 		}
 		// Not found
-		for _, arch := range manifestList.unmatchedInstances {
+		for _, platform := range manifestList.unmatchedInstances {
+			testName := fmt.Sprintf("%s %q+%q", manifestList.listFile, platform.arch, platform.variant)
 			_, err := list.ChooseInstance(&types.SystemContext{
-				ArchitectureChoice: arch,
+				ArchitectureChoice: platform.arch,
+				VariantChoice:      platform.variant,
 				OSChoice:           "linux",
 			})
-			assert.Error(t, err)
+			assert.Error(t, err, testName)
 		}
 	}
 }
@@ -16,9 +16,9 @@ import (
 // FIXME(runcom, mitr): should we have a mediatype pkg??
 const (
 	// DockerV2Schema1MediaType MIME type represents Docker manifest schema 1
-	DockerV2Schema1MediaType = "application/vnd.docker.distribution.manifest.v1+json"
+	DockerV2Schema1MediaType = internalManifest.DockerV2Schema1MediaType
 	// DockerV2Schema1MediaType MIME type represents Docker manifest schema 1 with a JWS signature
-	DockerV2Schema1SignedMediaType = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	DockerV2Schema1SignedMediaType = internalManifest.DockerV2Schema1SignedMediaType
 	// DockerV2Schema2MediaType MIME type represents Docker manifest schema 2
 	DockerV2Schema2MediaType = "application/vnd.docker.distribution.manifest.v2+json"
 	// DockerV2Schema2ConfigMediaType is the MIME type used for schema 2 config blobs.
@@ -171,6 +171,14 @@ func GuessMIMEType(manifest []byte) string {
 
 // Digest returns the a digest of a docker manifest, with any necessary implied transformations like stripping v1s1 signatures.
 func Digest(manifest []byte) (digest.Digest, error) {
+	return DigestWithAlgorithm(manifest, digest.Canonical)
+}
+
+// DigestWithAlgorithm is like Digest, but computes the digest using algorithm.
+func DigestWithAlgorithm(manifest []byte, algorithm digest.Algorithm) (digest.Digest, error) {
+	if !algorithm.Available() {
+		return "", fmt.Errorf("digest algorithm %q is not supported", algorithm)
+	}
 	if GuessMIMEType(manifest) == DockerV2Schema1SignedMediaType {
 		sig, err := libtrust.ParsePrettySignature(manifest, "signatures")
 		if err != nil {
@@ -184,7 +192,7 @@ func Digest(manifest []byte) (digest.Digest, error) {
 		}
 	}
 
-	return digest.FromBytes(manifest), nil
+	return algorithm.FromBytes(manifest), nil
 }
 
 // MatchesDigest returns true iff the manifest matches expectedDigest.
@@ -192,8 +200,10 @@ func Digest(manifest []byte) (digest.Digest, error) {
 // Note that this is not doing ConstantTimeCompare; by the time we get here, the cryptographic signature must already have been verified,
 // or we are not using a cryptographic channel and the attacker can modify the digest along with the manifest blob.
 func MatchesDigest(manifest []byte, expectedDigest digest.Digest) (bool, error) {
-	// This should eventually support various digest types.
-	actualDigest, err := Digest(manifest)
+	if err := expectedDigest.Validate(); err != nil { // Also rejects unsupported algorithms
+		return false, nil
+	}
+	actualDigest, err := DigestWithAlgorithm(manifest, expectedDigest.Algorithm())
 	if err != nil {
 		return false, err
 	}
@@ -72,6 +72,30 @@ func TestDigest(t *testing.T) {
 	assert.Equal(t, digest.Digest(digestSha256EmptyTar), actualDigest)
 }
 
+func TestDigestWithAlgorithm(t *testing.T) {
+	manifest, err := os.ReadFile("fixtures/v2s2.manifest.json")
+	require.NoError(t, err)
+	actualDigest, err := DigestWithAlgorithm(manifest, digest.SHA512)
+	require.NoError(t, err)
+	assert.Equal(t, digest.SHA512.FromBytes(manifest), actualDigest)
+	actualDigest, err = DigestWithAlgorithm(manifest, digest.Canonical)
+	require.NoError(t, err)
+	assert.Equal(t, TestDockerV2S2ManifestDigest, actualDigest)
+
+	// The signature of a v2s1 manifest is stripped, regardless of the algorithm.
+	signed, err := os.ReadFile("fixtures/v2s1.manifest.json")
+	require.NoError(t, err)
+	signedDigest, err := DigestWithAlgorithm(signed, digest.SHA512)
+	require.NoError(t, err)
+	assert.NotEqual(t, digest.SHA512.FromBytes(signed), signedDigest)
+	res, err := MatchesDigest(signed, signedDigest)
+	require.NoError(t, err)
+	assert.True(t, res)
+
+	_, err = DigestWithAlgorithm(manifest, digest.Algorithm("md5"))
+	assert.Error(t, err)
+}
+
 func TestMatchesDigest(t *testing.T) {
 	cases := []struct {
 		path           string
@@ -81,6 +105,7 @@ func TestMatchesDigest(t *testing.T) {
 		// Success
 		{"v2s2.manifest.json", TestDockerV2S2ManifestDigest, true},
 		{"v2s1.manifest.json", TestDockerV2S1ManifestDigest, true},
+		{"v2s2.manifest.json", "sha512:50763a72163eef344fc0b58ec5a2676ceeddfa46b547475013778f3de5c0c1a75e18c947db36483e4622c1d46a908aa26649e6b0ac22514b8100889f74ed2b8c", true},
 		// No match (switched s1/s2)
 		{"v2s2.manifest.json", TestDockerV2S1ManifestDigest, false},
 		{"v2s1.manifest.json", TestDockerV2S2ManifestDigest, false},
@@ -224,6 +224,14 @@ func (m *OCI1) Inspect(configGetter func(types.BlobInfo) ([]byte, error)) (*type
 		LayersData:    imgInspectLayersFromLayerInfos(layerInfos),
 		Env:           v1.Config.Env,
 		Author:        v1.Author,
+		User:          v1.Config.User,
+		ParsedUser:    imgInspectParsedUser(v1.Config.User),
+		KnownLabels:   ParseKnownLabels(v1.Config.Labels),
+	}
+	// The OCI image specification does not define a healthcheck, but images built by Docker-compatible tools
+	// record it using the Docker field name.
+	if d1.Config != nil {
+		i.Healthcheck = imgInspectHealthcheck(d1.Config.Healthcheck)
 	}
 	return i, nil
 }
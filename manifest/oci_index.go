@@ -12,6 +12,30 @@ import (
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+const (
+	// DockerReferenceTypeAnnotation is the annotation docker buildx sets on OCI index entries which are not images of a platform.
+	DockerReferenceTypeAnnotation = "vnd.docker.reference.type"
+	// DockerReferenceDigestAnnotation is the annotation docker buildx sets on attestation manifest entries of an OCI index,
+	// containing the digest of the instance of the same index the attestations describe.
+	DockerReferenceDigestAnnotation = "vnd.docker.reference.digest"
+	// DockerAttestationManifestReferenceType is the value of DockerReferenceTypeAnnotation for attestation manifests.
+	DockerAttestationManifestReferenceType = "attestation-manifest"
+)
+
+// DockerAttestationSubject returns true if d, an entry of an OCI index, is an attestation manifest created by docker buildx,
+// and the digest of the instance it describes ("" if that is not recorded, or not a valid digest).
+// Attestation manifests use an "unknown/unknown" platform, and are never chosen as the image for a platform.
+func DockerAttestationSubject(d imgspecv1.Descriptor) (digest.Digest, bool) {
+	if d.Annotations[DockerReferenceTypeAnnotation] != DockerAttestationManifestReferenceType {
+		return "", false
+	}
+	subject := digest.Digest(d.Annotations[DockerReferenceDigestAnnotation])
+	if subject.Validate() != nil {
+		return "", true
+	}
+	return subject, true
+}
+
 // OCI1Index is just an alias for the OCI index type, but one which we can
 // provide methods for.
 type OCI1Index struct {
@@ -81,6 +105,9 @@ func (index *OCI1Index) ChooseInstance(ctx *types.SystemContext) (digest.Digest,
 			if d.Platform == nil {
 				continue
 			}
+			if _, isAttestation := DockerAttestationSubject(d); isAttestation {
+				continue
+			}
 			imagePlatform := imgspecv1.Platform{
 				Architecture: d.Platform.Architecture,
 				OS:           d.Platform.OS,
@@ -95,7 +122,7 @@ func (index *OCI1Index) ChooseInstance(ctx *types.SystemContext) (digest.Digest,
 	}
 
 	for _, d := range index.Manifests {
-		if d.Platform == nil {
+		if _, isAttestation := DockerAttestationSubject(d); d.Platform == nil && !isAttestation {
 			return d.Digest, nil
 		}
 	}
@@ -148,8 +175,11 @@ func OCI1IndexFromComponents(components []imgspecv1.Descriptor, annotations map[
 }
 
 // OCI1IndexClone creates a deep copy of the passed-in index.
+// The presence or absence of the optional mediaType field is preserved, so that the digest of an unmodified index does not change.
 func OCI1IndexClone(index *OCI1Index) *OCI1Index {
-	return OCI1IndexFromComponents(index.Manifests, index.Annotations)
+	res := OCI1IndexFromComponents(index.Manifests, index.Annotations)
+	res.MediaType = index.MediaType
+	return res
 }
 
 // ToOCI1Index returns the index encoded as an OCI1 index.
@@ -195,7 +225,6 @@ func OCI1IndexFromManifest(manifest []byte) (*OCI1Index, error) {
 	index := OCI1Index{
 		Index: imgspecv1.Index{
 			Versioned:   imgspec.Versioned{SchemaVersion: 2},
-			MediaType:   imgspecv1.MediaTypeImageIndex,
 			Manifests:   []imgspecv1.Descriptor{},
 			Annotations: make(map[string]string),
 		},
@@ -1,10 +1,15 @@
 package manifest
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,3 +31,77 @@ func TestOCI1IndexFromManifest(t *testing.T) {
 	// Extra fields are rejected
 	testValidManifestWithExtraFieldsIsRejected(t, parser, validManifest, []string{"config", "fsLayers", "history", "layers"})
 }
+
+func TestOCI1IndexMediaTypeIsPreserved(t *testing.T) {
+	for _, c := range []struct {
+		fixture      string
+		hasMediaType bool
+	}{
+		{"ociv1.image.index.json", true},
+		{"ociv1nomime.image.index.json", false},
+	} {
+		original, err := os.ReadFile(filepath.Join("fixtures", c.fixture))
+		require.NoError(t, err)
+		index, err := OCI1IndexFromManifest(original)
+		require.NoError(t, err)
+		for _, list := range []List{index, index.Clone()} {
+			serialized, err := list.Serialize()
+			require.NoError(t, err, c.fixture)
+			var fields map[string]json.RawMessage
+			err = json.Unmarshal(serialized, &fields)
+			require.NoError(t, err, c.fixture)
+			_, ok := fields["mediaType"]
+			assert.Equal(t, c.hasMediaType, ok, c.fixture)
+			assert.Equal(t, imgspecv1.MediaTypeImageIndex, list.MIMEType(), c.fixture)
+		}
+	}
+}
+
+func TestOCI1IndexDockerAttestations(t *testing.T) {
+	const (
+		amd64Image       = "sha256:5ff4a3b5024b399f75c068927cc1102327a216a1b025be9d5107bc60dd2cb1e1"
+		arm64Image       = "sha256:50fa3ee64190b0764517998155293b8c4efe94c0212e054a079b09b57a751ec2"
+		amd64Attestation = "sha256:79e6ccdab8743bd1f67e3f6e8f3c85f9247c29f81c0d515edf1fb48a929e82df"
+		arm64Attestation = "sha256:da289e104615ca26783b6fd9863e2a0201c25628c54cb47d3b76b0d30f732b4d"
+	)
+	manifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.image.index.attestations.json"))
+	require.NoError(t, err)
+	index, err := OCI1IndexFromManifest(manifest)
+	require.NoError(t, err)
+
+	subjects := map[digest.Digest]digest.Digest{}
+	for _, d := range index.Manifests {
+		if subject, ok := DockerAttestationSubject(d); ok {
+			subjects[d.Digest] = subject
+		}
+	}
+	assert.Equal(t, map[digest.Digest]digest.Digest{
+		amd64Attestation: amd64Image,
+		arm64Attestation: arm64Image,
+	}, subjects)
+
+	// An invalid subject reference is not returned.
+	subject, ok := DockerAttestationSubject(imgspecv1.Descriptor{Annotations: map[string]string{
+		DockerReferenceTypeAnnotation:   DockerAttestationManifestReferenceType,
+		DockerReferenceDigestAnnotation: "invalid",
+	}})
+	assert.True(t, ok)
+	assert.Equal(t, digest.Digest(""), subject)
+
+	// Attestation manifests are never chosen as an image.
+	for arch, expected := range map[string]digest.Digest{"amd64": amd64Image, "arm64": arm64Image} {
+		instance, err := index.ChooseInstance(&types.SystemContext{ArchitectureChoice: arch, OSChoice: "linux"})
+		require.NoError(t, err, arch)
+		assert.Equal(t, expected, instance, arch)
+	}
+	_, err = index.ChooseInstance(&types.SystemContext{ArchitectureChoice: "unknown", OSChoice: "unknown"})
+	assert.Error(t, err)
+	// Not even if they have no platform.
+	withoutPlatforms := OCI1IndexClone(index)
+	withoutPlatforms.Manifests = withoutPlatforms.Manifests[2:]
+	for i := range withoutPlatforms.Manifests {
+		withoutPlatforms.Manifests[i].Platform = nil
+	}
+	_, err = withoutPlatforms.ChooseInstance(&types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/containers/image/v5/types"
+)
+
+// ParseImageUser parses the User field of an image configuration into its components.
+// Numeric UIDs and GIDs are normalized to their decimal representation.
+func ParseImageUser(user string) (*types.ImageInspectUser, error) {
+	res := &types.ImageInspectUser{}
+	if user == "" {
+		return res, nil
+	}
+	parts := strings.Split(user, ":")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid user %q: more than one ':'", user)
+	}
+	var err error
+	if res.User, res.UserIsNumeric, err = parseImageUserComponent(parts[0]); err != nil {
+		return nil, fmt.Errorf("invalid user %q: %w", user, err)
+	}
+	if len(parts) == 2 {
+		if res.Group, res.GroupIsNumeric, err = parseImageUserComponent(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid group in user %q: %w", user, err)
+		}
+	}
+	return res, nil
+}
+
+// parseImageUserComponent validates a single user or group component of an image configuration's User field,
+// and returns it (normalized if numeric), and whether it is numeric.
+func parseImageUserComponent(component string) (string, bool, error) {
+	if component == "" {
+		return "", false, fmt.Errorf("empty value")
+	}
+	isNumeric := true
+	for _, c := range component {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return "", false, fmt.Errorf("%q contains whitespace or control characters", component)
+		}
+		if c < '0' || c > '9' {
+			isNumeric = false
+		}
+	}
+	if !isNumeric {
+		return component, false, nil
+	}
+	id, err := strconv.ParseUint(component, 10, 32)
+	if err != nil {
+		return "", false, fmt.Errorf("numeric ID %q out of range", component)
+	}
+	return strconv.FormatUint(id, 10), true, nil
+}
+
+// imgInspectParsedUser returns the value of types.ImageInspectInfo.ParsedUser for the configuration's User value.
+func imgInspectParsedUser(user string) *types.ImageInspectUser {
+	parsed, err := ParseImageUser(user)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
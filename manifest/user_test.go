@@ -0,0 +1,47 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageUser(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected types.ImageInspectUser
+		isRoot   bool
+	}{
+		{"", types.ImageInspectUser{}, true},
+		{"0", types.ImageInspectUser{User: "0", UserIsNumeric: true}, true},
+		{"000", types.ImageInspectUser{User: "0", UserIsNumeric: true}, true},
+		{"root", types.ImageInspectUser{User: "root"}, true},
+		{"0:0", types.ImageInspectUser{User: "0", UserIsNumeric: true, Group: "0", GroupIsNumeric: true}, true},
+		{"1000:1000", types.ImageInspectUser{User: "1000", UserIsNumeric: true, Group: "1000", GroupIsNumeric: true}, false},
+		{"nobody", types.ImageInspectUser{User: "nobody"}, false},
+		{"nobody:wheel", types.ImageInspectUser{User: "nobody", Group: "wheel"}, false},
+		{"1000:users", types.ImageInspectUser{User: "1000", UserIsNumeric: true, Group: "users"}, false},
+		{"app1:100", types.ImageInspectUser{User: "app1", Group: "100", GroupIsNumeric: true}, false},
+	} {
+		res, err := ParseImageUser(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, *res, c.input)
+		assert.Equal(t, c.isRoot, res.IsRoot(), c.input)
+	}
+
+	for _, input := range []string{
+		":",
+		":0",
+		"0:",
+		"1:2:3",
+		"4294967296", // Larger than uint32
+		"user name",
+		"user\x00",
+	} {
+		_, err := ParseImageUser(input)
+		assert.Error(t, err, input)
+		assert.Nil(t, imgInspectParsedUser(input), input)
+	}
+}
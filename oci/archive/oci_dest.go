@@ -2,6 +2,7 @@ package archive
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -20,14 +21,23 @@ import (
 type ociArchiveImageDestination struct {
 	impl.Compat
 
-	ref          ociArchiveReference
+	ref          types.ImageReference
+	image        string
+	resolvedFile string    // The file to write the archive to, if output is nil
+	output       io.Writer // If not nil, the archive is written to output instead of resolvedFile
 	unpackedDest private.ImageDestination
 	tempDirRef   tempDirOCIRef
 }
 
 // newImageDestination returns an ImageDestination for writing to an existing directory.
 func newImageDestination(ctx context.Context, sys *types.SystemContext, ref ociArchiveReference) (private.ImageDestination, error) {
-	tempDirRef, err := createOCIRef(sys, ref.image)
+	return newArchiveImageDestination(ctx, sys, ref, ref.image, ref.resolvedFile, nil)
+}
+
+// newArchiveImageDestination returns an ImageDestination for ref, writing an archive containing image
+// to output if it is not nil, or to resolvedFile otherwise.
+func newArchiveImageDestination(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, image, resolvedFile string, output io.Writer) (private.ImageDestination, error) {
+	tempDirRef, err := createOCIRef(sys, image)
 	if err != nil {
 		return nil, fmt.Errorf("creating oci reference: %w", err)
 	}
@@ -40,6 +50,9 @@ func newImageDestination(ctx context.Context, sys *types.SystemContext, ref ociA
 	}
 	d := &ociArchiveImageDestination{
 		ref:          ref,
+		image:        image,
+		resolvedFile: resolvedFile,
+		output:       output,
 		unpackedDest: imagedestination.FromPublic(unpackedDest),
 		tempDirRef:   tempDirRef,
 	}
@@ -114,6 +127,13 @@ func (d *ociArchiveImageDestination) PutBlobWithOptions(ctx context.Context, str
 	return d.unpackedDest.PutBlobWithOptions(ctx, stream, inputInfo, options)
 }
 
+// SupportsDigestAlgorithm returns true if blobs and manifests with digests using algorithm can be stored,
+// and PutBlobOptions.DigestAlgorithm can be set to algorithm.
+func (d *ociArchiveImageDestination) SupportsDigestAlgorithm(algorithm digest.Algorithm) bool {
+	unpackedDest, ok := d.unpackedDest.(private.ImageDestinationWithDigestAlgorithms)
+	return ok && unpackedDest.SupportsDigestAlgorithm(algorithm)
+}
+
 // PutBlobPartial attempts to create a blob using the data that is already present
 // at the destination. chunkAccessor is accessed in a non-sequential way to retrieve the missing chunks.
 // It is available only if SupportsPutBlobPartial().
@@ -143,6 +163,28 @@ func (d *ociArchiveImageDestination) PutManifest(ctx context.Context, m []byte,
 	return d.unpackedDest.PutManifest(ctx, m, instanceDigest)
 }
 
+// PutManifestWithDigestAlgorithm is PutManifest, except that if instanceDigest is nil, the digest of the manifest
+// recorded in the index is computed using algorithm instead of digest.Canonical.
+func (d *ociArchiveImageDestination) PutManifestWithDigestAlgorithm(ctx context.Context, m []byte, instanceDigest *digest.Digest, algorithm digest.Algorithm) error {
+	unpackedDest, ok := d.unpackedDest.(private.ImageDestinationWithDigestAlgorithms)
+	if !ok { // Coverage: This should never happen, the unpacked destination is an OCI layout.
+		return errors.New("Internal error: the unpacked OCI layout does not support digest algorithms")
+	}
+	return unpackedDest.PutManifestWithDigestAlgorithm(ctx, m, instanceDigest, algorithm)
+}
+
+// PutReferrerManifest writes m, an OCI image manifest with a subject, which has manifestDigest, and records it as a referrer of the subject.
+// The blobs m refers to must have already been written using PutBlobWithOptions.
+// Unlike PutManifest, this does not affect the manifest of the image being written, and it can be called after it
+// (but before Commit), whether or not the primary manifest is a manifest list.
+func (d *ociArchiveImageDestination) PutReferrerManifest(ctx context.Context, m []byte, manifestDigest digest.Digest) error {
+	unpackedDest, ok := d.unpackedDest.(private.ImageDestinationWithReferrers)
+	if !ok { // Coverage: This should never happen, the unpacked destination is an OCI layout.
+		return errors.New("Internal error: the unpacked OCI layout does not support referrers")
+	}
+	return unpackedDest.PutReferrerManifest(ctx, m, manifestDigest)
+}
+
 // PutSignaturesWithFormat writes a set of signatures to the destination.
 // If instanceDigest is not nil, it contains a digest of the specific manifest instance to write or overwrite the signatures for
 // (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
@@ -158,24 +200,21 @@ func (d *ociArchiveImageDestination) PutSignaturesWithFormat(ctx context.Context
 // after the directory is made, it is tarred up into a file and the directory is deleted
 func (d *ociArchiveImageDestination) Commit(ctx context.Context, unparsedToplevel types.UnparsedImage) error {
 	if err := d.unpackedDest.Commit(ctx, unparsedToplevel); err != nil {
-		return fmt.Errorf("storing image %q: %w", d.ref.image, err)
+		return fmt.Errorf("storing image %q: %w", d.image, err)
 	}
 
 	// path of directory to tar up
 	src := d.tempDirRef.tempDirectory
+	if d.output != nil {
+		return tarDirectoryToWriter(src, d.output)
+	}
 	// path to save tarred up file
-	dst := d.ref.resolvedFile
+	dst := d.resolvedFile
 	return tarDirectory(src, dst)
 }
 
 // tar converts the directory at src and saves it to dst
 func tarDirectory(src, dst string) error {
-	// input is a stream of bytes from the archive of the directory at path
-	input, err := archive.Tar(src, archive.Uncompressed)
-	if err != nil {
-		return fmt.Errorf("retrieving stream of bytes from %q: %w", src, err)
-	}
-
 	// creates the tar file
 	outFile, err := os.Create(dst)
 	if err != nil {
@@ -183,9 +222,21 @@ func tarDirectory(src, dst string) error {
 	}
 	defer outFile.Close()
 
-	// copies the contents of the directory to the tar file
+	return tarDirectoryToWriter(src, outFile)
+}
+
+// tarDirectoryToWriter converts the directory at src and writes it to dst
+func tarDirectoryToWriter(src string, dst io.Writer) error {
+	// input is a stream of bytes from the archive of the directory at path
+	input, err := archive.Tar(src, archive.Uncompressed)
+	if err != nil {
+		return fmt.Errorf("retrieving stream of bytes from %q: %w", src, err)
+	}
+	defer input.Close()
+
+	// copies the contents of the directory to the tar stream
 	// TODO: This can take quite some time, and should ideally be cancellable using a context.Context.
-	_, err = io.Copy(outFile, input)
+	_, err = io.Copy(dst, input)
 
 	return err
 }
@@ -3,3 +3,4 @@ package archive
 import "github.com/containers/image/v5/internal/private"
 
 var _ private.ImageDestination = (*ociArchiveImageDestination)(nil)
+var _ private.ImageDestinationWithReferrers = (*ociArchiveImageDestination)(nil)
@@ -149,6 +149,16 @@ func (s *ociArchiveImageSource) GetSignaturesWithFormat(ctx context.Context, ins
 	return s.unpackedSrc.GetSignaturesWithFormat(ctx, instanceDigest)
 }
 
+// GetReferrers returns descriptors of the manifests of artifacts with artifactType which refer to the manifest with manifestDigest,
+// or of artifacts of all types if artifactType is "".
+func (s *ociArchiveImageSource) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	src, ok := s.unpackedSrc.(private.ImageSourceWithReferrers)
+	if !ok {
+		return nil, errors.New("Internal error: the unpacked OCI layout source does not support listing referrers")
+	}
+	return src.GetReferrers(ctx, manifestDigest, artifactType)
+}
+
 // LayerInfosForCopy returns either nil (meaning the values in the manifest are fine), or updated values for the layer
 // blobsums that are listed in the image's manifest.  If values are returned, they should be used when using GetBlob()
 // to read the image's layers.
@@ -40,6 +40,17 @@ func (t ociArchiveTransport) Name() string {
 	return "oci-archive"
 }
 
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "oci-archive:path[:reference]"
+	ReferenceSyntaxExample = "oci-archive:/var/tmp/busybox.tar:latest"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t ociArchiveTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix
 // into an ImageReference.
 func (t ociArchiveTransport) ParseReference(reference string) (types.ImageReference, error) {
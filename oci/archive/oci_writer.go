@@ -0,0 +1,82 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/oci/internal"
+	"github.com/containers/image/v5/types"
+)
+
+// NewWriterReference returns a reference which can only be used as a destination, writing an image as an oci-archive tar stream
+// to w, e.g. using copy.ImageToWriter. If image is not empty, it is recorded as the name of the image in the index of the archive.
+//
+// A tar stream must be written sequentially, with the size of each file known before its contents, and the index of the archive
+// can only be created after all of the image has been copied. So, the image is first assembled as an OCI layout in a temporary
+// directory (in types.SystemContext.BigFilesTemporaryDir of the destination, if set), and the tar stream is written to w only
+// when the image is committed; nothing is written to w if the copy fails. The stream contains the "blobs" directory, followed
+// by "index.json" and "oci-layout"; readers of the archive, including this transport, must not depend on the order of the entries.
+func NewWriterReference(w io.Writer, image string) (types.ImageReference, error) {
+	if err := internal.ValidateImageName(image); err != nil {
+		return nil, err
+	}
+	return writerReference{w: w, image: image}, nil
+}
+
+// writerReference is a types.ImageReference for an oci-archive tar stream written to an io.Writer.
+// It can only be used as a destination, and not be represented as a string which can be parsed.
+type writerReference struct {
+	w     io.Writer
+	image string
+}
+
+func (ref writerReference) Transport() types.ImageTransport {
+	return Transport
+}
+
+// StringWithinTransport returns a string representation of the reference.
+// Unlike other references, this can't be parsed by Transport.ParseReference.
+func (ref writerReference) StringWithinTransport() string {
+	return fmt.Sprintf("(stream):%s", ref.image)
+}
+
+// DockerReference returns a Docker reference associated with this reference
+func (ref writerReference) DockerReference() reference.Named {
+	return nil
+}
+
+// PolicyConfigurationIdentity returns a string representation of the reference, suitable for policy lookup.
+// A stream has no identity usable for policy lookup.
+func (ref writerReference) PolicyConfigurationIdentity() string {
+	return ""
+}
+
+// PolicyConfigurationNamespaces returns a list of other policy configuration namespaces to search
+// for if explicit configuration for PolicyConfigurationIdentity() is not set
+func (ref writerReference) PolicyConfigurationNamespaces() []string {
+	return []string{}
+}
+
+// NewImage returns a types.ImageCloser for this reference, which is not supported for a stream.
+func (ref writerReference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	return nil, errors.New("reading images from an oci-archive stream is not supported")
+}
+
+// NewImageSource returns a types.ImageSource for this reference, which is not supported for a stream.
+func (ref writerReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	return nil, errors.New("reading images from an oci-archive stream is not supported")
+}
+
+// NewImageDestination returns a types.ImageDestination for this reference.
+// The caller must call .Close() on the returned ImageDestination.
+func (ref writerReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return newArchiveImageDestination(ctx, sys, ref, ref.image, "", ref.w)
+}
+
+// DeleteImage deletes the named image from the registry, if supported.
+func (ref writerReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	return errors.New("Deleting images not implemented for oci-archive streams")
+}
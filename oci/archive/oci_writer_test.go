@@ -0,0 +1,34 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWriterReference(t *testing.T) {
+	for _, image := range []string{"", "example:latest"} {
+		ref, err := NewWriterReference(&bytes.Buffer{}, image)
+		require.NoError(t, err, image)
+		assert.Equal(t, writerReference{w: &bytes.Buffer{}, image: image}, ref, image)
+	}
+
+	// Invalid image names are rejected
+	_, err := NewWriterReference(&bytes.Buffer{}, "UPPERCASE:invalid!")
+	assert.Error(t, err)
+}
+
+func TestWriterReference(t *testing.T) {
+	ref := writerReference{w: &bytes.Buffer{}, image: "example:latest"}
+	assert.Equal(t, Transport, ref.Transport())
+	assert.Nil(t, ref.DockerReference())
+	_, err := ref.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+	_, err = ref.NewImage(context.Background(), nil)
+	assert.Error(t, err)
+	err = ref.DeleteImage(context.Background(), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package httplayout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/containers/image/v5/internal/imagesource/impl"
+	"github.com/containers/image/v5/internal/imagesource/stubs"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/internal"
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type httpLayoutImageSource struct {
+	impl.Compat
+	impl.PropertyMethodsInitialize
+	impl.NoSignatures
+	impl.DoesNotAffectLayerInfosForCopy
+	stubs.NoGetBlobAtInitialize
+
+	ref         httpLayoutReference
+	client      *http.Client
+	auth        *types.DockerAuthConfig // nil if not using basic authentication
+	bearerToken string                  // "" if not using a bearer token
+	index       *imgspecv1.Index
+	descriptor  imgspecv1.Descriptor
+}
+
+// newImageSource returns an ImageSource reading from an OCI layout served over HTTP(S).
+// The caller must call .Close() on the returned ImageSource.
+func newImageSource(ctx context.Context, sys *types.SystemContext, ref httpLayoutReference) (private.ImageSource, error) {
+	tr := tlsclientconfig.NewTransport()
+	tr.TLSClientConfig = tlsconfig.ServerDefault()
+	if sys != nil {
+		if sys.OCICertPath != "" {
+			if err := tlsclientconfig.SetupCertificates(sys.OCICertPath, tr.TLSClientConfig); err != nil {
+				return nil, err
+			}
+		}
+		tr.TLSClientConfig.InsecureSkipVerify = sys.OCIInsecureSkipTLSVerify
+	}
+
+	s := &httpLayoutImageSource{
+		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
+			HasThreadSafeGetBlob: true,
+		}),
+		NoGetBlobAtInitialize: stubs.NoGetBlobAt(ref),
+
+		ref:    ref,
+		client: &http.Client{Transport: tr},
+	}
+	if sys != nil {
+		if sys.OCIHTTPBearerToken != "" {
+			s.bearerToken = sys.OCIHTTPBearerToken
+		} else {
+			s.auth = sys.OCIHTTPAuthConfig
+		}
+	}
+	s.Compat = impl.AddCompat(s)
+
+	index, err := s.getIndex(ctx)
+	if err != nil {
+		tr.CloseIdleConnections()
+		return nil, err
+	}
+	descriptor, found, err := internal.ManifestDescriptor(index, ref.image)
+	if err != nil {
+		tr.CloseIdleConnections()
+		return nil, err
+	}
+	if !found {
+		tr.CloseIdleConnections()
+		return nil, fmt.Errorf("no descriptor found for reference %q", ref.image)
+	}
+	s.index = index
+	s.descriptor = descriptor
+	return s, nil
+}
+
+// Reference returns the reference used to set up this source, _as specified by the user_
+// (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
+func (s *httpLayoutImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+// Close removes resources associated with an initialized ImageSource, if any.
+func (s *httpLayoutImageSource) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// get performs a GET request for url, and returns the response if it succeeded.
+// The caller must close the response body.
+func (s *httpLayoutImageSource) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.bearerToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.bearerToken))
+	} else if s.auth != nil {
+		req.SetBasicAuth(s.auth.Username, s.auth.Password)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", url, res.Status)
+	}
+	return res, nil
+}
+
+// getIndex fetches and parses the index.json of the layout.
+func (s *httpLayoutImageSource) getIndex(ctx context.Context) (*imgspecv1.Index, error) {
+	res, err := s.get(ctx, s.ref.indexURL())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	indexJSON, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, err
+	}
+	index := &imgspecv1.Index{}
+	if err := json.Unmarshal(indexJSON, index); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.ref.indexURL(), err)
+	}
+	return index, nil
+}
+
+// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
+// It may use a remote (= slow) service.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *httpLayoutImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	var dig digest.Digest
+	var mimeType string
+	if instanceDigest == nil {
+		dig = s.descriptor.Digest
+		mimeType = s.descriptor.MediaType
+	} else {
+		dig = *instanceDigest
+		for _, md := range s.index.Manifests {
+			if md.Digest == dig {
+				mimeType = md.MediaType
+				break
+			}
+		}
+	}
+
+	manifestURL, err := s.ref.blobURL(dig)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := s.get(ctx, manifestURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	m, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, "", err
+	}
+	// Unlike a local layout, the server is not trusted to return the right data.
+	matches, err := manifest.MatchesDigest(m, dig)
+	if err != nil {
+		return nil, "", fmt.Errorf("computing digest of manifest %s: %w", dig, err)
+	}
+	if !matches {
+		return nil, "", fmt.Errorf("manifest fetched from %s does not match digest %s", manifestURL, dig)
+	}
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(m)
+	}
+	return m, mimeType, nil
+}
+
+// GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
+// The Digest field in BlobInfo is guaranteed to be provided, Size may be -1 and MediaType may be optionally provided.
+// May update BlobInfoCache, preferably after it knows for certain that a blob truly exists at a specific location.
+func (s *httpLayoutImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blobURL, err := s.ref.blobURL(info.Digest)
+	if err != nil {
+		return nil, -1, err
+	}
+	res, err := s.get(ctx, blobURL)
+	if err != nil {
+		return nil, -1, err
+	}
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		size = -1
+	}
+	return res.Body, size, nil
+}
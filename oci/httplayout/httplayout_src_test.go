@@ -0,0 +1,169 @@
+package httplayout
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/containers/image/v5/internal/testing/explicitfilepath-tmpdir"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putTestImage writes an image with a single layer to the OCI layout at dir, using the image name,
+// and returns its manifest and layer contents.
+func putTestImage(t *testing.T, dir, image string) ([]byte, []byte) {
+	ref, err := layout.NewReference(dir, image)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	layerBlob := []byte("layer of " + image)
+	layerInfo, err := dest.PutBlob(context.Background(), bytes.NewReader(layerBlob), types.BlobInfo{Digest: digest.FromBytes(layerBlob), Size: int64(len(layerBlob))}, none.NoCache, false)
+	require.NoError(t, err)
+	configBlob := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	configInfo, err := dest.PutBlob(context.Background(), bytes.NewReader(configBlob), types.BlobInfo{Digest: digest.FromBytes(configBlob), Size: int64(len(configBlob))}, none.NoCache, true)
+	require.NoError(t, err)
+	man := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configInfo.Digest,
+		Size:      configInfo.Size,
+	}, []imgspecv1.Descriptor{{
+		MediaType: imgspecv1.MediaTypeImageLayer,
+		Digest:    layerInfo.Digest,
+		Size:      layerInfo.Size,
+	}})
+	manBlob, err := man.Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	return manBlob, layerBlob
+}
+
+// newTestServer returns a server serving dir under /layout, requiring the Authorization header to be authorization, if not "".
+func newTestServer(t *testing.T, dir, authorization string) *httptest.Server {
+	fileServer := http.StripPrefix("/layout", http.FileServer(http.Dir(dir)))
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if authorization != "" && r.Header.Get("Authorization") != authorization {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fileServer.ServeHTTP(rw, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestImageSource(t *testing.T) {
+	dir := t.TempDir()
+	manBlob1, layerBlob1 := putTestImage(t, dir, "image1")
+	_, _ = putTestImage(t, dir, "image2")
+	server := newTestServer(t, dir, "")
+
+	ref, err := ParseReference(server.URL + "/layout#image1")
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	assert.Equal(t, ref, src.Reference())
+
+	m, mimeType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manBlob1, m)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mimeType)
+
+	layerDigest := digest.FromBytes(layerBlob1)
+	stream, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: layerDigest, Size: -1}, none.NoCache)
+	require.NoError(t, err)
+	defer stream.Close()
+	assert.Equal(t, int64(len(layerBlob1)), size)
+	contents, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, layerBlob1, contents)
+
+	// Missing blobs
+	_, _, err = src.GetBlob(context.Background(), types.BlobInfo{Digest: digest.FromString("missing"), Size: -1}, none.NoCache)
+	assert.Error(t, err)
+
+	// An image which does not exist
+	ref, err = ParseReference(server.URL + "/layout#image3")
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+
+	// The image must be specified if there are several
+	ref, err = ParseReference(server.URL + "/layout")
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.ErrorIs(t, err, layout.ErrMoreThanOneImage)
+
+	// A layout which does not exist
+	ref, err = ParseReference(server.URL + "/does-not-exist")
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestImageSourceCorruptedManifest(t *testing.T) {
+	dir := t.TempDir()
+	manBlob, _ := putTestImage(t, dir, "image")
+	manPath := filepath.Join(dir, "blobs", "sha256", digest.FromBytes(manBlob).Encoded())
+	require.NoError(t, os.WriteFile(manPath, append(manBlob, '\n'), 0o644))
+	server := newTestServer(t, dir, "")
+
+	ref, err := ParseReference(server.URL + "/layout")
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	_, _, err = src.GetManifest(context.Background(), nil)
+	assert.ErrorContains(t, err, "does not match digest")
+}
+
+func TestImageSourceAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	manBlob, _ := putTestImage(t, dir, "image")
+
+	for _, c := range []struct {
+		name          string
+		authorization string
+		sys           *types.SystemContext
+	}{
+		{"basic", "Basic dXNlcjpwYXNzd29yZA==", &types.SystemContext{
+			OCIHTTPAuthConfig: &types.DockerAuthConfig{Username: "user", Password: "password"},
+		}},
+		{"bearer", "Bearer token", &types.SystemContext{
+			OCIHTTPBearerToken: "token",
+		}},
+		{"bearer instead of basic", "Bearer token", &types.SystemContext{
+			OCIHTTPAuthConfig:  &types.DockerAuthConfig{Username: "user", Password: "password"},
+			OCIHTTPBearerToken: "token",
+		}},
+	} {
+		server := newTestServer(t, dir, c.authorization)
+		ref, err := ParseReference(server.URL + "/layout")
+		require.NoError(t, err, c.name)
+
+		_, err = ref.NewImageSource(context.Background(), nil)
+		assert.ErrorContains(t, err, "401", c.name)
+
+		src, err := ref.NewImageSource(context.Background(), c.sys)
+		require.NoError(t, err, c.name)
+		m, _, err := src.GetManifest(context.Background(), nil)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, manBlob, m, c.name)
+		require.NoError(t, src.Close(), c.name)
+	}
+}
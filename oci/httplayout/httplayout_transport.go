@@ -0,0 +1,217 @@
+// Package httplayout implements a read-only transport for images in OCI layouts served over HTTP(S),
+// i.e. with an index.json and blobs/algo/encoded files available at a base URL.
+package httplayout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/oci/internal"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+func init() {
+	transports.Register(Transport)
+}
+
+// Transport is an ImageTransport for OCI layouts served over HTTP(S).
+var Transport = httpLayoutTransport{}
+
+type httpLayoutTransport struct{}
+
+func (t httpLayoutTransport) Name() string {
+	return "oci-http"
+}
+
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "oci-http:url[#reference]"
+	ReferenceSyntaxExample = "oci-http:https://example.com/layout#busybox"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t httpLayoutTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
+// ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
+func (t httpLayoutTransport) ParseReference(reference string) (types.ImageReference, error) {
+	return ParseReference(reference)
+}
+
+// ValidatePolicyConfigurationScope checks that scope is a valid name for a signature.PolicyTransportScopes keys
+// (i.e. a valid PolicyConfigurationIdentity() or PolicyConfigurationNamespaces() return value).
+// It is acceptable to allow an invalid value which will never be matched, it can "only" cause user confusion.
+// scope passed to this function will not be "", that value is always allowed.
+func (t httpLayoutTransport) ValidatePolicyConfigurationScope(scope string) error {
+	u, err := parseBaseURL(scope)
+	if err != nil {
+		return fmt.Errorf("Invalid scope %s: %w", scope, err)
+	}
+	if u.String() != scope {
+		return fmt.Errorf(`Invalid scope %s: Uses non-canonical format, perhaps try %s`, scope, u.String())
+	}
+	if u.Path != "" && path.Clean(u.Path) != u.Path {
+		return fmt.Errorf(`Invalid scope %s: Uses non-canonical path format, perhaps try with path %s`, scope, path.Clean(u.Path))
+	}
+	return nil
+}
+
+// httpLayoutReference is an ImageReference for an image in an OCI layout served over HTTP(S).
+type httpLayoutReference struct {
+	baseURL *url.URL // The URL of the layout directory, without a trailing slash, query or fragment.
+	// As in the oci: transport, if image == "", the only image in index.json is used.
+	image string
+}
+
+// ParseReference converts a string of the form http[s]://host/path[#image], which should not start with the ImageTransport.Name prefix,
+// into an ImageReference.
+func ParseReference(ref string) (types.ImageReference, error) {
+	baseURL, image := ref, ""
+	if i := strings.Index(ref, "#"); i != -1 {
+		baseURL, image = ref[:i], ref[i+1:]
+	}
+	return NewReference(baseURL, image)
+}
+
+// NewReference returns a reference to image (which may be "" if the layout contains only one image)
+// in the OCI layout at baseURL.
+func NewReference(baseURL, image string) (types.ImageReference, error) {
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI layout URL %q: %w", baseURL, err)
+	}
+	if err := internal.ValidateImageName(image); err != nil {
+		return nil, err
+	}
+	return httpLayoutReference{baseURL: u, image: image}, nil
+}
+
+// parseBaseURL parses and validates the URL of a layout directory, and returns it without a trailing slash.
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("only http and https URLs are supported")
+	}
+	if u.Host == "" {
+		return nil, errors.New("the host name is missing")
+	}
+	if u.User != nil {
+		return nil, errors.New("credentials must not be included in the URL")
+	}
+	if u.RawQuery != "" || u.ForceQuery || u.Fragment != "" {
+		return nil, errors.New("queries and fragments are not supported")
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+func (ref httpLayoutReference) Transport() types.ImageTransport {
+	return Transport
+}
+
+// StringWithinTransport returns a string representation of the reference, which MUST be such that
+// reference.Transport().ParseReference(reference.StringWithinTransport()) returns an equivalent reference.
+// NOTE: The returned string is not promised to be equal to the original input to ParseReference;
+// e.g. default attribute values omitted by the user may be filled in in the return value, or vice versa.
+// WARNING: Do not use the return value in the UI to describe an image, it does not contain the Transport().Name() prefix.
+func (ref httpLayoutReference) StringWithinTransport() string {
+	if ref.image == "" {
+		return ref.baseURL.String()
+	}
+	return ref.baseURL.String() + "#" + ref.image
+}
+
+// DockerReference returns a Docker reference associated with this reference
+// (fully explicit, i.e. !reference.IsNameOnly, but reflecting user intent,
+// not e.g. after redirect or alias processing), or nil if unknown/not applicable.
+func (ref httpLayoutReference) DockerReference() reference.Named {
+	return nil
+}
+
+// PolicyConfigurationIdentity returns a string representation of the reference, suitable for policy lookup.
+// This MUST reflect user intent, not e.g. after processing of third-party redirects or aliases;
+// The value SHOULD be fully explicit about its semantics, with no hidden defaults, AND canonical
+// (i.e. various references with exactly the same semantics should return the same configuration identity)
+// It is fine for the return value to be equal to StringWithinTransport(), and it is desirable but
+// not required/guaranteed that it will be a valid input to Transport().ParseReference().
+// Returns "" if configuration identities for these references are not supported.
+func (ref httpLayoutReference) PolicyConfigurationIdentity() string {
+	// NOTE: As in the oci: transport, ref.image is not a part of the image identity, because "$url#$someimage" and "$url"
+	// may mean the same image and the two can’t be statically disambiguated.
+	return ref.baseURL.String()
+}
+
+// PolicyConfigurationNamespaces returns a list of other policy configuration namespaces to search
+// for if explicit configuration for PolicyConfigurationIdentity() is not set.  The list will be processed
+// in order, terminating on first match, and an implicit "" is always checked at the end.
+// It is STRONGLY recommended for the first element, if any, to be a prefix of PolicyConfigurationIdentity(),
+// and each following element to be a prefix of the element preceding it.
+func (ref httpLayoutReference) PolicyConfigurationNamespaces() []string {
+	res := []string{}
+	u := *ref.baseURL
+	for {
+		res = append(res, u.String())
+		lastSlash := strings.LastIndex(u.Path, "/")
+		if lastSlash == -1 {
+			break
+		}
+		u.Path = u.Path[:lastSlash]
+	}
+	return res
+}
+
+// NewImage returns a types.ImageCloser for this reference, possibly specialized for this ImageTransport.
+// The caller must call .Close() on the returned ImageCloser.
+// NOTE: If any kind of signature verification should happen, build an UnparsedImage from the value returned by NewImageSource,
+// verify that UnparsedImage, and convert it into a real Image via image.FromUnparsedImage.
+// WARNING: This may not do the right thing for a manifest list, see image.FromSource for details.
+func (ref httpLayoutReference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	return image.FromReference(ctx, sys, ref)
+}
+
+// NewImageSource returns a types.ImageSource for this reference.
+// The caller must call .Close() on the returned ImageSource.
+func (ref httpLayoutReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	return newImageSource(ctx, sys, ref)
+}
+
+// NewImageDestination returns a types.ImageDestination for this reference.
+// The caller must call .Close() on the returned ImageDestination.
+func (ref httpLayoutReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return nil, errors.New("Writing images is not supported for oci-http: images")
+}
+
+// DeleteImage deletes the named image from the registry, if supported.
+func (ref httpLayoutReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	return errors.New("Deleting images not implemented for oci-http: images")
+}
+
+// indexURL returns the URL of the index.json of the layout.
+func (ref httpLayoutReference) indexURL() string {
+	u := *ref.baseURL
+	u.Path += "/index.json"
+	return u.String()
+}
+
+// blobURL returns the URL of the blob (or manifest) with digest d in the layout.
+func (ref httpLayoutReference) blobURL(d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil { // Make sure d does not contain path separators or other unexpected values
+		return "", fmt.Errorf("unexpected digest reference %s: %w", d, err)
+	}
+	u := *ref.baseURL
+	u.Path += "/blobs/" + d.Algorithm().String() + "/" + d.Encoded()
+	return u.String(), nil
+}
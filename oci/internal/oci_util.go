@@ -1,12 +1,17 @@
 package internal
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // annotation spex from https://github.com/opencontainers/image-spec/blob/master/annotations.md#pre-defined-annotation-keys
@@ -16,6 +21,10 @@ const (
 	component = `(?:` + alphanum + `(?:` + separator + alphanum + `)*)`
 )
 
+// ErrMoreThanOneImage is an error returned when the manifest includes
+// more than one image and the user should choose which one to use.
+var ErrMoreThanOneImage = errors.New("more than one image in oci, choose an image")
+
 var refRegexp = regexp.MustCompile(`^` + component + `(?:/` + component + `)*$`)
 var windowsRefRegexp = regexp.MustCompile(`^([a-zA-Z]:\\.+?):(.*)$`)
 
@@ -125,3 +134,110 @@ func validateScopeNonWindows(scope string) error {
 
 	return nil
 }
+
+// RefNamesMatch returns true if refName, the value of an org.opencontainers.image.ref.name annotation, refers to image,
+// as specified in an OCI reference.
+// Apart from exact matches, if both use registry-style repo:tag syntax (i.e. contain a "/" or ":"), they match if they
+// refer to the same Docker reference after normalization, e.g. "busybox:latest" matches "docker.io/library/busybox:latest".
+func RefNamesMatch(refName, image string) bool {
+	if refName == image {
+		return true
+	}
+	if !strings.ContainsAny(refName, "/:") || !strings.ContainsAny(image, "/:") {
+		return false
+	}
+	normalizedRefName, err := reference.ParseNormalizedNamed(refName)
+	if err != nil {
+		return false
+	}
+	normalizedImage, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	return normalizedRefName.String() == normalizedImage.String()
+}
+
+// ManifestDescriptor returns the descriptor in index matching image, as specified in an OCI reference:
+// the only manifest in the index if image is "" (ignoring artifacts, e.g. referrers of that manifest, if there are other entries),
+// or the manifest with a matching org.opencontainers.image.ref.name annotation otherwise; an exact match is preferred,
+// but registry-style names are also matched after normalization, see RefNamesMatch.
+// It returns false if image is not "", and no manifest matches it.
+func ManifestDescriptor(index *imgspecv1.Index, image string) (imgspecv1.Descriptor, bool, error) {
+	if image == "" {
+		// return manifest if only one image is in the oci directory
+		if len(index.Manifests) == 1 {
+			return index.Manifests[0], true, nil
+		}
+		candidates := []imgspecv1.Descriptor{}
+		for _, md := range index.Manifests {
+			if md.ArtifactType == "" {
+				candidates = append(candidates, md)
+			}
+		}
+		if len(candidates) != 1 {
+			// ask user to choose image when more than one image in the oci directory
+			return imgspecv1.Descriptor{}, false, ErrMoreThanOneImage
+		}
+		return candidates[0], true, nil
+	}
+	// if image specified, look through all manifests for a match
+	var normalizedMatch *imgspecv1.Descriptor
+	for i, md := range index.Manifests {
+		if md.MediaType != imgspecv1.MediaTypeImageManifest && md.MediaType != imgspecv1.MediaTypeImageIndex {
+			continue
+		}
+		refName, ok := md.Annotations[imgspecv1.AnnotationRefName]
+		if !ok {
+			continue
+		}
+		if refName == image {
+			return md, true, nil
+		}
+		if RefNamesMatch(refName, image) {
+			if normalizedMatch != nil && normalizedMatch.Digest != md.Digest {
+				return imgspecv1.Descriptor{}, false, fmt.Errorf("more than one image in oci matches %s, e.g. %q and %q",
+					image, normalizedMatch.Annotations[imgspecv1.AnnotationRefName], refName)
+			}
+			normalizedMatch = &index.Manifests[i]
+		}
+	}
+	if normalizedMatch != nil {
+		return *normalizedMatch, true, nil
+	}
+	return imgspecv1.Descriptor{}, false, nil
+}
+
+// referrerManifest contains the fields of an OCI image manifest relevant for listing it as a referrer.
+type referrerManifest struct {
+	Config       imgspecv1.Descriptor  `json:"config"`
+	Subject      *imgspecv1.Descriptor `json:"subject,omitempty"`
+	ArtifactType string                `json:"artifactType,omitempty"`
+	Annotations  map[string]string     `json:"annotations,omitempty"`
+}
+
+// ReferrerDescriptor returns a descriptor of manifestBlob, which has mimeType and manifestDigest, suitable for listing it
+// as a referrer in an index, and the digest of the manifest it refers to using the "subject" field;
+// or "" if manifestBlob is not an OCI image manifest with a subject.
+func ReferrerDescriptor(manifestBlob []byte, mimeType string, manifestDigest digest.Digest) (imgspecv1.Descriptor, digest.Digest, error) {
+	if mimeType != imgspecv1.MediaTypeImageManifest {
+		return imgspecv1.Descriptor{}, "", nil
+	}
+	var m referrerManifest
+	if err := json.Unmarshal(manifestBlob, &m); err != nil {
+		return imgspecv1.Descriptor{}, "", fmt.Errorf("parsing manifest %s: %w", manifestDigest, err)
+	}
+	if m.Subject == nil {
+		return imgspecv1.Descriptor{}, "", nil
+	}
+	artifactType := m.ArtifactType
+	if artifactType == "" {
+		artifactType = m.Config.MediaType
+	}
+	return imgspecv1.Descriptor{
+		MediaType:    mimeType,
+		Digest:       manifestDigest,
+		Size:         int64(len(manifestBlob)),
+		ArtifactType: artifactType,
+		Annotations:  m.Annotations,
+	}, m.Subject.Digest, nil
+}
@@ -2,8 +2,10 @@ package internal
 
 import (
 	"fmt"
-	"github.com/stretchr/testify/assert"
 	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
 )
 
 type testDataSplitReference struct {
@@ -60,3 +62,112 @@ func TestValidateScopeWindows(t *testing.T) {
 		}
 	}
 }
+
+func TestManifestDescriptor(t *testing.T) {
+	image1 := imgspecv1.Descriptor{
+		MediaType:   imgspecv1.MediaTypeImageManifest,
+		Digest:      "sha256:0000000000000000000000000000000000000000000000000000000000000001",
+		Annotations: map[string]string{imgspecv1.AnnotationRefName: "image1"},
+	}
+	image2 := imgspecv1.Descriptor{
+		MediaType:   imgspecv1.MediaTypeImageIndex,
+		Digest:      "sha256:0000000000000000000000000000000000000000000000000000000000000002",
+		Annotations: map[string]string{imgspecv1.AnnotationRefName: "image2"},
+	}
+	notAnImage := imgspecv1.Descriptor{
+		MediaType:   "application/vnd.example.unknown",
+		Digest:      "sha256:0000000000000000000000000000000000000000000000000000000000000003",
+		Annotations: map[string]string{imgspecv1.AnnotationRefName: "image3"},
+	}
+	index := &imgspecv1.Index{Manifests: []imgspecv1.Descriptor{image1, image2, notAnImage}}
+
+	for _, c := range []struct {
+		image    string
+		expected *imgspecv1.Descriptor
+	}{
+		{"image1", &image1},
+		{"image2", &image2},
+		{"image3", nil},
+		{"image4", nil},
+	} {
+		desc, found, err := ManifestDescriptor(index, c.image)
+		assert.NoError(t, err, c.image)
+		if c.expected != nil {
+			assert.True(t, found, c.image)
+			assert.Equal(t, *c.expected, desc, c.image)
+		} else {
+			assert.False(t, found, c.image)
+		}
+	}
+
+	_, _, err := ManifestDescriptor(index, "")
+	assert.ErrorIs(t, err, ErrMoreThanOneImage)
+
+	desc, found, err := ManifestDescriptor(&imgspecv1.Index{Manifests: []imgspecv1.Descriptor{notAnImage}}, "")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, notAnImage, desc)
+
+	// Registry-style names
+	busybox := imgspecv1.Descriptor{
+		MediaType:   imgspecv1.MediaTypeImageManifest,
+		Digest:      "sha256:0000000000000000000000000000000000000000000000000000000000000004",
+		Annotations: map[string]string{imgspecv1.AnnotationRefName: "docker.io/library/busybox:latest"},
+	}
+	shortBusybox := imgspecv1.Descriptor{
+		MediaType:   imgspecv1.MediaTypeImageManifest,
+		Digest:      "sha256:0000000000000000000000000000000000000000000000000000000000000005",
+		Annotations: map[string]string{imgspecv1.AnnotationRefName: "busybox:latest"},
+	}
+	withPort := imgspecv1.Descriptor{
+		MediaType:   imgspecv1.MediaTypeImageManifest,
+		Digest:      "sha256:0000000000000000000000000000000000000000000000000000000000000006",
+		Annotations: map[string]string{imgspecv1.AnnotationRefName: "localhost:5000/ns/repo:v1.0"},
+	}
+	for _, c := range []struct {
+		manifests []imgspecv1.Descriptor
+		image     string
+		expected  *imgspecv1.Descriptor
+	}{
+		{[]imgspecv1.Descriptor{busybox, withPort}, "busybox:latest", &busybox},
+		{[]imgspecv1.Descriptor{busybox, withPort}, "library/busybox:latest", &busybox},
+		{[]imgspecv1.Descriptor{busybox, withPort}, "docker.io/library/busybox:latest", &busybox},
+		{[]imgspecv1.Descriptor{busybox, withPort}, "busybox:other", nil},
+		{[]imgspecv1.Descriptor{busybox, withPort}, "localhost:5000/ns/repo:v1.0", &withPort},
+		{[]imgspecv1.Descriptor{busybox, withPort}, "localhost:5000/repo:v1.0", nil},
+		// An exact match is preferred.
+		{[]imgspecv1.Descriptor{busybox, shortBusybox}, "busybox:latest", &shortBusybox},
+	} {
+		desc, found, err := ManifestDescriptor(&imgspecv1.Index{Manifests: c.manifests}, c.image)
+		assert.NoError(t, err, c.image)
+		if c.expected != nil {
+			assert.True(t, found, c.image)
+			assert.Equal(t, *c.expected, desc, c.image)
+		} else {
+			assert.False(t, found, c.image)
+		}
+	}
+	// Different images matching after normalization are ambiguous.
+	_, _, err = ManifestDescriptor(&imgspecv1.Index{Manifests: []imgspecv1.Descriptor{busybox, shortBusybox}}, "library/busybox:latest")
+	assert.Error(t, err)
+}
+
+func TestRefNamesMatch(t *testing.T) {
+	for _, c := range []struct {
+		refName, image string
+		expected       bool
+	}{
+		{"image1", "image1", true},
+		{"image1", "image2", false},
+		{"latest", "library/latest", false}, // Tag-only names are not normalized
+		{"busybox:latest", "docker.io/library/busybox:latest", true},
+		{"docker.io/library/busybox:latest", "busybox:latest", true},
+		{"busybox", "busybox:latest", false}, // "busybox" is not a registry-style name
+		{"example.com/ns/repo:tag", "example.com/ns/repo:tag", true},
+		{"example.com/ns/repo:tag", "example.com/ns/repo:other", false},
+		{"example.com:5000/repo:tag", "example.com:5000/repo:tag", true},
+		{"Invalid/Name:tag", "invalid/name:tag", false},
+	} {
+		assert.Equal(t, c.expected, RefNamesMatch(c.refName, c.image), fmt.Sprintf("%q, %q", c.refName, c.image))
+	}
+}
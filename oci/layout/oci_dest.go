@@ -15,6 +15,7 @@ import (
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/internal/putblobdigest"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/internal"
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
 	imgspec "github.com/opencontainers/image-spec/specs-go"
@@ -124,7 +125,7 @@ func (d *ociImageDestination) PutBlobWithOptions(ctx context.Context, stream io.
 		}
 	}()
 
-	digester, stream := putblobdigest.DigestIfCanonicalUnknown(stream, inputInfo)
+	digester, stream := putblobdigest.DigestIfAlgorithmUnknown(stream, inputInfo, options.DigestAlgorithm)
 	// TODO: This can take quite some time, and should ideally be cancellable using ctx.Done().
 	size, err := io.Copy(blobFile, stream)
 	if err != nil {
@@ -163,7 +164,13 @@ func (d *ociImageDestination) PutBlobWithOptions(ctx context.Context, stream io.
 		return types.BlobInfo{}, err
 	}
 	succeeded = true
-	return types.BlobInfo{Digest: blobDigest, Size: size}, nil
+	return types.BlobInfo{Digest: blobDigest, Size: size, MediaType: inputInfo.MediaType}, nil
+}
+
+// SupportsDigestAlgorithm returns true if blobs and manifests with digests using algorithm can be stored,
+// and PutBlobOptions.DigestAlgorithm can be set to algorithm.
+func (d *ociImageDestination) SupportsDigestAlgorithm(algorithm digest.Algorithm) bool {
+	return algorithm.Available()
 }
 
 // TryReusingBlobWithOptions checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
@@ -189,7 +196,7 @@ func (d *ociImageDestination) TryReusingBlobWithOptions(ctx context.Context, inf
 		return false, types.BlobInfo{}, err
 	}
 
-	return true, types.BlobInfo{Digest: info.Digest, Size: finfo.Size()}, nil
+	return true, types.BlobInfo{Digest: info.Digest, Size: finfo.Size(), MediaType: info.MediaType}, nil
 }
 
 // PutManifest writes a manifest to the destination.  Per our list of supported manifest MIME types,
@@ -203,12 +210,18 @@ func (d *ociImageDestination) TryReusingBlobWithOptions(ctx context.Context, inf
 // If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
 // but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
 func (d *ociImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	return d.PutManifestWithDigestAlgorithm(ctx, m, instanceDigest, digest.Canonical)
+}
+
+// PutManifestWithDigestAlgorithm is PutManifest, except that if instanceDigest is nil, the digest of the manifest
+// recorded in the index is computed using algorithm instead of digest.Canonical.
+func (d *ociImageDestination) PutManifestWithDigestAlgorithm(ctx context.Context, m []byte, instanceDigest *digest.Digest, algorithm digest.Algorithm) error {
 	var digest digest.Digest
 	var err error
 	if instanceDigest != nil {
 		digest = *instanceDigest
 	} else {
-		digest, err = manifest.Digest(m)
+		digest, err = manifest.DigestWithAlgorithm(m, algorithm)
 		if err != nil {
 			return err
 		}
@@ -248,16 +261,51 @@ func (d *ociImageDestination) PutManifest(ctx context.Context, m []byte, instanc
 	return nil
 }
 
+// PutReferrerManifest writes m, an OCI image manifest with a subject, which has manifestDigest, and records it as a referrer of the subject.
+// The blobs m refers to must have already been written using PutBlobWithOptions.
+// Unlike PutManifest, this does not affect the manifest of the image being written, and it can be called after it
+// (but before Commit), whether or not the primary manifest is a manifest list.
+func (d *ociImageDestination) PutReferrerManifest(ctx context.Context, m []byte, manifestDigest digest.Digest) error {
+	matches, err := manifest.MatchesDigest(m, manifestDigest)
+	if err != nil {
+		return fmt.Errorf("digesting referrer manifest: %w", err)
+	}
+	if !matches {
+		return fmt.Errorf("referrer manifest does not match digest %s", manifestDigest.String())
+	}
+	// Referrers are listed in the index, so that they can be found; they are not named, so they don't replace the image.
+	desc, subject, err := internal.ReferrerDescriptor(m, manifest.GuessMIMEType(m), manifestDigest)
+	if err != nil {
+		return err
+	}
+	if subject == "" {
+		return fmt.Errorf("manifest %s is not an OCI image manifest with a subject", manifestDigest.String())
+	}
+	blobPath, err := d.ref.blobPath(manifestDigest, d.sharedBlobDir)
+	if err != nil {
+		return err
+	}
+	if err := ensureParentDirectoryExists(blobPath); err != nil {
+		return err
+	}
+	if err := os.WriteFile(blobPath, m, 0644); err != nil {
+		return err
+	}
+	d.addManifest(&desc)
+	return nil
+}
+
 func (d *ociImageDestination) addManifest(desc *imgspecv1.Descriptor) {
 	// If the new entry has a name, remove any conflicting names which we already have.
 	if desc.Annotations != nil && desc.Annotations[imgspecv1.AnnotationRefName] != "" {
 		// The name is being set on a new entry, so remove any older ones that had the same name.
 		// We might be storing an index and all of its component images, and we'll want to attach
 		// the name to the last one, which is the index.
+		// Names which only match after normalization (e.g. "busybox:latest" and "docker.io/library/busybox:latest")
+		// are removed as well, so that the name refers only to the new entry.
 		for i, manifest := range d.index.Manifests {
-			if manifest.Annotations[imgspecv1.AnnotationRefName] == desc.Annotations[imgspecv1.AnnotationRefName] {
+			if refName, ok := manifest.Annotations[imgspecv1.AnnotationRefName]; ok && internal.RefNamesMatch(refName, desc.Annotations[imgspecv1.AnnotationRefName]) {
 				delete(d.index.Manifests[i].Annotations, imgspecv1.AnnotationRefName)
-				break
 			}
 		}
 	}
@@ -318,3 +366,48 @@ func indexExists(ref ociReference) bool {
 	}
 	return true
 }
+
+// ociBlobProbe is a private.BlobProbe for an OCI layout reference.
+type ociBlobProbe struct {
+	ref           ociReference
+	sharedBlobDir string
+}
+
+// newBlobProbe returns a private.BlobProbe for ref.
+func newBlobProbe(sys *types.SystemContext, ref ociReference) private.BlobProbe {
+	p := &ociBlobProbe{ref: ref}
+	if sys != nil {
+		p.sharedBlobDir = sys.OCISharedBlobDirPath
+	}
+	return p
+}
+
+// BlobExists returns true if the layout (or the shared blob directory) already contains the blob with info.Digest.
+func (p *ociBlobProbe) BlobExists(ctx context.Context, info types.BlobInfo) (bool, error) {
+	if info.Digest == "" {
+		return false, errors.New("Can not check for a blob with unknown digest")
+	}
+	blobPath, err := p.ref.blobPath(info.Digest, p.sharedBlobDir)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(blobPath)
+	if err != nil && os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AcceptsForeignLayerURLs returns false iff foreign layers in manifest should be actually
+// uploaded to the image destination, true otherwise.
+func (p *ociBlobProbe) AcceptsForeignLayerURLs() bool {
+	return true
+}
+
+// Close removes resources associated with the probe, if any.
+func (p *ociBlobProbe) Close() error {
+	return nil
+}
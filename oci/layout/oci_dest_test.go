@@ -19,6 +19,9 @@ import (
 )
 
 var _ private.ImageDestination = (*ociImageDestination)(nil)
+var _ private.ImageDestinationWithReferrers = (*ociImageDestination)(nil)
+var _ private.ImageReferenceWithBlobProbes = ociReference{}
+var _ private.ImageReferenceWithExistenceCheck = ociReference{}
 
 // readerFromFunc allows implementing Reader by any function, e.g. a closure.
 type readerFromFunc func([]byte) (int, error)
@@ -131,6 +134,36 @@ func TestPutTwoDifferentTags(t *testing.T) {
 	assert.Equal(t, "zomg", index.Manifests[2].Annotations[imgspecv1.AnnotationRefName])
 }
 
+func TestPutNormalizedTag(t *testing.T) {
+	ref, tmpDir := refToTempOCI(t)
+	ociRef, ok := ref.(ociReference)
+	require.True(t, ok)
+	putTestConfig(t, ociRef, tmpDir)
+
+	for _, name := range []string{"docker.io/library/busybox:latest", "busybox:latest"} {
+		ref, err := NewReference(tmpDir, name)
+		require.NoError(t, err)
+		ociRef, ok = ref.(ociReference)
+		require.True(t, ok)
+		putTestManifest(t, ociRef, tmpDir)
+	}
+
+	// The second name replaces the first one, which refers to the same image after normalization.
+	index, err := ociRef.getIndex()
+	require.NoError(t, err)
+	assert.Len(t, index.Manifests, 2, "Unexpected number of manifests")
+	assert.Equal(t, "imageValue", index.Manifests[0].Annotations[imgspecv1.AnnotationRefName])
+	assert.Equal(t, "busybox:latest", index.Manifests[1].Annotations[imgspecv1.AnnotationRefName])
+
+	ref, err = NewReference(tmpDir, "docker.io/library/busybox:latest")
+	require.NoError(t, err)
+	ociRef, ok = ref.(ociReference)
+	require.True(t, ok)
+	desc, err := ociRef.getManifestDescriptor()
+	require.NoError(t, err)
+	assert.Equal(t, index.Manifests[1], desc)
+}
+
 func putTestConfig(t *testing.T, ociRef ociReference, tmpDir string) {
 	data, err := os.ReadFile("../../internal/image/fixtures/oci1-config.json")
 	assert.NoError(t, err)
@@ -178,3 +211,24 @@ func putTestManifest(t *testing.T, ociRef ociReference, tmpDir string) {
 	digest := digest.FromBytes(data).Encoded()
 	assert.Contains(t, paths, filepath.Join(tmpDir, "blobs", "sha256", digest), "The OCI directory does not contain the new manifest data")
 }
+
+func TestPutBlobMediaType(t *testing.T) {
+	const customMediaType = "application/vnd.example.custom.blob.v1+json"
+	blob := []byte("custom blob contents")
+
+	ref, _ := refToTempOCI(t)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: "", Size: -1, MediaType: customMediaType}, memory.New(), false)
+	require.NoError(t, err)
+	assert.Equal(t, customMediaType, info.MediaType)
+	assert.Equal(t, digest.FromBytes(blob), info.Digest)
+	assert.Equal(t, int64(len(blob)), info.Size)
+
+	// Reusing the blob also reports the caller-provided MediaType
+	reused, reusedInfo, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: info.Digest, Size: -1, MediaType: customMediaType}, memory.New(), false)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, customMediaType, reusedInfo.MediaType)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/containers/image/v5/internal/imagesource/stubs"
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/internal"
 	"github.com/containers/image/v5/pkg/tlsclientconfig"
 	"github.com/containers/image/v5/types"
 	"github.com/docker/go-connections/tlsconfig"
@@ -135,6 +136,35 @@ func (s *ociImageSource) GetManifest(ctx context.Context, instanceDigest *digest
 	return m, mimeType, nil
 }
 
+// GetReferrers returns descriptors of the manifests of artifacts with artifactType which refer to the manifest with manifestDigest,
+// or of artifacts of all types if artifactType is "".
+// Referrers are the OCI image manifests listed in index.json which refer to manifestDigest using their "subject" field.
+func (s *ociImageSource) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	res := []imgspecv1.Descriptor{}
+	for _, md := range s.index.Manifests {
+		if md.MediaType != imgspecv1.MediaTypeImageManifest || md.Digest == manifestDigest {
+			continue
+		}
+		manifestPath, err := s.ref.blobPath(md.Digest, s.sharedBlobDir)
+		if err != nil {
+			return nil, err
+		}
+		m, err := os.ReadFile(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		desc, subject, err := internal.ReferrerDescriptor(m, md.MediaType, md.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if subject != manifestDigest || (artifactType != "" && desc.ArtifactType != artifactType) {
+			continue
+		}
+		res = append(res, desc)
+	}
+	return res, nil
+}
+
 // GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
 // The Digest field in BlobInfo is guaranteed to be provided, Size may be -1 and MediaType may be optionally provided.
 // May update BlobInfoCache, preferably after it knows for certain that a blob truly exists at a specific location.
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 	"github.com/containers/image/v5/pkg/blobinfocache/memory"
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -138,3 +140,87 @@ func createImageSource(t *testing.T, sys *types.SystemContext) types.ImageSource
 	require.NoError(t, err)
 	return imageSource
 }
+
+func TestGetReferrers(t *testing.T) {
+	tmpDir := t.TempDir()
+	subject, err := os.ReadFile("../../internal/image/fixtures/oci1.json")
+	require.NoError(t, err)
+	subjectDigest := digest.FromBytes(subject)
+	const artifactType = "application/vnd.example.signature"
+	referrer, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     imgspecv1.MediaTypeImageManifest,
+		"artifactType":  artifactType,
+		"config": imgspecv1.Descriptor{
+			MediaType: "application/vnd.oci.empty.v1+json",
+			Digest:    digest.FromString("{}"),
+			Size:      2,
+		},
+		"layers": []imgspecv1.Descriptor{},
+		"subject": imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageManifest,
+			Digest:    subjectDigest,
+			Size:      int64(len(subject)),
+		},
+		"annotations": map[string]string{"org.example.key": "value"},
+	})
+	require.NoError(t, err)
+	referrerDigest := digest.FromBytes(referrer)
+
+	// Write the subject, tagged, and the referrer, as an untagged entry in index.json.
+	ref, err := NewReference(tmpDir, "latest")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutManifest(context.Background(), subject, nil)
+	require.NoError(t, err)
+	referrersDest, ok := dest.(private.ImageDestinationWithReferrers)
+	require.True(t, ok)
+	err = referrersDest.PutReferrerManifest(context.Background(), referrer, referrerDigest)
+	require.NoError(t, err)
+	// A manifest without a subject is rejected.
+	err = referrersDest.PutReferrerManifest(context.Background(), subject, subjectDigest)
+	assert.Error(t, err)
+	err = dest.Commit(context.Background(), nil) // nil unparsedToplevel is invalid, we don’t currently use the value
+	require.NoError(t, err)
+
+	for _, image := range []string{"latest", ""} {
+		ref, err := NewReference(tmpDir, image)
+		require.NoError(t, err)
+		src, err := ref.NewImageSource(context.Background(), nil)
+		require.NoError(t, err, image)
+		defer src.Close()
+		m, _, err := src.GetManifest(context.Background(), nil)
+		require.NoError(t, err, image)
+		assert.Equal(t, subject, m, image)
+
+		referrersSource, ok := src.(private.ImageSourceWithReferrers)
+		require.True(t, ok)
+		for _, c := range []struct {
+			artifactType string
+			expected     []digest.Digest
+		}{
+			{"", []digest.Digest{referrerDigest}},
+			{artifactType, []digest.Digest{referrerDigest}},
+			{"application/vnd.example.other", []digest.Digest{}},
+		} {
+			referrers, err := referrersSource.GetReferrers(context.Background(), subjectDigest, c.artifactType)
+			require.NoError(t, err)
+			digests := []digest.Digest{}
+			for _, desc := range referrers {
+				digests = append(digests, desc.Digest)
+				assert.Equal(t, imgspecv1.MediaTypeImageManifest, desc.MediaType)
+				assert.Equal(t, int64(len(referrer)), desc.Size)
+				assert.Equal(t, artifactType, desc.ArtifactType)
+				assert.Equal(t, map[string]string{"org.example.key": "value"}, desc.Annotations)
+			}
+			assert.Equal(t, c.expected, digests, c.artifactType)
+		}
+
+		// The referrer itself has no referrers.
+		referrers, err := referrersSource.GetReferrers(context.Background(), referrerDigest, "")
+		require.NoError(t, err)
+		assert.Empty(t, referrers)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/containers/image/v5/directory/explicitfilepath"
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/oci/internal"
 	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/types"
@@ -29,7 +30,7 @@ var (
 
 	// ErrMoreThanOneImage is an error returned when the manifest includes
 	// more than one image and the user should choose which one to use.
-	ErrMoreThanOneImage = errors.New("more than one image in oci, choose an image")
+	ErrMoreThanOneImage = internal.ErrMoreThanOneImage
 )
 
 type ociTransport struct{}
@@ -38,6 +39,17 @@ func (t ociTransport) Name() string {
 	return "oci"
 }
 
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "oci:path[:reference]"
+	ReferenceSyntaxExample = "oci:/var/tmp/layout:busybox"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t ociTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
 func (t ociTransport) ParseReference(reference string) (types.ImageReference, error) {
 	return ParseReference(reference)
@@ -179,25 +191,14 @@ func (ref ociReference) getManifestDescriptor() (imgspecv1.Descriptor, error) {
 		return imgspecv1.Descriptor{}, err
 	}
 
-	if ref.image == "" {
-		// return manifest if only one image is in the oci directory
-		if len(index.Manifests) != 1 {
-			// ask user to choose image when more than one image in the oci directory
-			return imgspecv1.Descriptor{}, ErrMoreThanOneImage
-		}
-		return index.Manifests[0], nil
-	} else {
-		// if image specified, look through all manifests for a match
-		for _, md := range index.Manifests {
-			if md.MediaType != imgspecv1.MediaTypeImageManifest && md.MediaType != imgspecv1.MediaTypeImageIndex {
-				continue
-			}
-			if refName, ok := md.Annotations[imgspecv1.AnnotationRefName]; ok && refName == ref.image {
-				return md, nil
-			}
-		}
+	md, found, err := internal.ManifestDescriptor(index, ref.image)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
 	}
-	return imgspecv1.Descriptor{}, ImageNotFoundError{ref}
+	if !found {
+		return imgspecv1.Descriptor{}, ImageNotFoundError{ref}
+	}
+	return md, nil
 }
 
 // LoadManifestDescriptor loads the manifest descriptor to be used to retrieve the image name
@@ -222,6 +223,25 @@ func (ref ociReference) NewImageDestination(ctx context.Context, sys *types.Syst
 	return newImageDestination(sys, ref)
 }
 
+// NewBlobProbe returns a private.BlobProbe for this reference, which checks for blobs in the layout without modifying it.
+// The caller must call .Close() on the returned BlobProbe.
+func (ref ociReference) NewBlobProbe(ctx context.Context, sys *types.SystemContext) (private.BlobProbe, error) {
+	return newBlobProbe(sys, ref), nil
+}
+
+// ImageExists returns true if the layout contains an image matching the reference, and false if it does not
+// (including the case where the layout does not exist yet).
+func (ref ociReference) ImageExists(ctx context.Context, sys *types.SystemContext) (bool, error) {
+	_, err := ref.getManifestDescriptor()
+	if err != nil {
+		if os.IsNotExist(err) || errors.As(err, &ImageNotFoundError{}) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // DeleteImage deletes the named image from the registry, if supported.
 func (ref ociReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	return errors.New("Deleting images not implemented for oci: images")
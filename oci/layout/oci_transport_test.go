@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/containers/image/v5/internal/private"
 	_ "github.com/containers/image/v5/internal/testing/explicitfilepath-tmpdir"
 	"github.com/containers/image/v5/types"
 	"github.com/stretchr/testify/assert"
@@ -72,6 +73,8 @@ func testParseReference(t *testing.T, fn func(string) (types.ImageReference, err
 		for _, image := range []struct{ suffix, image string }{
 			{":notlatest:image", "notlatest:image"},
 			{":latestimage", "latestimage"},
+			{":example.com/ns/repo:tag", "example.com/ns/repo:tag"},
+			{":localhost:5000/repo:tag", "localhost:5000/repo:tag"},
 			{":", ""},
 			{"", ""},
 		} {
@@ -244,6 +247,31 @@ func TestReferenceNewImageDestination(t *testing.T) {
 	defer dest.Close()
 }
 
+func TestReferenceImageExists(t *testing.T) {
+	ref, tmpDir := refToTempOCI(t)
+	exists, err := ref.(private.ImageReferenceWithExistenceCheck).ImageExists(context.Background(), nil)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// An image missing from the index, or a layout which does not exist, is reported as missing
+	for _, c := range []struct{ dir, image string }{
+		{tmpDir, "otherImage"},
+		{filepath.Join(tmpDir, "does-not-exist"), "imageValue"},
+	} {
+		ref, err := NewReference(c.dir, c.image)
+		require.NoError(t, err)
+		exists, err := ref.(private.ImageReferenceWithExistenceCheck).ImageExists(context.Background(), nil)
+		require.NoError(t, err, c.dir)
+		assert.False(t, exists, c.dir)
+	}
+
+	// Other failures are reported
+	err = os.WriteFile(filepath.Join(tmpDir, "index.json"), []byte("invalid"), 0o644)
+	require.NoError(t, err)
+	_, err = ref.(private.ImageReferenceWithExistenceCheck).ImageExists(context.Background(), nil)
+	assert.Error(t, err)
+}
+
 func TestReferenceDeleteImage(t *testing.T) {
 	ref, _ := refToTempOCI(t)
 	err := ref.DeleteImage(context.Background(), nil)
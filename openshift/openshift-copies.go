@@ -79,12 +79,13 @@ type clientConfig interface {
 }
 
 // defaultClientConfig is a modified copy of openshift/origin/pkg/cmd/util/clientcmd.DefaultClientConfig.
-func defaultClientConfig() clientConfig {
+// ADDED: logger, used for reporting problems with the configuration.
+func defaultClientConfig(logger logrus.FieldLogger) clientConfig {
 	loadingRules := newOpenShiftClientConfigLoadingRules()
 	// REMOVED: Allowing command-line overriding of loadingRules
 	// REMOVED: clientcmd.ConfigOverrides
 
-	clientConfig := newNonInteractiveDeferredLoadingClientConfig(loadingRules)
+	clientConfig := newNonInteractiveDeferredLoadingClientConfig(logger, loadingRules)
 
 	return clientConfig
 }
@@ -118,6 +119,7 @@ func newOpenShiftClientConfigLoadingRules() *clientConfigLoadingRules {
 // the parse happens and you want your calling code to be ignorant of how the values are being mutated to avoid
 // passing extraneous information down a call stack
 type deferredLoadingClientConfig struct {
+	logger       logrus.FieldLogger // ADDED
 	loadingRules *clientConfigLoadingRules
 
 	clientConfig clientConfig
@@ -125,8 +127,8 @@ type deferredLoadingClientConfig struct {
 
 // NewNonInteractiveDeferredLoadingClientConfig is a modified copy of k8s.io/kubernetes/pkg/client/unversioned/clientcmd.NewNonInteractiveDeferredLoadingClientConfig.
 // NewNonInteractiveDeferredLoadingClientConfig creates a ConfigClientClientConfig using the passed context name
-func newNonInteractiveDeferredLoadingClientConfig(loadingRules *clientConfigLoadingRules) clientConfig {
-	return &deferredLoadingClientConfig{loadingRules: loadingRules}
+func newNonInteractiveDeferredLoadingClientConfig(logger logrus.FieldLogger, loadingRules *clientConfigLoadingRules) clientConfig {
+	return &deferredLoadingClientConfig{logger: logger, loadingRules: loadingRules}
 }
 
 func (config *deferredLoadingClientConfig) createClientConfig() (clientConfig, error) {
@@ -138,7 +140,7 @@ func (config *deferredLoadingClientConfig) createClientConfig() (clientConfig, e
 		}
 
 		// REMOVED: Interactive fallback support.
-		mergedClientConfig := newNonInteractiveClientConfig(*mergedConfig)
+		mergedClientConfig := newNonInteractiveClientConfig(config.logger, *mergedConfig)
 
 		config.clientConfig = mergedClientConfig
 	}
@@ -174,13 +176,14 @@ var (
 // directClientConfig is a modified copy of k8s.io/kubernetes/pkg/client/unversioned/clientcmd.DirectClientConfig.
 // DirectClientConfig is a ClientConfig interface that is backed by a clientcmdapi.Config, options overrides, and an optional fallbackReader for auth information
 type directClientConfig struct {
+	logger logrus.FieldLogger // ADDED
 	config clientcmdConfig
 }
 
 // newNonInteractiveClientConfig is a modified copy of k8s.io/kubernetes/pkg/client/unversioned/clientcmd.NewNonInteractiveClientConfig.
 // NewNonInteractiveClientConfig creates a DirectClientConfig using the passed context name and does not have a fallback reader for auth information
-func newNonInteractiveClientConfig(config clientcmdConfig) clientConfig {
-	return &directClientConfig{config}
+func newNonInteractiveClientConfig(logger logrus.FieldLogger, config clientcmdConfig) clientConfig {
+	return &directClientConfig{logger: logger, config: config}
 }
 
 // ClientConfig is a modified copy of k8s.io/kubernetes/pkg/client/unversioned/clientcmd.DirectClientConfig.ClientConfig.
@@ -282,8 +285,8 @@ func getUserIdentificationPartialConfig(configAuthInfo clientcmdAuthInfo) (*rest
 // but no errors in the sections requested or referenced.  It does not return early so that it can find as many errors as possible.
 func (config *directClientConfig) ConfirmUsable() error {
 	var validationErrors []error
-	validationErrors = append(validationErrors, validateAuthInfo(config.getAuthInfoName(), config.getAuthInfo())...)
-	validationErrors = append(validationErrors, validateClusterInfo(config.getClusterName(), config.getCluster())...)
+	validationErrors = append(validationErrors, validateAuthInfo(config.logger, config.getAuthInfoName(), config.getAuthInfo())...)
+	validationErrors = append(validationErrors, validateClusterInfo(config.logger, config.getClusterName(), config.getCluster())...)
 	// when direct client config is specified, and our only error is that no server is defined, we should
 	// return a standard "no config" error
 	if len(validationErrors) == 1 && validationErrors[0] == errEmptyCluster {
@@ -318,7 +321,7 @@ func (config *directClientConfig) getContext() clientcmdContext {
 	var mergedContext clientcmdContext
 	if configContext, exists := contexts[contextName]; exists {
 		if err := mergo.MergeWithOverwrite(&mergedContext, configContext); err != nil {
-			logrus.Debugf("Can't merge configContext: %v", err)
+			config.logger.Debugf("Can't merge configContext: %v", err)
 		}
 	}
 	// REMOVED: overrides support
@@ -333,11 +336,11 @@ var (
 )
 
 // helper for checking certificate/key/CA
-func validateFileIsReadable(name string) error {
+func validateFileIsReadable(logger logrus.FieldLogger, name string) error {
 	answer, err := os.Open(name)
 	defer func() {
 		if err := answer.Close(); err != nil {
-			logrus.Debugf("Error closing %v: %v", name, err)
+			logger.Debugf("Error closing %v: %v", name, err)
 		}
 	}()
 	return err
@@ -345,7 +348,7 @@ func validateFileIsReadable(name string) error {
 
 // validateClusterInfo is a modified copy of k8s.io/kubernetes/pkg/client/unversioned/clientcmd.DirectClientConfig.validateClusterInfo.
 // validateClusterInfo looks for conflicts and errors in the cluster info
-func validateClusterInfo(clusterName string, clusterInfo clientcmdCluster) []error {
+func validateClusterInfo(logger logrus.FieldLogger, clusterName string, clusterInfo clientcmdCluster) []error {
 	var validationErrors []error
 
 	if reflect.DeepEqual(clientcmdCluster{}, clusterInfo) {
@@ -364,7 +367,7 @@ func validateClusterInfo(clusterName string, clusterInfo clientcmdCluster) []err
 		validationErrors = append(validationErrors, fmt.Errorf("certificate-authority-data and certificate-authority are both specified for %v. certificate-authority-data will override", clusterName))
 	}
 	if len(clusterInfo.CertificateAuthority) != 0 {
-		err := validateFileIsReadable(clusterInfo.CertificateAuthority)
+		err := validateFileIsReadable(logger, clusterInfo.CertificateAuthority)
 		if err != nil {
 			validationErrors = append(validationErrors, fmt.Errorf("unable to read certificate-authority %v for %v due to %v", clusterInfo.CertificateAuthority, clusterName, err))
 		}
@@ -375,7 +378,7 @@ func validateClusterInfo(clusterName string, clusterInfo clientcmdCluster) []err
 
 // validateAuthInfo is a modified copy of k8s.io/kubernetes/pkg/client/unversioned/clientcmd.DirectClientConfig.validateAuthInfo.
 // validateAuthInfo looks for conflicts and errors in the auth info
-func validateAuthInfo(authInfoName string, authInfo clientcmdAuthInfo) []error {
+func validateAuthInfo(logger logrus.FieldLogger, authInfoName string, authInfo clientcmdAuthInfo) []error {
 	var validationErrors []error
 
 	usingAuthPath := false
@@ -402,13 +405,13 @@ func validateAuthInfo(authInfoName string, authInfo clientcmdAuthInfo) []error {
 		}
 
 		if len(authInfo.ClientCertificate) != 0 {
-			err := validateFileIsReadable(authInfo.ClientCertificate)
+			err := validateFileIsReadable(logger, authInfo.ClientCertificate)
 			if err != nil {
 				validationErrors = append(validationErrors, fmt.Errorf("unable to read client-cert %v for %v due to %v", authInfo.ClientCertificate, authInfoName, err))
 			}
 		}
 		if len(authInfo.ClientKey) != 0 {
-			err := validateFileIsReadable(authInfo.ClientKey)
+			err := validateFileIsReadable(logger, authInfo.ClientKey)
 			if err != nil {
 				validationErrors = append(validationErrors, fmt.Errorf("unable to read client-key %v for %v due to %v", authInfo.ClientKey, authInfoName, err))
 			}
@@ -431,7 +434,7 @@ func (config *directClientConfig) getAuthInfo() clientcmdAuthInfo {
 	var mergedAuthInfo clientcmdAuthInfo
 	if configAuthInfo, exists := authInfos[authInfoName]; exists {
 		if err := mergo.MergeWithOverwrite(&mergedAuthInfo, configAuthInfo); err != nil {
-			logrus.Debugf("Can't merge configAuthInfo: %v", err)
+			config.logger.Debugf("Can't merge configAuthInfo: %v", err)
 		}
 	}
 	// REMOVED: overrides support
@@ -446,14 +449,14 @@ func (config *directClientConfig) getCluster() clientcmdCluster {
 
 	var mergedClusterInfo clientcmdCluster
 	if err := mergo.MergeWithOverwrite(&mergedClusterInfo, defaultCluster); err != nil {
-		logrus.Debugf("Can't merge defaultCluster: %v", err)
+		config.logger.Debugf("Can't merge defaultCluster: %v", err)
 	}
 	if err := mergo.MergeWithOverwrite(&mergedClusterInfo, envVarCluster); err != nil {
-		logrus.Debugf("Can't merge envVarCluster: %v", err)
+		config.logger.Debugf("Can't merge envVarCluster: %v", err)
 	}
 	if configClusterInfo, exists := clusterInfos[clusterInfoName]; exists {
 		if err := mergo.MergeWithOverwrite(&mergedClusterInfo, configClusterInfo); err != nil {
-			logrus.Debugf("Can't merge configClusterInfo: %v", err)
+			config.logger.Debugf("Can't merge configClusterInfo: %v", err)
 		}
 	}
 	// REMOVED: overrides support
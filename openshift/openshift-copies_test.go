@@ -3,6 +3,7 @@ package openshift
 import (
 	"testing"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -59,7 +60,7 @@ func TestDirectClientConfig(t *testing.T) {
 	config, err := rules.Load()
 	require.NoError(t, err)
 
-	direct := newNonInteractiveClientConfig(*config)
+	direct := newNonInteractiveClientConfig(logrus.StandardLogger(), *config)
 	res, err := direct.ClientConfig()
 	require.NoError(t, err)
 	assert.Equal(t, &restConfig{
@@ -76,7 +77,7 @@ func TestDeferredLoadingClientConfig(t *testing.T) {
 	setupKubeConfigForSerialTest(t)
 
 	rules := newOpenShiftClientConfigLoadingRules()
-	deferred := newNonInteractiveDeferredLoadingClientConfig(rules)
+	deferred := newNonInteractiveDeferredLoadingClientConfig(logrus.StandardLogger(), rules)
 	res, err := deferred.ClientConfig()
 	require.NoError(t, err)
 	assert.Equal(t, &restConfig{
@@ -92,7 +93,7 @@ func TestDeferredLoadingClientConfig(t *testing.T) {
 func TestDefaultClientConfig(t *testing.T) {
 	setupKubeConfigForSerialTest(t)
 
-	config := defaultClientConfig()
+	config := defaultClientConfig(logrus.StandardLogger())
 	res, err := config.ClientConfig()
 	require.NoError(t, err)
 	assert.Equal(t, &restConfig{
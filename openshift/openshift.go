@@ -13,6 +13,8 @@ import (
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/logger"
+	"github.com/containers/image/v5/types"
 	"github.com/containers/image/v5/version"
 	"github.com/sirupsen/logrus"
 )
@@ -21,6 +23,7 @@ import (
 type openshiftClient struct {
 	ref     openshiftReference
 	baseURL *url.URL
+	logger  logrus.FieldLogger // See types.SystemContext.Logger; never nil
 	// Values from Kubernetes configuration
 	httpClient  *http.Client
 	bearerToken string // "" if not used
@@ -29,7 +32,7 @@ type openshiftClient struct {
 }
 
 // newOpenshiftClient creates a new openshiftClient for the specified reference.
-func newOpenshiftClient(ref openshiftReference) (*openshiftClient, error) {
+func newOpenshiftClient(sys *types.SystemContext, ref openshiftReference) (*openshiftClient, error) {
 	// We have already done this parsing in ParseReference, but thrown away
 	// httpClient. So, parse again.
 	// (We could also rework/split restClientFor to "get base URL" to be done
@@ -37,19 +40,20 @@ func newOpenshiftClient(ref openshiftReference) (*openshiftClient, error) {
 	// we support non-default clusters, this is good enough.)
 
 	// Overall, this is modelled on openshift/origin/pkg/cmd/util/clientcmd.New().ClientConfig() and openshift/origin/pkg/client.
-	cmdConfig := defaultClientConfig()
-	logrus.Debugf("cmdConfig: %#v", cmdConfig)
+	log := logger.FromSystemContext(sys)
+	cmdConfig := defaultClientConfig(log)
+	log.Debugf("cmdConfig: %#v", cmdConfig)
 	restConfig, err := cmdConfig.ClientConfig()
 	if err != nil {
 		return nil, err
 	}
 	// REMOVED: SetOpenShiftDefaults (values are not overridable in config files, so hard-coded these defaults.)
-	logrus.Debugf("restConfig: %#v", restConfig)
+	log.Debugf("restConfig: %#v", restConfig)
 	baseURL, httpClient, err := restClientFor(restConfig)
 	if err != nil {
 		return nil, err
 	}
-	logrus.Debugf("URL: %#v", *baseURL)
+	log.Debugf("URL: %#v", *baseURL)
 
 	if httpClient == nil {
 		httpClient = http.DefaultClient
@@ -58,6 +62,7 @@ func newOpenshiftClient(ref openshiftReference) (*openshiftClient, error) {
 	return &openshiftClient{
 		ref:         ref,
 		baseURL:     baseURL,
+		logger:      log,
 		httpClient:  httpClient,
 		bearerToken: restConfig.BearerToken,
 		username:    restConfig.Username,
@@ -71,7 +76,7 @@ func (c *openshiftClient) doRequest(ctx context.Context, method, path string, re
 	requestURL.Path = path
 	var requestBodyReader io.Reader
 	if requestBody != nil {
-		logrus.Debugf("Will send body: %s", requestBody)
+		c.logger.Debugf("Will send body: %s", requestBody)
 		requestBodyReader = bytes.NewReader(requestBody)
 	}
 	req, err := http.NewRequestWithContext(ctx, method, requestURL.String(), requestBodyReader)
@@ -90,7 +95,7 @@ func (c *openshiftClient) doRequest(ctx context.Context, method, path string, re
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	logrus.Debugf("%s %s", method, requestURL.Redacted())
+	c.logger.Debugf("%s %s", method, requestURL.Redacted())
 	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -100,9 +105,9 @@ func (c *openshiftClient) doRequest(ctx context.Context, method, path string, re
 	if err != nil {
 		return nil, err
 	}
-	logrus.Debugf("Got body: %s", body)
+	c.logger.Debugf("Got body: %s", body)
 	// FIXME: Just throwing this useful information away only to try to guess later...
-	logrus.Debugf("Got content-type: %s", res.Header.Get("Content-Type"))
+	c.logger.Debugf("Got content-type: %s", res.Header.Get("Content-Type"))
 
 	var status status
 	statusValid := false
@@ -15,7 +15,6 @@ import (
 	"github.com/containers/image/v5/internal/signature"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
-	"github.com/sirupsen/logrus"
 )
 
 type openshiftImageSource struct {
@@ -37,7 +36,7 @@ type openshiftImageSource struct {
 // newImageSource creates a new ImageSource for the specified reference.
 // The caller must call .Close() on the returned ImageSource.
 func newImageSource(sys *types.SystemContext, ref openshiftReference) (private.ImageSource, error) {
-	client, err := newOpenshiftClient(ref)
+	client, err := newOpenshiftClient(sys, ref)
 	if err != nil {
 		return nil, err
 	}
@@ -153,12 +152,12 @@ func (s *openshiftImageSource) ensureImageIsResolved(ctx context.Context) error
 	if te == nil {
 		return errors.New("No matching tag found")
 	}
-	logrus.Debugf("tag event %#v", te)
+	s.client.logger.Debugf("tag event %#v", te)
 	dockerRefString, err := s.client.convertDockerImageReference(te.DockerImageReference)
 	if err != nil {
 		return err
 	}
-	logrus.Debugf("Resolved reference %#v", dockerRefString)
+	s.client.logger.Debugf("Resolved reference %#v", dockerRefString)
 	dockerRef, err := docker.ParseReference("//" + dockerRefString)
 	if err != nil {
 		return err
@@ -0,0 +1,32 @@
+package openshift
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOpenshiftClientLogger(t *testing.T) {
+	setupKubeConfigForSerialTest(t)
+
+	ref, err := ParseReference("registry.example.com:8443/ns/stream:notlatest")
+	require.NoError(t, err)
+	osRef, ok := ref.(openshiftReference)
+	require.True(t, ok)
+	logger, hook := logrustest.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	// The fixture does not contain valid certificates, so this fails, but only after logging the configuration.
+	_, err = newOpenshiftClient(&types.SystemContext{Logger: logger}, osRef)
+	assert.Error(t, err)
+	messages := []string{}
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	require.NotEmpty(t, messages)
+	assert.True(t, strings.HasPrefix(messages[0], "cmdConfig: "), messages[0])
+}
@@ -27,6 +27,17 @@ func (t openshiftTransport) Name() string {
 	return "atomic"
 }
 
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "atomic:hostname/namespace/stream:tag"
+	ReferenceSyntaxExample = "atomic:registry.example.com/myns/mystream:latest"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t openshiftTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
 func (t openshiftTransport) ParseReference(reference string) (types.ImageReference, error) {
 	return ParseReference(reference)
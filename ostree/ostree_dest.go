@@ -1,5 +1,5 @@
-//go:build containers_image_ostree
-// +build containers_image_ostree
+//go:build containers_image_ostree && linux
+// +build containers_image_ostree,linux
 
 package ostree
 
@@ -1,5 +1,5 @@
-//go:build containers_image_ostree
-// +build containers_image_ostree
+//go:build containers_image_ostree && linux
+// +build containers_image_ostree,linux
 
 package ostree
 
@@ -31,6 +31,11 @@ func (t ostreeTransport) Name() string {
 	return "ostree"
 }
 
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t ostreeTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 func init() {
 	transports.Register(Transport)
 }
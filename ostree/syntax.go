@@ -0,0 +1,8 @@
+package ostree
+
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+// They are defined without build constraints, so that the stub transport in transports/alltransports can use them.
+const (
+	ReferenceSyntaxFormat  = "ostree:docker-reference[@/absolute/repo/path]"
+	ReferenceSyntaxExample = "ostree:busybox:latest@/ostree/repo"
+)
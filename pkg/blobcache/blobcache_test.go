@@ -141,6 +141,9 @@ func TestBlobCache(t *testing.T) {
 				if err != nil {
 					t.Fatalf("error writing config blob to source image: %v", err)
 				}
+				if err := destImage.Commit(context.TODO(), nil); err != nil { // nil unparsedToplevel is invalid, we don’t currently use the value
+					t.Fatalf("error committing source image: %v", err)
+				}
 				srcImage, err := srcRef.NewImageSource(context.TODO(), &systemContext)
 				if err != nil {
 					t.Fatalf("error opening source image: %v", err)
@@ -194,7 +197,7 @@ func TestBlobCache(t *testing.T) {
 						}
 					}
 				}
-				// Clear out anything in the source directory that probably isn't a manifest, so that we'll
+				// Clear out anything in the source directory that probably isn't a manifest (or the version file), so that we'll
 				// have to depend on the cached copies of some of the blobs.
 				srcNameDir, err := os.Open(srcdir)
 				if err != nil {
@@ -206,7 +209,7 @@ func TestBlobCache(t *testing.T) {
 					t.Fatalf("error reading contents of source directory %q: %v", srcdir, err)
 				}
 				for _, name := range srcNames {
-					if !strings.HasPrefix(name, "manifest") {
+					if !strings.HasPrefix(name, "manifest") && name != "version" {
 						os.Remove(filepath.Join(srcdir, name))
 					}
 				}
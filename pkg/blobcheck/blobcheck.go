@@ -0,0 +1,205 @@
+// Package blobcheck verifies that the blobs referenced by an image, typically one stored locally
+// (e.g. in a dir: or oci: directory), exist and match their digests, without copying the image.
+// This allows diagnosing incomplete or corrupted images, e.g. after an interrupted copy.
+package blobcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Problem describes a blob referenced by an image which is missing, or does not match its digest.
+type Problem struct {
+	// Instance is the digest of the manifest list instance referencing the blob, or nil for the top-level manifest.
+	// If Manifest is set, the problem is with the manifest of this instance.
+	Instance *digest.Digest
+	// Manifest is set if the problem is with the manifest of Instance, instead of a blob it references.
+	Manifest bool
+	// BlobInfo is the blob as referenced by the manifest (or manifest list).
+	BlobInfo types.BlobInfo
+
+	// Missing is set if the blob does not exist.
+	Missing bool
+	// If the blob exists, ActualDigest and ActualSize describe its contents, in case they don’t match BlobInfo.
+	ActualDigest digest.Digest
+	ActualSize   int64
+	// Err is set if checking the blob failed for any other reason.
+	Err error
+}
+
+func (p Problem) String() string {
+	what := fmt.Sprintf("blob %s", p.BlobInfo.Digest)
+	if p.Manifest {
+		what = "manifest"
+		if p.BlobInfo.Digest != "" {
+			what += " " + p.BlobInfo.Digest.String()
+		}
+	}
+	if p.Instance != nil && !p.Manifest {
+		what += fmt.Sprintf(" of instance %s", p.Instance.String())
+	}
+	switch {
+	case p.Missing:
+		return what + " is missing"
+	case p.Err != nil:
+		return fmt.Sprintf("checking %s: %v", what, p.Err)
+	case p.ActualDigest != p.BlobInfo.Digest:
+		return fmt.Sprintf("%s has unexpected digest %s", what, p.ActualDigest)
+	default:
+		return fmt.Sprintf("%s has unexpected size %d, expected %d", what, p.ActualSize, p.BlobInfo.Size)
+	}
+}
+
+// Check reads the manifest of ref and, if it is a manifest list, the manifests of all of its instances,
+// and verifies that all blobs they reference exist and match their digests (and sizes, if known).
+// No data is written anywhere.
+//
+// It returns the problems found, if any; an error is only returned if the check could not be performed at all.
+// Layers with URLs (“foreign layers”) which are not present locally are not reported.
+func Check(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) ([]Problem, error) {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, fmt.Errorf("initializing source %s: %w", transports.ImageName(ref), err)
+	}
+	defer src.Close()
+
+	c := checker{
+		src:     src,
+		checked: map[digest.Digest]struct{}{},
+	}
+	toplevel, toplevelType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Problem{{Manifest: true, Missing: true}}, nil
+		}
+		return nil, fmt.Errorf("reading manifest of %s: %w", transports.ImageName(ref), err)
+	}
+	if toplevelType == "" {
+		toplevelType = manifest.GuessMIMEType(toplevel)
+	}
+	if !manifest.MIMETypeIsMultiImage(toplevelType) {
+		if err := c.checkImage(ctx, nil, toplevel, toplevelType); err != nil {
+			return nil, err
+		}
+		return c.problems, nil
+	}
+
+	list, err := manifest.ListFromBlob(toplevel, toplevelType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest list of %s: %w", transports.ImageName(ref), err)
+	}
+	for _, instanceDigest := range list.Instances() {
+		instanceDigest := instanceDigest
+		instance, err := list.Instance(instanceDigest)
+		if err != nil {
+			return nil, err
+		}
+		manifestInfo := types.BlobInfo{Digest: instanceDigest, Size: instance.Size, MediaType: instance.MediaType}
+		m, mimeType, err := src.GetManifest(ctx, &instanceDigest)
+		if err != nil {
+			problem := Problem{Instance: &instanceDigest, Manifest: true, BlobInfo: manifestInfo}
+			if errors.Is(err, os.ErrNotExist) {
+				problem.Missing = true
+			} else {
+				problem.Err = err
+			}
+			c.problems = append(c.problems, problem)
+			continue
+		}
+		if matches, err := manifest.MatchesDigest(m, instanceDigest); err != nil || !matches {
+			problem := Problem{Instance: &instanceDigest, Manifest: true, BlobInfo: manifestInfo, ActualSize: int64(len(m)), Err: err}
+			if err == nil {
+				problem.ActualDigest, problem.Err = manifest.Digest(m)
+			}
+			c.problems = append(c.problems, problem)
+			continue
+		}
+		if mimeType == "" {
+			mimeType = instance.MediaType
+		}
+		if err := c.checkImage(ctx, &instanceDigest, m, mimeType); err != nil {
+			return nil, err
+		}
+	}
+	return c.problems, nil
+}
+
+// checker collects the state of Check.
+type checker struct {
+	src      types.ImageSource
+	checked  map[digest.Digest]struct{} // Blobs which have already been checked, possibly for another instance
+	problems []Problem
+}
+
+// checkImage checks the blobs referenced by the single-image manifest m with mimeType, of instance (or of the top-level image, if nil).
+func (c *checker) checkImage(ctx context.Context, instance *digest.Digest, m []byte, mimeType string) error {
+	parsed, err := manifest.FromBlob(m, mimeType)
+	if err != nil {
+		return fmt.Errorf("parsing manifest: %w", err)
+	}
+	blobs := []types.BlobInfo{}
+	if config := parsed.ConfigInfo(); config.Digest != "" { // Schema1 manifests have no config blob
+		blobs = append(blobs, config)
+	}
+	for _, layer := range parsed.LayerInfos() {
+		blobs = append(blobs, layer.BlobInfo)
+	}
+	for _, blob := range blobs {
+		if _, ok := c.checked[blob.Digest]; ok {
+			continue
+		}
+		c.checked[blob.Digest] = struct{}{}
+		if problem := c.checkBlob(ctx, blob); problem != nil {
+			problem.Instance = instance
+			c.problems = append(c.problems, *problem)
+		}
+	}
+	return nil
+}
+
+// checkBlob checks a single blob, and returns a problem description if it is not usable, or nil.
+func (c *checker) checkBlob(ctx context.Context, blob types.BlobInfo) *Problem {
+	// Only look for a local copy, never download foreign layers from their URLs.
+	localInfo := blob
+	localInfo.URLs = nil
+	problem := Problem{BlobInfo: blob}
+	if err := blob.Digest.Validate(); err != nil {
+		problem.Err = err
+		return &problem
+	}
+	stream, _, err := c.src.GetBlob(ctx, localInfo, none.NoCache)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if len(blob.URLs) != 0 {
+				return nil
+			}
+			problem.Missing = true
+		} else {
+			problem.Err = err
+		}
+		return &problem
+	}
+	defer stream.Close()
+
+	digester := blob.Digest.Algorithm().Digester()
+	size, err := io.Copy(digester.Hash(), stream)
+	if err != nil {
+		problem.Err = err
+		return &problem
+	}
+	problem.ActualDigest = digester.Digest()
+	problem.ActualSize = size
+	if problem.ActualDigest != blob.Digest || (blob.Size != -1 && size != blob.Size) {
+		return &problem
+	}
+	return nil
+}
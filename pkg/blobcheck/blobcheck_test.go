@@ -0,0 +1,145 @@
+package blobcheck
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/internal/testing/testimage"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putTestImage writes an OCI image with layers with the specified contents to dest, as instanceDigest
+// (see types.ImageDestination.PutManifest), and returns its manifest, the digest of its config and the digests of its layers.
+func putTestImage(t *testing.T, dest types.ImageDestination, instanceDigest bool, layerContents ...string) ([]byte, digest.Digest, []digest.Digest) {
+	img := testimage.Image{ManifestMIMEType: imgspecv1.MediaTypeImageManifest}
+	layerDigests := []digest.Digest{}
+	for _, contents := range layerContents {
+		img.Layers = append(img.Layers, testimage.Layer{Blob: []byte(contents), MediaType: imgspecv1.MediaTypeImageLayer})
+		layerDigests = append(layerDigests, digest.FromString(contents))
+	}
+	manBlob, configDigest := testimage.PutBlobs(t, dest, img)
+	var d *digest.Digest
+	if instanceDigest {
+		manDigest := digest.FromBytes(manBlob)
+		d = &manDigest
+	}
+	require.NoError(t, dest.PutManifest(context.Background(), manBlob, d))
+	return manBlob, configDigest, layerDigests
+}
+
+// newTestLayout creates an OCI layout containing a single image with layers with the specified contents, and returns
+// a reference to it, the layout directory, and the digests of the image’s config and layers.
+func newTestLayout(t *testing.T, layerContents ...string) (types.ImageReference, string, digest.Digest, []digest.Digest) {
+	dir := filepath.Join(t.TempDir(), "layout")
+	ref, err := layout.NewReference(dir, "test")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	_, configDigest, layerDigests := putTestImage(t, dest, false, layerContents...)
+	require.NoError(t, dest.Commit(context.Background(), nil))
+	return ref, dir, configDigest, layerDigests
+}
+
+// layoutBlobPath returns the path of blob d in the OCI layout in dir.
+func layoutBlobPath(dir string, d digest.Digest) string {
+	return filepath.Join(dir, "blobs", d.Algorithm().String(), d.Encoded())
+}
+
+func TestCheckComplete(t *testing.T) {
+	ref, _, _, _ := newTestLayout(t, "layer 1", "layer 2")
+	problems, err := Check(context.Background(), nil, ref)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestCheckMissingLayer(t *testing.T) {
+	ref, dir, _, layerDigests := newTestLayout(t, "layer 1", "layer 2")
+	require.NoError(t, os.Remove(layoutBlobPath(dir, layerDigests[1])))
+
+	problems, err := Check(context.Background(), nil, ref)
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	p := problems[0]
+	assert.Equal(t, layerDigests[1], p.BlobInfo.Digest)
+	assert.True(t, p.Missing)
+	assert.False(t, p.Manifest)
+	assert.Nil(t, p.Instance)
+	assert.NoError(t, p.Err)
+	assert.Contains(t, p.String(), "is missing")
+}
+
+func TestCheckCorruptedBlob(t *testing.T) {
+	ref, dir, configDigest, layerDigests := newTestLayout(t, "layer 1", "layer 2")
+	require.NoError(t, os.WriteFile(layoutBlobPath(dir, layerDigests[0]), []byte("layer X"), 0o644))
+	require.NoError(t, os.WriteFile(layoutBlobPath(dir, configDigest), []byte("{}"), 0o644))
+
+	problems, err := Check(context.Background(), nil, ref)
+	require.NoError(t, err)
+	require.Len(t, problems, 2)
+	// The config is checked first
+	assert.Equal(t, configDigest, problems[0].BlobInfo.Digest)
+	assert.Equal(t, digest.FromString("{}"), problems[0].ActualDigest)
+	assert.Equal(t, layerDigests[0], problems[1].BlobInfo.Digest)
+	assert.Equal(t, digest.FromString("layer X"), problems[1].ActualDigest)
+	assert.Equal(t, int64(len("layer X")), problems[1].ActualSize)
+	for _, p := range problems {
+		assert.False(t, p.Missing)
+		assert.NoError(t, p.Err)
+		assert.Contains(t, p.String(), "unexpected digest")
+	}
+}
+
+func TestCheckManifestList(t *testing.T) {
+	dir := t.TempDir()
+	ref, err := directory.NewReference(dir)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	instances := []imgspecv1.Descriptor{}
+	var layers []digest.Digest
+	for _, contents := range []string{"image 1", "image 2"} {
+		manBlob, _, layerDigests := putTestImage(t, dest, true, "shared layer", contents)
+		layers = layerDigests
+		instances = append(instances, imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageManifest,
+			Digest:    digest.FromBytes(manBlob),
+			Size:      int64(len(manBlob)),
+		})
+	}
+	indexBlob, err := manifest.OCI1IndexFromComponents(instances, nil).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), indexBlob, nil))
+	require.NoError(t, dest.Commit(context.Background(), nil))
+
+	problems, err := Check(context.Background(), nil, ref)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// Remove the manifest of the first instance, and the last layer of the second one.
+	require.NoError(t, os.Remove(filepath.Join(dir, instances[0].Digest.Encoded()+".manifest.json")))
+	require.NoError(t, os.Remove(filepath.Join(dir, layers[1].Encoded())))
+	problems, err = Check(context.Background(), nil, ref)
+	require.NoError(t, err)
+	require.Len(t, problems, 2)
+	assert.True(t, problems[0].Manifest)
+	assert.True(t, problems[0].Missing)
+	require.NotNil(t, problems[0].Instance)
+	assert.Equal(t, instances[0].Digest, *problems[0].Instance)
+	assert.Equal(t, instances[0].Digest, problems[0].BlobInfo.Digest)
+	assert.False(t, problems[1].Manifest)
+	assert.True(t, problems[1].Missing)
+	require.NotNil(t, problems[1].Instance)
+	assert.Equal(t, instances[1].Digest, *problems[1].Instance)
+	assert.Equal(t, layers[1], problems[1].BlobInfo.Digest)
+}
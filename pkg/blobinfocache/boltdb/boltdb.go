@@ -194,37 +194,45 @@ func (bdc *cache) UncompressedDigest(anyDigest digest.Digest) digest.Digest {
 // (Eventually, the DiffIDs in image config could detect the substitution, but that may be too late, and not all image formats contain that data.)
 func (bdc *cache) RecordDigestUncompressedPair(anyDigest digest.Digest, uncompressed digest.Digest) {
 	_ = bdc.update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(uncompressedDigestBucket)
-		if err != nil {
-			return err
-		}
-		key := []byte(anyDigest.String())
-		if previousBytes := b.Get(key); previousBytes != nil {
-			previous, err := digest.Parse(string(previousBytes))
-			if err != nil {
-				return err
-			}
-			if previous != uncompressed {
-				logrus.Warnf("Uncompressed digest for blob %s previously recorded as %s, now %s", anyDigest, previous, uncompressed)
-			}
-		}
-		if err := b.Put(key, []byte(uncompressed.String())); err != nil {
-			return err
-		}
+		return bdc.recordDigestUncompressedPair(tx, anyDigest, uncompressed)
+	}) // FIXME? Log error (but throttle the log volume on repeated accesses)?
+}
 
-		b, err = tx.CreateBucketIfNotExists(digestByUncompressedBucket)
-		if err != nil {
-			return err
-		}
-		b, err = b.CreateBucketIfNotExists([]byte(uncompressed.String()))
+// recordDigestUncompressedPair implements RecordDigestUncompressedPair within the provided read-write transaction.
+func (bdc *cache) recordDigestUncompressedPair(tx *bolt.Tx, anyDigest digest.Digest, uncompressed digest.Digest) error {
+	if !blobinfocache.DigestsAreRecordable(anyDigest, uncompressed) {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists(uncompressedDigestBucket)
+	if err != nil {
+		return err
+	}
+	key := []byte(anyDigest.String())
+	if previousBytes := b.Get(key); previousBytes != nil {
+		previous, err := digest.Parse(string(previousBytes))
 		if err != nil {
 			return err
 		}
-		if err := b.Put([]byte(anyDigest.String()), []byte{}); err != nil { // Possibly writing the same []byte{} presence marker again.
-			return err
+		if previous != uncompressed {
+			logrus.Warnf("Uncompressed digest for blob %s previously recorded as %s, now %s", anyDigest, previous, uncompressed)
 		}
-		return nil
-	}) // FIXME? Log error (but throttle the log volume on repeated accesses)?
+	}
+	if err := b.Put(key, []byte(uncompressed.String())); err != nil {
+		return err
+	}
+
+	b, err = tx.CreateBucketIfNotExists(digestByUncompressedBucket)
+	if err != nil {
+		return err
+	}
+	b, err = b.CreateBucketIfNotExists([]byte(uncompressed.String()))
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(anyDigest.String()), []byte{}); err != nil { // Possibly writing the same []byte{} presence marker again.
+		return err
+	}
+	return nil
 }
 
 // RecordDigestCompressorName records that the blob with digest anyDigest was compressed with the specified
@@ -234,40 +242,39 @@ func (bdc *cache) RecordDigestUncompressedPair(anyDigest digest.Digest, uncompre
 // (Eventually, the DiffIDs in image config could detect the substitution, but that may be too late, and not all image formats contain that data.)
 func (bdc *cache) RecordDigestCompressorName(anyDigest digest.Digest, compressorName string) {
 	_ = bdc.update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(digestCompressorBucket)
-		if err != nil {
-			return err
-		}
-		key := []byte(anyDigest.String())
-		if previousBytes := b.Get(key); previousBytes != nil {
-			if string(previousBytes) != compressorName {
-				logrus.Warnf("Compressor for blob with digest %s previously recorded as %s, now %s", anyDigest, string(previousBytes), compressorName)
-			}
-		}
-		if compressorName == blobinfocache.UnknownCompression {
-			return b.Delete(key)
-		}
-		return b.Put(key, []byte(compressorName))
+		return bdc.recordDigestCompressorName(tx, anyDigest, compressorName)
 	}) // FIXME? Log error (but throttle the log volume on repeated accesses)?
 }
 
+// recordDigestCompressorName implements RecordDigestCompressorName within the provided read-write transaction.
+func (bdc *cache) recordDigestCompressorName(tx *bolt.Tx, anyDigest digest.Digest, compressorName string) error {
+	if !blobinfocache.DigestsAreRecordable(anyDigest) {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists(digestCompressorBucket)
+	if err != nil {
+		return err
+	}
+	key := []byte(anyDigest.String())
+	if previousBytes := b.Get(key); previousBytes != nil {
+		if string(previousBytes) != compressorName {
+			logrus.Warnf("Compressor for blob with digest %s previously recorded as %s, now %s", anyDigest, string(previousBytes), compressorName)
+		}
+	}
+	if compressorName == blobinfocache.UnknownCompression {
+		return b.Delete(key)
+	}
+	return b.Put(key, []byte(compressorName))
+}
+
 // RecordKnownLocation records that a blob with the specified digest exists within the specified (transport, scope) scope,
 // and can be reused given the opaque location data.
 func (bdc *cache) RecordKnownLocation(transport types.ImageTransport, scope types.BICTransportScope, blobDigest digest.Digest, location types.BICLocationReference) {
+	if !blobinfocache.DigestsAreRecordable(blobDigest) {
+		return
+	}
 	_ = bdc.update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(knownLocationsBucket)
-		if err != nil {
-			return err
-		}
-		b, err = b.CreateBucketIfNotExists([]byte(transport.Name()))
-		if err != nil {
-			return err
-		}
-		b, err = b.CreateBucketIfNotExists([]byte(scope.Opaque))
-		if err != nil {
-			return err
-		}
-		b, err = b.CreateBucketIfNotExists([]byte(blobDigest.String()))
+		b, err := bdc.locationsBucket(tx, transport.Name(), scope, blobDigest)
 		if err != nil {
 			return err
 		}
@@ -282,6 +289,24 @@ func (bdc *cache) RecordKnownLocation(transport types.ImageTransport, scope type
 	}) // FIXME? Log error (but throttle the log volume on repeated accesses)?
 }
 
+// locationsBucket returns the (possibly newly created) bucket of known locations for (transportName, scope, blobDigest)
+// within the provided read-write transaction.
+func (bdc *cache) locationsBucket(tx *bolt.Tx, transportName string, scope types.BICTransportScope, blobDigest digest.Digest) (*bolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists(knownLocationsBucket)
+	if err != nil {
+		return nil, err
+	}
+	b, err = b.CreateBucketIfNotExists([]byte(transportName))
+	if err != nil {
+		return nil, err
+	}
+	b, err = b.CreateBucketIfNotExists([]byte(scope.Opaque))
+	if err != nil {
+		return nil, err
+	}
+	return b.CreateBucketIfNotExists([]byte(blobDigest.String()))
+}
+
 // appendReplacementCandidates creates prioritize.CandidateWithTime values for digest in scopeBucket with corresponding compression info from compressionBucket (if compressionBucket is not nil), and returns the result of appending them to candidates.
 func (bdc *cache) appendReplacementCandidates(candidates []prioritize.CandidateWithTime, scopeBucket, compressionBucket *bolt.Bucket, digest digest.Digest, requireCompressionInfo bool) []prioritize.CandidateWithTime {
 	digestKey := []byte(digest.String())
@@ -391,3 +416,135 @@ func (bdc *cache) candidateLocations(transport types.ImageTransport, scope types
 func (bdc *cache) CandidateLocations(transport types.ImageTransport, scope types.BICTransportScope, primaryDigest digest.Digest, canSubstitute bool) []types.BICReplacementCandidate {
 	return blobinfocache.CandidateLocationsFromV2(bdc.candidateLocations(transport, scope, primaryDigest, canSubstitute, false))
 }
+
+// Export returns all data recorded in the cache.
+func (bdc *cache) Export() (*blobinfocache.Contents, error) {
+	res := &blobinfocache.Contents{
+		UncompressedDigests: map[digest.Digest]digest.Digest{},
+		Compressors:         map[digest.Digest]string{},
+		KnownLocations:      []blobinfocache.KnownLocation{},
+	}
+	if err := bdc.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(uncompressedDigestBucket); b != nil {
+			if err := b.ForEach(func(k, v []byte) error {
+				anyDigest, err := digest.Parse(string(k))
+				if err != nil {
+					return err
+				}
+				uncompressed, err := digest.Parse(string(v))
+				if err != nil {
+					return err
+				}
+				res.UncompressedDigests[anyDigest] = uncompressed
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(digestCompressorBucket); b != nil {
+			if err := b.ForEach(func(k, v []byte) error {
+				d, err := digest.Parse(string(k))
+				if err != nil {
+					return err
+				}
+				res.Compressors[d] = string(v)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(knownLocationsBucket); b != nil {
+			// The outer levels only contain nested buckets, so the values passed to their ForEach callbacks are all nil.
+			return b.ForEach(func(transportName, _ []byte) error {
+				transportBucket := b.Bucket(transportName)
+				if transportBucket == nil {
+					return nil
+				}
+				return transportBucket.ForEach(func(scope, _ []byte) error {
+					scopeBucket := transportBucket.Bucket(scope)
+					if scopeBucket == nil {
+						return nil
+					}
+					return scopeBucket.ForEach(func(digestKey, _ []byte) error {
+						digestBucket := scopeBucket.Bucket(digestKey)
+						if digestBucket == nil {
+							return nil
+						}
+						d, err := digest.Parse(string(digestKey))
+						if err != nil {
+							return err
+						}
+						return digestBucket.ForEach(func(location, value []byte) error {
+							t := time.Time{}
+							if err := t.UnmarshalBinary(value); err != nil {
+								return err
+							}
+							res.KnownLocations = append(res.KnownLocations, blobinfocache.KnownLocation{
+								Transport: string(transportName),
+								Scope:     types.BICTransportScope{Opaque: string(scope)},
+								Digest:    d,
+								Location:  types.BICLocationReference{Opaque: string(location)},
+								LastSeen:  t,
+							})
+							return nil
+						})
+					})
+				})
+			})
+		}
+		return nil
+	}); err != nil && !os.IsNotExist(err) { // A cache which was never written to is empty.
+		return nil, fmt.Errorf("exporting blob info cache %q: %w", bdc.path, err)
+	}
+	return res, nil
+}
+
+// Import records all of contents in the cache, in addition to any data already recorded.
+// A known location which is already recorded is only updated if contents contains a more recent LastSeen value.
+func (bdc *cache) Import(contents *blobinfocache.Contents) error {
+	if err := blobinfocache.ValidateContents(contents); err != nil {
+		return fmt.Errorf("importing into blob info cache %q: %w", bdc.path, err)
+	}
+	if err := bdc.update(func(tx *bolt.Tx) error {
+		for anyDigest, uncompressed := range contents.UncompressedDigests {
+			if err := bdc.recordDigestUncompressedPair(tx, anyDigest, uncompressed); err != nil {
+				return err
+			}
+		}
+		for d, compressorName := range contents.Compressors {
+			if compressorName == blobinfocache.UnknownCompression {
+				continue
+			}
+			if err := bdc.recordDigestCompressorName(tx, d, compressorName); err != nil {
+				return err
+			}
+		}
+		for _, l := range contents.KnownLocations {
+			if !blobinfocache.DigestsAreRecordable(l.Digest) {
+				continue
+			}
+			b, err := bdc.locationsBucket(tx, l.Transport, l.Scope, l.Digest)
+			if err != nil {
+				return err
+			}
+			key := []byte(l.Location.Opaque)
+			if previousBytes := b.Get(key); previousBytes != nil {
+				previous := time.Time{}
+				if err := previous.UnmarshalBinary(previousBytes); err == nil && !l.LastSeen.After(previous) {
+					continue
+				}
+			}
+			value, err := l.LastSeen.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("importing into blob info cache %q: %w", bdc.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package blobinfocache
+
+import (
+	"fmt"
+
+	internalblobinfocache "github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/types"
+)
+
+// Contents is all data recorded in a blob info cache, as returned by Export.
+// It can be serialized as JSON, e.g. to pre-seed a cache on another machine using Import.
+type Contents = internalblobinfocache.Contents
+
+// KnownLocation is a single location of a blob recorded in a blob info cache.
+type KnownLocation = internalblobinfocache.KnownLocation
+
+// Export returns all data recorded in cache, which must have been created by this package,
+// or by the pkg/blobinfocache/memory or pkg/blobinfocache/boltdb packages.
+func Export(cache types.BlobInfoCache) (*Contents, error) {
+	c, ok := cache.(internalblobinfocache.BlobInfoCacheWithContents)
+	if !ok {
+		return nil, fmt.Errorf("exporting the contents of blob info cache %T is not supported", cache)
+	}
+	return c.Export()
+}
+
+// Import records all of contents in cache, which must have been created by this package,
+// or by the pkg/blobinfocache/memory or pkg/blobinfocache/boltdb packages, in addition to any data already recorded.
+// A known location which is already recorded is only updated if contents contains a more recent LastSeen value.
+// WARNING: Only import data from a trusted source; otherwise the cache could be poisoned and allow substituting unexpected blobs.
+func Import(cache types.BlobInfoCache, contents *Contents) error {
+	c, ok := cache.(internalblobinfocache.BlobInfoCacheWithContents)
+	if !ok {
+		return fmt.Errorf("importing into blob info cache %T is not supported", cache)
+	}
+	return c.Import(contents)
+}
@@ -0,0 +1,164 @@
+package blobinfocache
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/internal/testing/mocks"
+	"github.com/containers/image/v5/pkg/blobinfocache/boltdb"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	digestUncompressed = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+	digestCompressedA  = digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+	digestCompressedB  = digest.Digest("sha256:4444444444444444444444444444444444444444444444444444444444444444")
+)
+
+// normalizedContents returns c with KnownLocations in a fixed order and without monotonic clock readings, for comparisons.
+func normalizedContents(c *Contents) *Contents {
+	res := *c
+	res.KnownLocations = []KnownLocation{}
+	for _, l := range c.KnownLocations {
+		l.LastSeen = l.LastSeen.Round(0).UTC()
+		res.KnownLocations = append(res.KnownLocations, l)
+	}
+	sort.Slice(res.KnownLocations, func(i, j int) bool {
+		a, b := res.KnownLocations[i], res.KnownLocations[j]
+		if a.Scope != b.Scope {
+			return a.Scope.Opaque < b.Scope.Opaque
+		}
+		if a.Digest != b.Digest {
+			return a.Digest < b.Digest
+		}
+		return a.Location.Opaque < b.Location.Opaque
+	})
+	return &res
+}
+
+func TestExportImport(t *testing.T) {
+	transport := mocks.NameImageTransport("==BlobInfocache transport mock")
+	scopeA := types.BICTransportScope{Opaque: "scope a"}
+	scopeB := types.BICTransportScope{Opaque: "scope b"}
+
+	original := memory.New()
+	original.RecordDigestUncompressedPair(digestCompressedA, digestUncompressed)
+	original.RecordDigestUncompressedPair(digestCompressedB, digestUncompressed)
+	require.NoError(t, Import(original, &Contents{
+		Compressors: map[digest.Digest]string{
+			digestUncompressed: "uncompressed",
+			digestCompressedA:  "gzip",
+			digestCompressedB:  "zstd",
+		},
+	}))
+	original.RecordKnownLocation(transport, scopeA, digestCompressedA, types.BICLocationReference{Opaque: "A1"})
+	original.RecordKnownLocation(transport, scopeA, digestCompressedB, types.BICLocationReference{Opaque: "B1"})
+	original.RecordKnownLocation(transport, scopeB, digestCompressedA, types.BICLocationReference{Opaque: "A2"})
+	original.RecordKnownLocation(transport, scopeB, digestCompressedA, types.BICLocationReference{Opaque: "A3"})
+
+	exported, err := Export(original)
+	require.NoError(t, err)
+	assert.Equal(t, map[digest.Digest]digest.Digest{
+		digestCompressedA: digestUncompressed,
+		digestCompressedB: digestUncompressed,
+	}, exported.UncompressedDigests)
+	assert.Equal(t, map[digest.Digest]string{
+		digestUncompressed: "uncompressed",
+		digestCompressedA:  "gzip",
+		digestCompressedB:  "zstd",
+	}, exported.Compressors)
+	assert.Len(t, exported.KnownLocations, 4)
+
+	// The contents survive a round trip through JSON
+	serialized, err := json.Marshal(exported)
+	require.NoError(t, err)
+	var deserialized Contents
+	err = json.Unmarshal(serialized, &deserialized)
+	require.NoError(t, err)
+	assert.Equal(t, normalizedContents(exported), normalizedContents(&deserialized))
+
+	// memory → boltdb → memory
+	bolt := boltdb.New(filepath.Join(t.TempDir(), "db"))
+	emptyBolt, err := Export(bolt) // The database file does not exist yet
+	require.NoError(t, err)
+	assert.Equal(t, &Contents{
+		UncompressedDigests: map[digest.Digest]digest.Digest{},
+		Compressors:         map[digest.Digest]string{},
+		KnownLocations:      []KnownLocation{},
+	}, emptyBolt)
+	err = Import(bolt, &deserialized)
+	require.NoError(t, err)
+	boltExported, err := Export(bolt)
+	require.NoError(t, err)
+	assert.Equal(t, normalizedContents(exported), normalizedContents(boltExported))
+	mem := memory.New()
+	err = Import(mem, boltExported)
+	require.NoError(t, err)
+	memExported, err := Export(mem)
+	require.NoError(t, err)
+	assert.Equal(t, normalizedContents(exported), normalizedContents(memExported))
+
+	// The imported data is used by the caches
+	for _, c := range []types.BlobInfoCache{bolt, mem} {
+		assert.Equal(t, digestUncompressed, c.UncompressedDigest(digestCompressedA))
+		candidates := c.CandidateLocations(transport, scopeB, digestCompressedA, false)
+		assert.Len(t, candidates, 2)
+	}
+
+	// Importing older data does not overwrite newer known locations; newer data does.
+	for _, c := range []types.BlobInfoCache{bolt, mem} {
+		location := exported.KnownLocations[0]
+		for _, timestamp := range []time.Time{time.Unix(1, 0), time.Now().Add(time.Hour)} {
+			location.LastSeen = timestamp
+			err := Import(c, &Contents{KnownLocations: []KnownLocation{location}})
+			require.NoError(t, err)
+			contents, err := Export(c)
+			require.NoError(t, err)
+			found := false
+			for _, l := range contents.KnownLocations {
+				if l.Transport == location.Transport && l.Scope == location.Scope && l.Digest == location.Digest && l.Location == location.Location {
+					found = true
+					if timestamp.Before(time.Now()) {
+						assert.True(t, l.LastSeen.After(timestamp))
+					} else {
+						assert.True(t, l.LastSeen.Equal(timestamp))
+					}
+				}
+			}
+			assert.True(t, found)
+		}
+	}
+
+	// Invalid digests are rejected, without recording any of the contents.
+	for _, invalid := range []*Contents{
+		{UncompressedDigests: map[digest.Digest]digest.Digest{digestCompressedA: digestUncompressed, "sha256:short": digestUncompressed}},
+		{UncompressedDigests: map[digest.Digest]digest.Digest{digestCompressedA: "sha256:not-hex!"}},
+		{UncompressedDigests: map[digest.Digest]digest.Digest{digestCompressedA: ""}},
+		{Compressors: map[digest.Digest]string{"unknown:3333333333333333333333333333333333333333333333333333333333333333": "gzip"}},
+		{KnownLocations: []KnownLocation{{Transport: transport.Name(), Scope: scopeA, Digest: "sha256:ABC", Location: types.BICLocationReference{Opaque: "x"}}}},
+	} {
+		for _, c := range []types.BlobInfoCache{boltdb.New(filepath.Join(t.TempDir(), "cache.db")), memory.New()} {
+			err := Import(c, invalid)
+			assert.Error(t, err, "%#v", invalid)
+			contents, err := Export(c)
+			require.NoError(t, err)
+			assert.Empty(t, contents.UncompressedDigests)
+			assert.Empty(t, contents.Compressors)
+			assert.Empty(t, contents.KnownLocations)
+		}
+	}
+
+	// Caches which don't support exporting and importing
+	_, err = Export(none.NoCache)
+	assert.Error(t, err)
+	err = Import(none.NoCache, exported)
+	assert.Error(t, err)
+}
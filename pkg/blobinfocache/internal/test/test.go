@@ -17,6 +17,7 @@ const (
 	digestCompressedA         = digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
 	digestCompressedB         = digest.Digest("sha256:4444444444444444444444444444444444444444444444444444444444444444")
 	digestCompressedUnrelated = digest.Digest("sha256:5555555555555555555555555555555555555555555555555555555555555555")
+	digestSHA512              = digest.Digest("sha512:66666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666666")
 	compressorNameU           = "compressorName/U"
 	compressorNameA           = "compressorName/A"
 	compressorNameB           = "compressorName/B"
@@ -35,6 +36,7 @@ func GenericCache(t *testing.T, newTestCache func(t *testing.T) blobinfocache.Bl
 		{"RecordKnownLocations", testGenericRecordKnownLocations},
 		{"CandidateLocations", testGenericCandidateLocations},
 		{"CandidateLocations2", testGenericCandidateLocations2},
+		{"NonCanonicalDigests", testGenericNonCanonicalDigests},
 	} {
 		t.Run(s.name, func(t *testing.T) {
 			cache := newTestCache(t)
@@ -95,6 +97,28 @@ func testGenericRecordKnownLocations(t *testing.T, cache blobinfocache.BlobInfoC
 	}
 }
 
+func testGenericNonCanonicalDigests(t *testing.T, cache blobinfocache.BlobInfoCache2) {
+	transport := mocks.NameImageTransport("==BlobInfocache transport mock")
+	scope := types.BICTransportScope{Opaque: "A"}
+	lr := types.BICLocationReference{Opaque: "A1"}
+
+	// Nothing is recorded about digests using other algorithms than digest.Canonical.
+	cache.RecordDigestUncompressedPair(digestSHA512, digestUncompressed)
+	cache.RecordDigestUncompressedPair(digestCompressedA, digestSHA512)
+	cache.RecordDigestCompressorName(digestSHA512, compressorNameA)
+	cache.RecordKnownLocation(transport, scope, digestSHA512, lr)
+	assert.Equal(t, digest.Digest(""), cache.UncompressedDigest(digestSHA512))
+	assert.Equal(t, digest.Digest(""), cache.UncompressedDigest(digestCompressedA))
+	assert.Equal(t, digest.Digest(""), cache.UncompressedDigest(digestUncompressed))
+	assert.Equal(t, []types.BICReplacementCandidate{}, cache.CandidateLocations(transport, scope, digestSHA512, true))
+
+	// A sha512 blob is never offered as a substitute for a blob with the same uncompressed digest.
+	cache.RecordDigestUncompressedPair(digestCompressedA, digestUncompressed)
+	cache.RecordKnownLocation(transport, scope, digestCompressedA, lr)
+	assert.Equal(t, []types.BICReplacementCandidate{{Digest: digestCompressedA, Location: lr}},
+		cache.CandidateLocations(transport, scope, digestUncompressed, true))
+}
+
 // candidate is a shorthand for types.BICReplacementCandidate
 type candidate struct {
 	d  digest.Digest
@@ -81,6 +81,14 @@ func (mem *cache) uncompressedDigestLocked(anyDigest digest.Digest) digest.Diges
 func (mem *cache) RecordDigestUncompressedPair(anyDigest digest.Digest, uncompressed digest.Digest) {
 	mem.mutex.Lock()
 	defer mem.mutex.Unlock()
+	mem.recordDigestUncompressedPairLocked(anyDigest, uncompressed)
+}
+
+// recordDigestUncompressedPairLocked implements RecordDigestUncompressedPair, but must be called only with mem.mutex held.
+func (mem *cache) recordDigestUncompressedPairLocked(anyDigest digest.Digest, uncompressed digest.Digest) {
+	if !blobinfocache.DigestsAreRecordable(anyDigest, uncompressed) {
+		return
+	}
 	if previous, ok := mem.uncompressedDigests[anyDigest]; ok && previous != uncompressed {
 		logrus.Warnf("Uncompressed digest for blob %s previously recorded as %s, now %s", anyDigest, previous, uncompressed)
 	}
@@ -97,20 +105,32 @@ func (mem *cache) RecordDigestUncompressedPair(anyDigest digest.Digest, uncompre
 // RecordKnownLocation records that a blob with the specified digest exists within the specified (transport, scope) scope,
 // and can be reused given the opaque location data.
 func (mem *cache) RecordKnownLocation(transport types.ImageTransport, scope types.BICTransportScope, blobDigest digest.Digest, location types.BICLocationReference) {
+	if !blobinfocache.DigestsAreRecordable(blobDigest) {
+		return
+	}
 	mem.mutex.Lock()
 	defer mem.mutex.Unlock()
 	key := locationKey{transport: transport.Name(), scope: scope, blobDigest: blobDigest}
+	mem.locationScopeLocked(key)[location] = time.Now() // Possibly overwriting an older entry.
+}
+
+// locationScopeLocked returns the (possibly newly created) map of locations for key in mem.knownLocations.
+// It must be called only with mem.mutex held.
+func (mem *cache) locationScopeLocked(key locationKey) map[types.BICLocationReference]time.Time {
 	locationScope, ok := mem.knownLocations[key]
 	if !ok {
 		locationScope = map[types.BICLocationReference]time.Time{}
 		mem.knownLocations[key] = locationScope
 	}
-	locationScope[location] = time.Now() // Possibly overwriting an older entry.
+	return locationScope
 }
 
 // RecordDigestCompressorName records that the blob with the specified digest is either compressed with the specified
 // algorithm, or uncompressed, or that we no longer know.
 func (mem *cache) RecordDigestCompressorName(blobDigest digest.Digest, compressorName string) {
+	if !blobinfocache.DigestsAreRecordable(blobDigest) {
+		return
+	}
 	mem.mutex.Lock()
 	defer mem.mutex.Unlock()
 	if compressorName == blobinfocache.UnknownCompression {
@@ -184,3 +204,61 @@ func (mem *cache) candidateLocations(transport types.ImageTransport, scope types
 	}
 	return prioritize.DestructivelyPrioritizeReplacementCandidates(res, primaryDigest, uncompressedDigest)
 }
+
+// Export returns all data recorded in the cache.
+func (mem *cache) Export() (*blobinfocache.Contents, error) {
+	mem.mutex.Lock()
+	defer mem.mutex.Unlock()
+	res := &blobinfocache.Contents{
+		UncompressedDigests: map[digest.Digest]digest.Digest{},
+		Compressors:         map[digest.Digest]string{},
+		KnownLocations:      []blobinfocache.KnownLocation{},
+	}
+	for anyDigest, uncompressed := range mem.uncompressedDigests {
+		res.UncompressedDigests[anyDigest] = uncompressed
+	}
+	for d, compressorName := range mem.compressors {
+		res.Compressors[d] = compressorName
+	}
+	for key, locationScope := range mem.knownLocations {
+		for location, lastSeen := range locationScope {
+			res.KnownLocations = append(res.KnownLocations, blobinfocache.KnownLocation{
+				Transport: key.transport,
+				Scope:     key.scope,
+				Digest:    key.blobDigest,
+				Location:  location,
+				LastSeen:  lastSeen,
+			})
+		}
+	}
+	return res, nil
+}
+
+// Import records all of contents in the cache, in addition to any data already recorded.
+// A known location which is already recorded is only updated if contents contains a more recent LastSeen value.
+func (mem *cache) Import(contents *blobinfocache.Contents) error {
+	if err := blobinfocache.ValidateContents(contents); err != nil {
+		return err
+	}
+	mem.mutex.Lock()
+	defer mem.mutex.Unlock()
+	for anyDigest, uncompressed := range contents.UncompressedDigests {
+		mem.recordDigestUncompressedPairLocked(anyDigest, uncompressed)
+	}
+	for d, compressorName := range contents.Compressors {
+		if compressorName == blobinfocache.UnknownCompression || !blobinfocache.DigestsAreRecordable(d) {
+			continue
+		}
+		mem.compressors[d] = compressorName
+	}
+	for _, l := range contents.KnownLocations {
+		if !blobinfocache.DigestsAreRecordable(l.Digest) {
+			continue
+		}
+		locationScope := mem.locationScopeLocked(locationKey{transport: l.Transport, scope: l.Scope, blobDigest: l.Digest})
+		if previous, ok := locationScope[l.Location]; !ok || l.LastSeen.After(previous) {
+			locationScope[l.Location] = l.LastSeen
+		}
+	}
+	return nil
+}
@@ -7,7 +7,7 @@ import (
 	"github.com/containers/image/v5/pkg/blobinfocache/internal/test"
 )
 
-var _ blobinfocache.BlobInfoCache2 = &cache{}
+var _ blobinfocache.BlobInfoCacheWithContents = &cache{}
 
 func newTestCache(t *testing.T) blobinfocache.BlobInfoCache2 {
 	return new2()
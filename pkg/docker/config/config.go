@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -65,7 +66,7 @@ func SetCredentials(sys *types.SystemContext, key, username, password string) (s
 		return "", err
 	}
 
-	helpers, err := sysregistriesv2.CredentialHelpers(sys)
+	helpers, err := credentialHelpers(sys)
 	if err != nil {
 		return "", err
 	}
@@ -80,6 +81,9 @@ func SetCredentials(sys *types.SystemContext, key, username, password string) (s
 		case sysregistriesv2.AuthenticationFileHelper:
 			desc, err = modifyJSON(sys, func(auths *dockerConfigFile) (bool, error) {
 				if ch, exists := auths.CredHelpers[key]; exists {
+					if sys != nil && sys.DisableCredentialHelpers {
+						return false, credentialHelpersDisabledErr(ch, key)
+					}
 					if isNamespaced {
 						return false, unsupportedNamespaceErr(ch)
 					}
@@ -114,6 +118,19 @@ func unsupportedNamespaceErr(helper string) error {
 	return fmt.Errorf("namespaced key is not supported for credential helper %s", helper)
 }
 
+func credentialHelpersDisabledErr(helper, key string) error {
+	return fmt.Errorf("credential helper %s is configured for %s, but credential helpers are disabled", helper, key)
+}
+
+// credentialHelpers returns the credential helpers to use for sys, in order;
+// only the built-in helper for auth files if sys.DisableCredentialHelpers is set.
+func credentialHelpers(sys *types.SystemContext) ([]string, error) {
+	if sys != nil && sys.DisableCredentialHelpers {
+		return []string{sysregistriesv2.AuthenticationFileHelper}, nil
+	}
+	return sysregistriesv2.CredentialHelpers(sys)
+}
+
 // SetAuthentication stores the username and password in the credential helper or file
 // See the documentation of SetCredentials for format of "key"
 func SetAuthentication(sys *types.SystemContext, key, username, password string) error {
@@ -137,7 +154,7 @@ func GetAllCredentials(sys *types.SystemContext) (map[string]types.DockerAuthCon
 	// While we're at it, we’ll also canonicalize docker.io to the standard format.
 	normalizedDockerIORegistry := normalizeRegistry("docker.io")
 
-	helpers, err := sysregistriesv2.CredentialHelpers(sys)
+	helpers, err := credentialHelpers(sys)
 	if err != nil {
 		return nil, err
 	}
@@ -241,7 +258,12 @@ func getAuthFilePaths(sys *types.SystemContext, homeDir string) []authPath {
 //
 // GetCredentialsForRef should almost always be used in favor of this API.
 func GetCredentials(sys *types.SystemContext, key string) (types.DockerAuthConfig, error) {
-	return getCredentialsWithHomeDir(sys, key, homedir.Get())
+	return GetCredentialsWithContext(context.Background(), sys, key)
+}
+
+// GetCredentialsWithContext is GetCredentials, using ctx for types.SystemContext.DockerCredentialProvider.
+func GetCredentialsWithContext(ctx context.Context, sys *types.SystemContext, key string) (types.DockerAuthConfig, error) {
+	return getCredentialsWithHomeDir(ctx, sys, key, homedir.Get())
 }
 
 // GetCredentialsForRef returns the registry credentials necessary for
@@ -249,13 +271,18 @@ func GetCredentials(sys *types.SystemContext, key string) (types.DockerAuthConfi
 // appropriate for sys and the users’ configuration.
 // If an entry is not found, an empty struct is returned.
 func GetCredentialsForRef(sys *types.SystemContext, ref reference.Named) (types.DockerAuthConfig, error) {
-	return getCredentialsWithHomeDir(sys, ref.Name(), homedir.Get())
+	return GetCredentialsForRefWithContext(context.Background(), sys, ref)
+}
+
+// GetCredentialsForRefWithContext is GetCredentialsForRef, using ctx for types.SystemContext.DockerCredentialProvider.
+func GetCredentialsForRefWithContext(ctx context.Context, sys *types.SystemContext, ref reference.Named) (types.DockerAuthConfig, error) {
+	return getCredentialsWithHomeDir(ctx, sys, ref.Name(), homedir.Get())
 }
 
 // getCredentialsWithHomeDir is an internal implementation detail of
 // GetCredentialsForRef and GetCredentials. It exists only to allow testing it
 // with an artificial home directory.
-func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (types.DockerAuthConfig, error) {
+func getCredentialsWithHomeDir(ctx context.Context, sys *types.SystemContext, key, homeDir string) (types.DockerAuthConfig, error) {
 	_, err := validateKey(key)
 	if err != nil {
 		return types.DockerAuthConfig{}, err
@@ -273,10 +300,21 @@ func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (t
 		registry = key
 	}
 
+	if sys != nil && sys.DockerCredentialProvider != nil {
+		creds, found, err := sys.DockerCredentialProvider.GetCredentials(ctx, key)
+		if err != nil {
+			return types.DockerAuthConfig{}, fmt.Errorf("looking up credentials for %s in the credential provider: %w", key, err)
+		}
+		if found {
+			logrus.Debugf("Returning credentials for %s from DockerCredentialProvider", key)
+			return creds, nil
+		}
+	}
+
 	// Anonymous function to query credentials from auth files.
 	getCredentialsFromAuthFiles := func() (types.DockerAuthConfig, string, error) {
 		for _, path := range getAuthFilePaths(sys, homeDir) {
-			authConfig, err := findCredentialsInFile(key, registry, path.path, path.legacyFormat)
+			authConfig, err := findCredentialsInFile(sys, key, registry, path.path, path.legacyFormat)
 			if err != nil {
 				return types.DockerAuthConfig{}, "", err
 			}
@@ -288,7 +326,7 @@ func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (t
 		return types.DockerAuthConfig{}, "", nil
 	}
 
-	helpers, err := sysregistriesv2.CredentialHelpers(sys)
+	helpers, err := credentialHelpers(sys)
 	if err != nil {
 		return types.DockerAuthConfig{}, err
 	}
@@ -351,7 +389,7 @@ func GetAuthentication(sys *types.SystemContext, key string) (string, string, er
 // getAuthenticationWithHomeDir is an internal implementation detail of GetAuthentication,
 // it exists only to allow testing it with an artificial home directory.
 func getAuthenticationWithHomeDir(sys *types.SystemContext, key, homeDir string) (string, string, error) {
-	auth, err := getCredentialsWithHomeDir(sys, key, homeDir)
+	auth, err := getCredentialsWithHomeDir(context.Background(), sys, key, homeDir)
 	if err != nil {
 		return "", "", err
 	}
@@ -371,7 +409,7 @@ func RemoveAuthentication(sys *types.SystemContext, key string) error {
 		return err
 	}
 
-	helpers, err := sysregistriesv2.CredentialHelpers(sys)
+	helpers, err := credentialHelpers(sys)
 	if err != nil {
 		return err
 	}
@@ -405,6 +443,9 @@ func RemoveAuthentication(sys *types.SystemContext, key string) error {
 		case sysregistriesv2.AuthenticationFileHelper:
 			_, err = modifyJSON(sys, func(auths *dockerConfigFile) (bool, error) {
 				if innerHelper, exists := auths.CredHelpers[key]; exists {
+					if sys != nil && sys.DisableCredentialHelpers {
+						return false, credentialHelpersDisabledErr(innerHelper, key)
+					}
 					removeFromCredHelper(innerHelper)
 				}
 				if _, ok := auths.AuthConfigs[key]; ok {
@@ -435,7 +476,7 @@ func RemoveAuthentication(sys *types.SystemContext, key string) error {
 // RemoveAllAuthentication deletes all the credentials stored in credential
 // helpers and auth files.
 func RemoveAllAuthentication(sys *types.SystemContext) error {
-	helpers, err := sysregistriesv2.CredentialHelpers(sys)
+	helpers, err := credentialHelpers(sys)
 	if err != nil {
 		return err
 	}
@@ -448,6 +489,9 @@ func RemoveAllAuthentication(sys *types.SystemContext) error {
 		case sysregistriesv2.AuthenticationFileHelper:
 			_, err = modifyJSON(sys, func(auths *dockerConfigFile) (bool, error) {
 				for registry, helper := range auths.CredHelpers {
+					if sys != nil && sys.DisableCredentialHelpers {
+						return false, credentialHelpersDisabledErr(helper, registry)
+					}
 					// Helpers in auth files are expected
 					// to exist, so no special treatment
 					// for them.
@@ -655,7 +699,8 @@ func deleteAuthFromCredHelper(credHelper, registry string) error {
 
 // findCredentialsInFile looks for credentials matching "key"
 // (which is "registry" or a namespace in "registry") in "path".
-func findCredentialsInFile(key, registry, path string, legacyFormat bool) (types.DockerAuthConfig, error) {
+// If sys.DisableCredentialHelpers is set, a credHelpers entry for "registry" is not used, and it is treated as having no credentials.
+func findCredentialsInFile(sys *types.SystemContext, key, registry, path string, legacyFormat bool) (types.DockerAuthConfig, error) {
 	auths, err := readJSONFile(path, legacyFormat)
 	if err != nil {
 		return types.DockerAuthConfig{}, fmt.Errorf("reading JSON file %q: %w", path, err)
@@ -665,6 +710,10 @@ func findCredentialsInFile(key, registry, path string, legacyFormat bool) (types
 	// This intentionally uses "registry", not "key"; we don't support namespaced
 	// credentials in helpers.
 	if ch, exists := auths.CredHelpers[registry]; exists {
+		if sys != nil && sys.DisableCredentialHelpers {
+			logrus.Debugf("Not looking up in credential helper %s based on credHelpers entry in %s, credential helpers are disabled", ch, path)
+			return types.DockerAuthConfig{}, nil
+		}
 		logrus.Debugf("Looking up in credential helper %s based on credHelpers entry in %s", ch, path)
 		return getAuthFromCredHelper(ch, registry)
 	}
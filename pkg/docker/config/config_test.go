@@ -1,11 +1,14 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/containers/image/v5/docker/reference"
@@ -283,7 +286,7 @@ func TestGetAuth(t *testing.T) {
 					sys = tc.sys
 				}
 
-				auth, err := getCredentialsWithHomeDir(sys, tc.key, tmpHomeDir)
+				auth, err := getCredentialsWithHomeDir(context.Background(), sys, tc.key, tmpHomeDir)
 				require.NoError(t, err)
 				assert.Equal(t, tc.expected, auth)
 
@@ -340,7 +343,7 @@ func TestGetAuthFromLegacyFile(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			auth, err := getCredentialsWithHomeDir(nil, tc.hostname, tmpDir)
+			auth, err := getCredentialsWithHomeDir(context.Background(), nil, tc.hostname, tmpDir)
 			require.NoError(t, err)
 			assert.Equal(t, tc.expected, auth)
 
@@ -385,7 +388,7 @@ func TestGetAuthPreferNewConfig(t *testing.T) {
 		}
 	}
 
-	auth, err := getCredentialsWithHomeDir(nil, "docker.io", tmpDir)
+	auth, err := getCredentialsWithHomeDir(context.Background(), nil, "docker.io", tmpDir)
 	assert.NoError(t, err)
 	assert.Equal(t, "docker", auth.Username)
 	assert.Equal(t, "io", auth.Password)
@@ -409,7 +412,7 @@ func TestGetAuthFailsOnBadInput(t *testing.T) {
 	configPath := filepath.Join(configDir, "auth.json")
 
 	// no config file present
-	auth, err := getCredentialsWithHomeDir(nil, "index.docker.io", tmpHomeDir)
+	auth, err := getCredentialsWithHomeDir(context.Background(), nil, "index.docker.io", tmpHomeDir)
 	if err != nil {
 		t.Fatalf("got unexpected error: %#+v", err)
 	}
@@ -418,13 +421,13 @@ func TestGetAuthFailsOnBadInput(t *testing.T) {
 	if err := os.WriteFile(configPath, []byte("Json rocks! Unless it doesn't."), 0640); err != nil {
 		t.Fatalf("failed to write file %q: %v", configPath, err)
 	}
-	_, err = getCredentialsWithHomeDir(nil, "index.docker.io", tmpHomeDir)
+	_, err = getCredentialsWithHomeDir(context.Background(), nil, "index.docker.io", tmpHomeDir)
 	assert.ErrorContains(t, err, "unmarshaling JSON")
 
 	// remove the invalid config file
 	os.RemoveAll(configPath)
 	// no config file present
-	auth, err = getCredentialsWithHomeDir(nil, "index.docker.io", tmpHomeDir)
+	auth, err = getCredentialsWithHomeDir(context.Background(), nil, "index.docker.io", tmpHomeDir)
 	if err != nil {
 		t.Fatalf("got unexpected error: %#+v", err)
 	}
@@ -434,7 +437,7 @@ func TestGetAuthFailsOnBadInput(t *testing.T) {
 	if err := os.WriteFile(configPath, []byte("I'm certainly not a json string."), 0640); err != nil {
 		t.Fatalf("failed to write file %q: %v", configPath, err)
 	}
-	_, err = getCredentialsWithHomeDir(nil, "index.docker.io", tmpHomeDir)
+	_, err = getCredentialsWithHomeDir(context.Background(), nil, "index.docker.io", tmpHomeDir)
 	assert.ErrorContains(t, err, "unmarshaling JSON")
 }
 
@@ -877,7 +880,7 @@ func TestSetGetCredentials(t *testing.T) {
 		require.NoError(t, err)
 
 		// Try to authenticate against them
-		auth, err := getCredentialsWithHomeDir(sys, tc.get, tmpDir)
+		auth, err := getCredentialsWithHomeDir(context.Background(), sys, tc.get, tmpDir)
 		require.NoError(t, err)
 
 		if tc.shouldAuth {
@@ -889,3 +892,157 @@ func TestSetGetCredentials(t *testing.T) {
 		}
 	}
 }
+
+func TestDisableCredentialHelpers(t *testing.T) {
+	// A credential helper which records that it was executed, and returns credentials for any registry.
+	helperDir := t.TempDir()
+	executedPath := filepath.Join(helperDir, "executed")
+	helper := fmt.Sprintf("#!/bin/sh\ntouch %q\nread UNUSED\n"+
+		`echo '{"ServerURL":"registry-a.com","Username":"helper-user","Secret":"helper-password"}'`+"\n", executedPath)
+	err := os.WriteFile(filepath.Join(helperDir, "docker-credential-helper-recording"), []byte(helper), 0o755)
+	require.NoError(t, err)
+	t.Setenv("PATH", fmt.Sprintf("%s:%s", helperDir, os.Getenv("PATH")))
+
+	registriesConfPath := filepath.Join(t.TempDir(), "registries.conf")
+	err = os.WriteFile(registriesConfPath, []byte(`credential-helpers = [ "containers-auth.json", "helper-recording" ]`), 0o600)
+	require.NoError(t, err)
+	authFilePath := filepath.Join(t.TempDir(), "auth.json")
+	err = os.WriteFile(authFilePath, []byte(`{
+		"auths": {"example.org": {"auth": "ZXhhbXBsZTpvcmc="}},
+		"credHelpers": {"registry-a.com": "helper-recording"}
+	}`), 0o600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		AuthFilePath:                authFilePath,
+		SystemRegistriesConfPath:    registriesConfPath,
+		SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+		DisableCredentialHelpers:    true,
+	}
+
+	// Credentials in the auth file are found.
+	auth, err := GetCredentials(sys, "example.org")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "example", Password: "org"}, auth)
+	// Neither the credHelpers entry nor the helper in registries.conf is used.
+	for _, key := range []string{"registry-a.com", "registry-b.com"} {
+		auth, err := GetCredentials(sys, key)
+		require.NoError(t, err)
+		assert.Equal(t, types.DockerAuthConfig{}, auth, key)
+	}
+	allCreds, err := GetAllCredentials(sys)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]types.DockerAuthConfig{"example.org": {Username: "example", Password: "org"}}, allCreds)
+
+	// Storing and removing credentials of registries with a credHelpers entry fails.
+	_, err = SetCredentials(sys, "registry-a.com", "user", "password")
+	assert.Error(t, err)
+	err = RemoveAuthentication(sys, "registry-a.com")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotLoggedIn))
+	err = RemoveAllAuthentication(sys)
+	assert.Error(t, err)
+	// Other credentials are stored in the auth file.
+	desc, err := SetCredentials(sys, "registry-b.com", "user", "password")
+	require.NoError(t, err)
+	assert.Equal(t, authFilePath, desc)
+	auth, err = GetCredentials(sys, "registry-b.com")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", Password: "password"}, auth)
+	err = RemoveAuthentication(sys, "registry-b.com")
+	require.NoError(t, err)
+
+	_, err = os.Stat(executedPath)
+	assert.True(t, os.IsNotExist(err), "the credential helper was executed")
+
+	// Without DisableCredentialHelpers, the credHelpers entry is used.
+	sys.DisableCredentialHelpers = false
+	auth, err = GetCredentials(sys, "registry-a.com")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "helper-user", Password: "helper-password"}, auth)
+	_, err = os.Stat(executedPath)
+	assert.NoError(t, err)
+}
+
+// testCredentialProvider is a types.DockerCredentialProvider which returns credentials for the repositories in a single registry.
+type testCredentialProvider struct {
+	registry string
+	creds    types.DockerAuthConfig
+	err      error
+	queried  []string
+}
+
+// testCredentialProviderContextKey is a key of a context.Context value recorded by testCredentialProvider.
+type testCredentialProviderContextKey struct{}
+
+func (p *testCredentialProvider) GetCredentials(ctx context.Context, key string) (types.DockerAuthConfig, bool, error) {
+	queried := key
+	if v, ok := ctx.Value(testCredentialProviderContextKey{}).(string); ok {
+		queried += " " + v
+	}
+	p.queried = append(p.queried, queried)
+	if p.err != nil {
+		return types.DockerAuthConfig{}, false, p.err
+	}
+	if key != p.registry && !strings.HasPrefix(key, p.registry+"/") {
+		return types.DockerAuthConfig{}, false, nil
+	}
+	return p.creds, true, nil
+}
+
+func TestDockerCredentialProvider(t *testing.T) {
+	registriesConfPath := filepath.Join(t.TempDir(), "registries.conf")
+	err := os.WriteFile(registriesConfPath, []byte(`credential-helpers = [ "containers-auth.json" ]`), 0o600)
+	require.NoError(t, err)
+	authFilePath := filepath.Join(t.TempDir(), "auth.json")
+	err = os.WriteFile(authFilePath, []byte(`{
+		"auths": {
+			"example.org": {"auth": "ZXhhbXBsZTpvcmc="},
+			"registry.example.com": {"auth": "ZXhhbXBsZTpvcmc="}
+		}
+	}`), 0o600)
+	require.NoError(t, err)
+	provider := &testCredentialProvider{
+		registry: "registry.example.com",
+		creds:    types.DockerAuthConfig{Username: "provider-user", IdentityToken: "provider-token"},
+	}
+	sys := &types.SystemContext{
+		AuthFilePath:                authFilePath,
+		SystemRegistriesConfPath:    registriesConfPath,
+		SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+		DockerCredentialProvider:    provider,
+	}
+
+	// The provider takes precedence over the auth file, and is queried with the full key, and the context of the caller.
+	auth, err := GetCredentials(sys, "registry.example.com/ns/repo")
+	require.NoError(t, err)
+	assert.Equal(t, provider.creds, auth)
+	ref, err := reference.ParseNamed("registry.example.com/ns/repo:tag")
+	require.NoError(t, err)
+	ctx := context.WithValue(context.Background(), testCredentialProviderContextKey{}, "ctx")
+	auth, err = GetCredentialsForRefWithContext(ctx, sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, provider.creds, auth)
+	// Registries the provider declines use the auth file.
+	auth, err = GetCredentials(sys, "example.org")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "example", Password: "org"}, auth)
+	auth, err = GetCredentials(sys, "unknown.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{}, auth)
+	assert.Equal(t, []string{"registry.example.com/ns/repo", "registry.example.com/ns/repo ctx", "example.org", "unknown.example.com"}, provider.queried)
+
+	// DockerAuthConfig overrides the provider.
+	provider.queried = nil
+	auth, err = GetCredentials(&types.SystemContext{
+		DockerAuthConfig:         &types.DockerAuthConfig{Username: "foo", Password: "bar"},
+		DockerCredentialProvider: provider,
+	}, "registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "foo", Password: "bar"}, auth)
+	assert.Empty(t, provider.queried)
+
+	// Errors from the provider are reported.
+	provider.err = errors.New("secret manager unavailable")
+	_, err = GetCredentials(sys, "example.org")
+	assert.ErrorIs(t, err, provider.err)
+}
@@ -0,0 +1,290 @@
+// Package flatten exports the merged filesystem of an image, i.e. the result of applying all of its layers in order,
+// as a single tar stream.
+//
+// Layer whiteouts are applied as in an overlay filesystem: a whiteout removes the file or directory from lower layers,
+// an opaque directory marker removes the contents of the directory in lower layers, and replacing a directory with
+// a non-directory removes the directory’s contents. The whiteout markers themselves are not included in the output.
+//
+// Hard links which refer to a file present in the merged filesystem are written as hard links to it.
+// If the target of a hard link was removed or replaced by a later layer, the link still refers to the original
+// contents of the file (as it would in an overlay filesystem); then the first such link is written as a regular file
+// with those original contents, and any other links to the same original file are written as hard links to it.
+package flatten
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache"
+	"github.com/containers/image/v5/pkg/layertar"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+)
+
+// entryID identifies an entry of a layer, by the index of the layer in the image and of the entry in the layer.
+type entryID struct {
+	layer int
+	index int
+}
+
+// before returns true if id precedes other in the order the layers are applied.
+func (id entryID) before(other entryID) bool {
+	return id.layer < other.layer || (id.layer == other.layer && id.index < other.index)
+}
+
+// visibleEntry is the entry which determines a path in the merged filesystem.
+type visibleEntry struct {
+	id  entryID
+	dir bool
+}
+
+// plan records which entries of the layers are written to the output, and how.
+type plan struct {
+	paths      map[entryID]string      // Entries written to the output, with their paths
+	dirHeaders map[entryID]*tar.Header // Directory entries whose metadata is replaced by a later layer, with the later header
+	linkNames  map[entryID]string      // Hard links written to the output, with the path of their target
+	promoted   map[entryID]string      // Hard link targets not in the merged filesystem, with the path to write them to
+}
+
+// Image writes the merged filesystem of the image at srcRef to w, as an uncompressed tar stream.
+// The image is accepted only if policyContext allows running it; if srcRef is a manifest list, the instance
+// appropriate for sys is used.
+//
+// The layers are read twice: first to determine which entries are a part of the merged filesystem, and then to write them,
+// so that the output does not need to be buffered. Some of the output may have been written to w even if this fails.
+func Image(ctx context.Context, policyContext *signature.PolicyContext, w io.Writer, srcRef types.ImageReference, sys *types.SystemContext) (retErr error) {
+	src, err := srcRef.NewImageSource(ctx, sys)
+	if err != nil {
+		return fmt.Errorf("initializing source %s: %w", srcRef.StringWithinTransport(), err)
+	}
+	defer func() {
+		if err := src.Close(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("closing source %s: %w", srcRef.StringWithinTransport(), err)
+		}
+	}()
+
+	unparsed := image.UnparsedInstance(src, nil)
+	if allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsed); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
+		return fmt.Errorf("Source image rejected: %w", err)
+	}
+	manifestBlob, mimeType, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return fmt.Errorf("reading manifest: %w", err)
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(manifestBlob, mimeType)
+		if err != nil {
+			return fmt.Errorf("parsing primary manifest as list: %w", err)
+		}
+		instanceDigest, err := list.ChooseInstance(sys)
+		if err != nil {
+			return fmt.Errorf("choosing an image from manifest list: %w", err)
+		}
+		unparsed = image.UnparsedInstance(src, &instanceDigest)
+		if allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsed); !allowed || err != nil {
+			return fmt.Errorf("Source image rejected: %w", err)
+		}
+	}
+	img, err := image.FromUnparsedImage(ctx, sys, unparsed)
+	if err != nil {
+		return fmt.Errorf("initializing image from source %s: %w", srcRef.StringWithinTransport(), err)
+	}
+	layers := img.LayerInfos()
+	for _, layer := range layers {
+		if strings.HasSuffix(layer.MediaType, "+encrypted") {
+			return fmt.Errorf("layer %s is encrypted, which is not supported", layer.Digest)
+		}
+	}
+
+	cache := blobinfocache.DefaultCache(sys)
+	p, err := planOutput(ctx, src, layers, cache)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for i, layer := range layers {
+		if err := forEachEntry(ctx, src, layer, cache, func(index int, entry *layertar.Entry, contents io.Reader) error {
+			return p.write(tw, entryID{layer: i, index: index}, entry, contents)
+		}); err != nil {
+			return fmt.Errorf("writing layer %s: %w", layer.Digest, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("writing the tar stream: %w", err)
+	}
+	return nil
+}
+
+// forEachEntry calls fn for each entry of layer, read from src, with the index of the entry and a reader for its contents.
+// The digest of the layer blob is verified after all entries are processed.
+func forEachEntry(ctx context.Context, src types.ImageSource, layer types.BlobInfo, cache types.BlobInfoCache,
+	fn func(index int, entry *layertar.Entry, contents io.Reader) error) error {
+	stream, _, err := src.GetBlob(ctx, layer, cache)
+	if err != nil {
+		return fmt.Errorf("reading blob %s: %w", layer.Digest, err)
+	}
+	defer stream.Close()
+	if err := layer.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %w", layer.Digest, err)
+	}
+	verifier := layer.Digest.Verifier()
+	teeStream := io.TeeReader(stream, verifier)
+
+	r, err := layertar.NewReader(teeStream)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for index := 0; ; index++ {
+		entry, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		if err := fn(index, entry, r); err != nil {
+			return err
+		}
+	}
+	// Read the rest of the blob (e.g. padding after the end of the tar stream) to verify its digest.
+	if _, err := io.Copy(io.Discard, teeStream); err != nil {
+		return fmt.Errorf("reading blob %s: %w", layer.Digest, err)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its digest", layer.Digest)
+	}
+	return nil
+}
+
+// planOutput reads the headers of all entries of layers from src, and determines which of them are a part of the merged filesystem.
+func planOutput(ctx context.Context, src types.ImageSource, layers []types.BlobInfo, cache types.BlobInfoCache) (*plan, error) {
+	visible := map[string]visibleEntry{}
+	dirHeaders := map[entryID]*tar.Header{}
+	links := map[entryID]entryID{} // Hard links, with the non-link entry they refer to
+	// remove removes the entries from layers before maxLayer at paths under parent from visible.
+	// If includingParent, the entry at parent is removed as well.
+	remove := func(parent string, includingParent bool, maxLayer int) {
+		for p, e := range visible {
+			if e.id.layer >= maxLayer {
+				continue
+			}
+			if (includingParent && p == parent) || parent == "." || strings.HasPrefix(p, parent+"/") {
+				delete(visible, p)
+			}
+		}
+	}
+
+	for i, layer := range layers {
+		if err := forEachEntry(ctx, src, layer, cache, func(index int, entry *layertar.Entry, _ io.Reader) error {
+			id := entryID{layer: i, index: index}
+			switch {
+			case entry.OpaqueWhiteout:
+				remove(entry.Path, false, i)
+				return nil
+			case entry.Whiteout:
+				remove(entry.Path, true, i)
+				return nil
+			case entry.Path == ".":
+				return nil
+			}
+
+			isDir := entry.Header.Typeflag == tar.TypeDir
+			if existing, ok := visible[entry.Path]; ok && existing.dir {
+				if isDir {
+					// The directory keeps its place in the output, so that it precedes its contents, but uses the new metadata.
+					dirHeaders[existing.id] = entry.Header
+					return nil
+				}
+				remove(entry.Path, false, i+1)
+			}
+			visible[entry.Path] = visibleEntry{id: id, dir: isDir}
+			if entry.Header.Typeflag == tar.TypeLink {
+				target, ok := visible[entry.LinkTarget]
+				if !ok || target.dir {
+					return fmt.Errorf("hard link %q refers to %q, which is not a file", entry.Path, entry.LinkTarget)
+				}
+				targetID := target.id
+				if t, ok := links[targetID]; ok {
+					targetID = t
+				}
+				links[id] = targetID
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	p := &plan{
+		paths:      map[entryID]string{},
+		dirHeaders: map[entryID]*tar.Header{},
+		linkNames:  map[entryID]string{},
+		promoted:   map[entryID]string{},
+	}
+	survivingLinks := []entryID{}
+	for path, e := range visible {
+		p.paths[e.id] = path
+		if h, ok := dirHeaders[e.id]; ok {
+			p.dirHeaders[e.id] = h
+		}
+		if _, ok := links[e.id]; ok {
+			survivingLinks = append(survivingLinks, e.id)
+		}
+	}
+	// Process the links in order, so that the first link to a removed target is the one promoted to a regular file.
+	sort.Slice(survivingLinks, func(i, j int) bool {
+		return survivingLinks[i].before(survivingLinks[j])
+	})
+	for _, id := range survivingLinks {
+		target := links[id]
+		if targetPath, ok := p.paths[target]; ok {
+			p.linkNames[id] = targetPath
+		} else if promotedPath, ok := p.promoted[target]; ok {
+			p.linkNames[id] = promotedPath
+		} else {
+			p.promoted[target] = p.paths[id]
+			delete(p.paths, id) // Written at the position of target instead.
+		}
+	}
+	return p, nil
+}
+
+// write writes entry, with the specified id and contents, to tw, if it is a part of the output.
+func (p *plan) write(tw *tar.Writer, id entryID, entry *layertar.Entry, contents io.Reader) error {
+	path, ok := p.promoted[id]
+	if !ok {
+		path, ok = p.paths[id]
+		if !ok {
+			return nil
+		}
+	}
+	hdr := *entry.Header
+	if h, ok := p.dirHeaders[id]; ok {
+		hdr = *h
+	}
+	hdr.Name = path
+	if hdr.Typeflag == tar.TypeDir {
+		hdr.Name += "/"
+	}
+	if linkName, ok := p.linkNames[id]; ok {
+		hdr.Linkname = linkName
+	}
+	hdr.Format = tar.FormatUnknown // The original format may not be able to represent the new names.
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return fmt.Errorf("writing header for %q: %w", path, err)
+	}
+	if hdr.Size > 0 {
+		if _, err := io.Copy(tw, contents); err != nil {
+			return fmt.Errorf("writing contents of %q: %w", path, err)
+		}
+	}
+	return nil
+}
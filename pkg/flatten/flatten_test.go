@@ -0,0 +1,150 @@
+package flatten
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/containers/image/v5/internal/testing/testimage"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEntry is an entry of a test layer.
+type testEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	contents string
+	linkname string
+}
+
+// newTestImage creates a schema2 image with uncompressed layers with the specified entries in a new dir: directory,
+// and returns a reference to it.
+func newTestImage(t *testing.T, layers ...[]testEntry) types.ImageReference {
+	img := testimage.Image{ManifestMIMEType: manifest.DockerV2Schema2MediaType}
+	for _, entries := range layers {
+		tarEntries := []testimage.Entry{}
+		for _, e := range entries {
+			tarEntries = append(tarEntries, testimage.Entry{
+				Header:   tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: e.mode, Linkname: e.linkname},
+				Contents: e.contents,
+			})
+		}
+		img.Layers = append(img.Layers, testimage.Layer{
+			Blob:      testimage.Tar(t, tarEntries...),
+			MediaType: manifest.DockerV2SchemaLayerMediaTypeUncompressed,
+		})
+	}
+	ref, _ := testimage.NewDirImage(t, img)
+	return ref
+}
+
+// readTar returns the entries of the tar stream in data, in order.
+func readTar(t *testing.T, data []byte) []testEntry {
+	res := []testEntry{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		contents, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		res = append(res, testEntry{name: hdr.Name, typeflag: hdr.Typeflag, mode: hdr.Mode, contents: string(contents), linkname: hdr.Linkname})
+	}
+	return res
+}
+
+func policyContext(t *testing.T, requirement signature.PolicyRequirement) *signature.PolicyContext {
+	pc, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{requirement}})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Destroy() })
+	return pc
+}
+
+func TestImage(t *testing.T) {
+	ref := newTestImage(t, []testEntry{
+		{name: "etc/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "etc/passwd", typeflag: tar.TypeReg, mode: 0o644, contents: "base passwd"},
+		{name: "etc/shadow", typeflag: tar.TypeReg, mode: 0o600, contents: "base shadow"},
+		{name: "etc/hosts", typeflag: tar.TypeReg, mode: 0o644, contents: "base hosts"},
+		{name: "var/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "var/cache/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "var/cache/old", typeflag: tar.TypeReg, mode: 0o644, contents: "old cache"},
+		{name: "bin/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "bin/busybox", typeflag: tar.TypeReg, mode: 0o755, contents: "base busybox"},
+		{name: "bin/sh", typeflag: tar.TypeLink, linkname: "bin/busybox"},
+		{name: "bin/ash", typeflag: tar.TypeLink, linkname: "./bin/sh"},
+		{name: "bin/true", typeflag: tar.TypeReg, mode: 0o755, contents: "true"},
+		{name: "bin/false", typeflag: tar.TypeLink, linkname: "bin/true"},
+		{name: "usr/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "usr/lib/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "usr/lib/libc.so", typeflag: tar.TypeReg, mode: 0o644, contents: "libc"},
+	}, []testEntry{
+		{name: "etc/", typeflag: tar.TypeDir, mode: 0o700},
+		{name: "etc/passwd", typeflag: tar.TypeReg, mode: 0o644, contents: "new passwd"},
+		{name: "etc/.wh.shadow", typeflag: tar.TypeReg},
+		{name: "var/cache/.wh..wh..opq", typeflag: tar.TypeReg},
+		{name: "var/cache/new", typeflag: tar.TypeReg, mode: 0o644, contents: "new cache"},
+		{name: "bin/busybox", typeflag: tar.TypeReg, mode: 0o755, contents: "new busybox"},
+		{name: "usr", typeflag: tar.TypeSymlink, linkname: "/"},
+	})
+
+	var buf bytes.Buffer
+	err := Image(context.Background(), policyContext(t, signature.NewPRInsecureAcceptAnything()), &buf, ref, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []testEntry{
+		// The directory keeps its place, but uses the metadata from the upper layer.
+		{name: "etc/", typeflag: tar.TypeDir, mode: 0o700},
+		{name: "etc/hosts", typeflag: tar.TypeReg, mode: 0o644, contents: "base hosts"},
+		{name: "var/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "var/cache/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "bin/", typeflag: tar.TypeDir, mode: 0o755},
+		// The target of the links was replaced, so the first link holds the original contents.
+		{name: "bin/sh", typeflag: tar.TypeReg, mode: 0o755, contents: "base busybox"},
+		{name: "bin/ash", typeflag: tar.TypeLink, linkname: "bin/sh"},
+		{name: "bin/true", typeflag: tar.TypeReg, mode: 0o755, contents: "true"},
+		{name: "bin/false", typeflag: tar.TypeLink, linkname: "bin/true"},
+		{name: "etc/passwd", typeflag: tar.TypeReg, mode: 0o644, contents: "new passwd"},
+		{name: "var/cache/new", typeflag: tar.TypeReg, mode: 0o644, contents: "new cache"},
+		{name: "bin/busybox", typeflag: tar.TypeReg, mode: 0o755, contents: "new busybox"},
+		{name: "usr", typeflag: tar.TypeSymlink, linkname: "/"},
+	}, readTar(t, buf.Bytes()))
+
+	// A whiteout of a directory removes all of its contents.
+	ref = newTestImage(t, []testEntry{
+		{name: "dir/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "dir/file", typeflag: tar.TypeReg, mode: 0o644, contents: "file"},
+		{name: "other", typeflag: tar.TypeReg, mode: 0o644, contents: "other"},
+	}, []testEntry{
+		{name: ".wh.dir", typeflag: tar.TypeReg},
+	})
+	buf.Reset()
+	err = Image(context.Background(), policyContext(t, signature.NewPRInsecureAcceptAnything()), &buf, ref, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []testEntry{
+		{name: "other", typeflag: tar.TypeReg, mode: 0o644, contents: "other"},
+	}, readTar(t, buf.Bytes()))
+
+	// A hard link to a file which does not exist is rejected.
+	ref = newTestImage(t, []testEntry{
+		{name: "link", typeflag: tar.TypeLink, linkname: "missing"},
+	})
+	buf.Reset()
+	err = Image(context.Background(), policyContext(t, signature.NewPRInsecureAcceptAnything()), &buf, ref, nil)
+	assert.Error(t, err)
+
+	// An image rejected by the policy is not written.
+	buf.Reset()
+	err = Image(context.Background(), policyContext(t, signature.NewPRReject()), &buf, ref, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 0, buf.Len())
+}
@@ -0,0 +1,197 @@
+package imagediff
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/pkg/blobinfocache"
+	"github.com/containers/image/v5/pkg/layertar"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+)
+
+// FileChangeKind is the kind of a change made to a path by a layer.
+type FileChangeKind int
+
+const (
+	// FileAdded means the path did not exist in the lower layers.
+	FileAdded FileChangeKind = iota
+	// FileModified means the path existed in the lower layers, and the layer contains a new version of it.
+	FileModified
+	// FileRemoved means the path existed in the lower layers, and the layer removes it (and everything under it).
+	FileRemoved
+)
+
+// String returns a human-readable description of k.
+func (k FileChangeKind) String() string {
+	switch k {
+	case FileAdded:
+		return "added"
+	case FileModified:
+		return "modified"
+	case FileRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("FileChangeKind(%d)", int(k))
+	}
+}
+
+// FileChange is a change made to a path by a layer.
+type FileChange struct {
+	Path string // A clean path relative to the root of the filesystem, without a leading "/", as in layertar.Entry.Path.
+	Kind FileChangeKind
+}
+
+// FileTree is the set of paths in the filesystem created by a stack of layers, used to determine the changes made by each layer.
+type FileTree struct {
+	paths map[string]bool // Paths in the filesystem => true if the path is a directory
+}
+
+// NewFileTree returns an empty FileTree, i.e. the filesystem below the base layer.
+func NewFileTree() *FileTree {
+	return &FileTree{paths: map[string]bool{}}
+}
+
+// ApplyLayer reads the layer in r, adds it on top of t, and returns the changes it makes, sorted by path.
+// Both AUFS-style whiteouts (".wh." and ".wh..wh..opq" marker files) and overlay-style whiteouts
+// (0/0 character devices and directories with an "overlay.opaque" extended attribute) are recognized.
+// The paths removed by a layer are only reported at the top-most removed directory, not for every path under it.
+// A directory which is replaced by a non-directory is reported as modified, and the paths under it as removed.
+func (t *FileTree) ApplyLayer(r *layertar.Reader) ([]FileChange, error) {
+	layerPaths := map[string]bool{} // Paths in the layer => true if the path is a directory
+	whiteouts := map[string]struct{}{}
+	opaques := map[string]struct{}{}
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		if entry.Path == "." {
+			continue
+		}
+		switch {
+		case entry.OpaqueWhiteout:
+			opaques[entry.Path] = struct{}{}
+			continue
+		case entry.Whiteout:
+			whiteouts[entry.Path] = struct{}{}
+			continue
+		case layertar.IsOverlayWhiteout(entry.Header):
+			whiteouts[entry.Path] = struct{}{}
+			continue
+		case layertar.IsOverlayOpaqueDir(entry.Header):
+			opaques[entry.Path] = struct{}{}
+		}
+		layerPaths[entry.Path] = entry.Header.Typeflag == tar.TypeDir
+		// Parent directories which are not included in the layer are created implicitly.
+		for p := path.Dir(entry.Path); p != "."; p = path.Dir(p) {
+			if _, ok := layerPaths[p]; ok {
+				break
+			}
+			layerPaths[p] = true
+		}
+	}
+	for p, isDir := range layerPaths {
+		if !isDir && t.paths[p] {
+			// The contents of a lower directory replaced by a non-directory are removed, as if the directory were opaque.
+			opaques[p] = struct{}{}
+		}
+	}
+
+	removed := map[string]struct{}{} // Paths which exist in the lower layers, and not in the new filesystem.
+	for p := range t.paths {
+		if _, ok := layerPaths[p]; !ok && isWhitedOut(p, whiteouts, opaques) {
+			removed[p] = struct{}{}
+		}
+	}
+	res := []FileChange{}
+	for p, isDir := range layerPaths {
+		if _, exists := t.paths[p]; exists { // Including paths removed by an opaque directory, then created again by the layer.
+			res = append(res, FileChange{Path: p, Kind: FileModified})
+		} else {
+			res = append(res, FileChange{Path: p, Kind: FileAdded})
+		}
+		t.paths[p] = isDir
+	}
+	for p := range removed {
+		delete(t.paths, p)
+		if _, parentRemoved := removed[path.Dir(p)]; !parentRemoved {
+			res = append(res, FileChange{Path: p, Kind: FileRemoved})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res, nil
+}
+
+// isWhitedOut returns true if p, a path in a lower layer, is removed by whiteouts of itself or of its parents, or by opaque parent directories.
+func isWhitedOut(p string, whiteouts, opaques map[string]struct{}) bool {
+	if _, ok := whiteouts[p]; ok {
+		return true
+	}
+	for parent := path.Dir(p); parent != "."; parent = path.Dir(parent) {
+		if _, ok := whiteouts[parent]; ok {
+			return true
+		}
+		if _, ok := opaques[parent]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LayerFileChanges reads the layers of the image at ref and returns the changes made by each layer, starting with the base layer.
+// If the image is a manifest list, the instance appropriate for sys is used.
+// Unlike Images, this reads the contents of all layers.
+func LayerFileChanges(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) ([][]FileChange, error) {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, fmt.Errorf("initializing source %s: %w", transports.ImageName(ref), err)
+	}
+	defer src.Close()
+	// This chooses an instance if the image is a manifest list.
+	img, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return nil, fmt.Errorf("reading image %s: %w", transports.ImageName(ref), err)
+	}
+	cache := blobinfocache.DefaultCache(sys)
+	tree := NewFileTree()
+	res := [][]FileChange{}
+	for _, layer := range img.LayerInfos() {
+		if strings.HasSuffix(layer.MediaType, "+encrypted") {
+			return nil, fmt.Errorf("layer %s is encrypted, which is not supported", layer.Digest)
+		}
+		changes, err := layerFileChanges(ctx, src, tree, layer, cache)
+		if err != nil {
+			return nil, fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+		}
+		res = append(res, changes)
+	}
+	return res, nil
+}
+
+// layerFileChanges reads layer from src, and applies it to tree.
+func layerFileChanges(ctx context.Context, src types.ImageSource, tree *FileTree, layer types.BlobInfo, cache types.BlobInfoCache) ([]FileChange, error) {
+	stream, _, err := src.GetBlob(ctx, layer, cache)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	r, err := layertar.NewReader(stream)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return tree.ApplyLayer(r)
+}
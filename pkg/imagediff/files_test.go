@@ -0,0 +1,108 @@
+package imagediff
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTar returns an uncompressed tar stream containing entries, with the file contents "contents".
+func testTar(t *testing.T, entries ...tar.Header) string {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range entries {
+		hdr := hdr
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len("contents"))
+		}
+		if hdr.PAXRecords != nil {
+			hdr.Format = tar.FormatPAX
+		}
+		require.NoError(t, tw.WriteHeader(&hdr))
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte("contents"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return buf.String()
+}
+
+func TestLayerFileChanges(t *testing.T) {
+	dir := func(name string) tar.Header {
+		return tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0o755}
+	}
+	file := func(name string) tar.Header {
+		return tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644}
+	}
+	overlayOpaqueDir := dir("var/")
+	overlayOpaqueDir.PAXRecords = map[string]string{"SCHILY.xattr.trusted.overlay.opaque": "y"}
+	ref := newTestImage(t, imgspecv1.ImageConfig{},
+		testTar(t, dir("./"), dir("etc/"), file("etc/passwd"), file("etc/hosts"), file("./tmp/x"),
+			file("var/cache/a"), file("var/cache/b/c")),
+		// AUFS-style whiteouts
+		testTar(t, file("etc/.wh.hosts"), file("etc/passwd"), file(".wh.tmp"),
+			file("var/cache/new"), file("var/cache/.wh..wh..opq"), file("missing/.wh.file")),
+		// Overlay-style whiteouts
+		testTar(t, tar.Header{Typeflag: tar.TypeChar, Name: "etc/passwd"}, overlayOpaqueDir, file("var/lib")),
+		// A directory replaced by a non-directory
+		testTar(t, tar.Header{Typeflag: tar.TypeSymlink, Name: "var", Linkname: "etc"}),
+	)
+
+	changes, err := LayerFileChanges(context.Background(), nil, ref)
+	require.NoError(t, err)
+	assert.Equal(t, [][]FileChange{
+		{
+			{"etc", FileAdded},
+			{"etc/hosts", FileAdded},
+			{"etc/passwd", FileAdded},
+			{"tmp", FileAdded},
+			{"tmp/x", FileAdded},
+			{"var", FileAdded},
+			{"var/cache", FileAdded},
+			{"var/cache/a", FileAdded},
+			{"var/cache/b", FileAdded},
+			{"var/cache/b/c", FileAdded},
+		},
+		{
+			{"etc", FileModified},
+			{"etc/hosts", FileRemoved},
+			{"etc/passwd", FileModified},
+			{"tmp", FileRemoved},
+			{"var", FileModified},
+			{"var/cache", FileModified},
+			{"var/cache/a", FileRemoved},
+			{"var/cache/b", FileRemoved},
+			{"var/cache/new", FileAdded},
+		},
+		{
+			{"etc/passwd", FileRemoved},
+			{"var", FileModified},
+			{"var/cache", FileRemoved},
+			{"var/lib", FileAdded},
+		},
+		{
+			{"var", FileModified},
+			{"var/lib", FileRemoved},
+		},
+	}, changes)
+}
+
+func TestFileChangeKindString(t *testing.T) {
+	for _, c := range []struct {
+		kind     FileChangeKind
+		expected string
+	}{
+		{FileAdded, "added"},
+		{FileModified, "modified"},
+		{FileRemoved, "removed"},
+		{FileChangeKind(42), "FileChangeKind(42)"},
+	} {
+		assert.Equal(t, c.expected, c.kind.String())
+	}
+}
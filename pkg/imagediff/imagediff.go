@@ -0,0 +1,203 @@
+// Package imagediff compares two images, e.g. two versions of the same image, reporting which layers were added,
+// removed or changed, and how their configurations differ.
+// Only manifests and configs are read; layers are compared by their DiffIDs, without downloading their contents.
+// LayerFileChanges reads the layers of an image, to report the changes to files made by each layer.
+package imagediff
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LayerDiff describes a difference between the layers of the two images, at the same position in the layer stack.
+type LayerDiff struct {
+	Index int           // The position of the layer in the layer stack, starting with 0 for the base layer.
+	A     digest.Digest // The DiffID of the layer of image A, or "" if image A has no layer at Index.
+	B     digest.Digest // The DiffID of the layer of image B, or "" if image B has no layer at Index.
+}
+
+// ValueChange describes a value which differs between the two images.
+type ValueChange struct {
+	A, B string
+}
+
+// MapDiff describes the differences between two maps of strings, e.g. labels.
+type MapDiff struct {
+	Added   map[string]string      // Keys only present in image B, with their values.
+	Removed map[string]string      // Keys only present in image A, with their values.
+	Changed map[string]ValueChange // Keys present in both images, with different values.
+}
+
+// Empty returns true if there are no differences.
+func (d MapDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// StringSliceChange describes a list of strings, e.g. an entrypoint, which differs between the two images.
+type StringSliceChange struct {
+	A, B []string
+}
+
+// Diff describes the differences between two images A and B.
+type Diff struct {
+	// SharedLayers is the number of base layers which are identical in both images.
+	SharedLayers int
+	// AddedLayers are the layers following the last layer of image A, which only exist in image B.
+	AddedLayers []LayerDiff
+	// RemovedLayers are the layers following the last layer of image B, which only exist in image A.
+	RemovedLayers []LayerDiff
+	// ChangedLayers are the positions which contain a layer in both images, with different DiffIDs.
+	ChangedLayers []LayerDiff
+
+	// Env contains the differences between the environment variables of the two images.
+	Env MapDiff
+	// Labels contains the differences between the labels of the two images.
+	Labels MapDiff
+	// Entrypoint and Cmd are set if the respective values differ.
+	Entrypoint *StringSliceChange
+	Cmd        *StringSliceChange
+	// User and WorkingDir are set if the respective values differ.
+	User       *ValueChange
+	WorkingDir *ValueChange
+}
+
+// Empty returns true if the two images have the same layers and there are no differences in the compared configuration fields.
+func (d *Diff) Empty() bool {
+	return len(d.AddedLayers) == 0 && len(d.RemovedLayers) == 0 && len(d.ChangedLayers) == 0 &&
+		d.Env.Empty() && d.Labels.Empty() && d.Entrypoint == nil && d.Cmd == nil && d.User == nil && d.WorkingDir == nil
+}
+
+// Images reads the manifests and configs of refA and refB and returns the differences between them.
+// If an image is a manifest list, the instance appropriate for sys is compared.
+// Layer contents are not read.
+func Images(ctx context.Context, sys *types.SystemContext, refA, refB types.ImageReference) (*Diff, error) {
+	configA, err := imageConfig(ctx, sys, refA)
+	if err != nil {
+		return nil, err
+	}
+	configB, err := imageConfig(ctx, sys, refB)
+	if err != nil {
+		return nil, err
+	}
+	return Configs(configA, configB), nil
+}
+
+// imageConfig returns the config of the image at ref.
+func imageConfig(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (*imgspecv1.Image, error) {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, fmt.Errorf("initializing source %s: %w", transports.ImageName(ref), err)
+	}
+	defer src.Close()
+	// This chooses an instance if the image is a manifest list.
+	img, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return nil, fmt.Errorf("reading image %s: %w", transports.ImageName(ref), err)
+	}
+	config, err := img.OCIConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading config of %s: %w", transports.ImageName(ref), err)
+	}
+	return config, nil
+}
+
+// Configs returns the differences between images with configs a and b.
+func Configs(a, b *imgspecv1.Image) *Diff {
+	res := &Diff{
+		AddedLayers:   []LayerDiff{},
+		RemovedLayers: []LayerDiff{},
+		ChangedLayers: []LayerDiff{},
+		Env:           diffMaps(envMap(a.Config.Env), envMap(b.Config.Env)),
+		Labels:        diffMaps(a.Config.Labels, b.Config.Labels),
+		Entrypoint:    diffStringSlices(a.Config.Entrypoint, b.Config.Entrypoint),
+		Cmd:           diffStringSlices(a.Config.Cmd, b.Config.Cmd),
+		User:          diffStrings(a.Config.User, b.Config.User),
+		WorkingDir:    diffStrings(a.Config.WorkingDir, b.Config.WorkingDir),
+	}
+
+	layersA, layersB := a.RootFS.DiffIDs, b.RootFS.DiffIDs
+	for res.SharedLayers < len(layersA) && res.SharedLayers < len(layersB) && layersA[res.SharedLayers] == layersB[res.SharedLayers] {
+		res.SharedLayers++
+	}
+	for i := res.SharedLayers; i < len(layersA) || i < len(layersB); i++ {
+		switch {
+		case i >= len(layersA):
+			res.AddedLayers = append(res.AddedLayers, LayerDiff{Index: i, B: layersB[i]})
+		case i >= len(layersB):
+			res.RemovedLayers = append(res.RemovedLayers, LayerDiff{Index: i, A: layersA[i]})
+		case layersA[i] != layersB[i]:
+			res.ChangedLayers = append(res.ChangedLayers, LayerDiff{Index: i, A: layersA[i], B: layersB[i]})
+		}
+	}
+	return res
+}
+
+// envMap converts a list of environment variables in the KEY=value format into a map.
+// Variables without a value are recorded with a "" value.
+func envMap(env []string) map[string]string {
+	res := map[string]string{}
+	for _, e := range env {
+		key, value := e, ""
+		if i := strings.Index(e, "="); i != -1 {
+			key, value = e[:i], e[i+1:]
+		}
+		res[key] = value
+	}
+	return res
+}
+
+// diffMaps returns the differences between a and b.
+func diffMaps(a, b map[string]string) MapDiff {
+	res := MapDiff{
+		Added:   map[string]string{},
+		Removed: map[string]string{},
+		Changed: map[string]ValueChange{},
+	}
+	for key, valueA := range a {
+		valueB, ok := b[key]
+		switch {
+		case !ok:
+			res.Removed[key] = valueA
+		case valueB != valueA:
+			res.Changed[key] = ValueChange{A: valueA, B: valueB}
+		}
+	}
+	for key, value := range b {
+		if _, ok := a[key]; !ok {
+			res.Added[key] = value
+		}
+	}
+	return res
+}
+
+// diffStringSlices returns a StringSliceChange if a and b differ, or nil otherwise.
+func diffStringSlices(a, b []string) *StringSliceChange {
+	if len(a) == len(b) {
+		same := true
+		for i := range a {
+			if a[i] != b[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return nil
+		}
+	}
+	return &StringSliceChange{A: a, B: b}
+}
+
+// diffStrings returns a ValueChange if a and b differ, or nil otherwise.
+func diffStrings(a, b string) *ValueChange {
+	if a == b {
+		return nil
+	}
+	return &ValueChange{A: a, B: b}
+}
@@ -0,0 +1,111 @@
+package imagediff
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/internal/testing/testimage"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestImage writes an OCI image with config and layers with the specified contents to a new dir: directory, and returns a reference to it.
+// The layers are not compressed, so their digests are also their DiffIDs.
+func newTestImage(t *testing.T, config imgspecv1.ImageConfig, layerContents ...string) types.ImageReference {
+	img := testimage.Image{
+		ManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+		Config:           imgspecv1.Image{Config: config},
+	}
+	for _, contents := range layerContents {
+		img.Layers = append(img.Layers, testimage.Layer{Blob: []byte(contents), MediaType: imgspecv1.MediaTypeImageLayer})
+	}
+	ref, _ := testimage.NewDirImage(t, img)
+	return ref
+}
+
+func TestImages(t *testing.T) {
+	refA := newTestImage(t, imgspecv1.ImageConfig{
+		Env:        []string{"PATH=/usr/bin", "VERSION=1.0", "REMOVED=yes"},
+		Labels:     map[string]string{"version": "1.0", "vendor": "example"},
+		Entrypoint: []string{"/bin/app"},
+		Cmd:        []string{"--serve"},
+		User:       "app",
+	}, "base", "app 1.0")
+	refB := newTestImage(t, imgspecv1.ImageConfig{
+		Env:        []string{"PATH=/usr/bin", "VERSION=1.1", "ADDED"},
+		Labels:     map[string]string{"version": "1.1", "vendor": "example", "revision": "abc"},
+		Entrypoint: []string{"/bin/app"},
+		Cmd:        []string{"--serve", "--verbose"},
+		User:       "app",
+		WorkingDir: "/srv",
+	}, "base", "app 1.1", "config")
+
+	diff, err := Images(context.Background(), nil, refA, refB)
+	require.NoError(t, err)
+	assert.Equal(t, &Diff{
+		SharedLayers:  1,
+		AddedLayers:   []LayerDiff{{Index: 2, B: digest.FromString("config")}},
+		RemovedLayers: []LayerDiff{},
+		ChangedLayers: []LayerDiff{{Index: 1, A: digest.FromString("app 1.0"), B: digest.FromString("app 1.1")}},
+		Env: MapDiff{
+			Added:   map[string]string{"ADDED": ""},
+			Removed: map[string]string{"REMOVED": "yes"},
+			Changed: map[string]ValueChange{"VERSION": {A: "1.0", B: "1.1"}},
+		},
+		Labels: MapDiff{
+			Added:   map[string]string{"revision": "abc"},
+			Removed: map[string]string{},
+			Changed: map[string]ValueChange{"version": {A: "1.0", B: "1.1"}},
+		},
+		Cmd:        &StringSliceChange{A: []string{"--serve"}, B: []string{"--serve", "--verbose"}},
+		WorkingDir: &ValueChange{A: "", B: "/srv"},
+	}, diff)
+	assert.False(t, diff.Empty())
+
+	// The reverse diff
+	diff, err = Images(context.Background(), nil, refB, refA)
+	require.NoError(t, err)
+	assert.Equal(t, 1, diff.SharedLayers)
+	assert.Equal(t, []LayerDiff{}, diff.AddedLayers)
+	assert.Equal(t, []LayerDiff{{Index: 2, A: digest.FromString("config")}}, diff.RemovedLayers)
+	assert.Equal(t, map[string]string{"REMOVED": "yes"}, diff.Env.Added)
+
+	// An image compared with itself
+	diff, err = Images(context.Background(), nil, refA, refA)
+	require.NoError(t, err)
+	assert.True(t, diff.Empty())
+	assert.Equal(t, 2, diff.SharedLayers)
+
+	// A missing image
+	missingRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	_, err = Images(context.Background(), nil, refA, missingRef)
+	assert.Error(t, err)
+}
+
+func TestConfigs(t *testing.T) {
+	d1, d2, d3 := digest.FromString("1"), digest.FromString("2"), digest.FromString("3")
+	for _, c := range []struct {
+		a, b                          []digest.Digest
+		shared                        int
+		added, removed, changedLayers []LayerDiff
+	}{
+		{[]digest.Digest{}, []digest.Digest{}, 0, []LayerDiff{}, []LayerDiff{}, []LayerDiff{}},
+		{[]digest.Digest{d1, d2}, []digest.Digest{d1, d2}, 2, []LayerDiff{}, []LayerDiff{}, []LayerDiff{}},
+		{[]digest.Digest{}, []digest.Digest{d1}, 0, []LayerDiff{{Index: 0, B: d1}}, []LayerDiff{}, []LayerDiff{}},
+		{[]digest.Digest{d1, d2}, []digest.Digest{d1}, 1, []LayerDiff{}, []LayerDiff{{Index: 1, A: d2}}, []LayerDiff{}},
+		// A changed base layer; the following layers are compared by position
+		{[]digest.Digest{d1, d2}, []digest.Digest{d3, d2}, 0, []LayerDiff{}, []LayerDiff{}, []LayerDiff{{Index: 0, A: d1, B: d3}}},
+	} {
+		diff := Configs(&imgspecv1.Image{RootFS: imgspecv1.RootFS{DiffIDs: c.a}}, &imgspecv1.Image{RootFS: imgspecv1.RootFS{DiffIDs: c.b}})
+		assert.Equal(t, c.shared, diff.SharedLayers)
+		assert.Equal(t, c.added, diff.AddedLayers)
+		assert.Equal(t, c.removed, diff.RemovedLayers)
+		assert.Equal(t, c.changedLayers, diff.ChangedLayers)
+		assert.Equal(t, len(c.added) == 0 && len(c.removed) == 0 && len(c.changedLayers) == 0, diff.Empty())
+	}
+}
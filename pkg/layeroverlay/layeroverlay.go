@@ -0,0 +1,157 @@
+// Package layeroverlay implements an image source combining a base image with additional layers.
+package layeroverlay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Transport is the transport of Overlay references. It is not registered in the transports package, and it does not parse
+// references: an Overlay can only be created using NewOverlay.
+var Transport = overlayTransport{}
+
+type overlayTransport struct{}
+
+// Name returns the name of the transport, which must be unique among other transports.
+func (t overlayTransport) Name() string {
+	return "layer-overlay"
+}
+
+// ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
+func (t overlayTransport) ParseReference(reference string) (types.ImageReference, error) {
+	return nil, errors.New("layer overlay references can not be parsed, use layeroverlay.NewOverlay")
+}
+
+// ValidatePolicyConfigurationScope checks that scope is a valid name for a signature.PolicyTransportScopes keys
+// (i.e. a valid PolicyConfigurationIdentity() or PolicyConfigurationNamespaces() return value).
+// It is acceptable to allow an invalid value which will never be matched, it can "only" cause user confusion.
+// scope passed to this function will not be "", that value is always allowed.
+func (t overlayTransport) ValidatePolicyConfigurationScope(scope string) error {
+	// Overlays have no identity of their own, only the default scope "" can match them.
+	return errors.New("layer overlay images can not be matched by a scope, only the transport default can be configured")
+}
+
+// schema2LayerMediaTypes and oci1LayerMediaTypes are the layer MIME types which can be added to images
+// using the respective manifest formats.
+var (
+	schema2LayerMediaTypes = map[string]struct{}{
+		manifest.DockerV2SchemaLayerMediaTypeUncompressed: {},
+		manifest.DockerV2Schema2LayerMediaType:            {},
+		manifest.DockerV2Schema2ForeignLayerMediaType:     {},
+		manifest.DockerV2Schema2ForeignLayerMediaTypeGzip: {},
+	}
+	oci1LayerMediaTypes = map[string]struct{}{
+		imgspecv1.MediaTypeImageLayer:                     {},
+		imgspecv1.MediaTypeImageLayerGzip:                 {},
+		imgspecv1.MediaTypeImageLayerZstd:                 {},
+		imgspecv1.MediaTypeImageLayerNonDistributable:     {},
+		imgspecv1.MediaTypeImageLayerNonDistributableGzip: {},
+		imgspecv1.MediaTypeImageLayerNonDistributableZstd: {},
+	}
+)
+
+// Layer describes a layer blob added on top of the base image of an Overlay.
+type Layer struct {
+	// Reference is the image the layer blob is read from; the blob is read using GetBlob of its image source,
+	// so it does not need to be a part of the image's manifest.
+	Reference types.ImageReference
+	// BlobInfo describes the layer blob. Digest, Size and MediaType must be set.
+	BlobInfo types.BlobInfo
+	// DiffID is the digest of the uncompressed layer contents.
+	DiffID digest.Digest
+	// CreatedBy is recorded in the image history, if the base image's config contains a history.
+	CreatedBy string
+}
+
+// Overlay is an image reference which combines a base image with additional layers, possibly coming from other images,
+// into a single synthetic image, with a manifest and config generated on the fly.
+// It can only be used as an image source; the synthetic image is never signed.
+//
+// Implements types.ImageReference.
+type Overlay struct {
+	base   types.ImageReference
+	layers []Layer
+}
+
+// NewOverlay returns a reference to an image consisting of base with layers added on top of its layers, in order.
+func NewOverlay(base types.ImageReference, layers []Layer) (*Overlay, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers to add to %q", transports.ImageName(base))
+	}
+	for i, l := range layers {
+		if l.Reference == nil {
+			return nil, fmt.Errorf("layer %d: no source image specified", i)
+		}
+		if err := l.BlobInfo.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("layer %d: invalid digest %q: %w", i, l.BlobInfo.Digest, err)
+		}
+		if l.BlobInfo.Size < 0 {
+			return nil, fmt.Errorf("layer %d (%s): size is unknown", i, l.BlobInfo.Digest)
+		}
+		if l.BlobInfo.MediaType == "" {
+			return nil, fmt.Errorf("layer %d (%s): media type is not set", i, l.BlobInfo.Digest)
+		}
+		_, isSchema2Layer := schema2LayerMediaTypes[l.BlobInfo.MediaType]
+		_, isOCI1Layer := oci1LayerMediaTypes[l.BlobInfo.MediaType]
+		if !isSchema2Layer && !isOCI1Layer {
+			return nil, fmt.Errorf("layer %d (%s): %q is not a layer media type", i, l.BlobInfo.Digest, l.BlobInfo.MediaType)
+		}
+		if err := l.DiffID.Validate(); err != nil {
+			return nil, fmt.Errorf("layer %d (%s): invalid diff ID %q: %w", i, l.BlobInfo.Digest, l.DiffID, err)
+		}
+	}
+	return &Overlay{
+		base:   base,
+		layers: layers,
+	}, nil
+}
+
+// Transport returns the layer overlay transport; the synthetic image is not a part of the base image's transport.
+func (o *Overlay) Transport() types.ImageTransport {
+	return Transport
+}
+
+// StringWithinTransport describes the base image and the added layers; it can not be parsed back into a reference.
+func (o *Overlay) StringWithinTransport() string {
+	res := transports.ImageName(o.base)
+	for _, l := range o.layers {
+		res += "+" + l.BlobInfo.Digest.String()
+	}
+	return res
+}
+
+// DockerReference returns nil: the synthetic image is not stored under any name.
+func (o *Overlay) DockerReference() reference.Named {
+	return nil
+}
+
+// PolicyConfigurationIdentity returns "": the synthetic image has no identity of its own, so only the transport default
+// policy applies to it.
+func (o *Overlay) PolicyConfigurationIdentity() string {
+	return ""
+}
+
+func (o *Overlay) PolicyConfigurationNamespaces() []string {
+	return nil
+}
+
+func (o *Overlay) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	return errors.New("deleting a layer overlay image is not supported")
+}
+
+func (o *Overlay) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	return image.FromReference(ctx, sys, o)
+}
+
+func (o *Overlay) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return nil, fmt.Errorf("writing to a layer overlay of %q is not supported", transports.ImageName(o.base))
+}
@@ -0,0 +1,173 @@
+package layeroverlay
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/internal/testing/testimage"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestImage creates a schema2 image with uncompressed layers with the specified contents in a new dir: directory,
+// and returns a reference to it and its layers.
+// If diffIDs is not nil, it is used in the config instead of the actual diff IDs.
+func newTestImage(t *testing.T, diffIDs []digest.Digest, layerContents ...string) (types.ImageReference, []Layer) {
+	img := testimage.Image{
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+		Config:           imgspecv1.Image{RootFS: imgspecv1.RootFS{DiffIDs: diffIDs}},
+	}
+	layers := []Layer{}
+	for _, contents := range layerContents {
+		blob := testimage.Tar(t, testimage.File(contents, contents))
+		img.Layers = append(img.Layers, testimage.Layer{Blob: blob, MediaType: manifest.DockerV2SchemaLayerMediaTypeUncompressed})
+		img.Config.History = append(img.Config.History, imgspecv1.History{CreatedBy: "add " + contents})
+		layers = append(layers, Layer{
+			BlobInfo: types.BlobInfo{
+				Digest:    digest.FromBytes(blob),
+				Size:      int64(len(blob)),
+				MediaType: manifest.DockerV2SchemaLayerMediaTypeUncompressed,
+			},
+			DiffID:    digest.FromBytes(blob),
+			CreatedBy: "add " + contents,
+		})
+	}
+	ref, _ := testimage.NewDirImage(t, img)
+	for i := range layers {
+		layers[i].Reference = ref
+	}
+	return ref, layers
+}
+
+func TestNewOverlay(t *testing.T) {
+	baseRef, layers := newTestImage(t, nil, "base")
+	validLayer := layers[0]
+
+	_, err := NewOverlay(baseRef, []Layer{validLayer})
+	assert.NoError(t, err)
+
+	_, err = NewOverlay(baseRef, nil)
+	assert.Error(t, err)
+
+	for _, modify := range []func(l *Layer){
+		func(l *Layer) { l.Reference = nil },
+		func(l *Layer) { l.BlobInfo.Digest = "" },
+		func(l *Layer) { l.BlobInfo.Size = -1 },
+		func(l *Layer) { l.BlobInfo.MediaType = "" },
+		func(l *Layer) { l.BlobInfo.MediaType = manifest.DockerV2Schema2ConfigMediaType },
+		func(l *Layer) { l.BlobInfo.MediaType = imgspecv1.MediaTypeImageManifest },
+		func(l *Layer) { l.DiffID = "sha256:invalid" },
+	} {
+		invalidLayer := validLayer
+		modify(&invalidLayer)
+		_, err := NewOverlay(baseRef, []Layer{validLayer, invalidLayer})
+		assert.Error(t, err)
+	}
+}
+
+func TestOverlayIdentity(t *testing.T) {
+	baseRef, _ := newTestImage(t, nil, "base")
+	_, extraLayers := newTestImage(t, nil, "extra")
+	overlayRef, err := NewOverlay(baseRef, extraLayers)
+	require.NoError(t, err)
+
+	assert.Equal(t, "layer-overlay", overlayRef.Transport().Name())
+	assert.Equal(t, transports.ImageName(baseRef)+"+"+extraLayers[0].BlobInfo.Digest.String(), overlayRef.StringWithinTransport())
+	_, err = overlayRef.Transport().ParseReference(overlayRef.StringWithinTransport())
+	assert.Error(t, err)
+	assert.Nil(t, overlayRef.DockerReference())
+	assert.Equal(t, "", overlayRef.PolicyConfigurationIdentity())
+	assert.Empty(t, overlayRef.PolicyConfigurationNamespaces())
+}
+
+func TestOverlayCopy(t *testing.T) {
+	baseRef, baseLayers := newTestImage(t, nil, "base 1", "base 2")
+	_, extraLayers := newTestImage(t, nil, "extra 1", "extra 2")
+	overlayRef, err := NewOverlay(baseRef, extraLayers)
+	require.NoError(t, err)
+
+	destRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{
+		Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()},
+	})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+	_, err = copy.Image(context.Background(), policyContext, destRef, overlayRef, &copy.Options{})
+	require.NoError(t, err)
+
+	img, err := destRef.NewImage(context.Background(), nil)
+	require.NoError(t, err)
+	defer img.Close()
+	expectedLayers := append(append([]Layer{}, baseLayers...), extraLayers...)
+	layerInfos := img.LayerInfos()
+	require.Len(t, layerInfos, len(expectedLayers))
+	for i, l := range expectedLayers {
+		assert.Equal(t, l.BlobInfo.Digest, layerInfos[i].Digest)
+	}
+	config, err := img.OCIConfig(context.Background())
+	require.NoError(t, err)
+	require.Len(t, config.RootFS.DiffIDs, len(expectedLayers))
+	require.Len(t, config.History, len(expectedLayers))
+	for i, l := range expectedLayers {
+		assert.Equal(t, l.DiffID, config.RootFS.DiffIDs[i])
+		assert.Equal(t, l.CreatedBy, config.History[i].CreatedBy)
+	}
+	assert.Equal(t, "amd64", config.Architecture)
+
+	// The added layers are readable from the destination.
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	for _, l := range extraLayers {
+		stream, size, err := src.GetBlob(context.Background(), l.BlobInfo, none.NoCache)
+		require.NoError(t, err)
+		stream.Close()
+		assert.Equal(t, l.BlobInfo.Size, size)
+	}
+}
+
+func TestOverlayInconsistentBase(t *testing.T) {
+	// The base config lists fewer diff IDs than there are layers.
+	baseRef, _ := newTestImage(t, []digest.Digest{}, "base")
+	_, extraLayers := newTestImage(t, nil, "extra")
+	overlayRef, err := NewOverlay(baseRef, extraLayers)
+	require.NoError(t, err)
+	_, err = overlayRef.NewImageSource(context.Background(), nil)
+	assert.ErrorContains(t, err, "1 layers in the manifest, 0 diff IDs in the config")
+
+	// The base config lists more diff IDs than there are layers.
+	baseLayer := testimage.Tar(t, testimage.File("base", "base"))
+	baseRef, _ = newTestImage(t, []digest.Digest{digest.FromBytes(baseLayer), digest.FromString("other")}, "base")
+	overlayRef, err = NewOverlay(baseRef, extraLayers)
+	require.NoError(t, err)
+	_, err = overlayRef.NewImageSource(context.Background(), nil)
+	assert.ErrorContains(t, err, "1 layers in the manifest, 2 diff IDs in the config")
+
+	// The base rootfs is not of the "layers" type.
+	baseRef, _ = testimage.NewDirImage(t, testimage.Image{
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+		Config:           imgspecv1.Image{RootFS: imgspecv1.RootFS{Type: "unknown"}},
+	})
+	overlayRef, err = NewOverlay(baseRef, extraLayers)
+	require.NoError(t, err)
+	_, err = overlayRef.NewImageSource(context.Background(), nil)
+	assert.ErrorContains(t, err, `unsupported rootfs type "unknown"`)
+
+	// A layer media type not valid for the base manifest format.
+	baseRef, _ = newTestImage(t, nil, "base")
+	extraLayers[0].BlobInfo.MediaType = imgspecv1.MediaTypeImageLayer
+	overlayRef, err = NewOverlay(baseRef, extraLayers)
+	require.NoError(t, err)
+	_, err = overlayRef.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+}
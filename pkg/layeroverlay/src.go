@@ -0,0 +1,267 @@
+package layeroverlay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/internal/imagesource/impl"
+	"github.com/containers/image/v5/internal/imagesource/stubs"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type overlaySource struct {
+	impl.Compat
+	impl.PropertyMethodsInitialize
+	impl.NoSignatures // The base image's signatures don't apply to the combined image.
+	impl.DoesNotAffectLayerInfosForCopy
+	stubs.NoGetBlobAtInitialize
+
+	reference    *Overlay
+	base         types.ImageSource
+	layerSources map[digest.Digest]types.ImageSource // Sources of the added layers, by blob digest; values may repeat.
+	sources      []types.ImageSource                 // All of the sources to close, including base.
+	manifest     []byte
+	manifestType string
+	config       []byte
+	configDigest digest.Digest
+}
+
+func (o *Overlay) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	s := &overlaySource{
+		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
+			HasThreadSafeGetBlob: false,
+		}),
+		NoGetBlobAtInitialize: stubs.NoGetBlobAt(o),
+
+		reference:    o,
+		layerSources: map[digest.Digest]types.ImageSource{},
+	}
+	s.Compat = impl.AddCompat(s)
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			s.Close()
+		}
+	}()
+
+	base, err := o.base.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, fmt.Errorf("opening base image %q: %w", transports.ImageName(o.base), err)
+	}
+	s.base = base
+	s.sources = append(s.sources, base)
+
+	sourcesByName := map[string]types.ImageSource{}
+	for _, l := range o.layers {
+		name := transports.ImageName(l.Reference)
+		src, ok := sourcesByName[name]
+		if !ok {
+			src, err = l.Reference.NewImageSource(ctx, sys)
+			if err != nil {
+				return nil, fmt.Errorf("opening image %q for layer %s: %w", name, l.BlobInfo.Digest, err)
+			}
+			sourcesByName[name] = src
+			s.sources = append(s.sources, src)
+		}
+		s.layerSources[l.BlobInfo.Digest] = src
+	}
+
+	if err := s.generateManifestAndConfig(ctx, sys); err != nil {
+		return nil, err
+	}
+	succeeded = true
+	return s, nil
+}
+
+// generateManifestAndConfig sets s.manifest and s.config to describe the combined image.
+func (s *overlaySource) generateManifestAndConfig(ctx context.Context, sys *types.SystemContext) error {
+	// This chooses an instance if the base image is a manifest list.
+	img, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(s.base, nil))
+	if err != nil {
+		return fmt.Errorf("reading base image %q: %w", transports.ImageName(s.reference.base), err)
+	}
+	baseConfig, err := img.ConfigBlob(ctx)
+	if err != nil {
+		return fmt.Errorf("reading base image config: %w", err)
+	}
+	config, err := s.combinedConfig(baseConfig, len(img.LayerInfos()))
+	if err != nil {
+		return err
+	}
+	configDigest := digest.FromBytes(config)
+	configInfo := types.BlobInfo{
+		Digest: configDigest,
+		Size:   int64(len(config)),
+	}
+
+	var man []byte
+	switch img.ManifestMIMEType {
+	case manifest.DockerV2Schema2MediaType:
+		m, err := manifest.Schema2FromManifest(img.ManifestBlob)
+		if err != nil {
+			return err
+		}
+		m.ConfigDescriptor.Digest = configInfo.Digest
+		m.ConfigDescriptor.Size = configInfo.Size
+		for _, l := range s.reference.layers {
+			if _, ok := schema2LayerMediaTypes[l.BlobInfo.MediaType]; !ok {
+				return fmt.Errorf("layer %s with media type %q can not be added to a Docker schema 2 image", l.BlobInfo.Digest, l.BlobInfo.MediaType)
+			}
+			m.LayersDescriptors = append(m.LayersDescriptors, manifest.Schema2Descriptor{
+				MediaType: l.BlobInfo.MediaType,
+				Size:      l.BlobInfo.Size,
+				Digest:    l.BlobInfo.Digest,
+			})
+		}
+		man, err = m.Serialize()
+		if err != nil {
+			return err
+		}
+	case imgspecv1.MediaTypeImageManifest:
+		m, err := manifest.OCI1FromManifest(img.ManifestBlob)
+		if err != nil {
+			return err
+		}
+		m.Config.Digest = configInfo.Digest
+		m.Config.Size = configInfo.Size
+		for _, l := range s.reference.layers {
+			if _, ok := oci1LayerMediaTypes[l.BlobInfo.MediaType]; !ok {
+				return fmt.Errorf("layer %s with media type %q can not be added to an OCI image", l.BlobInfo.Digest, l.BlobInfo.MediaType)
+			}
+			m.Layers = append(m.Layers, imgspecv1.Descriptor{
+				MediaType: l.BlobInfo.MediaType,
+				Size:      l.BlobInfo.Size,
+				Digest:    l.BlobInfo.Digest,
+			})
+		}
+		man, err = m.Serialize()
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("adding layers to an image with manifest type %q is not supported", img.ManifestMIMEType)
+	}
+
+	combinedManifest, err := manifest.FromBlob(man, img.ManifestMIMEType)
+	if err != nil {
+		return err
+	}
+	var combinedConfig struct {
+		RootFS imgspecv1.RootFS `json:"rootfs"`
+	}
+	if err := json.Unmarshal(config, &combinedConfig); err != nil {
+		return err
+	}
+	if err := validateRootFS(combinedConfig.RootFS, len(combinedManifest.LayerInfos())); err != nil {
+		return fmt.Errorf("inconsistent combined image: %w", err)
+	}
+
+	s.manifest = man
+	s.manifestType = img.ManifestMIMEType
+	s.config = config
+	s.configDigest = configDigest
+	return nil
+}
+
+// combinedConfig returns a config consisting of baseConfig, which belongs to an image with baseLayers layers,
+// with s.reference.layers added.
+func (s *overlaySource) combinedConfig(baseConfig []byte, baseLayers int) ([]byte, error) {
+	// Decode only the fields we need to modify, to preserve everything else as is.
+	config := map[string]*json.RawMessage{}
+	if err := json.Unmarshal(baseConfig, &config); err != nil {
+		return nil, fmt.Errorf("parsing base image config: %w", err)
+	}
+	var rootFS imgspecv1.RootFS
+	if raw, ok := config["rootfs"]; ok && raw != nil {
+		if err := json.Unmarshal(*raw, &rootFS); err != nil {
+			return nil, fmt.Errorf("parsing base image rootfs: %w", err)
+		}
+	}
+	if err := validateRootFS(rootFS, baseLayers); err != nil {
+		return nil, fmt.Errorf("inconsistent base image: %w", err)
+	}
+	var history []imgspecv1.History
+	if raw, ok := config["history"]; ok && raw != nil {
+		if err := json.Unmarshal(*raw, &history); err != nil {
+			return nil, fmt.Errorf("parsing base image history: %w", err)
+		}
+	}
+
+	for _, l := range s.reference.layers {
+		rootFS.DiffIDs = append(rootFS.DiffIDs, l.DiffID)
+		if history != nil {
+			history = append(history, imgspecv1.History{CreatedBy: l.CreatedBy})
+		}
+	}
+	if err := setConfigField(config, "rootfs", rootFS); err != nil {
+		return nil, err
+	}
+	if history != nil {
+		if err := setConfigField(config, "history", history); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(config)
+}
+
+// validateRootFS returns an error unless rootFS is a "layers" rootfs with a diff ID for each of the layers in the manifest.
+func validateRootFS(rootFS imgspecv1.RootFS, layers int) error {
+	if rootFS.Type != "layers" {
+		return fmt.Errorf("unsupported rootfs type %q", rootFS.Type)
+	}
+	if len(rootFS.DiffIDs) != layers {
+		return fmt.Errorf("%d layers in the manifest, %d diff IDs in the config", layers, len(rootFS.DiffIDs))
+	}
+	return nil
+}
+
+// setConfigField sets config[key] to the JSON representation of value.
+func setConfigField(config map[string]*json.RawMessage, key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", key, err)
+	}
+	raw := json.RawMessage(b)
+	config[key] = &raw
+	return nil
+}
+
+func (s *overlaySource) Reference() types.ImageReference {
+	return s.reference
+}
+
+func (s *overlaySource) Close() error {
+	var res error
+	for _, src := range s.sources {
+		if err := src.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+func (s *overlaySource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return nil, "", errors.New("manifest lists are not supported by layer overlay images")
+	}
+	return s.manifest, s.manifestType, nil
+}
+
+func (s *overlaySource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if info.Digest == s.configDigest {
+		return io.NopCloser(bytes.NewReader(s.config)), int64(len(s.config)), nil
+	}
+	if src, ok := s.layerSources[info.Digest]; ok {
+		return src.GetBlob(ctx, info, cache)
+	}
+	return s.base.GetBlob(ctx, info, cache)
+}
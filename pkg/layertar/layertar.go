@@ -0,0 +1,154 @@
+// Package layertar enumerates the entries of a layer blob, without extracting it,
+// e.g. to list the paths and modes of files in a layer.
+package layertar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/compression"
+	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
+)
+
+const (
+	// WhiteoutPrefix is the prefix of file names marking a removal of the file from lower layers.
+	WhiteoutPrefix = ".wh."
+	// WhiteoutMetaPrefix is the prefix of file names used internally by AUFS, not representing any files in the layer.
+	WhiteoutMetaPrefix = WhiteoutPrefix + WhiteoutPrefix
+	// WhiteoutOpaqueDir is the file name marking that the contents of a directory in lower layers are removed.
+	WhiteoutOpaqueDir = WhiteoutMetaPrefix + ".opq"
+)
+
+// overlayOpaqueXattrs are the PAX records marking a directory as opaque in layers created from overlay filesystems.
+var overlayOpaqueXattrs = []string{"SCHILY.xattr.trusted.overlay.opaque", "SCHILY.xattr.user.overlay.opaque"}
+
+// Entry is a single entry of a layer.
+type Entry struct {
+	// Header is the tar header of the entry, as stored in the layer.
+	Header *tar.Header
+	// Path is the cleaned path of the entry, relative to the root of the layer (without a leading "/" or "./").
+	// For whiteouts, this is the path of the removed file or directory, not of the marker file.
+	Path string
+	// Whiteout is set if the entry marks Path as removed from lower layers.
+	Whiteout bool
+	// OpaqueWhiteout is set if the entry marks the contents of directory Path in lower layers as removed.
+	OpaqueWhiteout bool
+	// LinkTarget is the cleaned path (relative to the root of the layer, like Path) of the target of a hard link,
+	// if the entry is a hard link (Header.Typeflag == tar.TypeLink), or "".
+	LinkTarget string
+}
+
+// Reader enumerates the entries of a layer, reading the contents of entries only on demand.
+type Reader struct {
+	decompressed io.ReadCloser
+	tarReader    *tar.Reader
+}
+
+// NewReader returns a Reader for the layer blob in stream, detecting its compression automatically.
+// The caller must call Close() on the returned Reader.
+func NewReader(stream io.Reader) (*Reader, error) {
+	decompressed, _, err := compression.AutoDecompress(stream)
+	if err != nil {
+		return nil, fmt.Errorf("detecting layer compression: %w", err)
+	}
+	return newReader(decompressed), nil
+}
+
+// NewReaderWithDecompressor returns a Reader for the layer blob in stream, which is compressed using decompressor,
+// or not compressed if decompressor is nil (e.g. as returned by compression.DetectCompression).
+// The caller must call Close() on the returned Reader.
+func NewReaderWithDecompressor(stream io.Reader, decompressor compressiontypes.DecompressorFunc) (*Reader, error) {
+	if decompressor == nil {
+		return newReader(io.NopCloser(stream)), nil
+	}
+	decompressed, err := decompressor(stream)
+	if err != nil {
+		return nil, fmt.Errorf("initializing decompression: %w", err)
+	}
+	return newReader(decompressed), nil
+}
+
+// newReader returns a Reader for the uncompressed tar stream in decompressed.
+func newReader(decompressed io.ReadCloser) *Reader {
+	return &Reader{
+		decompressed: decompressed,
+		tarReader:    tar.NewReader(decompressed),
+	}
+}
+
+// Next advances to the next entry of the layer, and returns it.
+// It returns io.EOF at the end of the layer.
+// Entries used internally by AUFS (with names starting with ".wh..wh.", other than opaque directory markers) are skipped.
+func (r *Reader) Next() (*Entry, error) {
+	for {
+		hdr, err := r.tarReader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("reading layer: %w", err)
+		}
+		entry := Entry{
+			Header: hdr,
+			Path:   cleanPath(hdr.Name),
+		}
+		dir, base := path.Split(entry.Path)
+		switch {
+		case base == WhiteoutOpaqueDir:
+			entry.Path = cleanPath(dir)
+			entry.OpaqueWhiteout = true
+		case strings.HasPrefix(base, WhiteoutMetaPrefix):
+			continue
+		case strings.HasPrefix(base, WhiteoutPrefix):
+			entry.Path = cleanPath(path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix)))
+			entry.Whiteout = true
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			entry.LinkTarget = cleanPath(hdr.Linkname)
+		}
+		return &entry, nil
+	}
+}
+
+// IsOverlayWhiteout returns true if hdr is a whiteout created from an overlay filesystem, i.e. a 0/0 character device.
+// Unlike AUFS-style whiteouts, these are not recognized by Reader.Next, because they are also valid contents of a layer.
+func IsOverlayWhiteout(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeChar && hdr.Devmajor == 0 && hdr.Devminor == 0
+}
+
+// IsOverlayOpaqueDir returns true if hdr is a directory marked as opaque in an overlay filesystem.
+func IsOverlayOpaqueDir(hdr *tar.Header) bool {
+	if hdr.Typeflag != tar.TypeDir {
+		return false
+	}
+	for _, key := range overlayOpaqueXattrs {
+		if hdr.PAXRecords[key] == "y" {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads the contents of the entry most recently returned by Next.
+// Entries without contents (e.g. directories, links and whiteouts) read as empty.
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.tarReader.Read(p)
+}
+
+// Close releases resources associated with the Reader. It does not close the input stream.
+func (r *Reader) Close() error {
+	return r.decompressed.Close()
+}
+
+// cleanPath returns p as a clean path relative to the root of the layer, or "." for the root itself.
+func cleanPath(p string) string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return "."
+	}
+	return p[1:]
+}
@@ -0,0 +1,182 @@
+package layertar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLayer returns an uncompressed tarball with a few entries, including whiteouts and a hard link.
+func testLayer(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		hdr      tar.Header
+		contents string
+	}{
+		{tar.Header{Name: "./etc/", Mode: 0o755, Typeflag: tar.TypeDir}, ""},
+		{tar.Header{Name: "./etc/passwd", Mode: 0o644, Typeflag: tar.TypeReg}, "root:x:0:0::/root:/bin/sh\n"},
+		{tar.Header{Name: "./etc/passwd-", Linkname: "./etc/passwd", Typeflag: tar.TypeLink}, ""},
+		{tar.Header{Name: "/etc/.wh.shadow", Mode: 0o600, Typeflag: tar.TypeReg}, ""},
+		{tar.Header{Name: "var/cache/.wh..wh..opq", Mode: 0o600, Typeflag: tar.TypeReg}, ""},
+		{tar.Header{Name: ".wh..wh.plnk/", Mode: 0o700, Typeflag: tar.TypeDir}, ""},
+		{tar.Header{Name: "usr/bin/sh", Linkname: "/bin/busybox", Mode: 0o777, Typeflag: tar.TypeSymlink}, ""},
+	} {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.contents))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func gzipLayer(t *testing.T, layer []byte) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	_, err := gzw.Write(layer)
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	type entry struct {
+		path           string
+		typeflag       byte
+		mode           int64
+		whiteout       bool
+		opaqueWhiteout bool
+		linkTarget     string
+	}
+	expected := []entry{
+		{path: "etc", typeflag: tar.TypeDir, mode: 0o755},
+		{path: "etc/passwd", typeflag: tar.TypeReg, mode: 0o644},
+		{path: "etc/passwd-", typeflag: tar.TypeLink, linkTarget: "etc/passwd"},
+		{path: "etc/shadow", typeflag: tar.TypeReg, mode: 0o600, whiteout: true},
+		{path: "var/cache", typeflag: tar.TypeReg, mode: 0o600, opaqueWhiteout: true},
+		{path: "usr/bin/sh", typeflag: tar.TypeSymlink, mode: 0o777},
+	}
+
+	layer := testLayer(t)
+	gzipped := gzipLayer(t, layer)
+	for _, c := range []struct {
+		name      string
+		newReader func() (*Reader, error)
+	}{
+		{"gzip, detected", func() (*Reader, error) { return NewReader(bytes.NewReader(gzipped)) }},
+		{"uncompressed, detected", func() (*Reader, error) { return NewReader(bytes.NewReader(layer)) }},
+		{"gzip, explicit", func() (*Reader, error) {
+			return NewReaderWithDecompressor(bytes.NewReader(gzipped), compression.GzipDecompressor)
+		}},
+		{"uncompressed, explicit", func() (*Reader, error) { return NewReaderWithDecompressor(bytes.NewReader(layer), nil) }},
+	} {
+		r, err := c.newReader()
+		require.NoError(t, err, c.name)
+		entries := []entry{}
+		for {
+			e, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, c.name)
+			entries = append(entries, entry{
+				path:           e.Path,
+				typeflag:       e.Header.Typeflag,
+				mode:           e.Header.Mode,
+				whiteout:       e.Whiteout,
+				opaqueWhiteout: e.OpaqueWhiteout,
+				linkTarget:     e.LinkTarget,
+			})
+			if e.Path == "etc/passwd" {
+				contents, err := io.ReadAll(r)
+				require.NoError(t, err, c.name)
+				assert.Equal(t, "root:x:0:0::/root:/bin/sh\n", string(contents), c.name)
+			}
+		}
+		assert.Equal(t, expected, entries, c.name)
+		_, err = r.Next()
+		assert.Equal(t, io.EOF, err, c.name)
+		require.NoError(t, r.Close(), c.name)
+	}
+
+	// Contents which are not read are skipped.
+	r, err := NewReader(bytes.NewReader(gzipped))
+	require.NoError(t, err)
+	defer r.Close()
+	count := 0
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		count++
+	}
+	assert.Equal(t, len(expected), count)
+
+	// Truncated layer
+	r, err = NewReader(bytes.NewReader(layer[:600]))
+	require.NoError(t, err)
+	defer r.Close()
+	for {
+		_, err = r.Next()
+		if err != nil {
+			break
+		}
+	}
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
+
+func TestCleanPath(t *testing.T) {
+	for _, c := range []struct{ input, expected string }{
+		{"", "."},
+		{".", "."},
+		{"./", "."},
+		{"/", "."},
+		{"a", "a"},
+		{"./a/b/", "a/b"},
+		{"/a//b", "a/b"},
+		{"../a", "a"},
+		{"a/../../b", "b"},
+	} {
+		assert.Equal(t, c.expected, cleanPath(c.input), c.input)
+	}
+}
+
+func TestIsOverlayWhiteout(t *testing.T) {
+	for _, c := range []struct {
+		hdr      tar.Header
+		expected bool
+	}{
+		{tar.Header{Typeflag: tar.TypeChar}, true},
+		{tar.Header{Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3}, false},
+		{tar.Header{Typeflag: tar.TypeBlock}, false},
+		{tar.Header{Typeflag: tar.TypeReg}, false},
+	} {
+		assert.Equal(t, c.expected, IsOverlayWhiteout(&c.hdr), "%#v", c.hdr)
+	}
+}
+
+func TestIsOverlayOpaqueDir(t *testing.T) {
+	for _, c := range []struct {
+		hdr      tar.Header
+		expected bool
+	}{
+		{tar.Header{Typeflag: tar.TypeDir, PAXRecords: map[string]string{"SCHILY.xattr.trusted.overlay.opaque": "y"}}, true},
+		{tar.Header{Typeflag: tar.TypeDir, PAXRecords: map[string]string{"SCHILY.xattr.user.overlay.opaque": "y"}}, true},
+		{tar.Header{Typeflag: tar.TypeDir, PAXRecords: map[string]string{"SCHILY.xattr.trusted.overlay.opaque": "n"}}, false},
+		{tar.Header{Typeflag: tar.TypeDir}, false},
+		{tar.Header{Typeflag: tar.TypeReg, PAXRecords: map[string]string{"SCHILY.xattr.trusted.overlay.opaque": "y"}}, false},
+	} {
+		assert.Equal(t, c.expected, IsOverlayOpaqueDir(&c.hdr), "%#v", c.hdr)
+	}
+}
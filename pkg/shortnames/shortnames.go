@@ -105,6 +105,51 @@ func Remove(ctx *types.SystemContext, name string) error {
 	return sysregistriesv2.RemoveShortNameAlias(ctx, name)
 }
 
+// LookupAlias returns the short-name alias configured for the specified name,
+// along with a human-readable description of the config where the alias is
+// specified, or nil if there is no such alias.  Unlike Resolve, it only
+// consults the configuration: it neither prompts the user nor considers the
+// unqualified-search registries, so it can be used to preview how name would
+// be resolved.
+//
+// A tag or digest of the specified name is added to the returned alias;
+// otherwise the alias is normalized with the "latest" tag, as in Resolve.
+func LookupAlias(ctx *types.SystemContext, name string) (reference.Named, string, error) {
+	isShort, shortRef, err := parseUnnormalizedShortName(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if !isShort {
+		return nil, "", fmt.Errorf("%q is not a short name", name)
+	}
+	// Aliases are not used at all in this case, see Resolve.
+	if ctx != nil && ctx.PodmanOnlyShortNamesIgnoreRegistriesConfAndForceDockerHub {
+		return nil, "", nil
+	}
+
+	isTagged, isDigested, shortNameRepo, tag, digest := splitUserInput(shortRef)
+	namedAlias, aliasOriginDescription, err := sysregistriesv2.ResolveShortNameAlias(ctx, shortNameRepo.String())
+	if err != nil {
+		return nil, "", err
+	}
+	if namedAlias == nil {
+		return nil, "", nil
+	}
+	if isTagged {
+		namedAlias, err = reference.WithTag(namedAlias, tag)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if isDigested {
+		namedAlias, err = reference.WithDigest(namedAlias, digest)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return reference.TagNameOnly(namedAlias), aliasOriginDescription, nil
+}
+
 // Resolved encapsulates all data for a resolved image name.
 type Resolved struct {
 	PullCandidates []PullCandidate
@@ -601,3 +601,60 @@ func TestResolveLocally(t *testing.T) {
 		assert.Equal(t, test.expectedSysResolveToDockerHub, aliases[0].String())
 	}
 }
+
+func TestLookupAlias(t *testing.T) {
+	tmp, err := os.CreateTemp("", "aliases.conf")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/aliases.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+		UserShortNameAliasConfPath:  tmp.Name(),
+	}
+	sysResolveToDockerHub := &types.SystemContext{
+		SystemRegistriesConfPath:                                  "testdata/aliases.conf",
+		SystemRegistriesConfDirPath:                               "testdata/this-does-not-exist",
+		UserShortNameAliasConfPath:                                tmp.Name(),
+		PodmanOnlyShortNamesIgnoreRegistriesConfAndForceDockerHub: true,
+	}
+
+	_, err = sysregistriesv2.TryUpdatingCache(sys)
+	require.NoError(t, err)
+	addAlias(t, sys, "user", "quay.io/user/image", false)
+
+	digest := "@sha256:d366a4665ab44f0648d7a00ae3fae139d55e32f9712c67accd604bb55df9d05a"
+
+	for _, test := range []struct {
+		input, value, origin string
+	}{
+		{"docker", "docker.io/library/foo:latest", "testdata/aliases.conf"},
+		{"docker:tag", "docker.io/library/foo:tag", "testdata/aliases.conf"},
+		{"docker" + digest, "docker.io/library/foo" + digest, "testdata/aliases.conf"},
+		{"quay/foo", "quay.io/library/foo:latest", "testdata/aliases.conf"},
+		{"user", "quay.io/user/image:latest", tmp.Name()},
+		{"empty", "", ""},        // Set to an empty string in the config
+		{"doesnotexist", "", ""}, // Not configured at all
+	} {
+		alias, origin, err := LookupAlias(sys, test.input)
+		require.NoError(t, err, test.input)
+		if test.value == "" {
+			assert.Nil(t, alias, test.input)
+		} else {
+			require.NotNil(t, alias, test.input)
+			assert.Equal(t, test.value, alias.String(), test.input)
+		}
+		assert.Equal(t, test.origin, origin, test.input)
+
+		// Aliases are ignored when enforcing resolving to Docker Hub.
+		alias, _, err = LookupAlias(sysResolveToDockerHub, test.input)
+		require.NoError(t, err, test.input)
+		assert.Nil(t, alias, test.input)
+	}
+
+	// Only short names can be looked up.
+	for _, input := range []string{"", "Invalid#$", "registry.com/repo/image", "localhost/image"} {
+		_, _, err := LookupAlias(sys, input)
+		assert.Error(t, err, input)
+	}
+}
@@ -125,12 +125,27 @@ type Registry struct {
 	Endpoint
 	// The registry's mirrors.
 	Mirrors []Endpoint `toml:"mirror,omitempty"`
-	// If true, pulling from the registry will be blocked.
+	// If true, pulling from and pushing to the registry will be blocked, including pulls through its mirrors.
 	Blocked bool `toml:"blocked,omitempty"`
 	// If true, mirrors will only be used for digest pulls. Pulling images by
 	// tag can potentially yield different images, depending on which endpoint
 	// we pull from.  Restricting mirrors to pulls by digest avoids that issue.
 	MirrorByDigestOnly bool `toml:"mirror-by-digest-only,omitempty"`
+	// A pull-through cache for the registry, if any. Unlike a mirror, a pull-through cache
+	// fetches anything it does not contain from the registry itself, so it is used first,
+	// for both digest and tag pulls, regardless of MirrorByDigestOnly; the mirrors and the
+	// registry are only used if accessing the cache fails.
+	// The cache is only used for reading images, it never affects writes.
+	PullThroughCache *Endpoint `toml:"pull-through-cache,omitempty"`
+	// If not "", a directory with the same structure as the per-host certificate directories
+	// (CA certificates ending with ".crt", a client certificate ending with ".cert" and its key ending with ".key"),
+	// used instead of the per-host certificate directory when connecting to the registry.
+	// Must not be set together with ClientCert and ClientKey.
+	ClientCertDir string `toml:"client-cert-dir,omitempty"`
+	// If not "", paths to a PEM-encoded client certificate and its private key presented when connecting to the registry,
+	// instead of any client certificate in the per-host certificate directory. Both must be set at the same time.
+	ClientCert string `toml:"client-cert,omitempty"`
+	ClientKey  string `toml:"client-key,omitempty"`
 }
 
 // PullSource consists of an Endpoint and a Reference. Note that the reference is
@@ -167,6 +182,9 @@ func (r *Registry) PullSourcesFromReference(ref reference.Named) ([]PullSource,
 		}
 	}
 	endpoints = append(endpoints, r.Endpoint)
+	if r.PullThroughCache != nil {
+		endpoints = append([]Endpoint{*r.PullThroughCache}, endpoints...)
+	}
 
 	sources := []PullSource{}
 	for _, ep := range endpoints {
@@ -426,6 +444,21 @@ func (config *V2RegistriesConf) postProcessRegistries() error {
 				return &InvalidRegistries{s: fmt.Sprintf("unsupported pull-from-mirror value %q for mirror %q", mir.PullFromMirror, mir.Location)}
 			}
 		}
+		if reg.PullThroughCache != nil {
+			reg.PullThroughCache.Location, err = parseLocation(reg.PullThroughCache.Location)
+			if err != nil {
+				return err
+			}
+			if reg.PullThroughCache.Location == "" {
+				return &InvalidRegistries{s: "invalid condition: pull-through cache location is unset"}
+			}
+			if reg.PullThroughCache.PullFromMirror != "" {
+				return &InvalidRegistries{s: fmt.Sprintf("pull-from-mirror must not be set for the pull-through cache %q", reg.PullThroughCache.Location)}
+			}
+		}
+		if err := validateClientCertificates(reg); err != nil {
+			return err
+		}
 		if reg.Location == "" {
 			regMap[reg.Prefix] = append(regMap[reg.Prefix], reg)
 		} else {
@@ -460,6 +493,11 @@ func (config *V2RegistriesConf) postProcessRegistries() error {
 				msg := fmt.Sprintf("registry '%s' is defined multiple times with conflicting 'blocked' setting", reg.Location)
 				return &InvalidRegistries{s: msg}
 			}
+
+			if reg.ClientCertDir != other.ClientCertDir || reg.ClientCert != other.ClientCert || reg.ClientKey != other.ClientKey {
+				msg := fmt.Sprintf("registry '%s' is defined multiple times with conflicting client certificate settings", reg.Location)
+				return &InvalidRegistries{s: msg}
+			}
 		}
 	}
 
@@ -501,6 +539,22 @@ func (config *V2RegistriesConf) postProcessRegistries() error {
 	return nil
 }
 
+// validateClientCertificates returns an error if the client certificate configuration of reg is invalid.
+func validateClientCertificates(reg *Registry) error {
+	if reg.ClientCertDir != "" && (reg.ClientCert != "" || reg.ClientKey != "") {
+		return &InvalidRegistries{s: fmt.Sprintf("client-cert-dir and client-cert/client-key must not be set at the same time for registry %q", reg.Prefix)}
+	}
+	if (reg.ClientCert == "") != (reg.ClientKey == "") {
+		return &InvalidRegistries{s: fmt.Sprintf("client-cert and client-key must be set at the same time for registry %q", reg.Prefix)}
+	}
+	for _, path := range []string{reg.ClientCertDir, reg.ClientCert, reg.ClientKey} {
+		if path != "" && !filepath.IsAbs(path) {
+			return &InvalidRegistries{s: fmt.Sprintf("client certificate path %q for registry %q is not absolute", path, reg.Prefix)}
+		}
+	}
+	return nil
+}
+
 // ConfigPath returns the path to the system-wide registry configuration file.
 // Deprecated: This API implies configuration is read from files, and that there is only one.
 // Please use ConfigurationSourceDescription to obtain a string usable for error messages.
@@ -856,11 +910,16 @@ func refMatchingPrefix(ref, prefix string) int {
 			return -1
 		}
 		c := ref[len(prefix)]
-		// This allows "example.com:5000" to match "example.com",
-		// which is unintended; that will get fixed eventually, DON'T RELY
-		// ON THE CURRENT BEHAVIOR.
-		if c == ':' || c == '/' || c == '@' {
+		switch c {
+		case '/', '@':
 			return len(prefix)
+		case ':':
+			// After a prefix which is only a host name, ':' starts a port number, so
+			// "example.com:5000" does not match "example.com"; otherwise it starts a tag.
+			if strings.Contains(prefix, "/") {
+				return len(prefix)
+			}
+			return -1
 		}
 		return -1
 	default:
@@ -872,6 +931,7 @@ func refMatchingPrefix(ref, prefix string) int {
 // which is a registry, repository namespace repository or image reference (as formatted by
 // reference.Domain(), reference.Named.Name() or reference.Reference.String()
 // — note that this requires the name to start with an explicit hostname!).
+// If a wildcard prefix and a prefix without a wildcard have the same length, the latter is used.
 // If no Registry prefixes the image, nil is returned.
 func FindRegistry(ctx *types.SystemContext, ref string) (*Registry, error) {
 	config, err := getConfig(ctx)
@@ -887,15 +947,22 @@ func FindRegistry(ctx *types.SystemContext, ref string) (*Registry, error) {
 func findRegistryWithParsedConfig(config *parsedConfig, ref string) (*Registry, error) {
 	reg := Registry{}
 	prefixLen := 0
+	portMismatchPrefix := "" // The longest host-only prefix which matched ref, on a different port, in older versions
 	for _, r := range config.partialV2.Registries {
 		if refMatchingPrefix(ref, r.Prefix) != -1 {
 			length := len(r.Prefix)
-			if length > prefixLen {
+			if length > prefixLen || (length == prefixLen && strings.HasPrefix(reg.Prefix, "*.") && !strings.HasPrefix(r.Prefix, "*.")) {
 				reg = r
 				prefixLen = length
 			}
+		} else if !strings.ContainsAny(r.Prefix, "/*") && strings.HasPrefix(ref, r.Prefix+":") && len(r.Prefix) > len(portMismatchPrefix) {
+			portMismatchPrefix = r.Prefix
 		}
 	}
+	if len(portMismatchPrefix) > prefixLen {
+		logrus.Warnf("Registry prefix %q does not match %q, which is on a different port; older versions used it for %q anyway. "+
+			"Add a [[registry]] table with a prefix including the port if that is still intended", portMismatchPrefix, ref, ref)
+	}
 	if prefixLen != 0 {
 		return &reg, nil
 	}
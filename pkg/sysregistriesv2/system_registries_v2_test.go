@@ -8,6 +8,8 @@ import (
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -184,7 +186,8 @@ func TestRefMatchingPrefix(t *testing.T) {
 		{"docker.io", "example.com", -1},
 		{"example.com:5000", "example.com:5000", len("example.com:5000")},
 		{"example.com:50000", "example.com:5000", -1},
-		{"example.com:5000", "example.com", len("example.com")}, // FIXME FIXME This is unintended and undocumented, don't rely on this behavior
+		{"example.com:5000", "example.com", -1},
+		{"example.com:5000/foo", "example.com", -1},
 		{"example.com/foo", "example.com", len("example.com")},
 		{"example.com/foo/bar", "example.com", len("example.com")},
 		{"example.com/foo/bar:baz", "example.com", len("example.com")},
@@ -204,6 +207,8 @@ func TestRefMatchingPrefix(t *testing.T) {
 		{"example.com/foo", "example.com/foo:bar", -1},
 		{"example.com/foo:bar", "example.com/foo:bar", len("example.com/foo:bar")},
 		{"example.com/foo:bar2", "example.com/foo:bar", -1},
+		{"example.com/foo:bar", "example.com/foo", len("example.com/foo")},
+		{"example.com:5000/foo:bar", "example.com:5000/foo", len("example.com:5000/foo")},
 		{"example.com", "example.com/foo@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", -1},
 		{"example.com/foo", "example.com/foo@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", -1},
 		{"example.com/foo@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "example.com/foo@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
@@ -284,6 +289,48 @@ func TestNewConfigWrapper(t *testing.T) {
 	}
 }
 
+func TestFindRegistryPortMismatchWarning(t *testing.T) {
+	config := &parsedConfig{partialV2: V2RegistriesConf{Registries: []Registry{
+		{Prefix: "example.com"},
+		{Prefix: "example.com:5000/specific"},
+		{Prefix: "*.example.com"},
+	}}}
+	oldHooks := logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	defer logrus.StandardLogger().ReplaceHooks(oldHooks)
+	hook := logrustest.NewLocal(logrus.StandardLogger())
+	for _, c := range []struct {
+		ref            string
+		expectedPrefix string // "" if no registry matches
+		warning        bool
+	}{
+		{"example.com/foo", "example.com", false},
+		{"example.com:5000/foo", "", true},                                    // Matched "example.com" in older versions
+		{"example.com:5000/specific/foo", "example.com:5000/specific", false}, // A longer prefix matches anyway
+		{"sub.example.com:5000/foo", "*.example.com", false},
+	} {
+		hook.Reset()
+		reg, err := findRegistryWithParsedConfig(config, c.ref)
+		require.NoError(t, err, c.ref)
+		if c.expectedPrefix == "" {
+			assert.Nil(t, reg, c.ref)
+		} else {
+			require.NotNil(t, reg, c.ref)
+			assert.Equal(t, c.expectedPrefix, reg.Prefix, c.ref)
+		}
+		warnings := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				warnings++
+			}
+		}
+		if c.warning {
+			assert.Equal(t, 1, warnings, c.ref)
+		} else {
+			assert.Equal(t, 0, warnings, c.ref)
+		}
+	}
+}
+
 func TestFindRegistry(t *testing.T) {
 	sys := &types.SystemContext{
 		SystemRegistriesConfPath:    "testdata/find-registry.conf",
@@ -425,6 +472,8 @@ func TestInvalidV2Configs(t *testing.T) {
 		{"testdata/blocked-conflicts.conf", "registry 'registry.com' is defined multiple times with conflicting 'blocked' setting"},
 		{"testdata/missing-mirror-location.conf", "invalid condition: mirror location is unset"},
 		{"testdata/invalid-prefix.conf", "invalid location"},
+		{"testdata/invalid-client-certificates.conf", "client-cert and client-key must be set at the same time"},
+		{"testdata/client-certificates-conflicts.conf", "registry 'registry.com' is defined multiple times with conflicting client certificate settings"},
 		{"testdata/this-does-not-exist.conf", "no such file or directory"},
 	} {
 		_, err := GetRegistries(&types.SystemContext{SystemRegistriesConfPath: c.path})
@@ -435,6 +484,40 @@ func TestInvalidV2Configs(t *testing.T) {
 	}
 }
 
+func TestClientCertificates(t *testing.T) {
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/client-certificates.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+	}
+	reg, err := FindRegistry(sys, "registry-a.com/foo:latest")
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	assert.Equal(t, "", reg.ClientCertDir)
+	assert.Equal(t, "/etc/containers/client-certs/registry-a.com/client.cert", reg.ClientCert)
+	assert.Equal(t, "/etc/containers/client-certs/registry-a.com/client.key", reg.ClientKey)
+
+	reg, err = FindRegistry(sys, "registry-b.com/foo:latest")
+	require.NoError(t, err)
+	require.NotNil(t, reg)
+	assert.Equal(t, "/etc/containers/client-certs/registry-b.com", reg.ClientCertDir)
+	assert.Equal(t, "", reg.ClientCert)
+	assert.Equal(t, "", reg.ClientKey)
+
+	for _, c := range []struct {
+		registry       Registry
+		errorSubstring string
+	}{
+		{Registry{ClientCertDir: "/certs", ClientCert: "/certs/client.cert", ClientKey: "/certs/client.key"}, "must not be set at the same time"},
+		{Registry{ClientKey: "/certs/client.key"}, "must be set at the same time"},
+		{Registry{ClientCertDir: "certs"}, "is not absolute"},
+		{Registry{ClientCert: "client.cert", ClientKey: "/certs/client.key"}, "is not absolute"},
+	} {
+		c.registry.Prefix = "registry.com"
+		err := validateClientCertificates(&c.registry)
+		assert.ErrorContains(t, err, c.errorSubstring, c.registry)
+	}
+}
+
 func TestUnmarshalConfig(t *testing.T) {
 	registries, err := GetRegistries(&types.SystemContext{
 		SystemRegistriesConfPath:    "testdata/unmarshal.conf",
@@ -753,6 +836,106 @@ func TestPullSourcesMirrorFromReference(t *testing.T) {
 	}
 }
 
+func TestPullSourcesFromReferenceWithPullThroughCache(t *testing.T) {
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/pull-through-cache.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+	}
+	registries, err := GetRegistries(sys)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(registries))
+
+	digest := "@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	for _, tc := range []struct {
+		ref           string
+		expected      []string
+		cacheInsecure bool
+	}{
+		// The cache is used first, even for tag pulls with mirror-by-digest-only
+		{"registry-a.com/foo/image" + digest, []string{
+			"cache.example.com/registry-a/image" + digest,
+			"mirror-1.registry-a.com/image" + digest,
+			"registry-a.com/bar/image" + digest,
+		}, true},
+		{"registry-a.com/foo/image:aaa", []string{
+			"cache.example.com/registry-a/image:aaa",
+			"registry-a.com/bar/image:aaa",
+		}, true},
+		{"registry-b.com/ns/image:aaa", []string{
+			"cache.example.com/registry-b/ns/image:aaa",
+			"registry-b.com/ns/image:aaa",
+		}, false},
+	} {
+		ref := toNamedRef(t, tc.ref)
+		registry, err := FindRegistry(sys, ref.Name())
+		require.NoError(t, err)
+		require.NotNil(t, registry)
+		pullSources, err := registry.PullSourcesFromReference(ref)
+		require.NoError(t, err)
+		res := []string{}
+		for _, ps := range pullSources {
+			res = append(res, ps.Reference.String())
+		}
+		assert.Equal(t, tc.expected, res, tc.ref)
+		assert.Equal(t, tc.cacheInsecure, pullSources[0].Endpoint.Insecure, tc.ref)
+	}
+}
+
+func TestPullSourcesFromReferenceWithOverlappingPrefixes(t *testing.T) {
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/prefix-remapping.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+	}
+	registries, err := GetRegistries(sys)
+	require.NoError(t, err)
+	assert.Equal(t, 7, len(registries))
+
+	digest := "@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	for _, tc := range []struct {
+		ref      string
+		expected []string
+	}{
+		// The longest matching prefix is used, and the rest of the repository path is kept
+		{"docker.io/user/image:latest", []string{"mirror.example.com/dockerhub/user/image:latest"}},
+		{"docker.io/library/alpine:latest", []string{"internal.example.com/dockerhub/alpine:latest"}},
+		{"docker.io/library/alpine/nested" + digest, []string{"internal.example.com/dockerhub/alpine/nested" + digest}},
+		{"docker.io/library/busybox:latest", []string{
+			"mirror.internal.example.com/forks/busybox:latest",
+			"internal.example.com/forks/busybox:latest",
+		}},
+		{"docker.io/library/busybox/nested:latest", []string{
+			"mirror.internal.example.com/forks/busybox/nested:latest",
+			"internal.example.com/forks/busybox/nested:latest",
+		}},
+		// Not a path component boundary
+		{"docker.io/library/busyboxx:latest", []string{"internal.example.com/dockerhub/busyboxx:latest"}},
+		{"docker.io/libraryy/image:latest", []string{"mirror.example.com/dockerhub/libraryy/image:latest"}},
+		// A host prefix does not match a different port
+		{"example.com/ns/image:latest", []string{"internal.example.com/example/ns/image:latest"}},
+		{"example.com:5000/ns/image:latest", []string{"internal.example.com/example-5000/ns/image:latest"}},
+		// A prefix without a wildcard is preferred over a wildcard of the same length
+		{"a.example.net/image:latest", []string{"exact.example.com/a/image:latest"}},
+		{"b.example.net/image:latest", []string{"wildcard.example.com/image:latest"}},
+	} {
+		ref := toNamedRef(t, tc.ref)
+		registry, err := FindRegistry(sys, ref.Name())
+		require.NoError(t, err)
+		require.NotNil(t, registry, tc.ref)
+		pullSources, err := registry.PullSourcesFromReference(ref)
+		require.NoError(t, err)
+		res := []string{}
+		for _, ps := range pullSources {
+			res = append(res, ps.Reference.String())
+		}
+		assert.Equal(t, tc.expected, res, tc.ref)
+	}
+
+	// A different port of a host which is not configured is not affected.
+	registry, err := FindRegistry(sys, "example.com:6000/ns/image")
+	require.NoError(t, err)
+	assert.Nil(t, registry)
+}
+
 func TestInvalidMirrorConfig(t *testing.T) {
 	for _, tc := range []struct {
 		sys       *types.SystemContext
@@ -779,6 +962,13 @@ func TestInvalidMirrorConfig(t *testing.T) {
 			},
 			expectErr: fmt.Sprintf("unsupported pull-from-mirror value %q for mirror %q", "notvalid", "mirror-1.registry-a.com"),
 		},
+		{
+			sys: &types.SystemContext{
+				SystemRegistriesConfPath:    "testdata/invalid-pull-through-cache.conf",
+				SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+			},
+			expectErr: fmt.Sprintf("pull-from-mirror must not be set for the pull-through cache %q", "cache.example.com"),
+		},
 	} {
 		_, err := GetRegistries(tc.sys)
 		assert.ErrorContains(t, err, tc.expectErr)
@@ -0,0 +1,287 @@
+package tee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/v5/internal/imagedestination"
+	"github.com/containers/image/v5/internal/imagedestination/impl"
+	"github.com/containers/image/v5/internal/imagedestination/stubs"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+type teeDestination struct {
+	impl.Compat
+	impl.PropertyMethodsInitialize
+	stubs.NoPutBlobPartialInitialize
+
+	reference types.ImageReference
+	primary   private.ImageDestination
+	secondary private.ImageDestination
+}
+
+// NewDestination returns a destination which writes everything to both primary and secondary.
+// The returned destination takes ownership of primary and secondary, and closes them when it is closed.
+func NewDestination(primary, secondary types.ImageDestination) (types.ImageDestination, error) {
+	return newDestination(primary.Reference(), primary, secondary)
+}
+
+// newDestination returns a destination for ref which writes everything to both primary and secondary.
+func newDestination(ref types.ImageReference, primary, secondary types.ImageDestination) (*teeDestination, error) {
+	supportedManifestMIMETypes, err := commonManifestMIMETypes(primary.SupportedManifestMIMETypes(), secondary.SupportedManifestMIMETypes())
+	if err != nil {
+		return nil, fmt.Errorf("writing to both %q and %q: %w", transports.ImageName(primary.Reference()), transports.ImageName(secondary.Reference()), err)
+	}
+	desiredLayerCompression := primary.DesiredLayerCompression()
+	if secondary.DesiredLayerCompression() != desiredLayerCompression {
+		// The destinations receive the same blob data, so we can't satisfy both.
+		desiredLayerCompression = types.PreserveOriginal
+	}
+	d := &teeDestination{
+		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
+			SupportedManifestMIMETypes:     supportedManifestMIMETypes,
+			DesiredLayerCompression:        desiredLayerCompression,
+			AcceptsForeignLayerURLs:        primary.AcceptsForeignLayerURLs() && secondary.AcceptsForeignLayerURLs(),
+			MustMatchRuntimeOS:             primary.MustMatchRuntimeOS() || secondary.MustMatchRuntimeOS(),
+			IgnoresEmbeddedDockerReference: primary.IgnoresEmbeddedDockerReference() && secondary.IgnoresEmbeddedDockerReference(),
+			HasThreadSafePutBlob:           primary.HasThreadSafePutBlob() && secondary.HasThreadSafePutBlob(),
+		}),
+		NoPutBlobPartialInitialize: stubs.NoPutBlobPartial(ref),
+
+		reference: ref,
+		primary:   imagedestination.FromPublic(primary),
+		secondary: imagedestination.FromPublic(secondary),
+	}
+	d.Compat = impl.AddCompat(d)
+	return d, nil
+}
+
+// commonManifestMIMETypes returns the manifest MIME types supported by destinations supporting primary and secondary,
+// in the order of preference of primary.
+func commonManifestMIMETypes(primary, secondary []string) ([]string, error) {
+	if len(primary) == 0 { // Any type is supported
+		return secondary, nil
+	}
+	if len(secondary) == 0 {
+		return primary, nil
+	}
+	res := []string{}
+	for _, t := range primary {
+		for _, t2 := range secondary {
+			if t == t2 {
+				res = append(res, t)
+				break
+			}
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no manifest format is supported by both destinations (%v vs. %v)", primary, secondary)
+	}
+	return res, nil
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (d *teeDestination) Reference() types.ImageReference {
+	return d.reference
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+func (d *teeDestination) Close() error {
+	err := d.primary.Close()
+	if err2 := d.secondary.Close(); err2 != nil && err == nil {
+		err = err2
+	}
+	return err
+}
+
+// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
+// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
+func (d *teeDestination) SupportsSignatures(ctx context.Context) error {
+	if err := d.primary.SupportsSignatures(ctx); err != nil {
+		return err
+	}
+	if err := d.secondary.SupportsSignatures(ctx); err != nil {
+		return fmt.Errorf("secondary destination %q: %w", transports.ImageName(d.secondary.Reference()), err)
+	}
+	return nil
+}
+
+// PutBlobWithOptions writes contents of stream and returns data representing the result.
+// inputInfo.Digest can be optionally provided if known; if provided, and stream is read to the end without error, the digest MUST match the stream contents.
+// inputInfo.Size is the expected length of stream, if known.
+// inputInfo.MediaType describes the blob format, if known.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (d *teeDestination) PutBlobWithOptions(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, options private.PutBlobOptions) (types.BlobInfo, error) {
+	// The data is streamed to both destinations at the same time; whatever the primary destination reads is
+	// written to a pipe consumed by the secondary one. A failure of either destination makes the other one fail as well.
+	pipeReader, pipeWriter := io.Pipe()
+	type putBlobResult struct {
+		info types.BlobInfo
+		err  error
+	}
+	secondaryDone := make(chan putBlobResult, 1)
+	go func() {
+		info, err := d.secondary.PutBlobWithOptions(ctx, pipeReader, inputInfo, options)
+		if err != nil {
+			pipeReader.CloseWithError(errSecondaryFailed)
+		} else {
+			// The destination may not have needed all of the data; don't block the primary one.
+			_, _ = io.Copy(io.Discard, pipeReader)
+			pipeReader.Close()
+		}
+		secondaryDone <- putBlobResult{info: info, err: err}
+	}()
+
+	primaryInfo, primaryErr := d.primary.PutBlobWithOptions(ctx, io.TeeReader(stream, pipeWriter), inputInfo, options)
+	if primaryErr == nil {
+		// Forward any data the primary destination did not read, so that the secondary one sees the complete blob.
+		if _, err := io.Copy(pipeWriter, stream); err != nil {
+			pipeWriter.CloseWithError(err)
+		} else {
+			pipeWriter.Close()
+		}
+	} else {
+		pipeWriter.CloseWithError(errPrimaryFailed)
+	}
+	secondary := <-secondaryDone
+
+	switch {
+	case primaryErr != nil && secondary.err != nil && !errors.Is(secondary.err, errPrimaryFailed):
+		// Either the secondary destination failed first, causing the primary one to fail, or both have failed independently.
+		return types.BlobInfo{}, fmt.Errorf("writing blob to secondary destination %q: %w", transports.ImageName(d.secondary.Reference()), secondary.err)
+	case primaryErr != nil:
+		return types.BlobInfo{}, primaryErr
+	case secondary.err != nil:
+		return types.BlobInfo{}, fmt.Errorf("blob %s was written to %q, but writing it to secondary destination %q failed: %w",
+			primaryInfo.Digest, transports.ImageName(d.primary.Reference()), transports.ImageName(d.secondary.Reference()), secondary.err)
+	}
+	if secondary.info.Digest != primaryInfo.Digest {
+		return types.BlobInfo{}, fmt.Errorf("destinations recorded blob %s inconsistently: %s in %q, %s in secondary %q", inputInfo.Digest,
+			primaryInfo.Digest, transports.ImageName(d.primary.Reference()), secondary.info.Digest, transports.ImageName(d.secondary.Reference()))
+	}
+	return primaryInfo, nil
+}
+
+var (
+	// errPrimaryFailed is the error returned to the secondary destination when reading the blob after the primary destination failed.
+	errPrimaryFailed = errors.New("writing the blob to the primary destination failed")
+	// errSecondaryFailed is the error returned to the primary destination when reading the blob after the secondary destination failed.
+	errSecondaryFailed = errors.New("writing the blob to the secondary destination failed")
+)
+
+// TryReusingBlobWithOptions checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size, and may
+// include CompressionOperation and CompressionAlgorithm fields to indicate that a change to the compression type should be
+// reflected in the manifest that will be written.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+func (d *teeDestination) TryReusingBlobWithOptions(ctx context.Context, info types.BlobInfo, options private.TryReusingBlobOptions) (bool, types.BlobInfo, error) {
+	// We don't have access to the blob data here, so the blob can only be reused if both destinations can reuse it;
+	// otherwise the caller uses PutBlobWithOptions, which writes the same data to both.
+	// Reusing a blob may have side effects (e.g. a storage destination commits the layer at options.LayerIndex, a registry
+	// may mount the blob from another repository), so first check, without a cache or a layer index, that both destinations
+	// already contain exactly this blob, and only then reuse it in both. Substitutes are not used, because the two destinations
+	// could choose different ones.
+	probeOptions := private.TryReusingBlobOptions{
+		Cache:         none.NoCache,
+		CanSubstitute: false,
+		SrcRef:        options.SrcRef,
+	}
+	reused, _, err := d.secondary.TryReusingBlobWithOptions(ctx, info, probeOptions)
+	if err != nil {
+		return false, types.BlobInfo{}, fmt.Errorf("checking for blob %s in secondary destination %q: %w", info.Digest, transports.ImageName(d.secondary.Reference()), err)
+	}
+	if !reused {
+		return false, types.BlobInfo{}, nil
+	}
+	reused, _, err = d.primary.TryReusingBlobWithOptions(ctx, info, probeOptions)
+	if err != nil || !reused {
+		if err == nil {
+			logrus.Debugf("Blob %s can be reused only by the secondary destination, not reusing it", info.Digest)
+		}
+		return false, types.BlobInfo{}, err
+	}
+
+	options.CanSubstitute = false
+	reused, primaryInfo, err := d.primary.TryReusingBlobWithOptions(ctx, info, options)
+	if err != nil || !reused {
+		return false, types.BlobInfo{}, err
+	}
+	reused, _, err = d.secondary.TryReusingBlobWithOptions(ctx, info, options)
+	if err != nil {
+		return false, types.BlobInfo{}, fmt.Errorf("blob %s was reused in %q, but reusing it in secondary destination %q failed: %w",
+			info.Digest, transports.ImageName(d.primary.Reference()), transports.ImageName(d.secondary.Reference()), err)
+	}
+	if !reused {
+		return false, types.BlobInfo{}, fmt.Errorf("blob %s was reused in %q, but is no longer available in secondary destination %q",
+			info.Digest, transports.ImageName(d.primary.Reference()), transports.ImageName(d.secondary.Reference()))
+	}
+	return true, primaryInfo, nil
+}
+
+// PutManifest writes manifest to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for
+// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+// It is expected but not enforced that the instanceDigest, when specified, matches the digest of `manifest` as generated
+// by `manifest.Digest()`.
+// FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
+// If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
+// but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
+func (d *teeDestination) PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error {
+	if err := d.primary.PutManifest(ctx, manifest, instanceDigest); err != nil {
+		return err
+	}
+	if err := d.secondary.PutManifest(ctx, manifest, instanceDigest); err != nil {
+		return fmt.Errorf("manifest was written to %q, but writing it to secondary destination %q failed: %w",
+			transports.ImageName(d.primary.Reference()), transports.ImageName(d.secondary.Reference()), err)
+	}
+	return nil
+}
+
+// PutSignaturesWithFormat writes a set of signatures to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write or overwrite the signatures for
+// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+// MUST be called after PutManifest (signatures may reference manifest contents).
+func (d *teeDestination) PutSignaturesWithFormat(ctx context.Context, signatures []signature.Signature, instanceDigest *digest.Digest) error {
+	if err := d.primary.PutSignaturesWithFormat(ctx, signatures, instanceDigest); err != nil {
+		return err
+	}
+	if err := d.secondary.PutSignaturesWithFormat(ctx, signatures, instanceDigest); err != nil {
+		return fmt.Errorf("signatures were written to %q, but writing them to secondary destination %q failed: %w",
+			transports.ImageName(d.primary.Reference()), transports.ImageName(d.secondary.Reference()), err)
+	}
+	return nil
+}
+
+// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+// unparsedToplevel contains data about the top-level manifest of the source (which may be a single-arch image or a manifest list
+// if PutManifest was only called for the single-arch image with instanceDigest == nil), primarily to allow lookups by the
+// original manifest list digest, if desired.
+// WARNING: This does not have any transactional semantics:
+// - Uploaded data MAY be visible to others before Commit() is called
+// - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
+//
+// The secondary destination is committed first, so that a failure to commit it leaves the primary destination uncommitted;
+// if committing the primary destination fails after that, the image remains committed in the secondary destination.
+func (d *teeDestination) Commit(ctx context.Context, unparsedToplevel types.UnparsedImage) error {
+	if err := d.secondary.Commit(ctx, unparsedToplevel); err != nil {
+		return fmt.Errorf("committing secondary destination %q: %w", transports.ImageName(d.secondary.Reference()), err)
+	}
+	if err := d.primary.Commit(ctx, unparsedToplevel); err != nil {
+		return fmt.Errorf("image was committed to secondary destination %q, but committing %q failed: %w",
+			transports.ImageName(d.secondary.Reference()), transports.ImageName(d.primary.Reference()), err)
+	}
+	return nil
+}
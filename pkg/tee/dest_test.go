@@ -0,0 +1,329 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/internal/imagedestination/impl"
+	"github.com/containers/image/v5/internal/imagedestination/stubs"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ private.ImageDestination = (*teeDestination)(nil)
+
+// fakeDestination is a types.ImageDestination which records what it has received.
+type fakeDestination struct {
+	impl.PropertyMethodsInitialize
+	stubs.AlwaysSupportsSignatures
+
+	ref types.ImageReference
+	// Configuration
+	knownBlobs     map[digest.Digest]types.BlobInfo // Blobs which TryReusingBlob can reuse, possibly substituted.
+	readLimit      int64                            // If > 0, PutBlob reads only this many bytes and then succeeds.
+	putBlobErr     error
+	putManifestErr error
+	commitErr      error
+	// Recorded data
+	blobs       map[digest.Digest][]byte
+	reusedBlobs []digest.Digest // Blobs reused by TryReusingBlob calls which use a cache, i.e. are not just probes.
+	manifests   [][]byte
+	signatures  [][]byte
+	committed   bool
+	closed      bool
+}
+
+func newFakeDestination(t *testing.T, props impl.Properties) *fakeDestination {
+	ref, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	return &fakeDestination{
+		PropertyMethodsInitialize: impl.PropertyMethods(props),
+		ref:                       ref,
+		knownBlobs:                map[digest.Digest]types.BlobInfo{},
+		blobs:                     map[digest.Digest][]byte{},
+	}
+}
+
+func (f *fakeDestination) Reference() types.ImageReference {
+	return f.ref
+}
+
+func (f *fakeDestination) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	if f.putBlobErr != nil {
+		return types.BlobInfo{}, f.putBlobErr
+	}
+	if f.readLimit > 0 {
+		stream = io.LimitReader(stream, f.readLimit)
+	}
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	if f.readLimit > 0 {
+		return inputInfo, nil // Pretend that we already had the rest of the data.
+	}
+	d := digest.FromBytes(data)
+	if inputInfo.Digest != "" && inputInfo.Digest != d {
+		return types.BlobInfo{}, errors.New("digest mismatch")
+	}
+	f.blobs[d] = data
+	return types.BlobInfo{Digest: d, Size: int64(len(data))}, nil
+}
+
+func (f *fakeDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	known, ok := f.knownBlobs[info.Digest]
+	if !ok || (!canSubstitute && known.Digest != info.Digest) {
+		return false, types.BlobInfo{}, nil
+	}
+	if cache != none.NoCache {
+		f.reusedBlobs = append(f.reusedBlobs, info.Digest)
+	}
+	return true, known, nil
+}
+
+func (f *fakeDestination) PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error {
+	if f.putManifestErr != nil {
+		return f.putManifestErr
+	}
+	f.manifests = append(f.manifests, manifest)
+	return nil
+}
+
+func (f *fakeDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	f.signatures = append(f.signatures, signatures...)
+	return nil
+}
+
+func (f *fakeDestination) Commit(ctx context.Context, unparsedToplevel types.UnparsedImage) error {
+	if f.commitErr != nil {
+		return f.commitErr
+	}
+	f.committed = true
+	return nil
+}
+
+func TestNewDestination(t *testing.T) {
+	for _, c := range []struct {
+		primary, secondary, expected []string // expected == nil means failure
+	}{
+		{nil, nil, nil},
+		{[]string{manifest.DockerV2Schema2MediaType}, nil, []string{manifest.DockerV2Schema2MediaType}},
+		{nil, []string{imgspecv1.MediaTypeImageManifest}, []string{imgspecv1.MediaTypeImageManifest}},
+		{
+			[]string{imgspecv1.MediaTypeImageManifest, manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType},
+			[]string{manifest.DockerV2Schema1SignedMediaType, manifest.DockerV2Schema2MediaType},
+			[]string{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType},
+		},
+		{[]string{imgspecv1.MediaTypeImageManifest}, []string{manifest.DockerV2Schema2MediaType}, nil},
+	} {
+		primary := newFakeDestination(t, impl.Properties{SupportedManifestMIMETypes: c.primary})
+		secondary := newFakeDestination(t, impl.Properties{SupportedManifestMIMETypes: c.secondary})
+		dest, err := NewDestination(primary, secondary)
+		if c.expected == nil && (c.primary != nil || c.secondary != nil) {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, dest.SupportedManifestMIMETypes())
+		assert.Equal(t, primary.ref, dest.Reference())
+	}
+
+	primary := newFakeDestination(t, impl.Properties{
+		DesiredLayerCompression: types.Compress,
+		AcceptsForeignLayerURLs: true,
+		MustMatchRuntimeOS:      true,
+		HasThreadSafePutBlob:    true,
+	})
+	secondary := newFakeDestination(t, impl.Properties{
+		DesiredLayerCompression:        types.Decompress,
+		IgnoresEmbeddedDockerReference: true,
+		HasThreadSafePutBlob:           true,
+	})
+	dest, err := NewDestination(primary, secondary)
+	require.NoError(t, err)
+	assert.Equal(t, types.PreserveOriginal, dest.DesiredLayerCompression())
+	assert.False(t, dest.AcceptsForeignLayerURLs())
+	assert.True(t, dest.MustMatchRuntimeOS())
+	assert.False(t, dest.IgnoresEmbeddedDockerReference())
+	assert.True(t, dest.HasThreadSafePutBlob())
+
+	require.NoError(t, dest.Close())
+	assert.True(t, primary.closed)
+	assert.True(t, secondary.closed)
+}
+
+func TestPutBlob(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // Larger than any internal pipe buffers
+	blobDigest := digest.FromBytes(blob)
+	blobInfo := types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}
+
+	// Both destinations receive the full data, even if one of them doesn't read all of it.
+	for _, c := range []struct{ primaryLimit, secondaryLimit int64 }{
+		{0, 0},
+		{1000, 0},
+		{0, 1000},
+	} {
+		primary := newFakeDestination(t, impl.Properties{})
+		primary.readLimit = c.primaryLimit
+		secondary := newFakeDestination(t, impl.Properties{})
+		secondary.readLimit = c.secondaryLimit
+		dest, err := NewDestination(primary, secondary)
+		require.NoError(t, err)
+		info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), blobInfo, none.NoCache, false)
+		require.NoError(t, err)
+		assert.Equal(t, blobDigest, info.Digest)
+		for _, f := range []*fakeDestination{primary, secondary} {
+			if f.readLimit == 0 {
+				assert.Equal(t, blob, f.blobs[blobDigest])
+			}
+		}
+	}
+
+	// Failures of either destination are reported, and make the other destination fail as well.
+	for _, failSecondary := range []bool{false, true} {
+		primary := newFakeDestination(t, impl.Properties{})
+		secondary := newFakeDestination(t, impl.Properties{})
+		failing := primary
+		if failSecondary {
+			failing = secondary
+		}
+		failing.putBlobErr = errors.New("injected failure")
+		dest, err := NewDestination(primary, secondary)
+		require.NoError(t, err)
+		_, err = dest.PutBlob(context.Background(), bytes.NewReader(blob), blobInfo, none.NoCache, false)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, failing.putBlobErr)
+		if failSecondary {
+			assert.Contains(t, err.Error(), "secondary")
+		}
+		assert.Empty(t, primary.blobs)
+		assert.Empty(t, secondary.blobs)
+	}
+
+	// A stream failure is reported, and neither destination records the blob.
+	primary := newFakeDestination(t, impl.Properties{})
+	secondary := newFakeDestination(t, impl.Properties{})
+	dest, err := NewDestination(primary, secondary)
+	require.NoError(t, err)
+	streamErr := errors.New("stream failure")
+	_, err = dest.PutBlob(context.Background(), io.MultiReader(bytes.NewReader(blob[:1000]), &failingReader{streamErr}), blobInfo, none.NoCache, false)
+	assert.ErrorIs(t, err, streamErr)
+	assert.Empty(t, primary.blobs)
+	assert.Empty(t, secondary.blobs)
+}
+
+// failingReader is an io.Reader which always fails with err.
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestTryReusingBlob(t *testing.T) {
+	blobDigest := digest.FromString("blob")
+	substitute := types.BlobInfo{Digest: digest.FromString("substitute"), Size: 10}
+	for _, c := range []struct {
+		primary, secondary *types.BlobInfo // nil if the blob is not known
+		expected           *types.BlobInfo // nil if the blob should not be reused
+	}{
+		{nil, nil, nil},
+		{&types.BlobInfo{Digest: blobDigest, Size: 4}, nil, nil},
+		{nil, &types.BlobInfo{Digest: blobDigest, Size: 4}, nil},
+		{&types.BlobInfo{Digest: blobDigest, Size: 4}, &substitute, nil},
+		{&types.BlobInfo{Digest: blobDigest, Size: 4}, &types.BlobInfo{Digest: blobDigest, Size: 4}, &types.BlobInfo{Digest: blobDigest, Size: 4}},
+		// Substitutes are not used, the destinations might choose different ones.
+		{&substitute, &substitute, nil},
+	} {
+		primary := newFakeDestination(t, impl.Properties{})
+		if c.primary != nil {
+			primary.knownBlobs[blobDigest] = *c.primary
+		}
+		secondary := newFakeDestination(t, impl.Properties{})
+		if c.secondary != nil {
+			secondary.knownBlobs[blobDigest] = *c.secondary
+		}
+		dest, err := NewDestination(primary, secondary)
+		require.NoError(t, err)
+		reused, info, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blobDigest}, memory.New(), true)
+		require.NoError(t, err)
+		if c.expected == nil {
+			assert.False(t, reused)
+			// Neither destination has actually reused the blob, e.g. if only the primary one could.
+			assert.Empty(t, primary.reusedBlobs)
+			assert.Empty(t, secondary.reusedBlobs)
+		} else {
+			assert.True(t, reused)
+			assert.Equal(t, *c.expected, info)
+			assert.Equal(t, []digest.Digest{blobDigest}, primary.reusedBlobs)
+			assert.Equal(t, []digest.Digest{blobDigest}, secondary.reusedBlobs)
+		}
+	}
+}
+
+func TestPutManifestSignaturesAndCommit(t *testing.T) {
+	man := []byte("manifest")
+	sigs := []signature.Signature{signature.SimpleSigningFromBlob([]byte("signature"))}
+
+	primary := newFakeDestination(t, impl.Properties{})
+	secondary := newFakeDestination(t, impl.Properties{})
+	d, err := newDestination(primary.ref, primary, secondary)
+	require.NoError(t, err)
+	require.NoError(t, d.PutManifest(context.Background(), man, nil))
+	require.NoError(t, d.PutSignaturesWithFormat(context.Background(), sigs, nil))
+	require.NoError(t, d.Commit(context.Background(), nil))
+	for _, f := range []*fakeDestination{primary, secondary} {
+		assert.Equal(t, [][]byte{man}, f.manifests)
+		assert.Equal(t, [][]byte{[]byte("signature")}, f.signatures)
+		assert.True(t, f.committed)
+	}
+
+	// A failure to write the manifest to the secondary destination is reported as such.
+	primary = newFakeDestination(t, impl.Properties{})
+	secondary = newFakeDestination(t, impl.Properties{})
+	secondary.putManifestErr = errors.New("injected failure")
+	d, err = newDestination(primary.ref, primary, secondary)
+	require.NoError(t, err)
+	err = d.PutManifest(context.Background(), man, nil)
+	assert.ErrorIs(t, err, secondary.putManifestErr)
+	assert.Contains(t, err.Error(), "secondary")
+
+	// If the secondary destination can't be committed, the primary one is not committed either.
+	primary = newFakeDestination(t, impl.Properties{})
+	secondary = newFakeDestination(t, impl.Properties{})
+	secondary.commitErr = errors.New("injected failure")
+	d, err = newDestination(primary.ref, primary, secondary)
+	require.NoError(t, err)
+	err = d.Commit(context.Background(), nil)
+	assert.ErrorIs(t, err, secondary.commitErr)
+	assert.False(t, primary.committed)
+
+	// A failure to commit the primary destination reports that the secondary one has been committed.
+	primary = newFakeDestination(t, impl.Properties{})
+	primary.commitErr = errors.New("injected failure")
+	secondary = newFakeDestination(t, impl.Properties{})
+	d, err = newDestination(primary.ref, primary, secondary)
+	require.NoError(t, err)
+	err = d.Commit(context.Background(), nil)
+	assert.ErrorIs(t, err, primary.commitErr)
+	assert.Contains(t, err.Error(), "was committed to secondary")
+	assert.True(t, secondary.committed)
+}
@@ -0,0 +1,95 @@
+// Package tee implements an image destination which writes the same image to two destinations at once,
+// e.g. to a registry and to a local cache.
+package tee
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+)
+
+// Reference is an image reference which, when used as a destination, writes the image to both of the
+// wrapped references; blob data is streamed to both destinations at the same time.
+// The primary reference determines the identity of the image (e.g. for signature policies),
+// and is the one used when reading the image.
+//
+// Implements types.ImageReference.
+type Reference struct {
+	primary   types.ImageReference
+	secondary types.ImageReference
+}
+
+// NewReference returns a reference which writes images to both primary and secondary.
+func NewReference(primary, secondary types.ImageReference) (*Reference, error) {
+	if primary == nil || secondary == nil {
+		return nil, fmt.Errorf("both a primary and a secondary destination must be specified")
+	}
+	return &Reference{
+		primary:   primary,
+		secondary: secondary,
+	}, nil
+}
+
+func (r *Reference) Transport() types.ImageTransport {
+	return r.primary.Transport()
+}
+
+func (r *Reference) StringWithinTransport() string {
+	return r.primary.StringWithinTransport()
+}
+
+func (r *Reference) DockerReference() reference.Named {
+	return r.primary.DockerReference()
+}
+
+func (r *Reference) PolicyConfigurationIdentity() string {
+	return r.primary.PolicyConfigurationIdentity()
+}
+
+func (r *Reference) PolicyConfigurationNamespaces() []string {
+	return r.primary.PolicyConfigurationNamespaces()
+}
+
+// DeleteImage deletes the image from both destinations.
+func (r *Reference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	if err := r.primary.DeleteImage(ctx, sys); err != nil {
+		return fmt.Errorf("deleting image %q: %w", transports.ImageName(r.primary), err)
+	}
+	if err := r.secondary.DeleteImage(ctx, sys); err != nil {
+		return fmt.Errorf("image %q was deleted, but deleting secondary image %q failed: %w",
+			transports.ImageName(r.primary), transports.ImageName(r.secondary), err)
+	}
+	return nil
+}
+
+// NewImage reads the image from the primary reference.
+func (r *Reference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	return r.primary.NewImage(ctx, sys)
+}
+
+// NewImageSource reads the image from the primary reference.
+func (r *Reference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	return r.primary.NewImageSource(ctx, sys)
+}
+
+func (r *Reference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	primary, err := r.primary.NewImageDestination(ctx, sys)
+	if err != nil {
+		return nil, fmt.Errorf("creating image destination %q: %w", transports.ImageName(r.primary), err)
+	}
+	secondary, err := r.secondary.NewImageDestination(ctx, sys)
+	if err != nil {
+		primary.Close()
+		return nil, fmt.Errorf("creating secondary image destination %q: %w", transports.ImageName(r.secondary), err)
+	}
+	d, err := newDestination(r, primary, secondary)
+	if err != nil {
+		primary.Close()
+		secondary.Close()
+		return nil, err
+	}
+	return d, nil
+}
@@ -3,6 +3,7 @@ package sif
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -65,21 +66,21 @@ func generateInjectedScript(environment []string, runscript []string) []byte {
 	return []byte(script)
 }
 
-// processDefFile finds sif.DataDeffile in sifImage, if any,
-// and returns:
-// - the command to run
-// - contents of a script to inject as injectedScriptTargetPath, or nil
-func processDefFile(sifImage *sif.FileImage) (string, []byte, error) {
-	var environment, runscript []string
-
+// readDefFile finds sif.DataDeffile in sifImage, if any, and returns non-trivial contents of
+// its %environment and %runscript sections, or nil values if there is no definition file.
+func readDefFile(sifImage *sif.FileImage) ([]string, []string, error) {
 	desc, err := sifImage.GetDescriptor(sif.WithDataType(sif.DataDeffile))
-	if err == nil {
-		environment, runscript, err = parseDefFile(desc.GetReader())
-		if err != nil {
-			return "", nil, err
-		}
+	if err != nil {
+		return nil, nil, nil
 	}
+	return parseDefFile(desc.GetReader())
+}
 
+// processDefFile uses the %environment and %runscript sections of a SIF definition file, as returned by readDefFile,
+// and returns:
+// - the command to run
+// - contents of a script to inject as injectedScriptTargetPath, or nil
+func processDefFile(environment, runscript []string) (string, []byte) {
 	var command string
 	var injectedScript []byte
 	if len(environment) == 0 && len(runscript) == 0 {
@@ -90,7 +91,75 @@ func processDefFile(sifImage *sif.FileImage) (string, []byte, error) {
 		command = injectedScriptTargetPath
 	}
 
-	return command, injectedScript, nil
+	return command, injectedScript
+}
+
+// parseEnvironment returns the variables set by simple assignments ("NAME=value" or "export NAME=value") in lines,
+// formatted as "NAME=value".  Other shell commands are ignored, and so are assignments of values which the shell
+// would expand (using $ or backticks), because we can't reproduce the result.
+func parseEnvironment(lines []string) []string {
+	res := []string{}
+	for _, line := range lines {
+		s := strings.TrimSpace(line)
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		s = strings.TrimSpace(strings.TrimPrefix(s, "export "))
+		i := strings.Index(s, "=")
+		if i <= 0 || strings.ContainsAny(s[:i], " \t$\"'") {
+			continue
+		}
+		name, value := s[:i], s[i+1:]
+		singleQuoted := len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\''
+		if !singleQuoted && strings.ContainsAny(value, "$`") {
+			logrus.Warnf("Ignoring SIF environment variable %s, its value %q would be expanded by the shell", name, value)
+			continue
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		res = append(res, name+"="+value)
+	}
+	return res
+}
+
+// processMetadata uses the %environment section of the definition file, as returned by readDefFile,
+// finds sif.DataEnvVar and sif.DataLabels in sifImage, if any, and returns:
+// - the environment variables set by the %environment section of the definition file, and by the environment object, or nil
+// - the labels, or nil
+func processMetadata(sifImage *sif.FileImage, defFileEnvironment []string) ([]string, map[string]string, error) {
+	var env []string
+	if len(defFileEnvironment) != 0 {
+		env = append(env, parseEnvironment(defFileEnvironment)...)
+	}
+	if desc, err := sifImage.GetDescriptor(sif.WithDataType(sif.DataEnvVar)); err == nil {
+		data, err := desc.GetData()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading SIF environment object: %w", err)
+		}
+		env = append(env, parseEnvironment(strings.Split(string(data), "\n"))...)
+	}
+
+	var labels map[string]string
+	if desc, err := sifImage.GetDescriptor(sif.WithDataType(sif.DataLabels)); err == nil {
+		data, err := desc.GetData()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading SIF labels object: %w", err)
+		}
+		rawLabels := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &rawLabels); err != nil {
+			return nil, nil, fmt.Errorf("parsing SIF labels object: %w", err)
+		}
+		labels = map[string]string{}
+		for key, raw := range rawLabels {
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				value = string(raw) // Not a string, use the JSON representation.
+			}
+			labels[key] = value
+		}
+	}
+	return env, labels, nil
 }
 
 func writeInjectedScript(extractedRootPath string, injectedScript []byte) error {
@@ -140,14 +209,15 @@ func createTarFromSIFInputs(ctx context.Context, tarPath, squashFSPath string, i
 	return nil
 }
 
-// convertSIFToElements processes sifImage and creates/returns
+// convertSIFToElements processes sifImage, using the %environment and %runscript sections of its definition file
+// as returned by readDefFile, and creates/returns
 // the relevant elements for constructing an OCI-like image:
 // - A path to a tar file containing a root filesystem,
 // - A command to run.
 // The returned tar file path is inside tempDir, which can be assumed to be empty
 // at start, and is exclusively used by the current process (i.e. it is safe
 // to use hard-coded relative paths within it).
-func convertSIFToElements(ctx context.Context, sifImage *sif.FileImage, tempDir string) (string, []string, error) {
+func convertSIFToElements(ctx context.Context, sifImage *sif.FileImage, environment, runscript []string, tempDir string) (string, []string, error) {
 	// We could allocate unique names for all of these using os.{CreateTemp,MkdirTemp}, but tempDir is exclusive,
 	// so we can just hard-code a set of unique values here.
 	// We create and/or manage cleanup of these two paths.
@@ -173,10 +243,7 @@ func convertSIFToElements(ctx context.Context, sifImage *sif.FileImage, tempDir
 		}
 	}()
 
-	command, injectedScript, err := processDefFile(sifImage)
-	if err != nil {
-		return "", nil, err
-	}
+	command, injectedScript := processDefFile(environment, runscript)
 
 	rootFS, err := sifImage.GetDescriptor(sif.WithPartitionType(sif.PartPrimSys))
 	if err != nil {
@@ -2,10 +2,13 @@ package sif
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/sylabs/sif/v2/pkg/sif"
 )
 
 func TestParseDefFile(t *testing.T) {
@@ -56,3 +59,56 @@ func TestGenerateInjectedScript(t *testing.T) {
 		`echo "Hello $FOO"`+"\n"+
 		"sleep 5\n", string(res))
 }
+
+func TestParseEnvironment(t *testing.T) {
+	res := parseEnvironment([]string{
+		"export FOO=world",
+		"  BAR=baz  ",
+		`export QUOTED="a b"`,
+		"SINGLE='c d'",
+		"EMPTY=",
+		"# COMMENTED=out",
+		"",
+		`if [ -z "$X" ]; then X=1; fi`,
+		"=novalue",
+		"EXPANDED=$HOME/bin",
+		`QUOTEDEXPANDED="${HOME}"`,
+		"COMMAND=`date`",
+		"LITERAL='$HOME'",
+	})
+	assert.Equal(t, []string{"FOO=world", "BAR=baz", "QUOTED=a b", "SINGLE=c d", "EMPTY=", "LITERAL=$HOME"}, res)
+}
+
+func TestProcessMetadata(t *testing.T) {
+	// testdata/labels.sif contains a definition file, an environment object, and a labels object.
+	sifImg, err := sif.LoadContainerFromPath("testdata/labels.sif", sif.OptLoadWithFlag(os.O_RDONLY))
+	require.NoError(t, err)
+	defer func() {
+		_ = sifImg.UnloadContainer()
+	}()
+	environment, _, err := readDefFile(sifImg)
+	require.NoError(t, err)
+	env, labels, err := processMetadata(sifImg, environment)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"FOO=world", "LANG=C.UTF-8", "PATH=/opt/bin:/usr/bin"}, env)
+	assert.Equal(t, map[string]string{
+		"org.label-schema.schema-version": "1.0",
+		"org.opencontainers.image.title":  "example",
+		"count":                           "3",
+	}, labels)
+
+	// A SIF file without any metadata objects
+	emptyImg, err := sif.CreateContainerAtPath(filepath.Join(t.TempDir(), "empty.sif"))
+	require.NoError(t, err)
+	defer func() {
+		_ = emptyImg.UnloadContainer()
+	}()
+	environment, runscript, err := readDefFile(emptyImg)
+	require.NoError(t, err)
+	assert.Nil(t, environment)
+	assert.Nil(t, runscript)
+	env, labels, err = processMetadata(emptyImg, environment)
+	require.NoError(t, err)
+	assert.Nil(t, env)
+	assert.Nil(t, labels)
+}
@@ -84,7 +84,11 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref sifRefere
 		}
 	}()
 
-	layerPath, commandLine, err := convertSIFToElements(ctx, sifImg, workDir)
+	environment, runscript, err := readDefFile(sifImg)
+	if err != nil {
+		return nil, err
+	}
+	layerPath, commandLine, err := convertSIFToElements(ctx, sifImg, environment, runscript, workDir)
 	if err != nil {
 		return nil, fmt.Errorf("converting rootfs from SquashFS to Tarball: %w", err)
 	}
@@ -94,13 +98,20 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref sifRefere
 		return nil, fmt.Errorf("gathering blob information: %w", err)
 	}
 
+	env, labels, err := processMetadata(sifImg, environment)
+	if err != nil {
+		return nil, fmt.Errorf("reading SIF metadata: %w", err)
+	}
+
 	created := sifImg.ModifiedAt()
 	config := imgspecv1.Image{
 		Created:      &created,
 		Architecture: sifImg.PrimaryArch(),
 		OS:           "linux",
 		Config: imgspecv1.ImageConfig{
-			Cmd: commandLine,
+			Cmd:    commandLine,
+			Env:    env,
+			Labels: labels,
 		},
 		RootFS: imgspecv1.RootFS{
 			Type:    "layers",
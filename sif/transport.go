@@ -27,6 +27,17 @@ func (t sifTransport) Name() string {
 	return "sif"
 }
 
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "sif:path"
+	ReferenceSyntaxExample = "sif:/var/tmp/busybox.sif"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t sifTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
 func (t sifTransport) ParseReference(reference string) (types.ImageReference, error) {
 	return NewReference(reference)
@@ -50,17 +50,48 @@ func SignDockerManifest(m []byte, dockerReference string, mech SigningMechanism,
 // using mech.
 func VerifyDockerManifestSignature(unverifiedSignature, unverifiedManifest []byte,
 	expectedDockerReference string, mech SigningMechanism, expectedKeyIdentity string) (*Signature, error) {
+	return verifyDockerManifestSignature(unverifiedSignature, unverifiedManifest, expectedDockerReference, mech, func(keyIdentity string) error {
+		if keyIdentity != expectedKeyIdentity {
+			return internal.NewInvalidSignatureError(fmt.Sprintf("Signature by %s does not match expected fingerprint %s", keyIdentity, expectedKeyIdentity))
+		}
+		return nil
+	})
+}
+
+// VerifyDockerManifestSignatureWithPublicKeys checks that unverifiedSignature uses one of the GPG keys in publicKeys
+// (as accepted by NewEphemeralGPGSigningMechanism) to sign unverifiedManifest as expectedDockerReference, and returns
+// the signed claims. This does not use the user’s GPG keyring, nor any registry, so it can be used to verify a signature
+// and a manifest stored in local files.
+func VerifyDockerManifestSignatureWithPublicKeys(unverifiedSignature, unverifiedManifest []byte,
+	expectedDockerReference string, publicKeys []byte) (*Signature, error) {
+	mech, trustedIdentities, err := NewEphemeralGPGSigningMechanism(publicKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer mech.Close()
+	if len(trustedIdentities) == 0 {
+		return nil, errors.New("no public keys provided")
+	}
+	return verifyDockerManifestSignature(unverifiedSignature, unverifiedManifest, expectedDockerReference, mech, func(keyIdentity string) error {
+		for _, trustedIdentity := range trustedIdentities {
+			if keyIdentity == trustedIdentity {
+				return nil
+			}
+		}
+		return internal.NewInvalidSignatureError(fmt.Sprintf("Signature by %s does not match any of the provided public keys", keyIdentity))
+	})
+}
+
+// verifyDockerManifestSignature checks that unverifiedSignature uses a key accepted by validateKeyIdentity to sign unverifiedManifest
+// as expectedDockerReference, using mech.
+func verifyDockerManifestSignature(unverifiedSignature, unverifiedManifest []byte,
+	expectedDockerReference string, mech SigningMechanism, validateKeyIdentity func(keyIdentity string) error) (*Signature, error) {
 	expectedRef, err := reference.ParseNormalizedNamed(expectedDockerReference)
 	if err != nil {
 		return nil, err
 	}
 	sig, err := verifyAndExtractSignature(mech, unverifiedSignature, signatureAcceptanceRules{
-		validateKeyIdentity: func(keyIdentity string) error {
-			if keyIdentity != expectedKeyIdentity {
-				return internal.NewInvalidSignatureError(fmt.Sprintf("Signature by %s does not match expected fingerprint %s", keyIdentity, expectedKeyIdentity))
-			}
-			return nil
-		},
+		validateKeyIdentity: validateKeyIdentity,
 		validateSignedDockerReference: func(signedDockerReference string) error {
 			signedRef, err := reference.ParseNormalizedNamed(signedDockerReference)
 			if err != nil {
@@ -167,3 +167,53 @@ func TestVerifyDockerManifestSignature(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, sig)
 }
+
+func TestVerifyDockerManifestSignatureWithPublicKeys(t *testing.T) {
+	manifest, err := os.ReadFile("fixtures/image.manifest.json")
+	require.NoError(t, err)
+	signature, err := os.ReadFile("fixtures/image.signature")
+	require.NoError(t, err)
+	publicKey, err := os.ReadFile("fixtures/public-key.gpg")
+	require.NoError(t, err)
+	otherPublicKey, err := os.ReadFile("fixtures/public-key-2.gpg")
+	require.NoError(t, err)
+	bothPublicKeys, err := os.ReadFile("fixtures/pubring.gpg")
+	require.NoError(t, err)
+
+	// Successful verification
+	for _, keys := range [][]byte{publicKey, bothPublicKeys} {
+		sig, err := VerifyDockerManifestSignatureWithPublicKeys(signature, manifest, TestImageSignatureReference, keys)
+		require.NoError(t, err)
+		assert.Equal(t, TestImageSignatureReference, sig.DockerReference)
+		assert.Equal(t, TestImageManifestDigest, sig.DockerManifestDigest)
+	}
+
+	// Verification using a different canonicalization of TestImageSignatureReference
+	sig, err := VerifyDockerManifestSignatureWithPublicKeys(signature, manifest, "docker.io/"+TestImageSignatureReference, publicKey)
+	require.NoError(t, err)
+	assert.Equal(t, TestImageSignatureReference, sig.DockerReference)
+
+	// For extra paranoia, test that we return nil data on error.
+
+	// No valid public keys
+	sig, err = VerifyDockerManifestSignatureWithPublicKeys(signature, manifest, TestImageSignatureReference, []byte("This is invalid"))
+	assert.Error(t, err)
+	assert.Nil(t, sig)
+
+	// Signed by a different key
+	sig, err = VerifyDockerManifestSignatureWithPublicKeys(signature, manifest, TestImageSignatureReference, otherPublicKey)
+	assert.Error(t, err)
+	assert.Nil(t, sig)
+
+	// Docker reference mismatch
+	sig, err = VerifyDockerManifestSignatureWithPublicKeys(signature, manifest, "example.com/does-not/match", publicKey)
+	assert.Error(t, err)
+	assert.Nil(t, sig)
+
+	// Docker manifest digest mismatch
+	otherManifest, err := os.ReadFile("fixtures/dir-img-modified-manifest/manifest.json")
+	require.NoError(t, err)
+	sig, err = VerifyDockerManifestSignatureWithPublicKeys(signature, otherManifest, TestImageSignatureReference, publicKey)
+	assert.Error(t, err)
+	assert.Nil(t, sig)
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+	sigstoreSignature "github.com/sigstore/sigstore/pkg/signature"
+)
+
+const (
+	// InTotoPayloadType is the DSSE payload type of in-toto statements.
+	InTotoPayloadType = "application/vnd.in-toto+json"
+	// inTotoStatementTypePrefix is the prefix of the "_type" value of all versions of in-toto statements.
+	inTotoStatementTypePrefix = "https://in-toto.io/Statement/"
+	inTotoHashAlgorithm       = crypto.SHA256
+)
+
+// DSSEEnvelope is a DSSE (Dead Simple Signing Envelope), as used for in-toto attestations.
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"` // base64-encoded
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+// DSSESignature is a single signature in a DSSEEnvelope.
+type DSSESignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"` // base64-encoded
+}
+
+// DSSEPreAuthEncoding returns the DSSE pre-authentication encoding of payload with payloadType, i.e. the data which is signed.
+func DSSEPreAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// UntrustedInTotoStatement is a parsed in-toto statement; only the fields relevant for policy evaluation are included.
+type UntrustedInTotoStatement struct {
+	UntrustedType          string                   `json:"_type"`
+	UntrustedSubjects      []UntrustedInTotoSubject `json:"subject"`
+	UntrustedPredicateType string                   `json:"predicateType"`
+}
+
+// UntrustedInTotoSubject is a subject of an in-toto statement.
+type UntrustedInTotoSubject struct {
+	UntrustedName    string            `json:"name"`
+	UntrustedDigests map[string]string `json:"digest"` // Algorithm name (e.g. "sha256") → hex value
+}
+
+// InTotoAcceptanceRules specifies how to decide whether an in-toto statement is acceptable.
+// We centralize the actual parsing and data extraction in VerifyInTotoEnvelope; this supplies
+// the policy, similarly to SigstorePayloadAcceptanceRules.
+type InTotoAcceptanceRules struct {
+	ValidateSubjectDigests func(digests []digest.Digest) error
+	ValidatePredicateType  func(predicateType string) error
+}
+
+// VerifyInTotoEnvelope verifies unverifiedEnvelope, a DSSE envelope containing an in-toto statement, using publicKey,
+// and if at least one of its signatures is valid, returns the statement after validating it using rules.
+func VerifyInTotoEnvelope(publicKey crypto.PublicKey, unverifiedEnvelope []byte, rules InTotoAcceptanceRules) (*UntrustedInTotoStatement, error) {
+	verifier, err := sigstoreSignature.LoadVerifier(publicKey, inTotoHashAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("creating verifier: %w", err)
+	}
+
+	var envelope DSSEEnvelope
+	if err := json.Unmarshal(unverifiedEnvelope, &envelope); err != nil {
+		return nil, NewInvalidSignatureError(fmt.Sprintf("parsing DSSE envelope: %v", err))
+	}
+	if envelope.PayloadType != InTotoPayloadType {
+		return nil, NewInvalidSignatureError(fmt.Sprintf("unexpected DSSE payload type %q", envelope.PayloadType))
+	}
+	unverifiedPayload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, NewInvalidSignatureError(fmt.Sprintf("base64 decoding DSSE payload: %v", err))
+	}
+	if len(envelope.Signatures) == 0 {
+		return nil, NewInvalidSignatureError("DSSE envelope contains no signatures")
+	}
+	pae := DSSEPreAuthEncoding(envelope.PayloadType, unverifiedPayload)
+	var verifyErr error
+	verified := false
+	for _, sig := range envelope.Signatures {
+		unverifiedSignature, err := base64.StdEncoding.DecodeString(sig.Sig)
+		if err != nil {
+			verifyErr = NewInvalidSignatureError(fmt.Sprintf("base64 decoding: %v", err))
+			continue
+		}
+		if err := verifier.VerifySignature(bytes.NewReader(unverifiedSignature), bytes.NewReader(pae)); err != nil {
+			verifyErr = NewInvalidSignatureError(fmt.Sprintf("cryptographic signature verification failed: %v", err))
+			continue
+		}
+		verified = true
+		break
+	}
+	if !verified {
+		return nil, verifyErr
+	}
+
+	var statement UntrustedInTotoStatement
+	if err := json.Unmarshal(unverifiedPayload, &statement); err != nil {
+		return nil, NewInvalidSignatureError(fmt.Sprintf("parsing in-toto statement: %v", err))
+	}
+	if !strings.HasPrefix(statement.UntrustedType, inTotoStatementTypePrefix) {
+		return nil, NewInvalidSignatureError(fmt.Sprintf("unexpected in-toto statement type %q", statement.UntrustedType))
+	}
+	subjectDigests := []digest.Digest{}
+	for _, subject := range statement.UntrustedSubjects {
+		for algorithm, value := range subject.UntrustedDigests {
+			subjectDigests = append(subjectDigests, digest.NewDigestFromEncoded(digest.Algorithm(algorithm), value))
+		}
+	}
+	if err := rules.ValidateSubjectDigests(subjectDigests); err != nil {
+		return nil, err
+	}
+	if err := rules.ValidatePredicateType(statement.UntrustedPredicateType); err != nil {
+		return nil, err
+	}
+	// InTotoAcceptanceRules have accepted this value.
+	return &statement, nil
+}
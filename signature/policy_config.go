@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 
@@ -143,8 +144,9 @@ func (m *policyTransportsMap) UnmarshalJSON(data []byte) error {
 			return nil
 		}
 		ptsWithTransport := policyTransportScopesWithTransport{
-			transport: transport,
-			dest:      &PolicyTransportScopes{}, // This allocates a new instance on each call.
+			transportName: key,
+			transport:     transport,
+			dest:          &PolicyTransportScopes{}, // This allocates a new instance on each call.
 		}
 		tmpMap[key] = ptsWithTransport.dest
 		return &ptsWithTransport
@@ -169,8 +171,9 @@ func (m *PolicyTransportScopes) UnmarshalJSON(data []byte) error {
 // policyTransportScopesWithTransport is a way to unmarshal a PolicyTransportScopes
 // while validating using a specific ImageTransport if not nil.
 type policyTransportScopesWithTransport struct {
-	transport types.ImageTransport
-	dest      *PolicyTransportScopes
+	transportName string
+	transport     types.ImageTransport
+	dest          *PolicyTransportScopes
 }
 
 // Compile-time check that policyTransportScopesWithTransport implements json.Unmarshaler.
@@ -197,6 +200,13 @@ func (m *policyTransportScopesWithTransport) UnmarshalJSON(data []byte) error {
 	}); err != nil {
 		return err
 	}
+	for key := range tmpMap {
+		if isGlobScope(m.transportName, key) {
+			if _, err := path.Match(key, ""); err != nil {
+				return InvalidPolicyFormatError(fmt.Sprintf("Invalid scope pattern %q: %v", key, err))
+			}
+		}
+	}
 	for key, ptr := range tmpMap {
 		(*m.dest)[key] = *ptr
 	}
@@ -245,6 +255,8 @@ func newPolicyRequirementFromJSON(data []byte) (PolicyRequirement, error) {
 		res = &prSignedBaseLayer{}
 	case prTypeSigstoreSigned:
 		res = &prSigstoreSigned{}
+	case prTypeInTotoAttested:
+		res = &prInTotoAttested{}
 	default:
 		return nil, InvalidPolicyFormatError(fmt.Sprintf("Unknown policy requirement type \"%s\"", typeField.Type))
 	}
@@ -619,6 +631,97 @@ func (pr *prSigstoreSigned) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// newPRInTotoAttested returns a new prInTotoAttested if parameters are valid.
+func newPRInTotoAttested(keyPath string, keyData []byte, predicateType string) (*prInTotoAttested, error) {
+	if len(keyPath) > 0 && len(keyData) > 0 {
+		return nil, InvalidPolicyFormatError("keyPath and keyData cannot be used simultaneously")
+	}
+	if len(keyPath) == 0 && len(keyData) == 0 {
+		return nil, InvalidPolicyFormatError("At least one of keyPath and keyData must be specified")
+	}
+	return &prInTotoAttested{
+		prCommon:      prCommon{Type: prTypeInTotoAttested},
+		KeyPath:       keyPath,
+		KeyData:       keyData,
+		PredicateType: predicateType,
+	}, nil
+}
+
+// newPRInTotoAttestedKeyPath is NewPRInTotoAttestedKeyPath, except it returns the private type.
+func newPRInTotoAttestedKeyPath(keyPath string, predicateType string) (*prInTotoAttested, error) {
+	return newPRInTotoAttested(keyPath, nil, predicateType)
+}
+
+// NewPRInTotoAttestedKeyPath returns a new "inTotoAttested" PolicyRequirement using a KeyPath.
+// If predicateType is not empty, the attestation must have that predicate type.
+func NewPRInTotoAttestedKeyPath(keyPath string, predicateType string) (PolicyRequirement, error) {
+	return newPRInTotoAttestedKeyPath(keyPath, predicateType)
+}
+
+// newPRInTotoAttestedKeyData is NewPRInTotoAttestedKeyData, except it returns the private type.
+func newPRInTotoAttestedKeyData(keyData []byte, predicateType string) (*prInTotoAttested, error) {
+	return newPRInTotoAttested("", keyData, predicateType)
+}
+
+// NewPRInTotoAttestedKeyData returns a new "inTotoAttested" PolicyRequirement using a KeyData.
+// If predicateType is not empty, the attestation must have that predicate type.
+func NewPRInTotoAttestedKeyData(keyData []byte, predicateType string) (PolicyRequirement, error) {
+	return newPRInTotoAttestedKeyData(keyData, predicateType)
+}
+
+// Compile-time check that prInTotoAttested implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prInTotoAttested)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (pr *prInTotoAttested) UnmarshalJSON(data []byte) error {
+	*pr = prInTotoAttested{}
+	var tmp prInTotoAttested
+	var gotKeyPath, gotKeyData = false, false
+	if err := internal.ParanoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "type":
+			return &tmp.Type
+		case "keyPath":
+			gotKeyPath = true
+			return &tmp.KeyPath
+		case "keyData":
+			gotKeyData = true
+			return &tmp.KeyData
+		case "predicateType":
+			return &tmp.PredicateType
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if tmp.Type != prTypeInTotoAttested {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unexpected policy requirement type \"%s\"", tmp.Type))
+	}
+
+	var res *prInTotoAttested
+	var err error
+	switch {
+	case gotKeyPath && gotKeyData:
+		return InvalidPolicyFormatError("keyPath and keyData cannot be used simultaneously")
+	case gotKeyPath && !gotKeyData:
+		res, err = newPRInTotoAttestedKeyPath(tmp.KeyPath, tmp.PredicateType)
+	case !gotKeyPath && gotKeyData:
+		res, err = newPRInTotoAttestedKeyData(tmp.KeyData, tmp.PredicateType)
+	case !gotKeyPath && !gotKeyData:
+		return InvalidPolicyFormatError("At least one of keyPath and keyData must be specified")
+	default: // Coverage: This should never happen
+		return fmt.Errorf("Impossible keyPath/keyData presence combination!?")
+	}
+	if err != nil {
+		return err
+	}
+	*pr = *res
+
+	return nil
+}
+
 // newPolicyReferenceMatchFromJSON parses JSON data into a PolicyReferenceMatch implementation.
 func newPolicyReferenceMatchFromJSON(data []byte) (PolicyReferenceMatch, error) {
 	var typeField prmCommon
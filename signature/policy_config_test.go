@@ -497,8 +497,9 @@ func tryUnmarshalModifiedPTS(t *testing.T, pts *PolicyTransportScopes, transport
 
 	*pts = PolicyTransportScopes{}
 	dest := policyTransportScopesWithTransport{
-		transport: transport,
-		dest:      pts,
+		transportName: transport.Name(),
+		transport:     transport,
+		dest:          pts,
 	}
 	return jsonUnmarshalFromObject(t, tmp, &dest)
 }
@@ -507,8 +508,9 @@ func TestPolicyTransportScopesWithTransportUnmarshalJSON(t *testing.T) {
 	var pts PolicyTransportScopes
 
 	dest := policyTransportScopesWithTransport{
-		transport: docker.Transport,
-		dest:      &pts,
+		transportName: docker.Transport.Name(),
+		transport:     docker.Transport,
+		dest:          &pts,
 	}
 	testInvalidJSONInput(t, &dest)
 
@@ -530,8 +532,9 @@ func TestPolicyTransportScopesWithTransportUnmarshalJSON(t *testing.T) {
 	// Success
 	pts = PolicyTransportScopes{}
 	dest = policyTransportScopesWithTransport{
-		transport: docker.Transport,
-		dest:      &pts,
+		transportName: docker.Transport.Name(),
+		transport:     docker.Transport,
+		dest:          &pts,
 	}
 	err = json.Unmarshal(validJSON, &dest)
 	require.NoError(t, err)
@@ -547,6 +550,9 @@ func TestPolicyTransportScopesWithTransportUnmarshalJSON(t *testing.T) {
 		// A scope is an invalid PolicyRequirements
 		func(v mSI) { v["docker.io/library/busybox"] = PolicyRequirements{} },
 		func(v mSI) { v[""] = PolicyRequirements{} },
+		// A scope is an invalid glob pattern
+		func(v mSI) { v["docker.io/library/[busybox"] = v["docker.io/library/busybox"] },
+		func(v mSI) { v["docker.io/library/busybox\\"] = v["docker.io/library/busybox"] },
 	}
 	for _, fn := range breakFns {
 		err = tryUnmarshalModifiedPTS(t, &pts, docker.Transport, validJSON, fn)
@@ -563,8 +569,9 @@ func TestPolicyTransportScopesWithTransportUnmarshalJSON(t *testing.T) {
 
 		pts = PolicyTransportScopes{}
 		dest := policyTransportScopesWithTransport{
-			transport: docker.Transport,
-			dest:      &pts,
+			transportName: docker.Transport.Name(),
+			transport:     docker.Transport,
+			dest:          &pts,
 		}
 		err = json.Unmarshal(testJSON, &dest)
 		assert.Error(t, err)
@@ -586,11 +593,24 @@ func TestPolicyTransportScopesWithTransportUnmarshalJSON(t *testing.T) {
 				delete(v, key)
 			}
 		},
+		// Glob pattern scopes
+		func(v mSI) { v["docker.io/library/*-prod"] = v["docker.io/library/busybox"] },
+		func(v mSI) { v["registry*.example.com/[ab]team"] = v["docker.io/library/busybox"] },
+		func(v mSI) { v["*.example.com"] = v["docker.io/library/busybox"] },
 	}
 	for _, fn := range allowedModificationFns {
 		err = tryUnmarshalModifiedPTS(t, &pts, docker.Transport, validJSON, fn)
 		require.NoError(t, err)
 	}
+
+	// Scopes of other transports are never glob patterns, so they don't have to be valid patterns.
+	err = tryUnmarshalModifiedPTS(t, &pts, directory.Transport, validJSON, func(v mSI) {
+		for key := range v {
+			delete(v, key)
+		}
+		v["/dir/[unterminated"] = PolicyRequirements{NewPRReject()}
+	})
+	require.NoError(t, err)
 }
 
 func TestPolicyRequirementsUnmarshalJSON(t *testing.T) {
@@ -1179,6 +1199,102 @@ func TestPRSigstoreSignedUnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestNewPRInTotoAttested(t *testing.T) {
+	const testPath = "/foo/bar"
+	testData := []byte("abc")
+	const testPredicateType = "https://slsa.dev/provenance/v0.2"
+
+	// Success
+	pr, err := newPRInTotoAttested(testPath, nil, testPredicateType)
+	require.NoError(t, err)
+	assert.Equal(t, &prInTotoAttested{
+		prCommon:      prCommon{prTypeInTotoAttested},
+		KeyPath:       testPath,
+		KeyData:       nil,
+		PredicateType: testPredicateType,
+	}, pr)
+	pr, err = newPRInTotoAttested("", testData, "")
+	require.NoError(t, err)
+	assert.Equal(t, &prInTotoAttested{
+		prCommon:      prCommon{prTypeInTotoAttested},
+		KeyPath:       "",
+		KeyData:       testData,
+		PredicateType: "",
+	}, pr)
+
+	// Both keyPath and keyData specified
+	_, err = newPRInTotoAttested(testPath, testData, testPredicateType)
+	assert.Error(t, err)
+
+	// Neither keyPath nor keyData specified
+	_, err = newPRInTotoAttested("", nil, testPredicateType)
+	assert.Error(t, err)
+}
+
+func TestNewPRInTotoAttestedKeyPath(t *testing.T) {
+	const testPath = "/foo/bar"
+	_pr, err := NewPRInTotoAttestedKeyPath(testPath, "")
+	require.NoError(t, err)
+	pr, ok := _pr.(*prInTotoAttested)
+	require.True(t, ok)
+	assert.Equal(t, testPath, pr.KeyPath)
+	// Failure cases tested in TestNewPRInTotoAttested.
+}
+
+func TestNewPRInTotoAttestedKeyData(t *testing.T) {
+	testData := []byte("abc")
+	_pr, err := NewPRInTotoAttestedKeyData(testData, "")
+	require.NoError(t, err)
+	pr, ok := _pr.(*prInTotoAttested)
+	require.True(t, ok)
+	assert.Equal(t, testData, pr.KeyData)
+	// Failure cases tested in TestNewPRInTotoAttested.
+}
+
+func TestPRInTotoAttestedUnmarshalJSON(t *testing.T) {
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prInTotoAttested{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRInTotoAttestedKeyData([]byte("abc"), "https://slsa.dev/provenance/v0.2")
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// The "type" field is missing
+			func(v mSI) { delete(v, "type") },
+			// Wrong "type" field
+			func(v mSI) { v["type"] = 1 },
+			func(v mSI) { v["type"] = "this is invalid" },
+			// Extra top-level sub-object
+			func(v mSI) { v["unexpected"] = 1 },
+			// Both "keyPath" and "keyData" is missing
+			func(v mSI) { delete(v, "keyData") },
+			// Both "keyPath" and "keyData" is present
+			func(v mSI) { v["keyPath"] = "/foo/bar" },
+			// Invalid "keyPath" field
+			func(v mSI) { delete(v, "keyData"); v["keyPath"] = 1 },
+			// Invalid "keyData" field
+			func(v mSI) { v["keyData"] = 1 },
+			func(v mSI) { v["keyData"] = "this is invalid base64" },
+			// Invalid "predicateType" field
+			func(v mSI) { v["predicateType"] = 1 },
+		},
+		duplicateFields: []string{"type", "keyData", "predicateType"},
+	}.run(t)
+	// Test the keyPath-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prInTotoAttested{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRInTotoAttestedKeyPath("/foo/bar", "")
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		duplicateFields: []string{"type", "keyPath"},
+	}.run(t)
+}
+
 func TestNewPolicyReferenceMatchFromJSON(t *testing.T) {
 	// Sample success. Others tested in the individual PolicyReferenceMatch.UnmarshalJSON implementations.
 	validPRM := NewPRMMatchRepoDigestOrExact()
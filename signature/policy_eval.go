@@ -8,6 +8,9 @@ package signature
 import (
 	"context"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/internal/unparsedimage"
@@ -132,8 +135,78 @@ func policyIdentityLogName(ref types.ImageReference) string {
 	return ref.Transport().Name() + ":" + ref.PolicyConfigurationIdentity()
 }
 
+// isGlobScope returns true if scope, a key of PolicyTransportScopes for transportName, is a glob pattern (as used by path.Match)
+// instead of a literal scope.
+// Only scopes of the docker and atomic transports, which are repository names, can be glob patterns; scopes of other transports
+// (e.g. file system paths, which may legitimately contain the special characters) are always literal.
+// The "*.example.com" wildcarded subdomain expressions returned by PolicyConfigurationNamespaces of some transports
+// are literal scopes, not glob patterns.
+func isGlobScope(transportName, scope string) bool {
+	if transportName != "docker" && transportName != "atomic" {
+		return false
+	}
+	if strings.HasPrefix(scope, "*.") {
+		scope = scope[len("*."):]
+	}
+	return strings.ContainsAny(scope, `*?[\`)
+}
+
+// globScopeSpecificity returns a measure of how specific the glob pattern scope is: the number of its characters
+// which match only themselves.
+func globScopeSpecificity(scope string) int {
+	res := 0
+	inClass := false
+	for i := 0; i < len(scope); i++ {
+		switch c := scope[i]; {
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+		case c == '*' || c == '?':
+		case c == '\\':
+			i++ // The escaped character is literal.
+			res++
+		default:
+			res++
+		}
+	}
+	return res
+}
+
+// matchGlobScopes returns the most specific of the glob pattern scopes in transportScopes, for transportName, which match name,
+// a namespace returned by PolicyConfigurationNamespaces, or "" if there is none.
+// It fails if more than one pattern with the highest specificity matches, because the choice would be ambiguous.
+func matchGlobScopes(transportName string, transportScopes PolicyTransportScopes, name string) (string, error) {
+	bestScope := ""
+	bestSpecificity := -1
+	ambiguousScope := ""
+	for scope := range transportScopes {
+		if !isGlobScope(transportName, scope) {
+			continue
+		}
+		if matches, err := path.Match(scope, name); err != nil || !matches { // Invalid patterns are rejected when parsing the policy; never match them otherwise.
+			continue
+		}
+		specificity := globScopeSpecificity(scope)
+		switch {
+		case specificity > bestSpecificity:
+			bestScope, bestSpecificity, ambiguousScope = scope, specificity, ""
+		case specificity == bestSpecificity:
+			ambiguousScope = scope
+		}
+	}
+	if ambiguousScope != "" {
+		scopes := []string{bestScope, ambiguousScope}
+		sort.Strings(scopes)
+		return "", PolicyRequirementError(fmt.Sprintf("Ambiguous policy: %q matches both scopes %q and %q", name, scopes[0], scopes[1]))
+	}
+	return bestScope, nil
+}
+
 // requirementsForImageRef selects the appropriate requirements for ref.
-func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) PolicyRequirements {
+func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) (PolicyRequirements, error) {
 	// Do we have a PolicyTransportScopes for this transport?
 	transportName := ref.Transport().Name()
 	if transportScopes, ok := pc.Policy.Transports[transportName]; ok {
@@ -141,26 +214,38 @@ func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) Polic
 		identity := ref.PolicyConfigurationIdentity()
 		if req, ok := transportScopes[identity]; ok {
 			logrus.Debugf(` Using transport "%s" policy section %s`, transportName, identity)
-			return req
+			return req, nil
 		}
 
-		// Look for a match of the possible parent namespaces.
+		// Look for a match of the possible parent namespaces, from the most specific one.
+		// For each namespace, a literal scope takes precedence over glob patterns.
 		for _, name := range ref.PolicyConfigurationNamespaces() {
 			if req, ok := transportScopes[name]; ok {
 				logrus.Debugf(` Using transport "%s" specific policy section %s`, transportName, name)
-				return req
+				return req, nil
+			}
+			if strings.HasPrefix(name, "*.") { // A wildcarded subdomain expression, which is only matched literally.
+				continue
+			}
+			scope, err := matchGlobScopes(transportName, transportScopes, name)
+			if err != nil {
+				return nil, err
+			}
+			if scope != "" {
+				logrus.Debugf(` Using transport "%s" policy section %s, a pattern matching %s`, transportName, scope, name)
+				return transportScopes[scope], nil
 			}
 		}
 
 		// Look for a default match for the transport.
 		if req, ok := transportScopes[""]; ok {
 			logrus.Debugf(` Using transport "%s" policy section ""`, transportName)
-			return req
+			return req, nil
 		}
 	}
 
 	logrus.Debugf(" Using default policy section")
-	return pc.Policy.Default
+	return pc.Policy.Default, nil
 }
 
 // GetSignaturesWithAcceptedAuthor returns those signatures from an image
@@ -190,7 +275,10 @@ func (pc *PolicyContext) GetSignaturesWithAcceptedAuthor(ctx context.Context, pu
 	image := unparsedimage.FromPublic(publicImage)
 
 	logrus.Debugf("GetSignaturesWithAcceptedAuthor for image %s", policyIdentityLogName(image.Reference()))
-	reqs := pc.requirementsForImageRef(image.Reference())
+	reqs, err := pc.requirementsForImageRef(image.Reference())
+	if err != nil {
+		return nil, err
+	}
 
 	// FIXME: Use image.UntrustedSignatures, use that to improve error messages (needs tests!)
 	unverifiedSignatures, err := image.Signatures(ctx)
@@ -272,7 +360,10 @@ func (pc *PolicyContext) IsRunningImageAllowed(ctx context.Context, publicImage
 	image := unparsedimage.FromPublic(publicImage)
 
 	logrus.Debugf("IsRunningImageAllowed for image %s", policyIdentityLogName(image.Reference()))
-	reqs := pc.requirementsForImageRef(image.Reference())
+	reqs, err := pc.requirementsForImageRef(image.Reference())
+	if err != nil {
+		return false, err
+	}
 
 	if len(reqs) == 0 {
 		return false, PolicyRequirementError("List of verification policy requirements must not be empty")
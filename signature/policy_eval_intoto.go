@@ -0,0 +1,122 @@
+// Policy evaluation for prInTotoAttested.
+
+package signature
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/internal"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+)
+
+const (
+	// inTotoAttestationArtifactType is the artifact type of OCI referrers containing in-toto attestations.
+	inTotoAttestationArtifactType = "application/vnd.in-toto+json"
+	// dsseEnvelopeMediaType is the media type of layers of in-toto attestation referrers; each contains a DSSE envelope.
+	dsseEnvelopeMediaType = "application/vnd.dsse.envelope.v1+json"
+)
+
+func (pr *prInTotoAttested) isSignatureAuthorAccepted(ctx context.Context, image private.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	return sarUnknown, nil, nil
+}
+
+// publicKey returns the trusted public key specified by pr.
+func (pr *prInTotoAttested) publicKey() (crypto.PublicKey, error) {
+	if pr.KeyPath != "" && pr.KeyData != nil {
+		return nil, errors.New(`Internal inconsistency: both "keyPath" and "keyData" specified`)
+	}
+	// FIXME: move this to per-context initialization
+	var publicKeyPEM []byte
+	if pr.KeyData != nil {
+		publicKeyPEM = pr.KeyData
+	} else {
+		d, err := os.ReadFile(pr.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		publicKeyPEM = d
+	}
+	publicKey, err := cryptoutils.UnmarshalPEMToPublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	return publicKey, nil
+}
+
+// isEnvelopeAccepted returns nil if envelope, a DSSE envelope, contains an in-toto statement about image signed by publicKey,
+// which satisfies the other requirements of pr.
+func (pr *prInTotoAttested) isEnvelopeAccepted(ctx context.Context, image private.UnparsedImage, publicKey crypto.PublicKey, envelope []byte) error {
+	_, err := internal.VerifyInTotoEnvelope(publicKey, envelope, internal.InTotoAcceptanceRules{
+		ValidateSubjectDigests: func(digests []digest.Digest) error {
+			m, _, err := image.Manifest(ctx)
+			if err != nil {
+				return err
+			}
+			for _, d := range digests {
+				digestMatches, err := manifest.MatchesDigest(m, d)
+				if err != nil {
+					return err
+				}
+				if digestMatches {
+					return nil
+				}
+			}
+			return PolicyRequirementError("Attestation subjects do not include the image digest")
+		},
+		ValidatePredicateType: func(predicateType string) error {
+			if pr.PredicateType != "" && predicateType != pr.PredicateType {
+				return PolicyRequirementError(fmt.Sprintf("Attestation predicate type %q is not accepted", predicateType))
+			}
+			return nil
+		},
+	})
+	return err
+}
+
+func (pr *prInTotoAttested) isRunningImageAllowed(ctx context.Context, image private.UnparsedImage) (bool, error) {
+	publicKey, err := pr.publicKey()
+	if err != nil {
+		return false, err
+	}
+	referrers, err := image.UntrustedReferrers(ctx, inTotoAttestationArtifactType)
+	if err != nil {
+		return false, err
+	}
+	var rejections []error
+	for _, referrer := range referrers {
+		for i, layer := range referrer.Manifest.Layers {
+			if layer.MediaType != dsseEnvelopeMediaType || i >= len(referrer.Layers) {
+				continue
+			}
+			if err := pr.isEnvelopeAccepted(ctx, image, publicKey, referrer.Layers[i]); err != nil {
+				rejections = append(rejections, err)
+				continue
+			}
+			// One accepted attestation is enough.
+			return true, nil
+		}
+	}
+	var summary error
+	switch len(rejections) {
+	case 0:
+		summary = PolicyRequirementError("An in-toto attestation was required, but no attestation exists")
+	case 1:
+		summary = rejections[0]
+	default:
+		var msgs []string
+		for _, e := range rejections {
+			msgs = append(msgs, e.Error())
+		}
+		summary = PolicyRequirementError(fmt.Sprintf("None of the in-toto attestations were accepted, reasons: %s",
+			strings.Join(msgs, "; ")))
+	}
+	return false, summary
+}
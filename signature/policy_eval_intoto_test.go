@@ -0,0 +1,242 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/internal/image"
+	"github.com/containers/image/v5/internal/imagesource"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/internal"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	sigstoreSignature "github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/require"
+)
+
+const testInTotoPredicateType = "https://slsa.dev/provenance/v0.2"
+
+// referrersImageSourceMock is a dir: image source which also returns referrers, stored in memory.
+type referrersImageSourceMock struct {
+	private.ImageSource
+	referrers []imgspecv1.Descriptor
+	manifests map[digest.Digest][]byte
+	blobs     map[digest.Digest][]byte
+}
+
+func (s *referrersImageSourceMock) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	res := []imgspecv1.Descriptor{}
+	for _, desc := range s.referrers {
+		if desc.ArtifactType == artifactType {
+			res = append(res, desc)
+		}
+	}
+	return res, nil
+}
+
+func (s *referrersImageSourceMock) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		if m, ok := s.manifests[*instanceDigest]; ok {
+			return m, imgspecv1.MediaTypeImageManifest, nil
+		}
+	}
+	return s.ImageSource.GetManifest(ctx, instanceDigest)
+}
+
+func (s *referrersImageSourceMock) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if blob, ok := s.blobs[info.Digest]; ok {
+		return io.NopCloser(bytes.NewReader(blob)), int64(len(blob)), nil
+	}
+	return s.ImageSource.GetBlob(ctx, info, cache)
+}
+
+// addAttestation adds a referrer with artifactType, containing a DSSE envelope with statement signed by signer, to s.
+func (s *referrersImageSourceMock) addAttestation(t *testing.T, artifactType string, signer sigstoreSignature.Signer, statement interface{}) {
+	payload, err := json.Marshal(statement)
+	require.NoError(t, err)
+	sig, err := signer.SignMessage(bytes.NewReader(internal.DSSEPreAuthEncoding(internal.InTotoPayloadType, payload)))
+	require.NoError(t, err)
+	envelope, err := json.Marshal(internal.DSSEEnvelope{
+		PayloadType: internal.InTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []internal.DSSESignature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	require.NoError(t, err)
+	envelopeDigest := digest.FromBytes(envelope)
+	s.blobs[envelopeDigest] = envelope
+
+	m, err := json.Marshal(imgspecv1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config: imgspecv1.Descriptor{
+			MediaType: artifactType,
+			Digest:    digest.FromString("{}"),
+			Size:      2,
+		},
+		Layers: []imgspecv1.Descriptor{{
+			MediaType: dsseEnvelopeMediaType,
+			Digest:    envelopeDigest,
+			Size:      int64(len(envelope)),
+		}},
+	})
+	require.NoError(t, err)
+	manifestDigest := digest.FromBytes(m)
+	s.manifests[manifestDigest] = m
+	s.referrers = append(s.referrers, imgspecv1.Descriptor{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Digest:       manifestDigest,
+		Size:         int64(len(m)),
+	})
+}
+
+// referrersImageMock returns a private.UnparsedImage for dir, with referrers in the returned source.
+func referrersImageMock(t *testing.T, dir string) (private.UnparsedImage, *referrersImageSourceMock) {
+	ref, err := directory.NewReference(dir)
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		err := src.Close()
+		require.NoError(t, err)
+	})
+	mock := &referrersImageSourceMock{
+		ImageSource: imagesource.FromPublic(src),
+		manifests:   map[digest.Digest][]byte{},
+		blobs:       map[digest.Digest][]byte{},
+	}
+	return image.UnparsedInstance(mock, nil), mock
+}
+
+// inTotoStatement returns an in-toto statement about subjectDigest with predicateType.
+func inTotoStatement(subjectDigest digest.Digest, predicateType string) map[string]interface{} {
+	return map[string]interface{}{
+		"_type": "https://in-toto.io/Statement/v0.1",
+		"subject": []map[string]interface{}{{
+			"name":   "testing/manifest",
+			"digest": map[string]string{subjectDigest.Algorithm().String(): subjectDigest.Encoded()},
+		}},
+		"predicateType": predicateType,
+		"predicate":     map[string]interface{}{"builder": map[string]string{"id": "https://example.com/builder"}},
+	}
+}
+
+// newTestInTotoKey returns a signer using a new key, and the PEM-encoded public key.
+func newTestInTotoKey(t *testing.T) (sigstoreSignature.Signer, []byte) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := sigstoreSignature.LoadSigner(privateKey, crypto.SHA256)
+	require.NoError(t, err)
+	publicKeyPEM, err := cryptoutils.MarshalPublicKeyToPEM(privateKey.Public())
+	require.NoError(t, err)
+	return signer, publicKeyPEM
+}
+
+func TestPRInTotoAttestedIsSignatureAuthorAccepted(t *testing.T) {
+	// Signatures are not relevant for this requirement.
+	pr, err := NewPRInTotoAttestedKeyData([]byte("abc"), "")
+	require.NoError(t, err)
+	image := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	sig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), image, sig)
+	assertSARUnknown(t, sar, parsedSig, err)
+}
+
+func TestPRInTotoAttestedIsRunningImageAllowed(t *testing.T) {
+	signer, publicKeyPEM := newTestInTotoKey(t)
+	otherSigner, _ := newTestInTotoKey(t)
+	manifestBlob, err := os.ReadFile("fixtures/dir-img-unsigned/manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+
+	// A valid provenance attestation
+	image, src := referrersImageMock(t, "fixtures/dir-img-unsigned")
+	src.addAttestation(t, inTotoAttestationArtifactType, signer, inTotoStatement(manifestDigest, testInTotoPredicateType))
+	for _, predicateType := range []string{"", testInTotoPredicateType} {
+		pr, err := NewPRInTotoAttestedKeyData(publicKeyPEM, predicateType)
+		require.NoError(t, err)
+		allowed, err := pr.isRunningImageAllowed(context.Background(), image)
+		assertRunningAllowed(t, allowed, err)
+	}
+	// The same, reading the key from a file
+	keyPath := t.TempDir() + "/key.pub"
+	err = os.WriteFile(keyPath, publicKeyPEM, 0o600)
+	require.NoError(t, err)
+	pr, err := NewPRInTotoAttestedKeyPath(keyPath, testInTotoPredicateType)
+	require.NoError(t, err)
+	allowed, err := pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// An invalid attestation does not prevent accepting another, valid, one
+	image, src = referrersImageMock(t, "fixtures/dir-img-unsigned")
+	src.addAttestation(t, inTotoAttestationArtifactType, otherSigner, inTotoStatement(manifestDigest, testInTotoPredicateType))
+	src.addAttestation(t, inTotoAttestationArtifactType, signer, inTotoStatement(manifestDigest, testInTotoPredicateType))
+	pr, err = NewPRInTotoAttestedKeyData(publicKeyPEM, testInTotoPredicateType)
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+
+	// No attestation
+	image, src = referrersImageMock(t, "fixtures/dir-img-unsigned")
+	pr, err = NewPRInTotoAttestedKeyData(publicKeyPEM, "")
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// A source which does not support referrers
+	image = dirImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Only a referrer of a different artifact type
+	image, src = referrersImageMock(t, "fixtures/dir-img-unsigned")
+	src.addAttestation(t, "application/vnd.example.other", signer, inTotoStatement(manifestDigest, testInTotoPredicateType))
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	for _, c := range []struct {
+		signer        sigstoreSignature.Signer
+		statement     map[string]interface{}
+		predicateType string
+	}{
+		// Signed by an untrusted key
+		{otherSigner, inTotoStatement(manifestDigest, testInTotoPredicateType), ""},
+		// A statement about a different image
+		{signer, inTotoStatement(digest.FromString("another image"), testInTotoPredicateType), ""},
+		// A different predicate type
+		{signer, inTotoStatement(manifestDigest, "https://example.com/other-predicate"), testInTotoPredicateType},
+		// Not an in-toto statement
+		{signer, map[string]interface{}{"_type": "https://example.com/not-in-toto"}, ""},
+	} {
+		image, src = referrersImageMock(t, "fixtures/dir-img-unsigned")
+		src.addAttestation(t, inTotoAttestationArtifactType, c.signer, c.statement)
+		pr, err := NewPRInTotoAttestedKeyData(publicKeyPEM, c.predicateType)
+		require.NoError(t, err)
+		allowed, err := pr.isRunningImageAllowed(context.Background(), image)
+		assertRunningRejected(t, allowed, err)
+	}
+
+	// An invalid key
+	image, src = referrersImageMock(t, "fixtures/dir-img-unsigned")
+	src.addAttestation(t, inTotoAttestationArtifactType, signer, inTotoStatement(manifestDigest, testInTotoPredicateType))
+	pr, err = NewPRInTotoAttestedKeyData([]byte("this is not a key"), "")
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
+	assertRunningRejected(t, allowed, err)
+}
@@ -124,7 +124,8 @@ func TestPolicyContextRequirementsForImageRefNotRegisteredTransport(t *testing.T
 	require.NoError(t, err)
 	ref, err := reference.ParseNormalizedNamed("registry.access.redhat.com/rhel7:latest")
 	require.NoError(t, err)
-	reqs := pc.requirementsForImageRef(pcImageReferenceMock{transportName: "docker", ref: ref})
+	reqs, err := pc.requirementsForImageRef(pcImageReferenceMock{transportName: "docker", ref: ref})
+	require.NoError(t, err)
 	assert.True(t, &(reqs[0]) == &(pr[0]))
 	assert.True(t, len(reqs) == len(pr))
 
@@ -193,7 +194,8 @@ func TestPolicyContextRequirementsForImageRef(t *testing.T) {
 
 		ref, err := reference.ParseNormalizedNamed(c.input)
 		require.NoError(t, err)
-		reqs := pc.requirementsForImageRef(pcImageReferenceMock{transportName: c.inputTransport, ref: ref})
+		reqs, err := pc.requirementsForImageRef(pcImageReferenceMock{transportName: c.inputTransport, ref: ref})
+		require.NoError(t, err)
 		comment := fmt.Sprintf("case %s:%s: %#v", c.inputTransport, c.input, reqs[0])
 		// Do not use assert.Equal, which would do a deep contents comparison; we want to compare
 		// the pointers. Also, == does not work on slices; so test that the slices start at the
@@ -203,6 +205,123 @@ func TestPolicyContextRequirementsForImageRef(t *testing.T) {
 	}
 }
 
+func TestPolicyContextRequirementsForImageRefGlobScopes(t *testing.T) {
+	ktGPG := SBKeyTypeGPGKeys
+	prm := NewPRMMatchRepoDigestOrExact()
+
+	policy := &Policy{
+		Default:    PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{"docker": {}},
+	}
+	for _, scope := range []string{
+		"",
+		"quay.io",
+		"quay.io/org",
+		"quay.io/org/*-prod",
+		"quay.io/org/app-prod",
+		"quay.io/org/app-prod:v1",
+		"quay.io/org/*",
+		"quay.io/*/*-prod",
+		"quay.io/org/app-*:v*",
+		"quay.io/org/app-*-prod",
+		"quay.io/org/svc-*-prod",
+		"quay.io/org/*-svc-prod",
+		"*.example.com",
+		"registry*.example.com",
+		"quay.io/[ab]team/*",
+	} {
+		policy.Transports["docker"][scope] = PolicyRequirements{xNewPRSignedByKeyData(ktGPG, []byte(scope), prm)}
+	}
+
+	pc, err := NewPolicyContext(policy)
+	require.NoError(t, err)
+
+	for _, c := range []struct{ input, matched string }{
+		// An exact identity match wins over any pattern
+		{"quay.io/org/app-prod:v1", "quay.io/org/app-prod:v1"},
+		// Patterns are not matched against the tag
+		{"quay.io/org/app-prod:v2", "quay.io/org/app-prod"},
+		// An exact repository match wins over patterns matching the same repository
+		{"quay.io/org/app-prod:latest", "quay.io/org/app-prod"},
+		// The most specific of the patterns matching the repository wins
+		{"quay.io/org/web-prod:latest", "quay.io/org/*-prod"},
+		{"quay.io/org/app-web-prod:latest", "quay.io/org/app-*-prod"},
+		{"quay.io/org/web:latest", "quay.io/org/*"},
+		{"quay.io/other/web-prod:latest", "quay.io/*/*-prod"},
+		{"quay.io/ateam/web:latest", "quay.io/[ab]team/*"},
+		// * does not match across path components, so only less specific namespaces match
+		{"quay.io/org/nested/web-prod:latest", "quay.io/org/*"},
+		{"quay.io/ateam/nested/web:latest", "quay.io/[ab]team/*"},
+		{"quay.io/cteam/nested/web:latest", "quay.io"},
+		{"quay.io/other/web:latest", "quay.io"},
+		// Host name patterns; "*.example.com" is a literal wildcarded subdomain scope, which matches after the host name
+		{"registry1.example.com/repo:latest", "registry*.example.com"},
+		{"other.example.com/repo:latest", "*.example.com"},
+		// Default
+		{"docker.io/library/busybox:latest", ""},
+	} {
+		expected, ok := policy.Transports["docker"][c.matched]
+		require.True(t, ok, c.input)
+		ref, err := reference.ParseNormalizedNamed(c.input)
+		require.NoError(t, err)
+		reqs, err := pc.requirementsForImageRef(pcImageReferenceMock{transportName: "docker", ref: ref})
+		require.NoError(t, err, c.input)
+		comment := fmt.Sprintf("case %s: %#v", c.input, reqs[0])
+		assert.True(t, &(reqs[0]) == &(expected[0]), comment)
+		assert.True(t, len(reqs) == len(expected), comment)
+	}
+
+	// Two patterns of the same specificity matching the same name are ambiguous
+	for _, input := range []string{
+		"quay.io/org/svc-svc-prod:latest", // "quay.io/org/svc-*-prod" and "quay.io/org/*-svc-prod"
+	} {
+		ref, err := reference.ParseNormalizedNamed(input)
+		require.NoError(t, err)
+		_, err = pc.requirementsForImageRef(pcImageReferenceMock{transportName: "docker", ref: ref})
+		assert.ErrorContains(t, err, "Ambiguous policy", input)
+		allowed, err := pc.IsRunningImageAllowed(context.Background(), pcImageMock(t, "fixtures/dir-img-valid", input))
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+}
+
+func TestPolicyContextRequirementsForImageRefLiteralScopes(t *testing.T) {
+	// Scopes of transports other than docker and atomic are not glob patterns.
+	policy := &Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"dir": {"example.com/images/*": PolicyRequirements{NewPRInsecureAcceptAnything()}},
+		},
+	}
+	pc, err := NewPolicyContext(policy)
+	require.NoError(t, err)
+	ref, err := reference.ParseNormalizedNamed("example.com/images/busybox:latest")
+	require.NoError(t, err)
+	reqs, err := pc.requirementsForImageRef(pcImageReferenceMock{transportName: "dir", ref: ref})
+	require.NoError(t, err)
+	assert.Equal(t, policy.Default, reqs)
+	assert.False(t, isGlobScope("dir", "example.com/images/*"))
+	assert.True(t, isGlobScope("docker", "quay.io/*"))
+	assert.True(t, isGlobScope("atomic", "quay.io/*"))
+}
+
+func TestGlobScopeSpecificity(t *testing.T) {
+	for _, c := range []struct {
+		scope    string
+		expected int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"a*c", 2},
+		{"a?c", 2},
+		{"a[bc]d", 2},
+		{"a[^x]d", 2},
+		{`a\*b`, 3},
+		{"*", 0},
+	} {
+		assert.Equal(t, c.expected, globScopeSpecificity(c.scope), c.scope)
+	}
+}
+
 // pcImageMock returns a private.UnparsedImage for a directory, claiming a specified dockerReference and implementing PolicyConfigurationIdentity/PolicyConfigurationNamespaces.
 func pcImageMock(t *testing.T, dir, dockerReference string) private.UnparsedImage {
 	ref, err := reference.ParseNormalizedNamed(dockerReference)
@@ -47,6 +47,7 @@ const (
 	prTypeSignedBy               prTypeIdentifier = "signedBy"
 	prTypeSignedBaseLayer        prTypeIdentifier = "signedBaseLayer"
 	prTypeSigstoreSigned         prTypeIdentifier = "sigstoreSigned"
+	prTypeInTotoAttested         prTypeIdentifier = "inTotoAttested"
 )
 
 // prInsecureAcceptAnything is a PolicyRequirement with type = prTypeInsecureAcceptAnything:
@@ -125,6 +126,20 @@ type prSigstoreSigned struct {
 	SignedIdentity PolicyReferenceMatch `json:"signedIdentity"`
 }
 
+// prInTotoAttested is a PolicyRequirement with type = prTypeInTotoAttested: the image has an in-toto attestation,
+// stored as an OCI referrer of the image, which is signed by a trusted key.
+type prInTotoAttested struct {
+	prCommon
+
+	// KeyPath is a pathname to a local file containing the trusted key. Exactly one of KeyPath and KeyData must be specified.
+	KeyPath string `json:"keyPath,omitempty"`
+	// KeyData contains the trusted key, base64-encoded. Exactly one of KeyPath and KeyData must be specified.
+	KeyData []byte `json:"keyData,omitempty"`
+
+	// PredicateType, if not empty, is the predicate type the attestation must have, e.g. "https://slsa.dev/provenance/v0.2".
+	PredicateType string `json:"predicateType,omitempty"`
+}
+
 // PolicyReferenceMatch specifies a set of image identities accepted in PolicyRequirement.
 // The type is public, but its implementation is private.
 
@@ -0,0 +1,238 @@
+// Note: Consider the API unstable until the code supports at least three different image formats or transports.
+
+package signature
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	internalsig "github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/internal"
+	"github.com/opencontainers/go-digest"
+)
+
+// SignerFormat identifies the kind of signatures created by a Signer.
+type SignerFormat string
+
+const (
+	// SimpleSigningSignerFormat indicates that Signer.Sign returns an OpenPGP message containing the payload,
+	// i.e. the same data as SigningMechanism.Sign.
+	SimpleSigningSignerFormat SignerFormat = "simple-signing"
+	// SigstoreSignerFormat indicates that Signer.Sign returns a raw signature of the payload, verifiable
+	// using the corresponding public key, as used by sigstore/cosign.
+	SigstoreSignerFormat SignerFormat = "sigstore"
+)
+
+// Signer is a signing backend, e.g. a wrapper around a key stored in a HSM, a cloud KMS, or a local GPG keyring.
+// The caller is responsible for creating the payload to sign; a Signer only needs to create a signature of it.
+type Signer interface {
+	// Format returns the kind of signatures created by Sign.
+	Format() SignerFormat
+	// KeyIdentity returns a human-readable identification of the signing key (e.g. a fingerprint or a key URI), for progress and error messages.
+	KeyIdentity() string
+	// Sign returns a signature of payload, in the format indicated by Format.
+	Sign(payload []byte) ([]byte, error)
+}
+
+// VerifyingSigner is a Signer which can also check whether a signature was created by its key.
+// This is used e.g. to avoid redundantly signing an image which already carries a signature by the same key.
+type VerifyingSigner interface {
+	Signer
+	// Verify checks that unverifiedSignature, in the format indicated by Format, was created by the signing key, and returns the signed payload.
+	// For SimpleSigningSignerFormat, unverifiedSignature is an OpenPGP message containing the payload, and unverifiedPayload is ignored;
+	// for SigstoreSignerFormat, unverifiedSignature is a raw signature of unverifiedPayload.
+	Verify(unverifiedSignature, unverifiedPayload []byte) ([]byte, error)
+}
+
+// gpgSigner is a Signer using a SigningMechanism.
+type gpgSigner struct {
+	mech        SigningMechanism
+	keyIdentity string
+	passphrase  string
+}
+
+// NewGPGSigner returns a Signer creating simple signing signatures using mech, keyIdentity, and passphrase, if not "".
+// The caller is responsible for closing mech after it is done using the Signer.
+func NewGPGSigner(mech SigningMechanism, keyIdentity string, passphrase string) (Signer, error) {
+	// The gpgme implementation can’t use passphrase with \n; reject it here for consistent behavior.
+	if strings.Contains(passphrase, "\n") {
+		return nil, errors.New("invalid passphrase: must not contain a line break")
+	}
+	return &gpgSigner{
+		mech:        mech,
+		keyIdentity: keyIdentity,
+		passphrase:  passphrase,
+	}, nil
+}
+
+// Format returns the kind of signatures created by Sign.
+func (s *gpgSigner) Format() SignerFormat {
+	return SimpleSigningSignerFormat
+}
+
+// KeyIdentity returns a human-readable identification of the signing key.
+func (s *gpgSigner) KeyIdentity() string {
+	return s.keyIdentity
+}
+
+// Sign returns an OpenPGP message containing payload, signed by the key.
+func (s *gpgSigner) Sign(payload []byte) ([]byte, error) {
+	if newMech, ok := s.mech.(signingMechanismWithPassphrase); ok {
+		return newMech.SignWithPassphrase(payload, s.keyIdentity, s.passphrase)
+	}
+	if s.passphrase != "" {
+		return nil, errors.New("signing mechanism does not support passphrases")
+	}
+	return s.mech.Sign(payload, s.keyIdentity)
+}
+
+// keyID returns s.keyIdentity as an upper-case fingerprint or key ID, or an error if it is neither (e.g. an e-mail address).
+func (s *gpgSigner) keyID() (string, error) {
+	keyID := strings.ToUpper(strings.TrimPrefix(s.keyIdentity, "0x"))
+	if len(keyID) < 8 || strings.Trim(keyID, "0123456789ABCDEF") != "" {
+		return "", fmt.Errorf("key identity %q is not a fingerprint or a key ID, can’t check signatures created by it", s.keyIdentity)
+	}
+	return keyID, nil
+}
+
+// Verify checks that unverifiedSignature is an OpenPGP message signed by the key, and returns its contents.
+// This requires s.keyIdentity to be a fingerprint or a key ID.
+func (s *gpgSigner) Verify(unverifiedSignature, unverifiedPayload []byte) ([]byte, error) {
+	keyID, err := s.keyID()
+	if err != nil {
+		return nil, err
+	}
+	contents, signedBy, err := s.mech.Verify(unverifiedSignature)
+	if err != nil {
+		return nil, err
+	}
+	// A key ID is a suffix of the fingerprint.
+	if !strings.HasSuffix(strings.ToUpper(signedBy), keyID) {
+		return nil, fmt.Errorf("signature by key %s, not %s", signedBy, s.keyIdentity)
+	}
+	return contents, nil
+}
+
+// SignDockerManifestWithSignerUnstable returns a signature for manifest as the specified dockerReference, using signer.
+//
+// Yes, this returns an internal type, and should currently not be used outside of c/image.
+// There is NO COMITTMENT TO STABLE API.
+func SignDockerManifestWithSignerUnstable(m []byte, dockerReference reference.Named, signer Signer) (internalsig.Signature, error) {
+	if reference.IsNameOnly(dockerReference) {
+		return nil, fmt.Errorf("reference %s can’t be signed, it has neither a tag nor a digest", dockerReference.String())
+	}
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format := signer.Format(); format {
+	case SimpleSigningSignerFormat:
+		payload, err := json.Marshal(newUntrustedSignature(manifestDigest, dockerReference.String()))
+		if err != nil {
+			return nil, err
+		}
+		sig, err := signer.Sign(payload)
+		if err != nil {
+			return nil, fmt.Errorf("signing using %s: %w", signer.KeyIdentity(), err)
+		}
+		return internalsig.SimpleSigningFromBlob(sig), nil
+
+	case SigstoreSignerFormat:
+		// sigstore/cosign completely ignores dockerReference for actual policy decisions.
+		// They record the repo (but NOT THE TAG) in the value; without the tag we can’t detect version rollbacks.
+		// So, just do what simple signing does, and cosign won’t mind.
+		payload, err := json.Marshal(internal.NewUntrustedSigstorePayload(manifestDigest, dockerReference.String()))
+		if err != nil {
+			return nil, err
+		}
+		sig, err := signer.Sign(payload)
+		if err != nil {
+			return nil, fmt.Errorf("signing using %s: %w", signer.KeyIdentity(), err)
+		}
+		return internalsig.SigstoreFromComponents(internalsig.SigstoreSignatureMIMEType, payload,
+			map[string]string{
+				internalsig.SigstoreSignatureAnnotationKey: base64.StdEncoding.EncodeToString(sig),
+			}), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported signer format %q", format)
+	}
+}
+
+// DockerManifestSignedBySignerUnstable returns true if sig is a valid signature of manifest as the specified dockerReference,
+// created by signer. It returns false if sig is in a different format, or was created by a different key, or for a different
+// manifest or reference. Fails if signer does not implement VerifyingSigner.
+//
+// Yes, this uses an internal type, and should currently not be used outside of c/image.
+// There is NO COMITTMENT TO STABLE API.
+func DockerManifestSignedBySignerUnstable(sig internalsig.Signature, m []byte, dockerReference reference.Named, signer Signer) (bool, error) {
+	verifier, ok := signer.(VerifyingSigner)
+	if !ok {
+		return false, fmt.Errorf("signer %s does not support verifying signatures", signer.KeyIdentity())
+	}
+	if gpgSigner, ok := signer.(*gpgSigner); ok {
+		// Verify failures are treated as signatures by other keys below; report an unusable key identity instead of never finding a match.
+		if _, err := gpgSigner.keyID(); err != nil {
+			return false, err
+		}
+	}
+
+	var manifestDigest digest.Digest
+	var signedReference string
+	switch sig := sig.(type) {
+	case internalsig.SimpleSigning:
+		if signer.Format() != SimpleSigningSignerFormat {
+			return false, nil
+		}
+		contents, err := verifier.Verify(sig.UntrustedSignature(), nil)
+		if err != nil {
+			return false, nil
+		}
+		var payload untrustedSignature
+		if err := json.Unmarshal(contents, &payload); err != nil {
+			return false, nil
+		}
+		manifestDigest, signedReference = payload.UntrustedDockerManifestDigest, payload.UntrustedDockerReference
+
+	case internalsig.Sigstore:
+		if signer.Format() != SigstoreSignerFormat || sig.UntrustedMIMEType() != internalsig.SigstoreSignatureMIMEType {
+			return false, nil
+		}
+		base64Signature, ok := sig.UntrustedAnnotations()[internalsig.SigstoreSignatureAnnotationKey]
+		if !ok {
+			return false, nil
+		}
+		rawSignature, err := base64.StdEncoding.DecodeString(base64Signature)
+		if err != nil {
+			return false, nil
+		}
+		contents, err := verifier.Verify(rawSignature, sig.UntrustedPayload())
+		if err != nil {
+			return false, nil
+		}
+		var payload internal.UntrustedSigstorePayload
+		if err := json.Unmarshal(contents, &payload); err != nil {
+			return false, nil
+		}
+		manifestDigest, signedReference = payload.UntrustedDockerManifestDigest, payload.UntrustedDockerReference
+
+	default:
+		return false, nil
+	}
+
+	signedRef, err := reference.ParseNormalizedNamed(signedReference)
+	if err != nil || signedRef.String() != dockerReference.String() {
+		return false, nil
+	}
+	matches, err := manifest.MatchesDigest(m, manifestDigest)
+	if err != nil {
+		return false, err
+	}
+	return matches, nil
+}
@@ -0,0 +1,224 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/containers/image/v5/docker/reference"
+	internalsig "github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/internal"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySigner is a Signer using an in-memory ECDSA key, creating sigstore signatures.
+type memorySigner struct {
+	key     *ecdsa.PrivateKey
+	signErr error // If not nil, Sign fails with this error
+}
+
+func newMemorySigner(t *testing.T) *memorySigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &memorySigner{key: key}
+}
+
+func (s *memorySigner) Format() SignerFormat {
+	return SigstoreSignerFormat
+}
+
+func (s *memorySigner) KeyIdentity() string {
+	return "memory"
+}
+
+func (s *memorySigner) Sign(payload []byte) ([]byte, error) {
+	if s.signErr != nil {
+		return nil, s.signErr
+	}
+	hash := sha256.Sum256(payload)
+	return ecdsa.SignASN1(rand.Reader, s.key, hash[:])
+}
+
+func (s *memorySigner) Verify(unverifiedSignature, unverifiedPayload []byte) ([]byte, error) {
+	hash := sha256.Sum256(unverifiedPayload)
+	if !ecdsa.VerifyASN1(&s.key.PublicKey, hash[:], unverifiedSignature) {
+		return nil, errors.New("invalid signature")
+	}
+	return unverifiedPayload, nil
+}
+
+func (s *memorySigner) publicKey() crypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+func TestNewGPGSigner(t *testing.T) {
+	mech, err := newGPGSigningMechanismInDirectory(testGPGHomeDirectory)
+	require.NoError(t, err)
+	defer mech.Close()
+
+	signer, err := NewGPGSigner(mech, TestKeyFingerprint, "")
+	require.NoError(t, err)
+	assert.Equal(t, SimpleSigningSignerFormat, signer.Format())
+	assert.Equal(t, TestKeyFingerprint, signer.KeyIdentity())
+
+	_, err = NewGPGSigner(mech, TestKeyFingerprint, "with\nnewline")
+	assert.Error(t, err)
+}
+
+func TestSignDockerManifestWithSignerUnstable(t *testing.T) {
+	manifestBlob, err := os.ReadFile("fixtures/image.manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+	ref, err := reference.ParseNormalizedNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+
+	// A simple signing signature, using GPG
+	mech, err := newGPGSigningMechanismInDirectory(testGPGHomeDirectory)
+	require.NoError(t, err)
+	defer mech.Close()
+	if err := mech.SupportsSigning(); err == nil {
+		gpgSigner, err := NewGPGSigner(mech, TestKeyFingerprint, "")
+		require.NoError(t, err)
+		sig, err := SignDockerManifestWithSignerUnstable(manifestBlob, ref, gpgSigner)
+		require.NoError(t, err)
+		simpleSig, ok := sig.(internalsig.SimpleSigning)
+		require.True(t, ok)
+		verified, err := VerifyDockerManifestSignature(simpleSig.UntrustedSignature(), manifestBlob, ref.String(), mech, TestKeyFingerprint)
+		require.NoError(t, err)
+		assert.Equal(t, ref.String(), verified.DockerReference)
+		assert.Equal(t, manifestDigest, verified.DockerManifestDigest)
+	}
+
+	// A sigstore signature, using a custom signing backend
+	memSigner := newMemorySigner(t)
+	sig, err := SignDockerManifestWithSignerUnstable(manifestBlob, ref, memSigner)
+	require.NoError(t, err)
+	sigstoreSig, ok := sig.(internalsig.Sigstore)
+	require.True(t, ok)
+	assert.Equal(t, internalsig.SigstoreSignatureMIMEType, sigstoreSig.UntrustedMIMEType())
+	payload, err := internal.VerifySigstorePayload(memSigner.publicKey(), sigstoreSig.UntrustedPayload(),
+		sigstoreSig.UntrustedAnnotations()[internalsig.SigstoreSignatureAnnotationKey], internal.SigstorePayloadAcceptanceRules{
+			ValidateSignedDockerReference: func(signedRef string) error {
+				if signedRef != ref.String() {
+					return errors.New("Unexpected signed reference")
+				}
+				return nil
+			},
+			ValidateSignedDockerManifestDigest: func(signedDigest digest.Digest) error {
+				if signedDigest != manifestDigest {
+					return errors.New("Unexpected signed manifest digest")
+				}
+				return nil
+			},
+		})
+	require.NoError(t, err)
+	assert.Equal(t, ref.String(), payload.UntrustedDockerReference)
+
+	// A reference without a tag or digest can’t be signed
+	nameOnly, err := reference.ParseNormalizedNamed("example.com/ns/repo")
+	require.NoError(t, err)
+	_, err = SignDockerManifestWithSignerUnstable(manifestBlob, nameOnly, memSigner)
+	assert.Error(t, err)
+
+	// Signing failures are reported
+	memSigner.signErr = errors.New("Expected signing failure")
+	_, err = SignDockerManifestWithSignerUnstable(manifestBlob, ref, memSigner)
+	assert.ErrorIs(t, err, memSigner.signErr)
+}
+
+func TestDockerManifestSignedBySignerUnstable(t *testing.T) {
+	manifestBlob, err := os.ReadFile("fixtures/image.manifest.json")
+	require.NoError(t, err)
+	otherManifestBlob := []byte("another manifest")
+	ref, err := reference.ParseNormalizedNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+	otherRef, err := reference.ParseNormalizedNamed("example.com/ns/repo:other")
+	require.NoError(t, err)
+
+	// Simple signing, using the fixture signature by TestKeyFingerprint
+	mech, err := newGPGSigningMechanismInDirectory(testGPGHomeDirectory)
+	require.NoError(t, err)
+	defer mech.Close()
+	simpleSigBlob, err := os.ReadFile("fixtures/image.signature")
+	require.NoError(t, err)
+	simpleSig := internalsig.SimpleSigningFromBlob(simpleSigBlob)
+	unknownKeySigBlob, err := os.ReadFile("fixtures/unknown-key.signature")
+	require.NoError(t, err)
+	signedRef, err := reference.ParseNormalizedNamed(TestImageSignatureReference)
+	require.NoError(t, err)
+	for _, keyIdentity := range []string{
+		TestKeyFingerprint,
+		TestKeyFingerprint[len(TestKeyFingerprint)-16:],       // Long key ID
+		"0x" + TestKeyFingerprint[len(TestKeyFingerprint)-8:], // Short key ID
+	} {
+		gpgSigner, err := NewGPGSigner(mech, keyIdentity, "")
+		require.NoError(t, err)
+		for _, c := range []struct {
+			sig           internalsig.Signature
+			manifest      []byte
+			ref           reference.Named
+			expectedMatch bool
+		}{
+			{simpleSig, manifestBlob, signedRef, true},
+			{simpleSig, otherManifestBlob, signedRef, false},
+			{simpleSig, manifestBlob, ref, false},
+			{internalsig.SimpleSigningFromBlob(unknownKeySigBlob), manifestBlob, signedRef, false},
+			{internalsig.SimpleSigningFromBlob([]byte("invalid")), manifestBlob, signedRef, false},
+			{internalsig.SigstoreFromComponents(internalsig.SigstoreSignatureMIMEType, []byte("payload"), nil), manifestBlob, signedRef, false},
+		} {
+			res, err := DockerManifestSignedBySignerUnstable(c.sig, c.manifest, c.ref, gpgSigner)
+			require.NoError(t, err, keyIdentity)
+			assert.Equal(t, c.expectedMatch, res, keyIdentity)
+		}
+	}
+	// A different key
+	gpgSigner, err := NewGPGSigner(mech, "0123456789ABCDEF", "")
+	require.NoError(t, err)
+	res, err := DockerManifestSignedBySignerUnstable(simpleSig, manifestBlob, signedRef, gpgSigner)
+	require.NoError(t, err)
+	assert.False(t, res)
+	// A key identity which is not a key ID can’t be checked
+	gpgSigner, err = NewGPGSigner(mech, "user@example.com", "")
+	require.NoError(t, err)
+	_, err = DockerManifestSignedBySignerUnstable(simpleSig, manifestBlob, signedRef, gpgSigner)
+	assert.Error(t, err)
+
+	// Sigstore, using a custom signing backend
+	memSigner := newMemorySigner(t)
+	sigstoreSig, err := SignDockerManifestWithSignerUnstable(manifestBlob, ref, memSigner)
+	require.NoError(t, err)
+	for _, c := range []struct {
+		sig           internalsig.Signature
+		manifest      []byte
+		ref           reference.Named
+		signer        Signer
+		expectedMatch bool
+	}{
+		{sigstoreSig, manifestBlob, ref, memSigner, true},
+		{sigstoreSig, otherManifestBlob, ref, memSigner, false},
+		{sigstoreSig, manifestBlob, otherRef, memSigner, false},
+		{sigstoreSig, manifestBlob, ref, newMemorySigner(t), false},
+		{simpleSig, manifestBlob, signedRef, memSigner, false},
+		{internalsig.SigstoreFromComponents(internalsig.SigstoreSignatureMIMEType, sigstoreSig.(internalsig.Sigstore).UntrustedPayload(), nil),
+			manifestBlob, ref, memSigner, false},
+		{internalsig.SigstoreFromComponents(internalsig.SigstoreSignatureMIMEType, []byte("modified payload"), sigstoreSig.(internalsig.Sigstore).UntrustedAnnotations()),
+			manifestBlob, ref, memSigner, false},
+	} {
+		res, err := DockerManifestSignedBySignerUnstable(c.sig, c.manifest, c.ref, c.signer)
+		require.NoError(t, err)
+		assert.Equal(t, c.expectedMatch, res)
+	}
+
+	// A Signer which does not support verification
+	_, err = DockerManifestSignedBySignerUnstable(sigstoreSig, manifestBlob, ref, struct{ Signer }{memSigner})
+	assert.Error(t, err)
+}
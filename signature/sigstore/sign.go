@@ -2,15 +2,12 @@ package sigstore
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"os"
 
 	"github.com/containers/image/v5/docker/reference"
-	"github.com/containers/image/v5/internal/signature"
-	"github.com/containers/image/v5/manifest"
-	"github.com/containers/image/v5/signature/internal"
+	internalsig "github.com/containers/image/v5/internal/signature"
+	"github.com/containers/image/v5/signature"
 	sigstoreSignature "github.com/sigstore/sigstore/pkg/signature"
 )
 
@@ -19,47 +16,66 @@ import (
 //
 // Yes, this returns an internal type, and should currently not be used outside of c/image.
 // There is NO COMITTMENT TO STABLE API.
-func SignDockerManifestWithPrivateKeyFileUnstable(m []byte, dockerReference reference.Named, privateKeyFile string, passphrase []byte) (signature.Sigstore, error) {
-	privateKeyPEM, err := os.ReadFile(privateKeyFile)
+func SignDockerManifestWithPrivateKeyFileUnstable(m []byte, dockerReference reference.Named, privateKeyFile string, passphrase []byte) (internalsig.Sigstore, error) {
+	signer, err := NewPrivateKeyFileSigner(privateKeyFile, passphrase)
 	if err != nil {
-		return signature.Sigstore{}, fmt.Errorf("reading private key from %s: %w", privateKeyFile, err)
+		return internalsig.Sigstore{}, err
 	}
-	signer, err := loadPrivateKey(privateKeyPEM, passphrase)
+	sig, err := signature.SignDockerManifestWithSignerUnstable(m, dockerReference, signer)
 	if err != nil {
-		return signature.Sigstore{}, fmt.Errorf("initializing private key: %w", err)
+		return internalsig.Sigstore{}, err
 	}
+	sigstoreSig, ok := sig.(internalsig.Sigstore)
+	if !ok { // Coverage: This should never happen, sigstoreSigner.Format always returns signature.SigstoreSignerFormat.
+		return internalsig.Sigstore{}, fmt.Errorf("Internal error: unexpected signature type %T", sig)
+	}
+	return sigstoreSig, nil
+}
 
-	return signDockerManifest(m, dockerReference, signer)
+// sigstoreSigner is a signature.Signer using a sigstore private key.
+type sigstoreSigner struct {
+	signer      sigstoreSignature.SignerVerifier
+	keyIdentity string
 }
 
-func signDockerManifest(m []byte, dockerReference reference.Named, signer sigstoreSignature.Signer) (signature.Sigstore, error) {
-	if reference.IsNameOnly(dockerReference) {
-		return signature.Sigstore{}, fmt.Errorf("reference %s can’t be signed, it has neither a tag nor a digest", dockerReference.String())
-	}
-	manifestDigest, err := manifest.Digest(m)
+// NewPrivateKeyFileSigner returns a signature.Signer creating sigstore signatures using a private key
+// in privateKeyFile and an optional passphrase.
+func NewPrivateKeyFileSigner(privateKeyFile string, passphrase []byte) (signature.Signer, error) {
+	privateKeyPEM, err := os.ReadFile(privateKeyFile)
 	if err != nil {
-		return signature.Sigstore{}, err
+		return nil, fmt.Errorf("reading private key from %s: %w", privateKeyFile, err)
 	}
-	// sigstore/cosign completely ignores dockerReference for actual policy decisions.
-	// They record the repo (but NOT THE TAG) in the value; without the tag we can’t detect version rollbacks.
-	// So, just do what simple signing does, and cosign won’t mind.
-	payloadData := internal.NewUntrustedSigstorePayload(manifestDigest, dockerReference.String())
-	payloadBytes, err := json.Marshal(payloadData)
+	signer, err := loadPrivateKey(privateKeyPEM, passphrase)
 	if err != nil {
-		return signature.Sigstore{}, err
+		return nil, fmt.Errorf("initializing private key: %w", err)
 	}
+	return &sigstoreSigner{
+		signer:      signer,
+		keyIdentity: privateKeyFile,
+	}, nil
+}
 
+// Format returns the kind of signatures created by Sign.
+func (s *sigstoreSigner) Format() signature.SignerFormat {
+	return signature.SigstoreSignerFormat
+}
+
+// KeyIdentity returns a human-readable identification of the signing key.
+func (s *sigstoreSigner) KeyIdentity() string {
+	return s.keyIdentity
+}
+
+// Sign returns a raw signature of payload.
+func (s *sigstoreSigner) Sign(payload []byte) ([]byte, error) {
 	// github.com/sigstore/cosign/internal/pkg/cosign.payloadSigner uses signatureoptions.WithContext(),
 	// which seems to be not used by anything. So we don’t bother.
-	signatureBytes, err := signer.SignMessage(bytes.NewReader(payloadBytes))
-	if err != nil {
-		return signature.Sigstore{}, fmt.Errorf("creating signature: %w", err)
-	}
-	base64Signature := base64.StdEncoding.EncodeToString(signatureBytes)
+	return s.signer.SignMessage(bytes.NewReader(payload))
+}
 
-	return signature.SigstoreFromComponents(signature.SigstoreSignatureMIMEType,
-		payloadBytes,
-		map[string]string{
-			signature.SigstoreSignatureAnnotationKey: base64Signature,
-		}), nil
+// Verify checks that unverifiedSignature is a raw signature of unverifiedPayload, created by the private key, and returns the payload.
+func (s *sigstoreSigner) Verify(unverifiedSignature, unverifiedPayload []byte) ([]byte, error) {
+	if err := s.signer.VerifySignature(bytes.NewReader(unverifiedSignature), bytes.NewReader(unverifiedPayload)); err != nil {
+		return nil, err
+	}
+	return unverifiedPayload, nil
 }
@@ -191,15 +191,8 @@ func (s untrustedSignature) sign(mech SigningMechanism, keyIdentity string, pass
 		return nil, err
 	}
 
-	if newMech, ok := mech.(signingMechanismWithPassphrase); ok {
-		return newMech.SignWithPassphrase(json, keyIdentity, passphrase)
-	}
-
-	if passphrase != "" {
-		return nil, errors.New("signing mechanism does not support passphrases")
-	}
-
-	return mech.Sign(json, keyIdentity)
+	signer := gpgSigner{mech: mech, keyIdentity: keyIdentity, passphrase: passphrase}
+	return signer.Sign(json)
 }
 
 // signatureAcceptanceRules specifies how to decide whether an untrusted signature is acceptable.
@@ -0,0 +1,527 @@
+//go:build !containers_image_storage_stub
+// +build !containers_image_storage_stub
+
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage"
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/pkg/ioutils"
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// zstdChunkedManifestChecksumKey and zstdChunkedManifestInfoKey are the layer annotations which contain the digest
+	// and the location of the table of contents of a zstd:chunked layer, as written by
+	// github.com/containers/storage/pkg/chunked/compressor.
+	zstdChunkedManifestChecksumKey = "io.containers.zstd-chunked.manifest-checksum"
+	zstdChunkedManifestInfoKey     = "io.containers.zstd-chunked.manifest-position"
+	// zstdChunkedManifestTypeCRFS is the only table of contents format we understand.
+	zstdChunkedManifestTypeCRFS = 1
+	// zstdChunkedMaxManifestSize is the largest table of contents, compressed or not, we are willing to read.
+	zstdChunkedMaxManifestSize = 50 * 1024 * 1024
+	// zstdChunkedManifestBigDataKey is the layer big data key containing the uncompressed table of contents of a layer
+	// created from a zstd:chunked blob; github.com/containers/storage/pkg/chunked uses the same key.
+	zstdChunkedManifestBigDataKey = "zstd-chunked-manifest"
+)
+
+// zstdChunkedTOC is the table of contents of a zstd:chunked layer.
+// Only the fields we use are included.
+type zstdChunkedTOC struct {
+	Version int                   `json:"version"`
+	Entries []zstdChunkedTOCEntry `json:"entries"`
+}
+
+// zstdChunkedTOCEntry is an entry of zstdChunkedTOC.
+type zstdChunkedTOCEntry struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size,omitempty"`
+	Digest string `json:"digest,omitempty"` // Of the uncompressed contents of a regular file
+	// Offset and EndOffset delimit the zstd frames containing the contents of a regular file in the blob.
+	Offset    int64 `json:"offset,omitempty"`
+	EndOffset int64 `json:"endOffset,omitempty"`
+}
+
+const (
+	zstdChunkedTypeReg   = "reg"
+	zstdChunkedTypeChunk = "chunk" // Describes a part of the preceding "reg" entry, not a separate tar entry
+)
+
+// hasReusableContents returns true if e is a regular file with contents we can look for in other layers.
+func (e *zstdChunkedTOCEntry) hasReusableContents() bool {
+	return e.Type == zstdChunkedTypeReg && e.Size > 0 && e.Digest != ""
+}
+
+// normalizedTarPath returns name, a path from a tar header or a table of contents, in a canonical form, so that
+// equivalent spellings can be compared.
+func normalizedTarPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// forEachBlobChunk fetches chunks of srcInfo using chunkAccessor, and calls fn with the contents of each of them, in order.
+func forEachBlobChunk(ctx context.Context, chunkAccessor private.BlobChunkAccessor, srcInfo types.BlobInfo, chunks []private.ImageSourceChunk,
+	fn func(i int, r io.Reader) error) error {
+	streams, errs, err := chunkAccessor.GetBlobAt(ctx, srcInfo, chunks)
+	if err != nil {
+		return err
+	}
+	defer func() { // Let the goroutine producing the streams terminate, even if we fail early.
+		for streams != nil || errs != nil {
+			select {
+			case stream, ok := <-streams:
+				if !ok {
+					streams = nil
+					continue
+				}
+				stream.Close()
+			case _, ok := <-errs:
+				if !ok {
+					errs = nil
+				}
+			}
+		}
+	}()
+
+	i := 0
+	for streams != nil {
+		select {
+		case stream, ok := <-streams:
+			if !ok {
+				streams = nil
+				continue
+			}
+			if i >= len(chunks) {
+				stream.Close()
+				return fmt.Errorf("received more than the %d requested chunks of blob %s", len(chunks), srcInfo.Digest)
+			}
+			// Read the stream to its end even if fn does not consume all of it, so that we can get the next one.
+			err := fn(i, stream)
+			if err == nil {
+				_, err = io.Copy(io.Discard, stream)
+			}
+			stream.Close()
+			if err != nil {
+				return err
+			}
+			i++
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if i != len(chunks) {
+		return fmt.Errorf("received %d of the %d requested chunks of blob %s", i, len(chunks), srcInfo.Digest)
+	}
+	return nil
+}
+
+// readZstdChunkedTOC reads the table of contents of the zstd:chunked layer srcInfo using chunkAccessor.
+// It returns the table of contents, both uncompressed and parsed, and the offset in the blob where it starts;
+// the blob data before that offset is the zstd-compressed tar stream of the layer.
+func readZstdChunkedTOC(ctx context.Context, chunkAccessor private.BlobChunkAccessor, srcInfo types.BlobInfo) ([]byte, *zstdChunkedTOC, uint64, error) {
+	checksumAnnotation, ok := srcInfo.Annotations[zstdChunkedManifestChecksumKey]
+	if !ok {
+		return nil, nil, 0, fmt.Errorf("blob %s is not a zstd:chunked layer: annotation %q not found", srcInfo.Digest, zstdChunkedManifestChecksumKey)
+	}
+	checksum, err := digest.Parse(checksumAnnotation)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid annotation %q: %w", zstdChunkedManifestChecksumKey, err)
+	}
+	infoAnnotation, ok := srcInfo.Annotations[zstdChunkedManifestInfoKey]
+	if !ok {
+		return nil, nil, 0, fmt.Errorf("annotation %q not found", zstdChunkedManifestInfoKey)
+	}
+	var offset, length, uncompressedLength, manifestType uint64
+	if _, err := fmt.Sscanf(infoAnnotation, "%d:%d:%d:%d", &offset, &length, &uncompressedLength, &manifestType); err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid annotation %q value %q: %w", zstdChunkedManifestInfoKey, infoAnnotation, err)
+	}
+	if manifestType != zstdChunkedManifestTypeCRFS {
+		return nil, nil, 0, fmt.Errorf("unsupported zstd:chunked table of contents type %d", manifestType)
+	}
+	if length > zstdChunkedMaxManifestSize || uncompressedLength > zstdChunkedMaxManifestSize {
+		return nil, nil, 0, fmt.Errorf("zstd:chunked table of contents of blob %s is too large", srcInfo.Digest)
+	}
+	// The table of contents is stored in a zstd skippable frame, after an 8-byte frame header.
+	if offset < 8 || (srcInfo.Size != -1 && offset+length > uint64(srcInfo.Size)) {
+		return nil, nil, 0, fmt.Errorf("invalid zstd:chunked table of contents position %q", infoAnnotation)
+	}
+
+	var compressed []byte
+	if err := forEachBlobChunk(ctx, chunkAccessor, srcInfo, []private.ImageSourceChunk{{Offset: offset, Length: length}}, func(_ int, r io.Reader) error {
+		verifier := checksum.Verifier()
+		data, err := io.ReadAll(io.TeeReader(io.LimitReader(r, int64(length)), verifier))
+		if err != nil {
+			return err
+		}
+		if !verifier.Verified() {
+			return fmt.Errorf("zstd:chunked table of contents of blob %s does not match digest %s", srcInfo.Digest, checksum)
+		}
+		compressed = data
+		return nil
+	}); err != nil {
+		return nil, nil, 0, fmt.Errorf("reading zstd:chunked table of contents: %w", err)
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer decoder.Close()
+	tocBytes, err := decoder.DecodeAll(compressed, make([]byte, 0, uncompressedLength))
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("decompressing zstd:chunked table of contents: %w", err)
+	}
+	toc := zstdChunkedTOC{}
+	if err := json.Unmarshal(tocBytes, &toc); err != nil {
+		return nil, nil, 0, fmt.Errorf("parsing zstd:chunked table of contents: %w", err)
+	}
+	return tocBytes, &toc, offset - 8, nil
+}
+
+// copyReusableFiles looks for the contents of regular files listed in toc in other layers in the store which were created
+// from zstd:chunked blobs, and copies the contents it finds to temporary files.
+// It returns the paths of the temporary files, indexed by the digest of their contents.
+func (s *storageImageDestination) copyReusableFiles(toc *zstdChunkedTOC) (map[string]string, error) {
+	wanted := map[string]struct{}{}
+	for i := range toc.Entries {
+		if toc.Entries[i].hasReusableContents() {
+			wanted[toc.Entries[i].Digest] = struct{}{}
+		}
+	}
+	res := map[string]string{}
+	if len(wanted) == 0 {
+		return res, nil
+	}
+
+	store := s.imageRef.transport.store
+	layers, err := store.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("listing layers: %w", err)
+	}
+	for _, layer := range layers {
+		if len(res) == len(wanted) {
+			break
+		}
+		// Names of files in this layer with the contents we want, and their digests
+		layerFiles, err := reusableFilesInLayer(store, layer.ID, wanted, res)
+		if err != nil {
+			logrus.Debugf("Not reusing files from layer %q: %v", layer.ID, err)
+			continue
+		}
+		if len(layerFiles) == 0 {
+			continue
+		}
+		if err := s.copyFilesFromLayer(layer.ID, layerFiles, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// reusableFilesInLayer returns normalized paths of regular files in layerID with contents in wanted and not already in found,
+// according to the layer's table of contents, and digests of their contents.
+func reusableFilesInLayer(store storage.Store, layerID string, wanted map[string]struct{}, found map[string]string) (map[string]string, error) {
+	bigDataNames, err := store.ListLayerBigData(layerID)
+	if err != nil {
+		return nil, err
+	}
+	hasTOC := false
+	for _, name := range bigDataNames {
+		if name == zstdChunkedManifestBigDataKey {
+			hasTOC = true
+			break
+		}
+	}
+	if !hasTOC {
+		return nil, nil
+	}
+	rc, err := store.LayerBigData(layerID, zstdChunkedManifestBigDataKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	toc := zstdChunkedTOC{}
+	if err := json.NewDecoder(rc).Decode(&toc); err != nil {
+		return nil, fmt.Errorf("parsing zstd:chunked table of contents: %w", err)
+	}
+	res := map[string]string{}
+	for i := range toc.Entries {
+		e := &toc.Entries[i]
+		if !e.hasReusableContents() {
+			continue
+		}
+		if _, ok := wanted[e.Digest]; !ok {
+			continue
+		}
+		if _, ok := found[e.Digest]; ok {
+			continue
+		}
+		res[normalizedTarPath(e.Name)] = e.Digest
+	}
+	return res, nil
+}
+
+// copyFilesFromLayer copies regular files in layerID, listed as normalized paths in files, to temporary files,
+// if their contents match the digests in files, and records the temporary files in found.
+func (s *storageImageDestination) copyFilesFromLayer(layerID string, files map[string]string, found map[string]string) error {
+	noCompression := archive.Uncompressed
+	diff, err := s.imageRef.transport.store.Diff("", layerID, &storage.DiffOptions{Compression: &noCompression})
+	if err != nil {
+		logrus.Debugf("Not reusing files from layer %q: %v", layerID, err)
+		return nil
+	}
+	defer diff.Close()
+	tr := tar.NewReader(diff)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logrus.Debugf("Not reusing more files from layer %q: %v", layerID, err)
+			return nil
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		expected, ok := files[normalizedTarPath(hdr.Name)]
+		if !ok {
+			continue
+		}
+		if _, ok := found[expected]; ok {
+			continue
+		}
+		d, err := digest.Parse(expected)
+		if err != nil {
+			continue
+		}
+		filename := s.computeNextBlobCacheFile()
+		file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_EXCL, 0600)
+		if err != nil {
+			return fmt.Errorf("creating temporary file %q: %w", filename, err)
+		}
+		verifier := d.Verifier()
+		_, err = io.Copy(io.MultiWriter(file, verifier), tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil || !verifier.Verified() {
+			os.Remove(filename)
+			if err != nil {
+				return fmt.Errorf("copying %q from layer %q: %w", hdr.Name, layerID, err)
+			}
+			logrus.Debugf("Contents of %q in layer %q do not match %s, not reusing them", hdr.Name, layerID, d)
+			continue
+		}
+		found[expected] = filename
+	}
+}
+
+// zstdChunkedSegment is a part of a zstd:chunked blob: either a chunk to fetch, or the contents of a file we already have.
+type zstdChunkedSegment struct {
+	chunk      private.ImageSourceChunk
+	reusedFile string // If not "", the path of a file with the uncompressed contents of chunk, which is not fetched
+}
+
+// putBlobPartialReusingFiles implements PutBlobPartial for graph drivers which can't apply layers using a differ:
+// it reads the table of contents of a zstd:chunked layer, reuses contents of files present in other layers
+// in the store, fetches only the rest of the blob using chunkAccessor, and stores the result as an uncompressed layer
+// in a pending file, to be committed like a layer written using PutBlobWithOptions.
+func (s *storageImageDestination) putBlobPartialReusingFiles(ctx context.Context, chunkAccessor private.BlobChunkAccessor, srcInfo types.BlobInfo) (types.BlobInfo, error) {
+	tocBytes, toc, tocOffset, err := readZstdChunkedTOC(ctx, chunkAccessor, srcInfo)
+	if err != nil {
+		return srcInfo, err
+	}
+	reusedFiles, err := s.copyReusableFiles(toc)
+	if err != nil {
+		return srcInfo, err
+	}
+	defer func() {
+		for _, filename := range reusedFiles {
+			os.Remove(filename)
+		}
+	}()
+
+	segments := []zstdChunkedSegment{}
+	chunks := []private.ImageSourceChunk{}
+	addChunk := func(offset, length uint64) {
+		chunk := private.ImageSourceChunk{Offset: offset, Length: length}
+		segments = append(segments, zstdChunkedSegment{chunk: chunk})
+		chunks = append(chunks, chunk)
+	}
+	offset := uint64(0)
+	for i := range toc.Entries {
+		e := &toc.Entries[i]
+		if !e.hasReusableContents() {
+			continue
+		}
+		filename, ok := reusedFiles[e.Digest]
+		if !ok {
+			continue
+		}
+		start, end := uint64(e.Offset), uint64(e.EndOffset)
+		if e.Offset < 0 || start < offset || end < start || end > tocOffset {
+			return srcInfo, fmt.Errorf("invalid offsets of %q in the zstd:chunked table of contents", e.Name)
+		}
+		if start > offset {
+			addChunk(offset, start-offset)
+		}
+		segments = append(segments, zstdChunkedSegment{
+			chunk:      private.ImageSourceChunk{Offset: start, Length: end - start},
+			reusedFile: filename,
+		})
+		offset = end
+	}
+	if offset < tocOffset {
+		addChunk(offset, tocOffset-offset)
+	}
+
+	filename := s.computeNextBlobCacheFile()
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_EXCL, 0600)
+	if err != nil {
+		return srcInfo, fmt.Errorf("creating temporary file %q: %w", filename, err)
+	}
+	succeeded := false
+	defer func() {
+		file.Close()
+		if !succeeded {
+			os.Remove(filename)
+		}
+	}()
+	diffID := digest.Canonical.Digester()
+	counter := ioutils.NewWriteCounter(io.MultiWriter(file, diffID.Hash()))
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return srcInfo, err
+	}
+	defer decoder.Close()
+
+	nextSegment := 0
+	// writeReusedSegments writes the reused segments starting at nextSegment, until the next segment to fetch.
+	writeReusedSegments := func() error {
+		for ; nextSegment < len(segments) && segments[nextSegment].reusedFile != ""; nextSegment++ {
+			f, err := os.Open(segments[nextSegment].reusedFile)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(counter, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := writeReusedSegments(); err != nil {
+		return srcInfo, err
+	}
+	if len(chunks) != 0 {
+		if err := forEachBlobChunk(ctx, chunkAccessor, srcInfo, chunks, func(_ int, r io.Reader) error {
+			if err := decoder.Reset(io.LimitReader(r, int64(segments[nextSegment].chunk.Length))); err != nil {
+				return err
+			}
+			if _, err := io.Copy(counter, decoder); err != nil {
+				return fmt.Errorf("decompressing blob %s at offset %d: %w", srcInfo.Digest, segments[nextSegment].chunk.Offset, err)
+			}
+			nextSegment++
+			return writeReusedSegments()
+		}); err != nil {
+			return srcInfo, err
+		}
+	}
+
+	// Ensure that what we have assembled is the layer described by the table of contents, which is authenticated
+	// by the annotation in the manifest.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return srcInfo, err
+	}
+	if err := verifyLayerMatchesTOC(file, toc); err != nil {
+		return srcInfo, fmt.Errorf("assembling layer %s: %w", srcInfo.Digest, err)
+	}
+
+	s.lock.Lock()
+	s.blobDiffIDs[srcInfo.Digest] = diffID.Digest()
+	s.fileSizes[srcInfo.Digest] = srcInfo.Size
+	s.filenames[srcInfo.Digest] = filename
+	s.zstdChunkedTOCs[srcInfo.Digest] = tocBytes
+	s.lock.Unlock()
+	succeeded = true
+	logrus.Debugf("Assembled layer %s, reusing %d of the files in other layers", srcInfo.Digest, len(reusedFiles))
+	return srcInfo, nil
+}
+
+// verifyLayerMatchesTOC checks that the uncompressed tar stream in layer contains exactly the entries in toc,
+// with the regular files’ contents matching their digests.
+func verifyLayerMatchesTOC(layer io.Reader, toc *zstdChunkedTOC) error {
+	tr := tar.NewReader(layer)
+	entries := toc.Entries
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for len(entries) != 0 && entries[0].Type == zstdChunkedTypeChunk {
+			entries = entries[1:]
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("unexpected entry %q, not in the table of contents", hdr.Name)
+		}
+		e := &entries[0]
+		entries = entries[1:]
+		if normalizedTarPath(hdr.Name) != normalizedTarPath(e.Name) {
+			return fmt.Errorf("unexpected entry %q, expected %q", hdr.Name, e.Name)
+		}
+		if e.hasReusableContents() {
+			d, err := digest.Parse(e.Digest)
+			if err != nil {
+				return fmt.Errorf("invalid digest of %q: %w", e.Name, err)
+			}
+			if hdr.Typeflag != tar.TypeReg || hdr.Size != e.Size {
+				return fmt.Errorf("entry %q does not match the table of contents", hdr.Name)
+			}
+			verifier := d.Verifier()
+			if _, err := io.Copy(verifier, tr); err != nil {
+				return err
+			}
+			if !verifier.Verified() {
+				return fmt.Errorf("contents of %q do not match digest %s", hdr.Name, d)
+			}
+		}
+	}
+	for len(entries) != 0 && entries[0].Type == zstdChunkedTypeChunk {
+		entries = entries[1:]
+	}
+	if len(entries) != 0 {
+		return fmt.Errorf("entry %q in the table of contents not found", entries[0].Name)
+	}
+	return nil
+}
+
+// recordZstdChunkedTOC records tocBytes as the table of contents of layerID, so that later partial pulls can reuse its files.
+func recordZstdChunkedTOC(store storage.Store, layerID string, tocBytes []byte) error {
+	if err := store.SetLayerBigData(layerID, zstdChunkedManifestBigDataKey, bytes.NewReader(tocBytes)); err != nil {
+		return fmt.Errorf("recording the zstd:chunked table of contents of layer %q: %w", layerID, err)
+	}
+	return nil
+}
@@ -82,6 +82,7 @@ type storageImageDestination struct {
 	indexToPulledLayerInfo map[int]*manifest.LayerInfo                           // Mapping from layer (by index) to pulled down blob
 	blobAdditionalLayer    map[digest.Digest]storage.AdditionalLayer             // Mapping from layer blobsums to their corresponding additional layer
 	diffOutputs            map[digest.Digest]*graphdriver.DriverWithDifferOutput // Mapping from digest to differ output
+	zstdChunkedTOCs        map[digest.Digest][]byte                              // Mapping from layer blobsums assembled by putBlobPartialReusingFiles to their tables of contents
 }
 
 // newImageDestination sets us up to write a new image, caching blobs in a temporary directory until
@@ -121,6 +122,7 @@ func newImageDestination(sys *types.SystemContext, imageRef storageReference) (*
 		indexToStorageID:       make(map[int]*string),
 		indexToPulledLayerInfo: make(map[int]*manifest.LayerInfo),
 		diffOutputs:            make(map[digest.Digest]*graphdriver.DriverWithDifferOutput),
+		zstdChunkedTOCs:        make(map[digest.Digest][]byte),
 	}
 	dest.Compat = impl.AddCompat(dest)
 	return dest, nil
@@ -263,7 +265,17 @@ func (f *zstdFetcher) GetBlobAt(chunks []chunked.ImageSourceChunk) (chan io.Read
 // It is available only if SupportsPutBlobPartial().
 // Even if SupportsPutBlobPartial() returns true, the call can fail, in which case the caller
 // should fall back to PutBlobWithOptions.
+// With graph drivers which support ApplyDiffWithDiffer (overlay), the differ from github.com/containers/storage/pkg/chunked
+// decides which chunks are already present; with other graph drivers, putBlobPartialReusingFiles reuses files of zstd:chunked layers.
 func (s *storageImageDestination) PutBlobPartial(ctx context.Context, chunkAccessor private.BlobChunkAccessor, srcInfo types.BlobInfo, cache blobinfocache.BlobInfoCache2) (types.BlobInfo, error) {
+	driver, err := s.imageRef.transport.store.GraphDriver()
+	if err != nil {
+		return srcInfo, err
+	}
+	if _, ok := driver.(graphdriver.DriverWithDiffer); !ok {
+		return s.putBlobPartialReusingFiles(ctx, chunkAccessor, srcInfo)
+	}
+
 	fetcher := zstdFetcher{
 		chunkAccessor: chunkAccessor,
 		ctx:           ctx,
@@ -686,6 +698,14 @@ func (s *storageImageDestination) commitLayer(ctx context.Context, blob manifest
 	if err != nil && !errors.Is(err, storage.ErrDuplicateID) {
 		return fmt.Errorf("adding layer with blob %q: %w", blob.Digest, err)
 	}
+	s.lock.Lock()
+	tocBytes, ok := s.zstdChunkedTOCs[blob.Digest]
+	s.lock.Unlock()
+	if ok && err == nil {
+		if err := recordZstdChunkedTOC(s.imageRef.transport.store, layer.ID, tocBytes); err != nil {
+			return err
+		}
+	}
 
 	s.indexToStorageID[index] = &layer.ID
 	return nil
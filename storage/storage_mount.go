@@ -0,0 +1,123 @@
+//go:build !containers_image_storage_stub
+// +build !containers_image_storage_stub
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/containers/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// LayerMounter is implemented by types.ImageSource values returned by the containers-storage: transport,
+// and allows reading the contents of the image’s layers directly through the graph driver, without extracting them
+// from blobs.
+type LayerMounter interface {
+	// LayerIDs returns the IDs of the layers of the image, ordered from the base layer up.
+	LayerIDs() ([]string, error)
+	// MountLayer mounts the layer with layerID, which must be one of the values returned by LayerIDs, and returns
+	// the path of the mounted directory. The directory contains the contents of the layer merged with all of its parents,
+	// and must only be used for reading.
+	// Each successful MountLayer call must be matched by an UnmountLayer call; a layer mounted several times remains
+	// mounted until all of them are released.
+	MountLayer(layerID string) (string, error)
+	// UnmountLayer releases a mount of layerID created by MountLayer.
+	UnmountLayer(layerID string) error
+}
+
+var _ LayerMounter = (*storageImageSource)(nil)
+
+// layerMountStore is the subset of storage.Store used by storageImageSource to mount layers.
+type layerMountStore interface {
+	Layer(id string) (*storage.Layer, error)
+	Mount(id, mountLabel string) (string, error)
+	Unmount(id string, force bool) (bool, error)
+}
+
+// LayerIDs returns the IDs of the layers of the image, ordered from the base layer up.
+func (s *storageImageSource) LayerIDs() ([]string, error) {
+	res := []string{}
+	layerID := s.image.TopLayer
+	for layerID != "" {
+		layer, err := s.mountStore.Layer(layerID)
+		if err != nil {
+			return nil, fmt.Errorf("reading layer %q of image %q: %w", layerID, s.image.ID, err)
+		}
+		res = append([]string{layerID}, res...)
+		layerID = layer.Parent
+	}
+	return res, nil
+}
+
+// MountLayer mounts the layer with layerID, which must be one of the values returned by LayerIDs, and returns
+// the path of the mounted directory.
+func (s *storageImageSource) MountLayer(layerID string) (string, error) {
+	layerIDs, err := s.LayerIDs()
+	if err != nil {
+		return "", err
+	}
+	found := false
+	for _, id := range layerIDs {
+		if id == layerID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("layer %q is not a layer of image %q", layerID, s.image.ID)
+	}
+
+	s.mountsMutex.Lock()
+	defer s.mountsMutex.Unlock()
+	if s.closed {
+		return "", errors.New("mounting a layer of a closed image source")
+	}
+	// The store counts mounts itself, so that layers mounted by other users are not unmounted by us;
+	// we record our own mounts so that we never release more than we have created.
+	path, err := s.mountStore.Mount(layerID, "")
+	if err != nil {
+		return "", fmt.Errorf("mounting layer %q: %w", layerID, err)
+	}
+	s.mounts[layerID]++
+	return path, nil
+}
+
+// UnmountLayer releases a mount of layerID created by MountLayer.
+func (s *storageImageSource) UnmountLayer(layerID string) error {
+	s.mountsMutex.Lock()
+	defer s.mountsMutex.Unlock()
+	if s.mounts[layerID] == 0 {
+		return fmt.Errorf("layer %q was not mounted by this image source", layerID)
+	}
+	if _, err := s.mountStore.Unmount(layerID, false); err != nil {
+		return fmt.Errorf("unmounting layer %q: %w", layerID, err)
+	}
+	s.mounts[layerID]--
+	if s.mounts[layerID] == 0 {
+		delete(s.mounts, layerID)
+	}
+	return nil
+}
+
+// unmountAllLayers releases all mounts created by MountLayer, and prevents creating new ones.
+func (s *storageImageSource) unmountAllLayers() error {
+	s.mountsMutex.Lock()
+	defer s.mountsMutex.Unlock()
+	s.closed = true
+	var firstErr error
+	for layerID, count := range s.mounts {
+		logrus.Debugf("Unmounting layer %q, still mounted %d times when closing the image source", layerID, count)
+		for ; count > 0; count-- {
+			if _, err := s.mountStore.Unmount(layerID, false); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("unmounting layer %q: %w", layerID, err)
+				}
+				break
+			}
+		}
+		delete(s.mounts, layerID)
+	}
+	return firstErr
+}
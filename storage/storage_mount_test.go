@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containers/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMountStore is a layerMountStore with a fixed set of layers, which records mounts.
+type fakeMountStore struct {
+	layers   map[string]*storage.Layer
+	mounts   map[string]int
+	mountErr error // If not nil, Mount fails with this error
+}
+
+func newFakeMountStore() *fakeMountStore {
+	return &fakeMountStore{
+		layers: map[string]*storage.Layer{
+			"base":   {ID: "base"},
+			"middle": {ID: "middle", Parent: "base"},
+			"top":    {ID: "top", Parent: "middle"},
+			"other":  {ID: "other"},
+		},
+		mounts: map[string]int{},
+	}
+}
+
+func (s *fakeMountStore) Layer(id string) (*storage.Layer, error) {
+	layer, ok := s.layers[id]
+	if !ok {
+		return nil, storage.ErrLayerUnknown
+	}
+	return layer, nil
+}
+
+func (s *fakeMountStore) Mount(id, mountLabel string) (string, error) {
+	if s.mountErr != nil {
+		return "", s.mountErr
+	}
+	s.mounts[id]++
+	return "/mnt/" + id, nil
+}
+
+func (s *fakeMountStore) Unmount(id string, force bool) (bool, error) {
+	if force {
+		return false, errors.New("unexpected forced unmount")
+	}
+	if s.mounts[id] == 0 {
+		return false, errors.New("not mounted")
+	}
+	s.mounts[id]--
+	return s.mounts[id] > 0, nil
+}
+
+func newFakeMountSource(store *fakeMountStore, topLayer string) *storageImageSource {
+	return &storageImageSource{
+		image:      &storage.Image{ID: "image", TopLayer: topLayer},
+		mountStore: store,
+		mounts:     map[string]int{},
+	}
+}
+
+func TestStorageImageSourceLayerIDs(t *testing.T) {
+	store := newFakeMountStore()
+
+	ids, err := newFakeMountSource(store, "top").LayerIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base", "middle", "top"}, ids)
+
+	ids, err = newFakeMountSource(store, "").LayerIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, ids)
+
+	_, err = newFakeMountSource(store, "unknown").LayerIDs()
+	assert.Error(t, err)
+}
+
+func TestStorageImageSourceMountLayer(t *testing.T) {
+	store := newFakeMountStore()
+	src := newFakeMountSource(store, "top")
+
+	path, err := src.MountLayer("middle")
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/middle", path)
+	assert.Equal(t, map[string]int{"middle": 1}, store.mounts)
+
+	// Layers which are not a part of the image can’t be mounted
+	_, err = src.MountLayer("other")
+	assert.Error(t, err)
+	_, err = src.MountLayer("unknown")
+	assert.Error(t, err)
+
+	// Mount failures are reported
+	store.mountErr = errors.New("mount failed")
+	_, err = src.MountLayer("top")
+	assert.ErrorIs(t, err, store.mountErr)
+	store.mountErr = nil
+
+	// A layer mounted twice remains mounted until both mounts are released
+	path, err = src.MountLayer("middle")
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/middle", path)
+	err = src.UnmountLayer("middle")
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.mounts["middle"])
+	err = src.UnmountLayer("middle")
+	require.NoError(t, err)
+	assert.Equal(t, 0, store.mounts["middle"])
+
+	// Layers not mounted by the source, e.g. in use by other users, are not unmounted
+	store.mounts["base"] = 1
+	err = src.UnmountLayer("base")
+	assert.Error(t, err)
+	err = src.UnmountLayer("middle")
+	assert.Error(t, err)
+	assert.Equal(t, 1, store.mounts["base"])
+	delete(store.mounts, "base")
+
+	// Close releases all remaining mounts, and no new mounts can be created afterwards
+	_, err = src.MountLayer("top")
+	require.NoError(t, err)
+	_, err = src.MountLayer("top")
+	require.NoError(t, err)
+	_, err = src.MountLayer("base")
+	require.NoError(t, err)
+	err = src.Close()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"top": 0, "base": 0, "middle": 0}, store.mounts)
+	_, err = src.MountLayer("top")
+	assert.Error(t, err)
+}
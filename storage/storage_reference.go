@@ -5,6 +5,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -279,6 +280,17 @@ func (s storageReference) DeleteImage(ctx context.Context, sys *types.SystemCont
 	return err
 }
 
+// ImageExists returns true if the reference resolves to an image in the store, and false if it does not.
+func (s storageReference) ImageExists(ctx context.Context, sys *types.SystemContext) (bool, error) {
+	if _, err := s.resolveImage(sys); err != nil {
+		if errors.Is(err, ErrNoSuchImage) || errors.Is(err, storage.ErrImageUnknown) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (s storageReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
 	return newImageSource(ctx, sys, s)
 }
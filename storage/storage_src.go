@@ -40,6 +40,10 @@ type storageImageSource struct {
 	layerPosition   map[digest.Digest]int   // Where we are in reading a blob's layers
 	cachedManifest  []byte                  // A cached copy of the manifest, if already known, or nil
 	getBlobMutex    sync.Mutex              // Mutex to sync state for parallel GetBlob executions
+	mountStore      layerMountStore         // Used to mount layers, normally s.imageRef.transport.store
+	mountsMutex     sync.Mutex              // Protects mounts and closed
+	mounts          map[string]int          // Number of mounts created by MountLayer and not yet released, for each layer ID
+	closed          bool                    // Set by Close, to prevent creating new mounts
 	SignatureSizes  []int                   `json:"signature-sizes,omitempty"`  // List of sizes of each signature slice
 	SignaturesSizes map[digest.Digest][]int `json:"signatures-sizes,omitempty"` // List of sizes of each signature slice
 }
@@ -63,6 +67,8 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, imageRef stor
 		systemContext:   sys,
 		image:           img,
 		layerPosition:   make(map[digest.Digest]int),
+		mountStore:      imageRef.transport.store,
+		mounts:          make(map[string]int),
 		SignatureSizes:  []int{},
 		SignaturesSizes: make(map[digest.Digest][]int),
 	}
@@ -81,8 +87,9 @@ func (s *storageImageSource) Reference() types.ImageReference {
 }
 
 // Close cleans up any resources we tied up while reading the image.
+// Any layers mounted using MountLayer, and not yet released, are unmounted.
 func (s *storageImageSource) Close() error {
-	return nil
+	return s.unmountAllLayers()
 }
 
 // GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
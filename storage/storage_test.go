@@ -36,12 +36,13 @@ import (
 )
 
 var (
-	_ types.ImageDestination   = &storageImageDestination{}
-	_ private.ImageDestination = (*storageImageDestination)(nil)
-	_ types.ImageSource        = &storageImageSource{}
-	_ private.ImageSource      = (*storageImageSource)(nil)
-	_ types.ImageReference     = &storageReference{}
-	_ types.ImageTransport     = &storageTransport{}
+	_ types.ImageDestination                   = &storageImageDestination{}
+	_ private.ImageDestination                 = (*storageImageDestination)(nil)
+	_ types.ImageSource                        = &storageImageSource{}
+	_ private.ImageSource                      = (*storageImageSource)(nil)
+	_ types.ImageReference                     = &storageReference{}
+	_ private.ImageReferenceWithExistenceCheck = &storageReference{}
+	_ types.ImageTransport                     = &storageTransport{}
 )
 
 const (
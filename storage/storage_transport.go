@@ -79,6 +79,11 @@ func (s *storageTransport) Name() string {
 	return "containers-storage"
 }
 
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (s *storageTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 // SetStore sets the Store object which the Transport will use for parsing
 // references when information about a Store is not directly specified as part
 // of the reference.  If one is not set, the library will attempt to initialize
@@ -0,0 +1,8 @@
+package storage
+
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+// They are defined without build constraints, so that the stub transport in transports/alltransports can use them.
+const (
+	ReferenceSyntaxFormat  = "containers-storage:[[storage-specifier]]{image-id|docker-reference[@image-id]}"
+	ReferenceSyntaxExample = "containers-storage:docker.io/library/busybox:latest"
+)
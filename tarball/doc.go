@@ -1,6 +1,9 @@
 // Package tarball provides a way to generate images using one or more layer
 // tarballs and an optional template configuration.
 //
+// Any of the tarballs can also be a directory; a layer is then built from its
+// contents, excluding files matching the patterns set using IgnoreUpdater.
+//
 // An example:
 //
 //	package main
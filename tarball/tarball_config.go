@@ -0,0 +1,61 @@
+package tarball
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// validateConfig returns an error if config, a configuration passed to ConfigUpdate, contains values which
+// would not result in a usable image.
+func validateConfig(config imgspecv1.Image) error {
+	c := config.Config
+	for _, env := range c.Env {
+		if i := strings.IndexByte(env, '='); i <= 0 {
+			return fmt.Errorf("invalid environment variable %q, expected NAME=VALUE", env)
+		}
+	}
+	for port := range c.ExposedPorts {
+		if err := validateExposedPort(port); err != nil {
+			return err
+		}
+	}
+	for volume := range c.Volumes {
+		if !path.IsAbs(volume) {
+			return fmt.Errorf("invalid volume %q, the path must be absolute", volume)
+		}
+	}
+	if c.WorkingDir != "" && !path.IsAbs(c.WorkingDir) {
+		return fmt.Errorf("invalid working directory %q, the path must be absolute", c.WorkingDir)
+	}
+	if strings.TrimSpace(c.User) != c.User {
+		return fmt.Errorf("invalid user %q", c.User)
+	}
+	for label := range c.Labels {
+		if label == "" {
+			return fmt.Errorf("invalid empty label name")
+		}
+	}
+	return nil
+}
+
+// validateExposedPort returns an error if port is not of the form port[/protocol].
+func validateExposedPort(port string) error {
+	number := port
+	if i := strings.IndexByte(port, '/'); i != -1 {
+		number = port[:i]
+		switch protocol := port[i+1:]; protocol {
+		case "tcp", "udp", "sctp":
+		default:
+			return fmt.Errorf("invalid exposed port %q, unknown protocol %q", port, protocol)
+		}
+	}
+	n, err := strconv.ParseUint(number, 10, 16)
+	if err != nil || n == 0 {
+		return fmt.Errorf("invalid exposed port %q, expected port[/protocol]", port)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package tarball
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTarball creates a tarball containing a single file, and returns its path.
+func newTestTarball(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "layer.tar")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	contents := []byte("#!/bin/sh\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "app", Mode: 0o755, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	return path
+}
+
+func TestConfigUpdate(t *testing.T) {
+	created := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	config := imgspecv1.Image{
+		Created:      &created,
+		Author:       "CI",
+		Architecture: "arm64",
+		OS:           "linux",
+		Config: imgspecv1.ImageConfig{
+			User:         "1000:1000",
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}, "53/udp": {}, "9000": {}},
+			Env:          []string{"PATH=/usr/bin:/bin", "EMPTY="},
+			Entrypoint:   []string{"/app"},
+			Cmd:          []string{"--verbose"},
+			Volumes:      map[string]struct{}{"/data": {}},
+			WorkingDir:   "/srv",
+			Labels:       map[string]string{"org.example.label": "value"},
+			StopSignal:   "SIGTERM",
+		},
+		History: []imgspecv1.History{{Comment: "built by CI"}},
+	}
+
+	ref, err := NewReference([]string{newTestTarball(t)}, nil)
+	require.NoError(t, err)
+	err = ref.(ConfigUpdater).ConfigUpdate(config, map[string]string{imgspecv1.AnnotationDescription: "test image"})
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	manifestBlob, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	man, err := manifest.OCI1FromManifest(manifestBlob)
+	require.NoError(t, err)
+	assert.Equal(t, "test image", man.Annotations[imgspecv1.AnnotationDescription])
+	stream, _, err := src.GetBlob(context.Background(), manifest.BlobInfoFromOCI1Descriptor(man.Config), none.NoCache)
+	require.NoError(t, err)
+	defer stream.Close()
+	configBlob, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	var res imgspecv1.Image
+	err = json.Unmarshal(configBlob, &res)
+	require.NoError(t, err)
+
+	require.NotNil(t, res.Created)
+	assert.True(t, created.Equal(*res.Created))
+	assert.Equal(t, "CI", res.Author)
+	assert.Equal(t, "arm64", res.Architecture)
+	assert.Equal(t, "linux", res.OS)
+	assert.Equal(t, config.Config, res.Config)
+	require.Len(t, res.RootFS.DiffIDs, 1)
+	require.Len(t, res.History, 1)
+	assert.Equal(t, "built by CI", res.History[0].Comment)
+
+	// Invalid configurations are rejected.
+	for _, c := range []imgspecv1.ImageConfig{
+		{Env: []string{"NOVALUE"}},
+		{Env: []string{"=value"}},
+		{ExposedPorts: map[string]struct{}{"http": {}}},
+		{ExposedPorts: map[string]struct{}{"0/tcp": {}}},
+		{ExposedPorts: map[string]struct{}{"65536": {}}},
+		{ExposedPorts: map[string]struct{}{"80/icmp": {}}},
+		{Volumes: map[string]struct{}{"data": {}}},
+		{WorkingDir: "relative/dir"},
+		{User: " root"},
+		{Labels: map[string]string{"": "value"}},
+	} {
+		err := ref.(ConfigUpdater).ConfigUpdate(imgspecv1.Image{Config: c}, nil)
+		assert.Error(t, err, c)
+	}
+}
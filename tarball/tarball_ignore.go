@@ -0,0 +1,125 @@
+package tarball
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/internal/tmpdir"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/pkg/fileutils"
+)
+
+// IgnoreUpdater is an interface that ImageReferences for "tarball" images also
+// implement.  It can be used to set patterns, using the .dockerignore syntax,
+// of files to exclude from layers built from directories.
+//
+// Any of the file names of a "tarball" reference can refer to a directory instead of
+// a tarball; the layer is then built from the contents of that directory.
+type IgnoreUpdater interface {
+	IgnoreUpdate(patterns []string) error
+}
+
+// IgnoreUpdate sets the patterns of files to exclude from layers built from directories,
+// relative to the root of each directory.  As in .dockerignore files, patterns starting with "!"
+// re-include files excluded by an earlier pattern, and excluding a directory excludes all of its contents.
+func (r *tarballReference) IgnoreUpdate(patterns []string) error {
+	cleaned := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = cleanIgnorePattern(pattern)
+		if pattern == "" {
+			continue
+		}
+		cleaned = append(cleaned, pattern)
+	}
+	if _, err := fileutils.NewPatternMatcher(cleaned); err != nil {
+		return fmt.Errorf("invalid ignore patterns: %w", err)
+	}
+	r.ignorePatterns = cleaned
+	return nil
+}
+
+// cleanIgnorePattern normalizes a single pattern the way .dockerignore files are processed,
+// returning "" if the pattern is empty.
+func cleanIgnorePattern(pattern string) string {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return ""
+	}
+	negated := false
+	if pattern[0] == '!' {
+		negated = true
+		pattern = strings.TrimSpace(pattern[1:])
+		if pattern == "" {
+			return ""
+		}
+	}
+	pattern = filepath.Clean(pattern)
+	pattern = filepath.ToSlash(pattern)
+	if len(pattern) > 1 && pattern[0] == '/' {
+		pattern = pattern[1:]
+	}
+	if negated {
+		pattern = "!" + pattern
+	}
+	return pattern
+}
+
+// ReadIgnorePatterns reads ignore patterns in the .dockerignore file format from r,
+// suitable for IgnoreUpdater.IgnoreUpdate.
+func ReadIgnorePatterns(r io.Reader) ([]string, error) {
+	patterns := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Lines starting with # are comments.
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if pattern := cleanIgnorePattern(line); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading ignore patterns: %w", err)
+	}
+	return patterns, nil
+}
+
+// tarDirectory creates an uncompressed tarball with the contents of directory, excluding files matching
+// ignorePatterns, in a temporary file, and returns the file, open for reading.
+// The caller is responsible for closing and removing the file.
+func tarDirectory(sys *types.SystemContext, directory string, ignorePatterns []string) (*os.File, error) {
+	tarStream, err := archive.TarWithOptions(directory, &archive.TarOptions{
+		Compression:     archive.Uncompressed,
+		ExcludePatterns: ignorePatterns,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating a layer from %q: %w", directory, err)
+	}
+	defer tarStream.Close()
+
+	file, err := os.CreateTemp(tmpdir.TemporaryDirectoryForBigFiles(sys), "tarball-layer")
+	if err != nil {
+		return nil, fmt.Errorf("error creating a temporary file for a layer from %q: %w", directory, err)
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err := io.Copy(file, tarStream); err != nil {
+		return nil, fmt.Errorf("error creating a layer from %q: %w", directory, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	succeeded = true
+	return file, nil
+}
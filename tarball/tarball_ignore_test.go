@@ -0,0 +1,103 @@
+package tarball
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadIgnorePatterns(t *testing.T) {
+	patterns, err := ReadIgnorePatterns(strings.NewReader("# comment\n\n*.log\n  !keep.log  \n/build/\n./sub/../other\n!\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.log", "!keep.log", "build", "other"}, patterns)
+}
+
+func TestIgnoreUpdate(t *testing.T) {
+	ref, err := NewReference([]string{t.TempDir()}, nil)
+	require.NoError(t, err)
+	updater, ok := ref.(IgnoreUpdater)
+	require.True(t, ok)
+	err = updater.IgnoreUpdate([]string{"["})
+	assert.Error(t, err)
+}
+
+func TestDirectoryLayerIgnorePatterns(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{
+		"a.txt",
+		"b.log",
+		"keep.log",
+		"build/out.bin",
+		"build/keep/x",
+		"sub/c.txt",
+		"sub/d.tmp",
+		"sub/deeper/e.tmp",
+		".git/config",
+	} {
+		path := filepath.Join(dir, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(file), 0o644))
+	}
+
+	ref, err := NewReference([]string{dir}, nil)
+	require.NoError(t, err)
+	err = ref.(IgnoreUpdater).IgnoreUpdate([]string{
+		"*.log",
+		"!keep.log",
+		"build",
+		"!build/keep",
+		"**/*.tmp",
+		".git/",
+	})
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	manifestBlob, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	man, err := manifest.OCI1FromManifest(manifestBlob)
+	require.NoError(t, err)
+	require.Len(t, man.Layers, 1)
+
+	stream, _, err := src.GetBlob(context.Background(), manifest.BlobInfoFromOCI1Descriptor(man.Layers[0]), none.NoCache)
+	require.NoError(t, err)
+	names := []string{}
+	contents := map[string]string{}
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		name := strings.TrimSuffix(hdr.Name, "/")
+		names = append(names, name)
+		if hdr.Typeflag == tar.TypeReg {
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			contents[name] = string(data)
+		}
+	}
+	stream.Close()
+	sort.Strings(names)
+	assert.Equal(t, []string{"a.txt", "build/keep", "build/keep/x", "keep.log", "sub", "sub/c.txt", "sub/deeper"}, names)
+	assert.Equal(t, "sub/c.txt", contents["sub/c.txt"])
+
+	// The temporary layer file is removed on Close.
+	tempFiles := src.(*tarballImageSource).tempFiles
+	require.Len(t, tempFiles, 1)
+	require.NoError(t, src.Close())
+	_, err = os.Stat(tempFiles[0])
+	assert.True(t, os.IsNotExist(err))
+}
@@ -17,20 +17,31 @@ import (
 // implement.  It can be used to set values for a configuration, and to set
 // image annotations which will be present in the images returned by the
 // reference's NewImage() or NewImageSource() methods.
+//
+// All fields of the configuration are used as provided, notably the
+// execution parameters (environment, entrypoint, command, working directory,
+// labels, exposed ports, volumes, user), except that RootFS and History are
+// generated from the layers; the comment of the first History entry, if any,
+// is used for the generated History.  Created, Architecture and OS are set to
+// default values if empty.  ConfigUpdate fails if the configuration is invalid.
 type ConfigUpdater interface {
 	ConfigUpdate(config imgspecv1.Image, annotations map[string]string) error
 }
 
 type tarballReference struct {
-	config      imgspecv1.Image
-	annotations map[string]string
-	filenames   []string
-	stdin       []byte
+	config         imgspecv1.Image
+	annotations    map[string]string
+	ignorePatterns []string
+	filenames      []string
+	stdin          []byte
 }
 
 // ConfigUpdate updates the image's default configuration and adds annotations
 // which will be visible in source images created using this reference.
 func (r *tarballReference) ConfigUpdate(config imgspecv1.Image, annotations map[string]string) error {
+	if err := validateConfig(config); err != nil {
+		return fmt.Errorf("invalid image configuration: %w", err)
+	}
 	r.config = config
 	if r.annotations == nil {
 		r.annotations = make(map[string]string)
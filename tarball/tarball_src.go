@@ -38,6 +38,7 @@ type tarballImageSource struct {
 	configID   digest.Digest
 	configSize int64
 	manifest   []byte
+	tempFiles  []string // Temporary files containing layers built from directories, to remove on Close.
 }
 
 func (r *tarballReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
@@ -49,6 +50,13 @@ func (r *tarballReference) NewImageSource(ctx context.Context, sys *types.System
 	blobSizes := []int64{}
 	blobTimes := []time.Time{}
 	blobTypes := []string{}
+	tempFiles := []string{}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			removeTempFiles(tempFiles)
+		}
+	}()
 	for _, filename := range r.filenames {
 		var file *os.File
 		var err error
@@ -65,13 +73,27 @@ func (r *tarballReference) NewImageSource(ctx context.Context, sys *types.System
 				return nil, fmt.Errorf("error opening %q for reading: %v", filename, err)
 			}
 			defer file.Close()
-			reader = file
 			fileinfo, err := file.Stat()
 			if err != nil {
 				return nil, fmt.Errorf("error reading size of %q: %v", filename, err)
 			}
-			blobSize = fileinfo.Size()
 			blobTime = fileinfo.ModTime()
+			if fileinfo.IsDir() {
+				// Build the layer from the directory's contents, once, so that GetBlob returns exactly the data we digest here.
+				file, err = tarDirectory(sys, filename, r.ignorePatterns)
+				if err != nil {
+					return nil, err
+				}
+				defer file.Close()
+				tempFiles = append(tempFiles, file.Name())
+				filename = file.Name()
+				fileinfo, err = file.Stat()
+				if err != nil {
+					return nil, fmt.Errorf("error reading size of %q: %v", filename, err)
+				}
+			}
+			reader = file
+			blobSize = fileinfo.Size()
 		}
 
 		// Default to assuming the layer is compressed.
@@ -209,16 +231,26 @@ func (r *tarballReference) NewImageSource(ctx context.Context, sys *types.System
 		configID:   configID,
 		configSize: configSize,
 		manifest:   manifestBytes,
+		tempFiles:  tempFiles,
 	}
 	src.Compat = impl.AddCompat(src)
 
+	succeeded = true
 	return src, nil
 }
 
 func (is *tarballImageSource) Close() error {
+	removeTempFiles(is.tempFiles)
 	return nil
 }
 
+// removeTempFiles removes temporary layer files, ignoring errors.
+func removeTempFiles(files []string) {
+	for _, file := range files {
+		_ = os.Remove(file)
+	}
+}
+
 // GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
 // The Digest field in BlobInfo is guaranteed to be provided, Size may be -1 and MediaType may be optionally provided.
 // May update BlobInfoCache, preferably after it knows for certain that a blob truly exists at a specific location.
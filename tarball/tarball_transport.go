@@ -30,6 +30,17 @@ func (t *tarballTransport) Name() string {
 	return transportName
 }
 
+// ReferenceSyntaxFormat and ReferenceSyntaxExample describe the references accepted by Transport, see ReferenceSyntax.
+const (
+	ReferenceSyntaxFormat  = "tarball:path[:path...]"
+	ReferenceSyntaxExample = "tarball:/var/tmp/layer.tar.gz"
+)
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (t *tarballTransport) ReferenceSyntax() (format, example string) {
+	return ReferenceSyntaxFormat, ReferenceSyntaxExample
+}
+
 func (t *tarballTransport) ParseReference(reference string) (types.ImageReference, error) {
 	var stdin []byte
 	var err error
@@ -7,10 +7,12 @@ import (
 	// register all known transports
 	// NOTE: Make sure docs/containers-policy.json.5.md is updated when adding or updating
 	// a transport.
+	_ "github.com/containers/image/v5/contentstore"
 	_ "github.com/containers/image/v5/directory"
 	_ "github.com/containers/image/v5/docker"
 	_ "github.com/containers/image/v5/docker/archive"
 	_ "github.com/containers/image/v5/oci/archive"
+	_ "github.com/containers/image/v5/oci/httplayout"
 	_ "github.com/containers/image/v5/oci/layout"
 	_ "github.com/containers/image/v5/openshift"
 	_ "github.com/containers/image/v5/sif"
@@ -1,6 +1,7 @@
 package alltransports
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/containers/image/v5/directory"
@@ -27,6 +28,7 @@ func TestParseImageName(t *testing.T) {
 func TestImageNameHandling(t *testing.T) {
 	// Always registered transports
 	for _, c := range []struct{ transport, input, roundtrip string }{
+		{"content-store", "/etc@sha256:0000000000000000000000000000000000000000000000000000000000000000", "/etc@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
 		{"dir", "/etc", "/etc"},
 		{"docker", "//busybox", "//busybox:latest"},
 		{"docker", "//busybox:notlatest", "//busybox:notlatest"}, // This also tests handling of multiple ":" characters
@@ -36,6 +38,7 @@ func TestImageNameHandling(t *testing.T) {
 		{"oci", "/etc:someimage:mytag", "/etc:someimage:mytag"},
 		{"oci-archive", "/etc:someimage", "/etc:someimage"},
 		{"oci-archive", "/etc:someimage:mytag", "/etc:someimage:mytag"},
+		{"oci-http", "https://example.com/layout/#someimage:mytag", "https://example.com/layout#someimage:mytag"},
 		// "atomic" not tested here because it depends on per-user configuration for the default cluster.
 		// "containers-storage" not tested here because it needs to initialize various directories on the fs.
 	} {
@@ -53,6 +56,29 @@ func TestImageNameHandling(t *testing.T) {
 	}
 }
 
+func TestListSyntaxes(t *testing.T) {
+	syntaxes := transports.ListSyntaxes()
+	names := []string{}
+	for _, s := range syntaxes {
+		names = append(names, s.Name)
+		assert.NotEmpty(t, s.Format, s.Name)
+		assert.True(t, strings.HasPrefix(s.Format, s.Name+":"), s.Name)
+		assert.NotEmpty(t, s.Example, s.Name)
+		assert.True(t, strings.HasPrefix(s.Example, s.Name+":"), s.Name)
+	}
+	assert.Equal(t, transports.ListNames(), names)
+
+	// The examples are accepted, except by transports which may be stubbed out or which open the referenced files when parsing.
+	for _, s := range syntaxes {
+		switch s.Name {
+		case "containers-storage", "docker-daemon", "ostree", "tarball":
+			continue
+		}
+		_, err := ParseImageName(s.Example)
+		assert.NoError(t, err, s.Example)
+	}
+}
+
 func TestTransportFromImageName(t *testing.T) {
 	dirTransport := TransportFromImageName("dir:/tmp/test")
 	assert.Equal(t, dirTransport.Name(), directory.Transport.Name())
@@ -3,8 +3,11 @@
 
 package alltransports
 
-import "github.com/containers/image/v5/transports"
+import (
+	"github.com/containers/image/v5/docker/daemon"
+	"github.com/containers/image/v5/transports"
+)
 
 func init() {
-	transports.Register(transports.NewStubTransport("docker-daemon"))
+	transports.Register(transports.NewStubTransportWithSyntax("docker-daemon", daemon.ReferenceSyntaxFormat, daemon.ReferenceSyntaxExample))
 }
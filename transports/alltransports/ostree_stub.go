@@ -3,8 +3,11 @@
 
 package alltransports
 
-import "github.com/containers/image/v5/transports"
+import (
+	"github.com/containers/image/v5/ostree"
+	"github.com/containers/image/v5/transports"
+)
 
 func init() {
-	transports.Register(transports.NewStubTransport("ostree"))
+	transports.Register(transports.NewStubTransportWithSyntax("ostree", ostree.ReferenceSyntaxFormat, ostree.ReferenceSyntaxExample))
 }
@@ -3,8 +3,11 @@
 
 package alltransports
 
-import "github.com/containers/image/v5/transports"
+import (
+	"github.com/containers/image/v5/storage"
+	"github.com/containers/image/v5/transports"
+)
 
 func init() {
-	transports.Register(transports.NewStubTransport("containers-storage"))
+	transports.Register(transports.NewStubTransportWithSyntax("containers-storage", storage.ReferenceSyntaxFormat, storage.ReferenceSyntaxExample))
 }
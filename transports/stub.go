@@ -34,3 +34,20 @@ func (s stubTransport) ValidatePolicyConfigurationScope(scope string) error {
 	// See also the treatment of unknown transports in policyTransportScopesWithTransport.UnmarshalJSON .
 	return nil
 }
+
+// stubTransportWithSyntax is a stubTransport which also describes the references accepted by the transport in builds which support it.
+type stubTransportWithSyntax struct {
+	stubTransport
+	format, example string
+}
+
+// NewStubTransportWithSyntax returns an implementation of types.ImageTransport which has a name, and implements ReferenceSyntaxDescriber
+// using format and example, but rejects any references with “the transport $name: is not supported in this build”.
+func NewStubTransportWithSyntax(name, format, example string) types.ImageTransport {
+	return stubTransportWithSyntax{stubTransport: stubTransport(name), format: format, example: example}
+}
+
+// ReferenceSyntax returns the format of the references accepted by the transport, and an example image name.
+func (s stubTransportWithSyntax) ReferenceSyntax() (format, example string) {
+	return s.format, s.example
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStubTransport(t *testing.T) {
@@ -16,3 +17,19 @@ func TestStubTransport(t *testing.T) {
 	err = s.ValidatePolicyConfigurationScope("this is accepted regardless of content")
 	assert.NoError(t, err)
 }
+
+func TestStubTransportWithSyntax(t *testing.T) {
+	const name = "whatever"
+
+	s := NewStubTransportWithSyntax(name, "whatever:path", "whatever:/some/path")
+	assert.Equal(t, name, s.Name())
+	_, err := s.ParseReference("this is rejected regardless of content")
+	assert.Error(t, err)
+	err = s.ValidatePolicyConfigurationScope("this is accepted regardless of content")
+	assert.NoError(t, err)
+	describer, ok := s.(ReferenceSyntaxDescriber)
+	require.True(t, ok)
+	format, example := describer.ReferenceSyntax()
+	assert.Equal(t, "whatever:path", format)
+	assert.Equal(t, "whatever:/some/path", example)
+}
@@ -88,3 +88,33 @@ func ListNames() []string {
 	sort.Strings(names)
 	return names
 }
+
+// ReferenceSyntaxDescriber is an optional interface which implementations of types.ImageTransport can implement
+// to describe the references they accept, e.g. for help text.
+type ReferenceSyntaxDescriber interface {
+	// ReferenceSyntax returns the format of the references accepted by the transport, including the transport name prefix
+	// (e.g. "dir:path"), and an example image name accepted by the transport, including the prefix (e.g. "dir:/var/tmp/busybox").
+	ReferenceSyntax() (format, example string)
+}
+
+// TransportSyntax describes the references accepted by a registered transport.
+type TransportSyntax struct {
+	Name    string // The transport name, as returned by types.ImageTransport.Name()
+	Format  string // The format of the references, including the transport name prefix, or "" if the transport does not describe it
+	Example string // An example image name, including the transport name prefix, or "" if the transport does not describe it
+}
+
+// ListSyntaxes returns the reference syntax of the non deprecated transports (the ones returned by ListNames), sorted by name.
+// The format and example are empty for transports which don't implement ReferenceSyntaxDescriber.
+func ListSyntaxes() []TransportSyntax {
+	names := ListNames()
+	res := make([]TransportSyntax, 0, len(names))
+	for _, name := range names {
+		s := TransportSyntax{Name: name}
+		if describer, ok := Get(name).(ReferenceSyntaxDescriber); ok {
+			s.Format, s.Example = describer.ReferenceSyntax()
+		}
+		res = append(res, s)
+	}
+	return res
+}
@@ -3,12 +3,15 @@ package types
 import (
 	"context"
 	"io"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/containers/image/v5/docker/reference"
 	compression "github.com/containers/image/v5/pkg/compression/types"
 	digest "github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
 )
 
 // ImageTransport is a top-level namespace for ways to to store/load an image.
@@ -469,6 +472,14 @@ type ImageInspectInfo struct {
 	LayersData    []ImageInspectLayer
 	Env           []string
 	Author        string
+	User          string                   // As specified in the image configuration; "" if not specified
+	ParsedUser    *ImageInspectUser        // User, split into its components; nil if User is not valid
+	KnownLabels   ImageInspectKnownLabels  // Values of well-known keys in Labels, parsed where applicable
+	Healthcheck   *ImageInspectHealthcheck // As specified in the image configuration; nil if not specified, or for schema1 images
+	// Names of the image (in the repository:tag and repository@digest forms), as recorded by the transport outside of the image,
+	// e.g. by the local docker daemon; nil if the transport doesn't record them.
+	RepoTags    []string
+	RepoDigests []string
 }
 
 // ImageInspectLayer is a set of metadata describing an image layers' detail
@@ -479,6 +490,63 @@ type ImageInspectLayer struct {
 	Annotations map[string]string
 }
 
+// ImageInspectKnownLabels contains the values of the pre-defined annotation keys of the OCI image specification
+// (e.g. org.opencontainers.image.source) found in image labels.
+// Fields are empty or nil if the relevant label is missing, or, for parsed values, if the value is not valid.
+type ImageInspectKnownLabels struct {
+	Created       *time.Time    // org.opencontainers.image.created, an RFC 3339 timestamp
+	Authors       string        // org.opencontainers.image.authors
+	URL           *url.URL      // org.opencontainers.image.url, an absolute URL
+	Documentation *url.URL      // org.opencontainers.image.documentation, an absolute URL
+	Source        *url.URL      // org.opencontainers.image.source, an absolute URL
+	Version       string        // org.opencontainers.image.version
+	Revision      string        // org.opencontainers.image.revision
+	Vendor        string        // org.opencontainers.image.vendor
+	Licenses      string        // org.opencontainers.image.licenses, an SPDX license expression
+	RefName       string        // org.opencontainers.image.ref.name
+	Title         string        // org.opencontainers.image.title
+	Description   string        // org.opencontainers.image.description
+	BaseDigest    digest.Digest // org.opencontainers.image.base.digest
+	BaseName      string        // org.opencontainers.image.base.name
+}
+
+// ImageInspectUser describes the user an image runs as, as specified in the configuration's User field,
+// which can be one of "user", "uid", "user:group", "uid:gid", "user:gid" or "uid:group".
+type ImageInspectUser struct {
+	User           string // "" if not specified, i.e. the default (root) user
+	UserIsNumeric  bool   // True if User is a numeric UID, false if it is a user name
+	Group          string // "" if not specified
+	GroupIsNumeric bool   // True if Group is a numeric GID, false if it is a group name
+}
+
+// ImageInspectHealthcheck describes how to check that a container created from the image is healthy (the HEALTHCHECK instruction).
+// Zero values mean the value is inherited from the base image, or from the runtime defaults.
+type ImageInspectHealthcheck struct {
+	// Test is the test to perform: empty to inherit, {"NONE"} to disable the check,
+	// {"CMD", args...} to run the arguments directly, or {"CMD-SHELL", command} to run command with the system's default shell.
+	Test        []string
+	Interval    time.Duration // Time to wait between checks
+	Timeout     time.Duration // Time to wait before considering a check to have hung
+	StartPeriod time.Duration // Time to wait after starting the container before running the first check
+	Retries     int           // Number of consecutive failures needed to consider the container unhealthy
+}
+
+// IsRoot returns true if the user is the root user: either not specified, or UID 0, or the "root" user name
+// (which conventionally refers to UID 0, but that can't be verified without the image's /etc/passwd).
+func (u ImageInspectUser) IsRoot() bool {
+	return u.User == "" || u.User == "root" || (u.UserIsNumeric && u.User == "0")
+}
+
+// DockerCredentialProvider supplies registry credentials programmatically, e.g. from a secret manager,
+// instead of the auth files and credential helpers.
+type DockerCredentialProvider interface {
+	// GetCredentials returns the credentials to use for key, which is a repository (e.g. "registry.example.com/ns/repo"),
+	// a namespace within a registry, or a registry host name (possibly with a port), as in the auth files.
+	// It returns false if it has no credentials for key; the auth files and credential helpers are then used as usual.
+	// GetCredentials may be called concurrently, and multiple times for the same key.
+	GetCredentials(ctx context.Context, key string) (DockerAuthConfig, bool, error)
+}
+
 // DockerAuthConfig contains authorization information for connecting to a registry.
 // the value of Username and Password can be empty for accessing the registry anonymously
 type DockerAuthConfig struct {
@@ -491,6 +559,16 @@ type DockerAuthConfig struct {
 	IdentityToken string
 }
 
+// DockerClientCertificate specifies the client certificate used when connecting to a registry.
+// Either CertDir, or both CertFile and KeyFile, should be set.
+type DockerClientCertificate struct {
+	// A directory with the same structure as SystemContext.DockerCertPath, used instead of the per-host certificate directory.
+	CertDir string
+	// Paths to a PEM-encoded client certificate and its private key, used instead of any client certificate in the per-host certificate directory.
+	CertFile string
+	KeyFile  string
+}
+
 // OptionalBool is a boolean with an additional undefined value, which is meant
 // to be used in the context of user input to distinguish between a
 // user-specified value and a default value.
@@ -586,6 +664,10 @@ type SystemContext struct {
 	// this field is ignored if `AuthFilePath` is set (we favor the newer format);
 	// only reading of this data is supported;
 	LegacyFormatAuthFilePath string
+	// If true, credentials are only read from and written to the authentication files: credential helpers, whether configured
+	// in registries.conf or in the "credHelpers" section of an authentication file, are never executed. Registries with a
+	// "credHelpers" entry are treated as having no credentials, and attempts to store or remove their credentials fail.
+	DisableCredentialHelpers bool
 	// If not "", overrides the use of platform.GOARCH when choosing an image or verifying architecture match.
 	ArchitectureChoice string
 	// If not "", overrides the use of platform.GOOS when choosing an image or verifying OS match.
@@ -598,6 +680,13 @@ type SystemContext struct {
 	DockerArchiveAdditionalTags []reference.NamedTagged
 	// If not "", overrides the temporary directory to use for storing big files
 	BigFilesTemporaryDir string
+	// If not "", the digest algorithm (e.g. digest.SHA512) preferred for digests of blobs and manifests written by copy operations,
+	// if the destination supports it; digest.Canonical is used otherwise.
+	DigestAlgorithm digest.Algorithm
+	// If not nil, debug and informational messages about operations using this SystemContext are logged to Logger
+	// instead of the global logrus logger, e.g. to capture them using logrus hooks, or to add fields identifying the operation.
+	// Currently only the docker and openshift transports use it; other parts of the library always use the global logger.
+	Logger logrus.FieldLogger
 
 	// === OCI.Transport overrides ===
 	// If not "", a directory containing a CA certificate (ending with ".crt"),
@@ -610,6 +699,11 @@ type SystemContext struct {
 	OCISharedBlobDirPath string
 	// Allow UnCompress image layer for OCI image layer
 	OCIAcceptUncompressedLayers bool
+	// If not nil, the username and password used (with HTTP basic authentication) when reading OCI layouts using the oci-http: transport.
+	// Ignored if OCIHTTPBearerToken is not "".
+	OCIHTTPAuthConfig *DockerAuthConfig
+	// If not "", a bearer token used when reading OCI layouts using the oci-http: transport.
+	OCIHTTPBearerToken string
 
 	// === docker.Transport overrides ===
 	// If not "", a directory containing a CA certificate (ending with ".crt"),
@@ -621,9 +715,18 @@ type SystemContext struct {
 	DockerPerHostCertDirPath string
 	// Allow contacting container registries over HTTP, or HTTPS with failed TLS verification. Note that this does not affect other TLS connections.
 	DockerInsecureSkipTLSVerify OptionalBool
+	// If not 0, the minimum TLS version (e.g. tls.VersionTLS12) required when talking to a container registry.
+	DockerMinimumTLSVersion uint16
+	// If not 0, a request to a container registry fails if no data (response headers or body) is received for this long.
+	// Unlike a context deadline, this applies to each request separately, and restarts whenever data is received;
+	// requests without a body, and blob downloads (if the registry supports range requests), are retried after such a failure.
+	DockerRequestIdleTimeout time.Duration
 	// if nil, the library tries to parse ~/.docker/config.json to retrieve credentials
 	// Ignored if DockerBearerRegistryToken is non-empty.
 	DockerAuthConfig *DockerAuthConfig
+	// If not nil, consulted for the credentials of a repository before the auth files and credential helpers.
+	// Ignored if DockerAuthConfig is not nil or DockerBearerRegistryToken is non-empty.
+	DockerCredentialProvider DockerCredentialProvider
 	// if not "", the library uses this registry token to authenticate to the registry
 	DockerBearerRegistryToken string
 	// if not "", an User-Agent header is added to each request when contacting a registry.
@@ -634,14 +737,64 @@ type SystemContext struct {
 	DockerDisableV1Ping bool
 	// If true, dockerImageDestination.SupportedManifestMIMETypes will omit the Schema1 media types from the supported list
 	DockerDisableDestSchema1MIMETypes bool
+	// If true, Docker schema1 manifests are rejected: the docker transport refuses to read or write them, and copy.Image
+	// (when set in Options.DestinationCtx) never uses schema1 for the destination, converting to a newer format if possible.
+	DockerRejectSchema1Manifests bool
+	// If true, the docker transport destination sends an OPTIONS request for the manifest of the destination when it is opened,
+	// and if the registry lists the manifest MIME types it accepts in the Accept header of the response, only those types
+	// are reported by SupportedManifestMIMETypes, so that copy.Image chooses a suitable manifest format upfront instead
+	// of trying formats one by one. The result is cached per registry for the lifetime of the process.
+	DockerProbeManifestMIMETypes bool
 	// If true, the physical pull source of docker transport images logged as info level
 	DockerLogMirrorChoice bool
+	// If not "", the location (host[:port][/namespace]) of a pull-through cache used for reading images from any registry:
+	// the registry domain of the image reference is replaced by this location. The cache is tried first, before any
+	// pull-through caches and mirrors configured in registries.conf, and it is never used for writing images.
+	DockerPullThroughCache string
 	// Directory to use for OSTree temporary files
 	OSTreeTmpDirPath string
 	// If true, all blobs will have precomputed digests to ensure layers are not uploaded that already exist on the registry.
 	// Note that this requires writing blobs to temporary files, and takes more time than the default behavior,
 	// when the digest for a blob is unknown.
 	DockerRegistryPushPrecomputeDigests bool
+	// If true, a manifest fetched by tag must be accompanied by a Docker-Content-Digest header; otherwise, a missing header is accepted.
+	// A manifest which does not match the header, or an invalid header, is rejected in either case.
+	DockerStrictManifestDigestVerification bool
+	// If > 0, the maximum size in bytes of a manifest (or a list of referrers) read from a registry; reading a larger one is aborted
+	// and fails. The default is 4 MiB, the limit enforced by the reference registry implementation.
+	DockerMaxManifestSize int
+	// If > 0, the maximum size in bytes of a signature read from a lookaside server or a sigstore attachment payload read from a registry;
+	// reading a larger one is aborted and fails. The default is 4 MiB.
+	DockerMaxSignatureSize int
+	// If not nil, maps registry host names (optionally with a ":port" suffix, which takes precedence) to IP addresses
+	// to connect to instead of resolving the host names.  TLS certificates are still verified against the original host names.
+	// A value of the form "unix:///path/to/socket" connects to a Unix domain socket instead, e.g. for a local registry
+	// which is not exposed over TCP; the registry protocol, and TLS if the registry uses it, are used over the socket as usual.
+	DockerHostOverrides map[string]string
+	// If not nil, maps registry host names (with a ":port" suffix, if any) to client certificates used when connecting to them.
+	// For the matching hosts, this overrides DockerCertPath, DockerPerHostCertDirPath and the client certificates configured in registries.conf.
+	DockerClientCertificates map[string]DockerClientCertificate
+	// If not nil, maps registry host names (with a ":port" suffix, if any) to extra HTTP headers sent with every request to the
+	// matching registry (e.g. API keys or tenant IDs required by a gateway). Headers which the docker transport sets itself
+	// take precedence. The headers are not sent to other hosts, e.g. blob URLs outside of the registry or redirect targets.
+	DockerRegistryHeaders map[string]http.Header
+	// If true, DockerRegistryHeaders are also sent to the authentication server when obtaining a bearer token.
+	DockerRegistryHeadersForAuthServer bool
+	// If not nil, the forward proxy used when contacting container registries, instead of the one configured in the environment
+	// (HTTPS_PROXY, HTTP_PROXY and NO_PROXY). Credentials included in the URL are sent to the proxy using HTTP basic authentication.
+	DockerProxyURL *url.URL
+	// If not nil, the username and password sent to the proxy (DockerProxyURL, or the one configured in the environment) using
+	// HTTP basic authentication in the Proxy-Authorization header, overriding any credentials included in the proxy URL.
+	DockerProxyAuthConfig *DockerAuthConfig
+	// If > 0, HTTP connections to registries are kept alive for reuse (by default, a new connection is used for every request),
+	// keeping at most this number of idle connections per host. This applies separately to each opened image source or destination.
+	DockerMaxIdleConnsPerHost int
+	// If > 0, the maximum number of connections to a registry host, including connections in use and idle connections;
+	// further requests wait for a connection to become available. This applies separately to each opened image source or destination.
+	DockerMaxConnsPerHost int
+	// If not "", "tcp4" or "tcp6", to only connect to registries (and proxies) over IPv4 or IPv6, respectively,
+	// e.g. in dual-stack environments where one of the address families is not routed correctly.
+	DockerNetwork string
 
 	// === docker/daemon.Transport overrides ===
 	// A directory containing a CA certificate (ending with ".crt"),
@@ -658,6 +811,9 @@ type SystemContext struct {
 	DirForceCompress bool
 	// DirForceDecompress decompresses the image layers if set to true
 	DirForceDecompress bool
+	// DirExtractLayers, if set to true, also extracts each layer into a directory named after the layer digest, with a
+	// ".rootfs" suffix, e.g. for consumption by overlay-style tools; whiteouts are kept as ".wh." marker files.
+	DirExtractLayers bool
 
 	// CompressionFormat is the format to use for the compression of the blobs
 	CompressionFormat *compression.Algorithm